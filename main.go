@@ -2,25 +2,90 @@ package main
 
 import (
 	"flag"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 	"mykubelet/pkg/bootstrap"
+	"mykubelet/pkg/cm"
+	"mykubelet/pkg/cm/cpumanager"
+	"mykubelet/pkg/cm/memorymanager"
+	"mykubelet/pkg/cm/topologymanager"
 	"mykubelet/pkg/common"
+	"mykubelet/pkg/config"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/kubelet"
+	"mykubelet/pkg/metrics"
+	"mykubelet/pkg/network"
+	"mykubelet/pkg/network/cni"
 	"mykubelet/pkg/node"
+	"mykubelet/pkg/server"
 )
 
 func main() {
 	klog.InitFlags(nil)
+	podManifestPath := flag.String("pod-manifest-path", "", "Path to the directory or file containing static pod manifests")
+	manifestURL := flag.String("manifest-url", "", "URL for accessing additional static pod manifests")
+	cgroupDriver := flag.String("cgroup-driver", cm.CgroupDriverCgroupfs, "Driver that the kubelet uses to manipulate cgroups on the host. Possible values: 'cgroupfs', 'systemd'")
+	cgroupsPerQOS := flag.Bool("cgroups-per-qos", true, "Enable creation of QoS cgroup hierarchy, if true top level QoS and pod cgroups are created")
+	cpuManagerPolicy := flag.String("cpu-manager-policy", cpumanager.PolicyNone, "CPU Manager policy to use. Possible values: 'none', 'static'")
+	reservedCPUs := flag.String("reserved-cpus", "", "A comma-separated list of CPUs or CPU ranges that are reserved for system and kubernetes usage, e.g. 0-1,4")
+	topologyManagerPolicy := flag.String("topology-manager-policy", topologymanager.PolicyNone, "Topology Manager policy to use. Possible values: 'none', 'best-effort', 'restricted', 'single-numa-node'")
+	memoryManagerPolicy := flag.String("memory-manager-policy", memorymanager.PolicyNone, "Memory Manager policy to use. Possible values: 'None', 'Static'")
+	reservedMemory := flag.String("reserved-memory", "", "A list of memory reservations for NUMA nodes, e.g. 0:memory=1Gi,hugepages-2Mi=0;1:memory=512Mi")
+	networkPluginName := flag.String("network-plugin", network.NoopNetworkPluginName, "The name of the network plugin to be invoked for various events in kubelet/pod lifecycle. Possible values: '' (container runtime), 'cni'")
+	cniConfDir := flag.String("cni-conf-dir", cni.DefaultConfDir, "The full path of the directory in which to search for CNI config files")
+	cniBinDir := flag.String("cni-bin-dir", cni.DefaultBinDir, "A comma-separated list of full paths of directories in which to search for CNI plugin binaries")
+	cniCacheDir := flag.String("cni-cache-dir", cni.DefaultCacheDir, "The full path of the directory in which CNI should store cache files")
+	runtimeStreamingBaseURL := flag.String("runtime-streaming-base-url", "", "Base URL of the container runtime streaming server, used to resolve relative exec, attach and port-forward URLs returned by the runtime")
 	flag.Parse()
+	metrics.Register()
 
 	// bootstrap认证生成kubelet config
 	masterUrl := "https://110.41.142.160:6443"
 	nodeName := "mykubelet"
+	kubeletConfig := config.NewDefaultConfiguration(nodeName)
+	kubeletConfig.StaticPodPath = *podManifestPath
+	kubeletConfig.StaticPodURL = *manifestURL
+	kubeletConfig.CgroupDriver = *cgroupDriver
+	kubeletConfig.CgroupsPerQOS = *cgroupsPerQOS
+	kubeletConfig.CPUManagerPolicy = *cpuManagerPolicy
+	kubeletConfig.ReservedSystemCPUs = *reservedCPUs
+	kubeletConfig.TopologyManagerPolicy = *topologyManagerPolicy
+	kubeletConfig.MemoryManagerPolicy = *memoryManagerPolicy
+	kubeletConfig.ReservedMemory = *reservedMemory
+	kubeletConfig.NetworkPluginName = *networkPluginName
+	kubeletConfig.CNIConfDir = *cniConfDir
+	kubeletConfig.CNIBinDir = *cniBinDir
+	kubeletConfig.CNICacheDir = *cniCacheDir
+	kubeletConfig.RuntimeStreamingBaseURL = *runtimeStreamingBaseURL
 	bootstrap.BootStrap(nodeName, masterUrl)
 
-	// 注册节点
 	client := common.NewForKubeletConfig()
+
+	// 连接容器运行时
+	runtime, err := container.NewRemoteRuntime(kubeletConfig.ContainerRuntimeEndpoint)
+	if err != nil {
+		klog.Fatalln(err)
+	}
+
+	// 启动pod同步循环，静态pod不依赖apiServer，在节点注册之前启动，mirror pod在节点注册后创建
+	kl, err := kubelet.NewKubelet(client, kubeletConfig, runtime, clock.RealClock{})
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	go kl.Run(wait.NeverStop)
+
+	// 注册节点
 	node.RegisterNode(client, nodeName)
 
+	// 启动kubelet server
+	go func() {
+		auth := server.NewKubeletAuth(client, nodeName, kubeletConfig, clock.RealClock{})
+		if err := server.ListenAndServeKubeletServer(kl, auth, kubeletConfig); err != nil {
+			klog.Fatalln(err)
+		}
+	}()
+
 	// 启动租约控制器
 	node.StartLeaseController(client, nodeName)
 }
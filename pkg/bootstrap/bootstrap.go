@@ -4,6 +4,8 @@ import (
 	"k8s.io/klog/v2"
 	"mykubelet/pkg/bootstrap/lib"
 	"mykubelet/pkg/common"
+	"mykubelet/pkg/metrics"
+	"time"
 )
 
 // BootStrap 启动引导
@@ -12,9 +14,12 @@ import (
 // 2. 向kube-apiserver申请证书，然后kube-controller-manager给kubelet动态签署证书（包括手动批准CSR）
 // 3. 后续kubelet都将通过动态签署的证书与kube-apiserver通信
 func BootStrap(nodeName, masterUrl string) {
+	metrics.RegisterCertificateExpiry(metrics.CertificateTypeClient, lib.ClientCertificateNotAfter)
+
 	// 判断是否已经存在kubeconfig
 	if !lib.NeedRequestCsr() {
 		klog.Infoln("kubelet.config already exists. skip csr-boot")
+		metrics.BootstrapAttempts.WithLabelValues(metrics.BootstrapResultSkipped).Inc()
 		return
 	}
 
@@ -34,24 +39,34 @@ func BootStrap(nodeName, masterUrl string) {
 	// 授权kubelet创建csr（证书签名请求）
 	csrObj, err := lib.CreateCsr(bootClient, nodeName)
 	if err != nil {
-		klog.Fatalln(err)
+		bootstrapFailed(err)
 	}
 
 	// 等待批复
-	if err = lib.WaitForCsrApprove(bootClient, csrObj); err != nil {
-		klog.Fatalln(err)
+	waitStart := time.Now()
+	err = lib.WaitForCsrApprove(bootClient, csrObj)
+	metrics.CSRApprovalWaitDuration.Observe(metrics.SinceInSeconds(waitStart))
+	if err != nil {
+		bootstrapFailed(err)
 	}
 	klog.Infoln("kubelet pem-files have been saved in .kube")
 
 	// 获取证书生成kubeconfig
 	if err = lib.GenKubeconfig(masterUrl); err != nil {
-		klog.Fatalln(err)
+		bootstrapFailed(err)
 	}
 
 	client := common.NewForKubeletConfig()
 	info, err := client.ServerVersion()
 	if err != nil {
-		klog.Fatalln(err)
+		bootstrapFailed(err)
 	}
 	klog.Infoln(info.String())
+	metrics.BootstrapAttempts.WithLabelValues(metrics.BootstrapResultSuccess).Inc()
+}
+
+// 记录失败后退出
+func bootstrapFailed(err error) {
+	metrics.BootstrapAttempts.WithLabelValues(metrics.BootstrapResultFailure).Inc()
+	klog.Fatalln(err)
 }
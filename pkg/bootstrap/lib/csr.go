@@ -237,3 +237,12 @@ func NeedRequestCsr() bool {
 
 	return false
 }
+
+// ClientCertificateNotAfter 客户端证书的过期时间，证书不存在或无法解析时返回零值
+func ClientCertificateNotAfter() time.Time {
+	certs, err := cert.CertsFromFile(BootstrapPem)
+	if err != nil || len(certs) == 0 {
+		return time.Time{}
+	}
+	return certs[0].NotAfter
+}
@@ -0,0 +1,301 @@
+package cm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// CgroupDriverCgroupfs 直接按层级创建目录，如/kubepods/burstable/pod<uid>
+	CgroupDriverCgroupfs = "cgroupfs"
+	// CgroupDriverSystemd 按systemd的slice命名，如/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice
+	CgroupDriverSystemd = "systemd"
+
+	systemdSuffix = ".slice"
+)
+
+// pod和QoS的cgroup需要开启的控制器
+var requiredControllers = []string{"cpu", "memory", "pids"}
+
+// CgroupName cgroup名字的各级组成部分，和驱动无关，如[kubepods burstable pod<uid>]
+// pkg/kubelet/cm/cgroup_manager_linux.go
+type CgroupName []string
+
+// RootCgroupName 根cgroup
+var RootCgroupName = CgroupName([]string{})
+
+// NewCgroupName 在base下增加子cgroup，不修改base
+func NewCgroupName(base CgroupName, components ...string) CgroupName {
+	ret := make(CgroupName, 0, len(base)+len(components))
+	ret = append(ret, base...)
+	return append(ret, components...)
+}
+
+// ToCgroupfs cgroupfs驱动下的路径
+func (this CgroupName) ToCgroupfs() string {
+	return "/" + path.Join(this...)
+}
+
+// ToSystemd systemd驱动下的路径，每一级slice的名字带有所有上级的前缀，名字中的-替换为_
+func (this CgroupName) ToSystemd() string {
+	if len(this) == 0 {
+		return "/"
+	}
+	parts := make([]string, 0, len(this))
+	prefix := ""
+	for _, component := range this {
+		escaped := strings.ReplaceAll(component, "-", "_")
+		if prefix == "" {
+			prefix = escaped
+		} else {
+			prefix = prefix + "-" + escaped
+		}
+		parts = append(parts, prefix+systemdSuffix)
+	}
+	return "/" + path.Join(parts...)
+}
+
+// ParseCgroupfsToCgroupName cgroupfs驱动的路径转换为CgroupName
+func ParseCgroupfsToCgroupName(name string) CgroupName {
+	components := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if len(components) == 1 && components[0] == "" {
+		return RootCgroupName
+	}
+	return CgroupName(components)
+}
+
+// ParseSystemdToCgroupName systemd驱动的路径转换为CgroupName，最后一级slice的名字包含了完整的层级
+func ParseSystemdToCgroupName(name string) CgroupName {
+	driverName := strings.TrimSuffix(path.Base(name), systemdSuffix)
+	if driverName == "" || driverName == "/" || driverName == "-" {
+		return RootCgroupName
+	}
+	result := CgroupName{}
+	for _, part := range strings.Split(driverName, "-") {
+		result = append(result, strings.ReplaceAll(part, "_", "-"))
+	}
+	return result
+}
+
+// ResourceConfig cgroup的资源限制，为空的字段不修改
+// pkg/kubelet/cm/types.go
+type ResourceConfig struct {
+	// cgroup v1的cpu.shares，写入时转换为cpu.weight
+	CPUShares *uint64
+	// 每个周期可以使用的cpu时间，单位微秒，-1表示不限制
+	CPUQuota *int64
+	// cpu周期，单位微秒
+	CPUPeriod *uint64
+	// 内存限制，单位字节，小于等于0表示不限制
+	Memory *int64
+	// 最大进程数，小于等于0表示不限制
+	PidsLimit *int64
+}
+
+// CgroupConfig 需要创建或更新的cgroup
+type CgroupConfig struct {
+	Name               CgroupName
+	ResourceParameters *ResourceConfig
+}
+
+// CgroupManager 在cgroup v2统一层级中创建、更新和删除cgroup
+// root为cgroup文件系统的挂载点，也可以是普通目录，便于在没有权限的环境中测试
+// systemd驱动下同样直接操作cgroup文件系统，只使用slice的命名规则
+type CgroupManager struct {
+	root   string
+	driver string
+}
+
+// NewCgroupManager driver为cgroupfs或systemd
+func NewCgroupManager(root, driver string) (*CgroupManager, error) {
+	if driver != CgroupDriverCgroupfs && driver != CgroupDriverSystemd {
+		return nil, fmt.Errorf("invalid cgroup driver %q, must be %q or %q", driver, CgroupDriverCgroupfs, CgroupDriverSystemd)
+	}
+	return &CgroupManager{root: root, driver: driver}, nil
+}
+
+// Name 驱动格式的cgroup名字，作为CRI的cgroupParent
+func (this *CgroupManager) Name(name CgroupName) string {
+	if this.driver == CgroupDriverSystemd {
+		return name.ToSystemd()
+	}
+	return name.ToCgroupfs()
+}
+
+// CgroupName 驱动格式的名字转换为CgroupName
+func (this *CgroupManager) CgroupName(name string) CgroupName {
+	if this.driver == CgroupDriverSystemd {
+		return ParseSystemdToCgroupName(name)
+	}
+	return ParseCgroupfsToCgroupName(name)
+}
+
+// 在cgroup文件系统中的目录
+func (this *CgroupManager) path(name CgroupName) string {
+	return filepath.Join(this.root, this.Name(name))
+}
+
+// Exists cgroup目录是否存在
+func (this *CgroupManager) Exists(name CgroupName) bool {
+	info, err := os.Stat(this.path(name))
+	return err == nil && info.IsDir()
+}
+
+// Create 逐级创建cgroup，在每一级的父cgroup中开启需要的控制器，然后设置资源限制
+func (this *CgroupManager) Create(config *CgroupConfig) error {
+	for i := 1; i <= len(config.Name); i++ {
+		parent := this.path(config.Name[:i-1])
+		if err := enableControllers(parent); err != nil {
+			return fmt.Errorf("failed to enable controllers in cgroup %q: %v", parent, err)
+		}
+		if err := os.Mkdir(this.path(config.Name[:i]), 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create cgroup %q: %v", this.Name(config.Name[:i]), err)
+		}
+	}
+	return this.Update(config)
+}
+
+// Update 按ResourceConfig写入cpu.weight、cpu.max、memory.max和pids.max
+func (this *CgroupManager) Update(config *CgroupConfig) error {
+	rc := config.ResourceParameters
+	if rc == nil {
+		return nil
+	}
+	dir := this.path(config.Name)
+	if rc.CPUShares != nil {
+		if err := writeCgroupFile(dir, "cpu.weight", strconv.FormatUint(CPUSharesToCPUWeight(*rc.CPUShares), 10)); err != nil {
+			return err
+		}
+	}
+	if rc.CPUQuota != nil || rc.CPUPeriod != nil {
+		quota := "max"
+		if rc.CPUQuota != nil && *rc.CPUQuota > 0 {
+			quota = strconv.FormatInt(*rc.CPUQuota, 10)
+		}
+		period := uint64(QuotaPeriod)
+		if rc.CPUPeriod != nil && *rc.CPUPeriod > 0 {
+			period = *rc.CPUPeriod
+		}
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	if rc.Memory != nil {
+		if err := writeCgroupFile(dir, "memory.max", formatLimit(*rc.Memory)); err != nil {
+			return err
+		}
+	}
+	if rc.PidsLimit != nil {
+		if err := writeCgroupFile(dir, "pids.max", formatLimit(*rc.PidsLimit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Destroy 从最深的子cgroup开始逐个删除，cgroup中还有进程时失败
+func (this *CgroupManager) Destroy(name CgroupName) error {
+	if err := removeCgroup(this.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to destroy cgroup %q: %v", this.Name(name), err)
+	}
+	return nil
+}
+
+// Children 直接子cgroup的名字
+func (this *CgroupManager) Children(name CgroupName) ([]CgroupName, error) {
+	entries, err := os.ReadDir(this.path(name))
+	if err != nil {
+		return nil, err
+	}
+	children := []CgroupName{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			children = append(children, this.CgroupName(path.Join(this.Name(name), entry.Name())))
+		}
+	}
+	return children, nil
+}
+
+// enableControllers 在cgroup.subtree_control中开启子cgroup需要的控制器，只开启cgroup.controllers中可用的
+// 已经开启的控制器重复写入没有影响，有缺少的控制器时写入全部，普通目录中的文件也能反映开启的控制器
+func enableControllers(dir string) error {
+	enabled := readControllers(filepath.Join(dir, "cgroup.subtree_control"))
+	available := readControllers(filepath.Join(dir, "cgroup.controllers"))
+	toEnable := []string{}
+	missing := false
+	for _, controller := range requiredControllers {
+		// 没有cgroup.controllers时不是真实的cgroup文件系统，全部开启
+		if available != nil && !available[controller] {
+			continue
+		}
+		toEnable = append(toEnable, "+"+controller)
+		if !enabled[controller] {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(toEnable, " "))
+}
+
+// readControllers 读取以空格分隔的控制器列表，文件不存在时返回nil
+func readControllers(file string) map[string]bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	controllers := map[string]bool{}
+	for _, field := range strings.Fields(string(data)) {
+		controllers[strings.TrimPrefix(field, "+")] = true
+	}
+	return controllers
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %q to %q: %v", value, filepath.Join(dir, file), err)
+	}
+	return nil
+}
+
+func formatLimit(limit int64) string {
+	if limit <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+// removeCgroup 真实的cgroup文件系统中可以直接删除只包含控制文件的目录
+// 普通目录中的控制文件需要先删除
+func removeCgroup(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err = removeCgroup(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	err = os.Remove(dir)
+	if err == nil || !(errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Remove(dir)
+}
@@ -0,0 +1,206 @@
+package cm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readCgroupFile 读取temp root中cgroup的控制文件，不存在时返回空字符串
+func readCgroupFile(t *testing.T, root, dir, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, dir, file))
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func newTestCgroupManager(t *testing.T, driver string) (*CgroupManager, string) {
+	root := t.TempDir()
+	manager, err := NewCgroupManager(root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, root
+}
+
+func TestNewCgroupManagerInvalidDriver(t *testing.T) {
+	if _, err := NewCgroupManager(t.TempDir(), "docker"); err == nil {
+		t.Errorf("expected error for an unknown cgroup driver")
+	}
+}
+
+func TestCgroupNameConversion(t *testing.T) {
+	testCases := []struct {
+		name     CgroupName
+		cgroupfs string
+		systemd  string
+	}{
+		{name: RootCgroupName, cgroupfs: "/", systemd: "/"},
+		{name: CgroupName{"kubepods"}, cgroupfs: "/kubepods", systemd: "/kubepods.slice"},
+		{
+			name:     CgroupName{"kubepods", "burstable"},
+			cgroupfs: "/kubepods/burstable",
+			systemd:  "/kubepods.slice/kubepods-burstable.slice",
+		},
+		{
+			name:     CgroupName{"kubepods", "besteffort", "pod1234-abcd"},
+			cgroupfs: "/kubepods/besteffort/pod1234-abcd",
+			systemd:  "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1234_abcd.slice",
+		},
+	}
+	for _, tc := range testCases {
+		if got := tc.name.ToCgroupfs(); got != tc.cgroupfs {
+			t.Errorf("%v: expected cgroupfs name %q, got %q", tc.name, tc.cgroupfs, got)
+		}
+		if got := tc.name.ToSystemd(); got != tc.systemd {
+			t.Errorf("%v: expected systemd name %q, got %q", tc.name, tc.systemd, got)
+		}
+		if got := ParseCgroupfsToCgroupName(tc.cgroupfs); !reflect.DeepEqual(got, tc.name) {
+			t.Errorf("%q: expected %v, got %v", tc.cgroupfs, tc.name, got)
+		}
+		if got := ParseSystemdToCgroupName(tc.systemd); !reflect.DeepEqual(got, tc.name) {
+			t.Errorf("%q: expected %v, got %v", tc.systemd, tc.name, got)
+		}
+	}
+
+	base := CgroupName{"kubepods"}
+	child := NewCgroupName(base, "burstable")
+	NewCgroupName(base, "besteffort")
+	if !reflect.DeepEqual(child, CgroupName{"kubepods", "burstable"}) || len(base) != 1 {
+		t.Errorf("NewCgroupName must not modify its base, got %v and %v", base, child)
+	}
+}
+
+func TestCgroupManagerCreateUpdate(t *testing.T) {
+	shares := uint64(1024)
+	quota := int64(50000)
+	period := uint64(100000)
+	memory := int64(256 * 1024 * 1024)
+	pids := int64(100)
+	unlimited := int64(-1)
+	testCases := []struct {
+		driver string
+		dir    string
+	}{
+		{driver: CgroupDriverCgroupfs, dir: "kubepods/burstable"},
+		{driver: CgroupDriverSystemd, dir: "kubepods.slice/kubepods-burstable.slice"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.driver, func(t *testing.T) {
+			manager, root := newTestCgroupManager(t, tc.driver)
+			// 根cgroup中没有pids控制器
+			if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			name := CgroupName{"kubepods", "burstable"}
+			config := &CgroupConfig{Name: name, ResourceParameters: &ResourceConfig{
+				CPUShares: &shares,
+				CPUQuota:  &quota,
+				CPUPeriod: &period,
+				Memory:    &memory,
+				PidsLimit: &pids,
+			}}
+			if err := manager.Create(config); err != nil {
+				t.Fatal(err)
+			}
+			if !manager.Exists(name) {
+				t.Fatalf("expected cgroup %s to exist", manager.Name(name))
+			}
+			if info, err := os.Stat(filepath.Join(root, tc.dir)); err != nil || !info.IsDir() {
+				t.Fatalf("expected directory %s, got %v", tc.dir, err)
+			}
+			expected := map[string]string{
+				"cpu.weight": "39",
+				"cpu.max":    "50000 100000",
+				"memory.max": "268435456",
+				"pids.max":   "100",
+			}
+			for file, value := range expected {
+				if got := readCgroupFile(t, root, tc.dir, file); got != value {
+					t.Errorf("expected %s to be %q, got %q", file, value, got)
+				}
+			}
+			if got := readCgroupFile(t, root, "", "cgroup.subtree_control"); got != "+cpu +memory" {
+				t.Errorf("expected only available controllers enabled in the root, got %q", got)
+			}
+			if got := readCgroupFile(t, root, filepath.Dir(tc.dir), "cgroup.subtree_control"); got != "+cpu +memory +pids" {
+				t.Errorf("expected required controllers enabled in the parent, got %q", got)
+			}
+
+			// 没有设置的字段不修改，小于等于0表示不限制
+			if err := manager.Update(&CgroupConfig{Name: name, ResourceParameters: &ResourceConfig{
+				CPUQuota: &unlimited,
+				Memory:   &unlimited,
+			}}); err != nil {
+				t.Fatal(err)
+			}
+			expected["cpu.max"] = "max 100000"
+			expected["memory.max"] = "max"
+			for file, value := range expected {
+				if got := readCgroupFile(t, root, tc.dir, file); got != value {
+					t.Errorf("after update expected %s to be %q, got %q", file, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCgroupManagerChildrenAndDestroy(t *testing.T) {
+	for _, driver := range []string{CgroupDriverCgroupfs, CgroupDriverSystemd} {
+		t.Run(driver, func(t *testing.T) {
+			manager, root := newTestCgroupManager(t, driver)
+			burstable := CgroupName{"kubepods", "burstable"}
+			pods := []CgroupName{
+				NewCgroupName(burstable, "poda-1"),
+				NewCgroupName(burstable, "podb-2"),
+			}
+			for _, name := range pods {
+				if err := manager.Create(&CgroupConfig{Name: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 容器运行时在pod cgroup下创建的容器cgroup
+			containerDir := filepath.Join(root, manager.Name(pods[0]), "cri-containerd-abc.scope")
+			if err := os.Mkdir(containerDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(containerDir, "cgroup.procs"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			children, err := manager.Children(burstable)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, child := range children {
+				names = append(names, strings.Join(child, "/"))
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, []string{"kubepods/burstable/poda-1", "kubepods/burstable/podb-2"}) {
+				t.Errorf("unexpected children %v", names)
+			}
+
+			if err = manager.Destroy(pods[0]); err != nil {
+				t.Fatal(err)
+			}
+			if manager.Exists(pods[0]) || !manager.Exists(pods[1]) {
+				t.Errorf("expected only %v to be destroyed", pods[0])
+			}
+			if err = manager.Destroy(pods[0]); err != nil {
+				t.Errorf("destroying a missing cgroup should succeed, got %v", err)
+			}
+			if _, err = manager.Children(CgroupName{"missing"}); err == nil {
+				t.Errorf("expected error listing children of a missing cgroup")
+			}
+		})
+	}
+}
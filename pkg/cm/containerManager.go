@@ -0,0 +1,229 @@
+package cm
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm/cpumanager"
+	"mykubelet/pkg/cm/devicemanager"
+	"mykubelet/pkg/cm/memorymanager"
+	"mykubelet/pkg/cm/topologymanager"
+	"mykubelet/pkg/lifecycle"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// 所有pod的cgroup的根
+	defaultNodeAllocatableCgroupName = "kubepods"
+	// kubelet根目录下设备插件socket所在的目录
+	devicePluginsDirName = "device-plugins"
+)
+
+// ActivePodsFunc 节点上运行中的pod
+type ActivePodsFunc func() []*v1.Pod
+
+// NodeConfig 容器管理器的配置
+type NodeConfig struct {
+	// cgroup文件系统的挂载点
+	CgroupMountPath string
+	// cgroupfs或systemd，需要和容器运行时使用的驱动一致
+	CgroupDriver string
+	// 为true时创建kubepods、QoS和pod的cgroup，只支持cgroup v2
+	CgroupsPerQOS bool
+	// 节点的可分配资源，作为kubepods的限制
+	NodeAllocatable v1.ResourceList
+	// 是否为设置了cpu limits的容器设置cfs配额
+	EnforceCPULimits bool
+	// cfs周期
+	CPUCFSQuotaPeriod time.Duration
+	// 每个pod的最大进程数，小于等于0表示不限制
+	PodPidsLimit int64
+
+	// kubelet的根目录，保存cpu管理器的检查点，设备插件的socket在其下的device-plugins目录
+	RootDirectory string
+	// none或static
+	CPUManagerPolicy string
+	// 同步运行中容器cpuset的间隔
+	CPUManagerReconcilePeriod time.Duration
+	// 留给系统进程的cpu，static策略下不分配给容器
+	ReservedSystemCPUs cpuset.CPUSet
+	// none、best-effort、restricted或single-numa-node
+	TopologyManagerPolicy string
+	// None或Static
+	MemoryManagerPolicy string
+	// 每个NUMA节点上留给系统的内存和大页，Static策略下不分配给容器
+	ReservedMemory memorymanager.NUMANodeResources
+}
+
+// ContainerManager 管理节点上的cgroup层级：kubepods下按QoS等级划分，每个pod有自己的cgroup
+// pkg/kubelet/cm/container_manager_linux.go
+type ContainerManager struct {
+	config        NodeConfig
+	cgroupManager *CgroupManager
+	// kubepods
+	cgroupRoot          CgroupName
+	qosContainerManager *qosContainerManager
+	// 准入时按NUMA节点对齐cpu和内存
+	topologyManager *topologymanager.Manager
+	// Guaranteed pod中的容器独占cpu
+	cpuManager *cpumanager.Manager
+	// Guaranteed pod中的容器在NUMA节点上预留内存
+	memoryManager *memorymanager.Manager
+	// 设备插件提供的扩展资源
+	deviceManager *devicemanager.Manager
+	activePods    ActivePodsFunc
+}
+
+// NewContainerManager 开启cgroupsPerQOS时要求挂载点是cgroup v2的统一层级
+func NewContainerManager(config NodeConfig, activePods ActivePodsFunc) (*ContainerManager, error) {
+	cgroupManager, err := NewCgroupManager(config.CgroupMountPath, config.CgroupDriver)
+	if err != nil {
+		return nil, err
+	}
+	if config.CgroupsPerQOS {
+		if _, err = os.Stat(filepath.Join(config.CgroupMountPath, "cgroup.controllers")); err != nil {
+			return nil, fmt.Errorf("cgroupsPerQOS requires the cgroup v2 unified hierarchy mounted at %q: %v", config.CgroupMountPath, err)
+		}
+	}
+	numaNodes, err := topologymanager.DiscoverNUMANodes(topologymanager.DefaultSysNodePath)
+	if err != nil {
+		return nil, err
+	}
+	topologyManager, err := topologymanager.NewManager(numaNodes, config.TopologyManagerPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize topology manager: %v", err)
+	}
+	cpuManager, err := cpumanager.NewManager(config.CPUManagerPolicy, config.CPUManagerReconcilePeriod,
+		cpumanager.DefaultSysCPUPath, config.ReservedSystemCPUs, topologyManager, config.RootDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cpu manager: %v", err)
+	}
+	topologyManager.AddHintProvider(cpuManager)
+	memoryManager, err := memorymanager.NewManager(config.MemoryManagerPolicy, topologymanager.DefaultSysNodePath, numaNodes,
+		config.ReservedMemory, topologyManager, config.RootDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize memory manager: %v", err)
+	}
+	topologyManager.AddHintProvider(memoryManager)
+	deviceManager, err := devicemanager.NewManager(filepath.Join(config.RootDirectory, devicePluginsDirName), numaNodes, topologyManager)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize device manager: %v", err)
+	}
+	topologyManager.AddHintProvider(deviceManager)
+	cgroupRoot := NewCgroupName(RootCgroupName, defaultNodeAllocatableCgroupName)
+	return &ContainerManager{
+		config:              config,
+		cgroupManager:       cgroupManager,
+		cgroupRoot:          cgroupRoot,
+		qosContainerManager: newQOSContainerManager(cgroupManager, cgroupRoot, activePods),
+		topologyManager:     topologyManager,
+		cpuManager:          cpuManager,
+		memoryManager:       memoryManager,
+		deviceManager:       deviceManager,
+		activePods:          activePods,
+	}, nil
+}
+
+// Start 启动cpu、内存和设备管理器，创建kubepods并按可分配资源设置限制，然后创建QoS的cgroup
+func (this *ContainerManager) Start(runtimeService cpumanager.RuntimeService, stopCh <-chan struct{}) error {
+	if err := this.cpuManager.Start(cpumanager.ActivePodsFunc(this.activePods), runtimeService, stopCh); err != nil {
+		return err
+	}
+	if err := this.memoryManager.Start(memorymanager.ActivePodsFunc(this.activePods)); err != nil {
+		return err
+	}
+	if err := this.deviceManager.Start(devicemanager.ActivePodsFunc(this.activePods)); err != nil {
+		return err
+	}
+	if !this.config.CgroupsPerQOS {
+		return nil
+	}
+	if err := this.createNodeAllocatableCgroups(); err != nil {
+		return err
+	}
+	if err := this.qosContainerManager.Start(stopCh); err != nil {
+		return fmt.Errorf("failed to initialize top level QOS containers: %v", err)
+	}
+	klog.InfoS("Started container manager", "cgroupRoot", this.cgroupManager.Name(this.cgroupRoot),
+		"cgroupDriver", this.config.CgroupDriver)
+	return nil
+}
+
+// createNodeAllocatableCgroups kubepods的cpu权重和内存上限对应节点的可分配资源
+// pkg/kubelet/cm/node_container_manager_linux.go
+func (this *ContainerManager) createNodeAllocatableCgroups() error {
+	rc := &ResourceConfig{}
+	if cpu, ok := this.config.NodeAllocatable[v1.ResourceCPU]; ok {
+		shares := MilliCPUToShares(cpu.MilliValue())
+		rc.CPUShares = &shares
+	}
+	if memory, ok := this.config.NodeAllocatable[v1.ResourceMemory]; ok {
+		limit := memory.Value()
+		rc.Memory = &limit
+	}
+	config := &CgroupConfig{Name: this.cgroupRoot, ResourceParameters: rc}
+	if err := this.cgroupManager.Create(config); err != nil {
+		return fmt.Errorf("failed to create %q cgroup: %v", this.cgroupManager.Name(this.cgroupRoot), err)
+	}
+	return nil
+}
+
+// NewPodContainerManager 没有开启cgroupsPerQOS时返回不做任何操作的实现
+func (this *ContainerManager) NewPodContainerManager() PodContainerManager {
+	if !this.config.CgroupsPerQOS {
+		return &podContainerManagerNoop{}
+	}
+	return &podContainerManagerImpl{
+		qosContainersInfo: this.qosContainerManager.GetQOSContainersInfo(),
+		cgroupManager:     this.cgroupManager,
+		podPidsLimit:      this.config.PodPidsLimit,
+		enforceCPULimits:  this.config.EnforceCPULimits,
+		cpuCFSQuotaPeriod: uint64(this.config.CPUCFSQuotaPeriod / time.Microsecond),
+	}
+}
+
+// UpdateQOSCgroups 按运行中的pod更新QoS cgroup的cpu权重
+func (this *ContainerManager) UpdateQOSCgroups() error {
+	if !this.config.CgroupsPerQOS {
+		return nil
+	}
+	return this.qosContainerManager.UpdateCgroups()
+}
+
+// GetPodCgroupParent pod的cgroup，作为sandbox和容器的cgroupParent
+func (this *ContainerManager) GetPodCgroupParent(pod *v1.Pod) string {
+	_, cgroupParent := this.NewPodContainerManager().GetPodContainerName(pod)
+	return cgroupParent
+}
+
+// GenerateLinuxContainerResources 容器的cpu和内存限制，以及cpu和内存管理器分配的cpuset
+func (this *ContainerManager) GenerateLinuxContainerResources(pod *v1.Pod, container *v1.Container) *runtimeapi.LinuxContainerResources {
+	resources := GenerateLinuxContainerResources(container, this.config.EnforceCPULimits,
+		uint64(this.config.CPUCFSQuotaPeriod/time.Microsecond))
+	if cpus := this.cpuManager.GetCPUSet(pod.UID, container.Name); !cpus.IsEmpty() {
+		resources.CpusetCpus = cpus.String()
+	}
+	if mems := this.memoryManager.GetMemoryNUMANodes(pod.UID, container.Name); !mems.IsEmpty() {
+		resources.CpusetMems = mems.String()
+	}
+	return resources
+}
+
+// GetDeviceRunContainerOptions 设备插件要求注入容器的环境变量、挂载、设备和注解，容器没有分配设备时返回nil
+func (this *ContainerManager) GetDeviceRunContainerOptions(pod *v1.Pod, container *v1.Container) *devicemanager.DeviceRunContainerOptions {
+	return this.deviceManager.GetDeviceRunContainerOptions(pod, container)
+}
+
+// GetDevicePluginResourceCapacity 设备插件提供的扩展资源的容量和可分配数量，以及需要从节点上删除的资源
+func (this *ContainerManager) GetDevicePluginResourceCapacity() (v1.ResourceList, v1.ResourceList, []string) {
+	return this.deviceManager.GetCapacity()
+}
+
+// GetAllocateResourcesPodAdmitHandler 准入时由拓扑管理器合并cpu、内存和设备的NUMA hint并分配，无法满足策略时拒绝pod
+func (this *ContainerManager) GetAllocateResourcesPodAdmitHandler() lifecycle.PodAdmitHandler {
+	return this.topologyManager
+}
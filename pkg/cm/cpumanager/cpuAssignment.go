@@ -0,0 +1,152 @@
+package cpumanager
+
+import (
+	"fmt"
+	"k8s.io/utils/cpuset"
+	"sort"
+)
+
+// cpuAccumulator 按拓扑从可用cpu中逐步选取，直到满足需要的数量
+// pkg/kubelet/cm/cpumanager/cpu_assignment.go
+type cpuAccumulator struct {
+	topo *CPUTopology
+	// 还没有被选取的可用cpu
+	details       CPUDetails
+	numCPUsNeeded int
+	result        cpuset.CPUSet
+}
+
+func newCPUAccumulator(topo *CPUTopology, availableCPUs cpuset.CPUSet, numCPUs int) *cpuAccumulator {
+	return &cpuAccumulator{
+		topo:          topo,
+		details:       topo.CPUDetails.KeepOnly(availableCPUs),
+		numCPUsNeeded: numCPUs,
+		result:        cpuset.New(),
+	}
+}
+
+func (this *cpuAccumulator) take(cpus cpuset.CPUSet) {
+	this.result = this.result.Union(cpus)
+	this.details = this.details.KeepOnly(this.details.CPUs().Difference(this.result))
+	this.numCPUsNeeded -= cpus.Size()
+}
+
+// socket上的cpu全部可用
+func (this *cpuAccumulator) isSocketFree(socketID int) bool {
+	return this.details.CPUsInSockets(socketID).Size() == this.topo.CPUsPerSocket()
+}
+
+// 物理核上的超线程全部可用
+func (this *cpuAccumulator) isCoreFree(coreID int) bool {
+	return this.details.CPUsInCores(coreID).Size() == this.topo.CPUsPerCore()
+}
+
+// freeSockets 完全空闲的socket，按编号排序
+func (this *cpuAccumulator) freeSockets() []int {
+	free := []int{}
+	for _, socket := range this.details.Sockets().List() {
+		if this.isSocketFree(socket) {
+			free = append(free, socket)
+		}
+	}
+	return free
+}
+
+// freeCores 完全空闲的物理核，优先选择可用cpu少的socket，尽量先用满一个socket
+func (this *cpuAccumulator) freeCores() []int {
+	free := []int{}
+	for _, core := range this.details.Cores().List() {
+		if this.isCoreFree(core) {
+			free = append(free, core)
+		}
+	}
+	socketOf := func(core int) int {
+		return this.topo.CPUDetails[core].SocketID
+	}
+	sort.SliceStable(free, func(i, j int) bool {
+		si := this.details.CPUsInSockets(socketOf(free[i])).Size()
+		sj := this.details.CPUsInSockets(socketOf(free[j])).Size()
+		if si != sj {
+			return si < sj
+		}
+		return free[i] < free[j]
+	})
+	return free
+}
+
+// freeCPUs 所有可用cpu，优先选择可用cpu少的socket和物理核，先填满已经部分使用的核
+func (this *cpuAccumulator) freeCPUs() []int {
+	cpus := this.details.CPUs().List()
+	sort.SliceStable(cpus, func(i, j int) bool {
+		ii, ij := this.details[cpus[i]], this.details[cpus[j]]
+		si, sj := this.details.CPUsInSockets(ii.SocketID).Size(), this.details.CPUsInSockets(ij.SocketID).Size()
+		if si != sj {
+			return si < sj
+		}
+		ci, cj := this.details.CPUsInCores(ii.CoreID).Size(), this.details.CPUsInCores(ij.CoreID).Size()
+		if ci != cj {
+			return ci < cj
+		}
+		return cpus[i] < cpus[j]
+	})
+	return cpus
+}
+
+func (this *cpuAccumulator) needs(n int) bool {
+	return this.numCPUsNeeded >= n
+}
+
+func (this *cpuAccumulator) isSatisfied() bool {
+	return this.numCPUsNeeded < 1
+}
+
+func (this *cpuAccumulator) isFailed() bool {
+	return this.numCPUsNeeded > this.details.CPUs().Size()
+}
+
+// takeByTopology 从可用cpu中选取numCPUs个，依次尝试整个socket、整个物理核、单个超线程，减少跨socket和核的共享
+func takeByTopology(topo *CPUTopology, availableCPUs cpuset.CPUSet, numCPUs int) (cpuset.CPUSet, error) {
+	acc := newCPUAccumulator(topo, availableCPUs, numCPUs)
+	if acc.isSatisfied() {
+		return acc.result, nil
+	}
+	if acc.isFailed() {
+		return cpuset.New(), fmt.Errorf("not enough cpus available to satisfy request: requested=%d, available=%d",
+			numCPUs, availableCPUs.Size())
+	}
+
+	// 1. 需要的cpu不少于一个socket时，先选取完全空闲的socket
+	if acc.needs(topo.CPUsPerSocket()) {
+		for _, socket := range acc.freeSockets() {
+			if !acc.needs(topo.CPUsPerSocket()) {
+				break
+			}
+			acc.take(acc.details.CPUsInSockets(socket))
+			if acc.isSatisfied() {
+				return acc.result, nil
+			}
+		}
+	}
+
+	// 2. 需要的cpu不少于一个物理核时，选取完全空闲的物理核
+	if acc.needs(topo.CPUsPerCore()) {
+		for _, core := range acc.freeCores() {
+			if !acc.needs(topo.CPUsPerCore()) {
+				break
+			}
+			acc.take(acc.details.CPUsInCores(core))
+			if acc.isSatisfied() {
+				return acc.result, nil
+			}
+		}
+	}
+
+	// 3. 剩余的按单个超线程选取
+	for _, cpu := range acc.freeCPUs() {
+		acc.take(cpuset.New(cpu))
+		if acc.isSatisfied() {
+			return acc.result, nil
+		}
+	}
+	return cpuset.New(), fmt.Errorf("failed to allocate cpus")
+}
@@ -0,0 +1,133 @@
+package cpumanager
+
+import (
+	"k8s.io/utils/cpuset"
+	"testing"
+)
+
+func TestTakeByTopology(t *testing.T) {
+	testCases := []struct {
+		name      string
+		topology  []fixtureCPU
+		available cpuset.CPUSet
+		numCPUs   int
+		expected  cpuset.CPUSet
+		wantErr   bool
+	}{
+		{
+			name:      "take nothing",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   0,
+			expected:  cpuset.New(),
+		},
+		{
+			name:      "whole socket",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   4,
+			expected:  cpuset.New(0, 1, 4, 5),
+		},
+		{
+			name:      "whole socket that is still free",
+			topology:  dualSocketHT(),
+			available: cpuset.New(1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   4,
+			expected:  cpuset.New(2, 3, 6, 7),
+		},
+		{
+			name:      "socket and a core",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   6,
+			expected:  cpuset.New(0, 1, 2, 4, 5, 6),
+		},
+		{
+			name:      "whole core",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   2,
+			expected:  cpuset.New(0, 4),
+		},
+		{
+			name:      "whole core on the socket with fewer free cpus",
+			topology:  dualSocketHT(),
+			available: cpuset.New(1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   2,
+			expected:  cpuset.New(1, 5),
+		},
+		{
+			name:      "core and a thread on the same socket",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   3,
+			expected:  cpuset.New(0, 1, 4),
+		},
+		{
+			name:      "single thread",
+			topology:  dualSocketHT(),
+			available: cpuset.New(0, 1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   1,
+			expected:  cpuset.New(0),
+		},
+		{
+			name:      "single thread fills a partially used core",
+			topology:  dualSocketHT(),
+			available: cpuset.New(1, 2, 3, 4, 5, 6, 7),
+			numCPUs:   1,
+			expected:  cpuset.New(4),
+		},
+		{
+			name:      "threads from the socket with fewer free cpus first",
+			topology:  dualSocketHT(),
+			available: cpuset.New(4, 5, 6),
+			numCPUs:   2,
+			expected:  cpuset.New(4, 6),
+		},
+		{
+			name:      "not enough cpus",
+			topology:  dualSocketHT(),
+			available: cpuset.New(1, 2, 3),
+			numCPUs:   4,
+			wantErr:   true,
+		},
+		{
+			name:      "no SMT whole socket",
+			topology:  singleSocketNoSMT(),
+			available: cpuset.New(0, 1, 2, 3),
+			numCPUs:   4,
+			expected:  cpuset.New(0, 1, 2, 3),
+		},
+		{
+			name:      "no SMT single cores",
+			topology:  singleSocketNoSMT(),
+			available: cpuset.New(1, 2, 3),
+			numCPUs:   2,
+			expected:  cpuset.New(1, 2),
+		},
+		{
+			name:      "no SMT not enough cpus",
+			topology:  singleSocketNoSMT(),
+			available: cpuset.New(1, 2, 3),
+			numCPUs:   4,
+			wantErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := takeByTopology(discover(t, tc.topology), tc.available, tc.numCPUs)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %s", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !result.Equals(tc.expected) {
+				t.Errorf("expected %s, got %s", tc.expected, result)
+			}
+		})
+	}
+}
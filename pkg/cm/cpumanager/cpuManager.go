@@ -0,0 +1,205 @@
+package cpumanager
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm/topologymanager"
+	"mykubelet/pkg/container"
+	"sync"
+	"time"
+)
+
+// cpu管理器检查点文件的名字，在kubelet根目录下
+const cpuManagerStateFileName = "cpu_manager_state"
+
+// ActivePodsFunc 节点上运行中的pod
+type ActivePodsFunc func() []*v1.Pod
+
+// RuntimeService 调整运行中容器cpuset需要的运行时接口
+type RuntimeService interface {
+	GetPods(ctx context.Context) ([]*container.Pod, error)
+	UpdateContainerResources(ctx context.Context, containerID string, resources *runtimeapi.LinuxContainerResources) error
+}
+
+// Manager 为容器分配cpu，并定期把分配结果同步到运行中的容器
+// 独占cpu在准入时分配，共享池随着分配和释放变化，已经运行的容器由reconcile更新cpuset
+// pkg/kubelet/cm/cpumanager/cpu_manager.go
+type Manager struct {
+	// 保护policy对state的修改
+	lock            sync.Mutex
+	policy          Policy
+	state           *State
+	reconcilePeriod time.Duration
+
+	activePods     ActivePodsFunc
+	runtimeService RuntimeService
+	// 容器id -> 最近一次写入的cpuset，没有变化时不重复调用运行时
+	lastUpdate map[string]cpuset.CPUSet
+}
+
+// NewManager policyName为none或static，static策略从sysCPUPath读取cpu拓扑，reservedCPUs留给系统进程和共享池
+func NewManager(policyName string, reconcilePeriod time.Duration, sysCPUPath string, reservedCPUs cpuset.CPUSet,
+	affinity topologymanager.Store, stateDir string) (*Manager, error) {
+	var policy Policy
+	switch policyName {
+	case PolicyNone:
+		policy = NewNonePolicy()
+	case PolicyStatic:
+		topology, err := Discover(sysCPUPath)
+		if err != nil {
+			return nil, err
+		}
+		klog.InfoS("Detected CPU topology", "numCPUs", topology.NumCPUs, "numCores", topology.NumCores,
+			"numSockets", topology.NumSockets, "numNUMANodes", topology.NumNUMANodes)
+		if policy, err = NewStaticPolicy(topology, reservedCPUs, affinity); err != nil {
+			return nil, fmt.Errorf("new static policy error: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy: %q", policyName)
+	}
+	state, err := NewCheckpointState(stateDir, cpuManagerStateFileName, policy.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{
+		policy:          policy,
+		state:           state,
+		reconcilePeriod: reconcilePeriod,
+		lastUpdate:      map[string]cpuset.CPUSet{},
+	}, nil
+}
+
+// Start 校验检查点中的状态，static策略下定期reconcile
+func (this *Manager) Start(activePods ActivePodsFunc, runtimeService RuntimeService, stopCh <-chan struct{}) error {
+	klog.InfoS("Starting CPU manager", "policy", this.policy.Name())
+	this.activePods = activePods
+	this.runtimeService = runtimeService
+	if err := this.policy.Start(this.state); err != nil {
+		return fmt.Errorf("start cpu manager error: %v", err)
+	}
+	if this.policy.Name() == PolicyNone {
+		return nil
+	}
+	go wait.Until(this.reconcileState, this.reconcilePeriod, stopCh)
+	return nil
+}
+
+// Allocate 准入时为容器分配独占cpu，先清理已经不存在的pod占用的cpu
+func (this *Manager) Allocate(pod *v1.Pod, container *v1.Container) error {
+	this.removeStaleState()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.Allocate(this.state, pod, container)
+}
+
+// GetTopologyHints 准入时由拓扑管理器调用，先清理已经不存在的pod占用的cpu
+func (this *Manager) GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	this.removeStaleState()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.GetTopologyHints(this.state, pod, container)
+}
+
+// RemoveContainer 容器独占的cpu归还共享池
+func (this *Manager) RemoveContainer(podUID types.UID, containerName string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.RemoveContainer(this.state, string(podUID), containerName)
+}
+
+// GetCPUSet 创建容器时使用的cpuset，none策略下为空，表示不限制
+func (this *Manager) GetCPUSet(podUID types.UID, containerName string) cpuset.CPUSet {
+	return this.state.GetCPUSetOrDefault(string(podUID), containerName)
+}
+
+// removeStaleState 已经不在运行的pod的容器归还独占的cpu
+func (this *Manager) removeStaleState() {
+	if this.activePods == nil {
+		return
+	}
+	activeContainers := map[string]map[string]bool{}
+	for _, pod := range this.activePods() {
+		podUID := string(pod.UID)
+		activeContainers[podUID] = map[string]bool{}
+		for _, c := range pod.Spec.InitContainers {
+			activeContainers[podUID][c.Name] = true
+		}
+		for _, c := range pod.Spec.Containers {
+			activeContainers[podUID][c.Name] = true
+		}
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for podUID, containers := range this.state.GetCPUAssignments() {
+		for containerName := range containers {
+			if activeContainers[podUID][containerName] {
+				continue
+			}
+			klog.InfoS("RemoveStaleState: removing container", "podUID", podUID, "containerName", containerName)
+			if err := this.policy.RemoveContainer(this.state, podUID, containerName); err != nil {
+				klog.ErrorS(err, "RemoveStaleState: failed to remove container", "podUID", podUID, "containerName", containerName)
+			}
+		}
+	}
+}
+
+// reconcileState 运行中的容器按state更新cpuset，共享池变化后使用共享池的容器也需要更新
+// pkg/kubelet/cm/cpumanager/cpu_manager.go reconcileState
+func (this *Manager) reconcileState() {
+	this.removeStaleState()
+
+	ctx := context.Background()
+	runningPods, err := this.runtimeService.GetPods(ctx)
+	if err != nil {
+		klog.ErrorS(err, "ReconcileState: failed to list pods from runtime")
+		return
+	}
+	activePods := map[types.UID]bool{}
+	for _, pod := range this.activePods() {
+		activePods[pod.UID] = true
+	}
+
+	seen := map[string]bool{}
+	for _, pod := range runningPods {
+		if !activePods[pod.ID] {
+			continue
+		}
+		for _, c := range pod.Containers {
+			if c.State != container.ContainerStateRunning {
+				continue
+			}
+			containerID := c.ID.ID
+			seen[containerID] = true
+			cset := this.GetCPUSet(pod.ID, c.Name)
+			if cset.IsEmpty() {
+				continue
+			}
+			if last, ok := this.lastUpdate[containerID]; ok && last.Equals(cset) {
+				continue
+			}
+			klog.V(4).InfoS("ReconcileState: updating container", "podUID", pod.ID, "containerName", c.Name,
+				"containerID", containerID, "cpuSet", cset.String())
+			err = this.runtimeService.UpdateContainerResources(ctx, containerID, &runtimeapi.LinuxContainerResources{
+				CpusetCpus: cset.String(),
+			})
+			if err != nil {
+				klog.ErrorS(err, "ReconcileState: failed to update container", "podUID", pod.ID, "containerName", c.Name,
+					"containerID", containerID, "cpuSet", cset.String())
+				continue
+			}
+			this.lastUpdate[containerID] = cset
+		}
+	}
+	for containerID := range this.lastUpdate {
+		if !seen[containerID] {
+			delete(this.lastUpdate, containerID)
+		}
+	}
+}
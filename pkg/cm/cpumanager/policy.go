@@ -0,0 +1,55 @@
+package cpumanager
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"mykubelet/pkg/cm/topologymanager"
+)
+
+const (
+	// PolicyNone 不分配独占cpu，所有容器使用节点上全部cpu
+	PolicyNone = "none"
+	// PolicyStatic Guaranteed pod中请求整数cpu的容器独占cpu
+	PolicyStatic = "static"
+)
+
+// Policy cpu分配策略
+// pkg/kubelet/cm/cpumanager/policy.go
+type Policy interface {
+	Name() string
+	// Start 校验从检查点恢复的状态，没有状态时初始化
+	Start(s *State) error
+	// Allocate 为容器分配cpu，已经分配过时不重复分配
+	Allocate(s *State, pod *v1.Pod, container *v1.Container) error
+	// RemoveContainer 容器独占的cpu归还共享池
+	RemoveContainer(s *State, podUID string, containerName string) error
+	// GetTopologyHints 容器需要的独占cpu可以分配在哪些NUMA节点上
+	GetTopologyHints(s *State, pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint
+}
+
+// nonePolicy 不做任何分配
+// pkg/kubelet/cm/cpumanager/policy_none.go
+type nonePolicy struct{}
+
+func NewNonePolicy() Policy {
+	return &nonePolicy{}
+}
+
+func (this *nonePolicy) Name() string {
+	return PolicyNone
+}
+
+func (this *nonePolicy) Start(_ *State) error {
+	return nil
+}
+
+func (this *nonePolicy) Allocate(_ *State, _ *v1.Pod, _ *v1.Container) error {
+	return nil
+}
+
+func (this *nonePolicy) RemoveContainer(_ *State, _ string, _ string) error {
+	return nil
+}
+
+func (this *nonePolicy) GetTopologyHints(_ *State, _ *v1.Pod, _ *v1.Container) map[string][]topologymanager.TopologyHint {
+	return nil
+}
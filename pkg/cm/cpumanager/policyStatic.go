@@ -0,0 +1,238 @@
+package cpumanager
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm/topologymanager"
+	"mykubelet/pkg/qos"
+)
+
+// staticPolicy Guaranteed pod中cpu requests为整数的容器按拓扑分配独占的cpu，其他容器使用共享池
+// 预留的cpu始终留在共享池中，不分配给容器
+// pkg/kubelet/cm/cpumanager/policy_static.go
+type staticPolicy struct {
+	topology *CPUTopology
+	reserved cpuset.CPUSet
+	// 拓扑管理器合并后的hint，独占cpu优先从其中的NUMA节点分配
+	affinity topologymanager.Store
+	// init容器按顺序运行，其独占的cpu可以被同一pod中之后的容器复用
+	cpusToReuse map[string]cpuset.CPUSet
+}
+
+// NewStaticPolicy reservedCPUs不能为空，避免系统进程和共享池中的容器没有cpu可用
+func NewStaticPolicy(topology *CPUTopology, reservedCPUs cpuset.CPUSet, affinity topologymanager.Store) (Policy, error) {
+	if reservedCPUs.Size() == 0 {
+		return nil, fmt.Errorf("the static policy requires reserved cpus to be greater than zero")
+	}
+	allCPUs := topology.CPUDetails.CPUs()
+	if !reservedCPUs.IsSubsetOf(allCPUs) {
+		return nil, fmt.Errorf("reserved cpus %s are not a subset of the online cpus %s", reservedCPUs, allCPUs)
+	}
+	klog.InfoS("Static policy created with configuration", "reserved", reservedCPUs.String())
+	return &staticPolicy{
+		topology:    topology,
+		reserved:    reservedCPUs,
+		affinity:    affinity,
+		cpusToReuse: map[string]cpuset.CPUSet{},
+	}, nil
+}
+
+func (this *staticPolicy) Name() string {
+	return PolicyStatic
+}
+
+// Start 没有状态时共享池为全部cpu；有状态时共享池需要包含预留的cpu，且和各容器独占的cpu合起来正好是全部cpu
+func (this *staticPolicy) Start(s *State) error {
+	allCPUs := this.topology.CPUDetails.CPUs()
+	assignments := s.GetCPUAssignments()
+	defaultCPUSet := s.GetDefaultCPUSet()
+	if defaultCPUSet.IsEmpty() {
+		if len(assignments) != 0 {
+			return fmt.Errorf("default cpuset cannot be empty")
+		}
+		s.SetDefaultCPUSet(allCPUs)
+		return nil
+	}
+	if !this.reserved.IsSubsetOf(defaultCPUSet) {
+		return fmt.Errorf("not all reserved cpus: %q are present in defaultCpuSet: %q", this.reserved.String(), defaultCPUSet.String())
+	}
+	totalKnownCPUs := defaultCPUSet
+	for pod := range assignments {
+		for container, cset := range assignments[pod] {
+			if !cset.Intersection(defaultCPUSet).IsEmpty() {
+				return fmt.Errorf("pod: %s, container: %s cpuset: %q overlaps with default cpuset %q",
+					pod, container, cset.String(), defaultCPUSet.String())
+			}
+			totalKnownCPUs = totalKnownCPUs.Union(cset)
+		}
+	}
+	if !totalKnownCPUs.Equals(allCPUs) {
+		return fmt.Errorf("current set of available CPUs %q doesn't match with CPUs in state %q",
+			allCPUs.String(), totalKnownCPUs.String())
+	}
+	return nil
+}
+
+// assignableCPUs 共享池中除预留以外的cpu
+func (this *staticPolicy) assignableCPUs(s *State) cpuset.CPUSet {
+	return s.GetDefaultCPUSet().Difference(this.reserved)
+}
+
+func (this *staticPolicy) Allocate(s *State, pod *v1.Pod, container *v1.Container) error {
+	numCPUs := guaranteedCPUs(pod, container)
+	if numCPUs == 0 {
+		return nil
+	}
+	podUID := string(pod.UID)
+	if cset, ok := s.GetCPUSet(podUID, container.Name); ok {
+		this.updateCPUsToReuse(pod, container, cset)
+		klog.InfoS("Static policy: container already present in state, skipping", "pod", klog.KObj(pod), "containerName", container.Name)
+		return nil
+	}
+
+	hint := this.affinity.GetAffinity(podUID, container.Name)
+	cset, err := this.allocateCPUs(s, numCPUs, hint.NUMANodeAffinity, this.cpusToReuse[podUID])
+	if err != nil {
+		klog.ErrorS(err, "Unable to allocate CPUs", "pod", klog.KObj(pod), "containerName", container.Name, "numCPUs", numCPUs)
+		return err
+	}
+	s.SetCPUSet(podUID, container.Name, cset)
+	s.SetDefaultCPUSet(s.GetDefaultCPUSet().Difference(cset))
+	this.updateCPUsToReuse(pod, container, cset)
+	klog.InfoS("Static policy: allocated exclusive CPUs", "pod", klog.KObj(pod), "containerName", container.Name, "cpuset", cset.String())
+	return nil
+}
+
+// allocateCPUs 先从numaAffinity中的NUMA节点选取，不够时再从其他节点选取
+// pkg/kubelet/cm/cpumanager/policy_static.go allocateCPUs
+func (this *staticPolicy) allocateCPUs(s *State, numCPUs int, numaAffinity topologymanager.BitMask,
+	reusableCPUs cpuset.CPUSet) (cpuset.CPUSet, error) {
+	allocatableCPUs := this.assignableCPUs(s).Union(reusableCPUs)
+	result := cpuset.New()
+	if !numaAffinity.IsEmpty() {
+		alignedCPUs := cpuset.New()
+		for _, numaNodeID := range numaAffinity.GetBits() {
+			alignedCPUs = alignedCPUs.Union(allocatableCPUs.Intersection(this.topology.CPUDetails.CPUsInNUMANodes(numaNodeID)))
+		}
+		numAlignedToAlloc := alignedCPUs.Size()
+		if numCPUs < numAlignedToAlloc {
+			numAlignedToAlloc = numCPUs
+		}
+		alignedCPUs, err := takeByTopology(this.topology, alignedCPUs, numAlignedToAlloc)
+		if err != nil {
+			return cpuset.New(), err
+		}
+		result = result.Union(alignedCPUs)
+	}
+	remainingCPUs, err := takeByTopology(this.topology, allocatableCPUs.Difference(result), numCPUs-result.Size())
+	if err != nil {
+		return cpuset.New(), err
+	}
+	return result.Union(remainingCPUs), nil
+}
+
+// GetTopologyHints 已经分配过的容器按分配结果返回hint，否则遍历所有NUMA节点的组合
+func (this *staticPolicy) GetTopologyHints(s *State, pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	requested := guaranteedCPUs(pod, container)
+	if requested == 0 {
+		return nil
+	}
+	podUID := string(pod.UID)
+	if allocated, ok := s.GetCPUSet(podUID, container.Name); ok {
+		if allocated.Size() != requested {
+			klog.ErrorS(nil, "CPUs already allocated to container with different number than request", "pod", klog.KObj(pod),
+				"containerName", container.Name, "requestedSize", requested, "allocatedSize", allocated.Size())
+			return map[string][]topologymanager.TopologyHint{string(v1.ResourceCPU): {}}
+		}
+		return map[string][]topologymanager.TopologyHint{
+			string(v1.ResourceCPU): this.generateCPUTopologyHints(allocated, cpuset.New(), requested),
+		}
+	}
+	available := this.assignableCPUs(s)
+	reusable := this.cpusToReuse[podUID]
+	return map[string][]topologymanager.TopologyHint{
+		string(v1.ResourceCPU): this.generateCPUTopologyHints(available, reusable, requested),
+	}
+}
+
+// generateCPUTopologyHints 可用cpu足够的NUMA节点组合都是hint，节点数最少的为preferred
+// pkg/kubelet/cm/cpumanager/policy_static.go generateCPUTopologyHints
+func (this *staticPolicy) generateCPUTopologyHints(availableCPUs cpuset.CPUSet, reusableCPUs cpuset.CPUSet,
+	request int) []topologymanager.TopologyHint {
+	// 不考虑已经分配的cpu时，满足请求最少需要的NUMA节点数
+	minAffinitySize := this.topology.CPUDetails.NUMANodes().Size()
+	hints := []topologymanager.TopologyHint{}
+	topologymanager.IterateBitMasks(this.topology.CPUDetails.NUMANodes().List(), func(mask topologymanager.BitMask) {
+		cpusInMask := this.topology.CPUDetails.CPUsInNUMANodes(mask.GetBits()...)
+		if cpusInMask.Size() >= request && mask.Count() < minAffinitySize {
+			minAffinitySize = mask.Count()
+		}
+		numMatching := availableCPUs.Union(reusableCPUs).Intersection(cpusInMask).Size()
+		if numMatching < request {
+			return
+		}
+		hints = append(hints, topologymanager.TopologyHint{NUMANodeAffinity: mask})
+	})
+	for i := range hints {
+		if hints[i].NUMANodeAffinity.Count() == minAffinitySize {
+			hints[i].Preferred = true
+		}
+	}
+	return hints
+}
+
+// RemoveContainer 同一pod中其他容器还在使用的复用cpu不归还
+func (this *staticPolicy) RemoveContainer(s *State, podUID string, containerName string) error {
+	cset, ok := s.GetCPUSet(podUID, containerName)
+	if !ok {
+		return nil
+	}
+	s.Delete(podUID, containerName)
+	cpusInUse := cpuset.New()
+	for _, siblingCPUs := range s.GetCPUAssignments()[podUID] {
+		cpusInUse = cpusInUse.Union(siblingCPUs)
+	}
+	toRelease := cset.Difference(cpusInUse)
+	s.SetDefaultCPUSet(s.GetDefaultCPUSet().Union(toRelease))
+	klog.V(2).InfoS("Static policy: released CPUs", "podUID", podUID, "containerName", containerName, "cpuset", toRelease.String())
+	return nil
+}
+
+// updateCPUsToReuse 普通init容器的cpu在其退出后可以复用，业务容器和sidecar容器的cpu不能复用
+func (this *staticPolicy) updateCPUsToReuse(pod *v1.Pod, container *v1.Container, cset cpuset.CPUSet) {
+	podUID := string(pod.UID)
+	// 只记录最近分配的pod
+	for uid := range this.cpusToReuse {
+		if uid != podUID {
+			delete(this.cpusToReuse, uid)
+		}
+	}
+	if _, ok := this.cpusToReuse[podUID]; !ok {
+		this.cpusToReuse[podUID] = cpuset.New()
+	}
+	for _, initContainer := range pod.Spec.InitContainers {
+		if initContainer.Name != container.Name {
+			continue
+		}
+		if initContainer.RestartPolicy == nil || *initContainer.RestartPolicy != v1.ContainerRestartPolicyAlways {
+			this.cpusToReuse[podUID] = this.cpusToReuse[podUID].Union(cset)
+			return
+		}
+		break
+	}
+	this.cpusToReuse[podUID] = this.cpusToReuse[podUID].Difference(cset)
+}
+
+// guaranteedCPUs Guaranteed pod中cpu requests为整数的容器需要的独占cpu数，其他容器为0
+func guaranteedCPUs(pod *v1.Pod, container *v1.Container) int {
+	if qos.GetPodQOS(pod) != v1.PodQOSGuaranteed {
+		return 0
+	}
+	cpuQuantity := container.Resources.Requests[v1.ResourceCPU]
+	if cpuQuantity.Value()*1000 != cpuQuantity.MilliValue() {
+		return 0
+	}
+	return int(cpuQuantity.Value())
+}
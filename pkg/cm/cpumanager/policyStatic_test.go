@@ -0,0 +1,290 @@
+package cpumanager
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm/topologymanager"
+	"testing"
+)
+
+// fakeAffinity 拓扑管理器合并后的hint，没有设置的容器不限制NUMA节点
+type fakeAffinity map[string]topologymanager.TopologyHint
+
+func (this fakeAffinity) GetAffinity(podUID string, containerName string) topologymanager.TopologyHint {
+	return this[podUID+"/"+containerName]
+}
+
+func newStaticPolicyForTest(t *testing.T, affinity fakeAffinity) (*staticPolicy, *State) {
+	policy, err := NewStaticPolicy(discover(t, dualSocketHT()), cpuset.New(0), affinity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewCheckpointState(t.TempDir(), cpuManagerStateFileName, PolicyStatic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = policy.Start(s); err != nil {
+		t.Fatal(err)
+	}
+	return policy.(*staticPolicy), s
+}
+
+func resourceContainer(name, cpu, memory string, limited bool) v1.Container {
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse(cpu),
+		v1.ResourceMemory: resource.MustParse(memory),
+	}
+	c := v1.Container{Name: name, Resources: v1.ResourceRequirements{Requests: resources}}
+	if limited {
+		c.Resources.Limits = resources
+	}
+	return c
+}
+
+func guaranteedContainer(name, cpu string) v1.Container {
+	return resourceContainer(name, cpu, "1Gi", true)
+}
+
+func newCPUPod(uid string, initContainers []v1.Container, containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+		Spec:       v1.PodSpec{InitContainers: initContainers, Containers: containers},
+	}
+}
+
+func allocate(t *testing.T, policy *staticPolicy, s *State, pod *v1.Pod, c *v1.Container) cpuset.CPUSet {
+	if err := policy.Allocate(s, pod, c); err != nil {
+		t.Fatalf("failed to allocate %s/%s: %v", pod.UID, c.Name, err)
+	}
+	return s.GetCPUSetOrDefault(string(pod.UID), c.Name)
+}
+
+func TestNewStaticPolicyReserved(t *testing.T) {
+	topo := discover(t, dualSocketHT())
+	if _, err := NewStaticPolicy(topo, cpuset.New(), fakeAffinity{}); err == nil {
+		t.Errorf("expected error without reserved cpus")
+	}
+	if _, err := NewStaticPolicy(topo, cpuset.New(0, 8), fakeAffinity{}); err == nil {
+		t.Errorf("expected error when reserved cpus are not online")
+	}
+}
+
+// 依次分配核、socket和单个线程，预留的cpu0不会被分配
+func TestStaticPolicyAllocate(t *testing.T) {
+	policy, s := newStaticPolicyForTest(t, fakeAffinity{})
+	steps := []struct {
+		cpu      string
+		expected cpuset.CPUSet
+	}{
+		{cpu: "2", expected: cpuset.New(1, 5)},
+		{cpu: "4", expected: cpuset.New(2, 3, 6, 7)},
+		{cpu: "1", expected: cpuset.New(4)},
+	}
+	for i, step := range steps {
+		c := guaranteedContainer("app", step.cpu)
+		pod := newCPUPod(string(rune('a'+i)), nil, c)
+		if cset := allocate(t, policy, s, pod, &c); !cset.Equals(step.expected) {
+			t.Errorf("step %d: expected %s, got %s", i, step.expected, cset)
+		}
+	}
+	if !s.GetDefaultCPUSet().Equals(cpuset.New(0)) {
+		t.Errorf("expected only the reserved cpu left in the shared pool, got %s", s.GetDefaultCPUSet())
+	}
+	c := guaranteedContainer("app", "1")
+	if err := policy.Allocate(s, newCPUPod("full", nil, c), &c); err == nil {
+		t.Errorf("expected error when only reserved cpus are left")
+	}
+	for _, containers := range s.GetCPUAssignments() {
+		for _, cset := range containers {
+			if cset.Contains(0) {
+				t.Errorf("reserved cpu 0 handed out in %s", cset)
+			}
+		}
+	}
+
+	// 已经分配过的容器不重复分配
+	pod := newCPUPod("a", nil, guaranteedContainer("app", "2"))
+	if cset := allocate(t, policy, s, pod, &pod.Spec.Containers[0]); !cset.Equals(cpuset.New(1, 5)) {
+		t.Errorf("expected existing assignment to be kept, got %s", cset)
+	}
+}
+
+func TestStaticPolicyAllocateNUMAAffinity(t *testing.T) {
+	node1, err := topologymanager.NewBitMask(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, s := newStaticPolicyForTest(t, fakeAffinity{"pod/app": {NUMANodeAffinity: node1, Preferred: true}})
+	c := guaranteedContainer("app", "2")
+	if cset := allocate(t, policy, s, newCPUPod("pod", nil, c), &c); !cset.Equals(cpuset.New(2, 6)) {
+		t.Errorf("expected a core on NUMA node 1, got %s", cset)
+	}
+}
+
+func TestStaticPolicyAllocateShared(t *testing.T) {
+	testCases := []struct {
+		name string
+		pod  *v1.Pod
+	}{
+		{name: "burstable", pod: newCPUPod("pod", nil, resourceContainer("app", "2", "1Gi", false))},
+		{name: "fractional cpu", pod: newCPUPod("pod", nil, guaranteedContainer("app", "1500m"))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, s := newStaticPolicyForTest(t, fakeAffinity{})
+			cset := allocate(t, policy, s, tc.pod, &tc.pod.Spec.Containers[0])
+			if len(s.GetCPUAssignments()) != 0 || !cset.Equals(cpuset.New(0, 1, 2, 3, 4, 5, 6, 7)) {
+				t.Errorf("expected container to use the shared pool, got %s", cset)
+			}
+		})
+	}
+}
+
+func TestStaticPolicyReuseInitContainerCPUs(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	sidecar := guaranteedContainer("sidecar", "2")
+	sidecar.RestartPolicy = &always
+	testCases := []struct {
+		name           string
+		initContainer  v1.Container
+		expectedInit   cpuset.CPUSet
+		expectedApp    cpuset.CPUSet
+		expectedShared cpuset.CPUSet
+	}{
+		{
+			name:           "init container cpus are reused",
+			initContainer:  guaranteedContainer("init", "2"),
+			expectedInit:   cpuset.New(1, 5),
+			expectedApp:    cpuset.New(1, 5),
+			expectedShared: cpuset.New(0, 2, 3, 4, 6, 7),
+		},
+		{
+			name:           "sidecar cpus are not reused",
+			initContainer:  sidecar,
+			expectedInit:   cpuset.New(1, 5),
+			expectedApp:    cpuset.New(2, 6),
+			expectedShared: cpuset.New(0, 3, 4, 7),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, s := newStaticPolicyForTest(t, fakeAffinity{})
+			pod := newCPUPod("pod", []v1.Container{tc.initContainer}, guaranteedContainer("app", "2"))
+			if cset := allocate(t, policy, s, pod, &pod.Spec.InitContainers[0]); !cset.Equals(tc.expectedInit) {
+				t.Errorf("expected init container to get %s, got %s", tc.expectedInit, cset)
+			}
+			if cset := allocate(t, policy, s, pod, &pod.Spec.Containers[0]); !cset.Equals(tc.expectedApp) {
+				t.Errorf("expected app container to get %s, got %s", tc.expectedApp, cset)
+			}
+			if !s.GetDefaultCPUSet().Equals(tc.expectedShared) {
+				t.Errorf("expected shared pool %s, got %s", tc.expectedShared, s.GetDefaultCPUSet())
+			}
+		})
+	}
+}
+
+func TestStaticPolicyRemoveContainer(t *testing.T) {
+	policy, s := newStaticPolicyForTest(t, fakeAffinity{})
+	pod := newCPUPod("pod", []v1.Container{guaranteedContainer("init", "2")}, guaranteedContainer("app", "2"))
+	allocate(t, policy, s, pod, &pod.Spec.InitContainers[0])
+	allocate(t, policy, s, pod, &pod.Spec.Containers[0])
+
+	// 复用的cpu还被业务容器使用，不归还
+	if err := policy.RemoveContainer(s, "pod", "init"); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetDefaultCPUSet().Contains(1) || s.GetDefaultCPUSet().Contains(5) {
+		t.Errorf("cpus still used by the app container returned to the shared pool: %s", s.GetDefaultCPUSet())
+	}
+	if err := policy.RemoveContainer(s, "pod", "app"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.GetDefaultCPUSet().Equals(cpuset.New(0, 1, 2, 3, 4, 5, 6, 7)) || len(s.GetCPUAssignments()) != 0 {
+		t.Errorf("expected all cpus back in the shared pool, got %s %v", s.GetDefaultCPUSet(), s.GetCPUAssignments())
+	}
+}
+
+func TestStaticPolicyStart(t *testing.T) {
+	all := cpuset.New(0, 1, 2, 3, 4, 5, 6, 7)
+	testCases := []struct {
+		name        string
+		defaultSet  cpuset.CPUSet
+		assignments map[string]cpuset.CPUSet
+		expected    cpuset.CPUSet
+		wantErr     bool
+	}{
+		{
+			name:     "empty state uses all cpus",
+			expected: all,
+		},
+		{
+			name:       "valid state",
+			defaultSet: cpuset.New(0, 2, 3, 4, 6, 7),
+			assignments: map[string]cpuset.CPUSet{
+				"app": cpuset.New(1, 5),
+			},
+			expected: cpuset.New(0, 2, 3, 4, 6, 7),
+		},
+		{
+			name:        "empty default set with assignments",
+			assignments: map[string]cpuset.CPUSet{"app": cpuset.New(1, 5)},
+			wantErr:     true,
+		},
+		{
+			name:       "reserved cpu missing from default set",
+			defaultSet: cpuset.New(2, 3, 4, 6, 7),
+			assignments: map[string]cpuset.CPUSet{
+				"app": cpuset.New(0, 1, 5),
+			},
+			wantErr: true,
+		},
+		{
+			name:       "assignment overlaps default set",
+			defaultSet: cpuset.New(0, 1, 2, 3, 4, 6, 7),
+			assignments: map[string]cpuset.CPUSet{
+				"app": cpuset.New(1, 5),
+			},
+			wantErr: true,
+		},
+		{
+			name:       "state does not cover all cpus",
+			defaultSet: cpuset.New(0, 2, 3),
+			assignments: map[string]cpuset.CPUSet{
+				"app": cpuset.New(1, 5),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewStaticPolicy(discover(t, dualSocketHT()), cpuset.New(0), fakeAffinity{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := NewCheckpointState(t.TempDir(), cpuManagerStateFileName, PolicyStatic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.SetDefaultCPUSet(tc.defaultSet)
+			for name, cset := range tc.assignments {
+				s.SetCPUSet("pod", name, cset)
+			}
+			err = policy.Start(s)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !s.GetDefaultCPUSet().Equals(tc.expected) {
+				t.Errorf("expected default set %s, got %s", tc.expected, s.GetDefaultCPUSet())
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package cpumanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ContainerCPUAssignments pod uid -> 容器名 -> 独占的cpu
+type ContainerCPUAssignments map[string]map[string]cpuset.CPUSet
+
+// Clone 深拷贝
+func (this ContainerCPUAssignments) Clone() ContainerCPUAssignments {
+	ret := make(ContainerCPUAssignments, len(this))
+	for pod := range this {
+		ret[pod] = make(map[string]cpuset.CPUSet, len(this[pod]))
+		for container, cset := range this[pod] {
+			ret[pod][container] = cset
+		}
+	}
+	return ret
+}
+
+// cpuManagerCheckpoint 检查点文件的内容，checksum为其余字段的哈希，用于发现文件损坏
+type cpuManagerCheckpoint struct {
+	PolicyName    string                       `json:"policyName"`
+	DefaultCPUSet string                       `json:"defaultCpuSet"`
+	Entries       map[string]map[string]string `json:"entries,omitempty"`
+	Checksum      uint64                       `json:"checksum"`
+}
+
+func (this *cpuManagerCheckpoint) computeChecksum() (uint64, error) {
+	copied := *this
+	copied.Checksum = 0
+	data, err := json.Marshal(copied)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64(), nil
+}
+
+// State 容器独占的cpu和共享池，每次修改后写入检查点文件，kubelet重启后恢复
+// pkg/kubelet/cm/cpumanager/state/state_checkpoint.go
+type State struct {
+	lock           sync.RWMutex
+	policyName     string
+	checkpointPath string
+	assignments    ContainerCPUAssignments
+	// 没有独占cpu的容器共享的cpu
+	defaultCPUSet cpuset.CPUSet
+}
+
+// NewCheckpointState 从检查点文件恢复状态，文件不存在时为空状态，策略和检查点中的不一致时返回错误
+func NewCheckpointState(stateDir, checkpointName, policyName string) (*State, error) {
+	state := &State{
+		policyName:     policyName,
+		checkpointPath: filepath.Join(stateDir, checkpointName),
+		assignments:    ContainerCPUAssignments{},
+		defaultCPUSet:  cpuset.New(),
+	}
+	if err := state.restoreState(); err != nil {
+		return nil, fmt.Errorf("could not restore state from checkpoint: %v, please drain this node and delete the CPU manager checkpoint file %q before restarting Kubelet",
+			err, state.checkpointPath)
+	}
+	return state, nil
+}
+
+func (this *State) restoreState() error {
+	data, err := os.ReadFile(this.checkpointPath)
+	if os.IsNotExist(err) {
+		return this.storeState()
+	}
+	if err != nil {
+		return err
+	}
+	checkpoint := &cpuManagerCheckpoint{}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return fmt.Errorf("checkpoint is corrupted: %v", err)
+	}
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return err
+	}
+	if checksum != checkpoint.Checksum {
+		return fmt.Errorf("checkpoint is corrupted: checksum mismatch")
+	}
+	if checkpoint.PolicyName != this.policyName {
+		return fmt.Errorf("configured policy %q differs from state checkpoint policy %q", this.policyName, checkpoint.PolicyName)
+	}
+
+	if this.defaultCPUSet, err = cpuset.Parse(checkpoint.DefaultCPUSet); err != nil {
+		return fmt.Errorf("could not parse default cpu set %q: %v", checkpoint.DefaultCPUSet, err)
+	}
+	for pod := range checkpoint.Entries {
+		this.assignments[pod] = map[string]cpuset.CPUSet{}
+		for container, cpuString := range checkpoint.Entries[pod] {
+			cset, err := cpuset.Parse(cpuString)
+			if err != nil {
+				return fmt.Errorf("could not parse cpuset %q for container %q in pod %q: %v", cpuString, container, pod, err)
+			}
+			this.assignments[pod][container] = cset
+		}
+	}
+	klog.V(2).InfoS("State checkpoint: restored state from checkpoint", "defaultCPUSet", this.defaultCPUSet.String())
+	return nil
+}
+
+// storeState 先写临时文件再重命名，避免写入过程中重启导致文件损坏
+func (this *State) storeState() error {
+	checkpoint := &cpuManagerCheckpoint{
+		PolicyName:    this.policyName,
+		DefaultCPUSet: this.defaultCPUSet.String(),
+		Entries:       map[string]map[string]string{},
+	}
+	for pod := range this.assignments {
+		checkpoint.Entries[pod] = map[string]string{}
+		for container, cset := range this.assignments[pod] {
+			checkpoint.Entries[pod][container] = cset.String()
+		}
+	}
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return err
+	}
+	checkpoint.Checksum = checksum
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmpPath := this.checkpointPath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, this.checkpointPath)
+}
+
+func (this *State) store() {
+	if err := this.storeState(); err != nil {
+		klog.ErrorS(err, "Failed to store cpu manager state checkpoint", "path", this.checkpointPath)
+	}
+}
+
+// GetCPUSet 容器独占的cpu
+func (this *State) GetCPUSet(podUID, containerName string) (cpuset.CPUSet, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	cset, ok := this.assignments[podUID][containerName]
+	return cset, ok
+}
+
+// GetDefaultCPUSet 共享池
+func (this *State) GetDefaultCPUSet() cpuset.CPUSet {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.defaultCPUSet
+}
+
+// GetCPUSetOrDefault 容器没有独占cpu时使用共享池
+func (this *State) GetCPUSetOrDefault(podUID, containerName string) cpuset.CPUSet {
+	if cset, ok := this.GetCPUSet(podUID, containerName); ok {
+		return cset
+	}
+	return this.GetDefaultCPUSet()
+}
+
+// GetCPUAssignments 所有容器独占的cpu
+func (this *State) GetCPUAssignments() ContainerCPUAssignments {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.assignments.Clone()
+}
+
+// SetCPUSet 设置容器独占的cpu
+func (this *State) SetCPUSet(podUID, containerName string, cset cpuset.CPUSet) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.assignments[podUID]; !ok {
+		this.assignments[podUID] = map[string]cpuset.CPUSet{}
+	}
+	this.assignments[podUID][containerName] = cset
+	this.store()
+}
+
+// SetDefaultCPUSet 设置共享池
+func (this *State) SetDefaultCPUSet(cset cpuset.CPUSet) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.defaultCPUSet = cset
+	this.store()
+}
+
+// Delete 删除容器独占的cpu记录，cpu需要由调用方归还共享池
+func (this *State) Delete(podUID, containerName string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.assignments[podUID]; !ok {
+		return
+	}
+	delete(this.assignments[podUID], containerName)
+	if len(this.assignments[podUID]) == 0 {
+		delete(this.assignments, podUID)
+	}
+	this.store()
+}
@@ -0,0 +1,110 @@
+package cpumanager
+
+import (
+	"k8s.io/utils/cpuset"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewCheckpointState(dir, cpuManagerStateFileName, PolicyStatic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDefaultCPUSet(cpuset.New(0, 3, 4, 7))
+	s.SetCPUSet("pod", "init", cpuset.New(1, 5))
+	s.SetCPUSet("pod", "app", cpuset.New(1, 5))
+	s.SetCPUSet("other", "app", cpuset.New(2, 6))
+	s.Delete("pod", "init")
+
+	restored, err := NewCheckpointState(dir, cpuManagerStateFileName, PolicyStatic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.GetDefaultCPUSet().Equals(cpuset.New(0, 3, 4, 7)) {
+		t.Errorf("expected default set 0,3-4,7, got %s", restored.GetDefaultCPUSet())
+	}
+	assignments := restored.GetCPUAssignments()
+	if len(assignments) != 2 || len(assignments["pod"]) != 1 {
+		t.Errorf("unexpected assignments %v", assignments)
+	}
+	if cset, _ := restored.GetCPUSet("pod", "app"); !cset.Equals(cpuset.New(1, 5)) {
+		t.Errorf("expected pod/app to keep 1,5, got %s", cset)
+	}
+	if cset, _ := restored.GetCPUSet("other", "app"); !cset.Equals(cpuset.New(2, 6)) {
+		t.Errorf("expected other/app to keep 2,6, got %s", cset)
+	}
+	if _, ok := restored.GetCPUSet("pod", "init"); ok {
+		t.Errorf("expected deleted container to stay deleted")
+	}
+}
+
+func TestCheckpointStatePolicyMismatch(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCheckpointState(dir, cpuManagerStateFileName, PolicyStatic); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCheckpointState(dir, cpuManagerStateFileName, PolicyNone); err == nil {
+		t.Errorf("expected error when the policy changed")
+	}
+}
+
+func TestCheckpointStateCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewCheckpointState(dir, cpuManagerStateFileName, PolicyStatic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDefaultCPUSet(cpuset.New(0, 1))
+	path := filepath.Join(dir, cpuManagerStateFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改内容而不更新checksum
+	if err = os.WriteFile(path, []byte(strings.Replace(string(data), "0-1", "0-2", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewCheckpointState(dir, cpuManagerStateFileName, PolicyStatic); err == nil {
+		t.Errorf("expected error for a checkpoint with a wrong checksum")
+	}
+}
+
+// kubelet重启后从检查点恢复分配结果，并通过Start的校验
+func TestManagerRestoresCheckpoint(t *testing.T) {
+	sysCPUPath := writeSysCPU(t, dualSocketHT())
+	stateDir := t.TempDir()
+	manager, err := NewManager(PolicyStatic, 0, sysCPUPath, cpuset.New(0), fakeAffinity{}, stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.policy.Start(manager.state); err != nil {
+		t.Fatal(err)
+	}
+	c := guaranteedContainer("app", "2")
+	pod := newCPUPod("pod", nil, c)
+	if err = manager.Allocate(pod, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewManager(PolicyStatic, 0, sysCPUPath, cpuset.New(0), fakeAffinity{}, stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restarted.policy.Start(restarted.state); err != nil {
+		t.Fatalf("restored state failed validation: %v", err)
+	}
+	if cset := restarted.GetCPUSet(pod.UID, "app"); !cset.Equals(cpuset.New(1, 5)) {
+		t.Errorf("expected restored assignment 1,5, got %s", cset)
+	}
+	if cset := restarted.GetCPUSet(pod.UID, "other"); !cset.Equals(cpuset.New(0, 2, 3, 4, 6, 7)) {
+		t.Errorf("expected other containers to use the shared pool, got %s", cset)
+	}
+
+	if _, err = NewManager(PolicyNone, 0, sysCPUPath, cpuset.New(0), fakeAffinity{}, stateDir); err == nil {
+		t.Errorf("expected error when switching policy with an existing checkpoint")
+	}
+}
@@ -0,0 +1,227 @@
+package cpumanager
+
+import (
+	"fmt"
+	"k8s.io/utils/cpuset"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSysCPUPath 读取cpu拓扑的sysfs目录
+const DefaultSysCPUPath = "/sys/devices/system/cpu"
+
+// CPUInfo 逻辑cpu所在的NUMA节点、socket和物理核
+type CPUInfo struct {
+	NUMANodeID int
+	SocketID   int
+	CoreID     int
+}
+
+// CPUDetails 逻辑cpu编号到拓扑信息的映射
+type CPUDetails map[int]CPUInfo
+
+// CPUTopology 节点的cpu拓扑
+// pkg/kubelet/cm/cpumanager/topology/topology.go
+type CPUTopology struct {
+	NumCPUs      int
+	NumCores     int
+	NumSockets   int
+	NumNUMANodes int
+	CPUDetails   CPUDetails
+}
+
+// CPUsPerCore 每个物理核的超线程数
+func (this *CPUTopology) CPUsPerCore() int {
+	if this.NumCores == 0 {
+		return 0
+	}
+	return this.NumCPUs / this.NumCores
+}
+
+// CPUsPerSocket 每个socket的逻辑cpu数
+func (this *CPUTopology) CPUsPerSocket() int {
+	if this.NumSockets == 0 {
+		return 0
+	}
+	return this.NumCPUs / this.NumSockets
+}
+
+// KeepOnly 只保留cpus中的cpu
+func (this CPUDetails) KeepOnly(cpus cpuset.CPUSet) CPUDetails {
+	result := CPUDetails{}
+	for cpu, info := range this {
+		if cpus.Contains(cpu) {
+			result[cpu] = info
+		}
+	}
+	return result
+}
+
+// CPUs 所有逻辑cpu
+func (this CPUDetails) CPUs() cpuset.CPUSet {
+	cpus := make([]int, 0, len(this))
+	for cpu := range this {
+		cpus = append(cpus, cpu)
+	}
+	return cpuset.New(cpus...)
+}
+
+// NUMANodes 所有NUMA节点
+func (this CPUDetails) NUMANodes() cpuset.CPUSet {
+	ids := []int{}
+	for _, info := range this {
+		ids = append(ids, info.NUMANodeID)
+	}
+	return cpuset.New(ids...)
+}
+
+// Sockets 所有socket
+func (this CPUDetails) Sockets() cpuset.CPUSet {
+	ids := []int{}
+	for _, info := range this {
+		ids = append(ids, info.SocketID)
+	}
+	return cpuset.New(ids...)
+}
+
+// Cores 所有物理核，core_id只在socket内唯一，这里的编号是socket内最小的逻辑cpu编号
+func (this CPUDetails) Cores() cpuset.CPUSet {
+	ids := []int{}
+	for _, info := range this {
+		ids = append(ids, info.CoreID)
+	}
+	return cpuset.New(ids...)
+}
+
+// CPUsInNUMANodes NUMA节点上的逻辑cpu
+func (this CPUDetails) CPUsInNUMANodes(ids ...int) cpuset.CPUSet {
+	return this.filter(func(info CPUInfo) bool { return contains(ids, info.NUMANodeID) })
+}
+
+// CPUsInSockets socket上的逻辑cpu
+func (this CPUDetails) CPUsInSockets(ids ...int) cpuset.CPUSet {
+	return this.filter(func(info CPUInfo) bool { return contains(ids, info.SocketID) })
+}
+
+// CPUsInCores 物理核上的逻辑cpu
+func (this CPUDetails) CPUsInCores(ids ...int) cpuset.CPUSet {
+	return this.filter(func(info CPUInfo) bool { return contains(ids, info.CoreID) })
+}
+
+// CoresInSockets socket上的物理核
+func (this CPUDetails) CoresInSockets(ids ...int) cpuset.CPUSet {
+	cores := []int{}
+	for _, info := range this {
+		if contains(ids, info.SocketID) {
+			cores = append(cores, info.CoreID)
+		}
+	}
+	return cpuset.New(cores...)
+}
+
+func (this CPUDetails) filter(predicate func(info CPUInfo) bool) cpuset.CPUSet {
+	cpus := []int{}
+	for cpu, info := range this {
+		if predicate(info) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpuset.New(cpus...)
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover 从sysfs读取在线cpu的拓扑，sysCPUPath一般为/sys/devices/system/cpu，测试时可以指向构造的目录
+// 每个cpuN目录下topology/physical_package_id为socket，topology/core_id为socket内的核编号，nodeM子目录为所在的NUMA节点
+func Discover(sysCPUPath string) (*CPUTopology, error) {
+	data, err := os.ReadFile(filepath.Join(sysCPUPath, "online"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online cpus: %v", err)
+	}
+	online, err := cpuset.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse online cpus %q: %v", strings.TrimSpace(string(data)), err)
+	}
+
+	type coreKey struct {
+		socket int
+		core   int
+	}
+	details := CPUDetails{}
+	coreIDs := map[coreKey]int{}
+	for _, cpu := range online.List() {
+		cpuDir := filepath.Join(sysCPUPath, fmt.Sprintf("cpu%d", cpu))
+		socket, err := readInt(filepath.Join(cpuDir, "topology", "physical_package_id"))
+		if err != nil {
+			return nil, err
+		}
+		core, err := readInt(filepath.Join(cpuDir, "topology", "core_id"))
+		if err != nil {
+			return nil, err
+		}
+		numaNode, err := findNUMANode(cpuDir)
+		if err != nil {
+			return nil, err
+		}
+		// cpu按编号遍历，物理核用其上最小的逻辑cpu编号表示，保证在整个节点内唯一
+		key := coreKey{socket: socket, core: core}
+		if _, ok := coreIDs[key]; !ok {
+			coreIDs[key] = cpu
+		}
+		details[cpu] = CPUInfo{NUMANodeID: numaNode, SocketID: socket, CoreID: coreIDs[key]}
+	}
+	if len(details) == 0 {
+		return nil, fmt.Errorf("no online cpus found in %q", sysCPUPath)
+	}
+	return &CPUTopology{
+		NumCPUs:      len(details),
+		NumCores:     details.Cores().Size(),
+		NumSockets:   details.Sockets().Size(),
+		NumNUMANodes: details.NUMANodes().Size(),
+		CPUDetails:   details,
+	}, nil
+}
+
+// findNUMANode cpu目录下的nodeM子目录，没有NUMA信息时为0
+func findNUMANode(cpuDir string) (int, error) {
+	entries, err := os.ReadDir(cpuDir)
+	if err != nil {
+		return 0, err
+	}
+	nodes := []int{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "node") {
+			continue
+		}
+		if id, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "node")); err == nil {
+			nodes = append(nodes, id)
+		}
+	}
+	if len(nodes) == 0 {
+		return 0, nil
+	}
+	sort.Ints(nodes)
+	return nodes[0], nil
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	return value, nil
+}
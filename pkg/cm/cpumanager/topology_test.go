@@ -0,0 +1,114 @@
+package cpumanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixtureCPU sysfs中一个逻辑cpu的拓扑
+type fixtureCPU struct {
+	socket int
+	core   int
+	node   int
+}
+
+// writeSysCPU 在临时目录中构造/sys/devices/system/cpu，cpus的下标为逻辑cpu编号
+func writeSysCPU(t *testing.T, cpus []fixtureCPU) string {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "online"), fmt.Sprintf("0-%d\n", len(cpus)-1))
+	for i, cpu := range cpus {
+		cpuDir := filepath.Join(dir, fmt.Sprintf("cpu%d", i))
+		write(filepath.Join(cpuDir, "topology", "physical_package_id"), fmt.Sprintf("%d\n", cpu.socket))
+		write(filepath.Join(cpuDir, "topology", "core_id"), fmt.Sprintf("%d\n", cpu.core))
+		if err := os.MkdirAll(filepath.Join(cpuDir, fmt.Sprintf("node%d", cpu.node)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// 1个socket，4个物理核，没有超线程
+func singleSocketNoSMT() []fixtureCPU {
+	return []fixtureCPU{
+		{socket: 0, core: 0, node: 0},
+		{socket: 0, core: 1, node: 0},
+		{socket: 0, core: 2, node: 0},
+		{socket: 0, core: 3, node: 0},
+	}
+}
+
+// 2个socket，每个socket 2个物理核，每个核2个超线程，每个socket一个NUMA节点
+// 和常见的编号方式一样，cpu0-3是每个核的第一个超线程，cpu4-7是对应的第二个超线程
+func dualSocketHT() []fixtureCPU {
+	return []fixtureCPU{
+		{socket: 0, core: 0, node: 0},
+		{socket: 0, core: 1, node: 0},
+		{socket: 1, core: 0, node: 1},
+		{socket: 1, core: 1, node: 1},
+		{socket: 0, core: 0, node: 0},
+		{socket: 0, core: 1, node: 0},
+		{socket: 1, core: 0, node: 1},
+		{socket: 1, core: 1, node: 1},
+	}
+}
+
+func discover(t *testing.T, cpus []fixtureCPU) *CPUTopology {
+	topo, err := Discover(writeSysCPU(t, cpus))
+	if err != nil {
+		t.Fatalf("failed to discover topology: %v", err)
+	}
+	return topo
+}
+
+func TestDiscover(t *testing.T) {
+	topo := discover(t, dualSocketHT())
+	if topo.NumCPUs != 8 || topo.NumCores != 4 || topo.NumSockets != 2 || topo.NumNUMANodes != 2 {
+		t.Errorf("unexpected topology %+v", topo)
+	}
+	if topo.CPUsPerCore() != 2 || topo.CPUsPerSocket() != 4 {
+		t.Errorf("expected 2 cpus per core and 4 per socket, got %d and %d", topo.CPUsPerCore(), topo.CPUsPerSocket())
+	}
+	// core_id只在socket内唯一，物理核用其上最小的cpu编号表示
+	expected := CPUDetails{
+		0: {NUMANodeID: 0, SocketID: 0, CoreID: 0},
+		1: {NUMANodeID: 0, SocketID: 0, CoreID: 1},
+		2: {NUMANodeID: 1, SocketID: 1, CoreID: 2},
+		3: {NUMANodeID: 1, SocketID: 1, CoreID: 3},
+		4: {NUMANodeID: 0, SocketID: 0, CoreID: 0},
+		5: {NUMANodeID: 0, SocketID: 0, CoreID: 1},
+		6: {NUMANodeID: 1, SocketID: 1, CoreID: 2},
+		7: {NUMANodeID: 1, SocketID: 1, CoreID: 3},
+	}
+	if !reflect.DeepEqual(topo.CPUDetails, expected) {
+		t.Errorf("expected cpu details %v, got %v", expected, topo.CPUDetails)
+	}
+
+	topo = discover(t, singleSocketNoSMT())
+	if topo.NumCPUs != 4 || topo.NumCores != 4 || topo.NumSockets != 1 || topo.NumNUMANodes != 1 || topo.CPUsPerCore() != 1 {
+		t.Errorf("unexpected topology %+v", topo)
+	}
+}
+
+func TestDiscoverErrors(t *testing.T) {
+	if _, err := Discover(t.TempDir()); err == nil {
+		t.Errorf("expected error without online file")
+	}
+	dir := writeSysCPU(t, singleSocketNoSMT())
+	if err := os.Remove(filepath.Join(dir, "cpu2", "topology", "core_id")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Discover(dir); err == nil {
+		t.Errorf("expected error when core_id is missing")
+	}
+}
@@ -0,0 +1,86 @@
+package devicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"os"
+	"path/filepath"
+)
+
+// PodDevicesEntry 容器分配的一种资源的设备，以及插件Allocate的返回
+// pkg/kubelet/cm/devicemanager/checkpoint/checkpoint.go
+type PodDevicesEntry struct {
+	PodUID        string                               `json:"podUID"`
+	ContainerName string                               `json:"containerName"`
+	ResourceName  string                               `json:"resourceName"`
+	DeviceIDs     []string                             `json:"deviceIDs"`
+	AllocResp     *pluginapi.ContainerAllocateResponse `json:"allocResp,omitempty"`
+}
+
+// deviceManagerCheckpoint 检查点文件的内容，checksum为其余字段的哈希，用于发现文件损坏
+type deviceManagerCheckpoint struct {
+	PodDeviceEntries []PodDevicesEntry `json:"podDeviceEntries,omitempty"`
+	// 资源名 -> 注册过的设备，kubelet重启后插件重新注册前，这些资源仍然保留在节点上
+	RegisteredDevices map[string][]string `json:"registeredDevices,omitempty"`
+	Checksum          uint64              `json:"checksum"`
+}
+
+func (this *deviceManagerCheckpoint) computeChecksum() (uint64, error) {
+	copied := *this
+	copied.Checksum = 0
+	data, err := json.Marshal(copied)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64(), nil
+}
+
+// readCheckpoint 检查点文件不存在时返回空
+func readCheckpoint(checkpointPath string) (*deviceManagerCheckpoint, error) {
+	checkpoint := &deviceManagerCheckpoint{}
+	data, err := os.ReadFile(checkpointPath)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint is corrupted: %v", err)
+	}
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return nil, err
+	}
+	if checksum != checkpoint.Checksum {
+		return nil, fmt.Errorf("checkpoint is corrupted: checksum mismatch")
+	}
+	klog.V(2).InfoS("State checkpoint: restored device manager state from checkpoint", "path", checkpointPath)
+	return checkpoint, nil
+}
+
+// writeCheckpoint 先写临时文件再重命名，避免写入过程中重启导致文件损坏
+func writeCheckpoint(checkpointPath string, checkpoint *deviceManagerCheckpoint) error {
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return err
+	}
+	checkpoint.Checksum = checksum
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(checkpointPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := checkpointPath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, checkpointPath)
+}
@@ -0,0 +1,111 @@
+package devicemanager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"sync"
+	"time"
+)
+
+// 连接设备插件socket的超时时间
+const dialTimeout = 10 * time.Second
+
+// endpoint 到一个设备插件的连接，一个插件只提供一种资源
+// pkg/kubelet/cm/devicemanager/endpoint.go
+type endpoint struct {
+	resourceName string
+	socketPath   string
+	options      *pluginapi.DevicePluginOptions
+	client       pluginapi.DevicePluginClient
+	conn         *grpc.ClientConn
+
+	mutex sync.Mutex
+	// ListAndWatch结束的时间，为零表示插件还在运行
+	stopTime time.Time
+}
+
+// newEndpoint 连接插件的socket，插件在注册前需要已经开始监听
+func newEndpoint(socketPath, resourceName string, options *pluginapi.DevicePluginOptions) (*endpoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial device plugin %s at %s: %v", resourceName, socketPath, err)
+	}
+	if options == nil {
+		options = &pluginapi.DevicePluginOptions{}
+	}
+	return &endpoint{
+		resourceName: resourceName,
+		socketPath:   socketPath,
+		options:      options,
+		client:       pluginapi.NewDevicePluginClient(conn),
+		conn:         conn,
+	}, nil
+}
+
+// run 接收ListAndWatch推送的设备列表，交给callback更新，连接断开后返回
+func (this *endpoint) run(callback func(resourceName string, devices []*pluginapi.Device)) {
+	stream, err := this.client.ListAndWatch(context.Background(), &pluginapi.Empty{})
+	if err != nil {
+		klog.ErrorS(err, "ListAndWatch ended unexpectedly for device plugin", "resourceName", this.resourceName)
+		return
+	}
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			klog.ErrorS(err, "ListAndWatch ended unexpectedly for device plugin", "resourceName", this.resourceName)
+			return
+		}
+		klog.V(2).InfoS("State pushed for device plugin", "resourceName", this.resourceName, "resourceCapacity", len(response.Devices))
+		callback(this.resourceName, response.Devices)
+	}
+}
+
+// isStopped 插件已经停止
+func (this *endpoint) isStopped() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return !this.stopTime.IsZero()
+}
+
+// stopGracePeriodExpired 到now为止插件停止超过宽限期，其资源可以从节点上删除
+func (this *endpoint) stopGracePeriodExpired(now time.Time) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return !this.stopTime.IsZero() && now.Sub(this.stopTime) > endpointStopGracePeriod
+}
+
+// setStopTime 标记插件已经停止，并关闭连接
+func (this *endpoint) setStopTime(t time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.stopTime = t
+	if this.conn != nil {
+		this.conn.Close()
+	}
+}
+
+// allocate 调用插件的Allocate，返回容器需要的环境变量、挂载和设备
+func (this *endpoint) allocate(devs []string) (*pluginapi.AllocateResponse, error) {
+	if this.isStopped() {
+		return nil, fmt.Errorf(errEndpointStopped, this.resourceName)
+	}
+	return this.client.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: devs}},
+	})
+}
+
+// preStartContainer 插件要求时，在容器启动前初始化设备
+func (this *endpoint) preStartContainer(devs []string) (*pluginapi.PreStartContainerResponse, error) {
+	if this.isStopped() {
+		return nil, fmt.Errorf(errEndpointStopped, this.resourceName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginapi.KubeletPreStartContainerRPCTimeoutInSecs*time.Second)
+	defer cancel()
+	return this.client.PreStartContainer(ctx, &pluginapi.PreStartContainerRequest{DevicesIDs: devs})
+}
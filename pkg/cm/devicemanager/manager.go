@@ -0,0 +1,669 @@
+package devicemanager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/cm/topologymanager"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 设备管理器检查点文件的名字，和插件的socket在同一个目录下
+	kubeletDeviceManagerCheckpoint = "kubelet_internal_checkpoint"
+	// 插件停止后，其设备在节点上保留的时间，超过后从节点的容量中删除
+	endpointStopGracePeriod = 5 * time.Minute
+
+	errEndpointStopped = "endpoint %v has been stopped"
+)
+
+// ActivePodsFunc 节点上运行中的pod
+type ActivePodsFunc func() []*v1.Pod
+
+// Manager 设备插件通过kubelet.sock注册，设备管理器连接插件的socket，
+// 通过ListAndWatch跟踪设备的健康状态并作为扩展资源上报到节点，准入时按资源请求分配设备，创建容器前把Allocate的结果注入容器
+// pkg/kubelet/cm/devicemanager/manager.go
+type Manager struct {
+	socketDir      string
+	socketPath     string
+	checkpointPath string
+	server         *grpc.Server
+	wg             sync.WaitGroup
+
+	mutex sync.Mutex
+	// 资源名 -> 插件
+	endpoints map[string]*endpoint
+	// 资源名 -> 设备ID -> 设备
+	healthyDevices   map[string]map[string]*pluginapi.Device
+	unhealthyDevices map[string]map[string]*pluginapi.Device
+	// 资源名 -> 已经分配的设备ID
+	allocatedDevices map[string]map[string]bool
+	podDevices       *podDevices
+	// pod uid -> 资源名 -> init容器退出后可以给后面容器复用的设备
+	devicesToReuse map[string]map[string]map[string]bool
+
+	numaNodes  []int
+	affinity   topologymanager.Store
+	activePods ActivePodsFunc
+	// 插件停止时间和停止宽限期的计时，测试时可以替换
+	clock clock.Clock
+}
+
+// NewManager socketDir为插件和kubelet.sock所在的目录，numaNodes为节点上的所有NUMA节点
+func NewManager(socketDir string, numaNodes []int, affinity topologymanager.Store) (*Manager, error) {
+	if socketDir == "" {
+		return nil, fmt.Errorf("device plugin socket directory is empty")
+	}
+	return &Manager{
+		socketDir:        socketDir,
+		socketPath:       filepath.Join(socketDir, filepath.Base(pluginapi.KubeletSocket)),
+		checkpointPath:   filepath.Join(socketDir, kubeletDeviceManagerCheckpoint),
+		endpoints:        map[string]*endpoint{},
+		healthyDevices:   map[string]map[string]*pluginapi.Device{},
+		unhealthyDevices: map[string]map[string]*pluginapi.Device{},
+		allocatedDevices: map[string]map[string]bool{},
+		podDevices:       newPodDevices(),
+		devicesToReuse:   map[string]map[string]map[string]bool{},
+		numaNodes:        numaNodes,
+		affinity:         affinity,
+		clock:            clock.RealClock{},
+	}, nil
+}
+
+// Start 从检查点恢复分配，清理上次运行留下的socket，然后在kubelet.sock上启动注册服务
+func (this *Manager) Start(activePods ActivePodsFunc) error {
+	klog.InfoS("Starting device plugin manager", "socketPath", this.socketPath)
+	this.activePods = activePods
+	if err := this.readCheckpoint(); err != nil {
+		klog.ErrorS(err, "Continue after failing to read checkpoint file. Device allocation info may NOT be up-to-date")
+	}
+	if err := os.MkdirAll(this.socketDir, 0750); err != nil {
+		return fmt.Errorf("failed to create device plugin directory %s: %v", this.socketDir, err)
+	}
+	// 插件会监听目录，kubelet.sock重新创建后重新注册
+	if err := this.removeContents(); err != nil {
+		klog.ErrorS(err, "Fail to clean up stale content under device plugin directory", "dir", this.socketDir)
+	}
+	listener, err := net.Listen("unix", this.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on device plugin registration socket %s: %v", this.socketPath, err)
+	}
+	this.server = grpc.NewServer()
+	pluginapi.RegisterRegistrationServer(this.server, this)
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		if err := this.server.Serve(listener); err != nil {
+			klog.ErrorS(err, "Device plugin registration server stopped serving")
+		}
+	}()
+	return nil
+}
+
+// Stop 停止注册服务并断开所有插件
+func (this *Manager) Stop() error {
+	this.mutex.Lock()
+	for _, e := range this.endpoints {
+		if !e.isStopped() {
+			e.setStopTime(this.clock.Now())
+		}
+	}
+	this.mutex.Unlock()
+	if this.server == nil {
+		return nil
+	}
+	this.server.Stop()
+	this.wg.Wait()
+	this.server = nil
+	return nil
+}
+
+// removeContents 删除目录下除检查点外的文件
+func (this *Manager) removeContents() error {
+	entries, err := os.ReadDir(this.socketDir)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, entry := range entries {
+		if entry.Name() == kubeletDeviceManagerCheckpoint {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(this.socketDir, entry.Name())); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Register 插件的注册请求，校验后异步连接插件的socket
+func (this *Manager) Register(ctx context.Context, r *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	klog.InfoS("Got registration request from device plugin with resource", "resourceName", r.ResourceName)
+	if !isSupportedVersion(r.Version) {
+		err := fmt.Errorf("invalid version %q, supported versions: %v", r.Version, pluginapi.SupportedVersions)
+		klog.ErrorS(err, "Bad registration request from device plugin", "resourceName", r.ResourceName)
+		return &pluginapi.Empty{}, err
+	}
+	if !isExtendedResourceName(v1.ResourceName(r.ResourceName)) {
+		err := fmt.Errorf("invalid extended resource name %q", r.ResourceName)
+		klog.ErrorS(err, "Bad registration request from device plugin")
+		return &pluginapi.Empty{}, err
+	}
+	if r.Endpoint == "" || filepath.Base(r.Endpoint) != r.Endpoint {
+		err := fmt.Errorf("invalid endpoint %q, must be a socket file name under %s", r.Endpoint, this.socketDir)
+		klog.ErrorS(err, "Bad registration request from device plugin", "resourceName", r.ResourceName)
+		return &pluginapi.Empty{}, err
+	}
+	go this.addEndpoint(r)
+	return &pluginapi.Empty{}, nil
+}
+
+func isSupportedVersion(version string) bool {
+	for _, v := range pluginapi.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// isExtendedResourceName 带域名前缀且不在kubernetes.io下的资源，如example.com/gpu
+func isExtendedResourceName(name v1.ResourceName) bool {
+	s := string(name)
+	if !strings.Contains(s, "/") || strings.HasPrefix(s, v1.ResourceDefaultNamespacePrefix) {
+		return false
+	}
+	// 配额使用requests.前缀，不能作为资源名
+	if strings.HasPrefix(s, v1.DefaultResourceRequestsPrefix) {
+		return false
+	}
+	return len(validation.IsQualifiedName(s)) == 0
+}
+
+// addEndpoint 连接插件，替换同名资源的旧插件，ListAndWatch断开后插件的设备都变为不健康
+func (this *Manager) addEndpoint(r *pluginapi.RegisterRequest) {
+	e, err := newEndpoint(filepath.Join(this.socketDir, r.Endpoint), r.ResourceName, r.Options)
+	if err != nil {
+		klog.ErrorS(err, "Failed to dial device plugin", "resourceName", r.ResourceName)
+		return
+	}
+	this.mutex.Lock()
+	if old, ok := this.endpoints[r.ResourceName]; ok && !old.isStopped() {
+		old.setStopTime(this.clock.Now())
+	}
+	this.endpoints[r.ResourceName] = e
+	this.mutex.Unlock()
+	klog.V(2).InfoS("Registered endpoint", "resourceName", r.ResourceName, "endpoint", r.Endpoint)
+
+	e.run(this.updateDevices)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	// 已经被新注册的插件替换，或者设备管理器已经停止时不再修改设备，
+	// 避免Stop之后把注册过的设备从检查点中删除
+	if this.endpoints[r.ResourceName] != e || e.isStopped() {
+		return
+	}
+	e.setStopTime(this.clock.Now())
+	for id, dev := range this.healthyDevices[r.ResourceName] {
+		if this.unhealthyDevices[r.ResourceName] == nil {
+			this.unhealthyDevices[r.ResourceName] = map[string]*pluginapi.Device{}
+		}
+		this.unhealthyDevices[r.ResourceName][id] = dev
+	}
+	delete(this.healthyDevices, r.ResourceName)
+	klog.InfoS("Endpoint became unhealthy", "resourceName", r.ResourceName, "endpoint", r.Endpoint)
+	this.writeCheckpoint()
+}
+
+// updateDevices ListAndWatch每次推送插件的完整设备列表
+func (this *Manager) updateDevices(resourceName string, devices []*pluginapi.Device) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	healthy := map[string]*pluginapi.Device{}
+	unhealthy := map[string]*pluginapi.Device{}
+	for _, dev := range devices {
+		if dev.Health == pluginapi.Healthy {
+			healthy[dev.ID] = dev
+		} else {
+			unhealthy[dev.ID] = dev
+		}
+	}
+	this.healthyDevices[resourceName] = healthy
+	this.unhealthyDevices[resourceName] = unhealthy
+	this.writeCheckpoint()
+}
+
+// GetCapacity 节点上每种扩展资源的容量和可分配数量，不健康的设备只计入容量；
+// 插件停止超过宽限期的资源从节点上删除，返回在removed中
+func (this *Manager) GetCapacity() (capacity v1.ResourceList, allocatable v1.ResourceList, removed []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	capacity = v1.ResourceList{}
+	allocatable = v1.ResourceList{}
+	needsUpdateCheckpoint := false
+	resourceNames := map[string]bool{}
+	for resourceName := range this.healthyDevices {
+		resourceNames[resourceName] = true
+	}
+	for resourceName := range this.unhealthyDevices {
+		resourceNames[resourceName] = true
+	}
+	for resourceName := range resourceNames {
+		e, ok := this.endpoints[resourceName]
+		if !ok || e.stopGracePeriodExpired(this.clock.Now()) {
+			delete(this.endpoints, resourceName)
+			delete(this.healthyDevices, resourceName)
+			delete(this.unhealthyDevices, resourceName)
+			removed = append(removed, resourceName)
+			needsUpdateCheckpoint = true
+			continue
+		}
+		healthy := int64(len(this.healthyDevices[resourceName]))
+		capacity[v1.ResourceName(resourceName)] = *resource.NewQuantity(healthy+int64(len(this.unhealthyDevices[resourceName])), resource.DecimalSI)
+		allocatable[v1.ResourceName(resourceName)] = *resource.NewQuantity(healthy, resource.DecimalSI)
+	}
+	if needsUpdateCheckpoint {
+		this.writeCheckpoint()
+	}
+	sort.Strings(removed)
+	return capacity, allocatable, removed
+}
+
+// isDevicePluginResource 有插件注册过的资源
+func (this *Manager) isDevicePluginResource(resourceName string) bool {
+	_, registered := this.endpoints[resourceName]
+	_, healthy := this.healthyDevices[resourceName]
+	return registered || healthy
+}
+
+// GetTopologyHints 准入时由拓扑管理器调用，设备没有NUMA信息的资源不关心NUMA
+func (this *Manager) GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	this.updateAllocatedDevices()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	hints := map[string][]topologymanager.TopologyHint{}
+	podUID := string(pod.UID)
+	for name, quantity := range container.Resources.Limits {
+		resourceName := string(name)
+		if !this.isDevicePluginResource(resourceName) || !this.deviceHasTopologyAlignment(resourceName) {
+			continue
+		}
+		request := int(quantity.Value())
+		// 已经分配过时只能使用分配的设备
+		if allocated := this.podDevices.containerDevices(podUID, container.Name, resourceName); len(allocated) > 0 {
+			if len(allocated) != request {
+				klog.InfoS("Resource already allocated to pod with different number than request", "pod", klog.KObj(pod),
+					"containerName", container.Name, "resourceName", resourceName, "request", request, "allocated", len(allocated))
+				hints[resourceName] = []topologymanager.TopologyHint{}
+				continue
+			}
+			hints[resourceName] = this.generateDeviceTopologyHints(resourceName, toSet(allocated), nil, request)
+			continue
+		}
+		available := this.availableDevices(resourceName)
+		reusable := this.devicesToReuse[podUID][resourceName]
+		if len(available)+len(reusable) < request {
+			klog.InfoS("Unable to generate topology hints: requested number of devices unavailable", "resourceName", resourceName,
+				"request", request, "available", len(available)+len(reusable))
+			hints[resourceName] = []topologymanager.TopologyHint{}
+			continue
+		}
+		hints[resourceName] = this.generateDeviceTopologyHints(resourceName, available, reusable, request)
+	}
+	return hints
+}
+
+// deviceHasTopologyAlignment 资源的任一设备有NUMA信息
+func (this *Manager) deviceHasTopologyAlignment(resourceName string) bool {
+	for _, dev := range this.healthyDevices[resourceName] {
+		if dev.Topology != nil && len(dev.Topology.Nodes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// generateDeviceTopologyHints 能容纳请求的NUMA节点组合，节点数最少的组合为preferred
+func (this *Manager) generateDeviceTopologyHints(resourceName string, available map[string]bool, reusable map[string]bool,
+	request int) []topologymanager.TopologyHint {
+	minAffinitySize := len(this.numaNodes)
+	hints := []topologymanager.TopologyHint{}
+	topologymanager.IterateBitMasks(this.numaNodes, func(mask topologymanager.BitMask) {
+		devicesInMask := 0
+		for _, dev := range this.healthyDevices[resourceName] {
+			if deviceInMask(dev, mask) {
+				devicesInMask++
+			}
+		}
+		if devicesInMask >= request && mask.Count() < minAffinitySize {
+			minAffinitySize = mask.Count()
+		}
+		numMatching := 0
+		for id := range available {
+			if dev, ok := this.healthyDevices[resourceName][id]; ok && deviceInMask(dev, mask) {
+				numMatching++
+			}
+		}
+		for id := range reusable {
+			if dev, ok := this.healthyDevices[resourceName][id]; ok && !available[id] && deviceInMask(dev, mask) {
+				numMatching++
+			}
+		}
+		if numMatching < request {
+			return
+		}
+		hints = append(hints, topologymanager.TopologyHint{NUMANodeAffinity: mask})
+	})
+	for i := range hints {
+		if hints[i].NUMANodeAffinity.Count() == minAffinitySize {
+			hints[i].Preferred = true
+		}
+	}
+	return hints
+}
+
+// deviceInMask 设备所在的NUMA节点都在mask中
+func deviceInMask(dev *pluginapi.Device, mask topologymanager.BitMask) bool {
+	if dev.Topology == nil || len(dev.Topology.Nodes) == 0 {
+		return false
+	}
+	for _, node := range dev.Topology.Nodes {
+		if !mask.IsSet(int(node.ID)) {
+			return false
+		}
+	}
+	return true
+}
+
+// availableDevices 健康且没有分配的设备
+func (this *Manager) availableDevices(resourceName string) map[string]bool {
+	ret := map[string]bool{}
+	for id := range this.healthyDevices[resourceName] {
+		if !this.allocatedDevices[resourceName][id] {
+			ret[id] = true
+		}
+	}
+	return ret
+}
+
+// Allocate 为容器请求的每种扩展资源分配设备并调用插件的Allocate，结果写入检查点
+func (this *Manager) Allocate(pod *v1.Pod, container *v1.Container) error {
+	this.updateAllocatedDevices()
+	podUID := string(pod.UID)
+	for name, quantity := range container.Resources.Limits {
+		resourceName := string(name)
+		this.mutex.Lock()
+		isDevicePluginResource := this.isDevicePluginResource(resourceName)
+		this.mutex.Unlock()
+		if !isDevicePluginResource {
+			continue
+		}
+		if err := this.allocateResource(pod, container, resourceName, int(quantity.Value())); err != nil {
+			return err
+		}
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	// 普通init容器按顺序运行，退出后设备可以给后面的容器使用；业务容器同时运行，设备不能再复用
+	if isInitContainer(pod, container) {
+		this.addContainerDevicesToReuse(podUID, container.Name)
+	} else {
+		this.removeContainerDevicesFromReuse(podUID, container.Name)
+	}
+	this.writeCheckpoint()
+	return nil
+}
+
+func (this *Manager) allocateResource(pod *v1.Pod, container *v1.Container, resourceName string, needed int) error {
+	podUID := string(pod.UID)
+	this.mutex.Lock()
+	if len(this.podDevices.containerDevices(podUID, container.Name, resourceName)) > 0 {
+		this.mutex.Unlock()
+		return nil
+	}
+	devs, err := this.devicesToAllocate(podUID, container.Name, resourceName, needed)
+	if err != nil {
+		this.mutex.Unlock()
+		return err
+	}
+	e, ok := this.endpoints[resourceName]
+	this.mutex.Unlock()
+	if !ok {
+		this.releaseDevices(resourceName, devs)
+		return fmt.Errorf("unknown device plugin resource %s", resourceName)
+	}
+
+	if e.options.PreStartRequired {
+		klog.V(4).InfoS("Issuing a PreStartContainer call for container", "containerName", container.Name, "pod", klog.KObj(pod))
+		if _, err = e.preStartContainer(devs); err != nil {
+			this.releaseDevices(resourceName, devs)
+			return fmt.Errorf("device plugin PreStartContainer rpc failed with err: %v", err)
+		}
+	}
+	klog.V(3).InfoS("Making allocation request for device plugin", "devices", devs, "resourceName", resourceName)
+	resp, err := e.allocate(devs)
+	if err == nil && len(resp.ContainerResponses) == 0 {
+		err = fmt.Errorf("no containers return in allocation response %v", resp)
+	}
+	if err != nil {
+		this.releaseDevices(resourceName, devs)
+		return err
+	}
+	this.podDevices.insert(podUID, container.Name, resourceName, devs, resp.ContainerResponses[0])
+	return nil
+}
+
+// devicesToAllocate 先复用init容器的设备，再优先选择在容器NUMA亲和节点上的设备，选中的设备记为已分配
+func (this *Manager) devicesToAllocate(podUID, containerName, resourceName string, needed int) ([]string, error) {
+	allocated := []string{}
+	reusable := sortedKeys(this.devicesToReuse[podUID][resourceName])
+	for _, id := range reusable {
+		if len(allocated) == needed {
+			break
+		}
+		if _, ok := this.healthyDevices[resourceName][id]; ok {
+			allocated = append(allocated, id)
+		}
+	}
+	if len(allocated) < needed {
+		available := sortedKeys(this.availableDevices(resourceName))
+		if len(available) < needed-len(allocated) {
+			return nil, fmt.Errorf("requested number of devices unavailable for %s. Requested: %d, Available: %d",
+				resourceName, needed, len(available)+len(allocated))
+		}
+		hint := this.affinity.GetAffinity(podUID, containerName)
+		if !hint.NUMANodeAffinity.IsEmpty() {
+			aligned := []string{}
+			unaligned := []string{}
+			for _, id := range available {
+				if deviceInMask(this.healthyDevices[resourceName][id], hint.NUMANodeAffinity) {
+					aligned = append(aligned, id)
+				} else {
+					unaligned = append(unaligned, id)
+				}
+			}
+			available = append(aligned, unaligned...)
+		}
+		allocated = append(allocated, available[:needed-len(allocated)]...)
+	}
+	if this.allocatedDevices[resourceName] == nil {
+		this.allocatedDevices[resourceName] = map[string]bool{}
+	}
+	for _, id := range allocated {
+		this.allocatedDevices[resourceName][id] = true
+	}
+	return allocated, nil
+}
+
+// releaseDevices Allocate失败时归还选中的设备，复用的设备仍然属于init容器
+func (this *Manager) releaseDevices(resourceName string, devs []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	inUse := this.podDevices.devices()[resourceName]
+	for _, id := range devs {
+		if !inUse[id] {
+			delete(this.allocatedDevices[resourceName], id)
+		}
+	}
+}
+
+func (this *Manager) addContainerDevicesToReuse(podUID, containerName string) {
+	this.resetDevicesToReuse(podUID)
+	for resourceName := range this.healthyDevices {
+		for _, id := range this.podDevices.containerDevices(podUID, containerName, resourceName) {
+			if this.devicesToReuse[podUID][resourceName] == nil {
+				this.devicesToReuse[podUID][resourceName] = map[string]bool{}
+			}
+			this.devicesToReuse[podUID][resourceName][id] = true
+		}
+	}
+}
+
+func (this *Manager) removeContainerDevicesFromReuse(podUID, containerName string) {
+	this.resetDevicesToReuse(podUID)
+	for resourceName, devs := range this.devicesToReuse[podUID] {
+		for _, id := range this.podDevices.containerDevices(podUID, containerName, resourceName) {
+			delete(devs, id)
+		}
+	}
+}
+
+// resetDevicesToReuse 只保留正在准入的pod的记录
+func (this *Manager) resetDevicesToReuse(podUID string) {
+	for uid := range this.devicesToReuse {
+		if uid != podUID {
+			delete(this.devicesToReuse, uid)
+		}
+	}
+	if this.devicesToReuse[podUID] == nil {
+		this.devicesToReuse[podUID] = map[string]map[string]bool{}
+	}
+}
+
+func isInitContainer(pod *v1.Pod, container *v1.Container) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == container.Name {
+			// sidecar容器和业务容器一起运行
+			return c.RestartPolicy == nil || *c.RestartPolicy != v1.ContainerRestartPolicyAlways
+		}
+	}
+	return false
+}
+
+// RemoveContainer pod被拒绝时归还容器的设备
+func (this *Manager) RemoveContainer(podUID types.UID, containerName string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if !this.podDevices.hasPod(string(podUID)) {
+		return nil
+	}
+	this.podDevices.deleteContainer(string(podUID), containerName)
+	this.allocatedDevices = this.podDevices.devices()
+	this.writeCheckpoint()
+	return nil
+}
+
+// updateAllocatedDevices 已经不在运行的pod归还设备
+func (this *Manager) updateAllocatedDevices() {
+	if this.activePods == nil {
+		return
+	}
+	activePods := this.activePods()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	podsToBeRemoved := this.podDevices.pods()
+	for _, pod := range activePods {
+		delete(podsToBeRemoved, string(pod.UID))
+	}
+	if len(podsToBeRemoved) == 0 {
+		return
+	}
+	podUIDs := sortedKeys(podsToBeRemoved)
+	klog.V(3).InfoS("Pods to be removed", "podUIDs", podUIDs)
+	this.podDevices.delete(podUIDs)
+	this.allocatedDevices = this.podDevices.devices()
+	this.writeCheckpoint()
+}
+
+// GetDeviceRunContainerOptions 创建容器时需要注入的环境变量、挂载、设备和注解，容器没有分配设备时返回nil
+func (this *Manager) GetDeviceRunContainerOptions(pod *v1.Pod, container *v1.Container) *DeviceRunContainerOptions {
+	return this.podDevices.deviceRunContainerOptions(string(pod.UID), container.Name)
+}
+
+// writeCheckpoint 调用方持有锁
+func (this *Manager) writeCheckpoint() {
+	registeredDevices := map[string][]string{}
+	for resourceName, devs := range this.healthyDevices {
+		registeredDevices[resourceName] = sortedKeys(toDeviceSet(devs))
+	}
+	checkpoint := &deviceManagerCheckpoint{
+		PodDeviceEntries:  this.podDevices.toCheckpointData(),
+		RegisteredDevices: registeredDevices,
+	}
+	if err := writeCheckpoint(this.checkpointPath, checkpoint); err != nil {
+		klog.ErrorS(err, "Failed to write device manager checkpoint", "path", this.checkpointPath)
+	}
+}
+
+// readCheckpoint 恢复分配记录，注册过的资源在插件重新注册前作为已停止的插件保留，宽限期后删除
+func (this *Manager) readCheckpoint() error {
+	checkpoint, err := readCheckpoint(this.checkpointPath)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.podDevices.fromCheckpointData(checkpoint.PodDeviceEntries)
+	this.allocatedDevices = this.podDevices.devices()
+	for resourceName, ids := range checkpoint.RegisteredDevices {
+		devs := map[string]*pluginapi.Device{}
+		for _, id := range ids {
+			devs[id] = &pluginapi.Device{ID: id, Health: pluginapi.Healthy}
+		}
+		this.healthyDevices[resourceName] = devs
+		this.endpoints[resourceName] = &endpoint{resourceName: resourceName, options: &pluginapi.DevicePluginOptions{}, stopTime: this.clock.Now()}
+	}
+	return nil
+}
+
+func toSet(ids []string) map[string]bool {
+	ret := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		ret[id] = true
+	}
+	return ret
+}
+
+func toDeviceSet(devs map[string]*pluginapi.Device) map[string]bool {
+	ret := make(map[string]bool, len(devs))
+	for id := range devs {
+		ret[id] = true
+	}
+	return ret
+}
+
+func sortedKeys(set map[string]bool) []string {
+	ret := make([]string, 0, len(set))
+	for k := range set {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,555 @@
+package devicemanager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/cm/topologymanager"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testResourceName = "example.com/gpu"
+
+// fakeDevicePlugin 进程内的设备插件，通过devicesCh推送新的设备列表
+type fakeDevicePlugin struct {
+	pluginapi.UnimplementedDevicePluginServer
+
+	resourceName string
+	endpoint     string
+	options      *pluginapi.DevicePluginOptions
+	server       *grpc.Server
+	devicesCh    chan []*pluginapi.Device
+	stopCh       chan struct{}
+
+	lock            sync.Mutex
+	devices         []*pluginapi.Device
+	allocateCalls   [][]string
+	preStartCalls   [][]string
+	allocateErr     error
+	preStartErr     error
+	stopOnce        sync.Once
+	listAndWatching bool
+}
+
+func newFakeDevicePlugin(endpoint string, devices ...*pluginapi.Device) *fakeDevicePlugin {
+	return &fakeDevicePlugin{
+		resourceName: testResourceName,
+		endpoint:     endpoint,
+		options:      &pluginapi.DevicePluginOptions{},
+		devices:      devices,
+		devicesCh:    make(chan []*pluginapi.Device, 10),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+func device(id string, health string) *pluginapi.Device {
+	return &pluginapi.Device{ID: id, Health: health}
+}
+
+// start 在socketDir中监听并向设备管理器注册
+func (this *fakeDevicePlugin) start(t *testing.T, manager *Manager) {
+	listener, err := net.Listen("unix", filepath.Join(manager.socketDir, this.endpoint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	this.server = grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(this.server, this)
+	go this.server.Serve(listener)
+	t.Cleanup(this.stop)
+
+	if err := register(manager, &pluginapi.RegisterRequest{
+		Version:      pluginapi.Version,
+		Endpoint:     this.endpoint,
+		ResourceName: this.resourceName,
+		Options:      this.options,
+	}); err != nil {
+		t.Fatalf("failed to register device plugin: %v", err)
+	}
+}
+
+func (this *fakeDevicePlugin) stop() {
+	this.stopOnce.Do(func() {
+		close(this.stopCh)
+		this.server.Stop()
+	})
+}
+
+// register 通过kubelet.sock注册
+func register(manager *Manager, request *pluginapi.RegisterRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+manager.socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = pluginapi.NewRegistrationClient(conn).Register(ctx, request)
+	return err
+}
+
+func (this *fakeDevicePlugin) GetDevicePluginOptions(_ context.Context, _ *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return this.options, nil
+}
+
+func (this *fakeDevicePlugin) ListAndWatch(_ *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) error {
+	this.lock.Lock()
+	devices := this.devices
+	this.listAndWatching = true
+	this.lock.Unlock()
+	if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: devices}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case devices := <-this.devicesCh:
+			if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: devices}); err != nil {
+				return err
+			}
+		case <-this.stopCh:
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (this *fakeDevicePlugin) Allocate(_ context.Context, req *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	resp := &pluginapi.AllocateResponse{}
+	for _, request := range req.ContainerRequests {
+		this.allocateCalls = append(this.allocateCalls, request.DevicesIDs)
+		if this.allocateErr != nil {
+			return nil, this.allocateErr
+		}
+		containerResp := &pluginapi.ContainerAllocateResponse{
+			Envs:        map[string]string{"VISIBLE_DEVICES": fmt.Sprint(request.DevicesIDs)},
+			Annotations: map[string]string{"example.com/gpu": "allocated"},
+		}
+		for _, id := range request.DevicesIDs {
+			containerResp.Devices = append(containerResp.Devices, &pluginapi.DeviceSpec{
+				HostPath:      "/dev/" + id,
+				ContainerPath: "/dev/" + id,
+				Permissions:   "rw",
+			})
+		}
+		resp.ContainerResponses = append(resp.ContainerResponses, containerResp)
+	}
+	return resp, nil
+}
+
+func (this *fakeDevicePlugin) PreStartContainer(_ context.Context, req *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.preStartCalls = append(this.preStartCalls, req.DevicesIDs)
+	if this.preStartErr != nil {
+		return nil, this.preStartErr
+	}
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
+
+func (this *fakeDevicePlugin) getAllocateCalls() [][]string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([][]string{}, this.allocateCalls...)
+}
+
+// fakeAffinity 所有容器都没有NUMA亲和
+type fakeAffinity struct{}
+
+func (this fakeAffinity) GetAffinity(_ string, _ string) topologymanager.TopologyHint {
+	return topologymanager.TopologyHint{}
+}
+
+type testManager struct {
+	*Manager
+	clock      *testingclock.FakeClock
+	lock       sync.Mutex
+	activePods []*v1.Pod
+}
+
+// newTestManager 在临时目录中启动设备管理器，dir为空时新建目录
+func newTestManager(t *testing.T, dir string, fakeClock *testingclock.FakeClock) *testManager {
+	if dir == "" {
+		// unix socket路径长度有限，不使用t.TempDir()
+		var err error
+		if dir, err = os.MkdirTemp("", "dp"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(dir)
+		})
+	}
+	manager, err := NewManager(dir, []int{0}, fakeAffinity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.clock = fakeClock
+	testManager := &testManager{Manager: manager, clock: fakeClock}
+	if err := manager.Start(testManager.getActivePods); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		manager.Stop()
+	})
+	return testManager
+}
+
+func (this *testManager) getActivePods() []*v1.Pod {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*v1.Pod{}, this.activePods...)
+}
+
+func (this *testManager) setActivePods(pods ...*v1.Pod) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.activePods = pods
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// capacityIs 资源的容量和可分配数量
+func (this *testManager) capacityIs(capacity, allocatable int64) func() bool {
+	return func() bool {
+		c, a, _ := this.GetCapacity()
+		cq, ok := c[testResourceName]
+		if !ok {
+			return false
+		}
+		aq := a[testResourceName]
+		return cq.Value() == capacity && aq.Value() == allocatable
+	}
+}
+
+func gpuContainer(name string, count int64) v1.Container {
+	return v1.Container{
+		Name: name,
+		Resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{testResourceName: *resource.NewQuantity(count, resource.DecimalSI)},
+		},
+	}
+}
+
+func newPod(uid types.UID, initContainers []v1.Container, containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-" + string(uid), Namespace: "default", UID: uid},
+		Spec:       v1.PodSpec{InitContainers: initContainers, Containers: containers},
+	}
+}
+
+func TestRegisterAndHealthUpdates(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy))
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(2, 2))
+
+	// 不健康的设备只计入容量
+	plugin.devicesCh <- []*pluginapi.Device{device("dev-1", pluginapi.Unhealthy), device("dev-2", pluginapi.Healthy)}
+	waitFor(t, "device to become unhealthy", manager.capacityIs(2, 1))
+
+	plugin.devicesCh <- []*pluginapi.Device{device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy),
+		device("dev-3", pluginapi.Healthy)}
+	waitFor(t, "device to recover and a new device to be added", manager.capacityIs(3, 3))
+
+	// 插件停止后设备都变为不健康，宽限期内资源保留在节点上
+	plugin.stop()
+	waitFor(t, "devices to become unhealthy after plugin stopped", manager.capacityIs(3, 0))
+	manager.clock.Step(endpointStopGracePeriod)
+	if _, _, removed := manager.GetCapacity(); len(removed) != 0 {
+		t.Errorf("resource should be kept within the stop grace period, removed %v", removed)
+	}
+
+	manager.clock.Step(time.Second)
+	capacity, allocatable, removed := manager.GetCapacity()
+	if !reflect.DeepEqual(removed, []string{testResourceName}) {
+		t.Errorf("expected resource to be removed after the stop grace period, got %v", removed)
+	}
+	if _, ok := capacity[testResourceName]; ok {
+		t.Errorf("removed resource should not be in capacity %v", capacity)
+	}
+	if _, ok := allocatable[testResourceName]; ok {
+		t.Errorf("removed resource should not be in allocatable %v", allocatable)
+	}
+	// 只上报一次删除
+	if _, _, removed := manager.GetCapacity(); len(removed) != 0 {
+		t.Errorf("resource should be removed only once, got %v", removed)
+	}
+}
+
+// 同名资源的插件重新注册后替换旧插件，旧插件断开不影响设备
+func TestReRegisterReplacesEndpoint(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	oldPlugin := newFakeDevicePlugin("gpu-old.sock", device("dev-1", pluginapi.Healthy))
+	oldPlugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(1, 1))
+
+	newPlugin := newFakeDevicePlugin("gpu-new.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy))
+	newPlugin.start(t, manager.Manager)
+	waitFor(t, "new plugin devices to be reported", manager.capacityIs(2, 2))
+
+	oldPlugin.stop()
+	time.Sleep(100 * time.Millisecond)
+	if !manager.capacityIs(2, 2)() {
+		c, a, _ := manager.GetCapacity()
+		t.Errorf("stopping the replaced plugin should not affect devices, capacity %v allocatable %v", c, a)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	testCases := []struct {
+		name    string
+		request *pluginapi.RegisterRequest
+	}{
+		{
+			name:    "unsupported version",
+			request: &pluginapi.RegisterRequest{Version: "v1alpha1", Endpoint: "gpu.sock", ResourceName: testResourceName},
+		},
+		{
+			name:    "not an extended resource",
+			request: &pluginapi.RegisterRequest{Version: pluginapi.Version, Endpoint: "gpu.sock", ResourceName: "gpu"},
+		},
+		{
+			name:    "kubernetes.io resource",
+			request: &pluginapi.RegisterRequest{Version: pluginapi.Version, Endpoint: "gpu.sock", ResourceName: "kubernetes.io/gpu"},
+		},
+		{
+			name:    "endpoint outside of the plugin directory",
+			request: &pluginapi.RegisterRequest{Version: pluginapi.Version, Endpoint: "../gpu.sock", ResourceName: testResourceName},
+		},
+	}
+	for _, tc := range testCases {
+		if err := register(manager.Manager, tc.request); err == nil {
+			t.Errorf("%s: expected registration to fail", tc.name)
+		}
+	}
+}
+
+// init容器退出后设备给业务容器复用
+func TestAllocateReusesInitContainerDevices(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy),
+		device("dev-3", pluginapi.Healthy))
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(3, 3))
+
+	initContainer := gpuContainer("init", 2)
+	app := gpuContainer("app", 2)
+	pod := newPod("pod-1", []v1.Container{initContainer}, app)
+	manager.setActivePods(pod)
+	if err := manager.Allocate(pod, &initContainer); err != nil {
+		t.Fatalf("failed to allocate init container: %v", err)
+	}
+	if err := manager.Allocate(pod, &app); err != nil {
+		t.Fatalf("failed to allocate app container: %v", err)
+	}
+	expected := [][]string{{"dev-1", "dev-2"}, {"dev-1", "dev-2"}}
+	if calls := plugin.getAllocateCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected allocate calls %v, got %v", expected, calls)
+	}
+
+	opts := manager.GetDeviceRunContainerOptions(pod, &app)
+	if opts == nil || len(opts.Devices) != 2 || opts.Devices[0].PathOnHost != "/dev/dev-1" {
+		t.Fatalf("unexpected run container options %+v", opts)
+	}
+	if len(opts.Envs) != 1 || opts.Envs[0].Name != "VISIBLE_DEVICES" || opts.Annotations["example.com/gpu"] != "allocated" {
+		t.Errorf("unexpected envs %v or annotations %v", opts.Envs, opts.Annotations)
+	}
+
+	// 只剩一个设备，另一个pod请求两个时失败
+	other := gpuContainer("app", 2)
+	otherPod := newPod("pod-2", nil, other)
+	manager.setActivePods(pod, otherPod)
+	if err := manager.Allocate(otherPod, &other); err == nil {
+		t.Errorf("expected allocation to fail when devices are unavailable")
+	}
+
+	// 第一个pod结束后设备归还
+	manager.setActivePods(otherPod)
+	if err := manager.Allocate(otherPod, &other); err != nil {
+		t.Errorf("expected allocation to succeed after devices were released: %v", err)
+	}
+}
+
+// sidecar容器和业务容器同时运行，不能复用设备
+func TestAllocateSidecarDevicesNotReused(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy),
+		device("dev-3", pluginapi.Healthy))
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(3, 3))
+
+	always := v1.ContainerRestartPolicyAlways
+	sidecar := gpuContainer("sidecar", 1)
+	sidecar.RestartPolicy = &always
+	app := gpuContainer("app", 2)
+	pod := newPod("pod-1", []v1.Container{sidecar}, app)
+	manager.setActivePods(pod)
+	if err := manager.Allocate(pod, &sidecar); err != nil {
+		t.Fatalf("failed to allocate sidecar container: %v", err)
+	}
+	if err := manager.Allocate(pod, &app); err != nil {
+		t.Fatalf("failed to allocate app container: %v", err)
+	}
+	expected := [][]string{{"dev-1"}, {"dev-2", "dev-3"}}
+	if calls := plugin.getAllocateCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected allocate calls %v, got %v", expected, calls)
+	}
+}
+
+// 插件Allocate失败时归还设备
+func TestAllocateFailureReleasesDevices(t *testing.T) {
+	manager := newTestManager(t, "", testingclock.NewFakeClock(time.Now()))
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy))
+	plugin.options = &pluginapi.DevicePluginOptions{PreStartRequired: true}
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(1, 1))
+
+	app := gpuContainer("app", 1)
+	pod := newPod("pod-1", nil, app)
+	manager.setActivePods(pod)
+
+	plugin.lock.Lock()
+	plugin.preStartErr = fmt.Errorf("device busy")
+	plugin.lock.Unlock()
+	if err := manager.Allocate(pod, &app); err == nil {
+		t.Fatalf("expected allocation to fail when PreStartContainer fails")
+	}
+
+	plugin.lock.Lock()
+	plugin.preStartErr = nil
+	plugin.allocateErr = fmt.Errorf("allocation failed")
+	plugin.lock.Unlock()
+	if err := manager.Allocate(pod, &app); err == nil {
+		t.Fatalf("expected allocation to fail when Allocate fails")
+	}
+
+	plugin.lock.Lock()
+	plugin.allocateErr = nil
+	plugin.lock.Unlock()
+	if err := manager.Allocate(pod, &app); err != nil {
+		t.Fatalf("expected device to be released after failed allocations: %v", err)
+	}
+	if len(plugin.preStartCalls) != 3 {
+		t.Errorf("expected PreStartContainer before every allocation, got %v", plugin.preStartCalls)
+	}
+}
+
+// kubelet重启后从检查点恢复分配，插件重新注册前资源按已停止的插件保留
+func TestCheckpointRestore(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	manager := newTestManager(t, "", fakeClock)
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy))
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(2, 2))
+
+	app := gpuContainer("app", 1)
+	pod := newPod("pod-1", nil, app)
+	manager.setActivePods(pod)
+	if err := manager.Allocate(pod, &app); err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	before := manager.GetDeviceRunContainerOptions(pod, &app)
+	manager.Stop()
+	plugin.stop()
+
+	restarted := newTestManager(t, manager.socketDir, fakeClock)
+	restarted.setActivePods(pod)
+	after := restarted.GetDeviceRunContainerOptions(pod, &app)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected run container options %+v to be restored, got %+v", before, after)
+	}
+	if !restarted.capacityIs(2, 2)() {
+		c, a, _ := restarted.GetCapacity()
+		t.Errorf("expected registered devices to be restored, capacity %v allocatable %v", c, a)
+	}
+
+	// 已经分配的设备不会再分配给其他pod
+	plugin = newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy), device("dev-2", pluginapi.Healthy))
+	plugin.start(t, restarted.Manager)
+	waitFor(t, "plugin to re-register", func() bool {
+		plugin.lock.Lock()
+		defer plugin.lock.Unlock()
+		return plugin.listAndWatching
+	})
+	waitFor(t, "devices to be reported", restarted.capacityIs(2, 2))
+	other := gpuContainer("app", 1)
+	otherPod := newPod("pod-2", nil, other)
+	restarted.setActivePods(pod, otherPod)
+	if err := restarted.Allocate(otherPod, &other); err != nil {
+		t.Fatalf("failed to allocate: %v", err)
+	}
+	if calls := plugin.getAllocateCalls(); !reflect.DeepEqual(calls, [][]string{{"dev-2"}}) {
+		t.Errorf("expected the free device to be allocated, got %v", calls)
+	}
+}
+
+// 检查点中的资源在插件没有重新注册时，宽限期后从节点上删除
+func TestCheckpointRestoreWithoutPlugin(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	manager := newTestManager(t, "", fakeClock)
+	plugin := newFakeDevicePlugin("gpu.sock", device("dev-1", pluginapi.Healthy))
+	plugin.start(t, manager.Manager)
+	waitFor(t, "devices to be reported", manager.capacityIs(1, 1))
+	manager.Stop()
+	plugin.stop()
+
+	restarted := newTestManager(t, manager.socketDir, fakeClock)
+	if !restarted.capacityIs(1, 1)() {
+		t.Fatalf("expected registered devices to be restored")
+	}
+	fakeClock.Step(endpointStopGracePeriod + time.Second)
+	if _, _, removed := restarted.GetCapacity(); !reflect.DeepEqual(removed, []string{testResourceName}) {
+		t.Errorf("expected resource to be removed after the stop grace period, got %v", removed)
+	}
+}
+
+func TestCorruptedCheckpoint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, kubeletDeviceManagerCheckpoint)
+	if err := writeCheckpoint(path, &deviceManagerCheckpoint{RegisteredDevices: map[string][]string{testResourceName: {"dev-1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpoint(path); err != nil {
+		t.Fatalf("failed to read checkpoint: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := []byte(string(data[:len(data)-2]) + "1}")
+	if err := os.WriteFile(path, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCheckpoint(path); err == nil {
+		t.Errorf("expected checksum mismatch to be detected")
+	}
+}
@@ -0,0 +1,222 @@
+package devicemanager
+
+import (
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+	"mykubelet/pkg/container"
+	"sort"
+	"sync"
+)
+
+// deviceAllocateInfo 容器分配的一种资源的设备，以及插件Allocate的返回
+type deviceAllocateInfo struct {
+	deviceIDs []string
+	allocResp *pluginapi.ContainerAllocateResponse
+}
+
+// podDevices pod uid -> 容器名 -> 资源名 -> 分配的设备
+// pkg/kubelet/cm/devicemanager/pod_devices.go
+type podDevices struct {
+	sync.RWMutex
+	devs map[string]map[string]map[string]deviceAllocateInfo
+}
+
+func newPodDevices() *podDevices {
+	return &podDevices{devs: map[string]map[string]map[string]deviceAllocateInfo{}}
+}
+
+func (this *podDevices) pods() map[string]bool {
+	this.RLock()
+	defer this.RUnlock()
+	ret := map[string]bool{}
+	for podUID := range this.devs {
+		ret[podUID] = true
+	}
+	return ret
+}
+
+func (this *podDevices) hasPod(podUID string) bool {
+	this.RLock()
+	defer this.RUnlock()
+	_, ok := this.devs[podUID]
+	return ok
+}
+
+func (this *podDevices) insert(podUID, containerName, resourceName string, deviceIDs []string, allocResp *pluginapi.ContainerAllocateResponse) {
+	this.Lock()
+	defer this.Unlock()
+	if _, ok := this.devs[podUID]; !ok {
+		this.devs[podUID] = map[string]map[string]deviceAllocateInfo{}
+	}
+	if _, ok := this.devs[podUID][containerName]; !ok {
+		this.devs[podUID][containerName] = map[string]deviceAllocateInfo{}
+	}
+	this.devs[podUID][containerName][resourceName] = deviceAllocateInfo{
+		deviceIDs: append([]string{}, deviceIDs...),
+		allocResp: allocResp,
+	}
+}
+
+func (this *podDevices) delete(podUIDs []string) {
+	this.Lock()
+	defer this.Unlock()
+	for _, podUID := range podUIDs {
+		delete(this.devs, podUID)
+	}
+}
+
+// deleteContainer 删除容器的记录，pod没有其他容器时一起删除
+func (this *podDevices) deleteContainer(podUID, containerName string) {
+	this.Lock()
+	defer this.Unlock()
+	if _, ok := this.devs[podUID]; !ok {
+		return
+	}
+	delete(this.devs[podUID], containerName)
+	if len(this.devs[podUID]) == 0 {
+		delete(this.devs, podUID)
+	}
+}
+
+// containerDevices 容器分配的一种资源的设备
+func (this *podDevices) containerDevices(podUID, containerName, resourceName string) []string {
+	this.RLock()
+	defer this.RUnlock()
+	info, ok := this.devs[podUID][containerName][resourceName]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, info.deviceIDs...)
+}
+
+// podDevices pod中所有容器分配的一种资源的设备
+func (this *podDevices) podDevices(podUID, resourceName string) map[string]bool {
+	this.RLock()
+	defer this.RUnlock()
+	ret := map[string]bool{}
+	for _, resources := range this.devs[podUID] {
+		for _, id := range resources[resourceName].deviceIDs {
+			ret[id] = true
+		}
+	}
+	return ret
+}
+
+// devices 资源名 -> 所有容器分配的设备
+func (this *podDevices) devices() map[string]map[string]bool {
+	this.RLock()
+	defer this.RUnlock()
+	ret := map[string]map[string]bool{}
+	for _, containers := range this.devs {
+		for _, resources := range containers {
+			for resourceName, info := range resources {
+				if _, ok := ret[resourceName]; !ok {
+					ret[resourceName] = map[string]bool{}
+				}
+				for _, id := range info.deviceIDs {
+					ret[resourceName][id] = true
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// toCheckpointData 按pod、容器、资源排序，保证相同的状态写出相同的检查点
+func (this *podDevices) toCheckpointData() []PodDevicesEntry {
+	this.RLock()
+	defer this.RUnlock()
+	entries := []PodDevicesEntry{}
+	for podUID, containers := range this.devs {
+		for containerName, resources := range containers {
+			for resourceName, info := range resources {
+				entries = append(entries, PodDevicesEntry{
+					PodUID:        podUID,
+					ContainerName: containerName,
+					ResourceName:  resourceName,
+					DeviceIDs:     append([]string{}, info.deviceIDs...),
+					AllocResp:     info.allocResp,
+				})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].PodUID != entries[j].PodUID {
+			return entries[i].PodUID < entries[j].PodUID
+		}
+		if entries[i].ContainerName != entries[j].ContainerName {
+			return entries[i].ContainerName < entries[j].ContainerName
+		}
+		return entries[i].ResourceName < entries[j].ResourceName
+	})
+	return entries
+}
+
+func (this *podDevices) fromCheckpointData(entries []PodDevicesEntry) {
+	for _, entry := range entries {
+		this.insert(entry.PodUID, entry.ContainerName, entry.ResourceName, entry.DeviceIDs, entry.AllocResp)
+	}
+}
+
+// DeviceRunContainerOptions 插件要求容器使用设备时设置的环境变量、挂载、设备节点和注解
+type DeviceRunContainerOptions struct {
+	Envs        []container.EnvVar
+	Mounts      []container.Mount
+	Devices     []container.DeviceInfo
+	Annotations map[string]string
+}
+
+// deviceRunContainerOptions 合并容器所有资源的Allocate返回，相同的容器路径只保留一个
+func (this *podDevices) deviceRunContainerOptions(podUID, containerName string) *DeviceRunContainerOptions {
+	this.RLock()
+	defer this.RUnlock()
+	resources, ok := this.devs[podUID][containerName]
+	if !ok {
+		return nil
+	}
+	// 按资源名排序，保证多个插件设置同一个变量时结果稳定
+	resourceNames := make([]string, 0, len(resources))
+	for resourceName := range resources {
+		resourceNames = append(resourceNames, resourceName)
+	}
+	sort.Strings(resourceNames)
+
+	opts := &DeviceRunContainerOptions{Annotations: map[string]string{}}
+	envs := map[string]string{}
+	mounts := map[string]bool{}
+	devices := map[string]bool{}
+	for _, resourceName := range resourceNames {
+		resp := resources[resourceName].allocResp
+		if resp == nil {
+			continue
+		}
+		for k, v := range resp.Envs {
+			envs[k] = v
+		}
+		for _, m := range resp.Mounts {
+			if mounts[m.ContainerPath] {
+				continue
+			}
+			mounts[m.ContainerPath] = true
+			opts.Mounts = append(opts.Mounts, container.Mount{HostPath: m.HostPath, ContainerPath: m.ContainerPath, ReadOnly: m.ReadOnly})
+		}
+		for _, d := range resp.Devices {
+			if devices[d.ContainerPath] {
+				continue
+			}
+			devices[d.ContainerPath] = true
+			opts.Devices = append(opts.Devices, container.DeviceInfo{PathOnHost: d.HostPath, PathInContainer: d.ContainerPath, Permissions: d.Permissions})
+		}
+		for k, v := range resp.Annotations {
+			opts.Annotations[k] = v
+		}
+	}
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts.Envs = append(opts.Envs, container.EnvVar{Name: name, Value: envs[name]})
+	}
+	return opts
+}
@@ -0,0 +1,144 @@
+package cm
+
+import (
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"mykubelet/pkg/qos"
+)
+
+// pkg/kubelet/cm/helpers_linux.go
+const (
+	// cpu.shares的取值范围
+	MinShares     = 2
+	MaxShares     = 262144
+	SharesPerCPU  = 1024
+	MilliCPUToCPU = 1000
+
+	// 默认的cfs周期100ms，单位微秒
+	QuotaPeriod = 100000
+	// 最小的cfs配额1ms
+	MinQuotaPeriod = 1000
+)
+
+// MilliCPUToQuota 毫核转换为每个周期的cpu配额，0表示不限制
+func MilliCPUToQuota(milliCPU int64, period int64) int64 {
+	if milliCPU == 0 {
+		return 0
+	}
+	quota := (milliCPU * period) / MilliCPUToCPU
+	if quota < MinQuotaPeriod {
+		quota = MinQuotaPeriod
+	}
+	return quota
+}
+
+// MilliCPUToShares 毫核转换为cpu.shares，1核为1024
+func MilliCPUToShares(milliCPU int64) uint64 {
+	if milliCPU == 0 {
+		return MinShares
+	}
+	shares := (milliCPU * SharesPerCPU) / MilliCPUToCPU
+	if shares < MinShares {
+		return MinShares
+	}
+	if shares > MaxShares {
+		return MaxShares
+	}
+	return uint64(shares)
+}
+
+// CPUSharesToCPUWeight cpu.shares[2, 262144]线性映射到cgroup v2的cpu.weight[1, 10000]
+func CPUSharesToCPUWeight(shares uint64) uint64 {
+	if shares < MinShares {
+		shares = MinShares
+	}
+	if shares > MaxShares {
+		shares = MaxShares
+	}
+	return 1 + ((shares-MinShares)*9999)/(MaxShares-MinShares)
+}
+
+// ResourceConfigForPod pod cgroup的资源限制
+// Guaranteed按requests设置权重、按limits设置配额和内存上限；Burstable只在所有容器都设置了对应limits时才限制；BestEffort只有最小权重
+// pkg/kubelet/cm/helpers_linux.go ResourceConfigForPod
+func ResourceConfigForPod(pod *v1.Pod, enforceCPULimits bool, cpuPeriod uint64) *ResourceConfig {
+	reqs := qos.PodRequests(pod)
+	limits := qos.PodLimits(pod)
+
+	cpuRequests := int64(0)
+	cpuLimits := int64(0)
+	memoryLimits := int64(0)
+	if request, found := reqs[v1.ResourceCPU]; found {
+		cpuRequests = request.MilliValue()
+	}
+	if limit, found := limits[v1.ResourceCPU]; found {
+		cpuLimits = limit.MilliValue()
+	}
+	if limit, found := limits[v1.ResourceMemory]; found {
+		memoryLimits = limit.Value()
+	}
+
+	cpuShares := MilliCPUToShares(cpuRequests)
+	cpuQuota := MilliCPUToQuota(cpuLimits, int64(cpuPeriod))
+	if !enforceCPULimits {
+		cpuQuota = -1
+	}
+
+	cpuLimitsDeclared := true
+	memoryLimitsDeclared := true
+	allContainers := append([]v1.Container{}, pod.Spec.Containers...)
+	allContainers = append(allContainers, pod.Spec.InitContainers...)
+	for _, c := range allContainers {
+		if c.Resources.Limits.Cpu().IsZero() {
+			cpuLimitsDeclared = false
+		}
+		if c.Resources.Limits.Memory().IsZero() {
+			memoryLimitsDeclared = false
+		}
+	}
+
+	result := &ResourceConfig{}
+	switch qos.GetPodQOS(pod) {
+	case v1.PodQOSGuaranteed:
+		result.CPUShares = &cpuShares
+		result.CPUQuota = &cpuQuota
+		result.CPUPeriod = &cpuPeriod
+		result.Memory = &memoryLimits
+	case v1.PodQOSBurstable:
+		result.CPUShares = &cpuShares
+		if cpuLimitsDeclared {
+			result.CPUQuota = &cpuQuota
+			result.CPUPeriod = &cpuPeriod
+		}
+		if memoryLimitsDeclared {
+			result.Memory = &memoryLimits
+		}
+	default:
+		shares := uint64(MinShares)
+		result.CPUShares = &shares
+	}
+	return result
+}
+
+// GenerateLinuxContainerResources 容器cgroup的资源限制，由运行时写入容器的cgroup
+// pkg/kubelet/kuberuntime/kuberuntime_container_linux.go generateLinuxContainerResources
+func GenerateLinuxContainerResources(container *v1.Container, enforceCPULimits bool, cpuPeriod uint64) *runtimeapi.LinuxContainerResources {
+	cpuRequest := container.Resources.Requests.Cpu()
+	cpuLimit := container.Resources.Limits.Cpu()
+	memoryLimit := container.Resources.Limits.Memory().Value()
+
+	resources := &runtimeapi.LinuxContainerResources{
+		MemoryLimitInBytes: memoryLimit,
+	}
+	// 没有设置requests但设置了limits时，requests默认等于limits
+	if cpuRequest.IsZero() && !cpuLimit.IsZero() {
+		resources.CpuShares = int64(MilliCPUToShares(cpuLimit.MilliValue()))
+	} else {
+		resources.CpuShares = int64(MilliCPUToShares(cpuRequest.MilliValue()))
+	}
+	if enforceCPULimits {
+		resources.CpuPeriod = int64(cpuPeriod)
+		resources.CpuQuota = MilliCPUToQuota(cpuLimit.MilliValue(), int64(cpuPeriod))
+	}
+	return resources
+}
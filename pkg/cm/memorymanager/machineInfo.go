@@ -0,0 +1,122 @@
+package memorymanager
+
+import (
+	"bufio"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NUMANodeResources NUMA节点编号 -> 内存资源名 -> 字节数
+type NUMANodeResources map[int]map[v1.ResourceName]uint64
+
+// DiscoverNUMAMemory 从sysfs读取每个NUMA节点的内存和大页总量，sysNodePath一般为/sys/devices/system/node
+// nodeN/meminfo中的MemTotal为内存，nodeN/hugepages/hugepages-<size>kB/nr_hugepages为大页数
+func DiscoverNUMAMemory(sysNodePath string, numaNodes []int) (NUMANodeResources, error) {
+	ret := NUMANodeResources{}
+	for _, id := range numaNodes {
+		nodeDir := filepath.Join(sysNodePath, fmt.Sprintf("node%d", id))
+		memTotal, err := readMemTotal(filepath.Join(nodeDir, "meminfo"))
+		if err != nil {
+			return nil, err
+		}
+		ret[id] = map[v1.ResourceName]uint64{v1.ResourceMemory: memTotal}
+
+		entries, err := os.ReadDir(filepath.Join(nodeDir, "hugepages"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			sizeKB, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "hugepages-"), "kB"), 10, 64)
+			if err != nil {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(nodeDir, "hugepages", entry.Name(), "nr_hugepages"))
+			if err != nil {
+				return nil, err
+			}
+			count, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse hugepages count of %q: %v", entry.Name(), err)
+			}
+			ret[id][hugePageResourceName(sizeKB*1024)] = count * sizeKB * 1024
+		}
+	}
+	return ret, nil
+}
+
+// hugePageResourceName 如2097152字节为hugepages-2Mi
+func hugePageResourceName(pageSize uint64) v1.ResourceName {
+	return v1.ResourceName(v1.ResourceHugePagesPrefix + resource.NewQuantity(int64(pageSize), resource.BinarySI).String())
+}
+
+// readMemTotal 解析"Node 0 MemTotal:  6127352 kB"
+func readMemTotal(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "MemTotal:" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse MemTotal in %q: %v", path, err)
+		}
+		return value * 1024, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal not found in %q", path)
+}
+
+// ParseReservedMemory 解析每个NUMA节点预留的内存，如"0:memory=1Gi,hugepages-2Mi=0;1:memory=512Mi"
+func ParseReservedMemory(value string) (NUMANodeResources, error) {
+	ret := NUMANodeResources{}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ret, nil
+	}
+	for _, nodeReservation := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(nodeReservation), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid reserved memory %q, expected <numa node>:<resource>=<quantity>", nodeReservation)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid NUMA node %q in reserved memory", parts[0])
+		}
+		if _, ok := ret[id]; !ok {
+			ret[id] = map[v1.ResourceName]uint64{}
+		}
+		for _, pair := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid reserved memory %q, expected <resource>=<quantity>", pair)
+			}
+			name := v1.ResourceName(strings.TrimSpace(kv[0]))
+			if name != v1.ResourceMemory && !isHugePageResourceName(name) {
+				return nil, fmt.Errorf("invalid resource %q in reserved memory, only memory and hugepages are supported", name)
+			}
+			q, err := resource.ParseQuantity(strings.TrimSpace(kv[1]))
+			if err != nil || q.Sign() < 0 {
+				return nil, fmt.Errorf("invalid quantity %q for %q in reserved memory", kv[1], name)
+			}
+			ret[id][name] += uint64(q.Value())
+		}
+	}
+	return ret, nil
+}
+
+func isHugePageResourceName(name v1.ResourceName) bool {
+	return strings.HasPrefix(string(name), v1.ResourceHugePagesPrefix)
+}
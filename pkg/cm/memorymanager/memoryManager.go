@@ -0,0 +1,125 @@
+package memorymanager
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm/topologymanager"
+	"sync"
+)
+
+// 内存管理器检查点文件的名字，在kubelet根目录下
+const memoryManagerStateFileName = "memory_manager_state"
+
+// ActivePodsFunc 节点上运行中的pod
+type ActivePodsFunc func() []*v1.Pod
+
+// Manager 准入时为Guaranteed pod的容器在NUMA节点上预留内存和大页，创建容器时限制其cpuset.mems
+// pkg/kubelet/cm/memorymanager/memory_manager.go
+type Manager struct {
+	// 保护policy对state的修改
+	lock       sync.Mutex
+	policy     Policy
+	state      *State
+	activePods ActivePodsFunc
+}
+
+// NewManager policyName为None或Static，static策略从sysNodePath读取numaNodes上的内存，reserved为每个NUMA节点预留给系统的内存
+func NewManager(policyName string, sysNodePath string, numaNodes []int, reserved NUMANodeResources,
+	affinity topologymanager.Store, stateDir string) (*Manager, error) {
+	var policy Policy
+	switch policyName {
+	case PolicyNone:
+		policy = NewNonePolicy()
+	case PolicyStatic:
+		machineInfo, err := DiscoverNUMAMemory(sysNodePath, numaNodes)
+		if err != nil {
+			return nil, err
+		}
+		if policy, err = NewStaticPolicy(machineInfo, reserved, affinity); err != nil {
+			return nil, fmt.Errorf("new static policy error: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy: %q", policyName)
+	}
+	state, err := NewCheckpointState(stateDir, memoryManagerStateFileName, policy.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{policy: policy, state: state}, nil
+}
+
+// Start 校验检查点中的状态
+func (this *Manager) Start(activePods ActivePodsFunc) error {
+	klog.InfoS("Starting memorymanager", "policy", this.policy.Name())
+	this.activePods = activePods
+	if err := this.policy.Start(this.state); err != nil {
+		return fmt.Errorf("start memory manager error: %v", err)
+	}
+	return nil
+}
+
+// GetTopologyHints 准入时由拓扑管理器调用，先清理已经不存在的pod占用的内存
+func (this *Manager) GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	this.removeStaleState()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.GetTopologyHints(this.state, pod, container)
+}
+
+// Allocate 按拓扑管理器的hint预留内存
+func (this *Manager) Allocate(pod *v1.Pod, container *v1.Container) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.Allocate(this.state, pod, container)
+}
+
+// RemoveContainer 容器的内存归还NUMA节点
+func (this *Manager) RemoveContainer(podUID types.UID, containerName string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.policy.RemoveContainer(this.state, string(podUID), containerName)
+}
+
+// GetMemoryNUMANodes 容器可以使用内存的NUMA节点，没有分配时为空，表示不限制
+func (this *Manager) GetMemoryNUMANodes(podUID types.UID, containerName string) cpuset.CPUSet {
+	ids := []int{}
+	for _, b := range this.state.GetMemoryBlocks(string(podUID), containerName) {
+		ids = append(ids, b.NUMAAffinity...)
+	}
+	return cpuset.New(ids...)
+}
+
+// removeStaleState 已经不在运行的pod的容器归还内存
+func (this *Manager) removeStaleState() {
+	if this.activePods == nil {
+		return
+	}
+	activeContainers := map[string]map[string]bool{}
+	for _, pod := range this.activePods() {
+		podUID := string(pod.UID)
+		activeContainers[podUID] = map[string]bool{}
+		for _, c := range pod.Spec.InitContainers {
+			activeContainers[podUID][c.Name] = true
+		}
+		for _, c := range pod.Spec.Containers {
+			activeContainers[podUID][c.Name] = true
+		}
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for podUID, containers := range this.state.GetMemoryAssignments() {
+		for containerName := range containers {
+			if activeContainers[podUID][containerName] {
+				continue
+			}
+			klog.InfoS("RemoveStaleState removing state", "podUID", podUID, "containerName", containerName)
+			if err := this.policy.RemoveContainer(this.state, podUID, containerName); err != nil {
+				klog.ErrorS(err, "RemoveStaleState: failed to remove state", "podUID", podUID, "containerName", containerName)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package memorymanager
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"mykubelet/pkg/cm/topologymanager"
+)
+
+const (
+	// PolicyNone 不按NUMA节点分配内存
+	PolicyNone = "None"
+	// PolicyStatic Guaranteed pod的容器在NUMA节点上独占内存和大页
+	PolicyStatic = "Static"
+)
+
+// Policy 内存分配策略
+// pkg/kubelet/cm/memorymanager/policy.go
+type Policy interface {
+	Name() string
+	// Start 校验从检查点恢复的状态，没有状态时初始化
+	Start(s *State) error
+	// Allocate 为容器分配内存，已经分配过时不重复分配
+	Allocate(s *State, pod *v1.Pod, container *v1.Container) error
+	// RemoveContainer 容器的内存归还NUMA节点
+	RemoveContainer(s *State, podUID string, containerName string) error
+	// GetTopologyHints 容器需要的内存和大页可以分配在哪些NUMA节点上
+	GetTopologyHints(s *State, pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint
+}
+
+// nonePolicy 不做任何分配
+// pkg/kubelet/cm/memorymanager/policy_none.go
+type nonePolicy struct{}
+
+func NewNonePolicy() Policy {
+	return &nonePolicy{}
+}
+
+func (this *nonePolicy) Name() string {
+	return PolicyNone
+}
+
+func (this *nonePolicy) Start(_ *State) error {
+	return nil
+}
+
+func (this *nonePolicy) Allocate(_ *State, _ *v1.Pod, _ *v1.Container) error {
+	return nil
+}
+
+func (this *nonePolicy) RemoveContainer(_ *State, _ string, _ string) error {
+	return nil
+}
+
+func (this *nonePolicy) GetTopologyHints(_ *State, _ *v1.Pod, _ *v1.Container) map[string][]topologymanager.TopologyHint {
+	return nil
+}
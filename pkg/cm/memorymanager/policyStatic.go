@@ -0,0 +1,406 @@
+package memorymanager
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/cm/topologymanager"
+	"mykubelet/pkg/qos"
+	"sort"
+)
+
+// staticPolicy Guaranteed pod的容器按拓扑管理器的hint在NUMA节点上预留内存和大页，容器的cpuset.mems限制为这些节点
+// 每个NUMA节点上预留给系统的内存不分配给容器
+// pkg/kubelet/cm/memorymanager/policy_static.go
+type staticPolicy struct {
+	// 每个NUMA节点上的内存和大页总量
+	machineInfo NUMANodeResources
+	// 每个NUMA节点上预留给系统的内存和大页
+	systemReserved NUMANodeResources
+	numaNodes      []int
+	affinity       topologymanager.Store
+}
+
+// NewStaticPolicy 预留的内存不能为空，且不能超过NUMA节点上的总量
+func NewStaticPolicy(machineInfo NUMANodeResources, reserved NUMANodeResources, affinity topologymanager.Store) (Policy, error) {
+	totalReserved := uint64(0)
+	for id, resources := range reserved {
+		if _, ok := machineInfo[id]; !ok {
+			return nil, fmt.Errorf("reserved memory for NUMA node %d, which does not exist", id)
+		}
+		for name, size := range resources {
+			if total := machineInfo[id][name]; size > total {
+				return nil, fmt.Errorf("reserved %s %d on NUMA node %d exceeds the capacity %d", name, size, id, total)
+			}
+		}
+		totalReserved += resources[v1.ResourceMemory]
+	}
+	if totalReserved == 0 {
+		return nil, fmt.Errorf("the static policy requires reserved memory to be greater than zero")
+	}
+	numaNodes := make([]int, 0, len(machineInfo))
+	for id := range machineInfo {
+		numaNodes = append(numaNodes, id)
+	}
+	sort.Ints(numaNodes)
+	return &staticPolicy{
+		machineInfo:    machineInfo,
+		systemReserved: reserved,
+		numaNodes:      numaNodes,
+		affinity:       affinity,
+	}, nil
+}
+
+func (this *staticPolicy) Name() string {
+	return PolicyStatic
+}
+
+// initialMachineState 没有任何分配时每个NUMA节点的状态
+func (this *staticPolicy) initialMachineState() NUMANodeMap {
+	machineState := NUMANodeMap{}
+	for _, id := range this.numaNodes {
+		node := &NUMANodeState{MemoryMap: map[v1.ResourceName]*MemoryTable{}, Cells: []int{id}}
+		for name, total := range this.machineInfo[id] {
+			systemReserved := this.systemReserved[id][name]
+			node.MemoryMap[name] = &MemoryTable{
+				TotalMemSize:   total,
+				SystemReserved: systemReserved,
+				Allocatable:    total - systemReserved,
+				Free:           total - systemReserved,
+			}
+		}
+		machineState[id] = node
+	}
+	return machineState
+}
+
+// Start 没有状态时按NUMA节点初始化；有状态时节点的总量和预留需要和当前配置一致，已预留的总量需要和各容器的内存块一致
+func (this *staticPolicy) Start(s *State) error {
+	expected := this.initialMachineState()
+	current := s.GetMachineState()
+	assignments := s.GetMemoryAssignments()
+	if len(current) == 0 {
+		if len(assignments) != 0 {
+			return fmt.Errorf("machine state can not be empty when it has memory assignments")
+		}
+		s.SetMachineState(expected)
+		return nil
+	}
+
+	if len(current) != len(expected) {
+		return fmt.Errorf("the expected machine state has %d NUMA nodes, but the state has %d", len(expected), len(current))
+	}
+	reservedInState := map[v1.ResourceName]uint64{}
+	for id, node := range expected {
+		currentNode, ok := current[id]
+		if !ok || len(currentNode.MemoryMap) != len(node.MemoryMap) {
+			return fmt.Errorf("the expected machine state of NUMA node %d is different from the state", id)
+		}
+		for name, table := range node.MemoryMap {
+			currentTable, ok := currentNode.MemoryMap[name]
+			if !ok || currentTable.TotalMemSize != table.TotalMemSize || currentTable.SystemReserved != table.SystemReserved {
+				return fmt.Errorf("the expected %s of NUMA node %d is different from the state", name, id)
+			}
+			reservedInState[name] += currentTable.Reserved
+		}
+	}
+	reservedInAssignments := map[v1.ResourceName]uint64{}
+	for pod := range assignments {
+		for _, blocks := range assignments[pod] {
+			for _, b := range blocks {
+				reservedInAssignments[b.Type] += b.Size
+			}
+		}
+	}
+	for name, size := range reservedInState {
+		if reservedInAssignments[name] != size {
+			return fmt.Errorf("the reserved %s %d in the machine state is different from the memory assignments %d",
+				name, size, reservedInAssignments[name])
+		}
+	}
+	return nil
+}
+
+func (this *staticPolicy) Allocate(s *State, pod *v1.Pod, container *v1.Container) error {
+	if qos.GetPodQOS(pod) != v1.PodQOSGuaranteed {
+		return nil
+	}
+	podUID := string(pod.UID)
+	if blocks := s.GetMemoryBlocks(podUID, container.Name); blocks != nil {
+		klog.InfoS("Static policy: container already present in state, skipping", "pod", klog.KObj(pod), "containerName", container.Name)
+		return nil
+	}
+	requested := getRequestedResources(container)
+	if len(requested) == 0 {
+		return nil
+	}
+
+	machineState := s.GetMachineState()
+	hint := this.affinity.GetAffinity(podUID, container.Name)
+	if hint.NUMANodeAffinity.IsEmpty() || !this.isAffinitySatisfyRequest(s, machineState, pod, hint.NUMANodeAffinity.GetBits(), requested) {
+		defaultHint, ok := this.getDefaultHint(s, machineState, pod, requested)
+		if !ok {
+			return fmt.Errorf("failed to get the default NUMA affinity, no NUMA nodes with enough memory is available")
+		}
+		hint = defaultHint
+	}
+
+	maskBits := hint.NUMANodeAffinity.GetBits()
+	blocks := []Block{}
+	for name, size := range requested {
+		// 已经退出的init容器的内存可以直接给后面的容器使用，不需要再从NUMA节点上分配
+		reused := this.reuseInitContainersMemory(s, pod, maskBits, name, size)
+		reserveMemory(machineState, maskBits, name, size-reused)
+		blocks = append(blocks, Block{NUMAAffinity: maskBits, Type: name, Size: size})
+	}
+	for _, id := range maskBits {
+		machineState[id].NumberOfAssignments += len(blocks)
+		machineState[id].Cells = maskBits
+	}
+	s.SetMachineState(machineState)
+	s.SetMemoryBlocks(podUID, container.Name, blocks)
+	klog.InfoS("Static policy: allocated memory", "pod", klog.KObj(pod), "containerName", container.Name, "numaNodes", maskBits)
+	return nil
+}
+
+// RemoveContainer 内存块按分配时的顺序归还NUMA节点
+func (this *staticPolicy) RemoveContainer(s *State, podUID string, containerName string) error {
+	blocks := s.GetMemoryBlocks(podUID, containerName)
+	if blocks == nil {
+		return nil
+	}
+	s.Delete(podUID, containerName)
+	machineState := s.GetMachineState()
+	for _, b := range blocks {
+		remaining := b.Size
+		for _, id := range b.NUMAAffinity {
+			node, ok := machineState[id]
+			if !ok {
+				continue
+			}
+			if table := node.MemoryMap[b.Type]; table != nil {
+				released := minUint64(table.Reserved, remaining)
+				table.Reserved -= released
+				table.Free += released
+				remaining -= released
+			}
+			node.NumberOfAssignments--
+			if node.NumberOfAssignments <= 0 {
+				node.NumberOfAssignments = 0
+				node.Cells = []int{id}
+			}
+		}
+	}
+	s.SetMachineState(machineState)
+	klog.V(2).InfoS("Static policy: released memory", "podUID", podUID, "containerName", containerName)
+	return nil
+}
+
+// GetTopologyHints 已经分配过的容器按内存块返回hint，否则遍历所有NUMA节点的组合
+func (this *staticPolicy) GetTopologyHints(s *State, pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	if qos.GetPodQOS(pod) != v1.PodQOSGuaranteed {
+		return nil
+	}
+	requested := getRequestedResources(container)
+	if len(requested) == 0 {
+		return nil
+	}
+	hints := map[string][]topologymanager.TopologyHint{}
+	if blocks := s.GetMemoryBlocks(string(pod.UID), container.Name); blocks != nil {
+		for _, b := range blocks {
+			mask, err := topologymanager.NewBitMask(b.NUMAAffinity...)
+			if err != nil {
+				klog.ErrorS(err, "Failed to generate NUMA bitmask", "pod", klog.KObj(pod), "containerName", container.Name)
+				return nil
+			}
+			hints[string(b.Type)] = []topologymanager.TopologyHint{{NUMANodeAffinity: mask, Preferred: true}}
+		}
+		return hints
+	}
+	calculated := this.calculateHints(s, s.GetMachineState(), pod, requested)
+	for name := range requested {
+		hints[string(name)] = calculated
+	}
+	return hints
+}
+
+// calculateHints 空闲内存足够的NUMA节点组合都是hint，可分配内存足够的组合中节点数最少的为preferred
+// 已经和其他节点一起分配过的节点只能和这些节点作为一个整体使用
+// pkg/kubelet/cm/memorymanager/policy_static.go calculateHints
+func (this *staticPolicy) calculateHints(s *State, machineState NUMANodeMap, pod *v1.Pod,
+	requested map[v1.ResourceName]uint64) []topologymanager.TopologyHint {
+	minAffinitySize := len(this.numaNodes)
+	hints := []topologymanager.TopologyHint{}
+	topologymanager.IterateBitMasks(this.numaNodes, func(mask topologymanager.BitMask) {
+		maskBits := mask.GetBits()
+		totalAllocatable := map[v1.ResourceName]uint64{}
+		for _, id := range maskBits {
+			for name := range requested {
+				if table := machineState[id].MemoryMap[name]; table != nil {
+					totalAllocatable[name] += table.Allocatable
+				}
+			}
+		}
+		for name, size := range requested {
+			if totalAllocatable[name] < size {
+				return
+			}
+		}
+		if mask.Count() < minAffinitySize {
+			minAffinitySize = mask.Count()
+		}
+		if !this.isAffinitySatisfyRequest(s, machineState, pod, maskBits, requested) {
+			return
+		}
+		hints = append(hints, topologymanager.TopologyHint{NUMANodeAffinity: mask})
+	})
+	for i := range hints {
+		if hints[i].NUMANodeAffinity.Count() == minAffinitySize {
+			hints[i].Preferred = true
+		}
+	}
+	return hints
+}
+
+// getDefaultHint 拓扑管理器没有给出可用的hint时，使用最优的hint
+func (this *staticPolicy) getDefaultHint(s *State, machineState NUMANodeMap, pod *v1.Pod,
+	requested map[v1.ResourceName]uint64) (topologymanager.TopologyHint, bool) {
+	hints := this.calculateHints(s, machineState, pod, requested)
+	if len(hints) == 0 {
+		return topologymanager.TopologyHint{}, false
+	}
+	best := hints[0]
+	for _, hint := range hints[1:] {
+		if hint.LessThan(best) {
+			best = hint
+		}
+	}
+	return best, true
+}
+
+// isAffinitySatisfyRequest maskBits中节点的空闲内存加上可以复用的init容器内存满足请求，且不会打破已有的节点分组
+func (this *staticPolicy) isAffinitySatisfyRequest(s *State, machineState NUMANodeMap, pod *v1.Pod, maskBits []int,
+	requested map[v1.ResourceName]uint64) bool {
+	totalFree := map[v1.ResourceName]uint64{}
+	for _, id := range maskBits {
+		node, ok := machineState[id]
+		if !ok {
+			return false
+		}
+		if node.NumberOfAssignments > 0 && !equalCells(node.Cells, maskBits) {
+			return false
+		}
+		for name := range requested {
+			if table := node.MemoryMap[name]; table != nil {
+				totalFree[name] += table.Free
+			}
+		}
+	}
+	for name, size := range requested {
+		if totalFree[name]+reusableMemory(s, pod, maskBits, name) < size {
+			return false
+		}
+	}
+	return true
+}
+
+// reuseInitContainersMemory 从同一pod中已经分配的普通init容器的内存块中取出可以复用的内存，返回复用的字节数
+// init容器按顺序运行并退出，它们的内存可以被之后启动的容器使用
+func (this *staticPolicy) reuseInitContainersMemory(s *State, pod *v1.Pod, maskBits []int, name v1.ResourceName,
+	size uint64) uint64 {
+	reused := uint64(0)
+	podUID := string(pod.UID)
+	for _, initContainer := range reusableInitContainers(pod) {
+		if reused == size {
+			break
+		}
+		blocks := s.GetMemoryBlocks(podUID, initContainer.Name)
+		changed := false
+		for i := range blocks {
+			if blocks[i].Type != name || !equalCells(blocks[i].NUMAAffinity, maskBits) {
+				continue
+			}
+			taken := minUint64(blocks[i].Size, size-reused)
+			blocks[i].Size -= taken
+			reused += taken
+			changed = changed || taken > 0
+		}
+		if changed {
+			s.SetMemoryBlocks(podUID, initContainer.Name, blocks)
+		}
+	}
+	return reused
+}
+
+// reusableMemory 同一pod中已经分配的普通init容器在maskBits上的内存
+func reusableMemory(s *State, pod *v1.Pod, maskBits []int, name v1.ResourceName) uint64 {
+	total := uint64(0)
+	for _, initContainer := range reusableInitContainers(pod) {
+		for _, b := range s.GetMemoryBlocks(string(pod.UID), initContainer.Name) {
+			if b.Type == name && equalCells(b.NUMAAffinity, maskBits) {
+				total += b.Size
+			}
+		}
+	}
+	return total
+}
+
+// reusableInitContainers 运行后会退出的init容器，sidecar容器一直运行，其内存不能复用
+func reusableInitContainers(pod *v1.Pod) []v1.Container {
+	ret := []v1.Container{}
+	for _, c := range pod.Spec.InitContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			continue
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// reserveMemory 按节点顺序从空闲内存中预留
+func reserveMemory(machineState NUMANodeMap, maskBits []int, name v1.ResourceName, size uint64) {
+	remaining := size
+	for _, id := range maskBits {
+		table := machineState[id].MemoryMap[name]
+		if table == nil {
+			continue
+		}
+		taken := minUint64(table.Free, remaining)
+		table.Reserved += taken
+		table.Free -= taken
+		remaining -= taken
+	}
+}
+
+// getRequestedResources 容器请求的内存和大页
+func getRequestedResources(container *v1.Container) map[v1.ResourceName]uint64 {
+	requested := map[v1.ResourceName]uint64{}
+	for name, quantity := range container.Resources.Requests {
+		if name != v1.ResourceMemory && !isHugePageResourceName(name) {
+			continue
+		}
+		if quantity.Value() > 0 {
+			requested[name] = uint64(quantity.Value())
+		}
+	}
+	return requested
+}
+
+func equalCells(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,251 @@
+package memorymanager
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/cm/topologymanager"
+	"reflect"
+	"testing"
+)
+
+const (
+	gi           = uint64(1024 * 1024 * 1024)
+	mi           = uint64(1024 * 1024)
+	hugepages2Mi = v1.ResourceName("hugepages-2Mi")
+)
+
+// fakeAffinity 拓扑管理器合并后的hint，没有设置的容器由策略选择NUMA节点
+type fakeAffinity map[string]topologymanager.TopologyHint
+
+func (this fakeAffinity) GetAffinity(podUID string, containerName string) topologymanager.TopologyHint {
+	return this[podUID+"/"+containerName]
+}
+
+func numaAffinity(bits ...int) topologymanager.TopologyHint {
+	mask, _ := topologymanager.NewBitMask(bits...)
+	return topologymanager.TopologyHint{NUMANodeAffinity: mask, Preferred: true}
+}
+
+// twoNUMANodes 每个NUMA节点8Gi内存和1Gi的2Mi大页
+func twoNUMANodes() NUMANodeResources {
+	return NUMANodeResources{
+		0: {v1.ResourceMemory: 8 * gi, hugepages2Mi: gi},
+		1: {v1.ResourceMemory: 8 * gi, hugepages2Mi: gi},
+	}
+}
+
+// 节点0预留1Gi内存，节点1预留2Gi内存和512Mi大页
+func testReservedMemory() NUMANodeResources {
+	return NUMANodeResources{
+		0: {v1.ResourceMemory: gi},
+		1: {v1.ResourceMemory: 2 * gi, hugepages2Mi: 512 * mi},
+	}
+}
+
+func newStaticPolicyForTest(t *testing.T, affinity fakeAffinity) (*staticPolicy, *State) {
+	policy, err := NewStaticPolicy(twoNUMANodes(), testReservedMemory(), affinity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewCheckpointState(t.TempDir(), memoryManagerStateFileName, PolicyStatic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = policy.Start(s); err != nil {
+		t.Fatal(err)
+	}
+	return policy.(*staticPolicy), s
+}
+
+func memoryContainer(name, memory, hugepages string) v1.Container {
+	resources := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse(memory),
+	}
+	if hugepages != "" {
+		resources[hugepages2Mi] = resource.MustParse(hugepages)
+	}
+	return v1.Container{Name: name, Resources: v1.ResourceRequirements{Requests: resources, Limits: resources}}
+}
+
+func newMemoryPod(uid string, initContainers []v1.Container, containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)},
+		Spec:       v1.PodSpec{InitContainers: initContainers, Containers: containers},
+	}
+}
+
+// expectMemoryTable 检查NUMA节点上一种内存的预留和空闲
+func expectMemoryTable(t *testing.T, s *State, id int, name v1.ResourceName, reserved, free uint64) {
+	t.Helper()
+	table := s.GetMachineState()[id].MemoryMap[name]
+	if table.Reserved != reserved || table.Free != free {
+		t.Errorf("NUMA node %d %s: expected reserved %d free %d, got reserved %d free %d",
+			id, name, reserved, free, table.Reserved, table.Free)
+	}
+}
+
+func TestNewStaticPolicyReserved(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reserved NUMANodeResources
+	}{
+		{name: "no reserved memory", reserved: NUMANodeResources{0: {hugepages2Mi: 2 * mi}}},
+		{name: "missing NUMA node", reserved: NUMANodeResources{2: {v1.ResourceMemory: gi}}},
+		{name: "exceeds capacity", reserved: NUMANodeResources{1: {v1.ResourceMemory: gi, hugepages2Mi: 2 * gi}}},
+	}
+	for _, tc := range testCases {
+		if _, err := NewStaticPolicy(twoNUMANodes(), tc.reserved, fakeAffinity{}); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+// 每个NUMA节点的可分配量为总量减去该节点的预留
+func TestStaticPolicyStart(t *testing.T) {
+	_, s := newStaticPolicyForTest(t, fakeAffinity{})
+	expected := map[int]map[v1.ResourceName]MemoryTable{
+		0: {
+			v1.ResourceMemory: {TotalMemSize: 8 * gi, SystemReserved: gi, Allocatable: 7 * gi, Free: 7 * gi},
+			hugepages2Mi:      {TotalMemSize: gi, Allocatable: gi, Free: gi},
+		},
+		1: {
+			v1.ResourceMemory: {TotalMemSize: 8 * gi, SystemReserved: 2 * gi, Allocatable: 6 * gi, Free: 6 * gi},
+			hugepages2Mi:      {TotalMemSize: gi, SystemReserved: 512 * mi, Allocatable: 512 * mi, Free: 512 * mi},
+		},
+	}
+	machineState := s.GetMachineState()
+	for id, tables := range expected {
+		for name, table := range tables {
+			if got := *machineState[id].MemoryMap[name]; got != table {
+				t.Errorf("NUMA node %d %s: expected %+v, got %+v", id, name, table, got)
+			}
+		}
+	}
+}
+
+// 按拓扑管理器的hint分配，hint的节点空闲不足时使用节点最少的可用组合，都不满足时失败
+func TestStaticPolicyAllocate(t *testing.T) {
+	affinity := fakeAffinity{
+		"pod-1/app": numaAffinity(0),
+		"pod-2/app": numaAffinity(0),
+	}
+	policy, s := newStaticPolicyForTest(t, affinity)
+
+	c := memoryContainer("app", "4Gi", "512Mi")
+	pod := newMemoryPod("pod-1", nil, c)
+	if err := policy.Allocate(s, pod, &c); err != nil {
+		t.Fatal(err)
+	}
+	expectMemoryTable(t, s, 0, v1.ResourceMemory, 4*gi, 3*gi)
+	expectMemoryTable(t, s, 0, hugepages2Mi, 512*mi, 512*mi)
+	if node := s.GetMachineState()[0]; node.NumberOfAssignments != 2 || !reflect.DeepEqual(node.Cells, []int{0}) {
+		t.Errorf("expected 2 blocks on NUMA node 0, got %+v", node)
+	}
+
+	// 节点0只剩3Gi，改为分配在节点1上
+	c = memoryContainer("app", "4Gi", "")
+	pod = newMemoryPod("pod-2", nil, c)
+	if err := policy.Allocate(s, pod, &c); err != nil {
+		t.Fatal(err)
+	}
+	if blocks := s.GetMemoryBlocks("pod-2", "app"); !reflect.DeepEqual(blocks, []Block{{NUMAAffinity: []int{1}, Type: v1.ResourceMemory, Size: 4 * gi}}) {
+		t.Errorf("expected 4Gi on NUMA node 1, got %+v", blocks)
+	}
+	expectMemoryTable(t, s, 1, v1.ResourceMemory, 4*gi, 2*gi)
+
+	// 两个节点的大页都不够1Gi，节点0已经单独分配过，不能和节点1组合
+	c = memoryContainer("app", "1Gi", "1Gi")
+	pod = newMemoryPod("pod-3", nil, c)
+	if err := policy.Allocate(s, pod, &c); err == nil {
+		t.Errorf("expected error when no NUMA nodes have enough hugepages")
+	}
+	if blocks := s.GetMemoryBlocks("pod-3", "app"); blocks != nil {
+		t.Errorf("expected nothing allocated, got %+v", blocks)
+	}
+
+	// 非Guaranteed的pod不分配
+	c = memoryContainer("app", "1Gi", "")
+	c.Resources.Limits = nil
+	pod = newMemoryPod("pod-4", nil, c)
+	if err := policy.Allocate(s, pod, &c); err != nil || s.GetMemoryBlocks("pod-4", "app") != nil {
+		t.Errorf("expected burstable pod not to be allocated, got %v", err)
+	}
+
+	// 归还后节点0恢复
+	if err := policy.RemoveContainer(s, "pod-1", "app"); err != nil {
+		t.Fatal(err)
+	}
+	expectMemoryTable(t, s, 0, v1.ResourceMemory, 0, 7*gi)
+	expectMemoryTable(t, s, 0, hugepages2Mi, 0, gi)
+	if node := s.GetMachineState()[0]; node.NumberOfAssignments != 0 {
+		t.Errorf("expected no assignments on NUMA node 0, got %+v", node)
+	}
+}
+
+// 单个节点不够时跨节点分配，这组节点之后只能整体分配
+func TestStaticPolicyAllocateCrossNUMA(t *testing.T) {
+	policy, s := newStaticPolicyForTest(t, fakeAffinity{"pod-2/app": numaAffinity(1)})
+
+	c := memoryContainer("app", "10Gi", "")
+	pod := newMemoryPod("pod-1", nil, c)
+	hints := policy.GetTopologyHints(s, pod, &c)
+	expectedHints := []topologymanager.TopologyHint{numaAffinity(0, 1)}
+	if !reflect.DeepEqual(hints[string(v1.ResourceMemory)], expectedHints) {
+		t.Errorf("expected hints %v, got %v", expectedHints, hints)
+	}
+	if err := policy.Allocate(s, pod, &c); err != nil {
+		t.Fatal(err)
+	}
+	// 按节点顺序先用完节点0
+	expectMemoryTable(t, s, 0, v1.ResourceMemory, 7*gi, 0)
+	expectMemoryTable(t, s, 1, v1.ResourceMemory, 3*gi, 3*gi)
+
+	// hint为节点1，但节点1已经和节点0分在一组
+	c = memoryContainer("app", "1Gi", "")
+	pod = newMemoryPod("pod-2", nil, c)
+	if err := policy.Allocate(s, pod, &c); err != nil {
+		t.Fatal(err)
+	}
+	if blocks := s.GetMemoryBlocks("pod-2", "app"); !reflect.DeepEqual(blocks[0].NUMAAffinity, []int{0, 1}) {
+		t.Errorf("expected the NUMA node group [0 1], got %+v", blocks)
+	}
+	expectMemoryTable(t, s, 1, v1.ResourceMemory, 4*gi, 2*gi)
+
+	// 已经分配的容器按内存块返回hint
+	hints = policy.GetTopologyHints(s, pod, &c)
+	if !reflect.DeepEqual(hints[string(v1.ResourceMemory)], expectedHints) {
+		t.Errorf("expected hints of the allocated blocks %v, got %v", expectedHints, hints)
+	}
+}
+
+// 已经退出的init容器的内存给业务容器复用，sidecar的内存不能复用
+func TestStaticPolicyReuseInitContainerMemory(t *testing.T) {
+	restartPolicy := v1.ContainerRestartPolicyAlways
+	sidecar := memoryContainer("sidecar", "1Gi", "")
+	sidecar.RestartPolicy = &restartPolicy
+	initContainers := []v1.Container{memoryContainer("init", "2Gi", ""), sidecar}
+	app := memoryContainer("app", "3Gi", "")
+	pod := newMemoryPod("pod", initContainers, app)
+	affinity := fakeAffinity{}
+	for _, name := range []string{"init", "sidecar", "app"} {
+		affinity["pod/"+name] = numaAffinity(0)
+	}
+	policy, s := newStaticPolicyForTest(t, affinity)
+
+	for _, c := range append(initContainers, app) {
+		if err := policy.Allocate(s, pod, &c); err != nil {
+			t.Fatalf("failed to allocate %s: %v", c.Name, err)
+		}
+	}
+	// init的2Gi被复用，只需要再预留1Gi
+	expectMemoryTable(t, s, 0, v1.ResourceMemory, 4*gi, 3*gi)
+	if blocks := s.GetMemoryBlocks("pod", "init"); blocks[0].Size != 0 {
+		t.Errorf("expected the memory of init to be reused, got %+v", blocks)
+	}
+	if blocks := s.GetMemoryBlocks("pod", "sidecar"); blocks[0].Size != gi {
+		t.Errorf("expected the memory of sidecar to be kept, got %+v", blocks)
+	}
+}
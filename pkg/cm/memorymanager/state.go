@@ -0,0 +1,251 @@
+package memorymanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MemoryTable NUMA节点上一种内存资源的使用情况，单位字节
+// Allocatable = TotalMemSize - SystemReserved，Free = Allocatable - Reserved
+// pkg/kubelet/cm/memorymanager/state/state.go
+type MemoryTable struct {
+	TotalMemSize   uint64 `json:"total"`
+	SystemReserved uint64 `json:"systemReserved"`
+	Allocatable    uint64 `json:"allocatable"`
+	Reserved       uint64 `json:"reserved"`
+	Free           uint64 `json:"free"`
+}
+
+// NUMANodeState NUMA节点的内存和大页
+type NUMANodeState struct {
+	// 分配在该节点上的内存块数
+	NumberOfAssignments int                              `json:"numberOfAssignments"`
+	MemoryMap           map[v1.ResourceName]*MemoryTable `json:"memoryMap"`
+	// 和该节点一起分配的NUMA节点，跨节点分配的一组节点只能整体分配给容器
+	Cells []int `json:"cells"`
+}
+
+// Clone 深拷贝
+func (this *NUMANodeState) Clone() *NUMANodeState {
+	ret := &NUMANodeState{
+		NumberOfAssignments: this.NumberOfAssignments,
+		MemoryMap:           make(map[v1.ResourceName]*MemoryTable, len(this.MemoryMap)),
+		Cells:               append([]int{}, this.Cells...),
+	}
+	for name, table := range this.MemoryMap {
+		copied := *table
+		ret.MemoryMap[name] = &copied
+	}
+	return ret
+}
+
+// NUMANodeMap NUMA节点编号 -> 节点状态
+type NUMANodeMap map[int]*NUMANodeState
+
+// Clone 深拷贝
+func (this NUMANodeMap) Clone() NUMANodeMap {
+	ret := make(NUMANodeMap, len(this))
+	for id, node := range this {
+		ret[id] = node.Clone()
+	}
+	return ret
+}
+
+// Block 分配给容器的一块内存，Size在NUMAAffinity中的节点上按顺序分配
+type Block struct {
+	NUMAAffinity []int           `json:"numaAffinity"`
+	Type         v1.ResourceName `json:"type"`
+	Size         uint64          `json:"size"`
+}
+
+// ContainerMemoryAssignments pod uid -> 容器名 -> 分配的内存块
+type ContainerMemoryAssignments map[string]map[string][]Block
+
+// Clone 深拷贝
+func (this ContainerMemoryAssignments) Clone() ContainerMemoryAssignments {
+	ret := make(ContainerMemoryAssignments, len(this))
+	for pod := range this {
+		ret[pod] = make(map[string][]Block, len(this[pod]))
+		for container, blocks := range this[pod] {
+			ret[pod][container] = cloneBlocks(blocks)
+		}
+	}
+	return ret
+}
+
+func cloneBlocks(blocks []Block) []Block {
+	ret := make([]Block, 0, len(blocks))
+	for _, b := range blocks {
+		ret = append(ret, Block{NUMAAffinity: append([]int{}, b.NUMAAffinity...), Type: b.Type, Size: b.Size})
+	}
+	return ret
+}
+
+// memoryManagerCheckpoint 检查点文件的内容，checksum为其余字段的哈希，用于发现文件损坏
+type memoryManagerCheckpoint struct {
+	PolicyName   string                     `json:"policyName"`
+	MachineState NUMANodeMap                `json:"machineState"`
+	Entries      ContainerMemoryAssignments `json:"entries,omitempty"`
+	Checksum     uint64                     `json:"checksum"`
+}
+
+func (this *memoryManagerCheckpoint) computeChecksum() (uint64, error) {
+	copied := *this
+	copied.Checksum = 0
+	data, err := json.Marshal(copied)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64(), nil
+}
+
+// State NUMA节点的内存使用情况和容器的内存块，每次修改后写入检查点文件，kubelet重启后恢复
+// pkg/kubelet/cm/memorymanager/state/state_checkpoint.go
+type State struct {
+	lock           sync.RWMutex
+	policyName     string
+	checkpointPath string
+	machineState   NUMANodeMap
+	assignments    ContainerMemoryAssignments
+}
+
+// NewCheckpointState 从检查点文件恢复状态，文件不存在时为空状态，策略和检查点中的不一致时返回错误
+func NewCheckpointState(stateDir, checkpointName, policyName string) (*State, error) {
+	state := &State{
+		policyName:     policyName,
+		checkpointPath: filepath.Join(stateDir, checkpointName),
+		machineState:   NUMANodeMap{},
+		assignments:    ContainerMemoryAssignments{},
+	}
+	if err := state.restoreState(); err != nil {
+		return nil, fmt.Errorf("could not restore state from checkpoint: %v, please drain this node and delete the memory manager checkpoint file %q before restarting Kubelet",
+			err, state.checkpointPath)
+	}
+	return state, nil
+}
+
+func (this *State) restoreState() error {
+	data, err := os.ReadFile(this.checkpointPath)
+	if os.IsNotExist(err) {
+		return this.storeState()
+	}
+	if err != nil {
+		return err
+	}
+	checkpoint := &memoryManagerCheckpoint{}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return fmt.Errorf("checkpoint is corrupted: %v", err)
+	}
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return err
+	}
+	if checksum != checkpoint.Checksum {
+		return fmt.Errorf("checkpoint is corrupted: checksum mismatch")
+	}
+	if checkpoint.PolicyName != this.policyName {
+		return fmt.Errorf("configured policy %q differs from state checkpoint policy %q", this.policyName, checkpoint.PolicyName)
+	}
+	if checkpoint.MachineState != nil {
+		this.machineState = checkpoint.MachineState
+	}
+	if checkpoint.Entries != nil {
+		this.assignments = checkpoint.Entries
+	}
+	klog.V(2).InfoS("State checkpoint: restored memory manager state from checkpoint")
+	return nil
+}
+
+// storeState 先写临时文件再重命名，避免写入过程中重启导致文件损坏
+func (this *State) storeState() error {
+	checkpoint := &memoryManagerCheckpoint{
+		PolicyName:   this.policyName,
+		MachineState: this.machineState,
+		Entries:      this.assignments,
+	}
+	checksum, err := checkpoint.computeChecksum()
+	if err != nil {
+		return err
+	}
+	checkpoint.Checksum = checksum
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmpPath := this.checkpointPath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, this.checkpointPath)
+}
+
+func (this *State) store() {
+	if err := this.storeState(); err != nil {
+		klog.ErrorS(err, "Failed to store memory manager state checkpoint", "path", this.checkpointPath)
+	}
+}
+
+// GetMachineState NUMA节点的内存使用情况
+func (this *State) GetMachineState() NUMANodeMap {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.machineState.Clone()
+}
+
+// GetMemoryBlocks 容器分配的内存块
+func (this *State) GetMemoryBlocks(podUID, containerName string) []Block {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if blocks, ok := this.assignments[podUID][containerName]; ok {
+		return cloneBlocks(blocks)
+	}
+	return nil
+}
+
+// GetMemoryAssignments 所有容器分配的内存块
+func (this *State) GetMemoryAssignments() ContainerMemoryAssignments {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.assignments.Clone()
+}
+
+// SetMachineState 设置NUMA节点的内存使用情况
+func (this *State) SetMachineState(machineState NUMANodeMap) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.machineState = machineState.Clone()
+	this.store()
+}
+
+// SetMemoryBlocks 设置容器分配的内存块
+func (this *State) SetMemoryBlocks(podUID, containerName string, blocks []Block) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.assignments[podUID]; !ok {
+		this.assignments[podUID] = map[string][]Block{}
+	}
+	this.assignments[podUID][containerName] = cloneBlocks(blocks)
+	this.store()
+}
+
+// Delete 删除容器的内存块记录，内存需要由调用方归还NUMA节点
+func (this *State) Delete(podUID, containerName string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.assignments[podUID]; !ok {
+		return
+	}
+	delete(this.assignments[podUID], containerName)
+	if len(this.assignments[podUID]) == 0 {
+		delete(this.assignments, podUID)
+	}
+	this.store()
+}
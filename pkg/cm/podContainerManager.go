@@ -0,0 +1,126 @@
+package cm
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/qos"
+	"strings"
+)
+
+// pod cgroup名字的前缀，后面是pod的uid
+const podCgroupNamePrefix = "pod"
+
+// PodContainerManager 管理pod级别的cgroup
+// pkg/kubelet/cm/pod_container_manager_linux.go
+type PodContainerManager interface {
+	// Exists pod的cgroup是否存在
+	Exists(pod *v1.Pod) bool
+	// EnsureExists 创建pod的cgroup并按pod的资源设置限制
+	EnsureExists(pod *v1.Pod) error
+	// GetPodContainerName pod的cgroup名字，以及驱动格式的名字
+	GetPodContainerName(pod *v1.Pod) (CgroupName, string)
+	// Destroy 删除pod的cgroup
+	Destroy(name CgroupName) error
+	// GetAllPodsFromCgroups 从QoS cgroup中找到所有pod的cgroup
+	GetAllPodsFromCgroups() (map[types.UID]CgroupName, error)
+}
+
+// podContainerManagerImpl Guaranteed pod的cgroup在kubepods下，其他在对应QoS等级的cgroup下
+type podContainerManagerImpl struct {
+	qosContainersInfo QOSContainersInfo
+	cgroupManager     *CgroupManager
+	// 每个pod的最大进程数，小于等于0表示不限制
+	podPidsLimit      int64
+	enforceCPULimits  bool
+	cpuCFSQuotaPeriod uint64
+}
+
+func (this *podContainerManagerImpl) Exists(pod *v1.Pod) bool {
+	name, _ := this.GetPodContainerName(pod)
+	return this.cgroupManager.Exists(name)
+}
+
+func (this *podContainerManagerImpl) EnsureExists(pod *v1.Pod) error {
+	name, _ := this.GetPodContainerName(pod)
+	if this.cgroupManager.Exists(name) {
+		return nil
+	}
+	config := &CgroupConfig{
+		Name:               name,
+		ResourceParameters: ResourceConfigForPod(pod, this.enforceCPULimits, this.cpuCFSQuotaPeriod),
+	}
+	if this.podPidsLimit > 0 {
+		config.ResourceParameters.PidsLimit = &this.podPidsLimit
+	}
+	if err := this.cgroupManager.Create(config); err != nil {
+		return fmt.Errorf("failed to create container for %v : %v", name, err)
+	}
+	return nil
+}
+
+func (this *podContainerManagerImpl) GetPodContainerName(pod *v1.Pod) (CgroupName, string) {
+	var parent CgroupName
+	switch qos.GetPodQOS(pod) {
+	case v1.PodQOSGuaranteed:
+		parent = this.qosContainersInfo.Guaranteed
+	case v1.PodQOSBurstable:
+		parent = this.qosContainersInfo.Burstable
+	default:
+		parent = this.qosContainersInfo.BestEffort
+	}
+	name := NewCgroupName(parent, podCgroupNamePrefix+string(pod.UID))
+	return name, this.cgroupManager.Name(name)
+}
+
+func (this *podContainerManagerImpl) Destroy(name CgroupName) error {
+	klog.V(3).InfoS("Destroying pod cgroup", "cgroupName", this.cgroupManager.Name(name))
+	return this.cgroupManager.Destroy(name)
+}
+
+func (this *podContainerManagerImpl) GetAllPodsFromCgroups() (map[types.UID]CgroupName, error) {
+	foundPods := map[types.UID]CgroupName{}
+	qosContainers := []CgroupName{
+		this.qosContainersInfo.Guaranteed,
+		this.qosContainersInfo.Burstable,
+		this.qosContainersInfo.BestEffort,
+	}
+	for _, qosContainer := range qosContainers {
+		children, err := this.cgroupManager.Children(qosContainer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the cgroup directory %q: %v", this.cgroupManager.Name(qosContainer), err)
+		}
+		for _, child := range children {
+			last := child[len(child)-1]
+			if !strings.HasPrefix(last, podCgroupNamePrefix) {
+				continue
+			}
+			foundPods[types.UID(strings.TrimPrefix(last, podCgroupNamePrefix))] = child
+		}
+	}
+	return foundPods, nil
+}
+
+// podContainerManagerNoop 没有开启cgroupsPerQOS时pod不创建自己的cgroup
+type podContainerManagerNoop struct{}
+
+func (this *podContainerManagerNoop) Exists(_ *v1.Pod) bool {
+	return true
+}
+
+func (this *podContainerManagerNoop) EnsureExists(_ *v1.Pod) error {
+	return nil
+}
+
+func (this *podContainerManagerNoop) GetPodContainerName(_ *v1.Pod) (CgroupName, string) {
+	return RootCgroupName, ""
+}
+
+func (this *podContainerManagerNoop) Destroy(_ CgroupName) error {
+	return nil
+}
+
+func (this *podContainerManagerNoop) GetAllPodsFromCgroups() (map[types.UID]CgroupName, error) {
+	return map[types.UID]CgroupName{}, nil
+}
@@ -0,0 +1,220 @@
+package cm
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"testing"
+)
+
+func resourceList(cpu, memory string) v1.ResourceList {
+	ret := v1.ResourceList{}
+	if cpu != "" {
+		ret[v1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		ret[v1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return ret
+}
+
+func newCgroupTestPod(uid string, containers ...v1.ResourceRequirements) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)}}
+	for i, resources := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: string(rune('a' + i)), Resources: resources})
+	}
+	return pod
+}
+
+func newTestPodContainerManager(t *testing.T, driver string, enforceCPULimits bool) (*podContainerManagerImpl, *qosContainerManager, string) {
+	cgroupManager, root := newTestCgroupManager(t, driver)
+	qosManager := newQOSContainerManager(cgroupManager, NewCgroupName(RootCgroupName, defaultNodeAllocatableCgroupName),
+		func() []*v1.Pod { return nil })
+	return &podContainerManagerImpl{
+		qosContainersInfo: qosManager.GetQOSContainersInfo(),
+		cgroupManager:     cgroupManager,
+		podPidsLimit:      1024,
+		enforceCPULimits:  enforceCPULimits,
+		cpuCFSQuotaPeriod: QuotaPeriod,
+	}, qosManager, root
+}
+
+// 各QoS等级的pod cgroup的位置和资源限制，没有写入的文件为空字符串
+func TestPodContainerManagerEnsureExists(t *testing.T) {
+	testCases := []struct {
+		name             string
+		pod              *v1.Pod
+		enforceCPULimits bool
+		cgroupfsDir      string
+		systemdDir       string
+		expected         map[string]string
+	}{
+		{
+			name: "guaranteed",
+			pod: newCgroupTestPod("guaranteed-1",
+				v1.ResourceRequirements{Requests: resourceList("500m", "256Mi"), Limits: resourceList("500m", "256Mi")}),
+			enforceCPULimits: true,
+			cgroupfsDir:      "kubepods/podguaranteed-1",
+			systemdDir:       "kubepods.slice/kubepods-podguaranteed_1.slice",
+			expected: map[string]string{
+				"cpu.weight": "20",
+				"cpu.max":    "50000 100000",
+				"memory.max": "268435456",
+				"pids.max":   "1024",
+			},
+		},
+		{
+			name: "guaranteed without cpu limit enforcement",
+			pod: newCgroupTestPod("guaranteed-2",
+				v1.ResourceRequirements{Requests: resourceList("2", "1Gi"), Limits: resourceList("2", "1Gi")}),
+			cgroupfsDir: "kubepods/podguaranteed-2",
+			systemdDir:  "kubepods.slice/kubepods-podguaranteed_2.slice",
+			expected: map[string]string{
+				"cpu.weight": "79",
+				"cpu.max":    "max 100000",
+				"memory.max": "1073741824",
+				"pids.max":   "1024",
+			},
+		},
+		{
+			name: "burstable with all limits",
+			pod: newCgroupTestPod("burstable-1",
+				v1.ResourceRequirements{Requests: resourceList("250m", "128Mi"), Limits: resourceList("1", "512Mi")}),
+			enforceCPULimits: true,
+			cgroupfsDir:      "kubepods/burstable/podburstable-1",
+			systemdDir:       "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-podburstable_1.slice",
+			expected: map[string]string{
+				"cpu.weight": "10",
+				"cpu.max":    "100000 100000",
+				"memory.max": "536870912",
+				"pids.max":   "1024",
+			},
+		},
+		{
+			name: "burstable with a container without limits",
+			pod: newCgroupTestPod("burstable-2",
+				v1.ResourceRequirements{Requests: resourceList("250m", "128Mi"), Limits: resourceList("1", "512Mi")},
+				v1.ResourceRequirements{Requests: resourceList("250m", "")}),
+			enforceCPULimits: true,
+			cgroupfsDir:      "kubepods/burstable/podburstable-2",
+			systemdDir:       "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-podburstable_2.slice",
+			expected: map[string]string{
+				"cpu.weight": "20",
+				"cpu.max":    "",
+				"memory.max": "",
+				"pids.max":   "1024",
+			},
+		},
+		{
+			name:             "besteffort",
+			pod:              newCgroupTestPod("besteffort-1", v1.ResourceRequirements{}),
+			enforceCPULimits: true,
+			cgroupfsDir:      "kubepods/besteffort/podbesteffort-1",
+			systemdDir:       "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-podbesteffort_1.slice",
+			expected: map[string]string{
+				"cpu.weight": "1",
+				"cpu.max":    "",
+				"memory.max": "",
+				"pids.max":   "1024",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		for _, driver := range []string{CgroupDriverCgroupfs, CgroupDriverSystemd} {
+			t.Run(tc.name+"/"+driver, func(t *testing.T) {
+				manager, _, root := newTestPodContainerManager(t, driver, tc.enforceCPULimits)
+				dir := tc.cgroupfsDir
+				if driver == CgroupDriverSystemd {
+					dir = tc.systemdDir
+				}
+				if _, cgroupParent := manager.GetPodContainerName(tc.pod); cgroupParent != "/"+dir {
+					t.Errorf("expected cgroup parent %q, got %q", "/"+dir, cgroupParent)
+				}
+				if manager.Exists(tc.pod) {
+					t.Fatalf("pod cgroup should not exist before EnsureExists")
+				}
+				if err := manager.EnsureExists(tc.pod); err != nil {
+					t.Fatal(err)
+				}
+				if !manager.Exists(tc.pod) {
+					t.Fatalf("expected pod cgroup to exist")
+				}
+				for file, value := range tc.expected {
+					if got := readCgroupFile(t, root, dir, file); got != value {
+						t.Errorf("expected %s to be %q, got %q", file, value, got)
+					}
+				}
+			})
+		}
+	}
+}
+
+// 已经存在的pod cgroup不会被重新设置
+func TestPodContainerManagerEnsureExistsIsIdempotent(t *testing.T) {
+	manager, _, root := newTestPodContainerManager(t, CgroupDriverCgroupfs, true)
+	pod := newCgroupTestPod("pod-1", v1.ResourceRequirements{Requests: resourceList("1", "1Gi"), Limits: resourceList("1", "1Gi")})
+	if err := manager.EnsureExists(pod); err != nil {
+		t.Fatal(err)
+	}
+	pod.Spec.Containers[0].Resources = v1.ResourceRequirements{Requests: resourceList("2", "2Gi"), Limits: resourceList("2", "2Gi")}
+	if err := manager.EnsureExists(pod); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCgroupFile(t, root, "kubepods/podpod-1", "memory.max"); got != "1073741824" {
+		t.Errorf("expected memory.max to stay 1Gi, got %q", got)
+	}
+}
+
+func TestPodContainerManagerGetAllPodsFromCgroups(t *testing.T) {
+	for _, driver := range []string{CgroupDriverCgroupfs, CgroupDriverSystemd} {
+		t.Run(driver, func(t *testing.T) {
+			manager, qosManager, _ := newTestPodContainerManager(t, driver, true)
+			// 只创建QoS cgroup，不启动定期更新
+			stopCh := make(chan struct{})
+			close(stopCh)
+			if err := qosManager.Start(stopCh); err != nil {
+				t.Fatal(err)
+			}
+			pods := []*v1.Pod{
+				newCgroupTestPod("1111-aaaa", v1.ResourceRequirements{Requests: resourceList("1", "1Gi"), Limits: resourceList("1", "1Gi")}),
+				newCgroupTestPod("2222-bbbb", v1.ResourceRequirements{Requests: resourceList("100m", "")}),
+				newCgroupTestPod("3333-cccc", v1.ResourceRequirements{}),
+			}
+			expected := map[types.UID]CgroupName{}
+			for _, pod := range pods {
+				if err := manager.EnsureExists(pod); err != nil {
+					t.Fatal(err)
+				}
+				expected[pod.UID], _ = manager.GetPodContainerName(pod)
+			}
+			found, err := manager.GetAllPodsFromCgroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// kubepods下的burstable和besteffort不是pod
+			if !reflect.DeepEqual(found, expected) {
+				t.Errorf("expected %v, got %v", expected, found)
+			}
+
+			if err = manager.Destroy(expected["2222-bbbb"]); err != nil {
+				t.Fatal(err)
+			}
+			if found, err = manager.GetAllPodsFromCgroups(); err != nil || len(found) != 2 {
+				t.Errorf("expected 2 pods after destroy, got %v %v", found, err)
+			}
+		})
+	}
+}
+
+func TestNewPodContainerManagerNoop(t *testing.T) {
+	manager := (&ContainerManager{config: NodeConfig{CgroupsPerQOS: false}}).NewPodContainerManager()
+	pod := newCgroupTestPod("pod", v1.ResourceRequirements{})
+	if _, cgroupParent := manager.GetPodContainerName(pod); cgroupParent != "" {
+		t.Errorf("expected no cgroup parent without cgroupsPerQOS, got %q", cgroupParent)
+	}
+	if !manager.Exists(pod) || manager.EnsureExists(pod) != nil {
+		t.Errorf("expected noop pod container manager")
+	}
+}
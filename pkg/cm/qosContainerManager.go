@@ -0,0 +1,103 @@
+package cm
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/qos"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QoS cgroup的定期更新间隔，pod cgroup创建前也会更新
+const periodicQOSCgroupUpdateInterval = time.Minute
+
+// QOSContainersInfo 各QoS等级的cgroup，Guaranteed的pod直接放在kubepods下
+type QOSContainersInfo struct {
+	Guaranteed CgroupName
+	BestEffort CgroupName
+	Burstable  CgroupName
+}
+
+// qosContainerManager 在kubepods下创建burstable和besteffort的cgroup，并按pod的requests更新cpu权重
+// pkg/kubelet/cm/qos_container_manager_linux.go
+type qosContainerManager struct {
+	lock              sync.Mutex
+	qosContainersInfo QOSContainersInfo
+	cgroupManager     *CgroupManager
+	activePods        ActivePodsFunc
+}
+
+func newQOSContainerManager(cgroupManager *CgroupManager, cgroupRoot CgroupName, activePods ActivePodsFunc) *qosContainerManager {
+	return &qosContainerManager{
+		qosContainersInfo: QOSContainersInfo{
+			Guaranteed: cgroupRoot,
+			Burstable:  NewCgroupName(cgroupRoot, strings.ToLower(string(v1.PodQOSBurstable))),
+			BestEffort: NewCgroupName(cgroupRoot, strings.ToLower(string(v1.PodQOSBestEffort))),
+		},
+		cgroupManager: cgroupManager,
+		activePods:    activePods,
+	}
+}
+
+// Start 创建QoS的cgroup，besteffort只有最小的cpu权重，之后定期更新
+func (this *qosContainerManager) Start(stopCh <-chan struct{}) error {
+	minShares := uint64(MinShares)
+	qosClasses := map[v1.PodQOSClass]CgroupName{
+		v1.PodQOSBurstable:  this.qosContainersInfo.Burstable,
+		v1.PodQOSBestEffort: this.qosContainersInfo.BestEffort,
+	}
+	for qosClass, containerName := range qosClasses {
+		config := &CgroupConfig{Name: containerName, ResourceParameters: &ResourceConfig{}}
+		if qosClass == v1.PodQOSBestEffort {
+			config.ResourceParameters.CPUShares = &minShares
+		}
+		if err := this.cgroupManager.Create(config); err != nil {
+			return fmt.Errorf("failed to create top level %v QOS cgroup: %v", qosClass, err)
+		}
+	}
+
+	go wait.Until(func() {
+		if err := this.UpdateCgroups(); err != nil {
+			klog.InfoS("Failed to reserve QoS requests", "err", err)
+		}
+	}, periodicQOSCgroupUpdateInterval, stopCh)
+	return nil
+}
+
+// GetQOSContainersInfo 各QoS等级的cgroup
+func (this *qosContainerManager) GetQOSContainersInfo() QOSContainersInfo {
+	return this.qosContainersInfo
+}
+
+// UpdateCgroups burstable的cpu权重为所有burstable pod的cpu requests之和，besteffort为最小权重
+func (this *qosContainerManager) UpdateCgroups() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	burstablePodCPURequest := int64(0)
+	for _, pod := range this.activePods() {
+		if qos.GetPodQOS(pod) != v1.PodQOSBurstable {
+			continue
+		}
+		if request, found := qos.PodRequests(pod)[v1.ResourceCPU]; found {
+			burstablePodCPURequest += request.MilliValue()
+		}
+	}
+	burstableShares := MilliCPUToShares(burstablePodCPURequest)
+	bestEffortShares := uint64(MinShares)
+
+	configs := []*CgroupConfig{
+		{Name: this.qosContainersInfo.Burstable, ResourceParameters: &ResourceConfig{CPUShares: &burstableShares}},
+		{Name: this.qosContainersInfo.BestEffort, ResourceParameters: &ResourceConfig{CPUShares: &bestEffortShares}},
+	}
+	for _, config := range configs {
+		if err := this.cgroupManager.Update(config); err != nil {
+			return fmt.Errorf("failed to update QoS cgroup %q: %v", this.cgroupManager.Name(config.Name), err)
+		}
+	}
+	klog.V(4).InfoS("Updated QoS cgroup configuration")
+	return nil
+}
@@ -0,0 +1,76 @@
+package cm
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"sync"
+	"testing"
+)
+
+func TestQOSContainerManager(t *testing.T) {
+	testCases := []struct {
+		driver        string
+		guaranteedDir string
+		burstableDir  string
+		besteffortDir string
+	}{
+		{
+			driver:        CgroupDriverCgroupfs,
+			guaranteedDir: "kubepods",
+			burstableDir:  "kubepods/burstable",
+			besteffortDir: "kubepods/besteffort",
+		},
+		{
+			driver:        CgroupDriverSystemd,
+			guaranteedDir: "kubepods.slice",
+			burstableDir:  "kubepods.slice/kubepods-burstable.slice",
+			besteffortDir: "kubepods.slice/kubepods-besteffort.slice",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.driver, func(t *testing.T) {
+			cgroupManager, root := newTestCgroupManager(t, tc.driver)
+			var lock sync.Mutex
+			activePods := []*v1.Pod{}
+			manager := newQOSContainerManager(cgroupManager, NewCgroupName(RootCgroupName, defaultNodeAllocatableCgroupName),
+				func() []*v1.Pod {
+					lock.Lock()
+					defer lock.Unlock()
+					return activePods
+				})
+			// Guaranteed的pod直接放在kubepods下
+			info := manager.GetQOSContainersInfo()
+			if got := cgroupManager.Name(info.Guaranteed); got != "/"+tc.guaranteedDir {
+				t.Errorf("expected guaranteed cgroup %q, got %q", "/"+tc.guaranteedDir, got)
+			}
+			// 关闭的stopCh不启动定期更新，避免和测试中的读写并发
+			stopCh := make(chan struct{})
+			close(stopCh)
+			if err := manager.Start(stopCh); err != nil {
+				t.Fatal(err)
+			}
+			if got := readCgroupFile(t, root, tc.besteffortDir, "cpu.weight"); got != "1" {
+				t.Errorf("expected besteffort cpu.weight 1, got %q", got)
+			}
+
+			// burstable的权重为所有burstable pod的cpu requests之和，其他QoS等级的pod不计入
+			lock.Lock()
+			activePods = []*v1.Pod{
+				newCgroupTestPod("burstable-1", v1.ResourceRequirements{Requests: resourceList("500m", "")}),
+				newCgroupTestPod("burstable-2", v1.ResourceRequirements{Requests: resourceList("1500m", "1Gi")}),
+				newCgroupTestPod("guaranteed", v1.ResourceRequirements{Requests: resourceList("4", "1Gi"), Limits: resourceList("4", "1Gi")}),
+				newCgroupTestPod("besteffort", v1.ResourceRequirements{}),
+			}
+			lock.Unlock()
+			if err := manager.UpdateCgroups(); err != nil {
+				t.Fatal(err)
+			}
+			// 2核对应2048 shares
+			if got := readCgroupFile(t, root, tc.burstableDir, "cpu.weight"); got != "79" {
+				t.Errorf("expected burstable cpu.weight 79, got %q", got)
+			}
+			if got := readCgroupFile(t, root, tc.besteffortDir, "cpu.weight"); got != "1" {
+				t.Errorf("expected besteffort cpu.weight 1, got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package topologymanager
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// BitMask NUMA节点的集合，第i位表示NUMA节点i，最多支持64个节点
+// pkg/kubelet/cm/topologymanager/bitmask/bitmask.go
+type BitMask uint64
+
+// NewBitMask 包含指定NUMA节点的集合
+func NewBitMask(bits ...int) (BitMask, error) {
+	var mask BitMask
+	for _, b := range bits {
+		if b < 0 || b >= 64 {
+			return 0, fmt.Errorf("bit number must be in range 0-63")
+		}
+		mask |= 1 << uint64(b)
+	}
+	return mask, nil
+}
+
+// And 交集
+func And(first BitMask, masks ...BitMask) BitMask {
+	for _, m := range masks {
+		first &= m
+	}
+	return first
+}
+
+// Or 并集
+func Or(first BitMask, masks ...BitMask) BitMask {
+	for _, m := range masks {
+		first |= m
+	}
+	return first
+}
+
+// IsEmpty 不包含任何节点
+func (this BitMask) IsEmpty() bool {
+	return this == 0
+}
+
+// IsSet 是否包含节点
+func (this BitMask) IsSet(bit int) bool {
+	return bit >= 0 && bit < 64 && this&(1<<uint64(bit)) != 0
+}
+
+// IsEqual 包含的节点相同
+func (this BitMask) IsEqual(mask BitMask) bool {
+	return this == mask
+}
+
+// Count 包含的节点数
+func (this BitMask) Count() int {
+	return bits.OnesCount64(uint64(this))
+}
+
+// GetBits 包含的节点，从小到大排序
+func (this BitMask) GetBits() []int {
+	ret := []int{}
+	for i := 0; i < 64; i++ {
+		if this.IsSet(i) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// IsNarrowerThan 节点数更少，节点数相同时编号更小的更窄
+func (this BitMask) IsNarrowerThan(mask BitMask) bool {
+	if this.Count() == mask.Count() {
+		return this < mask
+	}
+	return this.Count() < mask.Count()
+}
+
+func (this BitMask) String() string {
+	ids := []string{}
+	for _, b := range this.GetBits() {
+		ids = append(ids, strconv.Itoa(b))
+	}
+	return "[" + strings.Join(ids, " ") + "]"
+}
+
+// IterateBitMasks 遍历bits的所有非空子集
+func IterateBitMasks(bits []int, callback func(BitMask)) {
+	var iterate func(start int, mask BitMask)
+	iterate = func(start int, mask BitMask) {
+		for i := start; i < len(bits); i++ {
+			next := mask | 1<<uint64(bits[i])
+			callback(next)
+			iterate(i+1, next)
+		}
+	}
+	iterate(0, 0)
+}
@@ -0,0 +1,202 @@
+package topologymanager
+
+const (
+	// PolicyNone 不做NUMA对齐
+	PolicyNone = "none"
+	// PolicyBestEffort 尽量对齐，无法对齐时仍然接受pod
+	PolicyBestEffort = "best-effort"
+	// PolicyRestricted 只接受所有资源都能按最优的NUMA节点分配的pod
+	PolicyRestricted = "restricted"
+	// PolicySingleNUMANode 只接受所有资源都能在同一个NUMA节点上分配的pod
+	PolicySingleNUMANode = "single-numa-node"
+)
+
+// TopologyHint 资源可以分配的NUMA节点，NUMANodeAffinity为空表示没有偏好
+// Preferred表示这是满足请求的最少的NUMA节点
+// pkg/kubelet/cm/topologymanager/topology_manager.go
+type TopologyHint struct {
+	NUMANodeAffinity BitMask
+	Preferred        bool
+}
+
+// IsEqual 节点和偏好都相同
+func (this TopologyHint) IsEqual(hint TopologyHint) bool {
+	return this.Preferred == hint.Preferred && this.NUMANodeAffinity.IsEqual(hint.NUMANodeAffinity)
+}
+
+// LessThan 偏好的hint更优，偏好相同时节点更少的更优
+func (this TopologyHint) LessThan(other TopologyHint) bool {
+	if this.Preferred != other.Preferred {
+		return this.Preferred
+	}
+	return this.NUMANodeAffinity.IsNarrowerThan(other.NUMANodeAffinity)
+}
+
+// Policy 合并各个资源的hint，并决定是否接受pod
+// pkg/kubelet/cm/topologymanager/policy.go
+type Policy interface {
+	Name() string
+	// Merge providersHints中每一项是一个provider按资源名返回的hint，返回合并后最优的hint和是否接受
+	Merge(providersHints []map[string][]TopologyHint) (TopologyHint, bool)
+}
+
+// NewPolicy numaNodes为节点上的所有NUMA节点
+func NewPolicy(name string, numaNodes []int) (Policy, bool) {
+	switch name {
+	case PolicyNone:
+		return &nonePolicy{}, true
+	case PolicyBestEffort:
+		return &bestEffortPolicy{numaNodes: numaNodes}, true
+	case PolicyRestricted:
+		return &restrictedPolicy{bestEffortPolicy{numaNodes: numaNodes}}, true
+	case PolicySingleNUMANode:
+		return &singleNumaNodePolicy{numaNodes: numaNodes}, true
+	}
+	return nil, false
+}
+
+type nonePolicy struct{}
+
+func (this *nonePolicy) Name() string {
+	return PolicyNone
+}
+
+func (this *nonePolicy) Merge(_ []map[string][]TopologyHint) (TopologyHint, bool) {
+	return TopologyHint{}, true
+}
+
+// bestEffortPolicy 选择最优的hint，总是接受pod
+type bestEffortPolicy struct {
+	numaNodes []int
+}
+
+func (this *bestEffortPolicy) Name() string {
+	return PolicyBestEffort
+}
+
+func (this *bestEffortPolicy) Merge(providersHints []map[string][]TopologyHint) (TopologyHint, bool) {
+	filteredHints := filterProvidersHints(providersHints)
+	return mergeFilteredHints(this.numaNodes, filteredHints), true
+}
+
+// restrictedPolicy 最优的hint不是preferred时拒绝pod
+type restrictedPolicy struct {
+	bestEffortPolicy
+}
+
+func (this *restrictedPolicy) Name() string {
+	return PolicyRestricted
+}
+
+func (this *restrictedPolicy) Merge(providersHints []map[string][]TopologyHint) (TopologyHint, bool) {
+	hint, _ := this.bestEffortPolicy.Merge(providersHints)
+	return hint, hint.Preferred
+}
+
+// singleNumaNodePolicy 只考虑单个NUMA节点的hint
+type singleNumaNodePolicy struct {
+	numaNodes []int
+}
+
+func (this *singleNumaNodePolicy) Name() string {
+	return PolicySingleNUMANode
+}
+
+func (this *singleNumaNodePolicy) Merge(providersHints []map[string][]TopologyHint) (TopologyHint, bool) {
+	filteredHints := filterProvidersHints(providersHints)
+	singleNumaHints := make([][]TopologyHint, 0, len(filteredHints))
+	for _, resourceHints := range filteredHints {
+		singleNumaHints = append(singleNumaHints, filterSingleNumaHints(resourceHints))
+	}
+	bestHint := mergeFilteredHints(this.numaNodes, singleNumaHints)
+	// 没有任何资源有偏好时合并结果为所有节点，不对应单个NUMA节点
+	defaultAffinity, _ := NewBitMask(this.numaNodes...)
+	if bestHint.NUMANodeAffinity.IsEqual(defaultAffinity) && len(this.numaNodes) > 1 {
+		bestHint = TopologyHint{Preferred: bestHint.Preferred}
+	}
+	return bestHint, bestHint.Preferred
+}
+
+// filterSingleNumaHints 只保留没有偏好或只有一个NUMA节点的preferred hint
+func filterSingleNumaHints(allResourcesHints []TopologyHint) []TopologyHint {
+	var filtered []TopologyHint
+	for _, hint := range allResourcesHints {
+		if hint.NUMANodeAffinity.IsEmpty() && hint.Preferred {
+			filtered = append(filtered, hint)
+		}
+		if hint.NUMANodeAffinity.Count() == 1 && hint.Preferred {
+			filtered = append(filtered, hint)
+		}
+	}
+	return filtered
+}
+
+// filterProvidersHints 按资源展开各个provider的hint
+// provider没有返回任何资源时表示不关心NUMA，资源的hint为空列表时表示无法满足
+func filterProvidersHints(providersHints []map[string][]TopologyHint) [][]TopologyHint {
+	var allProviderHints [][]TopologyHint
+	for _, hints := range providersHints {
+		if len(hints) == 0 {
+			allProviderHints = append(allProviderHints, []TopologyHint{{Preferred: true}})
+			continue
+		}
+		for resource := range hints {
+			if hints[resource] == nil {
+				allProviderHints = append(allProviderHints, []TopologyHint{{Preferred: true}})
+				continue
+			}
+			if len(hints[resource]) == 0 {
+				allProviderHints = append(allProviderHints, []TopologyHint{{Preferred: false}})
+				continue
+			}
+			allProviderHints = append(allProviderHints, hints[resource])
+		}
+	}
+	return allProviderHints
+}
+
+// mergeFilteredHints 遍历每个资源各取一个hint的所有组合，节点取交集，偏好取与，选出最优的组合
+// 没有有效组合时为所有节点且不是preferred
+func mergeFilteredHints(numaNodes []int, filteredHints [][]TopologyHint) TopologyHint {
+	defaultAffinity, _ := NewBitMask(numaNodes...)
+	bestHint := TopologyHint{NUMANodeAffinity: defaultAffinity, Preferred: false}
+	iterateAllProviderTopologyHints(filteredHints, func(permutation []TopologyHint) {
+		mergedHint := mergePermutation(defaultAffinity, permutation)
+		if mergedHint.NUMANodeAffinity.IsEmpty() {
+			return
+		}
+		if mergedHint.LessThan(bestHint) {
+			bestHint = mergedHint
+		}
+	})
+	return bestHint
+}
+
+// mergePermutation 没有偏好的hint按所有节点计算
+func mergePermutation(defaultAffinity BitMask, permutation []TopologyHint) TopologyHint {
+	preferred := true
+	merged := defaultAffinity
+	for _, hint := range permutation {
+		if !hint.NUMANodeAffinity.IsEmpty() {
+			merged = And(merged, hint.NUMANodeAffinity)
+		}
+		if !hint.Preferred {
+			preferred = false
+		}
+	}
+	return TopologyHint{NUMANodeAffinity: merged, Preferred: preferred}
+}
+
+func iterateAllProviderTopologyHints(allProviderHints [][]TopologyHint, callback func([]TopologyHint)) {
+	var iterate func(i int, accum []TopologyHint)
+	iterate = func(i int, accum []TopologyHint) {
+		if i == len(allProviderHints) {
+			callback(accum)
+			return
+		}
+		for _, hint := range allProviderHints[i] {
+			iterate(i+1, append(accum, hint))
+		}
+	}
+	iterate(0, []TopologyHint{})
+}
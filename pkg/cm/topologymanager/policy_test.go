@@ -0,0 +1,126 @@
+package topologymanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mask(bits ...int) BitMask {
+	m, _ := NewBitMask(bits...)
+	return m
+}
+
+func TestFilterSingleNumaHints(t *testing.T) {
+	hints := []TopologyHint{
+		{Preferred: true},
+		{Preferred: false},
+		{NUMANodeAffinity: mask(0), Preferred: true},
+		{NUMANodeAffinity: mask(0), Preferred: false},
+		{NUMANodeAffinity: mask(1), Preferred: true},
+		{NUMANodeAffinity: mask(0, 1), Preferred: true},
+		{NUMANodeAffinity: mask(0, 1), Preferred: false},
+	}
+	expected := []TopologyHint{
+		{Preferred: true},
+		{NUMANodeAffinity: mask(0), Preferred: true},
+		{NUMANodeAffinity: mask(1), Preferred: true},
+	}
+	if got := filterSingleNumaHints(hints); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// 两个NUMA节点上各个策略合并cpu、内存和设备的hint
+func TestPolicyMerge(t *testing.T) {
+	type result struct {
+		hint  TopologyHint
+		admit bool
+	}
+	testCases := []struct {
+		name           string
+		providersHints []map[string][]TopologyHint
+		bestEffort     result
+		restricted     result
+		singleNumaNode result
+	}{
+		{
+			name:           "no provider cares about NUMA",
+			providersHints: []map[string][]TopologyHint{nil, {"example.com/gpu": nil}},
+			bestEffort:     result{hint: TopologyHint{NUMANodeAffinity: mask(0, 1), Preferred: true}, admit: true},
+			restricted:     result{hint: TopologyHint{NUMANodeAffinity: mask(0, 1), Preferred: true}, admit: true},
+			singleNumaNode: result{hint: TopologyHint{Preferred: true}, admit: true},
+		},
+		{
+			name: "aligned on one NUMA node",
+			providersHints: []map[string][]TopologyHint{
+				{"cpu": {
+					{NUMANodeAffinity: mask(0), Preferred: true},
+					{NUMANodeAffinity: mask(1), Preferred: true},
+					{NUMANodeAffinity: mask(0, 1), Preferred: false},
+				}},
+				{"memory": {
+					{NUMANodeAffinity: mask(1), Preferred: true},
+					{NUMANodeAffinity: mask(0, 1), Preferred: false},
+				}},
+			},
+			bestEffort:     result{hint: TopologyHint{NUMANodeAffinity: mask(1), Preferred: true}, admit: true},
+			restricted:     result{hint: TopologyHint{NUMANodeAffinity: mask(1), Preferred: true}, admit: true},
+			singleNumaNode: result{hint: TopologyHint{NUMANodeAffinity: mask(1), Preferred: true}, admit: true},
+		},
+		{
+			name: "preferred on different NUMA nodes",
+			providersHints: []map[string][]TopologyHint{
+				{"cpu": {
+					{NUMANodeAffinity: mask(0), Preferred: true},
+					{NUMANodeAffinity: mask(0, 1), Preferred: false},
+				}},
+				{"example.com/gpu": {
+					{NUMANodeAffinity: mask(1), Preferred: true},
+					{NUMANodeAffinity: mask(0, 1), Preferred: false},
+				}},
+			},
+			bestEffort:     result{hint: TopologyHint{NUMANodeAffinity: mask(0), Preferred: false}, admit: true},
+			restricted:     result{hint: TopologyHint{NUMANodeAffinity: mask(0), Preferred: false}, admit: false},
+			singleNumaNode: result{hint: TopologyHint{Preferred: false}, admit: false},
+		},
+		{
+			name: "only satisfiable across NUMA nodes",
+			providersHints: []map[string][]TopologyHint{
+				{"memory": {{NUMANodeAffinity: mask(0, 1), Preferred: true}}},
+			},
+			bestEffort:     result{hint: TopologyHint{NUMANodeAffinity: mask(0, 1), Preferred: true}, admit: true},
+			restricted:     result{hint: TopologyHint{NUMANodeAffinity: mask(0, 1), Preferred: true}, admit: true},
+			singleNumaNode: result{hint: TopologyHint{Preferred: false}, admit: false},
+		},
+		{
+			name: "a resource cannot be satisfied",
+			providersHints: []map[string][]TopologyHint{
+				{"cpu": {{NUMANodeAffinity: mask(0), Preferred: true}}},
+				{"example.com/gpu": {}},
+			},
+			bestEffort:     result{hint: TopologyHint{NUMANodeAffinity: mask(0), Preferred: false}, admit: true},
+			restricted:     result{hint: TopologyHint{NUMANodeAffinity: mask(0), Preferred: false}, admit: false},
+			singleNumaNode: result{hint: TopologyHint{Preferred: false}, admit: false},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := map[string]result{
+				PolicyNone:           {admit: true},
+				PolicyBestEffort:     tc.bestEffort,
+				PolicyRestricted:     tc.restricted,
+				PolicySingleNUMANode: tc.singleNumaNode,
+			}
+			for name, e := range expected {
+				policy, ok := NewPolicy(name, []int{0, 1})
+				if !ok {
+					t.Fatalf("unknown policy %s", name)
+				}
+				hint, admit := policy.Merge(tc.providersHints)
+				if !hint.IsEqual(e.hint) || admit != e.admit {
+					t.Errorf("%s: expected %v admit %v, got %v admit %v", name, e.hint, e.admit, hint, admit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package topologymanager
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/lifecycle"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultSysNodePath 读取NUMA节点的sysfs目录
+	DefaultSysNodePath = "/sys/devices/system/node"
+
+	// ErrorTopologyAffinity 资源无法按策略对齐到NUMA节点时拒绝pod的reason
+	ErrorTopologyAffinity = "TopologyAffinityError"
+	// 超过这个数量的NUMA节点，遍历所有组合的代价太大
+	maxAllowableNUMANodes = 8
+)
+
+// HintProvider 按NUMA节点分配的资源，如cpu、内存和设备
+// pkg/kubelet/cm/topologymanager/topology_manager.go
+type HintProvider interface {
+	// GetTopologyHints 按资源名返回容器可以使用的NUMA节点，返回空表示不关心NUMA
+	GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]TopologyHint
+	// Allocate 按Store中容器的hint分配资源
+	Allocate(pod *v1.Pod, container *v1.Container) error
+	// RemoveContainer pod被拒绝时归还已经分配的资源
+	RemoveContainer(podUID types.UID, containerName string) error
+}
+
+// Store provider分配资源时获取容器合并后的hint
+type Store interface {
+	GetAffinity(podUID string, containerName string) TopologyHint
+}
+
+// Manager 准入时收集各个provider的hint，按策略合并，然后由provider按合并后的hint分配资源
+// 按容器对齐，不同容器可以在不同的NUMA节点上
+type Manager struct {
+	lock          sync.Mutex
+	policy        Policy
+	hintProviders []HintProvider
+	// pod uid -> 容器名 -> 合并后的hint
+	podTopologyHints map[string]map[string]TopologyHint
+}
+
+// NewManager numaNodes为节点上的所有NUMA节点
+func NewManager(numaNodes []int, policyName string) (*Manager, error) {
+	klog.InfoS("Creating topology manager with policy", "topologyPolicyName", policyName)
+	if policyName != PolicyNone && len(numaNodes) > maxAllowableNUMANodes {
+		return nil, fmt.Errorf("unsupported on machines with more than %v NUMA Nodes", maxAllowableNUMANodes)
+	}
+	policy, ok := NewPolicy(policyName, numaNodes)
+	if !ok {
+		return nil, fmt.Errorf("unknown policy: %q", policyName)
+	}
+	return &Manager{
+		policy:           policy,
+		podTopologyHints: map[string]map[string]TopologyHint{},
+	}, nil
+}
+
+// AddHintProvider 按添加的顺序分配资源
+func (this *Manager) AddHintProvider(provider HintProvider) {
+	this.hintProviders = append(this.hintProviders, provider)
+}
+
+// GetAffinity 容器合并后的hint，没有记录时为空，表示没有偏好
+func (this *Manager) GetAffinity(podUID string, containerName string) TopologyHint {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.podTopologyHints[podUID][containerName]
+}
+
+func (this *Manager) setAffinity(podUID string, containerName string, hint TopologyHint) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.podTopologyHints[podUID] == nil {
+		this.podTopologyHints[podUID] = map[string]TopologyHint{}
+	}
+	this.podTopologyHints[podUID][containerName] = hint
+}
+
+// removeStaleAffinity 只保留节点上其他pod和待准入pod的hint
+func (this *Manager) removeStaleAffinity(attrs *lifecycle.PodAdmitAttributes) {
+	active := map[string]bool{string(attrs.Pod.UID): true}
+	for _, pod := range attrs.OtherPods {
+		active[string(pod.UID)] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for podUID := range this.podTopologyHints {
+		if !active[podUID] {
+			delete(this.podTopologyHints, podUID)
+		}
+	}
+}
+
+// calculateAffinity 收集所有provider的hint并按策略合并
+func (this *Manager) calculateAffinity(pod *v1.Pod, container *v1.Container) (TopologyHint, bool) {
+	providersHints := make([]map[string][]TopologyHint, 0, len(this.hintProviders))
+	for _, provider := range this.hintProviders {
+		providersHints = append(providersHints, provider.GetTopologyHints(pod, container))
+	}
+	bestHint, admit := this.policy.Merge(providersHints)
+	klog.V(4).InfoS("ContainerTopologyHint", "bestHint", bestHint, "pod", klog.KObj(pod), "containerName", container.Name)
+	return bestHint, admit
+}
+
+// Admit 按启动顺序为每个容器合并hint并分配资源，任一容器无法满足时归还已经分配的资源并拒绝pod
+// pkg/kubelet/cm/topologymanager/scope.go admitPolicyNone/admitContainerScope
+func (this *Manager) Admit(attrs *lifecycle.PodAdmitAttributes) lifecycle.PodAdmitResult {
+	this.removeStaleAffinity(attrs)
+	pod := attrs.Pod
+	containers := append([]v1.Container{}, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	for i := range containers {
+		container := &containers[i]
+		bestHint, admit := this.calculateAffinity(pod, container)
+		if !admit {
+			this.releasePod(pod, containers[:i])
+			return lifecycle.PodAdmitResult{
+				Admit:   false,
+				Reason:  ErrorTopologyAffinity,
+				Message: "Resources cannot be allocated with Topology locality",
+			}
+		}
+		this.setAffinity(string(pod.UID), container.Name, bestHint)
+		for _, provider := range this.hintProviders {
+			if err := provider.Allocate(pod, container); err != nil {
+				this.releasePod(pod, containers[:i+1])
+				return lifecycle.PodAdmitResult{
+					Admit:   false,
+					Reason:  lifecycle.UnexpectedAdmissionErrorReason,
+					Message: fmt.Sprintf("Allocate failed due to %v, which is unexpected", err),
+				}
+			}
+		}
+	}
+	return lifecycle.PodAdmitResult{Admit: true}
+}
+
+// releasePod 被拒绝的pod已经分配给前面容器的资源归还
+func (this *Manager) releasePod(pod *v1.Pod, containers []v1.Container) {
+	for _, container := range containers {
+		for _, provider := range this.hintProviders {
+			if err := provider.RemoveContainer(pod.UID, container.Name); err != nil {
+				klog.ErrorS(err, "Failed to release resources of rejected pod", "pod", klog.KObj(pod), "containerName", container.Name)
+			}
+		}
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.podTopologyHints, string(pod.UID))
+}
+
+// DiscoverNUMANodes 从sysfs读取在线的NUMA节点，没有NUMA信息时只有节点0
+func DiscoverNUMANodes(sysNodePath string) ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(sysNodePath, "online"))
+	if os.IsNotExist(err) {
+		return []int{0}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online NUMA nodes: %v", err)
+	}
+	// 格式和cpu列表相同，如0-1,3
+	nodes, err := cpuset.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse online NUMA nodes %q: %v", strings.TrimSpace(string(data)), err)
+	}
+	return nodes.List(), nil
+}
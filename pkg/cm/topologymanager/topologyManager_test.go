@@ -0,0 +1,142 @@
+package topologymanager
+
+import (
+	"errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/lifecycle"
+	"reflect"
+	"testing"
+)
+
+// fakeHintProvider 按容器名返回hint，记录分配和归还的容器
+type fakeHintProvider struct {
+	hints       map[string]map[string][]TopologyHint
+	allocateErr error
+	allocated   []string
+	removed     []string
+}
+
+func (this *fakeHintProvider) GetTopologyHints(_ *v1.Pod, container *v1.Container) map[string][]TopologyHint {
+	return this.hints[container.Name]
+}
+
+func (this *fakeHintProvider) Allocate(_ *v1.Pod, container *v1.Container) error {
+	if this.allocateErr != nil {
+		return this.allocateErr
+	}
+	this.allocated = append(this.allocated, container.Name)
+	return nil
+}
+
+func (this *fakeHintProvider) RemoveContainer(_ types.UID, containerName string) error {
+	this.removed = append(this.removed, containerName)
+	return nil
+}
+
+func newTopologyTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "uid"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "app"}},
+		},
+	}
+}
+
+// init容器可以对齐，业务容器的cpu和设备在不同的NUMA节点上
+func newTopologyTestProviders() (*fakeHintProvider, *fakeHintProvider) {
+	cpuProvider := &fakeHintProvider{hints: map[string]map[string][]TopologyHint{
+		"init": {"cpu": {{NUMANodeAffinity: mask(0), Preferred: true}}},
+		"app":  {"cpu": {{NUMANodeAffinity: mask(0), Preferred: true}, {NUMANodeAffinity: mask(0, 1), Preferred: false}}},
+	}}
+	deviceProvider := &fakeHintProvider{hints: map[string]map[string][]TopologyHint{
+		"app": {"example.com/gpu": {{NUMANodeAffinity: mask(1), Preferred: true}}},
+	}}
+	return cpuProvider, deviceProvider
+}
+
+// restricted和single-numa-node拒绝无法对齐的pod，已经为前面的容器分配的资源被归还
+func TestAdmitTopologyAffinityError(t *testing.T) {
+	for _, policy := range []string{PolicyRestricted, PolicySingleNUMANode} {
+		t.Run(policy, func(t *testing.T) {
+			manager, err := NewManager([]int{0, 1}, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cpuProvider, deviceProvider := newTopologyTestProviders()
+			manager.AddHintProvider(cpuProvider)
+			manager.AddHintProvider(deviceProvider)
+
+			result := manager.Admit(&lifecycle.PodAdmitAttributes{Pod: newTopologyTestPod()})
+			if result.Admit || result.Reason != ErrorTopologyAffinity {
+				t.Fatalf("expected pod to be rejected with %s, got %+v", ErrorTopologyAffinity, result)
+			}
+			for _, provider := range []*fakeHintProvider{cpuProvider, deviceProvider} {
+				if !reflect.DeepEqual(provider.allocated, []string{"init"}) || !reflect.DeepEqual(provider.removed, []string{"init"}) {
+					t.Errorf("expected only init to be allocated and released, got allocated %v removed %v",
+						provider.allocated, provider.removed)
+				}
+			}
+			if hint := manager.GetAffinity("uid", "init"); !hint.NUMANodeAffinity.IsEmpty() {
+				t.Errorf("expected the affinity of a rejected pod to be removed, got %v", hint)
+			}
+		})
+	}
+}
+
+// best-effort接受无法对齐的pod
+func TestAdmitBestEffort(t *testing.T) {
+	manager, err := NewManager([]int{0, 1}, PolicyBestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpuProvider, deviceProvider := newTopologyTestProviders()
+	manager.AddHintProvider(cpuProvider)
+	manager.AddHintProvider(deviceProvider)
+
+	if result := manager.Admit(&lifecycle.PodAdmitAttributes{Pod: newTopologyTestPod()}); !result.Admit {
+		t.Fatalf("expected pod to be admitted, got %+v", result)
+	}
+	// 业务容器的cpu可以跨节点，和设备合并后在节点1上
+	expected := map[string]TopologyHint{
+		"init": {NUMANodeAffinity: mask(0), Preferred: true},
+		"app":  {NUMANodeAffinity: mask(1), Preferred: false},
+	}
+	for name, hint := range expected {
+		if got := manager.GetAffinity("uid", name); !got.IsEqual(hint) {
+			t.Errorf("%s: expected affinity %v, got %v", name, hint, got)
+		}
+	}
+	if !reflect.DeepEqual(cpuProvider.allocated, []string{"init", "app"}) {
+		t.Errorf("expected both containers to be allocated, got %v", cpuProvider.allocated)
+	}
+
+	// 不在节点上的pod的hint在下一次准入时删除
+	other := newTopologyTestPod()
+	other.UID = "other"
+	manager.Admit(&lifecycle.PodAdmitAttributes{Pod: other})
+	if got := manager.GetAffinity("uid", "init"); !got.NUMANodeAffinity.IsEmpty() {
+		t.Errorf("expected stale affinity to be removed, got %v", got)
+	}
+}
+
+func TestAdmitAllocateError(t *testing.T) {
+	manager, err := NewManager([]int{0, 1}, PolicyBestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpuProvider, deviceProvider := newTopologyTestProviders()
+	deviceProvider.allocateErr = errors.New("no devices")
+	manager.AddHintProvider(cpuProvider)
+	manager.AddHintProvider(deviceProvider)
+
+	result := manager.Admit(&lifecycle.PodAdmitAttributes{Pod: newTopologyTestPod()})
+	if result.Admit || result.Reason != lifecycle.UnexpectedAdmissionErrorReason {
+		t.Fatalf("expected unexpected admission error, got %+v", result)
+	}
+	if !reflect.DeepEqual(cpuProvider.removed, []string{"init"}) {
+		t.Errorf("expected resources of init to be released, got %v", cpuProvider.removed)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"mykubelet/pkg/cm"
+	"mykubelet/pkg/cm/cpumanager"
+	"mykubelet/pkg/cm/memorymanager"
+	"mykubelet/pkg/cm/topologymanager"
+	"mykubelet/pkg/common"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/network"
+	"mykubelet/pkg/network/cni"
+	"time"
+)
+
+// KubeletConfiguration kubelet的配置，通过/configz接口对外展示
+// staging/src/k8s.io/kubelet/config/v1beta1/types.go
+type KubeletConfiguration struct {
+	NodeName string `json:"nodeName"`
+	// kubelet server监听的地址和端口
+	Address string `json:"address"`
+	Port    int32  `json:"port"`
+	// 校验客户端证书的ca
+	ClientCAFile string `json:"clientCAFile"`
+	// 服务端证书，不存在时生成自签名证书；文件更新后自动重新加载
+	TLSCertFile       string `json:"tlsCertFile"`
+	TLSPrivateKeyFile string `json:"tlsPrivateKeyFile"`
+	// 容器运行时的地址
+	ContainerRuntimeEndpoint string `json:"containerRuntimeEndpoint"`
+	// 运行时streaming server的地址，运行时返回相对的exec/attach/portForward地址时以此为基础，如http://127.0.0.1:10010
+	RuntimeStreamingBaseURL string `json:"runtimeStreamingBaseURL"`
+	// kubelet的根目录，所在的文件系统作为节点的nodefs
+	RootDirectory string `json:"rootDirectory"`
+	// cgroup文件系统的挂载点，资源统计从这里读取
+	CgroupMountPath string `json:"cgroupMountPath"`
+	// 为true时创建kubepods、QoS和pod的cgroup，需要cgroup v2
+	CgroupsPerQOS bool `json:"cgroupsPerQOS"`
+	// cgroupfs或systemd，需要和容器运行时使用的驱动一致
+	CgroupDriver string `json:"cgroupDriver"`
+	// 是否为设置了cpu limits的容器和pod设置cfs配额
+	CPUCFSQuota bool `json:"cpuCFSQuota"`
+	// cfs配额的周期
+	CPUCFSQuotaPeriod metav1.Duration `json:"cpuCFSQuotaPeriod"`
+	// 每个pod的最大进程数，-1表示不限制
+	PodPidsLimit int64 `json:"podPidsLimit"`
+	// cpu管理器的策略，none或static
+	CPUManagerPolicy string `json:"cpuManagerPolicy"`
+	// cpu管理器同步容器cpuset的间隔
+	CPUManagerReconcilePeriod metav1.Duration `json:"cpuManagerReconcilePeriod"`
+	// 留给系统进程的cpu列表，如0-1,4，static策略下必须设置
+	ReservedSystemCPUs string `json:"reservedSystemCPUs"`
+	// 拓扑管理器的策略，none、best-effort、restricted或single-numa-node
+	TopologyManagerPolicy string `json:"topologyManagerPolicy"`
+	// 内存管理器的策略，None或Static
+	MemoryManagerPolicy string `json:"memoryManagerPolicy"`
+	// 每个NUMA节点留给系统的内存，如0:memory=1Gi,hugepages-2Mi=0;1:memory=512Mi，Static策略下必须设置
+	ReservedMemory string `json:"reservedMemory"`
+
+	// 网络插件，为空时由容器运行时配置pod网络，cni时由kubelet调用CNI插件，需要关闭运行时自己的CNI
+	NetworkPluginName string `json:"networkPluginName"`
+	// CNI网络配置的目录，使用按文件名排序的第一个配置
+	CNIConfDir string `json:"cniConfDir"`
+	// CNI插件可执行文件的目录，多个目录用逗号分隔
+	CNIBinDir string `json:"cniBinDir"`
+	// 缓存CNI ADD结果的目录，删除网络和kubelet重启后获取pod IP时使用
+	CNICacheDir string `json:"cniCacheDir"`
+
+	// 静态pod清单的目录或文件，为空时不读取
+	StaticPodPath string `json:"staticPodPath"`
+	// 静态pod清单的URL，为空时不读取
+	StaticPodURL string `json:"staticPodURL"`
+	// 读取静态pod清单目录的间隔
+	FileCheckFrequency metav1.Duration `json:"fileCheckFrequency"`
+	// 请求静态pod清单URL的间隔
+	HTTPCheckFrequency metav1.Duration `json:"httpCheckFrequency"`
+
+	// 容器日志文件轮转的大小，resource.Quantity格式
+	ContainerLogMaxSize string `json:"containerLogMaxSize"`
+	// 每个容器最多保留的日志文件数
+	ContainerLogMaxFiles int32 `json:"containerLogMaxFiles"`
+	// 是否压缩轮转后的旧日志文件
+	ContainerLogCompress bool `json:"containerLogCompress"`
+
+	// 每秒最多拉取镜像的次数，0表示不限制
+	RegistryPullQPS int32 `json:"registryPullQPS"`
+	// 拉取镜像的突发上限，RegistryPullQPS大于0时生效
+	RegistryBurst int32 `json:"registryBurst"`
+
+	// 镜像文件系统使用率达到该百分比时开始回收镜像
+	ImageGCHighThresholdPercent int32 `json:"imageGCHighThresholdPercent"`
+	// 镜像回收到使用率低于该百分比为止
+	ImageGCLowThresholdPercent int32 `json:"imageGCLowThresholdPercent"`
+	// 未使用的镜像至少保留的时间
+	ImageMinimumGCAge metav1.Duration `json:"imageMinimumGCAge"`
+	// 已退出的容器至少保留的时间
+	MinimumContainerTTLDuration metav1.Duration `json:"minimumContainerTTLDuration"`
+	// 每个pod中每个容器最多保留的已退出实例数，小于0表示不限制
+	MaxPerPodContainerCount int32 `json:"maxPerPodContainerCount"`
+	// 节点上最多保留的已退出容器数，小于0表示不限制
+	MaxContainerCount int32 `json:"maxContainerCount"`
+
+	// 硬驱逐阈值，达到后立即驱逐pod，如memory.available: 100Mi、nodefs.available: 10%
+	EvictionHard map[string]string `json:"evictionHard"`
+	// 软驱逐阈值，持续超过对应的宽限期后才驱逐
+	EvictionSoft map[string]string `json:"evictionSoft"`
+	// 软驱逐阈值的宽限期，如memory.available: 1m30s
+	EvictionSoftGracePeriod map[string]string `json:"evictionSoftGracePeriod"`
+	// 软驱逐时允许pod使用的最长优雅退出时间，单位秒
+	EvictionMaxPodGracePeriod int32 `json:"evictionMaxPodGracePeriod"`
+	// 驱逐时在阈值之外至少再回收的资源量，避免反复在阈值附近驱逐
+	EvictionMinimumReclaim map[string]string `json:"evictionMinimumReclaim"`
+	// 压力condition解除前需要等待的时间，避免状态抖动
+	EvictionPressureTransitionPeriod metav1.Duration `json:"evictionPressureTransitionPeriod"`
+
+	// TokenReview结果的缓存时间
+	AuthenticationWebhookCacheTTL metav1.Duration `json:"authenticationWebhookCacheTTL"`
+	// SubjectAccessReview允许和拒绝结果的缓存时间
+	AuthorizationWebhookCacheAuthorizedTTL   metav1.Duration `json:"authorizationWebhookCacheAuthorizedTTL"`
+	AuthorizationWebhookCacheUnauthorizedTTL metav1.Duration `json:"authorizationWebhookCacheUnauthorizedTTL"`
+}
+
+// NewDefaultConfiguration 默认配置
+func NewDefaultConfiguration(nodeName string) *KubeletConfiguration {
+	return &KubeletConfiguration{
+		NodeName:                 nodeName,
+		Address:                  "0.0.0.0",
+		Port:                     10250,
+		ClientCAFile:             common.CAFile,
+		TLSCertFile:              "./.kube/kubelet-serving.crt",
+		TLSPrivateKeyFile:        "./.kube/kubelet-serving.key",
+		ContainerRuntimeEndpoint: container.DefaultRuntimeEndpoint,
+		RootDirectory:            "/var/lib/kubelet",
+		CgroupMountPath:          "/sys/fs/cgroup",
+		CgroupsPerQOS:            true,
+		CgroupDriver:             cm.CgroupDriverCgroupfs,
+		CPUCFSQuota:              true,
+		CPUCFSQuotaPeriod:        metav1.Duration{Duration: 100 * time.Millisecond},
+		PodPidsLimit:             -1,
+
+		CPUManagerPolicy:          cpumanager.PolicyNone,
+		CPUManagerReconcilePeriod: metav1.Duration{Duration: 10 * time.Second},
+		TopologyManagerPolicy:     topologymanager.PolicyNone,
+		MemoryManagerPolicy:       memorymanager.PolicyNone,
+
+		NetworkPluginName: network.NoopNetworkPluginName,
+		CNIConfDir:        cni.DefaultConfDir,
+		CNIBinDir:         cni.DefaultBinDir,
+		CNICacheDir:       cni.DefaultCacheDir,
+
+		FileCheckFrequency: metav1.Duration{Duration: 20 * time.Second},
+		HTTPCheckFrequency: metav1.Duration{Duration: 20 * time.Second},
+
+		ContainerLogMaxSize:  "10Mi",
+		ContainerLogMaxFiles: 5,
+		ContainerLogCompress: true,
+
+		RegistryPullQPS: 5,
+		RegistryBurst:   10,
+
+		ImageGCHighThresholdPercent: 85,
+		ImageGCLowThresholdPercent:  80,
+		ImageMinimumGCAge:           metav1.Duration{Duration: 2 * time.Minute},
+		MaxPerPodContainerCount:     1,
+		MaxContainerCount:           -1,
+
+		EvictionHard: map[string]string{
+			"memory.available":  "100Mi",
+			"nodefs.available":  "10%",
+			"imagefs.available": "15%",
+		},
+		EvictionPressureTransitionPeriod: metav1.Duration{Duration: 5 * time.Minute},
+
+		AuthenticationWebhookCacheTTL:            metav1.Duration{Duration: 2 * time.Minute},
+		AuthorizationWebhookCacheAuthorizedTTL:   metav1.Duration{Duration: 5 * time.Minute},
+		AuthorizationWebhookCacheUnauthorizedTTL: metav1.Duration{Duration: 30 * time.Second},
+	}
+}
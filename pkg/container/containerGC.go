@@ -0,0 +1,269 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GCPolicy 容器垃圾回收策略
+// pkg/kubelet/container/container_gc.go
+type GCPolicy struct {
+	// 容器创建后至少保留的时间
+	MinAge time.Duration
+	// 每个pod中每个容器最多保留的已退出实例数，小于0表示不限制
+	MaxPerPodContainer int
+	// 节点上最多保留的已退出容器数，小于0表示不限制
+	MaxContainers int
+}
+
+// PodStateProvider 判断pod的容器、sandbox和日志是否可以全部删除
+type PodStateProvider interface {
+	// ShouldPodContentBeRemoved pod已经删除，或者已经终止且不会再启动
+	ShouldPodContentBeRemoved(uid types.UID) bool
+}
+
+// ContainerGC 删除已退出的容器、不再使用的sandbox和已删除pod的日志目录
+// pkg/kubelet/kuberuntime/kuberuntime_gc.go
+type ContainerGC struct {
+	runtime          Runtime
+	policy           GCPolicy
+	podStateProvider PodStateProvider
+	// pod来源是否已经同步，同步前无法判断pod是否已经删除
+	sourcesReady func() bool
+	clock        clock.Clock
+}
+
+// NewContainerGC 创建容器垃圾回收
+func NewContainerGC(runtime Runtime, policy GCPolicy, podStateProvider PodStateProvider, sourcesReady func() bool,
+	clock clock.Clock) (*ContainerGC, error) {
+	if policy.MinAge < 0 {
+		return nil, fmt.Errorf("invalid minimum garbage collection age: %v", policy.MinAge)
+	}
+	return &ContainerGC{
+		runtime:          runtime,
+		policy:           policy,
+		podStateProvider: podStateProvider,
+		sourcesReady:     sourcesReady,
+		clock:            clock,
+	}, nil
+}
+
+// 同一个pod中同名容器为一个回收单元
+type evictUnit struct {
+	uid  types.UID
+	name string
+}
+
+// 回收单元中的已退出容器，按创建时间倒序
+type containersByEvictUnit map[evictUnit][]*Container
+
+func (this containersByEvictUnit) numContainers() int {
+	num := 0
+	for _, containers := range this {
+		num += len(containers)
+	}
+	return num
+}
+
+// GarbageCollect 按策略回收已退出的容器，然后回收sandbox和日志目录
+func (this *ContainerGC) GarbageCollect(ctx context.Context) error {
+	return this.garbageCollect(ctx, this.policy, false)
+}
+
+// DeleteAllUnusedContainers 删除所有已退出的容器，磁盘压力时由驱逐管理器调用
+func (this *ContainerGC) DeleteAllUnusedContainers(ctx context.Context) error {
+	return this.garbageCollect(ctx, GCPolicy{MinAge: 0, MaxPerPodContainer: 0, MaxContainers: 0}, true)
+}
+
+func (this *ContainerGC) garbageCollect(ctx context.Context, policy GCPolicy, evictNonDeletedPods bool) error {
+	pods, err := this.runtime.GetPods(ctx)
+	if err != nil {
+		return err
+	}
+	allSourcesReady := this.sourcesReady()
+	errs := []string{}
+	if err = this.evictContainers(ctx, pods, policy, allSourcesReady, evictNonDeletedPods); err != nil {
+		errs = append(errs, err.Error())
+	}
+	// 删除容器后重新获取，没有容器的sandbox才能删除
+	if pods, err = this.runtime.GetPods(ctx); err != nil {
+		return err
+	}
+	if err = this.evictSandboxes(ctx, pods, allSourcesReady, evictNonDeletedPods); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if allSourcesReady {
+		if err = this.evictPodLogsDirectories(pods); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to garbage collect containers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// evictableContainers 没有在运行且创建时间超过minAge的容器，按回收单元分组
+func (this *ContainerGC) evictableContainers(pods []*Pod, minAge time.Duration) containersByEvictUnit {
+	evictUnits := containersByEvictUnit{}
+	newestGCTime := this.clock.Now().Add(-minAge)
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			if c.State == ContainerStateRunning || c.Created.After(newestGCTime) {
+				continue
+			}
+			key := evictUnit{uid: pod.ID, name: c.Name}
+			evictUnits[key] = append(evictUnits[key], c)
+		}
+	}
+	for key := range evictUnits {
+		containers := evictUnits[key]
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].Created.After(containers[j].Created)
+		})
+	}
+	return evictUnits
+}
+
+func (this *ContainerGC) evictContainers(ctx context.Context, pods []*Pod, policy GCPolicy, allSourcesReady, evictNonDeletedPods bool) error {
+	evictUnits := this.evictableContainers(pods, policy.MinAge)
+	errs := []string{}
+	removeOldest := func(containers []*Container, toRemove int) []*Container {
+		numToKeep := len(containers) - toRemove
+		if numToKeep < 0 {
+			numToKeep = 0
+		}
+		for _, c := range containers[numToKeep:] {
+			klog.V(4).InfoS("Removing container", "containerID", c.ID.ID, "containerName", c.Name)
+			if err := this.runtime.RemoveContainer(ctx, c.ID.ID); err != nil {
+				klog.ErrorS(err, "Failed to remove container", "containerID", c.ID.ID)
+				errs = append(errs, err.Error())
+			}
+		}
+		return containers[:numToKeep]
+	}
+
+	// 已经删除的pod的容器全部删除
+	if allSourcesReady {
+		for key, containers := range evictUnits {
+			if evictNonDeletedPods || this.podStateProvider.ShouldPodContentBeRemoved(key.uid) {
+				removeOldest(containers, len(containers))
+				delete(evictUnits, key)
+			}
+		}
+	}
+
+	// 每个容器最多保留MaxPerPodContainer个已退出的实例
+	if policy.MaxPerPodContainer >= 0 {
+		for key, containers := range evictUnits {
+			evictUnits[key] = removeOldest(containers, len(containers)-policy.MaxPerPodContainer)
+		}
+	}
+
+	// 超过节点上限时，先平均分配每个回收单元的配额，仍然超出时删除最旧的容器
+	if policy.MaxContainers >= 0 && evictUnits.numContainers() > policy.MaxContainers {
+		numUnits := len(evictUnits)
+		numContainersPerUnit := policy.MaxContainers / numUnits
+		if numContainersPerUnit < 1 {
+			numContainersPerUnit = 1
+		}
+		for key, containers := range evictUnits {
+			evictUnits[key] = removeOldest(containers, len(containers)-numContainersPerUnit)
+		}
+
+		if evictUnits.numContainers() > policy.MaxContainers {
+			all := []*Container{}
+			for _, containers := range evictUnits {
+				all = append(all, containers...)
+			}
+			sort.Slice(all, func(i, j int) bool {
+				return all[i].Created.After(all[j].Created)
+			})
+			removeOldest(all, len(all)-policy.MaxContainers)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove containers: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// evictSandboxes 删除没有容器且没有就绪的sandbox
+// 还存在的pod保留最新的sandbox，pod重建sandbox时需要它的attempt
+func (this *ContainerGC) evictSandboxes(ctx context.Context, pods []*Pod, allSourcesReady, evictNonDeletedPods bool) error {
+	errs := []string{}
+	for _, pod := range pods {
+		hasContainers := map[string]bool{}
+		for _, c := range pod.Containers {
+			hasContainers[c.PodSandboxID] = true
+		}
+		sandboxes := append([]*Container{}, pod.Sandboxes...)
+		sort.Slice(sandboxes, func(i, j int) bool {
+			return sandboxes[i].Created.After(sandboxes[j].Created)
+		})
+		removeAll := allSourcesReady && this.podStateProvider.ShouldPodContentBeRemoved(pod.ID)
+		for i, s := range sandboxes {
+			if s.State == ContainerStateRunning || hasContainers[s.ID.ID] {
+				continue
+			}
+			if i == 0 && !removeAll && !evictNonDeletedPods {
+				continue
+			}
+			klog.V(4).InfoS("Removing sandbox", "sandboxID", s.ID.ID, "podUID", pod.ID)
+			if err := this.runtime.RemovePodSandbox(ctx, s.ID.ID); err != nil {
+				klog.ErrorS(err, "Failed to remove sandbox", "sandboxID", s.ID.ID)
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove sandboxes: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// evictPodLogsDirectories 删除已经删除且在运行时中没有任何容器的pod的日志目录
+func (this *ContainerGC) evictPodLogsDirectories(pods []*Pod) error {
+	existing := map[types.UID]bool{}
+	for _, pod := range pods {
+		existing[pod.ID] = true
+	}
+	entries, err := os.ReadDir(PodLogsRootDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	errs := []string{}
+	for _, entry := range entries {
+		// 目录名为 <namespace>_<name>_<uid>
+		parts := strings.Split(entry.Name(), "_")
+		if !entry.IsDir() || len(parts) != 3 {
+			continue
+		}
+		uid := types.UID(parts[2])
+		if existing[uid] || !this.podStateProvider.ShouldPodContentBeRemoved(uid) {
+			continue
+		}
+		dir := filepath.Join(PodLogsRootDirectory, entry.Name())
+		klog.V(4).InfoS("Removing pod logs directory", "path", dir)
+		if err = os.RemoveAll(dir); err != nil {
+			klog.ErrorS(err, "Failed to remove pod logs directory", "path", dir)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove pod logs directories: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package container
+
+import "errors"
+
+// 容器启动失败的原因，会作为容器的waiting reason展示在pod status中
+var (
+	ErrCrashLoopBackOff = errors.New("CrashLoopBackOff")
+	ErrImagePull        = errors.New("ErrImagePull")
+	ErrImagePullBackOff = errors.New("ImagePullBackOff")
+	// 拉取策略为Never且镜像不存在
+	ErrImageNeverPull   = errors.New("ErrImageNeverPull")
+	ErrImageInspect     = errors.New("ImageInspectError")
+	ErrInvalidImageName = errors.New("InvalidImageName")
+	ErrCreateContainer  = errors.New("CreateContainerError")
+	// 卷挂载等容器配置错误
+	ErrCreateContainerConfig = errors.New("CreateContainerConfigError")
+	ErrRunContainer          = errors.New("RunContainerError")
+	ErrPostStartHook         = errors.New("PostStartHookError")
+)
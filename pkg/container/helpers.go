@@ -0,0 +1,14 @@
+package container
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// IsRestartableInitContainer restartPolicy为Always的初始化容器，即sidecar
+// sidecar按顺序启动后一直运行，不阻塞后续容器
+func IsRestartableInitContainer(initContainer *v1.Container) bool {
+	if initContainer.RestartPolicy == nil {
+		return false
+	}
+	return *initContainer.RestartPolicy == v1.ContainerRestartPolicyAlways
+}
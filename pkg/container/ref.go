@@ -0,0 +1,33 @@
+package container
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ref "k8s.io/client-go/tools/reference"
+)
+
+// GenerateContainerRef 生成指向pod中某个容器的引用，用于记录容器相关的事件
+// pkg/kubelet/container/ref.go
+func GenerateContainerRef(pod *v1.Pod, container *v1.Container) (*v1.ObjectReference, error) {
+	fieldPath, err := fieldPath(pod, container)
+	if err != nil {
+		fieldPath = "implicitly required container " + container.Name
+	}
+	return ref.GetPartialReference(scheme.Scheme, pod, fieldPath)
+}
+
+// 容器在pod spec中的路径，例如spec.containers{nginx}
+func fieldPath(pod *v1.Pod, container *v1.Container) (string, error) {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == container.Name {
+			return fmt.Sprintf("spec.containers{%s}", container.Name), nil
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == container.Name {
+			return fmt.Sprintf("spec.initContainers{%s}", container.Name), nil
+		}
+	}
+	return "", fmt.Errorf("container %q not found in pod %s/%s", container.Name, pod.Namespace, pod.Name)
+}
@@ -0,0 +1,596 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/klog/v2"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultRuntimeEndpoint = "unix:///run/containerd/containerd.sock"
+	// PodLogsRootDirectory 容器日志根目录，和kubelet保持一致
+	PodLogsRootDirectory = "/var/log/pods"
+
+	connectTimeout = 10 * time.Second
+)
+
+// RemoteRuntime 基于CRI gRPC的运行时实现
+type RemoteRuntime struct {
+	runtimeName   string
+	runtimeClient runtimeapi.RuntimeServiceClient
+	imageClient   runtimeapi.ImageServiceClient
+}
+
+var _ Runtime = &RemoteRuntime{}
+
+// NewRemoteRuntime 连接CRI endpoint，如 unix:///run/containerd/containerd.sock
+func NewRemoteRuntime(endpoint string) (*RemoteRuntime, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to runtime %q: %v", endpoint, err)
+	}
+
+	rt := &RemoteRuntime{
+		runtimeClient: runtimeapi.NewRuntimeServiceClient(conn),
+		imageClient:   runtimeapi.NewImageServiceClient(conn),
+	}
+	version, err := rt.runtimeClient.Version(ctx, &runtimeapi.VersionRequest{})
+	if err != nil {
+		return nil, err
+	}
+	rt.runtimeName = version.RuntimeName
+	klog.Infof("connected to container runtime %s %s", version.RuntimeName, version.RuntimeVersion)
+
+	return rt, nil
+}
+
+func (this *RemoteRuntime) Type() string {
+	return this.runtimeName
+}
+
+// BuildPodLogsDirectory pod的日志目录 /var/log/pods/<namespace>_<name>_<uid>
+func BuildPodLogsDirectory(namespace, name string, uid types.UID) string {
+	return filepath.Join(PodLogsRootDirectory, fmt.Sprintf("%s_%s_%s", namespace, name, uid))
+}
+
+// BuildContainerLogsPath 容器日志文件相对pod日志目录的路径 <container>/<restartCount>.log
+func BuildContainerLogsPath(containerName string, restartCount int) string {
+	return filepath.Join(containerName, fmt.Sprintf("%d.log", restartCount))
+}
+
+func newPodLabels(pod *v1.Pod) map[string]string {
+	labels := map[string]string{}
+	for k, v := range pod.Labels {
+		labels[k] = v
+	}
+	labels[KubernetesPodNameLabel] = pod.Name
+	labels[KubernetesPodNamespaceLabel] = pod.Namespace
+	labels[KubernetesPodUIDLabel] = string(pod.UID)
+	return labels
+}
+
+func (this *RemoteRuntime) RunPodSandbox(ctx context.Context, pod *v1.Pod, attempt uint32, opts *RunPodSandboxOptions) (string, error) {
+	if opts == nil {
+		opts = &RunPodSandboxOptions{}
+	}
+	config := &runtimeapi.PodSandboxConfig{
+		Metadata: &runtimeapi.PodSandboxMetadata{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Uid:       string(pod.UID),
+			Attempt:   attempt,
+		},
+		Hostname:     pod.Name,
+		LogDirectory: BuildPodLogsDirectory(pod.Namespace, pod.Name, pod.UID),
+		Labels:       newPodLabels(pod),
+		Annotations:  pod.Annotations,
+		Linux: &runtimeapi.LinuxPodSandboxConfig{
+			CgroupParent: opts.CgroupParent,
+			SecurityContext: &runtimeapi.LinuxSandboxSecurityContext{
+				NamespaceOptions: namespacesForPod(pod),
+			},
+		},
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.HostPort <= 0 {
+				continue
+			}
+			config.PortMappings = append(config.PortMappings, &runtimeapi.PortMapping{
+				Protocol:      runtimeapi.Protocol(runtimeapi.Protocol_value[string(p.Protocol)]),
+				ContainerPort: p.ContainerPort,
+				HostPort:      p.HostPort,
+				HostIp:        p.HostIP,
+			})
+		}
+	}
+
+	resp, err := this.runtimeClient.RunPodSandbox(ctx, &runtimeapi.RunPodSandboxRequest{Config: config})
+	if err != nil {
+		return "", err
+	}
+	return resp.PodSandboxId, nil
+}
+
+// sandboxInfo 运行时verbose状态中的info，containerd和cri-o都包含sandbox的pid和OCI spec
+type sandboxInfo struct {
+	Pid         int `json:"pid"`
+	RuntimeSpec struct {
+		Linux struct {
+			Namespaces []struct {
+				Type string `json:"type"`
+				Path string `json:"path"`
+			} `json:"namespaces"`
+		} `json:"linux"`
+	} `json:"runtimeSpec"`
+}
+
+// GetPodSandboxNetNS 优先使用OCI spec中网络命名空间的路径，没有时使用sandbox进程的命名空间
+func (this *RemoteRuntime) GetPodSandboxNetNS(ctx context.Context, sandboxID string) (string, error) {
+	resp, err := this.runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: sandboxID, Verbose: true})
+	if err != nil {
+		return "", err
+	}
+	data, ok := resp.Info["info"]
+	if !ok {
+		return "", fmt.Errorf("runtime returned no verbose info for sandbox %s", sandboxID)
+	}
+	info := &sandboxInfo{}
+	if err = json.Unmarshal([]byte(data), info); err != nil {
+		return "", fmt.Errorf("failed to decode verbose info of sandbox %s: %v", sandboxID, err)
+	}
+	for _, ns := range info.RuntimeSpec.Linux.Namespaces {
+		if ns.Type == "network" && ns.Path != "" {
+			return ns.Path, nil
+		}
+	}
+	if info.Pid > 0 {
+		return fmt.Sprintf("/proc/%d/ns/net", info.Pid), nil
+	}
+	return "", fmt.Errorf("cannot find network namespace of sandbox %s", sandboxID)
+}
+
+func namespacesForPod(pod *v1.Pod) *runtimeapi.NamespaceOption {
+	mode := func(host bool) runtimeapi.NamespaceMode {
+		if host {
+			return runtimeapi.NamespaceMode_NODE
+		}
+		return runtimeapi.NamespaceMode_POD
+	}
+	return &runtimeapi.NamespaceOption{
+		Network: mode(pod.Spec.HostNetwork),
+		Pid:     mode(pod.Spec.HostPID),
+		Ipc:     mode(pod.Spec.HostIPC),
+	}
+}
+
+func (this *RemoteRuntime) StopPodSandbox(ctx context.Context, sandboxID string) error {
+	_, err := this.runtimeClient.StopPodSandbox(ctx, &runtimeapi.StopPodSandboxRequest{PodSandboxId: sandboxID})
+	return err
+}
+
+func (this *RemoteRuntime) RemovePodSandbox(ctx context.Context, sandboxID string) error {
+	_, err := this.runtimeClient.RemovePodSandbox(ctx, &runtimeapi.RemovePodSandboxRequest{PodSandboxId: sandboxID})
+	return err
+}
+
+func (this *RemoteRuntime) CreateContainer(ctx context.Context, sandboxID string, pod *v1.Pod, container *v1.Container,
+	restartCount int, opts *RunContainerOptions) (string, error) {
+	if opts == nil {
+		opts = &RunContainerOptions{}
+	}
+	labels := map[string]string{
+		KubernetesPodNameLabel:       pod.Name,
+		KubernetesPodNamespaceLabel:  pod.Namespace,
+		KubernetesPodUIDLabel:        string(pod.UID),
+		KubernetesContainerNameLabel: container.Name,
+	}
+	logPath := opts.LogPath
+	if logPath == "" {
+		logPath = BuildContainerLogsPath(container.Name, restartCount)
+	}
+
+	config := &runtimeapi.ContainerConfig{
+		Metadata: &runtimeapi.ContainerMetadata{
+			Name:    container.Name,
+			Attempt: uint32(restartCount),
+		},
+		Image:      &runtimeapi.ImageSpec{Image: container.Image},
+		Command:    container.Command,
+		Args:       container.Args,
+		WorkingDir: container.WorkingDir,
+		Labels:     labels,
+		Annotations: map[string]string{
+			containerRestartCountAnnotation: strconv.Itoa(restartCount),
+		},
+		LogPath:   logPath,
+		Stdin:     container.Stdin,
+		StdinOnce: container.StdinOnce,
+		Tty:       container.TTY,
+		Linux: &runtimeapi.LinuxContainerConfig{
+			Resources: opts.Resources,
+		},
+	}
+	for _, e := range opts.Envs {
+		config.Envs = append(config.Envs, &runtimeapi.KeyValue{Key: e.Name, Value: e.Value})
+	}
+	for _, m := range opts.Mounts {
+		config.Mounts = append(config.Mounts, &runtimeapi.Mount{
+			HostPath:      m.HostPath,
+			ContainerPath: m.ContainerPath,
+			Readonly:      m.ReadOnly,
+		})
+	}
+	for _, d := range opts.Devices {
+		config.Devices = append(config.Devices, &runtimeapi.Device{
+			HostPath:      d.PathOnHost,
+			ContainerPath: d.PathInContainer,
+			Permissions:   d.Permissions,
+		})
+	}
+	for k, v := range opts.Annotations {
+		// 不能覆盖kubelet自己的注解
+		if _, ok := config.Annotations[k]; !ok {
+			config.Annotations[k] = v
+		}
+	}
+
+	sandboxConfig := &runtimeapi.PodSandboxConfig{
+		Metadata: &runtimeapi.PodSandboxMetadata{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Uid:       string(pod.UID),
+		},
+		LogDirectory: BuildPodLogsDirectory(pod.Namespace, pod.Name, pod.UID),
+		Labels:       newPodLabels(pod),
+		Linux: &runtimeapi.LinuxPodSandboxConfig{
+			CgroupParent: opts.CgroupParent,
+		},
+	}
+
+	resp, err := this.runtimeClient.CreateContainer(ctx, &runtimeapi.CreateContainerRequest{
+		PodSandboxId:  sandboxID,
+		Config:        config,
+		SandboxConfig: sandboxConfig,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ContainerId, nil
+}
+
+func (this *RemoteRuntime) StartContainer(ctx context.Context, containerID string) error {
+	_, err := this.runtimeClient.StartContainer(ctx, &runtimeapi.StartContainerRequest{ContainerId: containerID})
+	return err
+}
+
+func (this *RemoteRuntime) StopContainer(ctx context.Context, containerID string, timeout int64) error {
+	_, err := this.runtimeClient.StopContainer(ctx, &runtimeapi.StopContainerRequest{
+		ContainerId: containerID,
+		Timeout:     timeout,
+	})
+	return err
+}
+
+func (this *RemoteRuntime) RemoveContainer(ctx context.Context, containerID string) error {
+	_, err := this.runtimeClient.RemoveContainer(ctx, &runtimeapi.RemoveContainerRequest{ContainerId: containerID})
+	return err
+}
+
+func (this *RemoteRuntime) UpdateContainerResources(ctx context.Context, containerID string, resources *runtimeapi.LinuxContainerResources) error {
+	_, err := this.runtimeClient.UpdateContainerResources(ctx, &runtimeapi.UpdateContainerResourcesRequest{
+		ContainerId: containerID,
+		Linux:       resources,
+	})
+	return err
+}
+
+func (this *RemoteRuntime) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, error) {
+	resp, err := this.runtimeClient.ExecSync(ctx, &runtimeapi.ExecSyncRequest{
+		ContainerId: containerID,
+		Cmd:         cmd,
+		Timeout:     int64(timeout.Seconds()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := append(resp.Stdout, resp.Stderr...)
+	if resp.ExitCode != 0 {
+		return output, &ExitError{Code: resp.ExitCode, Output: output}
+	}
+	return output, nil
+}
+
+// GetExec CRI返回的是运行时streaming server的一次性地址
+func (this *RemoteRuntime) GetExec(ctx context.Context, containerID string, cmd []string, opts StreamOptions) (*url.URL, error) {
+	resp, err := this.runtimeClient.Exec(ctx, &runtimeapi.ExecRequest{
+		ContainerId: containerID,
+		Cmd:         cmd,
+		Tty:         opts.TTY,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(resp.Url)
+}
+
+func (this *RemoteRuntime) GetAttach(ctx context.Context, containerID string, opts StreamOptions) (*url.URL, error) {
+	resp, err := this.runtimeClient.Attach(ctx, &runtimeapi.AttachRequest{
+		ContainerId: containerID,
+		Tty:         opts.TTY,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(resp.Url)
+}
+
+func (this *RemoteRuntime) GetPortForward(ctx context.Context, podSandboxID string, ports []int32) (*url.URL, error) {
+	resp, err := this.runtimeClient.PortForward(ctx, &runtimeapi.PortForwardRequest{
+		PodSandboxId: podSandboxID,
+		Port:         ports,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(resp.Url)
+}
+
+func (this *RemoteRuntime) GetPods(ctx context.Context) ([]*Pod, error) {
+	pods := map[types.UID]*Pod{}
+	getPod := func(labels map[string]string) *Pod {
+		uid := types.UID(labels[KubernetesPodUIDLabel])
+		if uid == "" {
+			return nil
+		}
+		if _, ok := pods[uid]; !ok {
+			pods[uid] = &Pod{
+				ID:        uid,
+				Name:      labels[KubernetesPodNameLabel],
+				Namespace: labels[KubernetesPodNamespaceLabel],
+			}
+		}
+		return pods[uid]
+	}
+
+	sandboxes, err := this.runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sandboxes.Items {
+		pod := getPod(s.Labels)
+		if pod == nil {
+			continue
+		}
+		state := ContainerStateExited
+		if s.State == runtimeapi.PodSandboxState_SANDBOX_READY {
+			state = ContainerStateRunning
+		}
+		pod.Sandboxes = append(pod.Sandboxes, &Container{
+			ID:      BuildContainerID(this.runtimeName, s.Id),
+			State:   state,
+			Created: time.Unix(0, s.CreatedAt),
+		})
+	}
+
+	containers, err := this.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers.Containers {
+		pod := getPod(c.Labels)
+		if pod == nil {
+			continue
+		}
+		pod.Containers = append(pod.Containers, &Container{
+			ID:           BuildContainerID(this.runtimeName, c.Id),
+			Name:         c.Labels[KubernetesContainerNameLabel],
+			Image:        c.Image.GetImage(),
+			ImageRef:     c.ImageRef,
+			State:        toContainerState(c.State),
+			Created:      time.Unix(0, c.CreatedAt),
+			PodSandboxID: c.PodSandboxId,
+		})
+	}
+
+	ret := make([]*Pod, 0, len(pods))
+	for _, pod := range pods {
+		ret = append(ret, pod)
+	}
+	return ret, nil
+}
+
+func (this *RemoteRuntime) GetPodStatus(ctx context.Context, uid types.UID, name, namespace string) (*PodStatus, error) {
+	podStatus := &PodStatus{ID: uid, Name: name, Namespace: namespace}
+	filterLabels := map[string]string{KubernetesPodUIDLabel: string(uid)}
+
+	sandboxes, err := this.runtimeClient.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+		Filter: &runtimeapi.PodSandboxFilter{LabelSelector: filterLabels},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sandboxes.Items {
+		resp, err := this.runtimeClient.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{PodSandboxId: s.Id})
+		if err != nil {
+			return nil, err
+		}
+		ss := &SandboxStatus{
+			ID:        s.Id,
+			Ready:     resp.Status.State == runtimeapi.PodSandboxState_SANDBOX_READY,
+			CreatedAt: time.Unix(0, resp.Status.CreatedAt),
+			Attempt:   resp.Status.Metadata.GetAttempt(),
+		}
+		if resp.Status.Network != nil {
+			ss.IP = resp.Status.Network.Ip
+		}
+		podStatus.SandboxStatuses = append(podStatus.SandboxStatuses, ss)
+	}
+	sort.Slice(podStatus.SandboxStatuses, func(i, j int) bool {
+		return podStatus.SandboxStatuses[i].CreatedAt.After(podStatus.SandboxStatuses[j].CreatedAt)
+	})
+	if len(podStatus.SandboxStatuses) > 0 && podStatus.SandboxStatuses[0].Ready && podStatus.SandboxStatuses[0].IP != "" {
+		podStatus.IPs = []string{podStatus.SandboxStatuses[0].IP}
+	}
+
+	containers, err := this.runtimeClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{LabelSelector: filterLabels},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers.Containers {
+		cs, err := this.GetContainerStatus(ctx, c.Id)
+		if err != nil {
+			return nil, err
+		}
+		podStatus.ContainerStatuses = append(podStatus.ContainerStatuses, cs)
+	}
+	sort.Slice(podStatus.ContainerStatuses, func(i, j int) bool {
+		return podStatus.ContainerStatuses[i].CreatedAt.After(podStatus.ContainerStatuses[j].CreatedAt)
+	})
+
+	return podStatus, nil
+}
+
+func (this *RemoteRuntime) GetContainerStatus(ctx context.Context, containerID string) (*ContainerStatus, error) {
+	resp, err := this.runtimeClient.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: containerID})
+	if err != nil {
+		return nil, err
+	}
+	s := resp.Status
+	restartCount, _ := strconv.Atoi(s.Annotations[containerRestartCountAnnotation])
+	cs := &ContainerStatus{
+		ID:           BuildContainerID(this.runtimeName, s.Id),
+		Name:         s.Labels[KubernetesContainerNameLabel],
+		State:        toContainerState(s.State),
+		CreatedAt:    time.Unix(0, s.CreatedAt),
+		ExitCode:     int(s.ExitCode),
+		Image:        s.Image.GetImage(),
+		ImageID:      s.ImageRef,
+		RestartCount: restartCount,
+		Reason:       s.Reason,
+		Message:      s.Message,
+		LogPath:      s.LogPath,
+	}
+	if s.StartedAt > 0 {
+		cs.StartedAt = time.Unix(0, s.StartedAt)
+	}
+	if s.FinishedAt > 0 {
+		cs.FinishedAt = time.Unix(0, s.FinishedAt)
+	}
+	return cs, nil
+}
+
+func (this *RemoteRuntime) ReopenContainerLog(ctx context.Context, containerID string) error {
+	_, err := this.runtimeClient.ReopenContainerLog(ctx, &runtimeapi.ReopenContainerLogRequest{ContainerId: containerID})
+	return err
+}
+
+func toContainerState(state runtimeapi.ContainerState) ContainerState {
+	switch state {
+	case runtimeapi.ContainerState_CONTAINER_CREATED:
+		return ContainerStateCreated
+	case runtimeapi.ContainerState_CONTAINER_RUNNING:
+		return ContainerStateRunning
+	case runtimeapi.ContainerState_CONTAINER_EXITED:
+		return ContainerStateExited
+	}
+	return ContainerStateUnknown
+}
+
+func (this *RemoteRuntime) PullImage(ctx context.Context, image string, auth *AuthConfig) (string, error) {
+	req := &runtimeapi.PullImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	}
+	if auth != nil {
+		req.Auth = &runtimeapi.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			Auth:          auth.Auth,
+			ServerAddress: auth.ServerAddress,
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+	}
+	resp, err := this.imageClient.PullImage(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.ImageRef, nil
+}
+
+func (this *RemoteRuntime) GetImageRef(ctx context.Context, image string) (string, error) {
+	resp, err := this.imageClient.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Image == nil {
+		return "", nil
+	}
+	return resp.Image.Id, nil
+}
+
+func (this *RemoteRuntime) ImageFsInfo(ctx context.Context) (*FsUsage, error) {
+	resp, err := this.imageClient.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.ImageFilesystems) == 0 {
+		return nil, fmt.Errorf("runtime returned no image filesystem")
+	}
+	fs := resp.ImageFilesystems[0]
+	return &FsUsage{
+		Mountpoint: fs.GetFsId().GetMountpoint(),
+		UsedBytes:  fs.GetUsedBytes().GetValue(),
+		InodesUsed: fs.GetInodesUsed().GetValue(),
+	}, nil
+}
+
+func (this *RemoteRuntime) ListImages(ctx context.Context) ([]Image, error) {
+	resp, err := this.imageClient.ListImages(ctx, &runtimeapi.ListImagesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, 0, len(resp.Images))
+	for _, img := range resp.Images {
+		images = append(images, Image{
+			ID:          img.Id,
+			RepoTags:    img.RepoTags,
+			RepoDigests: img.RepoDigests,
+			Size:        img.Size_,
+			Pinned:      img.Pinned,
+		})
+	}
+	return images, nil
+}
+
+func (this *RemoteRuntime) RemoveImage(ctx context.Context, imageID string) error {
+	_, err := this.imageClient.RemoveImage(ctx, &runtimeapi.RemoveImageRequest{
+		Image: &runtimeapi.ImageSpec{Image: imageID},
+	})
+	return err
+}
@@ -0,0 +1,282 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 写在CRI容器和sandbox上的标签，用于从运行时反查所属的pod
+const (
+	KubernetesPodNameLabel       = "io.kubernetes.pod.name"
+	KubernetesPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	KubernetesPodUIDLabel        = "io.kubernetes.pod.uid"
+	KubernetesContainerNameLabel = "io.kubernetes.container.name"
+
+	containerRestartCountAnnotation = "io.kubernetes.container.restartCount"
+)
+
+// Runtime 容器运行时接口，屏蔽CRI的细节，kubelet的同步逻辑都构建在它之上
+type Runtime interface {
+	ImageService
+
+	// Type 运行时名称，如containerd，用于拼接status里的containerID
+	Type() string
+	// GetPods 列出运行时里属于kubelet管理的pod（包含已退出的容器）
+	GetPods(ctx context.Context) ([]*Pod, error)
+	// GetPodStatus 获取pod在运行时中的状态
+	GetPodStatus(ctx context.Context, uid types.UID, name, namespace string) (*PodStatus, error)
+
+	RunPodSandbox(ctx context.Context, pod *v1.Pod, attempt uint32, opts *RunPodSandboxOptions) (string, error)
+	StopPodSandbox(ctx context.Context, sandboxID string) error
+	RemovePodSandbox(ctx context.Context, sandboxID string) error
+	// GetPodSandboxNetNS sandbox网络命名空间的路径，kubelet通过网络插件配置pod网络时使用
+	GetPodSandboxNetNS(ctx context.Context, sandboxID string) (string, error)
+
+	CreateContainer(ctx context.Context, sandboxID string, pod *v1.Pod, container *v1.Container,
+		restartCount int, opts *RunContainerOptions) (string, error)
+	StartContainer(ctx context.Context, containerID string) error
+	StopContainer(ctx context.Context, containerID string, timeout int64) error
+	RemoveContainer(ctx context.Context, containerID string) error
+	// UpdateContainerResources 更新运行中容器的cgroup，如cpu管理器调整容器可以使用的cpu
+	UpdateContainerResources(ctx context.Context, containerID string, resources *runtimeapi.LinuxContainerResources) error
+
+	// ExecSync 在容器中同步执行命令，命令退出码非0时返回*ExitError
+	ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, error)
+	// GetExec 返回运行时streaming server上exec的地址，kubelet把客户端的连接代理过去
+	GetExec(ctx context.Context, containerID string, cmd []string, opts StreamOptions) (*url.URL, error)
+	// GetAttach 返回attach到容器主进程的地址
+	GetAttach(ctx context.Context, containerID string, opts StreamOptions) (*url.URL, error)
+	// GetPortForward 返回转发到sandbox网络命名空间端口的地址
+	GetPortForward(ctx context.Context, podSandboxID string, ports []int32) (*url.URL, error)
+
+	// GetContainerStatus 获取单个容器的状态
+	GetContainerStatus(ctx context.Context, containerID string) (*ContainerStatus, error)
+	// ReopenContainerLog 让运行时重新打开容器的日志文件，日志轮转后调用
+	ReopenContainerLog(ctx context.Context, containerID string) error
+}
+
+// ImageService 镜像相关操作
+type ImageService interface {
+	// PullImage 拉取镜像，返回镜像的引用（ID或digest），auth为空时匿名拉取
+	PullImage(ctx context.Context, image string, auth *AuthConfig) (string, error)
+	// GetImageRef 镜像存在时返回引用，不存在返回空字符串
+	GetImageRef(ctx context.Context, image string) (string, error)
+	// ImageFsInfo 镜像所在文件系统的使用情况
+	ImageFsInfo(ctx context.Context) (*FsUsage, error)
+	// ListImages 列出运行时中的所有镜像
+	ListImages(ctx context.Context) ([]Image, error)
+	// RemoveImage 删除镜像，imageID为镜像的ID
+	RemoveImage(ctx context.Context, imageID string) error
+}
+
+// Image 运行时中的镜像
+type Image struct {
+	ID          string
+	RepoTags    []string
+	RepoDigests []string
+	// 镜像占用的磁盘空间
+	Size uint64
+	// 运行时标记为不能删除的镜像，如pause镜像
+	Pinned bool
+}
+
+// AuthConfig 拉取镜像时使用的镜像仓库凭据
+type AuthConfig struct {
+	Username      string
+	Password      string
+	Auth          string
+	ServerAddress string
+	// 仓库返回的身份令牌，用于换取访问令牌
+	IdentityToken string
+	// 直接用于访问仓库的令牌
+	RegistryToken string
+}
+
+// FsUsage 运行时统计的文件系统占用，容量需要通过挂载点自行获取
+type FsUsage struct {
+	Mountpoint string
+	UsedBytes  uint64
+	InodesUsed uint64
+}
+
+// RunPodSandboxOptions 创建sandbox时的额外参数
+type RunPodSandboxOptions struct {
+	// pod的cgroup，格式和运行时的cgroup驱动一致，为空时由运行时决定
+	CgroupParent string
+}
+
+// RunContainerOptions 创建容器时的额外参数
+type RunContainerOptions struct {
+	Envs    []EnvVar
+	Mounts  []Mount
+	LogPath string
+	// 和创建sandbox时相同的pod cgroup
+	CgroupParent string
+	// 容器cgroup的cpu和内存限制
+	Resources *runtimeapi.LinuxContainerResources
+	// 设备插件分配给容器的设备节点
+	Devices []DeviceInfo
+	// 设备插件要求传给运行时的注解
+	Annotations map[string]string
+}
+
+// StreamOptions exec/attach需要连接的标准流，TTY时stderr合并到stdout
+type StreamOptions struct {
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+	TTY    bool
+}
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+type Mount struct {
+	HostPath      string
+	ContainerPath string
+	ReadOnly      bool
+}
+
+// DeviceInfo 映射到容器中的主机设备，Permissions为cgroup设备权限，如rwm
+type DeviceInfo struct {
+	PathOnHost      string
+	PathInContainer string
+	Permissions     string
+}
+
+// ContainerID 容器ID，格式为 <type>://<id>
+type ContainerID struct {
+	Type string
+	ID   string
+}
+
+// ParseContainerID 解析pod status里的containerID
+func ParseContainerID(containerID string) ContainerID {
+	var id ContainerID
+	parts := strings.SplitN(containerID, "://", 2)
+	if len(parts) != 2 {
+		return id
+	}
+	id.Type, id.ID = parts[0], parts[1]
+	return id
+}
+
+func BuildContainerID(typ, id string) ContainerID {
+	return ContainerID{Type: typ, ID: id}
+}
+
+func (this ContainerID) String() string {
+	return fmt.Sprintf("%s://%s", this.Type, this.ID)
+}
+
+func (this ContainerID) IsEmpty() bool {
+	return this.ID == ""
+}
+
+type ContainerState string
+
+const (
+	ContainerStateCreated ContainerState = "created"
+	ContainerStateRunning ContainerState = "running"
+	ContainerStateExited  ContainerState = "exited"
+	ContainerStateUnknown ContainerState = "unknown"
+)
+
+// Container 运行时中的容器（或sandbox）概要
+type Container struct {
+	ID    ContainerID
+	Name  string
+	Image string
+	// 容器使用的镜像ID，sandbox为空
+	ImageRef string
+	State    ContainerState
+	Created  time.Time
+	// 容器所在的sandbox，sandbox自身为空
+	PodSandboxID string
+}
+
+// Pod 运行时中按pod uid聚合的容器
+type Pod struct {
+	ID         types.UID
+	Name       string
+	Namespace  string
+	Containers []*Container
+	Sandboxes  []*Container
+}
+
+// ContainerStatus 运行时中容器的详细状态
+type ContainerStatus struct {
+	ID           ContainerID
+	Name         string
+	State        ContainerState
+	CreatedAt    time.Time
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	ExitCode     int
+	Image        string
+	ImageID      string
+	RestartCount int
+	Reason       string
+	Message      string
+	LogPath      string
+}
+
+// SandboxStatus pod sandbox的状态
+type SandboxStatus struct {
+	ID        string
+	Ready     bool
+	CreatedAt time.Time
+	Attempt   uint32
+	IP        string
+}
+
+// PodStatus pod在运行时中的状态
+type PodStatus struct {
+	ID        types.UID
+	Name      string
+	Namespace string
+	IPs       []string
+	// 按创建时间倒序
+	SandboxStatuses []*SandboxStatus
+	// 按创建时间倒序，同名容器的第一个是最新的实例
+	ContainerStatuses []*ContainerStatus
+}
+
+// FindContainerStatusByName 返回同名容器最新一次的状态
+func (this *PodStatus) FindContainerStatusByName(name string) *ContainerStatus {
+	for _, cs := range this.ContainerStatuses {
+		if cs.Name == name {
+			return cs
+		}
+	}
+	return nil
+}
+
+// GetRunningContainerStatuses 返回正在运行的容器
+func (this *PodStatus) GetRunningContainerStatuses() []*ContainerStatus {
+	ret := []*ContainerStatus{}
+	for _, cs := range this.ContainerStatuses {
+		if cs.State == ContainerStateRunning {
+			ret = append(ret, cs)
+		}
+	}
+	return ret
+}
+
+// ExitError ExecSync的命令以非0退出码结束
+type ExitError struct {
+	Code   int32
+	Output []byte
+}
+
+func (this *ExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", this.Code)
+}
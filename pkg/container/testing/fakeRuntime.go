@@ -0,0 +1,221 @@
+package testing
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"mykubelet/pkg/container"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// StopContainerCall 一次StopContainer调用的参数
+type StopContainerCall struct {
+	ContainerID string
+	Timeout     int64
+}
+
+// FakeRuntime 测试用的容器运行时，返回预先设置的数据并记录调用
+// 需要自定义行为的方法可以设置对应的Func
+// pkg/kubelet/container/testing/fake_runtime.go
+type FakeRuntime struct {
+	sync.Mutex
+	CalledFunctions []string
+	StopCalls       []StopContainerCall
+
+	Pods       []*container.Pod
+	PodStatus  map[types.UID]*container.PodStatus
+	Images     []container.Image
+	ImageFs    *container.FsUsage
+	NetNS      string
+	StreamURL  *url.URL
+	Err        error
+	ExecSyncFn func(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, error)
+	// StopContainerFn 在记录调用之后执行，可以用来模拟耗时的停止
+	StopContainerFn func(ctx context.Context, containerID string, timeout int64) error
+	// PullImageFn 设置后代替默认的拉取，可以检查使用的凭据或只让拉取失败
+	PullImageFn func(ctx context.Context, image string, auth *container.AuthConfig) (string, error)
+}
+
+var _ container.Runtime = &FakeRuntime{}
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{PodStatus: map[types.UID]*container.PodStatus{}}
+}
+
+func (this *FakeRuntime) record(name string) {
+	this.Lock()
+	defer this.Unlock()
+	this.CalledFunctions = append(this.CalledFunctions, name)
+}
+
+// GetStopCalls 返回StopContainer调用记录的副本
+func (this *FakeRuntime) GetStopCalls() []StopContainerCall {
+	this.Lock()
+	defer this.Unlock()
+	return append([]StopContainerCall{}, this.StopCalls...)
+}
+
+// SetPodStatus 设置GetPodStatus返回的状态
+func (this *FakeRuntime) SetPodStatus(status *container.PodStatus) {
+	this.Lock()
+	defer this.Unlock()
+	this.PodStatus[status.ID] = status
+}
+
+func (this *FakeRuntime) Type() string {
+	return "fake"
+}
+
+func (this *FakeRuntime) GetPods(_ context.Context) ([]*container.Pod, error) {
+	this.record("GetPods")
+	this.Lock()
+	defer this.Unlock()
+	return this.Pods, this.Err
+}
+
+func (this *FakeRuntime) GetPodStatus(_ context.Context, uid types.UID, name, namespace string) (*container.PodStatus, error) {
+	this.record("GetPodStatus")
+	this.Lock()
+	defer this.Unlock()
+	if status, ok := this.PodStatus[uid]; ok {
+		return status, this.Err
+	}
+	return &container.PodStatus{ID: uid, Name: name, Namespace: namespace}, this.Err
+}
+
+func (this *FakeRuntime) RunPodSandbox(_ context.Context, pod *v1.Pod, _ uint32, _ *container.RunPodSandboxOptions) (string, error) {
+	this.record("RunPodSandbox")
+	return "sandbox-" + string(pod.UID), this.Err
+}
+
+func (this *FakeRuntime) StopPodSandbox(_ context.Context, _ string) error {
+	this.record("StopPodSandbox")
+	return this.Err
+}
+
+func (this *FakeRuntime) RemovePodSandbox(_ context.Context, _ string) error {
+	this.record("RemovePodSandbox")
+	return this.Err
+}
+
+func (this *FakeRuntime) GetPodSandboxNetNS(_ context.Context, _ string) (string, error) {
+	this.record("GetPodSandboxNetNS")
+	return this.NetNS, this.Err
+}
+
+func (this *FakeRuntime) CreateContainer(_ context.Context, _ string, _ *v1.Pod, c *v1.Container, _ int,
+	_ *container.RunContainerOptions) (string, error) {
+	this.record("CreateContainer")
+	return c.Name, this.Err
+}
+
+func (this *FakeRuntime) StartContainer(_ context.Context, _ string) error {
+	this.record("StartContainer")
+	return this.Err
+}
+
+func (this *FakeRuntime) StopContainer(ctx context.Context, containerID string, timeout int64) error {
+	this.record("StopContainer")
+	this.Lock()
+	this.StopCalls = append(this.StopCalls, StopContainerCall{ContainerID: containerID, Timeout: timeout})
+	fn := this.StopContainerFn
+	this.Unlock()
+	if fn != nil {
+		return fn(ctx, containerID, timeout)
+	}
+	return this.Err
+}
+
+func (this *FakeRuntime) RemoveContainer(_ context.Context, _ string) error {
+	this.record("RemoveContainer")
+	return this.Err
+}
+
+func (this *FakeRuntime) UpdateContainerResources(_ context.Context, _ string, _ *runtimeapi.LinuxContainerResources) error {
+	this.record("UpdateContainerResources")
+	return this.Err
+}
+
+func (this *FakeRuntime) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, error) {
+	this.record("ExecSync")
+	if this.ExecSyncFn != nil {
+		return this.ExecSyncFn(ctx, containerID, cmd, timeout)
+	}
+	return nil, this.Err
+}
+
+func (this *FakeRuntime) GetExec(_ context.Context, _ string, _ []string, _ container.StreamOptions) (*url.URL, error) {
+	this.record("GetExec")
+	return this.StreamURL, this.Err
+}
+
+func (this *FakeRuntime) GetAttach(_ context.Context, _ string, _ container.StreamOptions) (*url.URL, error) {
+	this.record("GetAttach")
+	return this.StreamURL, this.Err
+}
+
+func (this *FakeRuntime) GetPortForward(_ context.Context, _ string, _ []int32) (*url.URL, error) {
+	this.record("GetPortForward")
+	return this.StreamURL, this.Err
+}
+
+func (this *FakeRuntime) GetContainerStatus(_ context.Context, containerID string) (*container.ContainerStatus, error) {
+	this.record("GetContainerStatus")
+	this.Lock()
+	defer this.Unlock()
+	for _, podStatus := range this.PodStatus {
+		for _, cs := range podStatus.ContainerStatuses {
+			if cs.ID.ID == containerID {
+				return cs, this.Err
+			}
+		}
+	}
+	return nil, this.Err
+}
+
+func (this *FakeRuntime) ReopenContainerLog(_ context.Context, _ string) error {
+	this.record("ReopenContainerLog")
+	return this.Err
+}
+
+func (this *FakeRuntime) PullImage(ctx context.Context, image string, auth *container.AuthConfig) (string, error) {
+	this.record("PullImage")
+	if this.PullImageFn != nil {
+		return this.PullImageFn(ctx, image, auth)
+	}
+	return image, this.Err
+}
+
+func (this *FakeRuntime) GetImageRef(_ context.Context, image string) (string, error) {
+	this.record("GetImageRef")
+	this.Lock()
+	defer this.Unlock()
+	for _, img := range this.Images {
+		for _, tag := range img.RepoTags {
+			if tag == image {
+				return img.ID, this.Err
+			}
+		}
+	}
+	return "", this.Err
+}
+
+func (this *FakeRuntime) ImageFsInfo(_ context.Context) (*container.FsUsage, error) {
+	this.record("ImageFsInfo")
+	return this.ImageFs, this.Err
+}
+
+func (this *FakeRuntime) ListImages(_ context.Context) ([]container.Image, error) {
+	this.record("ListImages")
+	this.Lock()
+	defer this.Unlock()
+	return this.Images, this.Err
+}
+
+func (this *FakeRuntime) RemoveImage(_ context.Context, _ string) error {
+	this.record("RemoveImage")
+	return this.Err
+}
@@ -0,0 +1,44 @@
+package events
+
+// 容器相关事件的reason
+// pkg/kubelet/events/event.go
+const (
+	CreatedContainer        = "Created"
+	StartedContainer        = "Started"
+	FailedToCreateContainer = "Failed"
+	FailedToStartContainer  = "Failed"
+	KillingContainer        = "Killing"
+	BackOffStartContainer   = "BackOff"
+)
+
+// 镜像相关事件的reason
+const (
+	PullingImage            = "Pulling"
+	PulledImage             = "Pulled"
+	FailedToPullImage       = "Failed"
+	FailedToInspectImage    = "InspectFailed"
+	ErrImageNeverPullPolicy = "ErrImageNeverPull"
+	BackOffPullImage        = "BackOff"
+)
+
+// 生命周期钩子相关事件的reason
+const (
+	FailedPostStartHook = "FailedPostStartHook"
+	FailedPreStopHook   = "FailedPreStopHook"
+)
+
+// 卷相关事件的reason
+const (
+	FailedMountVolume = "FailedMount"
+)
+
+// pod cgroup相关事件的reason
+const (
+	FailedToCreatePodContainer = "FailedCreatePodContainer"
+)
+
+// sandbox相关事件的reason
+const (
+	FailedCreatePodSandBox = "FailedCreatePodSandBox"
+	FailedStatusPodSandBox = "FailedPodSandBoxStatus"
+)
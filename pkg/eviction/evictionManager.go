@@ -0,0 +1,301 @@
+package eviction
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/lifecycle"
+	"sync"
+	"time"
+)
+
+const (
+	// 被驱逐pod的状态reason和事件reason
+	Reason = "Evicted"
+	// 尝试回收资源时在节点上记录的事件
+	thresholdMetReason = "EvictionThresholdMet"
+
+	// 获取资源统计的超时时间
+	summaryTimeout = 30 * time.Second
+	// 驱逐后等待pod清理的时间，避免在资源回收前继续驱逐其他pod
+	podCleanupTimeout  = 30 * time.Second
+	podCleanupPollFreq = time.Second
+
+	// 系统关键pod的优先级，不会被驱逐
+	systemCriticalPriority = 2 * 1000000000
+
+	// 有压力时拒绝pod的说明
+	nodeConditionMessageFmt = "The node had condition: %v. "
+)
+
+// PodCleanedUpFunc pod的容器是否已经全部停止
+type PodCleanedUpFunc func(pod *v1.Pod) bool
+
+// Manager 监控节点资源，满足驱逐阈值时设置压力condition并按顺序驱逐pod
+// pkg/kubelet/eviction/eviction_manager.go
+type Manager struct {
+	clock           clock.WithTicker
+	config          Config
+	killPodFunc     KillPodFunc
+	summaryProvider SummaryProvider
+	activePodsFunc  ActivePodsFunc
+	podCleanedUp    PodCleanedUpFunc
+	imageGC         ImageGC
+	containerGC     ContainerGC
+	recorder        record.EventRecorder
+	nodeRef         *v1.ObjectReference
+
+	lock sync.RWMutex
+	// 当前的压力condition
+	nodeConditions []v1.NodeConditionType
+	// condition最后一次被观测到的时间
+	nodeConditionsLastObservedAt nodeConditionsObservedAt
+	// 阈值第一次被观测到满足的时间
+	thresholdsFirstObservedAt thresholdsObservedAt
+	// 上一次满足的阈值，需要回收到最少回收量以上才解除
+	thresholdsMet []Threshold
+}
+
+// NewManager 创建驱逐管理器
+func NewManager(summaryProvider SummaryProvider, config Config, killPodFunc KillPodFunc, activePodsFunc ActivePodsFunc,
+	podCleanedUp PodCleanedUpFunc, imageGC ImageGC, containerGC ContainerGC, recorder record.EventRecorder, nodeName string,
+	clock clock.WithTicker) *Manager {
+	return &Manager{
+		clock:           clock,
+		config:          config,
+		killPodFunc:     killPodFunc,
+		summaryProvider: summaryProvider,
+		activePodsFunc:  activePodsFunc,
+		podCleanedUp:    podCleanedUp,
+		imageGC:         imageGC,
+		containerGC:     containerGC,
+		recorder:        recorder,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		nodeConditionsLastObservedAt: nodeConditionsObservedAt{},
+		thresholdsFirstObservedAt:    thresholdsObservedAt{},
+	}
+}
+
+// Start 每隔monitoringInterval检查一次驱逐阈值
+func (this *Manager) Start(monitoringInterval time.Duration, stopCh <-chan struct{}) {
+	klog.InfoS("Eviction manager: starting control loop", "thresholds", len(this.config.Thresholds))
+	go wait.Until(func() {
+		if pod := this.synchronize(); pod != nil {
+			this.waitForPodCleanup(pod, stopCh)
+		}
+	}, monitoringInterval, stopCh)
+}
+
+// IsUnderMemoryPressure 节点是否有内存压力
+func (this *Manager) IsUnderMemoryPressure() bool {
+	return this.hasNodeCondition(v1.NodeMemoryPressure)
+}
+
+// IsUnderDiskPressure 节点是否有磁盘压力
+func (this *Manager) IsUnderDiskPressure() bool {
+	return this.hasNodeCondition(v1.NodeDiskPressure)
+}
+
+// IsUnderPIDPressure 节点是否有进程号压力
+func (this *Manager) IsUnderPIDPressure() bool {
+	return this.hasNodeCondition(v1.NodePIDPressure)
+}
+
+// Admit 有磁盘压力时拒绝新的pod，系统关键pod和静态pod除外
+// pkg/kubelet/eviction/eviction_manager.go Admit
+func (this *Manager) Admit(attrs *lifecycle.PodAdmitAttributes) lifecycle.PodAdmitResult {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if !hasNodeCondition(this.nodeConditions, v1.NodeDiskPressure) || isCriticalPod(attrs.Pod) {
+		return lifecycle.PodAdmitResult{Admit: true}
+	}
+	klog.InfoS("Failed to admit pod to node", "pod", klog.KObj(attrs.Pod), "nodeCondition", this.nodeConditions)
+	return lifecycle.PodAdmitResult{
+		Admit:   false,
+		Reason:  Reason,
+		Message: fmt.Sprintf(nodeConditionMessageFmt, this.nodeConditions),
+	}
+}
+
+func (this *Manager) hasNodeCondition(condition v1.NodeConditionType) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return hasNodeCondition(this.nodeConditions, condition)
+}
+
+// synchronize 检查阈值并更新压力condition，需要回收资源时驱逐一个pod，返回被驱逐的pod
+func (this *Manager) synchronize() *v1.Pod {
+	thresholds := this.config.Thresholds
+	if len(thresholds) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+	defer cancel()
+	activePods := this.activePodsFunc()
+	summary, err := this.summaryProvider.GetSummary(ctx, false)
+	if err != nil {
+		klog.ErrorS(err, "Eviction manager: failed to get summary stats")
+		return nil
+	}
+	observations := makeSignalObservations(summary)
+	now := this.clock.Now()
+
+	this.lock.Lock()
+	// 之前满足的阈值在回收到最少回收量以上之前仍然视为满足
+	met := thresholdsMet(thresholds, observations, false)
+	if len(this.thresholdsMet) > 0 {
+		met = mergeThresholds(met, thresholdsMet(this.thresholdsMet, observations, true))
+	}
+	firstObservedAt := thresholdsFirstObservedAt(met, this.thresholdsFirstObservedAt, now)
+	conditions := nodeConditions(met)
+	if len(conditions) > 0 {
+		klog.V(3).InfoS("Eviction manager: node conditions observed", "nodeConditions", conditions)
+	}
+	conditionsLastObservedAt := nodeConditionsLastObservedAt(conditions, this.nodeConditionsLastObservedAt, now)
+	this.nodeConditions = nodeConditionsObservedSince(conditionsLastObservedAt, this.config.PressureTransitionPeriod, now)
+	this.nodeConditionsLastObservedAt = conditionsLastObservedAt
+	this.thresholdsFirstObservedAt = firstObservedAt
+	this.thresholdsMet = met
+	this.lock.Unlock()
+
+	// 软驱逐阈值需要持续满足超过宽限期
+	met = thresholdsMetGracePeriod(met, firstObservedAt, now)
+	if len(met) == 0 {
+		klog.V(3).InfoS("Eviction manager: no resources are starved")
+		return nil
+	}
+
+	sortThresholds(met)
+	threshold := met[0]
+	resourceToReclaim := signalToResource[threshold.Signal]
+	klog.InfoS("Eviction manager: attempting to reclaim", "resourceName", resourceToReclaim)
+	this.recorder.Eventf(this.nodeRef, v1.EventTypeWarning, thresholdMetReason, "Attempting to reclaim %s", resourceToReclaim)
+
+	// 磁盘压力时先回收镜像和容器，足够时不需要驱逐pod
+	if this.reclaimNodeLevelResources(ctx, threshold, thresholds) {
+		klog.InfoS("Eviction manager: able to reduce resource pressure without evicting pods", "resourceName", resourceToReclaim)
+		return nil
+	}
+
+	if len(activePods) == 0 {
+		klog.ErrorS(nil, "Eviction manager: eviction thresholds have been met, but no pods are active to evict")
+		return nil
+	}
+	stats := cachedStatsFunc(summary.Pods)
+	rankPods(threshold.Signal, activePods, stats)
+	klog.InfoS("Eviction manager: pods ranked for eviction", "pods", klog.KObjSlice(activePods))
+
+	// 每次只驱逐一个pod
+	for _, pod := range activePods {
+		gracePeriodOverride := int64(0)
+		if !isHardEvictionThreshold(threshold) {
+			gracePeriodOverride = this.config.MaxPodGracePeriodSeconds
+			if pod.Spec.TerminationGracePeriodSeconds != nil && *pod.Spec.TerminationGracePeriodSeconds < gracePeriodOverride {
+				gracePeriodOverride = *pod.Spec.TerminationGracePeriodSeconds
+			}
+		}
+		message := evictionMessage(threshold, observations, pod, stats)
+		if this.evictPod(pod, gracePeriodOverride, message) {
+			return pod
+		}
+	}
+	klog.InfoS("Eviction manager: unable to evict any pods from the node")
+	return nil
+}
+
+// reclaimNodeLevelResources 回收节点级的磁盘资源，回收后阈值不再满足时返回true
+func (this *Manager) reclaimNodeLevelResources(ctx context.Context, threshold Threshold, thresholds []Threshold) bool {
+	if threshold.Signal != SignalNodeFsAvailable && threshold.Signal != SignalImageFsAvailable {
+		return false
+	}
+	if err := this.containerGC.DeleteAllUnusedContainers(ctx); err != nil {
+		klog.ErrorS(err, "Eviction manager: failed to delete unused containers")
+	}
+	if err := this.imageGC.DeleteUnusedImages(ctx); err != nil {
+		klog.ErrorS(err, "Eviction manager: failed to delete unused images")
+	}
+
+	summary, err := this.summaryProvider.GetSummary(ctx, false)
+	if err != nil {
+		klog.ErrorS(err, "Eviction manager: failed to get summary stats after resource reclaim")
+		return false
+	}
+	observations := makeSignalObservations(summary)
+	for _, met := range thresholdsMet(thresholds, observations, true) {
+		if met.Signal == threshold.Signal {
+			return false
+		}
+	}
+	return true
+}
+
+// 驱逐pod，系统关键pod和静态pod不驱逐
+func (this *Manager) evictPod(pod *v1.Pod, gracePeriodOverride int64, message string) bool {
+	if isCriticalPod(pod) {
+		klog.ErrorS(nil, "Eviction manager: cannot evict a critical pod", "pod", klog.KObj(pod))
+		return false
+	}
+	this.recorder.Event(pod, v1.EventTypeWarning, Reason, message)
+	err := this.killPodFunc(pod, gracePeriodOverride, func(status *v1.PodStatus) {
+		status.Phase = v1.PodFailed
+		status.Reason = Reason
+		status.Message = message
+	})
+	if err != nil {
+		klog.ErrorS(err, "Eviction manager: pod failed to evict", "pod", klog.KObj(pod))
+	} else {
+		klog.InfoS("Eviction manager: pod is evicted successfully", "pod", klog.KObj(pod))
+	}
+	return true
+}
+
+// 等待被驱逐的pod停止，资源回收之前不再驱逐其他pod
+func (this *Manager) waitForPodCleanup(pod *v1.Pod, stopCh <-chan struct{}) {
+	timeout := this.clock.After(podCleanupTimeout)
+	ticker := this.clock.NewTicker(podCleanupPollFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-timeout:
+			klog.InfoS("Eviction manager: timed out waiting for pod to be cleaned up", "pod", klog.KObj(pod))
+			return
+		case <-ticker.C():
+			if this.podCleanedUp(pod) {
+				klog.InfoS("Eviction manager: pod is successfully cleaned up", "pod", klog.KObj(pod))
+				return
+			}
+		}
+	}
+}
+
+func isHardEvictionThreshold(threshold Threshold) bool {
+	return threshold.GracePeriod == 0
+}
+
+// evictionMessage 驱逐信息，包括阈值、可用量以及pod的使用量
+func evictionMessage(threshold Threshold, observations signalObservations, pod *v1.Pod, stats statsFunc) string {
+	resourceName := signalToResource[threshold.Signal]
+	message := fmt.Sprintf("The node was low on resource: %v. ", resourceName)
+	if observed, found := observations[threshold.Signal]; found {
+		message += fmt.Sprintf("Threshold quantity: %v, available: %v. ",
+			getThresholdQuantity(threshold.Value, observed.capacity), observed.available)
+	}
+	if s, found := stats(pod); found {
+		if usage := podUsage(threshold.Signal, s); usage != nil {
+			message += fmt.Sprintf("Pod was using %v, request is %v. ", usage, podRequest(pod, resourceName))
+		}
+	}
+	return message
+}
@@ -0,0 +1,334 @@
+package eviction
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/lifecycle"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	Mi = 1024 * 1024
+	Gi = 1024 * Mi
+)
+
+// fakeSummaryProvider 返回注入的资源统计
+type fakeSummaryProvider struct {
+	lock    sync.Mutex
+	summary *statsapi.Summary
+}
+
+func (this *fakeSummaryProvider) GetSummary(_ context.Context, _ bool) (*statsapi.Summary, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.summary, nil
+}
+
+func (this *fakeSummaryProvider) set(summary *statsapi.Summary) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.summary = summary
+}
+
+// 一次驱逐的pod、宽限期和驱逐后的状态
+type podKill struct {
+	pod         *v1.Pod
+	gracePeriod int64
+	status      v1.PodStatus
+}
+
+type fakeKiller struct {
+	kills []podKill
+}
+
+func (this *fakeKiller) killPod(pod *v1.Pod, gracePeriodOverride int64, statusFn func(*v1.PodStatus)) error {
+	status := v1.PodStatus{}
+	statusFn(&status)
+	this.kills = append(this.kills, podKill{pod: pod, gracePeriod: gracePeriodOverride, status: status})
+	return nil
+}
+
+// fakeGC 回收时调用reclaim，模拟回收后的磁盘统计
+type fakeGC struct {
+	imagesDeleted     int
+	containersDeleted int
+	reclaim           func()
+}
+
+func (this *fakeGC) DeleteUnusedImages(_ context.Context) error {
+	this.imagesDeleted++
+	if this.reclaim != nil {
+		this.reclaim()
+	}
+	return nil
+}
+
+func (this *fakeGC) DeleteAllUnusedContainers(_ context.Context) error {
+	this.containersDeleted++
+	return nil
+}
+
+type testManager struct {
+	*Manager
+	summaryProvider *fakeSummaryProvider
+	killer          *fakeKiller
+	gc              *fakeGC
+	clock           *testingclock.FakeClock
+	recorder        *record.FakeRecorder
+}
+
+func newTestManager(config Config, pods ...*v1.Pod) *testManager {
+	summaryProvider := &fakeSummaryProvider{summary: &statsapi.Summary{}}
+	killer := &fakeKiller{}
+	gc := &fakeGC{}
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	recorder := record.NewFakeRecorder(100)
+	activePods := func() []*v1.Pod {
+		return append([]*v1.Pod{}, pods...)
+	}
+	podCleanedUp := func(*v1.Pod) bool {
+		return true
+	}
+	manager := NewManager(summaryProvider, config, killer.killPod, activePods, podCleanedUp, gc, gc, recorder, "node", fakeClock)
+	return &testManager{
+		Manager:         manager,
+		summaryProvider: summaryProvider,
+		killer:          killer,
+		gc:              gc,
+		clock:           fakeClock,
+		recorder:        recorder,
+	}
+}
+
+func uint64Ptr(v uint64) *uint64 {
+	return &v
+}
+
+func memorySummary(available, workingSet uint64, podWorkingSet map[*v1.Pod]uint64) *statsapi.Summary {
+	summary := &statsapi.Summary{
+		Node: statsapi.NodeStats{
+			Memory: &statsapi.MemoryStats{AvailableBytes: uint64Ptr(available), WorkingSetBytes: uint64Ptr(workingSet)},
+		},
+	}
+	for pod, usage := range podWorkingSet {
+		summary.Pods = append(summary.Pods, statsapi.PodStats{
+			PodRef: statsapi.PodReference{Name: pod.Name, Namespace: pod.Namespace, UID: string(pod.UID)},
+			Memory: &statsapi.MemoryStats{WorkingSetBytes: uint64Ptr(usage)},
+		})
+	}
+	return summary
+}
+
+func nodeFsSummary(available, capacity uint64) *statsapi.Summary {
+	return &statsapi.Summary{
+		Node: statsapi.NodeStats{
+			Fs: &statsapi.FsStats{AvailableBytes: uint64Ptr(available), CapacityBytes: uint64Ptr(capacity)},
+		},
+	}
+}
+
+func newPod(name string, memoryRequest string) *v1.Pod {
+	c := v1.Container{Name: "app"}
+	if memoryRequest != "" {
+		c.Resources.Requests = v1.ResourceList{v1.ResourceMemory: resource.MustParse(memoryRequest)}
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)},
+		Spec:       v1.PodSpec{Containers: []v1.Container{c}},
+	}
+}
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func TestHardMemoryThreshold(t *testing.T) {
+	burstable := newPod("burstable", "100Mi")
+	bestEffort := newPod("best-effort", "")
+	manager := newTestManager(Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []Threshold{{
+			Signal: SignalMemoryAvailable,
+			Value:  ThresholdValue{Quantity: quantity("1Gi")},
+		}},
+	}, burstable, bestEffort)
+
+	manager.summaryProvider.set(memorySummary(2*Gi, 6*Gi, nil))
+	if pod := manager.synchronize(); pod != nil {
+		t.Fatalf("unexpected eviction of %s", pod.Name)
+	}
+	if manager.IsUnderMemoryPressure() {
+		t.Fatalf("unexpected memory pressure")
+	}
+
+	// burstable使用量超过requests更多，但BestEffort先被驱逐
+	manager.summaryProvider.set(memorySummary(500*Mi, 7*Gi, map[*v1.Pod]uint64{burstable: 3 * Gi, bestEffort: 10 * Mi}))
+	pod := manager.synchronize()
+	if pod != bestEffort {
+		t.Fatalf("expected best-effort pod to be evicted, got %v", pod)
+	}
+	if !manager.IsUnderMemoryPressure() || manager.IsUnderDiskPressure() || manager.IsUnderPIDPressure() {
+		t.Errorf("expected only memory pressure")
+	}
+	if len(manager.killer.kills) != 1 {
+		t.Fatalf("expected 1 kill, got %d", len(manager.killer.kills))
+	}
+	kill := manager.killer.kills[0]
+	if kill.gracePeriod != 0 {
+		t.Errorf("hard eviction should use grace period 0, got %d", kill.gracePeriod)
+	}
+	if kill.status.Phase != v1.PodFailed || kill.status.Reason != Reason || kill.status.Message == "" {
+		t.Errorf("unexpected evicted pod status %+v", kill.status)
+	}
+	if len(manager.recorder.Events) == 0 {
+		t.Errorf("expected eviction events")
+	}
+}
+
+func TestSoftMemoryThresholdGracePeriod(t *testing.T) {
+	twenty := int64(20)
+	pod := newPod("pod", "")
+	pod.Spec.TerminationGracePeriodSeconds = &twenty
+	manager := newTestManager(Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		MaxPodGracePeriodSeconds: 60,
+		Thresholds: []Threshold{{
+			Signal:      SignalMemoryAvailable,
+			Value:       ThresholdValue{Quantity: quantity("1Gi")},
+			GracePeriod: time.Minute,
+		}},
+	}, pod)
+	manager.summaryProvider.set(memorySummary(500*Mi, 7*Gi, nil))
+
+	// 软驱逐阈值刚满足时只设置condition
+	if evicted := manager.synchronize(); evicted != nil {
+		t.Fatalf("unexpected eviction before grace period")
+	}
+	if !manager.IsUnderMemoryPressure() {
+		t.Errorf("expected memory pressure")
+	}
+
+	manager.clock.Step(30 * time.Second)
+	if evicted := manager.synchronize(); evicted != nil {
+		t.Fatalf("unexpected eviction before grace period")
+	}
+
+	manager.clock.Step(30 * time.Second)
+	if evicted := manager.synchronize(); evicted != pod {
+		t.Fatalf("expected pod to be evicted after grace period")
+	}
+	// pod自己的宽限期比MaxPodGracePeriodSeconds短
+	if gracePeriod := manager.killer.kills[0].gracePeriod; gracePeriod != 20 {
+		t.Errorf("expected grace period 20, got %d", gracePeriod)
+	}
+}
+
+func TestPressureTransitionPeriod(t *testing.T) {
+	manager := newTestManager(Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []Threshold{{
+			Signal:     SignalMemoryAvailable,
+			Value:      ThresholdValue{Quantity: quantity("1Gi")},
+			MinReclaim: &ThresholdValue{Quantity: quantity("500Mi")},
+		}},
+	})
+	manager.summaryProvider.set(memorySummary(500*Mi, 7*Gi, nil))
+	manager.synchronize()
+	if !manager.IsUnderMemoryPressure() {
+		t.Fatalf("expected memory pressure")
+	}
+
+	// 回收到阈值以上但没有达到最少回收量，阈值仍然满足
+	manager.clock.Step(10 * time.Minute)
+	manager.summaryProvider.set(memorySummary(1200*Mi, 7*Gi, nil))
+	manager.synchronize()
+	if !manager.IsUnderMemoryPressure() {
+		t.Fatalf("expected memory pressure until minimum reclaim is met")
+	}
+
+	// 回收量足够后，condition在PressureTransitionPeriod之后才解除
+	manager.summaryProvider.set(memorySummary(2*Gi, 6*Gi, nil))
+	manager.synchronize()
+	if !manager.IsUnderMemoryPressure() {
+		t.Fatalf("expected memory pressure during transition period")
+	}
+	manager.clock.Step(5 * time.Minute)
+	manager.synchronize()
+	if manager.IsUnderMemoryPressure() {
+		t.Errorf("expected memory pressure to be cleared after transition period")
+	}
+}
+
+func TestCriticalPodNotEvicted(t *testing.T) {
+	priority := int32(systemCriticalPriority)
+	critical := newPod("critical", "")
+	critical.Spec.Priority = &priority
+	manager := newTestManager(Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []Threshold{{
+			Signal: SignalMemoryAvailable,
+			Value:  ThresholdValue{Quantity: quantity("1Gi")},
+		}},
+	}, critical)
+	manager.summaryProvider.set(memorySummary(500*Mi, 7*Gi, nil))
+
+	if pod := manager.synchronize(); pod != nil {
+		t.Fatalf("critical pod should not be evicted")
+	}
+	if len(manager.killer.kills) != 0 {
+		t.Errorf("unexpected kills %v", manager.killer.kills)
+	}
+}
+
+func TestDiskPressureReclaimsNodeLevelResources(t *testing.T) {
+	pod := newPod("pod", "")
+	manager := newTestManager(Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []Threshold{{
+			Signal: SignalNodeFsAvailable,
+			Value:  ThresholdValue{Percentage: 0.1},
+		}},
+	}, pod)
+	manager.summaryProvider.set(nodeFsSummary(5*Gi, 100*Gi))
+	manager.gc.reclaim = func() {
+		manager.summaryProvider.set(nodeFsSummary(20*Gi, 100*Gi))
+	}
+
+	if evicted := manager.synchronize(); evicted != nil {
+		t.Fatalf("expected image and container gc to relieve disk pressure without eviction")
+	}
+	if manager.gc.imagesDeleted != 1 || manager.gc.containersDeleted != 1 {
+		t.Errorf("expected node level resources to be reclaimed once, got images %d containers %d",
+			manager.gc.imagesDeleted, manager.gc.containersDeleted)
+	}
+	if !manager.IsUnderDiskPressure() {
+		t.Errorf("expected disk pressure")
+	}
+
+	// 有磁盘压力时拒绝新的pod，系统关键pod除外
+	if result := manager.Admit(&lifecycle.PodAdmitAttributes{Pod: newPod("new", "")}); result.Admit {
+		t.Errorf("expected pod to be rejected under disk pressure")
+	}
+	priority := int32(systemCriticalPriority)
+	critical := newPod("critical", "")
+	critical.Spec.Priority = &priority
+	if result := manager.Admit(&lifecycle.PodAdmitAttributes{Pod: critical}); !result.Admit {
+		t.Errorf("expected critical pod to be admitted under disk pressure")
+	}
+
+	// 回收不足时驱逐pod
+	manager.gc.reclaim = nil
+	manager.summaryProvider.set(nodeFsSummary(5*Gi, 100*Gi))
+	if evicted := manager.synchronize(); evicted != pod {
+		t.Fatalf("expected pod to be evicted when reclaim is not enough")
+	}
+}
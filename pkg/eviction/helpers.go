@@ -0,0 +1,501 @@
+package eviction
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"mykubelet/pkg/podconfig"
+	"mykubelet/pkg/qos"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pkg/kubelet/eviction/helpers.go
+
+// ParseThresholdConfig 解析硬驱逐和软驱逐阈值
+// 值可以是资源量（100Mi）或百分比（10%），软驱逐阈值必须设置宽限期
+func ParseThresholdConfig(evictionHard, evictionSoft, evictionSoftGracePeriod, evictionMinimumReclaim map[string]string) ([]Threshold, error) {
+	results := []Threshold{}
+	hardThresholds, err := parseThresholdStatements(evictionHard)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, hardThresholds...)
+
+	softThresholds, err := parseThresholdStatements(evictionSoft)
+	if err != nil {
+		return nil, err
+	}
+	gracePeriods, err := parseGracePeriods(evictionSoftGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	for i := range softThresholds {
+		signal := softThresholds[i].Signal
+		period, found := gracePeriods[signal]
+		if !found {
+			return nil, fmt.Errorf("grace period must be specified for the soft eviction threshold %v", signal)
+		}
+		softThresholds[i].GracePeriod = period
+	}
+	results = append(results, softThresholds...)
+
+	minReclaims, err := parseMinimumReclaims(evictionMinimumReclaim)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if minReclaim, found := minReclaims[results[i].Signal]; found {
+			results[i].MinReclaim = &minReclaim
+		}
+	}
+	return results, nil
+}
+
+func parseThresholdStatements(statements map[string]string) ([]Threshold, error) {
+	results := []Threshold{}
+	for key, value := range statements {
+		signal, err := parseSignal(key)
+		if err != nil {
+			return nil, err
+		}
+		thresholdValue, err := parseThresholdValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eviction threshold %v=%q: %v", signal, value, err)
+		}
+		results = append(results, Threshold{Signal: signal, Value: thresholdValue})
+	}
+	// map的遍历顺序不固定，排序后便于比较和输出
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Signal < results[j].Signal
+	})
+	return results, nil
+}
+
+func parseSignal(key string) (Signal, error) {
+	signal := Signal(key)
+	if _, ok := signalToNodeCondition[signal]; !ok {
+		return "", fmt.Errorf("unsupported eviction signal %v", key)
+	}
+	return signal, nil
+}
+
+// 解析百分比或资源量，百分比在0到100之间，资源量不能为负数
+func parseThresholdValue(value string) (ThresholdValue, error) {
+	if strings.HasSuffix(value, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 32)
+		if err != nil {
+			return ThresholdValue{}, err
+		}
+		if percentage < 0 || percentage > 100 {
+			return ThresholdValue{}, fmt.Errorf("percentage must be between 0%% and 100%%")
+		}
+		return ThresholdValue{Percentage: float32(percentage / 100)}, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return ThresholdValue{}, err
+	}
+	if quantity.Sign() < 0 {
+		return ThresholdValue{}, fmt.Errorf("quantity must not be negative")
+	}
+	return ThresholdValue{Quantity: &quantity}, nil
+}
+
+func parseGracePeriods(statements map[string]string) (map[Signal]time.Duration, error) {
+	results := map[Signal]time.Duration{}
+	for key, value := range statements {
+		signal, err := parseSignal(key)
+		if err != nil {
+			return nil, err
+		}
+		period, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eviction grace period %v=%q: %v", signal, value, err)
+		}
+		if period < 0 {
+			return nil, fmt.Errorf("eviction grace period %v must not be negative", signal)
+		}
+		results[signal] = period
+	}
+	return results, nil
+}
+
+func parseMinimumReclaims(statements map[string]string) (map[Signal]ThresholdValue, error) {
+	results := map[Signal]ThresholdValue{}
+	for key, value := range statements {
+		signal, err := parseSignal(key)
+		if err != nil {
+			return nil, err
+		}
+		minReclaim, err := parseThresholdValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eviction minimum reclaim %v=%q: %v", signal, value, err)
+		}
+		results[signal] = minReclaim
+	}
+	return results, nil
+}
+
+// 某个信号观测到的可用量和容量
+type signalObservation struct {
+	available *resource.Quantity
+	capacity  *resource.Quantity
+	time      metav1.Time
+}
+
+type signalObservations map[Signal]signalObservation
+
+// makeSignalObservations 从资源统计中取出每个信号的可用量和容量，统计缺失的信号不观测
+func makeSignalObservations(summary *statsapi.Summary) signalObservations {
+	result := signalObservations{}
+	node := summary.Node
+	if memory := node.Memory; memory != nil && memory.AvailableBytes != nil && memory.WorkingSetBytes != nil {
+		result[SignalMemoryAvailable] = signalObservation{
+			available: resource.NewQuantity(int64(*memory.AvailableBytes), resource.BinarySI),
+			capacity:  resource.NewQuantity(int64(*memory.AvailableBytes+*memory.WorkingSetBytes), resource.BinarySI),
+			time:      memory.Time,
+		}
+	}
+	if fs := node.Fs; fs != nil && fs.AvailableBytes != nil && fs.CapacityBytes != nil {
+		result[SignalNodeFsAvailable] = signalObservation{
+			available: resource.NewQuantity(int64(*fs.AvailableBytes), resource.BinarySI),
+			capacity:  resource.NewQuantity(int64(*fs.CapacityBytes), resource.BinarySI),
+			time:      fs.Time,
+		}
+	}
+	if node.Runtime != nil {
+		if fs := node.Runtime.ImageFs; fs != nil && fs.AvailableBytes != nil && fs.CapacityBytes != nil {
+			result[SignalImageFsAvailable] = signalObservation{
+				available: resource.NewQuantity(int64(*fs.AvailableBytes), resource.BinarySI),
+				capacity:  resource.NewQuantity(int64(*fs.CapacityBytes), resource.BinarySI),
+				time:      fs.Time,
+			}
+		}
+	}
+	if rlimit := node.Rlimit; rlimit != nil && rlimit.MaxPID != nil && rlimit.NumOfRunningProcesses != nil {
+		available := *rlimit.MaxPID - *rlimit.NumOfRunningProcesses
+		result[SignalPIDAvailable] = signalObservation{
+			available: resource.NewQuantity(available, resource.DecimalSI),
+			capacity:  resource.NewQuantity(*rlimit.MaxPID, resource.DecimalSI),
+			time:      rlimit.Time,
+		}
+	}
+	return result
+}
+
+// getThresholdQuantity 百分比阈值按容量换算成资源量
+func getThresholdQuantity(value ThresholdValue, capacity *resource.Quantity) *resource.Quantity {
+	if value.Quantity != nil {
+		quantity := value.Quantity.DeepCopy()
+		return &quantity
+	}
+	return resource.NewQuantity(int64(float64(capacity.Value())*float64(value.Percentage)), resource.BinarySI)
+}
+
+// thresholdsMet 返回可用量低于阈值的阈值
+// enforceMinReclaim为true时阈值加上最少回收量，已经满足的阈值需要回收到这个量以上才解除
+func thresholdsMet(thresholds []Threshold, observations signalObservations, enforceMinReclaim bool) []Threshold {
+	results := []Threshold{}
+	for _, threshold := range thresholds {
+		observed, found := observations[threshold.Signal]
+		if !found {
+			continue
+		}
+		quantity := getThresholdQuantity(threshold.Value, observed.capacity)
+		if enforceMinReclaim && threshold.MinReclaim != nil {
+			quantity.Add(*getThresholdQuantity(*threshold.MinReclaim, observed.capacity))
+		}
+		if quantity.Cmp(*observed.available) > 0 {
+			results = append(results, threshold)
+		}
+	}
+	return results
+}
+
+// mergeThresholds 合并两组阈值并去重
+func mergeThresholds(a, b []Threshold) []Threshold {
+	results := append([]Threshold{}, a...)
+	for _, threshold := range b {
+		if !hasThreshold(results, threshold) {
+			results = append(results, threshold)
+		}
+	}
+	return results
+}
+
+func hasThreshold(thresholds []Threshold, threshold Threshold) bool {
+	for _, t := range thresholds {
+		if t.Signal == threshold.Signal && t.GracePeriod == threshold.GracePeriod && compareThresholdValue(t.Value, threshold.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareThresholdValue(a, b ThresholdValue) bool {
+	if a.Quantity != nil && b.Quantity != nil {
+		return a.Quantity.Cmp(*b.Quantity) == 0
+	}
+	return a.Quantity == nil && b.Quantity == nil && a.Percentage == b.Percentage
+}
+
+// 阈值第一次被观测到满足的时间，key为thresholdKey
+type thresholdsObservedAt map[string]time.Time
+
+// thresholdKey 阈值的唯一标识，同一个信号可以同时有硬驱逐和软驱逐阈值
+func thresholdKey(threshold Threshold) string {
+	value := fmt.Sprintf("%v", threshold.Value.Percentage)
+	if threshold.Value.Quantity != nil {
+		value = threshold.Value.Quantity.String()
+	}
+	return fmt.Sprintf("%s/%s/%s", threshold.Signal, value, threshold.GracePeriod)
+}
+
+// thresholdsFirstObservedAt 保留之前记录的首次满足时间，新满足的阈值记为now
+func thresholdsFirstObservedAt(thresholds []Threshold, lastObservedAt thresholdsObservedAt, now time.Time) thresholdsObservedAt {
+	results := thresholdsObservedAt{}
+	for _, threshold := range thresholds {
+		key := thresholdKey(threshold)
+		observedAt, found := lastObservedAt[key]
+		if !found {
+			observedAt = now
+		}
+		results[key] = observedAt
+	}
+	return results
+}
+
+// thresholdsMetGracePeriod 满足时间超过宽限期的阈值
+func thresholdsMetGracePeriod(thresholds []Threshold, observedAt thresholdsObservedAt, now time.Time) []Threshold {
+	results := []Threshold{}
+	for _, threshold := range thresholds {
+		if now.Sub(observedAt[thresholdKey(threshold)]) >= threshold.GracePeriod {
+			results = append(results, threshold)
+		}
+	}
+	return results
+}
+
+// nodeConditions 阈值对应的节点condition，去重
+func nodeConditions(thresholds []Threshold) []v1.NodeConditionType {
+	results := []v1.NodeConditionType{}
+	for _, threshold := range thresholds {
+		if condition, found := signalToNodeCondition[threshold.Signal]; found && !hasNodeCondition(results, condition) {
+			results = append(results, condition)
+		}
+	}
+	return results
+}
+
+func hasNodeCondition(conditions []v1.NodeConditionType, condition v1.NodeConditionType) bool {
+	for _, c := range conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+type nodeConditionsObservedAt map[v1.NodeConditionType]time.Time
+
+// nodeConditionsLastObservedAt 更新condition最后一次被观测到的时间
+func nodeConditionsLastObservedAt(conditions []v1.NodeConditionType, lastObservedAt nodeConditionsObservedAt, now time.Time) nodeConditionsObservedAt {
+	results := nodeConditionsObservedAt{}
+	for condition, at := range lastObservedAt {
+		results[condition] = at
+	}
+	for _, condition := range conditions {
+		results[condition] = now
+	}
+	return results
+}
+
+// nodeConditionsObservedSince 在period内观测到过的condition，避免压力状态来回切换
+func nodeConditionsObservedSince(observedAt nodeConditionsObservedAt, period time.Duration, now time.Time) []v1.NodeConditionType {
+	results := []v1.NodeConditionType{}
+	for condition, at := range observedAt {
+		if now.Sub(at) < period {
+			results = append(results, condition)
+		}
+	}
+	return results
+}
+
+// 按优先级排列阈值，内存优先处理，同一信号硬驱逐优先
+func sortThresholds(thresholds []Threshold) {
+	priority := map[Signal]int{
+		SignalMemoryAvailable:  0,
+		SignalPIDAvailable:     1,
+		SignalNodeFsAvailable:  2,
+		SignalImageFsAvailable: 3,
+	}
+	sort.SliceStable(thresholds, func(i, j int) bool {
+		if priority[thresholds[i].Signal] != priority[thresholds[j].Signal] {
+			return priority[thresholds[i].Signal] < priority[thresholds[j].Signal]
+		}
+		return thresholds[i].GracePeriod < thresholds[j].GracePeriod
+	})
+}
+
+// 获取pod的资源统计
+type statsFunc func(pod *v1.Pod) (statsapi.PodStats, bool)
+
+func cachedStatsFunc(podStats []statsapi.PodStats) statsFunc {
+	uidToPodStats := map[string]statsapi.PodStats{}
+	for _, s := range podStats {
+		uidToPodStats[s.PodRef.UID] = s
+	}
+	return func(pod *v1.Pod) (statsapi.PodStats, bool) {
+		s, found := uidToPodStats[string(pod.UID)]
+		return s, found
+	}
+}
+
+// 比较两个pod，返回-1表示p1优先被驱逐
+type cmpFunc func(p1, p2 *v1.Pod) int
+
+// multiSorter 依次使用多个比较函数排序
+type multiSorter struct {
+	pods []*v1.Pod
+	cmp  []cmpFunc
+}
+
+func orderedBy(cmp ...cmpFunc) *multiSorter {
+	return &multiSorter{cmp: cmp}
+}
+
+func (this *multiSorter) Sort(pods []*v1.Pod) {
+	this.pods = pods
+	sort.Stable(this)
+}
+
+func (this *multiSorter) Len() int {
+	return len(this.pods)
+}
+
+func (this *multiSorter) Swap(i, j int) {
+	this.pods[i], this.pods[j] = this.pods[j], this.pods[i]
+}
+
+func (this *multiSorter) Less(i, j int) bool {
+	p1, p2 := this.pods[i], this.pods[j]
+	for _, cmp := range this.cmp {
+		if result := cmp(p1, p2); result != 0 {
+			return result < 0
+		}
+	}
+	return false
+}
+
+// pod的QoS等级，状态中没有时自行计算
+func podQOS(pod *v1.Pod) v1.PodQOSClass {
+	if pod.Status.QOSClass != "" {
+		return pod.Status.QOSClass
+	}
+	return qos.GetPodQOS(pod)
+}
+
+// qosComparator BestEffort最先驱逐，其次Burstable，最后Guaranteed
+func qosComparator(p1, p2 *v1.Pod) int {
+	rank := map[v1.PodQOSClass]int{
+		v1.PodQOSBestEffort: 0,
+		v1.PodQOSBurstable:  1,
+		v1.PodQOSGuaranteed: 2,
+	}
+	return rank[podQOS(p1)] - rank[podQOS(p2)]
+}
+
+// isCriticalPod 系统关键pod和静态pod
+func isCriticalPod(pod *v1.Pod) bool {
+	return (pod.Spec.Priority != nil && *pod.Spec.Priority >= systemCriticalPriority) || podconfig.IsStaticPod(pod)
+}
+
+// podRequest 所有容器某项资源的requests之和，init容器取最大值
+func podRequest(pod *v1.Pod, name v1.ResourceName) *resource.Quantity {
+	containerValue := resource.Quantity{Format: resource.BinarySI}
+	for _, c := range pod.Spec.Containers {
+		if request, found := c.Resources.Requests[name]; found {
+			containerValue.Add(request)
+		}
+	}
+	initValue := resource.Quantity{Format: resource.BinarySI}
+	for _, c := range pod.Spec.InitContainers {
+		if request, found := c.Resources.Requests[name]; found && request.Cmp(initValue) > 0 {
+			initValue = request.DeepCopy()
+		}
+	}
+	if initValue.Cmp(containerValue) > 0 {
+		return &initValue
+	}
+	return &containerValue
+}
+
+// exceedRequests 使用量超过requests的量大的优先驱逐，没有统计的pod使用量按0计算
+func exceedRequests(stats statsFunc, usageFunc func(statsapi.PodStats) *resource.Quantity, name v1.ResourceName) cmpFunc {
+	exceed := func(pod *v1.Pod) *resource.Quantity {
+		usage := resource.NewQuantity(0, resource.BinarySI)
+		if s, found := stats(pod); found {
+			if u := usageFunc(s); u != nil {
+				usage = u
+			}
+		}
+		usage.Sub(*podRequest(pod, name))
+		return usage
+	}
+	return func(p1, p2 *v1.Pod) int {
+		return exceed(p2).Cmp(*exceed(p1))
+	}
+}
+
+func memoryUsage(s statsapi.PodStats) *resource.Quantity {
+	if s.Memory == nil || s.Memory.WorkingSetBytes == nil {
+		return nil
+	}
+	return resource.NewQuantity(int64(*s.Memory.WorkingSetBytes), resource.BinarySI)
+}
+
+func ephemeralStorageUsage(s statsapi.PodStats) *resource.Quantity {
+	if s.EphemeralStorage == nil || s.EphemeralStorage.UsedBytes == nil {
+		return nil
+	}
+	return resource.NewQuantity(int64(*s.EphemeralStorage.UsedBytes), resource.BinarySI)
+}
+
+func processUsage(s statsapi.PodStats) *resource.Quantity {
+	if s.ProcessStats == nil || s.ProcessStats.ProcessCount == nil {
+		return nil
+	}
+	return resource.NewQuantity(int64(*s.ProcessStats.ProcessCount), resource.DecimalSI)
+}
+
+// rankPods 按信号对应的资源排列pod，排在前面的优先驱逐
+// 先按QoS等级，同一等级中使用量超过requests越多越先驱逐
+func rankPods(signal Signal, pods []*v1.Pod, stats statsFunc) {
+	switch signal {
+	case SignalMemoryAvailable:
+		orderedBy(qosComparator, exceedRequests(stats, memoryUsage, v1.ResourceMemory)).Sort(pods)
+	case SignalNodeFsAvailable, SignalImageFsAvailable:
+		orderedBy(qosComparator, exceedRequests(stats, ephemeralStorageUsage, v1.ResourceEphemeralStorage)).Sort(pods)
+	case SignalPIDAvailable:
+		// 进程数没有requests，直接按进程数排列
+		orderedBy(qosComparator, exceedRequests(stats, processUsage, resourcePIDs)).Sort(pods)
+	}
+}
+
+// podUsage 用于驱逐信息中的使用量描述
+func podUsage(signal Signal, s statsapi.PodStats) *resource.Quantity {
+	switch signal {
+	case SignalMemoryAvailable:
+		return memoryUsage(s)
+	case SignalNodeFsAvailable, SignalImageFsAvailable:
+		return ephemeralStorageUsage(s)
+	case SignalPIDAvailable:
+		return processUsage(s)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package eviction
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"time"
+)
+
+// Signal 触发驱逐的信号
+// pkg/kubelet/eviction/api/types.go
+type Signal string
+
+const (
+	// 节点可用内存
+	SignalMemoryAvailable Signal = "memory.available"
+	// kubelet根目录所在文件系统的可用空间
+	SignalNodeFsAvailable Signal = "nodefs.available"
+	// 容器运行时镜像所在文件系统的可用空间
+	SignalImageFsAvailable Signal = "imagefs.available"
+	// 节点可用的进程号
+	SignalPIDAvailable Signal = "pid.available"
+)
+
+// 每个信号对应的节点condition
+var signalToNodeCondition = map[Signal]v1.NodeConditionType{
+	SignalMemoryAvailable:  v1.NodeMemoryPressure,
+	SignalNodeFsAvailable:  v1.NodeDiskPressure,
+	SignalImageFsAvailable: v1.NodeDiskPressure,
+	SignalPIDAvailable:     v1.NodePIDPressure,
+}
+
+// 每个信号回收的资源，用于事件和驱逐信息
+var signalToResource = map[Signal]v1.ResourceName{
+	SignalMemoryAvailable:  v1.ResourceMemory,
+	SignalNodeFsAvailable:  v1.ResourceEphemeralStorage,
+	SignalImageFsAvailable: v1.ResourceEphemeralStorage,
+	SignalPIDAvailable:     resourcePIDs,
+}
+
+// 进程号不是标准的资源名，仅用于展示
+const resourcePIDs v1.ResourceName = "pids"
+
+// ThresholdValue 阈值，Quantity和Percentage只设置一个
+type ThresholdValue struct {
+	Quantity *resource.Quantity
+	// 占容量的比例，0到1之间
+	Percentage float32
+}
+
+// Threshold 驱逐阈值，可用量低于Value时满足
+type Threshold struct {
+	Signal Signal
+	Value  ThresholdValue
+	// 软驱逐阈值持续满足多长时间后才驱逐，硬驱逐阈值为0
+	GracePeriod time.Duration
+	// 驱逐时在阈值之外至少再回收的量
+	MinReclaim *ThresholdValue
+}
+
+// Config 驱逐管理器的配置
+type Config struct {
+	// 压力condition解除前需要等待的时间
+	PressureTransitionPeriod time.Duration
+	// 软驱逐时pod的最长优雅退出时间，单位秒
+	MaxPodGracePeriodSeconds int64
+	Thresholds               []Threshold
+}
+
+// KillPodFunc 以指定的宽限期终止pod，statusFn用来设置pod被驱逐后的状态
+type KillPodFunc func(pod *v1.Pod, gracePeriodOverride int64, statusFn func(*v1.PodStatus)) error
+
+// ActivePodsFunc 返回还在运行、可以被驱逐的pod
+type ActivePodsFunc func() []*v1.Pod
+
+// SummaryProvider 节点和pod的资源使用统计
+type SummaryProvider interface {
+	GetSummary(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.Summary, error)
+}
+
+// ImageGC 磁盘压力时删除未使用的镜像
+type ImageGC interface {
+	DeleteUnusedImages(ctx context.Context) error
+}
+
+// ContainerGC 磁盘压力时删除已退出的容器
+type ContainerGC interface {
+	DeleteAllUnusedContainers(ctx context.Context) error
+}
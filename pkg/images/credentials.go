@@ -0,0 +1,198 @@
+package images
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 没有写仓库地址的镜像默认来自docker hub
+const (
+	defaultRegistryHost = "docker.io"
+	// docker hub在docker配置中常用的地址
+	dockerHubLegacyHost = "index.docker.io"
+)
+
+// DockerConfigJSON .dockerconfigjson格式的凭据
+// pkg/credentialprovider/config.go
+type DockerConfigJSON struct {
+	Auths DockerConfig `json:"auths"`
+}
+
+// DockerConfig 仓库地址到凭据的映射，也是旧的.dockercfg格式
+type DockerConfig map[string]DockerConfigEntry
+
+// DockerConfigEntry 单个仓库的凭据
+type DockerConfigEntry struct {
+	Username string
+	Password string
+	Email    string
+}
+
+type dockerConfigEntryWithAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+	// base64编码的 用户名:密码
+	Auth string `json:"auth,omitempty"`
+}
+
+// UnmarshalJSON 设置了auth时从中解出用户名和密码
+func (this *DockerConfigEntry) UnmarshalJSON(data []byte) error {
+	var tmp dockerConfigEntryWithAuth
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	this.Username = tmp.Username
+	this.Password = tmp.Password
+	this.Email = tmp.Email
+	if tmp.Auth == "" {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(tmp.Auth)
+	if err != nil {
+		return err
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return fmt.Errorf("unable to parse auth field, must be formatted as base64(username:password)")
+	}
+	this.Username = username
+	this.Password = password
+	return nil
+}
+
+// keyringEntry 一个仓库地址及其凭据，地址的host支持*通配符
+type keyringEntry struct {
+	host string
+	path string
+	auth container.AuthConfig
+}
+
+// Keyring 根据镜像名查找可用的凭据
+// pkg/credentialprovider/keyring.go
+type Keyring struct {
+	entries []keyringEntry
+}
+
+// MakeKeyring 从docker-registry类型的secret中读取凭据，格式错误的secret忽略
+func MakeKeyring(secrets []v1.Secret) *Keyring {
+	keyring := &Keyring{}
+	for _, secret := range secrets {
+		config, err := parseSecret(secret)
+		if err != nil {
+			klog.ErrorS(err, "Failed to parse image pull secret", "secret", klog.KObj(&secret))
+			continue
+		}
+		for registry, entry := range config {
+			host, p := parseRegistryURL(registry)
+			keyring.entries = append(keyring.entries, keyringEntry{
+				host: host,
+				path: p,
+				auth: container.AuthConfig{
+					Username:      entry.Username,
+					Password:      entry.Password,
+					ServerAddress: registry,
+				},
+			})
+		}
+	}
+	// 路径更长的地址更具体，优先匹配
+	sort.SliceStable(keyring.entries, func(i, j int) bool {
+		a, b := keyring.entries[i], keyring.entries[j]
+		if len(a.host+a.path) != len(b.host+b.path) {
+			return len(a.host+a.path) > len(b.host+b.path)
+		}
+		return a.host+a.path < b.host+b.path
+	})
+	return keyring
+}
+
+func parseSecret(secret v1.Secret) (DockerConfig, error) {
+	switch secret.Type {
+	case v1.SecretTypeDockerConfigJson:
+		data, ok := secret.Data[v1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("secret is missing key %q", v1.DockerConfigJsonKey)
+		}
+		config := DockerConfigJSON{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		return config.Auths, nil
+	case v1.SecretTypeDockercfg:
+		data, ok := secret.Data[v1.DockerConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("secret is missing key %q", v1.DockerConfigKey)
+		}
+		config := DockerConfig{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+	return nil, fmt.Errorf("unsupported secret type %q", secret.Type)
+}
+
+// Lookup 返回匹配镜像仓库的凭据，按匹配程度从高到低排列
+func (this *Keyring) Lookup(image string) []container.AuthConfig {
+	host, p := parseImageRepository(image)
+	ret := []container.AuthConfig{}
+	for _, entry := range this.entries {
+		if matched, _ := path.Match(entry.host, host); !matched {
+			continue
+		}
+		if entry.path != "" && p != entry.path && !strings.HasPrefix(p, entry.path+"/") {
+			continue
+		}
+		ret = append(ret, entry.auth)
+	}
+	return ret
+}
+
+// parseRegistryURL 配置中的仓库地址可以带协议和路径，如https://index.docker.io/v1/
+func parseRegistryURL(registry string) (string, string) {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	u, err := url.Parse(registry)
+	if err != nil {
+		return registry, ""
+	}
+	host := normalizeRegistryHost(u.Host)
+	p := strings.Trim(u.Path, "/")
+	// docker hub的v1地址不是镜像路径
+	if host == defaultRegistryHost && (p == "v1" || p == "v2") {
+		p = ""
+	}
+	return host, p
+}
+
+// parseImageRepository 拆分镜像名中的仓库地址和镜像路径，去掉tag和digest
+func parseImageRepository(image string) (string, string) {
+	name, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	host := defaultRegistryHost
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host = first
+		name = rest
+	}
+	return normalizeRegistryHost(host), name
+}
+
+func normalizeRegistryHost(host string) string {
+	if host == dockerHubLegacyHost || host == "registry-1.docker.io" {
+		return defaultRegistryHost
+	}
+	return host
+}
@@ -0,0 +1,129 @@
+package images
+
+import (
+	"encoding/base64"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"mykubelet/pkg/container"
+	"reflect"
+	"testing"
+)
+
+func dockerConfigJSONSecret(name, config string) v1.Secret {
+	return v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(config)},
+	}
+}
+
+func TestParseDockerConfigJSON(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cr3t:with:colons"))
+	testCases := []struct {
+		name     string
+		secret   v1.Secret
+		expected DockerConfig
+		wantErr  bool
+	}{
+		{
+			name: "username and password",
+			secret: dockerConfigJSONSecret("plain",
+				`{"auths":{"registry.example.com":{"username":"user","password":"pass","email":"user@example.com"}}}`),
+			expected: DockerConfig{"registry.example.com": {Username: "user", Password: "pass", Email: "user@example.com"}},
+		},
+		{
+			name:     "auth overrides username and password",
+			secret:   dockerConfigJSONSecret("auth", `{"auths":{"https://index.docker.io/v1/":{"username":"ignored","auth":"`+auth+`"}}}`),
+			expected: DockerConfig{"https://index.docker.io/v1/": {Username: "robot", Password: "s3cr3t:with:colons"}},
+		},
+		{
+			name:    "auth without colon",
+			secret:  dockerConfigJSONSecret("bad-auth", `{"auths":{"registry.example.com":{"auth":"`+base64.StdEncoding.EncodeToString([]byte("robot"))+`"}}}`),
+			wantErr: true,
+		},
+		{
+			name:    "auth not base64",
+			secret:  dockerConfigJSONSecret("bad-base64", `{"auths":{"registry.example.com":{"auth":"%%%"}}}`),
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			secret:  dockerConfigJSONSecret("bad-json", `{"auths":`),
+			wantErr: true,
+		},
+		{
+			name: "missing key",
+			secret: v1.Secret{
+				Type: v1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{v1.DockerConfigKey: []byte(`{}`)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "legacy dockercfg",
+			secret: v1.Secret{
+				Type: v1.SecretTypeDockercfg,
+				Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"quay.io":{"username":"user","password":"pass"}}`)},
+			},
+			expected: DockerConfig{"quay.io": {Username: "user", Password: "pass"}},
+		},
+		{
+			name:    "opaque secret",
+			secret:  v1.Secret{Type: v1.SecretTypeOpaque},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := parseSecret(tc.secret)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, config)
+			}
+		})
+	}
+}
+
+func TestKeyringLookup(t *testing.T) {
+	keyring := MakeKeyring([]v1.Secret{
+		dockerConfigJSONSecret("hub", `{"auths":{"https://index.docker.io/v1/":{"username":"hub","password":"p"}}}`),
+		dockerConfigJSONSecret("registry", `{"auths":{
+			"registry.example.com":{"username":"registry","password":"p"},
+			"registry.example.com/team":{"username":"team","password":"p"},
+			"*.mirror.example.com":{"username":"mirror","password":"p"}}}`),
+		dockerConfigJSONSecret("broken", `not json`),
+	})
+	usernames := func(auths []container.AuthConfig) []string {
+		ret := []string{}
+		for _, auth := range auths {
+			ret = append(ret, auth.Username)
+		}
+		return ret
+	}
+	testCases := []struct {
+		image    string
+		expected []string
+	}{
+		{image: "nginx", expected: []string{"hub"}},
+		{image: "docker.io/library/nginx:1.25", expected: []string{"hub"}},
+		{image: "registry.example.com/team/app:v1", expected: []string{"team", "registry"}},
+		{image: "registry.example.com/other/app@sha256:abc", expected: []string{"registry"}},
+		{image: "registry.example.com/teamwork/app", expected: []string{"registry"}},
+		{image: "eu.mirror.example.com/app", expected: []string{"mirror"}},
+		{image: "quay.io/app", expected: []string{}},
+		{image: "localhost:5000/app", expected: []string{}},
+	}
+	for _, tc := range testCases {
+		if got := usernames(keyring.Lookup(tc.image)); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected credentials %v, got %v", tc.image, tc.expected, got)
+		}
+	}
+}
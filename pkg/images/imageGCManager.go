@@ -0,0 +1,292 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/utils/clock"
+	"math"
+	"mykubelet/pkg/container"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 检测镜像使用情况的间隔
+	imageDetectPeriod = 5 * time.Minute
+
+	// 清理镜像失败时在节点上记录的事件
+	freeDiskSpaceFailed = "FreeDiskSpaceFailed"
+	invalidDiskCapacity = "InvalidDiskCapacity"
+)
+
+// ImageGCPolicy 镜像垃圾回收策略
+// pkg/kubelet/images/image_gc_manager.go
+type ImageGCPolicy struct {
+	// 镜像文件系统使用率达到该百分比时开始回收
+	HighThresholdPercent int
+	// 回收到使用率低于该百分比为止
+	LowThresholdPercent int
+	// 镜像被发现后至少保留的时间
+	MinAge time.Duration
+}
+
+// StatsProvider 镜像文件系统的使用情况
+type StatsProvider interface {
+	ImageFsStats(ctx context.Context) (*statsapi.FsStats, error)
+}
+
+// 镜像的使用记录
+type imageRecord struct {
+	// 第一次检测到镜像的时间
+	firstDetected time.Time
+	// 最后一次被容器使用的时间
+	lastUsed time.Time
+	size     uint64
+	pinned   bool
+}
+
+// ImageGCManager 记录镜像最后被使用的时间，磁盘使用率过高时删除最久没有使用的镜像
+type ImageGCManager struct {
+	runtime       container.Runtime
+	statsProvider StatsProvider
+	recorder      record.EventRecorder
+	nodeRef       *v1.ObjectReference
+	policy        ImageGCPolicy
+	clock         clock.Clock
+
+	imageRecordsLock sync.Mutex
+	imageRecords     map[string]*imageRecord
+	// 第一次检测完成前不回收，避免把正在使用的镜像当作未使用
+	initialized bool
+}
+
+// NewImageGCManager 创建镜像垃圾回收，校验阈值的范围
+func NewImageGCManager(runtime container.Runtime, statsProvider StatsProvider, recorder record.EventRecorder, nodeName string,
+	policy ImageGCPolicy, clock clock.Clock) (*ImageGCManager, error) {
+	if policy.HighThresholdPercent < 0 || policy.HighThresholdPercent > 100 {
+		return nil, fmt.Errorf("invalid HighThresholdPercent %d, must be in range [0-100]", policy.HighThresholdPercent)
+	}
+	if policy.LowThresholdPercent < 0 || policy.LowThresholdPercent > 100 {
+		return nil, fmt.Errorf("invalid LowThresholdPercent %d, must be in range [0-100]", policy.LowThresholdPercent)
+	}
+	if policy.LowThresholdPercent > policy.HighThresholdPercent {
+		return nil, fmt.Errorf("LowThresholdPercent %d can not be higher than HighThresholdPercent %d",
+			policy.LowThresholdPercent, policy.HighThresholdPercent)
+	}
+	return &ImageGCManager{
+		runtime:       runtime,
+		statsProvider: statsProvider,
+		recorder:      recorder,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		policy:       policy,
+		clock:        clock,
+		imageRecords: map[string]*imageRecord{},
+	}, nil
+}
+
+// Start 定期检测镜像的使用情况
+func (this *ImageGCManager) Start(stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if _, err := this.detectImages(context.Background(), this.clock.Now()); err != nil {
+			klog.ErrorS(err, "Failed to monitor images")
+			return
+		}
+		this.imageRecordsLock.Lock()
+		this.initialized = true
+		this.imageRecordsLock.Unlock()
+	}, imageDetectPeriod, stopCh)
+}
+
+// detectImages 更新镜像记录，返回正在使用的镜像
+func (this *ImageGCManager) detectImages(ctx context.Context, detectTime time.Time) (map[string]bool, error) {
+	images, err := this.runtime.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := this.runtime.GetPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 所有容器使用的镜像，包括已退出的容器，删除前运行时也不允许删除这些镜像
+	imagesInUse := map[string]bool{}
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			if c.ImageRef != "" {
+				imagesInUse[c.ImageRef] = true
+			}
+			if c.Image != "" {
+				imagesInUse[c.Image] = true
+			}
+		}
+	}
+
+	this.imageRecordsLock.Lock()
+	defer this.imageRecordsLock.Unlock()
+	currentImages := map[string]bool{}
+	for _, image := range images {
+		currentImages[image.ID] = true
+		record, ok := this.imageRecords[image.ID]
+		if !ok {
+			klog.V(5).InfoS("Adding image to image records", "imageID", image.ID)
+			record = &imageRecord{firstDetected: detectTime}
+			this.imageRecords[image.ID] = record
+		}
+		if isImageUsed(image, imagesInUse) {
+			record.lastUsed = detectTime
+		}
+		record.size = image.Size
+		record.pinned = image.Pinned
+	}
+	for id := range this.imageRecords {
+		if !currentImages[id] {
+			klog.V(5).InfoS("Image is no longer present, removing from image records", "imageID", id)
+			delete(this.imageRecords, id)
+		}
+	}
+	return imagesInUse, nil
+}
+
+// 容器可能以ID、tag或digest引用镜像
+func isImageUsed(image container.Image, imagesInUse map[string]bool) bool {
+	if imagesInUse[image.ID] {
+		return true
+	}
+	for _, ref := range append(append([]string{}, image.RepoTags...), image.RepoDigests...) {
+		if imagesInUse[ref] {
+			return true
+		}
+	}
+	return false
+}
+
+// GarbageCollect 镜像文件系统使用率超过高水位时，删除镜像直到低于低水位
+func (this *ImageGCManager) GarbageCollect(ctx context.Context) error {
+	fsStats, err := this.statsProvider.ImageFsStats(ctx)
+	if err != nil {
+		return err
+	}
+	if fsStats.CapacityBytes == nil || fsStats.AvailableBytes == nil {
+		return fmt.Errorf("image filesystem stats are incomplete")
+	}
+	capacity := int64(*fsStats.CapacityBytes)
+	available := int64(*fsStats.AvailableBytes)
+	if available > capacity {
+		klog.InfoS("Availability is larger than capacity", "available", available, "capacity", capacity)
+		available = capacity
+	}
+	if capacity == 0 {
+		err = fmt.Errorf("invalid capacity %d on image filesystem", capacity)
+		this.recorder.Eventf(this.nodeRef, v1.EventTypeWarning, invalidDiskCapacity, "%s", err.Error())
+		return err
+	}
+
+	usagePercent := 100 - int(available*100/capacity)
+	if usagePercent < this.policy.HighThresholdPercent {
+		return nil
+	}
+	amountToFree := capacity*int64(100-this.policy.LowThresholdPercent)/100 - available
+	klog.InfoS("Disk usage on image filesystem is over the high threshold, trying to free bytes down to the low threshold",
+		"usage", usagePercent, "highThreshold", this.policy.HighThresholdPercent, "amountToFree", amountToFree,
+		"lowThreshold", this.policy.LowThresholdPercent)
+	freed, err := this.freeSpace(ctx, amountToFree, this.clock.Now())
+	if err != nil {
+		return err
+	}
+	if freed < amountToFree {
+		err = fmt.Errorf("failed to garbage collect required amount of images. Attempted to free %d bytes, but only found %d bytes eligible to free",
+			amountToFree, freed)
+		this.recorder.Eventf(this.nodeRef, v1.EventTypeWarning, freeDiskSpaceFailed, "%s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteUnusedImages 删除所有未使用的镜像，磁盘压力时由驱逐管理器调用
+func (this *ImageGCManager) DeleteUnusedImages(ctx context.Context) error {
+	klog.InfoS("Attempting to delete unused images")
+	_, err := this.freeSpace(ctx, math.MaxInt64, this.clock.Now())
+	return err
+}
+
+// 待删除的镜像
+type evictionInfo struct {
+	id string
+	imageRecord
+}
+
+// freeSpace 按最后使用时间从旧到新删除未使用的镜像，直到释放bytesToFree，返回实际释放的空间
+func (this *ImageGCManager) freeSpace(ctx context.Context, bytesToFree int64, freeTime time.Time) (int64, error) {
+	imagesInUse, err := this.detectImages(ctx, freeTime)
+	if err != nil {
+		return 0, err
+	}
+
+	this.imageRecordsLock.Lock()
+	if !this.initialized {
+		this.imageRecordsLock.Unlock()
+		klog.V(3).InfoS("Image records are not initialized yet, skipping image garbage collection")
+		return 0, nil
+	}
+	images := []evictionInfo{}
+	for id, record := range this.imageRecords {
+		if imagesInUse[id] {
+			continue
+		}
+		images = append(images, evictionInfo{id: id, imageRecord: *record})
+	}
+	this.imageRecordsLock.Unlock()
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].lastUsed.Equal(images[j].lastUsed) {
+			return images[i].firstDetected.Before(images[j].firstDetected)
+		}
+		return images[i].lastUsed.Before(images[j].lastUsed)
+	})
+
+	var spaceFreed int64
+	errs := []string{}
+	for _, image := range images {
+		// 本次检测时还在使用的镜像不删除
+		if !image.lastUsed.Before(freeTime) {
+			continue
+		}
+		if freeTime.Sub(image.firstDetected) < this.policy.MinAge {
+			klog.V(5).InfoS("Image's age is less than the policy's minAge, not eligible for garbage collection",
+				"imageID", image.id, "age", freeTime.Sub(image.firstDetected), "minAge", this.policy.MinAge)
+			continue
+		}
+		if image.pinned {
+			klog.V(5).InfoS("Image is pinned, skipping garbage collection", "imageID", image.id)
+			continue
+		}
+		klog.InfoS("Removing image to free bytes", "imageID", image.id, "size", image.size)
+		if err = this.runtime.RemoveImage(ctx, image.id); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		this.imageRecordsLock.Lock()
+		delete(this.imageRecords, image.id)
+		this.imageRecordsLock.Unlock()
+		spaceFreed += int64(image.size)
+		if spaceFreed >= bytesToFree {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return spaceFreed, fmt.Errorf("wanted to free %d bytes, but freed %d bytes space with errors in image deletion: %s",
+			bytesToFree, spaceFreed, strings.Join(errs, "; "))
+	}
+	return spaceFreed, nil
+}
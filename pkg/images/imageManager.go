@@ -0,0 +1,173 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/events"
+	"strings"
+	"time"
+)
+
+// 拉取失败的退避时间，从10s开始翻倍，最长5分钟
+const (
+	pullBackOffPeriod    = 10 * time.Second
+	maxPullBackOffPeriod = 5 * time.Minute
+)
+
+// ErrPullQPSExceeded 拉取请求超过了registryPullQPS的限制
+var ErrPullQPSExceeded = errors.New("pull QPS exceeded")
+
+// ImageManager 按拉取策略确保容器的镜像存在，拉取失败后对每个镜像单独退避
+// pkg/kubelet/images/image_manager.go
+type ImageManager struct {
+	recorder     record.EventRecorder
+	imageService container.ImageService
+	backOff      *flowcontrol.Backoff
+	clock        clock.Clock
+}
+
+// NewImageManager 创建镜像管理器，qps大于0时限制拉取镜像的频率
+func NewImageManager(recorder record.EventRecorder, imageService container.ImageService, qps float32, burst int,
+	clock clock.Clock) *ImageManager {
+	if qps > 0 {
+		imageService = &throttledImageService{
+			ImageService: imageService,
+			limiter:      flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		}
+	}
+	backOff := flowcontrol.NewBackOff(pullBackOffPeriod, maxPullBackOffPeriod)
+	backOff.Clock = clock
+	return &ImageManager{
+		recorder:     recorder,
+		imageService: imageService,
+		backOff:      backOff,
+		clock:        clock,
+	}
+}
+
+// EnsureImageExists 按容器的拉取策略检查或拉取镜像，返回镜像引用
+// 失败时返回展示在容器waiting状态中的信息，以及作为reason的错误
+func (this *ImageManager) EnsureImageExists(ctx context.Context, pod *v1.Pod, c *v1.Container,
+	pullSecrets []v1.Secret) (string, string, error) {
+	image, err := applyDefaultImageTag(c.Image)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to apply default image tag %q: %v", c.Image, err)
+		this.logIt(pod, c, v1.EventTypeWarning, events.FailedToInspectImage, msg)
+		return "", msg, container.ErrInvalidImageName
+	}
+
+	imageRef, err := this.imageService.GetImageRef(ctx, image)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to inspect image %q: %v", image, err)
+		this.logIt(pod, c, v1.EventTypeWarning, events.FailedToInspectImage, msg)
+		return "", msg, container.ErrImageInspect
+	}
+
+	present := imageRef != ""
+	if !shouldPullImage(c.ImagePullPolicy, present) {
+		if present {
+			msg := fmt.Sprintf("Container image %q already present on machine", image)
+			this.logIt(pod, c, v1.EventTypeNormal, events.PulledImage, msg)
+			return imageRef, "", nil
+		}
+		msg := fmt.Sprintf("Container image %q is not present with pull policy of Never", image)
+		this.logIt(pod, c, v1.EventTypeWarning, events.ErrImageNeverPullPolicy, msg)
+		return "", msg, container.ErrImageNeverPull
+	}
+
+	// 同一个pod的同一个镜像单独退避
+	backOffKey := fmt.Sprintf("%s_%s", pod.UID, image)
+	if this.backOff.IsInBackOffSinceUpdate(backOffKey, this.clock.Now()) {
+		msg := fmt.Sprintf("Back-off pulling image %q", image)
+		this.logIt(pod, c, v1.EventTypeNormal, events.BackOffPullImage, msg)
+		return "", msg, container.ErrImagePullBackOff
+	}
+
+	this.logIt(pod, c, v1.EventTypeNormal, events.PullingImage, fmt.Sprintf("Pulling image %q", image))
+	start := this.clock.Now()
+	imageRef, err = this.pullImage(ctx, image, MakeKeyring(pullSecrets))
+	if err != nil {
+		this.backOff.Next(backOffKey, this.clock.Now())
+		msg := fmt.Sprintf("Failed to pull image %q: %v", image, err)
+		this.logIt(pod, c, v1.EventTypeWarning, events.FailedToPullImage, msg)
+		return "", msg, container.ErrImagePull
+	}
+	this.logIt(pod, c, v1.EventTypeNormal, events.PulledImage, fmt.Sprintf("Successfully pulled image %q in %v",
+		image, this.clock.Since(start).Truncate(time.Millisecond)))
+	this.backOff.GC()
+	return imageRef, "", nil
+}
+
+// pullImage 依次使用匹配的凭据拉取，没有凭据时匿名拉取
+// pkg/kubelet/kuberuntime/kuberuntime_image.go PullImage
+func (this *ImageManager) pullImage(ctx context.Context, image string, keyring *Keyring) (string, error) {
+	creds := keyring.Lookup(image)
+	if len(creds) == 0 {
+		return this.imageService.PullImage(ctx, image, nil)
+	}
+	errs := []string{}
+	for i := range creds {
+		imageRef, err := this.imageService.PullImage(ctx, image, &creds[i])
+		if err == nil {
+			return imageRef, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// 记录容器的镜像事件，无法生成容器引用时只输出日志
+func (this *ImageManager) logIt(pod *v1.Pod, c *v1.Container, eventType, reason, msg string) {
+	ref, err := container.GenerateContainerRef(pod, c)
+	if err != nil {
+		klog.InfoS(msg, "pod", klog.KObj(pod), "containerName", c.Name, "reason", reason)
+		return
+	}
+	this.recorder.Event(ref, eventType, reason, msg)
+}
+
+// shouldPullImage Always总是拉取，IfNotPresent在镜像不存在时拉取，Never不拉取
+func shouldPullImage(pullPolicy v1.PullPolicy, present bool) bool {
+	if pullPolicy == v1.PullNever {
+		return false
+	}
+	if pullPolicy == v1.PullAlways || (pullPolicy == v1.PullIfNotPresent && !present) {
+		return true
+	}
+	return false
+}
+
+// applyDefaultImageTag 没有tag和digest的镜像使用latest
+func applyDefaultImageTag(image string) (string, error) {
+	if image == "" || strings.TrimSpace(image) != image || strings.ContainsAny(image, " \t\n") {
+		return "", fmt.Errorf("couldn't parse image reference %q", image)
+	}
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+	if strings.LastIndex(image, ":") > strings.LastIndex(image, "/") {
+		return image, nil
+	}
+	return image + ":latest", nil
+}
+
+// throttledImageService 按令牌桶限制拉取镜像的频率，超出时直接失败，由退避重试
+// pkg/kubelet/images/helpers.go
+type throttledImageService struct {
+	container.ImageService
+	limiter flowcontrol.RateLimiter
+}
+
+func (this *throttledImageService) PullImage(ctx context.Context, image string, auth *container.AuthConfig) (string, error) {
+	if this.limiter.TryAccept() {
+		return this.ImageService.PullImage(ctx, image, auth)
+	}
+	return "", ErrPullQPSExceeded
+}
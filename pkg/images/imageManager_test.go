@@ -0,0 +1,288 @@
+package images
+
+import (
+	"context"
+	"errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testImageID = "sha256:1111"
+
+func newImageTestPod(image string, policy v1.PullPolicy) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "uid"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:            "app",
+			Image:           image,
+			ImagePullPolicy: policy,
+		}}},
+	}
+}
+
+// newTestImageManager 运行时中已有nginx:latest，qps为0时不限制拉取频率
+func newTestImageManager(qps float32, burst int) (*ImageManager, *containertest.FakeRuntime, *testingclock.FakeClock, *record.FakeRecorder) {
+	runtime := containertest.NewFakeRuntime()
+	runtime.Images = []container.Image{{ID: testImageID, RepoTags: []string{"nginx:latest"}}}
+	clock := testingclock.NewFakeClock(time.Now())
+	recorder := record.NewFakeRecorder(100)
+	return NewImageManager(recorder, runtime, qps, burst, clock), runtime, clock, recorder
+}
+
+func countPulls(runtime *containertest.FakeRuntime) int {
+	runtime.Lock()
+	defer runtime.Unlock()
+	count := 0
+	for _, name := range runtime.CalledFunctions {
+		if name == "PullImage" {
+			count++
+		}
+	}
+	return count
+}
+
+// lastEvent 返回最后一个事件，FakeRecorder的格式为"类型 原因 信息"
+func lastEvent(recorder *record.FakeRecorder) string {
+	event := ""
+	for {
+		select {
+		case event = <-recorder.Events:
+		default:
+			return event
+		}
+	}
+}
+
+func TestEnsureImageExistsPullPolicy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		image         string
+		policy        v1.PullPolicy
+		expectedRef   string
+		expectedErr   error
+		expectedPulls int
+		expectedEvent string
+	}{
+		{
+			name:          "Always pulls a present image",
+			image:         "nginx",
+			policy:        v1.PullAlways,
+			expectedRef:   "nginx:latest",
+			expectedPulls: 1,
+			expectedEvent: "Normal Pulled Successfully pulled image",
+		},
+		{
+			name:          "IfNotPresent uses a present image",
+			image:         "nginx",
+			policy:        v1.PullIfNotPresent,
+			expectedRef:   testImageID,
+			expectedEvent: "Normal Pulled Container image \"nginx:latest\" already present",
+		},
+		{
+			name:          "IfNotPresent pulls a missing image",
+			image:         "redis:7",
+			policy:        v1.PullIfNotPresent,
+			expectedRef:   "redis:7",
+			expectedPulls: 1,
+			expectedEvent: "Normal Pulled Successfully pulled image",
+		},
+		{
+			name:          "Never uses a present image",
+			image:         "nginx:latest",
+			policy:        v1.PullNever,
+			expectedRef:   testImageID,
+			expectedEvent: "Normal Pulled Container image \"nginx:latest\" already present",
+		},
+		{
+			name:          "Never fails on a missing image",
+			image:         "redis:7",
+			policy:        v1.PullNever,
+			expectedErr:   container.ErrImageNeverPull,
+			expectedEvent: "Warning ErrImageNeverPull",
+		},
+		{
+			name:          "invalid image name",
+			image:         "nginx latest",
+			policy:        v1.PullAlways,
+			expectedErr:   container.ErrInvalidImageName,
+			expectedEvent: "Warning InspectFailed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, runtime, _, recorder := newTestImageManager(0, 0)
+			pod := newImageTestPod(tc.image, tc.policy)
+			imageRef, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil)
+			if err != tc.expectedErr {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if imageRef != tc.expectedRef {
+				t.Errorf("expected image ref %q, got %q", tc.expectedRef, imageRef)
+			}
+			if pulls := countPulls(runtime); pulls != tc.expectedPulls {
+				t.Errorf("expected %d pulls, got %d", tc.expectedPulls, pulls)
+			}
+			if event := lastEvent(recorder); !strings.HasPrefix(event, tc.expectedEvent) {
+				t.Errorf("expected event %q, got %q", tc.expectedEvent, event)
+			}
+		})
+	}
+}
+
+func TestEnsureImageExistsInspectError(t *testing.T) {
+	manager, runtime, _, _ := newTestImageManager(0, 0)
+	runtime.Err = errors.New("runtime unavailable")
+	pod := newImageTestPod("nginx", v1.PullIfNotPresent)
+	if _, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil); err != container.ErrImageInspect {
+		t.Errorf("expected %v, got %v", container.ErrImageInspect, err)
+	}
+}
+
+// 拉取失败后返回ErrImagePull，退避期间返回ImagePullBackOff且不拉取，退避时间从10s开始翻倍
+func TestEnsureImageExistsPullBackOff(t *testing.T) {
+	manager, runtime, clock, recorder := newTestImageManager(0, 0)
+	pullErr := errors.New("manifest unknown")
+	runtime.PullImageFn = func(_ context.Context, image string, _ *container.AuthConfig) (string, error) {
+		return "", pullErr
+	}
+	pod := newImageTestPod("redis:7", v1.PullIfNotPresent)
+	ensure := func() error {
+		_, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil)
+		return err
+	}
+
+	steps := []struct {
+		advance       time.Duration
+		expectedErr   error
+		expectedPulls int
+	}{
+		{expectedErr: container.ErrImagePull, expectedPulls: 1},
+		{advance: time.Second, expectedErr: container.ErrImagePullBackOff, expectedPulls: 1},
+		{advance: 9 * time.Second, expectedErr: container.ErrImagePull, expectedPulls: 2},
+		{advance: 19 * time.Second, expectedErr: container.ErrImagePullBackOff, expectedPulls: 2},
+		{advance: time.Second, expectedErr: container.ErrImagePull, expectedPulls: 3},
+	}
+	for i, step := range steps {
+		clock.Step(step.advance)
+		if err := ensure(); err != step.expectedErr {
+			t.Fatalf("step %d: expected %v, got %v", i, step.expectedErr, err)
+		}
+		if pulls := countPulls(runtime); pulls != step.expectedPulls {
+			t.Errorf("step %d: expected %d pulls, got %d", i, step.expectedPulls, pulls)
+		}
+		if step.expectedErr == container.ErrImagePullBackOff {
+			if event := lastEvent(recorder); !strings.HasPrefix(event, "Normal BackOff Back-off pulling image") {
+				t.Errorf("step %d: expected back-off event, got %q", i, event)
+			}
+		} else if event := lastEvent(recorder); !strings.Contains(event, pullErr.Error()) {
+			t.Errorf("step %d: expected pull failure event, got %q", i, event)
+		}
+	}
+
+	// 其他pod拉取同一个镜像不受退避影响
+	other := newImageTestPod("redis:7", v1.PullIfNotPresent)
+	other.UID = "other"
+	if _, _, err := manager.EnsureImageExists(context.Background(), other, &other.Spec.Containers[0], nil); err != container.ErrImagePull {
+		t.Errorf("expected other pod to pull, got %v", err)
+	}
+
+	// 退避结束后拉取成功
+	runtime.PullImageFn = nil
+	clock.Step(40 * time.Second)
+	if err := ensure(); err != nil {
+		t.Errorf("expected pull to succeed after back-off, got %v", err)
+	}
+}
+
+func TestEnsureImageExistsPullSecrets(t *testing.T) {
+	manager, runtime, _, _ := newTestImageManager(0, 0)
+	usernames := []string{}
+	runtime.PullImageFn = func(_ context.Context, image string, auth *container.AuthConfig) (string, error) {
+		if auth == nil {
+			usernames = append(usernames, "")
+			return "", errors.New("unauthorized")
+		}
+		usernames = append(usernames, auth.Username)
+		if auth.Username != "registry" {
+			return "", errors.New("unauthorized")
+		}
+		return "registry.example.com/team/app@sha256:2222", nil
+	}
+	secrets := []v1.Secret{
+		dockerConfigJSONSecret("registry", `{"auths":{
+			"registry.example.com":{"username":"registry","password":"p"},
+			"registry.example.com/team":{"username":"team","password":"p"}}}`),
+	}
+	pod := newImageTestPod("registry.example.com/team/app:v1", v1.PullAlways)
+	imageRef, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageRef != "registry.example.com/team/app@sha256:2222" {
+		t.Errorf("unexpected image ref %q", imageRef)
+	}
+	// 更具体的凭据先尝试，失败后使用下一个
+	if strings.Join(usernames, ",") != "team,registry" {
+		t.Errorf("expected credentials team then registry, got %v", usernames)
+	}
+}
+
+// 超过QPS的拉取直接失败，并进入退避
+func TestThrottledImageService(t *testing.T) {
+	manager, runtime, clock, recorder := newTestImageManager(0.001, 2)
+	if _, ok := manager.imageService.(*throttledImageService); !ok {
+		t.Fatalf("expected image service to be throttled when qps > 0")
+	}
+	for i, image := range []string{"redis:1", "redis:2"} {
+		pod := newImageTestPod(image, v1.PullAlways)
+		if _, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil); err != nil {
+			t.Fatalf("pull %d within burst failed: %v", i, err)
+		}
+	}
+	pod := newImageTestPod("redis:3", v1.PullAlways)
+	if _, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil); err != container.ErrImagePull {
+		t.Fatalf("expected pull over the QPS limit to fail, got %v", err)
+	}
+	if event := lastEvent(recorder); !strings.Contains(event, ErrPullQPSExceeded.Error()) {
+		t.Errorf("expected QPS exceeded in the event, got %q", event)
+	}
+	if pulls := countPulls(runtime); pulls != 2 {
+		t.Errorf("expected the throttled pull not to reach the runtime, got %d pulls", pulls)
+	}
+	clock.Step(time.Second)
+	if _, _, err := manager.EnsureImageExists(context.Background(), pod, &pod.Spec.Containers[0], nil); err != container.ErrImagePullBackOff {
+		t.Errorf("expected throttled pull to back off, got %v", err)
+	}
+
+	unthrottled, _, _, _ := newTestImageManager(0, 0)
+	if _, ok := unthrottled.imageService.(*throttledImageService); ok {
+		t.Errorf("expected no throttling when qps is 0")
+	}
+}
+
+func TestApplyDefaultImageTag(t *testing.T) {
+	testCases := map[string]string{
+		"nginx":                               "nginx:latest",
+		"nginx:1.25":                          "nginx:1.25",
+		"localhost:5000/app":                  "localhost:5000/app:latest",
+		"localhost:5000/app:v1":               "localhost:5000/app:v1",
+		"registry.example.com/app@sha256:abc": "registry.example.com/app@sha256:abc",
+	}
+	for image, expected := range testCases {
+		if got, err := applyDefaultImageTag(image); err != nil || got != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", image, expected, got, err)
+		}
+	}
+	for _, image := range []string{"", " nginx", "nginx latest"} {
+		if _, err := applyDefaultImageTag(image); err == nil {
+			t.Errorf("expected error for %q", image)
+		}
+	}
+}
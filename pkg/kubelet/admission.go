@@ -0,0 +1,81 @@
+package kubelet
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/lifecycle"
+	"mykubelet/pkg/node"
+)
+
+// startNodeReflector 监听本节点，准入检查时使用节点的标签、污点和可分配资源
+// pkg/kubelet/kubelet.go NewMainKubelet
+func (this *Kubelet) startNodeReflector(stopCh <-chan struct{}) {
+	lw := cache.NewListWatchFromClient(this.client.CoreV1().RESTClient(), "nodes", metav1.NamespaceAll,
+		fields.OneTermEqualSelector(metav1.ObjectNameField, this.nodeName))
+	go cache.NewReflector(lw, &v1.Node{}, this.nodeIndexer, 0).Run(stopCh)
+}
+
+// getNodeAnyWay 从缓存中获取本节点，节点还没有注册或同步时使用本地构造的节点
+// pkg/kubelet/kubelet_getters.go getNodeAnyWay
+func (this *Kubelet) getNodeAnyWay() (*v1.Node, error) {
+	if n, err := corelisters.NewNodeLister(this.nodeIndexer).Get(this.nodeName); err == nil {
+		return n, nil
+	}
+	return this.initialNode(), nil
+}
+
+// initialNode 本地构造的节点，只有注册时的标签和可分配资源
+func (this *Kubelet) initialNode() *v1.Node {
+	n := node.InitialNode(this.nodeName)
+	n.Status.Allocatable = node.NodeAllocatable()
+	return n
+}
+
+// canAdmitPod 依次执行准入检查，任一检查拒绝时返回拒绝的reason和message
+// pkg/kubelet/kubelet.go canAdmitPod
+func (this *Kubelet) canAdmitPod(pods []*v1.Pod, pod *v1.Pod) (bool, string, string) {
+	attrs := &lifecycle.PodAdmitAttributes{Pod: pod, OtherPods: pods}
+	for _, handler := range this.admitHandlers {
+		if result := handler.Admit(attrs); !result.Admit {
+			return false, result.Reason, result.Message
+		}
+	}
+	return true, "", ""
+}
+
+// rejectPod 拒绝的pod不会运行，直接设置为Failed
+// pkg/kubelet/kubelet.go rejectPod
+func (this *Kubelet) rejectPod(pod *v1.Pod, reason, message string) {
+	this.recorder.Eventf(pod, v1.EventTypeWarning, reason, "%s", message)
+	this.statusManager.SetPodStatus(pod, v1.PodStatus{
+		Phase:   v1.PodFailed,
+		Reason:  reason,
+		Message: fmt.Sprintf("Pod was rejected: %s", message),
+	})
+}
+
+// admitPod 对新增的pod执行准入检查，已经结束或正在删除的pod不检查
+// 其他pod只计算还在运行的pod，不包括被拒绝的pod
+func (this *Kubelet) admitPod(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return true
+	}
+	activePods := this.GetActivePods()
+	otherPods := make([]*v1.Pod, 0, len(activePods))
+	for _, p := range activePods {
+		if p.UID != pod.UID {
+			otherPods = append(otherPods, p)
+		}
+	}
+	if ok, reason, message := this.canAdmitPod(otherPods, pod); !ok {
+		klog.InfoS("Pod admission denied", "pod", klog.KObj(pod), "reason", reason, "message", message)
+		this.rejectPod(pod, reason, message)
+		return false
+	}
+	return true
+}
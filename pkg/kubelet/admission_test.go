@@ -0,0 +1,197 @@
+package kubelet
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"mykubelet/pkg/eviction"
+	"mykubelet/pkg/lifecycle"
+	"mykubelet/pkg/node"
+	"testing"
+	"time"
+)
+
+// newAdmissionTestKubelet 准入检查使用驱逐管理器和predicate，节点缓存中放入node
+func newAdmissionTestKubelet(t *testing.T, n *v1.Node, summary *statsapi.Summary) *testKubelet {
+	testKubelet := newTestKubelet()
+	kl := testKubelet.kubelet
+	kl.nodeIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if n != nil {
+		if err := kl.nodeIndexer.Add(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	threshold := resource.MustParse("1Gi")
+	evictionManager := eviction.NewManager(&fakeSummaryProvider{summary: summary}, eviction.Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []eviction.Threshold{{
+			Signal: eviction.SignalNodeFsAvailable,
+			Value:  eviction.ThresholdValue{Quantity: &threshold},
+		}},
+	}, kl.killPodForEviction, kl.GetActivePods, kl.podCleanedUp, noopGC{}, noopGC{}, kl.recorder, kl.nodeName, testKubelet.fakeClock)
+	kl.admitHandlers = []lifecycle.PodAdmitHandler{evictionManager, lifecycle.NewPredicateAdmitHandler(kl.getNodeAnyWay)}
+	// 观察到磁盘压力后停止驱逐管理器，压力condition保持不变，避免驱逐测试中的pod
+	if summary.Node.Fs != nil && *summary.Node.Fs.AvailableBytes < uint64(threshold.Value()) {
+		stopCh := make(chan struct{})
+		evictionManager.Start(10*time.Millisecond, stopCh)
+		waitFor(t, "disk pressure", evictionManager.IsUnderDiskPressure)
+		close(stopCh)
+	}
+	return testKubelet
+}
+
+// noopGC 磁盘压力时没有可以回收的容器和镜像
+type noopGC struct{}
+
+func (this noopGC) DeleteUnusedImages(_ context.Context) error {
+	return nil
+}
+
+func (this noopGC) DeleteAllUnusedContainers(_ context.Context) error {
+	return nil
+}
+
+func fsSummary(available uint64) *statsapi.Summary {
+	capacity := uint64(100 * 1024 * 1024 * 1024)
+	return &statsapi.Summary{Node: statsapi.NodeStats{
+		Fs: &statsapi.FsStats{AvailableBytes: &available, CapacityBytes: &capacity},
+	}}
+}
+
+func testNode(taints ...v1.Taint) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Spec:       v1.NodeSpec{Taints: taints},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("2"),
+			v1.ResourceMemory: resource.MustParse("4Gi"),
+			v1.ResourcePods:   resource.MustParse("10"),
+		}},
+	}
+}
+
+func requestingContainer(cpu, memory string) v1.Container {
+	return v1.Container{
+		Name: "app",
+		Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}
+}
+
+func TestAdmitPod(t *testing.T) {
+	plenty, low := uint64(50*1024*1024*1024), uint64(100*1024*1024)
+	systemCritical := int32(2000000000)
+	criticalPod := newTestPod("critical", requestingContainer("100m", "100Mi"))
+	criticalPod.Spec.Priority = &systemCritical
+	testCases := []struct {
+		name       string
+		node       *v1.Node
+		available  uint64
+		pod        *v1.Pod
+		wantAdmit  bool
+		wantReason string
+	}{
+		{
+			name:      "admitted",
+			node:      testNode(),
+			available: plenty,
+			pod:       newTestPod("pod", requestingContainer("1", "1Gi")),
+			wantAdmit: true,
+		},
+		{
+			name:       "out of cpu",
+			node:       testNode(),
+			available:  plenty,
+			pod:        newTestPod("pod", requestingContainer("3", "1Gi")),
+			wantReason: "OutOfcpu",
+		},
+		{
+			name:       "rejected under disk pressure",
+			node:       testNode(v1.Taint{Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule}),
+			available:  low,
+			pod:        newTestPod("pod", requestingContainer("1", "1Gi")),
+			wantReason: eviction.Reason,
+		},
+		{
+			name:      "critical pod admitted under disk pressure",
+			node:      testNode(v1.Taint{Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule}),
+			available: low,
+			pod:       criticalPod,
+			wantAdmit: true,
+		},
+		{
+			name:      "admitted under memory pressure taint",
+			node:      testNode(v1.Taint{Key: v1.TaintNodeMemoryPressure, Effect: v1.TaintEffectNoSchedule}),
+			available: plenty,
+			pod:       newTestPod("pod", requestingContainer("1", "1Gi")),
+			wantAdmit: true,
+		},
+		{
+			name:       "untolerated taint",
+			node:       testNode(v1.Taint{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}),
+			available:  plenty,
+			pod:        newTestPod("pod", requestingContainer("1", "1Gi")),
+			wantReason: lifecycle.TaintReason,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kl := newAdmissionTestKubelet(t, tc.node, fsSummary(tc.available)).kubelet
+			kl.podManager.AddPod(tc.pod)
+			if admitted := kl.admitPod(tc.pod); admitted != tc.wantAdmit {
+				t.Fatalf("expected admitted=%v, got %v", tc.wantAdmit, admitted)
+			}
+			status, ok := kl.statusManager.GetPodStatus(tc.pod.UID)
+			if tc.wantAdmit {
+				if ok && status.Phase == v1.PodFailed {
+					t.Errorf("admitted pod should not be failed, got %+v", status)
+				}
+				return
+			}
+			if !ok || status.Phase != v1.PodFailed || status.Reason != tc.wantReason {
+				t.Errorf("expected rejected pod to be Failed with reason %q, got %+v", tc.wantReason, status)
+			}
+		})
+	}
+}
+
+// 其他运行中的pod的请求计入已使用的资源，被拒绝的pod不计入
+func TestAdmitPodCountsActivePods(t *testing.T) {
+	kl := newAdmissionTestKubelet(t, testNode(), fsSummary(50*1024*1024*1024)).kubelet
+	first := newTestPod("first", requestingContainer("1500m", "1Gi"))
+	second := newTestPod("second", requestingContainer("1", "1Gi"))
+	third := newTestPod("third", requestingContainer("500m", "1Gi"))
+	for _, pod := range []*v1.Pod{first, second, third} {
+		kl.podManager.AddPod(pod)
+		kl.admitPod(pod)
+	}
+	if status, ok := kl.statusManager.GetPodStatus(second.UID); !ok || status.Reason != "OutOfcpu" {
+		t.Errorf("expected second pod to be rejected with OutOfcpu, got %+v", status)
+	}
+	if status, ok := kl.statusManager.GetPodStatus(third.UID); ok && status.Phase == v1.PodFailed {
+		t.Errorf("expected third pod to fit next to the first one, got %+v", status)
+	}
+}
+
+// 节点还没有同步到缓存时使用本地构造的节点
+func TestAdmitPodWithoutCachedNode(t *testing.T) {
+	kl := newAdmissionTestKubelet(t, nil, fsSummary(50*1024*1024*1024)).kubelet
+	pod := newTestPod("pod", requestingContainer("1", "1Gi"))
+	ok, reason, message := kl.canAdmitPod(nil, pod)
+	if !ok {
+		t.Errorf("expected pod to be admitted against the initial node, got %s: %s", reason, message)
+	}
+	capacity := node.NodeAllocatable()[v1.ResourcePods]
+	pods := make([]*v1.Pod, capacity.Value())
+	for i := range pods {
+		pods[i] = newTestPod("other", v1.Container{Name: "app"})
+	}
+	if ok, reason, _ := kl.canAdmitPod(pods, pod); ok || reason != "OutOfpods" {
+		t.Errorf("expected OutOfpods against the initial node, got %v %q", ok, reason)
+	}
+}
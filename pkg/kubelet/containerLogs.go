@@ -0,0 +1,106 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/logs"
+)
+
+// GetPodByName 根据namespace和名称查找本节点的pod
+func (this *Kubelet) GetPodByName(namespace, name string) (*v1.Pod, bool) {
+	return this.podManager.GetPodByName(namespace, name)
+}
+
+// GetKubeletContainerLogs 读取容器日志，previous为true时读取上一个退出的实例
+// pkg/kubelet/kubelet_pods.go
+func (this *Kubelet) GetKubeletContainerLogs(ctx context.Context, namespace, podName, containerName string,
+	logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error {
+	pod, ok := this.podManager.GetPodByName(namespace, podName)
+	if !ok {
+		return fmt.Errorf("pod %q cannot be found - no logs available", podName)
+	}
+
+	podStatus, found := this.statusManager.GetPodStatus(pod.UID)
+	if !found {
+		podStatus = pod.Status
+	}
+	containerID, err := validateContainerLogStatus(pod.Name, &podStatus, containerName, logOptions.Previous)
+	if err != nil {
+		return err
+	}
+	return this.readContainerLogs(ctx, containerID, logOptions, stdout, stderr)
+}
+
+// 读取容器的日志文件，follow时直到容器退出或ctx取消
+// pkg/kubelet/kuberuntime/kuberuntime_container.go ReadLogs
+func (this *Kubelet) readContainerLogs(ctx context.Context, containerID container.ContainerID, logOptions *v1.PodLogOptions,
+	stdout, stderr io.Writer) error {
+	status, err := this.runtime.GetContainerStatus(ctx, containerID.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get container status %q: %v", containerID.ID, err)
+	}
+	isRunning := func(ctx context.Context) (bool, error) {
+		status, err := this.runtime.GetContainerStatus(ctx, containerID.ID)
+		if err != nil {
+			return false, err
+		}
+		return status.State == container.ContainerStateRunning, nil
+	}
+	opts := logs.NewLogOptions(logOptions, this.clock.Now())
+	return logs.ReadLogs(ctx, status.LogPath, opts, isRunning, stdout, stderr)
+}
+
+// 根据容器状态确定读取哪个容器实例的日志
+func validateContainerLogStatus(podName string, podStatus *v1.PodStatus, containerName string, previous bool) (container.ContainerID, error) {
+	var containerID string
+
+	cStatus, found := findContainerStatus(podStatus.ContainerStatuses, containerName)
+	if !found {
+		cStatus, found = findContainerStatus(podStatus.InitContainerStatuses, containerName)
+	}
+	if !found {
+		return container.ContainerID{}, fmt.Errorf("container %q in pod %q is not available", containerName, podName)
+	}
+	lastState := cStatus.LastTerminationState
+	waiting, running, terminated := cStatus.State.Waiting, cStatus.State.Running, cStatus.State.Terminated
+
+	switch {
+	case previous:
+		if lastState.Terminated == nil || lastState.Terminated.ContainerID == "" {
+			return container.ContainerID{}, fmt.Errorf("previous terminated container %q in pod %q not found", containerName, podName)
+		}
+		containerID = lastState.Terminated.ContainerID
+
+	case running != nil:
+		containerID = cStatus.ContainerID
+
+	case terminated != nil:
+		// 容器状态未知时可能没有containerID，使用上一个实例
+		if terminated.ContainerID == "" {
+			if lastState.Terminated == nil || lastState.Terminated.ContainerID == "" {
+				return container.ContainerID{}, fmt.Errorf("container %q in pod %q is terminated", containerName, podName)
+			}
+			containerID = lastState.Terminated.ContainerID
+		} else {
+			containerID = terminated.ContainerID
+		}
+
+	case lastState.Terminated != nil:
+		// 容器在重启退避中，展示上一个实例的日志
+		if lastState.Terminated.ContainerID == "" {
+			return container.ContainerID{}, fmt.Errorf("container %q in pod %q is terminated", containerName, podName)
+		}
+		containerID = lastState.Terminated.ContainerID
+
+	case waiting != nil:
+		return container.ContainerID{}, fmt.Errorf("container %q in pod %q is waiting to start: %v", containerName, podName, waiting.Reason)
+	}
+
+	if containerID == "" {
+		return container.ContainerID{}, fmt.Errorf("container %q in pod %q is not available", containerName, podName)
+	}
+	return container.ParseContainerID(containerID), nil
+}
@@ -0,0 +1,440 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/cpuset"
+	"mykubelet/pkg/cm"
+	"mykubelet/pkg/cm/memorymanager"
+	"mykubelet/pkg/config"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/eviction"
+	"mykubelet/pkg/images"
+	"mykubelet/pkg/lifecycle"
+	"mykubelet/pkg/logs"
+	"mykubelet/pkg/machine"
+	"mykubelet/pkg/network"
+	"mykubelet/pkg/node"
+	"mykubelet/pkg/pluginmanager"
+	"mykubelet/pkg/podconfig"
+	"mykubelet/pkg/prober"
+	"mykubelet/pkg/stats"
+	"mykubelet/pkg/status"
+	"mykubelet/pkg/token"
+	"mykubelet/pkg/volume"
+	"mykubelet/pkg/volume/csi"
+	"mykubelet/pkg/volumemanager"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// 容器重启的退避时间，从10s开始翻倍，最长5分钟
+	// 容器持续运行2倍最长退避时间（10分钟）后退避重置
+	backOffPeriod       = 10 * time.Second
+	MaxContainerBackOff = 5 * time.Minute
+
+	// pod的定期同步间隔
+	resyncInterval = 10 * time.Second
+	// 同步出错后的重试间隔
+	backOffOnErrorInterval = 10 * time.Second
+
+	syncTickerPeriod   = time.Second
+	housekeepingPeriod = 2 * time.Second
+
+	// 资源统计读取的proc文件系统
+	procRoot = "/proc"
+
+	// 驱逐阈值的检查间隔
+	evictionMonitoringPeriod = 10 * time.Second
+
+	// 容器和镜像垃圾回收的间隔
+	ContainerGCPeriod = time.Minute
+	ImageGCPeriod     = 5 * time.Minute
+)
+
+// Kubelet 管理调度到本节点的pod
+type Kubelet struct {
+	nodeName string
+	client   kubernetes.Interface
+	runtime  container.Runtime
+	clock    clock.WithTicker
+	// kubelet的根目录，pod的卷在其下的pods目录中
+	rootDirectory string
+
+	podManager *podManager
+	// 创建和删除静态pod的mirror pod
+	mirrorClient *mirrorClient
+	// 静态pod清单目录和URL
+	staticPodSources []*staticPodSource
+	podWorkers       *podWorkers
+	statusManager    *status.Manager
+
+	// 执行postStart/preStop钩子
+	runner *lifecycle.HandlerRunner
+	// 记录pod和容器相关的事件
+	recorder record.EventRecorder
+	// 容器日志轮转
+	containerLogManager *logs.ContainerLogManager
+
+	// 节点、pod和容器的资源使用统计
+	statsProvider *stats.Provider
+	// 资源不足时驱逐pod，并提供节点的压力状态
+	evictionManager *eviction.Manager
+	// 按拉取策略拉取镜像
+	imageManager *images.ImageManager
+	// 回收已退出的容器和不再使用的镜像
+	containerGC    *container.ContainerGC
+	imageGCManager *images.ImageGCManager
+	// 挂载和卸载pod的卷
+	volumeManager *volumemanager.VolumeManager
+	// projected卷中service account token的获取和刷新
+	tokenManager *token.Manager
+	// csi卷插件，提供已经挂接到本节点的卷
+	csiPlugin *csi.Plugin
+	// 通过插件注册目录发现csi驱动
+	pluginManager *pluginmanager.PluginManager
+
+	// 管理kubepods、QoS和pod的cgroup
+	containerManager *cm.ContainerManager
+	// 为sandbox配置网络，默认由容器运行时负责
+	networkPlugin *network.PluginManager
+
+	// 缓存本节点，由reflector同步
+	nodeIndexer cache.Indexer
+	// 新增pod的准入检查，按顺序执行
+	admitHandlers []lifecycle.PodAdmitHandler
+
+	// pod来源是否已经完成第一次同步
+	podSourceSynced func() bool
+
+	machineInfoLock sync.Mutex
+	machineInfo     *machine.MachineInfo
+
+	probeManager    *prober.Manager
+	livenessManager *prober.ResultsManager
+	startupManager  *prober.ResultsManager
+
+	// 容器重启退避
+	backOff     *flowcontrol.Backoff
+	reasonCache *reasonCache
+}
+
+// NewKubelet 创建kubelet，clock用于重启退避等计时，测试时可以替换
+func NewKubelet(client kubernetes.Interface, kubeletConfig *config.KubeletConfiguration, runtime container.Runtime,
+	clock clock.WithTicker) (*Kubelet, error) {
+	nodeName := kubeletConfig.NodeName
+	podManager := newPodManager()
+	kl := &Kubelet{
+		nodeName:        nodeName,
+		client:          client,
+		runtime:         runtime,
+		clock:           clock,
+		rootDirectory:   kubeletConfig.RootDirectory,
+		podManager:      podManager,
+		mirrorClient:    newMirrorClient(client, nodeName),
+		statusManager:   status.NewManager(client, podManager),
+		livenessManager: prober.NewResultsManager(),
+		startupManager:  prober.NewResultsManager(),
+		reasonCache:     newReasonCache(),
+		recorder:        makeEventRecorder(client, nodeName),
+		nodeIndexer:     cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+	kl.probeManager = prober.NewManager(kl.statusManager, kl.livenessManager, kl.startupManager, runtime, clock)
+	kl.runner = lifecycle.NewHandlerRunner(runtime, kl.statusManager)
+	kl.podWorkers = newPodWorkers(kl.syncPod, kl.syncTerminatingPod, clock)
+
+	if kubeletConfig.StaticPodPath != "" {
+		kl.addStaticPodSource(podconfig.FileSource, func(updates podconfig.PodsUpdateFunc) podSourceRunner {
+			return podconfig.NewSourceFile(kubeletConfig.StaticPodPath, types.NodeName(nodeName),
+				kubeletConfig.FileCheckFrequency.Duration, updates)
+		})
+	}
+	if kubeletConfig.StaticPodURL != "" {
+		kl.addStaticPodSource(podconfig.HTTPSource, func(updates podconfig.PodsUpdateFunc) podSourceRunner {
+			return podconfig.NewSourceURL(kubeletConfig.StaticPodURL, nil, types.NodeName(nodeName),
+				kubeletConfig.HTTPCheckFrequency.Duration, updates)
+		})
+	}
+
+	kl.backOff = flowcontrol.NewBackOff(backOffPeriod, MaxContainerBackOff)
+	kl.backOff.Clock = clock
+
+	containerLogManager, err := logs.NewContainerLogManager(runtime, kubeletConfig.ContainerLogMaxSize,
+		int(kubeletConfig.ContainerLogMaxFiles), kubeletConfig.ContainerLogCompress, clock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize container log manager: %v", err)
+	}
+	kl.containerLogManager = containerLogManager
+
+	if kl.networkPlugin, err = newNetworkPlugin(kubeletConfig); err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(kubeletConfig.RootDirectory, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create root directory %q: %v", kubeletConfig.RootDirectory, err)
+	}
+	if err = os.MkdirAll(kl.GetPodsDir(), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create pods directory %q: %v", kl.GetPodsDir(), err)
+	}
+	kl.tokenManager = token.NewManager(client, clock)
+	kl.csiPlugin = csi.NewPlugin(kl)
+	kl.pluginManager = pluginmanager.NewPluginManager(kl.getPluginsRegistrationDir(), clock)
+	kl.pluginManager.AddHandler(registerapi.CSIPlugin, csi.NewRegistrationHandler(kl.csiPlugin))
+	kl.volumeManager = volumemanager.NewVolumeManager(volume.NewVolumePluginMgr(kl, kl.csiPlugin), kl.GetPodsDir(),
+		kl.getPodsWithVolumes, kl.recorder, clock)
+	kl.statsProvider = stats.NewProvider(nodeName, runtime, kubeletConfig.CgroupMountPath, procRoot, kubeletConfig.RootDirectory, clock)
+
+	kl.imageManager = images.NewImageManager(kl.recorder, runtime, float32(kubeletConfig.RegistryPullQPS),
+		int(kubeletConfig.RegistryBurst), clock)
+
+	containerGCPolicy := container.GCPolicy{
+		MinAge:             kubeletConfig.MinimumContainerTTLDuration.Duration,
+		MaxPerPodContainer: int(kubeletConfig.MaxPerPodContainerCount),
+		MaxContainers:      int(kubeletConfig.MaxContainerCount),
+	}
+	if kl.containerGC, err = container.NewContainerGC(runtime, containerGCPolicy, kl, kl.sourcesReady, clock); err != nil {
+		return nil, fmt.Errorf("failed to initialize container garbage collector: %v", err)
+	}
+	imageGCPolicy := images.ImageGCPolicy{
+		HighThresholdPercent: int(kubeletConfig.ImageGCHighThresholdPercent),
+		LowThresholdPercent:  int(kubeletConfig.ImageGCLowThresholdPercent),
+		MinAge:               kubeletConfig.ImageMinimumGCAge.Duration,
+	}
+	if kl.imageGCManager, err = images.NewImageGCManager(runtime, kl.statsProvider, kl.recorder, nodeName, imageGCPolicy, clock); err != nil {
+		return nil, fmt.Errorf("failed to initialize image garbage collector: %v", err)
+	}
+
+	thresholds, err := eviction.ParseThresholdConfig(kubeletConfig.EvictionHard, kubeletConfig.EvictionSoft,
+		kubeletConfig.EvictionSoftGracePeriod, kubeletConfig.EvictionMinimumReclaim)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse eviction thresholds: %v", err)
+	}
+	evictionConfig := eviction.Config{
+		PressureTransitionPeriod: kubeletConfig.EvictionPressureTransitionPeriod.Duration,
+		MaxPodGracePeriodSeconds: int64(kubeletConfig.EvictionMaxPodGracePeriod),
+		Thresholds:               thresholds,
+	}
+	kl.evictionManager = eviction.NewManager(kl.statsProvider, evictionConfig, kl.killPodForEviction, kl.GetActivePods,
+		kl.podCleanedUp, kl.imageGCManager, kl.containerGC, kl.recorder, nodeName, clock)
+	reservedSystemCPUs, err := cpuset.Parse(kubeletConfig.ReservedSystemCPUs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse reservedSystemCPUs %q: %v", kubeletConfig.ReservedSystemCPUs, err)
+	}
+	reservedMemory, err := memorymanager.ParseReservedMemory(kubeletConfig.ReservedMemory)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse reservedMemory %q: %v", kubeletConfig.ReservedMemory, err)
+	}
+	kl.containerManager, err = cm.NewContainerManager(cm.NodeConfig{
+		CgroupMountPath:   kubeletConfig.CgroupMountPath,
+		CgroupDriver:      kubeletConfig.CgroupDriver,
+		CgroupsPerQOS:     kubeletConfig.CgroupsPerQOS,
+		NodeAllocatable:   node.NodeAllocatable(),
+		EnforceCPULimits:  kubeletConfig.CPUCFSQuota,
+		CPUCFSQuotaPeriod: kubeletConfig.CPUCFSQuotaPeriod.Duration,
+		PodPidsLimit:      kubeletConfig.PodPidsLimit,
+
+		RootDirectory:             kubeletConfig.RootDirectory,
+		CPUManagerPolicy:          kubeletConfig.CPUManagerPolicy,
+		CPUManagerReconcilePeriod: kubeletConfig.CPUManagerReconcilePeriod.Duration,
+		ReservedSystemCPUs:        reservedSystemCPUs,
+		TopologyManagerPolicy:     kubeletConfig.TopologyManagerPolicy,
+		MemoryManagerPolicy:       kubeletConfig.MemoryManagerPolicy,
+		ReservedMemory:            reservedMemory,
+	}, kl.GetActivePods)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize container manager: %v", err)
+	}
+	kl.admitHandlers = append(kl.admitHandlers, kl.evictionManager, lifecycle.NewPredicateAdmitHandler(kl.getNodeAnyWay),
+		kl.containerManager.GetAllocateResourcesPodAdmitHandler())
+
+	return kl, nil
+}
+
+// 创建事件记录器，事件通过节点的client写入apiServer
+// cmd/kubelet/app/server.go makeEventRecorder
+func makeEventRecorder(client kubernetes.Interface, nodeName string) record.EventRecorder {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(3)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "kubelet", Host: nodeName})
+}
+
+// Run 启动各个管理器和pod同步循环，阻塞直到stopCh关闭
+func (this *Kubelet) Run(stopCh <-chan struct{}) {
+	klog.Infoln("starting kubelet")
+	if err := this.containerManager.Start(this.runtime, stopCh); err != nil {
+		klog.ErrorS(err, "Failed to start ContainerManager")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	this.statusManager.Start()
+	this.probeManager.Start()
+	this.containerLogManager.Start()
+	this.startNodeReflector(stopCh)
+	this.startPodSource(stopCh)
+	this.tokenManager.Start(stopCh)
+	this.volumeManager.Run(this.sourcesReady, stopCh)
+	this.pluginManager.Run(stopCh)
+	this.imageGCManager.Start(stopCh)
+	this.StartGarbageCollection(stopCh)
+	this.evictionManager.Start(evictionMonitoringPeriod, stopCh)
+	node.StartNodeStatusUpdater(this.client, this.nodeName, this.evictionManager, this, this.containerManager, this, stopCh)
+
+	this.syncLoop(stopCh)
+}
+
+// StartGarbageCollection 定期回收容器和镜像
+// pkg/kubelet/kubelet.go StartGarbageCollection
+func (this *Kubelet) StartGarbageCollection(stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := this.containerGC.GarbageCollect(context.Background()); err != nil {
+			klog.ErrorS(err, "Container garbage collection failed")
+		}
+	}, ContainerGCPeriod, stopCh)
+
+	go wait.Until(func() {
+		if err := this.imageGCManager.GarbageCollect(context.Background()); err != nil {
+			klog.ErrorS(err, "Image garbage collection failed")
+		}
+	}, ImageGCPeriod, stopCh)
+}
+
+// sourcesReady pod来源同步之前，不知道哪些pod已经删除
+func (this *Kubelet) sourcesReady() bool {
+	return this.podSourceSynced != nil && this.podSourceSynced()
+}
+
+// ShouldPodContentBeRemoved pod已经删除，或者已经终止且被删除或驱逐，容器和日志可以全部删除
+func (this *Kubelet) ShouldPodContentBeRemoved(uid types.UID) bool {
+	pod, ok := this.podManager.GetPodByUID(uid)
+	if !ok {
+		return true
+	}
+	if !this.podWorkers.IsPodTerminationRequested(uid) || this.podWorkers.IsPodTerminating(uid) {
+		return false
+	}
+	if pod.DeletionTimestamp != nil {
+		return true
+	}
+	status, ok := this.statusManager.GetPodStatus(uid)
+	return ok && status.Reason == eviction.Reason
+}
+
+// syncLoop pod同步主循环
+// pkg/kubelet/kubelet.go syncLoopIteration
+func (this *Kubelet) syncLoop(stopCh <-chan struct{}) {
+	syncTicker := this.clock.NewTicker(syncTickerPeriod)
+	defer syncTicker.Stop()
+	housekeepingTicker := this.clock.NewTicker(housekeepingPeriod)
+	defer housekeepingTicker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case update := <-this.livenessManager.Updates():
+			// 存活探针失败，需要重启容器
+			if update.Result == prober.Failure {
+				this.handleProbeSync(update.PodUID, "liveness")
+			}
+		case update := <-this.startupManager.Updates():
+			started := update.Result == prober.Success
+			this.statusManager.SetContainerStartup(update.PodUID, update.ContainerID, started)
+			this.handleProbeSync(update.PodUID, "startup")
+		case <-syncTicker.C():
+			// 到了重新同步时间的pod
+			for _, uid := range this.podWorkers.getWorkToSync() {
+				if pod, ok := this.podManager.GetPodByUID(uid); ok {
+					this.podWorkers.UpdatePod(pod)
+				}
+			}
+		case <-housekeepingTicker.C():
+			if err := this.HandlePodCleanups(); err != nil {
+				klog.ErrorS(err, "Failed cleaning pods")
+			}
+		}
+	}
+}
+
+// GetPods 本节点的pod，状态使用状态管理器中最新的状态
+func (this *Kubelet) GetPods() []*v1.Pod {
+	pods := this.podManager.GetPods()
+	ret := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if status, ok := this.statusManager.GetPodStatus(pod.UID); ok {
+			pod = pod.DeepCopy()
+			pod.Status = status
+		}
+		ret = append(ret, pod)
+	}
+	return ret
+}
+
+// GetActivePods 还没有开始终止、可以被驱逐的pod
+func (this *Kubelet) GetActivePods() []*v1.Pod {
+	pods := this.GetPods()
+	ret := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if this.podWorkers.IsPodTerminationRequested(pod.UID) {
+			continue
+		}
+		ret = append(ret, pod)
+	}
+	return ret
+}
+
+// 驱逐pod，以指定的宽限期终止，终止后的状态由statusFn设置为Failed
+func (this *Kubelet) killPodForEviction(pod *v1.Pod, gracePeriodOverride int64, statusFn func(*v1.PodStatus)) error {
+	this.podWorkers.TerminatePod(pod, gracePeriodOverride, statusFn)
+	return nil
+}
+
+// pod的容器是否已经全部停止
+func (this *Kubelet) podCleanedUp(pod *v1.Pod) bool {
+	return this.podWorkers.IsPodTerminationRequested(pod.UID) && !this.podWorkers.IsPodTerminating(pod.UID)
+}
+
+// GetCachedMachineInfo 节点的硬件信息，第一次获取后缓存
+func (this *Kubelet) GetCachedMachineInfo() (*machine.MachineInfo, error) {
+	this.machineInfoLock.Lock()
+	defer this.machineInfoLock.Unlock()
+	if this.machineInfo == nil {
+		info, err := machine.GetMachineInfo("/")
+		if err != nil {
+			return nil, err
+		}
+		this.machineInfo = info
+	}
+	return this.machineInfo, nil
+}
+
+// GetSummary 节点和pod的资源使用统计
+func (this *Kubelet) GetSummary(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.Summary, error) {
+	return this.statsProvider.GetSummary(ctx, onlyCPUAndMemory)
+}
+
+func (this *Kubelet) handleProbeSync(uid types.UID, probe string) {
+	pod, ok := this.podManager.GetPodByUID(uid)
+	if !ok {
+		return
+	}
+	klog.V(1).InfoS("SyncLoop (probe)", "probe", probe, "pod", klog.KObj(pod))
+	this.podWorkers.UpdatePod(pod)
+}
@@ -0,0 +1,91 @@
+package kubelet
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"mykubelet/pkg/lifecycle"
+	"mykubelet/pkg/network"
+	"mykubelet/pkg/prober"
+	"mykubelet/pkg/status"
+	"time"
+)
+
+type testKubelet struct {
+	kubelet     *Kubelet
+	fakeRuntime *containertest.FakeRuntime
+	fakeClock   *testingclock.FakeClock
+}
+
+// newTestKubelet 只初始化同步和终止pod需要的组件
+func newTestKubelet() *testKubelet {
+	fakeRuntime := containertest.NewFakeRuntime()
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	podManager := newPodManager()
+	kl := &Kubelet{
+		nodeName:        "node",
+		client:          fake.NewSimpleClientset(),
+		runtime:         fakeRuntime,
+		clock:           fakeClock,
+		podManager:      podManager,
+		livenessManager: prober.NewResultsManager(),
+		startupManager:  prober.NewResultsManager(),
+		reasonCache:     newReasonCache(),
+		recorder:        record.NewFakeRecorder(100),
+		networkPlugin:   network.NewPluginManager(network.NewNoopNetworkPlugin()),
+	}
+	kl.statusManager = status.NewManager(kl.client, podManager)
+	kl.probeManager = prober.NewManager(kl.statusManager, kl.livenessManager, kl.startupManager, fakeRuntime, fakeClock)
+	kl.runner = lifecycle.NewHandlerRunner(fakeRuntime, kl.statusManager)
+	kl.podWorkers = newPodWorkers(kl.syncPod, kl.syncTerminatingPod, fakeClock)
+	kl.backOff = flowcontrol.NewBackOff(backOffPeriod, MaxContainerBackOff)
+	kl.backOff.Clock = fakeClock
+	return &testKubelet{kubelet: kl, fakeRuntime: fakeRuntime, fakeClock: fakeClock}
+}
+
+func newTestPod(uid types.UID, containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-" + string(uid), Namespace: "default", UID: uid},
+		Spec:       v1.PodSpec{Containers: containers},
+	}
+}
+
+// newRunningPodStatus pod的sandbox就绪，每个容器都在运行，容器ID和容器名相同
+func newRunningPodStatus(pod *v1.Pod, startedAt time.Time) *container.PodStatus {
+	podStatus := &container.PodStatus{
+		ID:        pod.UID,
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		SandboxStatuses: []*container.SandboxStatus{{
+			ID:    "sandbox-" + string(pod.UID),
+			Ready: true,
+		}},
+	}
+	for _, c := range pod.Spec.Containers {
+		podStatus.ContainerStatuses = append(podStatus.ContainerStatuses, &container.ContainerStatus{
+			ID:        container.BuildContainerID("fake", c.Name),
+			Name:      c.Name,
+			State:     container.ContainerStateRunning,
+			StartedAt: startedAt,
+		})
+	}
+	return podStatus
+}
+
+// markExited 运行时中的容器已经退出
+func markExited(fakeRuntime *containertest.FakeRuntime, uid types.UID, containerID string, exitCode int) {
+	fakeRuntime.Lock()
+	defer fakeRuntime.Unlock()
+	for _, cs := range fakeRuntime.PodStatus[uid].ContainerStatuses {
+		if cs.ID.ID == containerID {
+			cs.State = container.ContainerStateExited
+			cs.ExitCode = exitCode
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/podconfig"
+)
+
+// mirrorClient 在apiServer中创建和删除静态pod的mirror pod
+// pkg/kubelet/pod/mirror_client.go
+type mirrorClient struct {
+	client   kubernetes.Interface
+	nodeName string
+}
+
+func newMirrorClient(client kubernetes.Interface, nodeName string) *mirrorClient {
+	return &mirrorClient{client: client, nodeName: nodeName}
+}
+
+// CreateMirrorPod 以静态pod的hash作为mirror注解创建mirror pod，owner为本节点，节点删除后一起删除
+func (this *mirrorClient) CreateMirrorPod(pod *v1.Pod) error {
+	node, err := this.client.CoreV1().Nodes().Get(context.Background(), this.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", this.nodeName, err)
+	}
+	if node.DeletionTimestamp != nil {
+		return fmt.Errorf("node %s is being deleted", this.nodeName)
+	}
+
+	mirrorPod := pod.DeepCopy()
+	mirrorPod.UID = ""
+	mirrorPod.ResourceVersion = ""
+	mirrorPod.Status = v1.PodStatus{}
+	hash := podconfig.GetPodHash(pod)
+	mirrorPod.Annotations[podconfig.ConfigMirrorAnnotationKey] = hash
+	controller := true
+	mirrorPod.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "Node",
+			Name:       node.Name,
+			UID:        node.UID,
+			Controller: &controller,
+		},
+	}
+	apiPod, err := this.client.CoreV1().Pods(mirrorPod.Namespace).Create(context.Background(), mirrorPod, metav1.CreateOptions{})
+	if err != nil && apierrors.IsAlreadyExists(err) {
+		// 已经存在相同hash的mirror pod时认为创建成功
+		existing, getErr := this.client.CoreV1().Pods(mirrorPod.Namespace).Get(context.Background(), mirrorPod.Name, metav1.GetOptions{})
+		if getErr == nil && existing.Annotations[podconfig.ConfigMirrorAnnotationKey] == hash {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	klog.V(2).InfoS("Created mirror pod", "pod", klog.KObj(apiPod), "podUID", apiPod.UID)
+	return nil
+}
+
+// DeleteMirrorPod 以0宽限期删除mirror pod，uid不为空时作为前置条件，防止删除新创建的mirror pod
+func (this *mirrorClient) DeleteMirrorPod(namespace, name string, uid *types.UID) (bool, error) {
+	klog.V(2).InfoS("Deleting a mirror pod", "pod", klog.KRef(namespace, name), "podUID", uid)
+	options := metav1.DeleteOptions{GracePeriodSeconds: new(int64)}
+	if uid != nil {
+		options.Preconditions = metav1.NewUIDPreconditions(string(*uid))
+	}
+	if err := this.client.CoreV1().Pods(namespace).Delete(context.Background(), name, options); err != nil {
+		// mirror pod已经不存在或者被重新创建
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
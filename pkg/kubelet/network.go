@@ -0,0 +1,122 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/config"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/network"
+	"mykubelet/pkg/network/cni"
+	"strings"
+)
+
+// newNetworkPlugin 按配置选择网络插件，为空时pod网络由容器运行时负责
+func newNetworkPlugin(kubeletConfig *config.KubeletConfiguration) (*network.PluginManager, error) {
+	switch kubeletConfig.NetworkPluginName {
+	case network.NoopNetworkPluginName:
+		return network.NewPluginManager(network.NewNoopNetworkPlugin()), nil
+	case network.CNIPluginName:
+		binDirs := []string{}
+		for _, dir := range strings.Split(kubeletConfig.CNIBinDir, ",") {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				binDirs = append(binDirs, dir)
+			}
+		}
+		return network.NewPluginManager(cni.NewPlugin(kubeletConfig.CNIConfDir, binDirs, kubeletConfig.CNICacheDir)), nil
+	default:
+		return nil, fmt.Errorf("network plugin %q not found", kubeletConfig.NetworkPluginName)
+	}
+}
+
+// UpdatePodCIDRs 节点spec中的podCIDR，由节点状态更新循环同步
+func (this *Kubelet) UpdatePodCIDRs(podCIDRs []string) {
+	this.networkPlugin.UpdatePodCIDRs(podCIDRs)
+}
+
+// NetworkStatus 网络插件没有就绪时返回原因，节点的NetworkUnavailable据此设置
+func (this *Kubelet) NetworkStatus() error {
+	return this.networkPlugin.NetworkStatus()
+}
+
+// podPortMappings pod中声明了hostPort的端口
+func podPortMappings(pod *v1.Pod) []network.PortMapping {
+	mappings := []network.PortMapping{}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.HostPort <= 0 {
+				continue
+			}
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			mappings = append(mappings, network.PortMapping{
+				HostPort:      p.HostPort,
+				ContainerPort: p.ContainerPort,
+				Protocol:      strings.ToLower(string(protocol)),
+				HostIP:        p.HostIP,
+			})
+		}
+	}
+	return mappings
+}
+
+// setUpPodNetwork sandbox创建后配置网络，失败时清理已经配置的部分，由调用方停止sandbox
+func (this *Kubelet) setUpPodNetwork(ctx context.Context, pod *v1.Pod, sandboxID string) error {
+	if pod.Spec.HostNetwork {
+		return nil
+	}
+	netnsPath, err := this.runtime.GetPodSandboxNetNS(ctx, sandboxID)
+	if err != nil {
+		return fmt.Errorf("failed to get network namespace of sandbox %s: %v", sandboxID, err)
+	}
+	if _, err = this.networkPlugin.SetUpPod(pod.Namespace, pod.Name, sandboxID, netnsPath, podPortMappings(pod)); err != nil {
+		if teardownErr := this.networkPlugin.TearDownPod(pod.Namespace, pod.Name, sandboxID, netnsPath); teardownErr != nil {
+			klog.ErrorS(teardownErr, "Failed to clean up network of sandbox after set up failure", "pod", klog.KObj(pod), "sandboxID", sandboxID)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkPodNetwork 检查运行中sandbox的网络是否和ADD时的结果一致
+func (this *Kubelet) checkPodNetwork(ctx context.Context, pod *v1.Pod, sandboxID string) error {
+	if pod.Spec.HostNetwork || this.networkPlugin.PluginName() == network.NoopNetworkPluginName {
+		return nil
+	}
+	netnsPath, err := this.runtime.GetPodSandboxNetNS(ctx, sandboxID)
+	if err != nil {
+		return fmt.Errorf("failed to get network namespace of sandbox %s: %v", sandboxID, err)
+	}
+	return this.networkPlugin.CheckPod(pod.Namespace, pod.Name, sandboxID, netnsPath)
+}
+
+// tearDownPodNetwork 停止sandbox前清理网络，已经退出的sandbox没有网络命名空间，插件只释放IP等资源
+func (this *Kubelet) tearDownPodNetwork(ctx context.Context, podStatus *container.PodStatus, sandbox *container.SandboxStatus) error {
+	if this.networkPlugin.PluginName() == network.NoopNetworkPluginName {
+		return nil
+	}
+	netnsPath := ""
+	if sandbox.Ready {
+		var err error
+		if netnsPath, err = this.runtime.GetPodSandboxNetNS(ctx, sandbox.ID); err != nil {
+			klog.ErrorS(err, "Failed to get network namespace of sandbox", "pod", klog.KRef(podStatus.Namespace, podStatus.Name),
+				"sandboxID", sandbox.ID)
+		}
+	}
+	return this.networkPlugin.TearDownPod(podStatus.Namespace, podStatus.Name, sandbox.ID, netnsPath)
+}
+
+// podIPs 最新的sandbox就绪时，网络插件分配的IP优先于运行时上报的IP
+func (this *Kubelet) podIPs(podStatus *container.PodStatus) []string {
+	if len(podStatus.SandboxStatuses) == 0 || !podStatus.SandboxStatuses[0].Ready {
+		return podStatus.IPs
+	}
+	status := this.networkPlugin.GetPodNetworkStatus(podStatus.Namespace, podStatus.Name, podStatus.SandboxStatuses[0].ID)
+	if status == nil || len(status.IPs) == 0 {
+		return podStatus.IPs
+	}
+	return status.IPs
+}
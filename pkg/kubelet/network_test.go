@@ -0,0 +1,125 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/client-go/tools/record"
+	"mykubelet/pkg/network"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNetworkPlugin 记录调用，按配置返回错误
+type fakeNetworkPlugin struct {
+	lock     sync.Mutex
+	calls    []string
+	setUpErr error
+	checkErr error
+}
+
+func (this *fakeNetworkPlugin) record(call string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.calls = append(this.calls, call)
+}
+
+func (this *fakeNetworkPlugin) getCalls() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]string{}, this.calls...)
+}
+
+func (this *fakeNetworkPlugin) Name() string {
+	return network.CNIPluginName
+}
+
+func (this *fakeNetworkPlugin) UpdatePodCIDRs(_ []string) {}
+
+func (this *fakeNetworkPlugin) SetUpPod(_, _, sandboxID, _ string, _ []network.PortMapping) (*network.PodNetworkStatus, error) {
+	this.record("SetUpPod " + sandboxID)
+	if this.setUpErr != nil {
+		return nil, this.setUpErr
+	}
+	return &network.PodNetworkStatus{IPs: []string{"10.1.0.5"}}, nil
+}
+
+func (this *fakeNetworkPlugin) TearDownPod(_, _, sandboxID, _ string) error {
+	this.record("TearDownPod " + sandboxID)
+	return nil
+}
+
+func (this *fakeNetworkPlugin) CheckPod(_, _, sandboxID, _ string) error {
+	this.record("CheckPod " + sandboxID)
+	return this.checkErr
+}
+
+func (this *fakeNetworkPlugin) GetPodNetworkStatus(_, _, _ string) *network.PodNetworkStatus {
+	return nil
+}
+
+func (this *fakeNetworkPlugin) Status() error {
+	return nil
+}
+
+// CHECK失败只记录事件，不停止运行中的pod
+func TestSyncPodNetworkCheckFailureKeepsPodRunning(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime := testKubelet.kubelet, testKubelet.fakeRuntime
+	plugin := &fakeNetworkPlugin{checkErr: fmt.Errorf("plugin unavailable")}
+	kl.networkPlugin = network.NewPluginManager(plugin)
+	recorder := kl.recorder.(*record.FakeRecorder)
+
+	pod := newTestPod("uid", preStopContainer("app"))
+	podStatus := newRunningPodStatus(pod, time.Now())
+	if err := kl.syncPodContainers(context.Background(), pod, podStatus); err != nil {
+		t.Fatalf("expected sync to succeed when network check fails, got %v", err)
+	}
+	if calls := plugin.getCalls(); len(calls) != 1 || calls[0] != "CheckPod sandbox-uid" {
+		t.Errorf("expected network to be checked, got %v", calls)
+	}
+	if stopCalls := fakeRuntime.GetStopCalls(); len(stopCalls) != 0 {
+		t.Errorf("expected no containers to be stopped, got %+v", stopCalls)
+	}
+	for _, call := range fakeRuntime.CalledFunctions {
+		if call == "StopPodSandbox" {
+			t.Errorf("expected sandbox not to be stopped")
+		}
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "Pod sandbox network check failed") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Errorf("expected an event for the failed network check")
+	}
+}
+
+// ADD失败时清理已经配置的部分
+func TestSetUpPodNetworkFailureTearsDown(t *testing.T) {
+	kl := newTestKubelet().kubelet
+	plugin := &fakeNetworkPlugin{setUpErr: fmt.Errorf("no IP addresses available")}
+	kl.networkPlugin = network.NewPluginManager(plugin)
+
+	pod := newTestPod("uid", preStopContainer("app"))
+	if err := kl.setUpPodNetwork(context.Background(), pod, "sandbox-uid"); err == nil {
+		t.Fatalf("expected network set up to fail")
+	}
+	expected := []string{"SetUpPod sandbox-uid", "TearDownPod sandbox-uid"}
+	if calls := plugin.getCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+
+	// hostNetwork的pod不经过网络插件
+	plugin.calls = nil
+	pod.Spec.HostNetwork = true
+	if err := kl.setUpPodNetwork(context.Background(), pod, "sandbox-uid"); err != nil {
+		t.Fatalf("expected host network pod to be skipped: %v", err)
+	}
+	if calls := plugin.getCalls(); len(calls) != 0 {
+		t.Errorf("expected no network plugin calls for host network pod, got %v", calls)
+	}
+}
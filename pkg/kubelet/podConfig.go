@@ -0,0 +1,95 @@
+package kubelet
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/podconfig"
+)
+
+// startPodSource 监听调度到本节点的pod，并开始读取静态pod清单
+// apiServer中的pod同步完成、所有静态pod来源都读取过一次后，pod来源才算同步完成
+// pkg/kubelet/config/apiserver.go
+func (this *Kubelet) startPodSource(stopCh <-chan struct{}) {
+	lw := cache.NewListWatchFromClient(this.client.CoreV1().RESTClient(), "pods", metav1.NamespaceAll,
+		fields.OneTermEqualSelector("spec.nodeName", this.nodeName))
+	informer := cache.NewSharedInformer(lw, &v1.Pod{}, 0)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			this.HandlePodAdditions(obj.(*v1.Pod))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			this.HandlePodUpdates(newObj.(*v1.Pod))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				this.HandlePodRemoves(pod)
+			}
+		},
+	})
+	this.podSourceSynced = func() bool {
+		if !informer.HasSynced() {
+			return false
+		}
+		for _, source := range this.staticPodSources {
+			if !source.hasSynced() {
+				return false
+			}
+		}
+		return true
+	}
+	go informer.Run(stopCh)
+	for _, source := range this.staticPodSources {
+		source.runner.Run(stopCh)
+	}
+}
+
+// HandlePodAdditions 新调度到本节点的pod，或者新增的静态pod，准入检查不通过的pod不会运行
+func (this *Kubelet) HandlePodAdditions(pod *v1.Pod) {
+	klog.V(2).InfoS("SyncLoop ADD", "source", podconfig.GetPodSource(pod), "pod", klog.KObj(pod))
+	this.podManager.AddPod(pod)
+	if podconfig.IsMirrorPod(pod) {
+		this.handleMirrorPod(pod)
+		return
+	}
+	if !this.admitPod(pod) {
+		return
+	}
+	this.podWorkers.UpdatePod(pod)
+}
+
+// HandlePodUpdates pod发生变化
+func (this *Kubelet) HandlePodUpdates(pod *v1.Pod) {
+	klog.V(2).InfoS("SyncLoop UPDATE", "source", podconfig.GetPodSource(pod), "pod", klog.KObj(pod))
+	this.podManager.UpdatePod(pod)
+	if podconfig.IsMirrorPod(pod) {
+		this.handleMirrorPod(pod)
+		return
+	}
+	this.podWorkers.UpdatePod(pod)
+}
+
+// HandlePodRemoves pod已经从apiServer删除，或者静态pod的清单已经删除
+// 正常删除时pod已经终止；强制删除时容器可能还在运行，需要立即终止
+func (this *Kubelet) HandlePodRemoves(pod *v1.Pod) {
+	klog.V(2).InfoS("SyncLoop REMOVE", "source", podconfig.GetPodSource(pod), "pod", klog.KObj(pod))
+	this.podManager.DeletePod(pod)
+	if podconfig.IsMirrorPod(pod) {
+		this.handleMirrorPod(pod)
+		return
+	}
+	this.probeManager.RemovePod(pod)
+	this.podWorkers.TerminatePod(pod, calculateGracePeriod(pod), nil)
+}
+
+// handleMirrorPod mirror pod不运行，变化时同步对应的静态pod，由syncPod重新创建不一致或被删除的mirror pod
+func (this *Kubelet) handleMirrorPod(mirrorPod *v1.Pod) {
+	if pod, ok := this.podManager.GetPodByMirrorPod(mirrorPod); ok {
+		this.podWorkers.UpdatePod(pod)
+	}
+}
@@ -0,0 +1,139 @@
+package kubelet
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/podconfig"
+	"sync"
+)
+
+// podManager 保存本节点应该运行的pod，以及静态pod对应的mirror pod
+// mirror pod只用于在apiServer中展示静态pod，不会运行，GetPods等方法不返回mirror pod
+// pkg/kubelet/pod/pod_manager.go
+type podManager struct {
+	lock     sync.RWMutex
+	podByUID map[types.UID]*v1.Pod
+
+	mirrorPodByUID map[types.UID]*v1.Pod
+	// key为namespace/name，和静态pod的全名相同
+	mirrorPodByFullName map[string]*v1.Pod
+}
+
+func newPodManager() *podManager {
+	return &podManager{
+		podByUID:            make(map[types.UID]*v1.Pod),
+		mirrorPodByUID:      make(map[types.UID]*v1.Pod),
+		mirrorPodByFullName: make(map[string]*v1.Pod),
+	}
+}
+
+func (this *podManager) AddPod(pod *v1.Pod) {
+	this.UpdatePod(pod)
+}
+
+func (this *podManager) UpdatePod(pod *v1.Pod) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if podconfig.IsMirrorPod(pod) {
+		this.mirrorPodByUID[pod.UID] = pod
+		this.mirrorPodByFullName[podconfig.GetPodFullName(pod)] = pod
+		return
+	}
+	this.podByUID[pod.UID] = pod
+}
+
+func (this *podManager) DeletePod(pod *v1.Pod) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if podconfig.IsMirrorPod(pod) {
+		delete(this.mirrorPodByUID, pod.UID)
+		// 同名的新mirror pod可能已经创建
+		fullName := podconfig.GetPodFullName(pod)
+		if mirrorPod, ok := this.mirrorPodByFullName[fullName]; ok && mirrorPod.UID == pod.UID {
+			delete(this.mirrorPodByFullName, fullName)
+		}
+		return
+	}
+	delete(this.podByUID, pod.UID)
+}
+
+func (this *podManager) GetPodByUID(uid types.UID) (*v1.Pod, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	pod, ok := this.podByUID[uid]
+	return pod, ok
+}
+
+func (this *podManager) GetPodByName(namespace, name string) (*v1.Pod, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, pod := range this.podByUID {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod, true
+		}
+	}
+	return nil, false
+}
+
+func (this *podManager) GetPods() []*v1.Pod {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	pods := make([]*v1.Pod, 0, len(this.podByUID))
+	for _, pod := range this.podByUID {
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// GetMirrorPods 所有的mirror pod
+func (this *podManager) GetMirrorPods() []*v1.Pod {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	pods := make([]*v1.Pod, 0, len(this.mirrorPodByUID))
+	for _, pod := range this.mirrorPodByUID {
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+// GetMirrorPodByPod 静态pod对应的mirror pod
+func (this *podManager) GetMirrorPodByPod(pod *v1.Pod) (*v1.Pod, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	mirrorPod, ok := this.mirrorPodByFullName[podconfig.GetPodFullName(pod)]
+	return mirrorPod, ok
+}
+
+// GetPodByMirrorPod mirror pod对应的静态pod
+func (this *podManager) GetPodByMirrorPod(mirrorPod *v1.Pod) (*v1.Pod, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, pod := range this.podByUID {
+		if podconfig.IsStaticPod(pod) && podconfig.GetPodFullName(pod) == podconfig.GetPodFullName(mirrorPod) {
+			return pod, true
+		}
+	}
+	return nil, false
+}
+
+// TranslatePodUID mirror pod的uid转换为对应静态pod的uid，其他pod原样返回
+func (this *podManager) TranslatePodUID(uid types.UID) types.UID {
+	this.lock.RLock()
+	mirrorPod, ok := this.mirrorPodByUID[uid]
+	this.lock.RUnlock()
+	if !ok {
+		return uid
+	}
+	if pod, ok := this.GetPodByMirrorPod(mirrorPod); ok {
+		return pod.UID
+	}
+	return uid
+}
+
+// IsMirrorPodOf mirror pod是否和静态pod一致，静态pod的清单变化后需要重新创建mirror pod
+func IsMirrorPodOf(mirrorPod, pod *v1.Pod) bool {
+	if podconfig.GetPodFullName(mirrorPod) != podconfig.GetPodFullName(pod) {
+		return false
+	}
+	return mirrorPod.Annotations[podconfig.ConfigMirrorAnnotationKey] == podconfig.GetPodHash(pod)
+}
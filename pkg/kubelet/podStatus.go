@@ -0,0 +1,251 @@
+package kubelet
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/qos"
+	"mykubelet/pkg/status"
+)
+
+// generateAPIPodStatus 根据运行时状态生成pod status
+// pkg/kubelet/kubelet_pods.go
+func (this *Kubelet) generateAPIPodStatus(pod *v1.Pod, podStatus *container.PodStatus) v1.PodStatus {
+	oldPodStatus, found := this.statusManager.GetPodStatus(pod.UID)
+	if !found {
+		oldPodStatus = pod.Status
+	}
+
+	s := v1.PodStatus{}
+	s.InitContainerStatuses = this.convertToAPIContainerStatuses(pod, podStatus, pod.Spec.InitContainers, "PodInitializing")
+	s.ContainerStatuses = this.convertToAPIContainerStatuses(pod, podStatus, pod.Spec.Containers, "ContainerCreating")
+	this.probeManager.UpdatePodStatus(pod, &s)
+
+	initialized := status.GeneratePodInitializedCondition(&pod.Spec, s.InitContainerStatuses, oldPodStatus.Phase)
+	// 业务容器启动过说明初始化已经完成，之后sidecar重启不影响Initialized
+	if initialized.Status != v1.ConditionTrue && hasAppContainerStarted(s.ContainerStatuses) {
+		initialized = v1.PodCondition{
+			Type:   v1.PodInitialized,
+			Status: v1.ConditionTrue,
+		}
+	}
+	podIsInitialized := initialized.Status == v1.ConditionTrue
+	if !podIsInitialized {
+		for i := range s.ContainerStatuses {
+			if waiting := s.ContainerStatuses[i].State.Waiting; waiting != nil && waiting.Reason == "ContainerCreating" {
+				waiting.Reason = "PodInitializing"
+			}
+		}
+	}
+
+	s.Phase = getPhase(pod, s.InitContainerStatuses, s.ContainerStatuses, podIsInitialized)
+	// 已经结束的pod不能回到运行状态，保留被拒绝或驱逐的原因
+	if oldPodStatus.Phase == v1.PodSucceeded || oldPodStatus.Phase == v1.PodFailed {
+		s.Phase = oldPodStatus.Phase
+		s.Reason = oldPodStatus.Reason
+		s.Message = oldPodStatus.Message
+	}
+
+	s.QOSClass = qos.GetPodQOS(pod)
+	s.Conditions = append(s.Conditions, initialized)
+	s.Conditions = append(s.Conditions, status.GeneratePodReadyCondition(&pod.Spec, s.ContainerStatuses, s.Phase))
+	s.Conditions = append(s.Conditions, status.GenerateContainersReadyCondition(&pod.Spec, s.ContainerStatuses, s.Phase))
+	s.Conditions = append(s.Conditions, v1.PodCondition{
+		Type:   v1.PodScheduled,
+		Status: v1.ConditionTrue,
+	})
+
+	for _, ip := range this.podIPs(podStatus) {
+		s.PodIPs = append(s.PodIPs, v1.PodIP{IP: ip})
+	}
+	if len(s.PodIPs) > 0 {
+		s.PodIP = s.PodIPs[0].IP
+	}
+
+	return s
+}
+
+// 把运行时的容器状态转换为api的容器状态
+// 最新的实例作为State，上一个实例作为LastTerminationState
+func (this *Kubelet) convertToAPIContainerStatuses(pod *v1.Pod, podStatus *container.PodStatus, containers []v1.Container,
+	defaultWaitingReason string) []v1.ContainerStatus {
+	statuses := make(map[string]*v1.ContainerStatus, len(containers))
+	for _, c := range containers {
+		statuses[c.Name] = &v1.ContainerStatus{
+			Name:  c.Name,
+			Image: c.Image,
+			State: v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{Reason: defaultWaitingReason},
+			},
+		}
+	}
+
+	containerSeen := map[string]int{}
+	for _, cs := range podStatus.ContainerStatuses {
+		apiStatus, ok := statuses[cs.Name]
+		if !ok || containerSeen[cs.Name] >= 2 {
+			continue
+		}
+		if containerSeen[cs.Name] == 0 {
+			statuses[cs.Name] = convertContainerStatus(cs)
+		} else if cs.State == container.ContainerStateExited {
+			apiStatus.LastTerminationState = convertContainerStatus(cs).State
+		}
+		containerSeen[cs.Name]++
+	}
+
+	// 启动失败的容器，展示失败原因
+	for _, c := range containers {
+		apiStatus := statuses[c.Name]
+		if apiStatus.State.Running != nil {
+			continue
+		}
+		reasonInfo, ok := this.reasonCache.Get(pod.UID, c.Name)
+		if !ok {
+			continue
+		}
+		if apiStatus.State.Terminated != nil {
+			apiStatus.LastTerminationState = apiStatus.State
+		}
+		apiStatus.State = v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason:  reasonInfo.Err.Error(),
+				Message: reasonInfo.Message,
+			},
+		}
+	}
+
+	ret := make([]v1.ContainerStatus, 0, len(containers))
+	for _, c := range containers {
+		ret = append(ret, *statuses[c.Name])
+	}
+	return ret
+}
+
+func convertContainerStatus(cs *container.ContainerStatus) *v1.ContainerStatus {
+	apiStatus := &v1.ContainerStatus{
+		Name:         cs.Name,
+		Image:        cs.Image,
+		ImageID:      cs.ImageID,
+		ContainerID:  cs.ID.String(),
+		RestartCount: int32(cs.RestartCount),
+	}
+	switch cs.State {
+	case container.ContainerStateRunning:
+		apiStatus.State.Running = &v1.ContainerStateRunning{StartedAt: metav1.NewTime(cs.StartedAt)}
+	case container.ContainerStateExited:
+		apiStatus.State.Terminated = &v1.ContainerStateTerminated{
+			ExitCode:    int32(cs.ExitCode),
+			Reason:      cs.Reason,
+			Message:     cs.Message,
+			StartedAt:   metav1.NewTime(cs.StartedAt),
+			FinishedAt:  metav1.NewTime(cs.FinishedAt),
+			ContainerID: cs.ID.String(),
+		}
+	default:
+		apiStatus.State.Waiting = &v1.ContainerStateWaiting{}
+	}
+	return apiStatus
+}
+
+// 业务容器是否运行过
+func hasAppContainerStarted(statuses []v1.ContainerStatus) bool {
+	for _, s := range statuses {
+		if s.State.Running != nil || s.State.Terminated != nil || s.LastTerminationState.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// getPhase 根据容器状态计算pod的phase
+func getPhase(pod *v1.Pod, initInfo []v1.ContainerStatus, info []v1.ContainerStatus, podIsInitialized bool) v1.PodPhase {
+	spec := &pod.Spec
+
+	failedInitialization := 0
+	for _, c := range spec.InitContainers {
+		if container.IsRestartableInitContainer(&c) {
+			continue
+		}
+		containerStatus, ok := findContainerStatus(initInfo, c.Name)
+		if !ok {
+			continue
+		}
+		if containerStatus.State.Terminated != nil && containerStatus.State.Terminated.ExitCode != 0 {
+			failedInitialization++
+		} else if containerStatus.State.Waiting != nil && containerStatus.LastTerminationState.Terminated != nil &&
+			containerStatus.LastTerminationState.Terminated.ExitCode != 0 {
+			failedInitialization++
+		}
+	}
+	// 初始化失败且不会重试
+	if !podIsInitialized && failedInitialization > 0 && spec.RestartPolicy == v1.RestartPolicyNever {
+		return v1.PodFailed
+	}
+	if !podIsInitialized {
+		return v1.PodPending
+	}
+
+	unknown := 0
+	running := 0
+	waiting := 0
+	stopped := 0
+	succeeded := 0
+	for _, c := range spec.Containers {
+		containerStatus, ok := findContainerStatus(info, c.Name)
+		if !ok {
+			unknown++
+			continue
+		}
+
+		switch {
+		case containerStatus.State.Running != nil:
+			running++
+		case containerStatus.State.Terminated != nil:
+			stopped++
+			if containerStatus.State.Terminated.ExitCode == 0 {
+				succeeded++
+			}
+		case containerStatus.State.Waiting != nil:
+			if containerStatus.LastTerminationState.Terminated != nil {
+				stopped++
+			} else {
+				waiting++
+			}
+		default:
+			unknown++
+		}
+	}
+
+	switch {
+	case waiting > 0:
+		return v1.PodPending
+	case running > 0 && unknown == 0:
+		return v1.PodRunning
+	case running == 0 && stopped > 0 && unknown == 0:
+		// 所有容器都退出了，由restartPolicy决定pod是否结束
+		if spec.RestartPolicy == v1.RestartPolicyAlways {
+			return v1.PodRunning
+		}
+		if stopped == succeeded {
+			return v1.PodSucceeded
+		}
+		if spec.RestartPolicy == v1.RestartPolicyNever {
+			return v1.PodFailed
+		}
+		return v1.PodRunning
+	case running > 0 && unknown > 0:
+		return v1.PodRunning
+	default:
+		return v1.PodPending
+	}
+}
+
+func findContainerStatus(statuses []v1.ContainerStatus, name string) (v1.ContainerStatus, bool) {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return v1.ContainerStatus{}, false
+}
@@ -0,0 +1,307 @@
+package kubelet
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/metrics"
+	"sync"
+	"time"
+)
+
+type syncPodFnType func(pod *v1.Pod) error
+
+// 终止pod，ctx在宽限期缩短时被取消，podStatusFn不为空时用来修改pod终止时的状态
+type syncTerminatingPodFnType func(ctx context.Context, pod *v1.Pod, gracePeriod int64, podStatusFn func(*v1.PodStatus)) error
+
+// pod的终止状态
+type podSyncStatus struct {
+	// 收到删除请求的时间，为零表示没有在终止
+	terminatingAt time.Time
+	// 宽限期，再次删除时只能缩短
+	gracePeriod int64
+	// 修改pod终止时的状态，如驱逐时设置Failed和Evicted
+	podStatusFn func(*v1.PodStatus)
+	// 取消正在执行的终止操作
+	cancelFn context.CancelFunc
+	// 所有容器已经停止
+	terminated bool
+}
+
+// podWorkers 每个pod一个协程串行执行syncPod
+// 协程忙时只保留最新的一次更新
+// pkg/kubelet/pod_workers.go
+type podWorkers struct {
+	lock sync.Mutex
+
+	podUpdates map[types.UID]chan *v1.Pod
+	isWorking  map[types.UID]bool
+	// 协程忙时收到的最新更新
+	lastUndeliveredWorkUpdate map[types.UID]*v1.Pod
+	// 下一次需要重新同步的时间
+	workQueue map[types.UID]time.Time
+	// 正在终止或已经终止的pod
+	podSyncStatuses map[types.UID]*podSyncStatus
+
+	syncPodFn            syncPodFnType
+	syncTerminatingPodFn syncTerminatingPodFnType
+	clock                clock.Clock
+}
+
+func newPodWorkers(syncPodFn syncPodFnType, syncTerminatingPodFn syncTerminatingPodFnType, clock clock.Clock) *podWorkers {
+	return &podWorkers{
+		podUpdates:                make(map[types.UID]chan *v1.Pod),
+		isWorking:                 make(map[types.UID]bool),
+		lastUndeliveredWorkUpdate: make(map[types.UID]*v1.Pod),
+		workQueue:                 make(map[types.UID]time.Time),
+		podSyncStatuses:           make(map[types.UID]*podSyncStatus),
+		syncPodFn:                 syncPodFn,
+		syncTerminatingPodFn:      syncTerminatingPodFn,
+		clock:                     clock,
+	}
+}
+
+// UpdatePod 触发pod同步，pod设置了deletionTimestamp时进入终止流程
+// 已经开始终止但还没有完成的pod（如驱逐的pod没有deletionTimestamp），按原来的宽限期重新执行终止，
+// 上一次终止失败时由此重试
+func (this *podWorkers) UpdatePod(pod *v1.Pod) {
+	if pod.DeletionTimestamp != nil {
+		this.TerminatePod(pod, calculateGracePeriod(pod), nil)
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if status, ok := this.podSyncStatuses[pod.UID]; ok && !status.terminatingAt.IsZero() {
+		if status.terminated {
+			klog.V(4).InfoS("Pod is terminated, ignoring update", "pod", klog.KObj(pod), "podUID", pod.UID)
+			return
+		}
+		klog.V(4).InfoS("Pod is terminating, retrying termination", "pod", klog.KObj(pod), "podUID", pod.UID)
+	}
+	this.deliver(pod)
+}
+
+// TerminatePod 以指定的宽限期终止pod，podStatusFn用于修改pod终止时的状态，可以为空
+// 终止过程中宽限期缩短时，取消正在执行的终止操作，用新的宽限期重新执行
+func (this *podWorkers) TerminatePod(pod *v1.Pod, gracePeriod int64, podStatusFn func(*v1.PodStatus)) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	status, ok := this.podSyncStatuses[pod.UID]
+	if !ok {
+		status = &podSyncStatus{}
+		this.podSyncStatuses[pod.UID] = status
+	}
+	if status.terminated {
+		return
+	}
+	if podStatusFn != nil {
+		status.podStatusFn = podStatusFn
+	}
+	if status.terminatingAt.IsZero() {
+		status.terminatingAt = this.clock.Now()
+		status.gracePeriod = gracePeriod
+		klog.V(2).InfoS("Pod is being terminated", "pod", klog.KObj(pod), "podUID", pod.UID, "gracePeriod", gracePeriod)
+	} else if gracePeriod < status.gracePeriod {
+		klog.V(2).InfoS("Pod termination grace period shortened", "pod", klog.KObj(pod), "podUID", pod.UID,
+			"oldGracePeriod", status.gracePeriod, "gracePeriod", gracePeriod)
+		status.gracePeriod = gracePeriod
+		if status.cancelFn != nil {
+			status.cancelFn()
+		}
+	} else {
+		// 宽限期没有变化，正在执行的终止操作继续即可
+		if this.isWorking[pod.UID] {
+			return
+		}
+	}
+	this.deliver(pod)
+}
+
+// IsPodTerminating pod是否在终止过程中
+func (this *podWorkers) IsPodTerminating(uid types.UID) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	status, ok := this.podSyncStatuses[uid]
+	return ok && !status.terminatingAt.IsZero() && !status.terminated
+}
+
+// IsPodTerminationRequested pod是否已经开始终止，包括已经终止的pod
+func (this *podWorkers) IsPodTerminationRequested(uid types.UID) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	status, ok := this.podSyncStatuses[uid]
+	return ok && !status.terminatingAt.IsZero()
+}
+
+// 调用方需持有锁
+func (this *podWorkers) deliver(pod *v1.Pod) {
+	uid := pod.UID
+	podUpdates, exists := this.podUpdates[uid]
+	if !exists {
+		podUpdates = make(chan *v1.Pod, 1)
+		this.podUpdates[uid] = podUpdates
+		go this.managePodLoop(podUpdates)
+	}
+	if !this.isWorking[uid] {
+		this.isWorking[uid] = true
+		podUpdates <- pod
+	} else {
+		this.lastUndeliveredWorkUpdate[uid] = pod
+	}
+	this.updateQueueDepth()
+}
+
+// 等待中的更新和等待重新同步的pod数量，调用方需持有锁
+func (this *podWorkers) updateQueueDepth() {
+	metrics.PodWorkerQueueDepth.Set(float64(len(this.lastUndeliveredWorkUpdate) + len(this.workQueue)))
+}
+
+// ForgetWorker 停止pod的协程
+func (this *podWorkers) ForgetWorker(uid types.UID) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.forgetWorker(uid)
+}
+
+// SyncKnownPods 清理已经终止且不再需要的pod的协程
+func (this *podWorkers) SyncKnownPods(desiredPods map[types.UID]bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for uid, status := range this.podSyncStatuses {
+		if !desiredPods[uid] && status.terminated {
+			this.forgetWorker(uid)
+		}
+	}
+}
+
+// 调用方需持有锁
+func (this *podWorkers) forgetWorker(uid types.UID) {
+	if status, ok := this.podSyncStatuses[uid]; ok && status.cancelFn != nil {
+		status.cancelFn()
+	}
+	delete(this.podSyncStatuses, uid)
+	if ch, ok := this.podUpdates[uid]; ok {
+		close(ch)
+		delete(this.podUpdates, uid)
+		delete(this.isWorking, uid)
+		delete(this.lastUndeliveredWorkUpdate, uid)
+		delete(this.workQueue, uid)
+	}
+	this.updateQueueDepth()
+}
+
+func (this *podWorkers) managePodLoop(podUpdates <-chan *v1.Pod) {
+	for pod := range podUpdates {
+		var err error
+		start := this.clock.Now()
+		if ctx, gracePeriod, podStatusFn, terminating := this.startTerminating(pod.UID); terminating {
+			err = this.syncTerminatingPodFn(ctx, pod, gracePeriod, podStatusFn)
+			metrics.PodWorkerDuration.WithLabelValues(metrics.OperationTerminate).Observe(this.clock.Since(start).Seconds())
+			if err == nil {
+				this.completeTerminating(pod)
+			} else if ctx.Err() != nil {
+				klog.V(2).InfoS("Pod termination was interrupted", "pod", klog.KObj(pod), "podUID", pod.UID, "err", err)
+				err = nil
+			}
+		} else {
+			err = this.syncPodFn(pod)
+			metrics.PodWorkerDuration.WithLabelValues(metrics.OperationSync).Observe(this.clock.Since(start).Seconds())
+		}
+		if err != nil {
+			klog.ErrorS(err, "Error syncing pod, skipping", "pod", klog.KObj(pod), "podUID", pod.UID)
+		}
+		this.wrapUp(pod.UID, err)
+	}
+}
+
+// 如果pod需要终止，返回可以被取消的ctx、当前的宽限期和修改终止状态的函数
+func (this *podWorkers) startTerminating(uid types.UID) (context.Context, int64, func(*v1.PodStatus), bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	status, ok := this.podSyncStatuses[uid]
+	if !ok || status.terminatingAt.IsZero() || status.terminated {
+		return nil, 0, nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	status.cancelFn = cancel
+	return ctx, status.gracePeriod, status.podStatusFn, true
+}
+
+func (this *podWorkers) completeTerminating(pod *v1.Pod) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	status, ok := this.podSyncStatuses[pod.UID]
+	if !ok {
+		return
+	}
+	status.cancelFn = nil
+	status.terminated = true
+	klog.V(2).InfoS("Pod terminated", "pod", klog.KObj(pod), "podUID", pod.UID,
+		"duration", this.clock.Since(status.terminatingAt))
+}
+
+// 同步结束，决定下一次同步的时间，并投递协程忙时收到的更新
+func (this *podWorkers) wrapUp(uid types.UID, syncErr error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	defer this.updateQueueDepth()
+
+	// 已经终止的pod不再同步
+	if status, ok := this.podSyncStatuses[uid]; ok && status.terminated {
+		delete(this.workQueue, uid)
+		delete(this.lastUndeliveredWorkUpdate, uid)
+		this.isWorking[uid] = false
+		return
+	}
+
+	if syncErr != nil {
+		this.workQueue[uid] = this.clock.Now().Add(backOffOnErrorInterval)
+	} else {
+		this.workQueue[uid] = this.clock.Now().Add(resyncInterval)
+	}
+
+	if pod, exists := this.lastUndeliveredWorkUpdate[uid]; exists {
+		if ch, ok := this.podUpdates[uid]; ok {
+			ch <- pod
+		}
+		delete(this.lastUndeliveredWorkUpdate, uid)
+	} else {
+		this.isWorking[uid] = false
+	}
+}
+
+// 取出到了同步时间的pod
+func (this *podWorkers) getWorkToSync() []types.UID {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	now := this.clock.Now()
+	ret := []types.UID{}
+	for uid, t := range this.workQueue {
+		if !t.After(now) {
+			ret = append(ret, uid)
+			delete(this.workQueue, uid)
+		}
+	}
+	this.updateQueueDepth()
+	return ret
+}
+
+// 计算pod的终止宽限期
+// 优先使用apiServer设置的deletionGracePeriodSeconds，强制删除时也至少给1秒
+func calculateGracePeriod(pod *v1.Pod) int64 {
+	gracePeriod := defaultTerminationGracePeriod
+	if pod.DeletionGracePeriodSeconds != nil {
+		gracePeriod = *pod.DeletionGracePeriodSeconds
+	} else if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	if gracePeriod < 1 {
+		gracePeriod = 1
+	}
+	return gracePeriod
+}
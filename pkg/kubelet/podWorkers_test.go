@@ -0,0 +1,234 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	containertest "mykubelet/pkg/container/testing"
+	"mykubelet/pkg/eviction"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func deletedPod(pod *v1.Pod, now time.Time, gracePeriod int64) *v1.Pod {
+	deleted := pod.DeepCopy()
+	deletionTimestamp := metav1.NewTime(now)
+	deleted.DeletionTimestamp = &deletionTimestamp
+	deleted.DeletionGracePeriodSeconds = &gracePeriod
+	return deleted
+}
+
+func TestTerminatePodShortenedGracePeriod(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime := testKubelet.kubelet, testKubelet.fakeRuntime
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	fakeRuntime.SetPodStatus(newRunningPodStatus(pod, testKubelet.fakeClock.Now()))
+
+	// 第一次停止一直等到被取消，之后的停止立即完成
+	stopping := make(chan struct{})
+	fakeRuntime.StopContainerFn = func(ctx context.Context, containerID string, timeout int64) error {
+		if len(fakeRuntime.GetStopCalls()) == 1 {
+			close(stopping)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		markExited(fakeRuntime, pod.UID, containerID, 137)
+		return nil
+	}
+
+	kl.podWorkers.UpdatePod(deletedPod(pod, testKubelet.fakeClock.Now(), 30))
+	<-stopping
+	if !kl.podWorkers.IsPodTerminating(pod.UID) {
+		t.Fatal("expected pod to be terminating")
+	}
+
+	kl.podWorkers.UpdatePod(deletedPod(pod, testKubelet.fakeClock.Now(), 5))
+	waitFor(t, "pod to terminate", func() bool {
+		return kl.podWorkers.IsPodTerminationRequested(pod.UID) && !kl.podWorkers.IsPodTerminating(pod.UID)
+	})
+
+	expected := []containertest.StopContainerCall{{ContainerID: "app", Timeout: 30}, {ContainerID: "app", Timeout: 5}}
+	if calls := fakeRuntime.GetStopCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected stop calls %+v, got %+v", expected, calls)
+	}
+}
+
+func TestTerminatePodLongerGracePeriodIgnored(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime := testKubelet.kubelet, testKubelet.fakeRuntime
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	fakeRuntime.SetPodStatus(newRunningPodStatus(pod, testKubelet.fakeClock.Now()))
+
+	stopping := make(chan struct{})
+	release := make(chan struct{})
+	fakeRuntime.StopContainerFn = func(ctx context.Context, containerID string, timeout int64) error {
+		close(stopping)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		markExited(fakeRuntime, pod.UID, containerID, 0)
+		return nil
+	}
+
+	kl.podWorkers.UpdatePod(deletedPod(pod, testKubelet.fakeClock.Now(), 10))
+	<-stopping
+	// 宽限期只能缩短，更长的宽限期不会打断正在执行的终止
+	kl.podWorkers.UpdatePod(deletedPod(pod, testKubelet.fakeClock.Now(), 60))
+	close(release)
+	waitFor(t, "pod to terminate", func() bool {
+		return !kl.podWorkers.IsPodTerminating(pod.UID)
+	})
+
+	expected := []containertest.StopContainerCall{{ContainerID: "app", Timeout: 10}}
+	if calls := fakeRuntime.GetStopCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected stop calls %+v, got %+v", expected, calls)
+	}
+}
+
+func TestCalculateGracePeriod(t *testing.T) {
+	thirty, zero := int64(30), int64(0)
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		expected int64
+	}{
+		{name: "default", pod: &v1.Pod{}, expected: defaultTerminationGracePeriod},
+		{name: "spec", pod: &v1.Pod{Spec: v1.PodSpec{TerminationGracePeriodSeconds: &thirty}}, expected: 30},
+		{
+			name: "deletion overrides spec",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{DeletionGracePeriodSeconds: &zero},
+				Spec:       v1.PodSpec{TerminationGracePeriodSeconds: &thirty},
+			},
+			expected: 1,
+		},
+	}
+	for _, test := range tests {
+		if got := calculateGracePeriod(test.pod); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+	}
+}
+
+// 驱逐的pod没有deletionTimestamp，第一次终止失败后由同步循环重试
+func TestEvictedPodTerminationRetried(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime, fakeClock := testKubelet.kubelet, testKubelet.fakeRuntime, testKubelet.fakeClock
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	kl.podManager.AddPod(pod)
+	fakeRuntime.SetPodStatus(newRunningPodStatus(pod, fakeClock.Now()))
+
+	fakeRuntime.StopContainerFn = func(ctx context.Context, containerID string, timeout int64) error {
+		if len(fakeRuntime.GetStopCalls()) == 1 {
+			return fmt.Errorf("runtime unavailable")
+		}
+		markExited(fakeRuntime, pod.UID, containerID, 137)
+		return nil
+	}
+
+	// 可用内存低于硬驱逐阈值
+	available, workingSet := uint64(100*1024*1024), uint64(4*1024*1024*1024)
+	summaryProvider := &fakeSummaryProvider{summary: &statsapi.Summary{
+		Node: statsapi.NodeStats{
+			Memory: &statsapi.MemoryStats{AvailableBytes: &available, WorkingSetBytes: &workingSet},
+		},
+	}}
+	threshold := resource.MustParse("1Gi")
+	evictionManager := eviction.NewManager(summaryProvider, eviction.Config{
+		PressureTransitionPeriod: 5 * time.Minute,
+		Thresholds: []eviction.Threshold{{
+			Signal: eviction.SignalMemoryAvailable,
+			Value:  eviction.ThresholdValue{Quantity: &threshold},
+		}},
+	}, kl.killPodForEviction, kl.GetActivePods, kl.podCleanedUp, nil, nil, kl.recorder, kl.nodeName, fakeClock)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	evictionManager.Start(10*time.Millisecond, stopCh)
+
+	waitFor(t, "failed termination to be requeued", func() bool {
+		kl.podWorkers.lock.Lock()
+		defer kl.podWorkers.lock.Unlock()
+		_, queued := kl.podWorkers.workQueue[pod.UID]
+		return queued && !kl.podWorkers.isWorking[pod.UID]
+	})
+	if !kl.podWorkers.IsPodTerminating(pod.UID) {
+		t.Fatal("expected pod to still be terminating after the failed kill")
+	}
+	if !evictionManager.IsUnderMemoryPressure() {
+		t.Errorf("expected memory pressure")
+	}
+
+	// 同步循环在退避之后重新投递pod
+	fakeClock.Step(backOffOnErrorInterval)
+	for _, uid := range kl.podWorkers.getWorkToSync() {
+		if p, ok := kl.podManager.GetPodByUID(uid); ok {
+			kl.podWorkers.UpdatePod(p)
+		}
+	}
+	waitFor(t, "pod to terminate", func() bool {
+		return kl.podCleanedUp(pod)
+	})
+
+	if calls := fakeRuntime.GetStopCalls(); len(calls) != 2 {
+		t.Errorf("expected 2 stop calls, got %+v", calls)
+	}
+	status, ok := kl.statusManager.GetPodStatus(pod.UID)
+	if !ok || status.Phase != v1.PodFailed || status.Reason != eviction.Reason {
+		t.Errorf("expected evicted pod status, got %+v", status)
+	}
+	if pods := kl.GetActivePods(); len(pods) != 0 {
+		t.Errorf("evicted pod should not be active, got %d pods", len(pods))
+	}
+}
+
+// 已经终止的pod不再处理更新
+func TestUpdateTerminatedPodIgnored(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime := testKubelet.kubelet, testKubelet.fakeRuntime
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	fakeRuntime.SetPodStatus(newRunningPodStatus(pod, testKubelet.fakeClock.Now()))
+	fakeRuntime.StopContainerFn = func(ctx context.Context, containerID string, timeout int64) error {
+		markExited(fakeRuntime, pod.UID, containerID, 0)
+		return nil
+	}
+
+	kl.podWorkers.TerminatePod(pod, 10, nil)
+	waitFor(t, "pod to terminate", func() bool {
+		return kl.podCleanedUp(pod)
+	})
+	kl.podWorkers.UpdatePod(pod)
+	kl.podWorkers.lock.Lock()
+	working := kl.podWorkers.isWorking[pod.UID]
+	kl.podWorkers.lock.Unlock()
+	if working {
+		t.Errorf("terminated pod should not be synced again")
+	}
+	if calls := fakeRuntime.GetStopCalls(); len(calls) != 1 {
+		t.Errorf("expected 1 stop call, got %+v", calls)
+	}
+}
+
+type fakeSummaryProvider struct {
+	summary *statsapi.Summary
+}
+
+func (this *fakeSummaryProvider) GetSummary(_ context.Context, _ bool) (*statsapi.Summary, error) {
+	return this.summary, nil
+}
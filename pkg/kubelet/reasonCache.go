@@ -0,0 +1,59 @@
+package kubelet
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync"
+)
+
+// reasonCache 记录容器最近一次启动失败的原因，如CrashLoopBackOff
+// 生成pod status时作为容器的waiting reason
+type reasonCache struct {
+	lock  sync.Mutex
+	cache map[string]reasonItem
+}
+
+type reasonItem struct {
+	Err     error
+	Message string
+}
+
+func newReasonCache() *reasonCache {
+	return &reasonCache{cache: make(map[string]reasonItem)}
+}
+
+func (this *reasonCache) composeKey(uid types.UID, name string) string {
+	return fmt.Sprintf("%s_%s", uid, name)
+}
+
+func (this *reasonCache) Add(uid types.UID, name string, reason error, message string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.cache[this.composeKey(uid, name)] = reasonItem{Err: reason, Message: message}
+}
+
+func (this *reasonCache) Remove(uid types.UID, name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.cache, this.composeKey(uid, name))
+}
+
+func (this *reasonCache) Get(uid types.UID, name string) (*reasonItem, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	item, ok := this.cache[this.composeKey(uid, name)]
+	return &item, ok
+}
+
+// RemovePod 删除pod所有容器的记录
+func (this *reasonCache) RemovePod(uid types.UID) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	prefix := fmt.Sprintf("%s_", uid)
+	for key := range this.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(this.cache, key)
+		}
+	}
+}
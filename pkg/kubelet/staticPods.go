@@ -0,0 +1,126 @@
+package kubelet
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/podconfig"
+	"sort"
+	"sync"
+)
+
+// podSourceRunner 读取静态pod清单的SourceFile或SourceURL
+type podSourceRunner interface {
+	Run(stopCh <-chan struct{})
+}
+
+// staticPodSource 静态pod的来源，记录上一次读取到的全量pod，用于计算增加和删除的pod
+// pkg/kubelet/config/config.go podStorage
+type staticPodSource struct {
+	name   string
+	runner podSourceRunner
+
+	lock sync.Mutex
+	pods map[types.UID]*v1.Pod
+	// 是否已经读取过一次，之前不知道哪些静态pod已经删除
+	seen bool
+}
+
+func (this *staticPodSource) hasSynced() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.seen
+}
+
+// addStaticPodSource 添加静态pod来源，newRunner用更新函数创建读取清单的runner
+func (this *Kubelet) addStaticPodSource(name string, newRunner func(updates podconfig.PodsUpdateFunc) podSourceRunner) {
+	source := &staticPodSource{name: name, pods: map[types.UID]*v1.Pod{}}
+	source.runner = newRunner(func(pods []*v1.Pod) {
+		this.updateStaticPods(source, pods)
+	})
+	this.staticPodSources = append(this.staticPodSources, source)
+}
+
+// updateStaticPods 和上一次的全量pod比较，清单的内容变化时uid也会变化，表现为删除旧pod、增加新pod
+func (this *Kubelet) updateStaticPods(source *staticPodSource, pods []*v1.Pod) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+
+	newPods := make(map[types.UID]*v1.Pod, len(pods))
+	for _, pod := range pods {
+		newPods[pod.UID] = pod
+	}
+	removed := []*v1.Pod{}
+	for uid, pod := range source.pods {
+		if _, ok := newPods[uid]; !ok {
+			removed = append(removed, pod)
+		}
+	}
+	added := []*v1.Pod{}
+	for uid, pod := range newPods {
+		if _, ok := source.pods[uid]; !ok {
+			added = append(added, pod)
+		}
+	}
+	source.pods = newPods
+	source.seen = true
+
+	// 先删除旧pod，再按名字顺序增加新pod
+	for _, pod := range removed {
+		this.HandlePodRemoves(pod)
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return podconfig.GetPodFullName(added[i]) < podconfig.GetPodFullName(added[j])
+	})
+	for _, pod := range added {
+		this.HandlePodAdditions(pod)
+	}
+}
+
+// syncMirrorPod 保证静态pod在apiServer中有一致的mirror pod
+// mirror pod正在删除或者和静态pod不一致时先删除，再重新创建；apiServer不可用时只记录日志，不影响静态pod运行
+// pkg/kubelet/kubelet.go syncPod
+func (this *Kubelet) syncMirrorPod(pod *v1.Pod) {
+	mirrorPod, ok := this.podManager.GetMirrorPodByPod(pod)
+	deleted := false
+	if ok && (mirrorPod.DeletionTimestamp != nil || !IsMirrorPodOf(mirrorPod, pod)) {
+		klog.InfoS("Trying to delete pod", "pod", klog.KObj(pod), "podUID", mirrorPod.UID)
+		var err error
+		deleted, err = this.mirrorClient.DeleteMirrorPod(mirrorPod.Namespace, mirrorPod.Name, &mirrorPod.UID)
+		if err != nil {
+			klog.ErrorS(err, "Failed deleting mirror pod", "pod", klog.KObj(mirrorPod))
+			return
+		}
+		if deleted {
+			this.podManager.DeletePod(mirrorPod)
+		}
+	}
+	if ok && !deleted {
+		return
+	}
+	klog.V(4).InfoS("Creating a mirror pod for static pod", "pod", klog.KObj(pod))
+	if err := this.mirrorClient.CreateMirrorPod(pod); err != nil {
+		klog.ErrorS(err, "Failed creating a mirror pod for", "pod", klog.KObj(pod))
+	}
+}
+
+// deleteOrphanedMirrorPods 删除静态pod已经不存在的mirror pod，静态pod停止完成后再删除
+// pkg/kubelet/kubelet_pods.go deleteOrphanedMirrorPods
+func (this *Kubelet) deleteOrphanedMirrorPods() {
+	for _, mirrorPod := range this.podManager.GetMirrorPods() {
+		if _, ok := this.podManager.GetPodByMirrorPod(mirrorPod); ok {
+			continue
+		}
+		// mirror注解的值是静态pod的hash，也就是静态pod的uid
+		staticPodUID := types.UID(mirrorPod.Annotations[podconfig.ConfigMirrorAnnotationKey])
+		if this.podWorkers.IsPodTerminating(staticPodUID) {
+			continue
+		}
+		uid := mirrorPod.UID
+		if _, err := this.mirrorClient.DeleteMirrorPod(mirrorPod.Namespace, mirrorPod.Name, &uid); err != nil {
+			klog.ErrorS(err, "Encountered error when deleting mirror pod", "pod", klog.KObj(mirrorPod))
+			continue
+		}
+		this.podManager.DeletePod(mirrorPod)
+	}
+}
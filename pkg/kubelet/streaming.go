@@ -0,0 +1,82 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/container"
+	"net/url"
+)
+
+// GetExec 返回在容器中执行命令的streaming地址
+// pkg/kubelet/kubelet_pods.go
+func (this *Kubelet) GetExec(ctx context.Context, namespace, podName string, podUID types.UID, containerName string,
+	cmd []string, opts container.StreamOptions) (*url.URL, error) {
+	_, cs, err := this.findRunningContainer(ctx, namespace, podName, podUID, containerName)
+	if err != nil {
+		return nil, err
+	}
+	return this.runtime.GetExec(ctx, cs.ID.ID, cmd, opts)
+}
+
+// GetAttach 返回attach到容器的streaming地址，容器没有分配tty时忽略客户端的tty参数
+func (this *Kubelet) GetAttach(ctx context.Context, namespace, podName string, podUID types.UID, containerName string,
+	opts container.StreamOptions) (*url.URL, error) {
+	pod, cs, err := this.findRunningContainer(ctx, namespace, podName, podUID, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if spec := findContainerSpec(pod, containerName); spec != nil {
+		opts.TTY = opts.TTY && spec.TTY
+	}
+	return this.runtime.GetAttach(ctx, cs.ID.ID, opts)
+}
+
+// GetPortForward 返回转发pod端口的streaming地址
+func (this *Kubelet) GetPortForward(ctx context.Context, namespace, podName string, podUID types.UID, ports []int32) (*url.URL, error) {
+	pod, err := this.findPod(namespace, podName, podUID)
+	if err != nil {
+		return nil, err
+	}
+	podStatus, err := this.runtime.GetPodStatus(ctx, pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status of pod %q: %v", podName, err)
+	}
+	for _, sandbox := range podStatus.SandboxStatuses {
+		if sandbox.Ready {
+			return this.runtime.GetPortForward(ctx, sandbox.ID, ports)
+		}
+	}
+	return nil, fmt.Errorf("pod %q has no ready sandbox", podName)
+}
+
+// 查找pod，podUID不为空时需要一致，避免连到同名的新pod
+func (this *Kubelet) findPod(namespace, podName string, podUID types.UID) (*v1.Pod, error) {
+	pod, ok := this.podManager.GetPodByName(namespace, podName)
+	if !ok || (podUID != "" && pod.UID != podUID) {
+		return nil, fmt.Errorf("pod %q not found", podName)
+	}
+	return pod, nil
+}
+
+// 查找pod中正在运行的容器
+func (this *Kubelet) findRunningContainer(ctx context.Context, namespace, podName string, podUID types.UID,
+	containerName string) (*v1.Pod, *container.ContainerStatus, error) {
+	pod, err := this.findPod(namespace, podName, podUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	podStatus, err := this.runtime.GetPodStatus(ctx, pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get status of pod %q: %v", podName, err)
+	}
+	cs := podStatus.FindContainerStatusByName(containerName)
+	if cs == nil {
+		return nil, nil, fmt.Errorf("container %q not found in pod %q", containerName, podName)
+	}
+	if cs.State != container.ContainerStateRunning {
+		return nil, nil, fmt.Errorf("container %q in pod %q is not running", containerName, podName)
+	}
+	return pod, cs, nil
+}
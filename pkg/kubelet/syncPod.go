@@ -0,0 +1,853 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/events"
+	"mykubelet/pkg/podconfig"
+	"mykubelet/pkg/prober"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTerminationGracePeriod int64 = 30
+	// 停止容器时最短的宽限期
+	minimumGracePeriodInSeconds int64 = 2
+)
+
+// 需要kill的容器
+type containerToKillInfo struct {
+	name    string
+	message string
+}
+
+// podActions 一次同步需要执行的操作
+type podActions struct {
+	// 需要重建sandbox时先kill整个pod
+	KillPod       bool
+	CreateSandbox bool
+	SandboxID     string
+	Attempt       uint32
+
+	// pod.Spec.InitContainers的下标，按顺序启动
+	InitContainersToStart []int
+	// pod.Spec.Containers的下标
+	ContainersToStart []int
+	ContainersToKill  map[container.ContainerID]containerToKillInfo
+}
+
+// syncPod 让pod在运行时中的状态向spec收敛
+// pkg/kubelet/kubelet.go syncPod
+func (this *Kubelet) syncPod(pod *v1.Pod) error {
+	ctx := context.Background()
+
+	podStatus, err := this.runtime.GetPodStatus(ctx, pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	apiPodStatus := this.generateAPIPodStatus(pod, podStatus)
+	this.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	// 运行结束的pod不再启动容器，只回收sandbox
+	if apiPodStatus.Phase == v1.PodSucceeded || apiPodStatus.Phase == v1.PodFailed {
+		return this.killPod(ctx, pod, podStatus, nil)
+	}
+
+	// 静态pod在apiServer中创建mirror pod
+	if podconfig.IsStaticPod(pod) {
+		this.syncMirrorPod(pod)
+	}
+
+	// 创建pod的cgroup，之后创建的sandbox和容器都在其中
+	pcm := this.containerManager.NewPodContainerManager()
+	if !pcm.Exists(pod) {
+		if err = this.containerManager.UpdateQOSCgroups(); err != nil {
+			klog.V(2).InfoS("Failed to update QoS cgroups while syncing pod", "pod", klog.KObj(pod), "err", err)
+		}
+		if err = pcm.EnsureExists(pod); err != nil {
+			this.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedToCreatePodContainer, "unable to ensure pod container exists: %v", err)
+			return fmt.Errorf("failed to ensure that the pod: %v cgroups exist and are correctly applied: %v", pod.UID, err)
+		}
+	}
+
+	this.probeManager.AddPod(pod)
+
+	// 卷挂载完成后才能启动容器
+	if err = this.volumeManager.WaitForAttachAndMount(ctx, pod); err != nil {
+		this.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedMountVolume, "Unable to attach or mount volumes: %v", err)
+		klog.ErrorS(err, "Unable to attach or mount volumes for pod; skipping pod", "pod", klog.KObj(pod))
+		return err
+	}
+
+	return this.syncPodContainers(ctx, pod, podStatus)
+}
+
+// syncTerminatingPod 按宽限期停止正在删除的pod，容器全部停止后由状态管理器完成删除
+// ctx被取消时说明宽限期被缩短，podWorkers会用新的宽限期重新调用
+// podStatusFn不为空时修改上报的状态，如被驱逐的pod设置为Failed
+// pkg/kubelet/kubelet.go syncTerminatingPod
+func (this *Kubelet) syncTerminatingPod(ctx context.Context, pod *v1.Pod, gracePeriod int64, podStatusFn func(*v1.PodStatus)) error {
+	klog.V(4).InfoS("syncTerminatingPod enter", "pod", klog.KObj(pod), "podUID", pod.UID, "gracePeriod", gracePeriod)
+	defer klog.V(4).InfoS("syncTerminatingPod exit", "pod", klog.KObj(pod), "podUID", pod.UID)
+
+	podStatus, err := this.runtime.GetPodStatus(ctx, pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	apiPodStatus := this.generateAPIPodStatus(pod, podStatus)
+	if podStatusFn != nil {
+		podStatusFn(&apiPodStatus)
+	}
+	this.statusManager.SetPodStatus(pod, apiPodStatus)
+
+	// 终止过程中不再探测，避免探针失败触发重启
+	this.probeManager.RemovePod(pod)
+
+	if err = this.killPod(ctx, pod, podStatus, &gracePeriod); err != nil {
+		return err
+	}
+
+	// 确认所有容器已经停止
+	podStatus, err = this.runtime.GetPodStatus(ctx, pod.UID, pod.Name, pod.Namespace)
+	if err != nil {
+		return err
+	}
+	if running := podStatus.GetRunningContainerStatuses(); len(running) > 0 {
+		names := []string{}
+		for _, cs := range running {
+			names = append(names, cs.Name)
+		}
+		return fmt.Errorf("detected running containers after a successful KillPod: %v", names)
+	}
+	apiPodStatus = this.generateAPIPodStatus(pod, podStatus)
+	if podStatusFn != nil {
+		podStatusFn(&apiPodStatus)
+	}
+	this.statusManager.SetPodStatus(pod, apiPodStatus)
+	this.statusManager.TerminatePod(pod)
+	this.reasonCache.RemovePod(pod.UID)
+
+	return nil
+}
+
+// 计算需要执行的操作
+// pkg/kubelet/kuberuntime/kuberuntime_manager.go computePodActions
+func (this *Kubelet) computePodActions(pod *v1.Pod, podStatus *container.PodStatus) podActions {
+	createSandbox, attempt, sandboxID := podSandboxChanged(podStatus)
+	changes := podActions{
+		KillPod:           createSandbox,
+		CreateSandbox:     createSandbox,
+		SandboxID:         sandboxID,
+		Attempt:           attempt,
+		ContainersToStart: []int{},
+		ContainersToKill:  make(map[container.ContainerID]containerToKillInfo),
+	}
+
+	if createSandbox {
+		// restartPolicy为Never的pod，sandbox挂掉后不再重建
+		if pod.Spec.RestartPolicy == v1.RestartPolicyNever && attempt != 0 && len(podStatus.ContainerStatuses) != 0 {
+			changes.CreateSandbox = false
+			return changes
+		}
+		// 有初始化容器时从第一个开始按顺序执行
+		if len(pod.Spec.InitContainers) != 0 {
+			changes.InitContainersToStart = []int{0}
+			return changes
+		}
+		for idx, c := range pod.Spec.Containers {
+			if containerSucceeded(&c, podStatus) && pod.Spec.RestartPolicy == v1.RestartPolicyOnFailure {
+				continue
+			}
+			changes.ContainersToStart = append(changes.ContainersToStart, idx)
+		}
+		return changes
+	}
+
+	// 初始化完成之前不启动业务容器
+	if !this.computeInitContainerActions(pod, podStatus, &changes) {
+		return changes
+	}
+
+	keepCount := 0
+	for idx, c := range pod.Spec.Containers {
+		containerStatus := podStatus.FindContainerStatusByName(c.Name)
+
+		if containerStatus == nil || containerStatus.State != container.ContainerStateRunning {
+			if ShouldContainerBeRestarted(&c, pod, podStatus) {
+				klog.V(3).InfoS("Container of pod is not in the desired state and shall be started", "containerName", c.Name, "pod", klog.KObj(pod))
+				changes.ContainersToStart = append(changes.ContainersToStart, idx)
+			}
+			continue
+		}
+
+		// 容器在运行，检查探针结果决定是否重启
+		message, failed := this.probeFailureMessage(&c, containerStatus)
+		if !failed {
+			keepCount++
+			continue
+		}
+		restart := pod.Spec.RestartPolicy != v1.RestartPolicyNever
+		if restart {
+			message = message + ", will be restarted"
+			changes.ContainersToStart = append(changes.ContainersToStart, idx)
+		}
+		changes.ContainersToKill[containerStatus.ID] = containerToKillInfo{
+			name:    containerStatus.Name,
+			message: message,
+		}
+		klog.V(2).InfoS("Message for Container of pod", "containerName", c.Name, "containerStatusID", containerStatus.ID.String(), "pod", klog.KObj(pod), "containerMessage", message)
+	}
+
+	if keepCount == 0 && len(changes.ContainersToStart) == 0 {
+		changes.KillPod = true
+	}
+
+	return changes
+}
+
+// computeInitContainerActions 计算初始化容器的操作，返回true表示初始化已经完成
+// 普通初始化容器按顺序执行，成功后才执行下一个；sidecar按顺序启动，之后一直保持运行
+func (this *Kubelet) computeInitContainerActions(pod *v1.Pod, podStatus *container.PodStatus, changes *podActions) bool {
+	if len(pod.Spec.InitContainers) == 0 {
+		return true
+	}
+
+	// 只看当前sandbox中的容器，sandbox重建后初始化需要重新执行
+	sandboxCreatedAt := podStatus.SandboxStatuses[0].CreatedAt
+	findStatus := func(name string) *container.ContainerStatus {
+		cs := podStatus.FindContainerStatusByName(name)
+		if cs == nil || cs.CreatedAt.Before(sandboxCreatedAt) {
+			return nil
+		}
+		return cs
+	}
+
+	// 业务容器已经启动过，说明初始化已经完成，只需要保证sidecar在运行
+	initialized := false
+	for _, c := range pod.Spec.Containers {
+		if findStatus(c.Name) != nil {
+			initialized = true
+			break
+		}
+	}
+
+	for idx := range pod.Spec.InitContainers {
+		c := &pod.Spec.InitContainers[idx]
+		status := findStatus(c.Name)
+
+		if container.IsRestartableInitContainer(c) {
+			if status == nil || status.State != container.ContainerStateRunning {
+				// sidecar不受pod的restartPolicy限制，退出后总是重启
+				changes.InitContainersToStart = append(changes.InitContainersToStart, idx)
+				if !initialized {
+					return false
+				}
+				continue
+			}
+			if message, failed := this.probeFailureMessage(c, status); failed {
+				changes.ContainersToKill[status.ID] = containerToKillInfo{
+					name:    status.Name,
+					message: message + ", will be restarted",
+				}
+				changes.InitContainersToStart = append(changes.InitContainersToStart, idx)
+				if !initialized {
+					return false
+				}
+				continue
+			}
+			// 配置了启动探针时，等待启动成功后再启动下一个容器
+			if !initialized && c.StartupProbe != nil {
+				if result, found := this.startupManager.Get(status.ID); !found || result != prober.Success {
+					return false
+				}
+			}
+			continue
+		}
+
+		if initialized {
+			continue
+		}
+		if status == nil {
+			changes.InitContainersToStart = append(changes.InitContainersToStart, idx)
+			return false
+		}
+		switch status.State {
+		case container.ContainerStateRunning:
+			// 等待执行结束
+			return false
+		case container.ContainerStateExited:
+			if status.ExitCode == 0 {
+				continue
+			}
+			klog.V(2).InfoS("Init container failed", "pod", klog.KObj(pod), "containerName", c.Name, "exitCode", status.ExitCode)
+			// 初始化失败，restartPolicy为Never时pod直接失败
+			if pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+				changes.KillPod = true
+				return false
+			}
+			changes.InitContainersToStart = append(changes.InitContainersToStart, idx)
+			return false
+		default:
+			changes.InitContainersToStart = append(changes.InitContainersToStart, idx)
+			return false
+		}
+	}
+
+	return true
+}
+
+// 运行中的容器存活探针或启动探针失败时返回原因
+func (this *Kubelet) probeFailureMessage(c *v1.Container, containerStatus *container.ContainerStatus) (string, bool) {
+	if result, found := this.livenessManager.Get(containerStatus.ID); found && result == prober.Failure {
+		return fmt.Sprintf("Container %s failed liveness probe", c.Name), true
+	}
+	if result, found := this.startupManager.Get(containerStatus.ID); found && result == prober.Failure {
+		return fmt.Sprintf("Container %s failed startup probe", c.Name), true
+	}
+	return "", false
+}
+
+// 执行podActions
+func (this *Kubelet) syncPodContainers(ctx context.Context, pod *v1.Pod, podStatus *container.PodStatus) error {
+	changes := this.computePodActions(pod, podStatus)
+	klog.V(3).InfoS("computePodActions got for pod", "podActions", changes, "pod", klog.KObj(pod))
+
+	if changes.KillPod {
+		if err := this.killPod(ctx, pod, podStatus, nil); err != nil {
+			return err
+		}
+	} else {
+		for containerID, info := range changes.ContainersToKill {
+			klog.V(3).InfoS("Killing unwanted container for pod", "containerName", info.name, "containerID", containerID.String(), "pod", klog.KObj(pod))
+			if err := this.killContainer(ctx, pod, containerID, info.name, info.message, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	sandboxID := changes.SandboxID
+	if changes.CreateSandbox {
+		klog.V(4).InfoS("Creating PodSandbox for pod", "pod", klog.KObj(pod))
+		var err error
+		sandboxID, err = this.runtime.RunPodSandbox(ctx, pod, changes.Attempt, &container.RunPodSandboxOptions{
+			CgroupParent: this.containerManager.GetPodCgroupParent(pod),
+		})
+		if err != nil {
+			this.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedCreatePodSandBox, "Failed to create pod sandbox: %v", err)
+			return fmt.Errorf("failed to create sandbox for pod %q: %v", klog.KObj(pod), err)
+		}
+		if err = this.setUpPodNetwork(ctx, pod, sandboxID); err != nil {
+			this.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedCreatePodSandBox, "Failed to set up pod sandbox network: %v", err)
+			// 停止sandbox，下次同步时重新创建
+			if stopErr := this.runtime.StopPodSandbox(ctx, sandboxID); stopErr != nil {
+				klog.ErrorS(stopErr, "Failed to stop sandbox after network set up failure", "pod", klog.KObj(pod), "sandboxID", sandboxID)
+			}
+			return fmt.Errorf("failed to set up sandbox network for pod %q: %v", klog.KObj(pod), err)
+		}
+	} else if sandboxID != "" && !changes.KillPod {
+		// CHECK失败可能只是插件暂时不可用，只记录事件，不因此停止运行中的pod
+		if err := this.checkPodNetwork(ctx, pod, sandboxID); err != nil {
+			klog.ErrorS(err, "Pod sandbox network check failed", "pod", klog.KObj(pod), "sandboxID", sandboxID)
+			this.recorder.Eventf(pod, v1.EventTypeWarning, events.FailedStatusPodSandBox, "Pod sandbox network check failed: %v", err)
+		}
+	}
+	if sandboxID == "" {
+		return nil
+	}
+
+	var pullSecrets []v1.Secret
+	if len(changes.InitContainersToStart) > 0 || len(changes.ContainersToStart) > 0 {
+		pullSecrets = this.getPullSecretsForPod(ctx, pod)
+	}
+	errs := []error{}
+	for _, idx := range changes.InitContainersToStart {
+		c := &pod.Spec.InitContainers[idx]
+		if err := this.startContainer(ctx, sandboxID, pod, c, podStatus, pullSecrets); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, idx := range changes.ContainersToStart {
+		c := &pod.Spec.Containers[idx]
+		if err := this.startContainer(ctx, sandboxID, pod, c, podStatus, pullSecrets); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// 启动容器，重启时受退避限制
+func (this *Kubelet) startContainer(ctx context.Context, sandboxID string, pod *v1.Pod, c *v1.Container, podStatus *container.PodStatus,
+	pullSecrets []v1.Secret) error {
+	restartCount := 0
+	if containerStatus := podStatus.FindContainerStatusByName(c.Name); containerStatus != nil {
+		restartCount = containerStatus.RestartCount + 1
+	}
+
+	if isInBackOff, msg, err := this.doBackOff(pod, c, podStatus); isInBackOff {
+		klog.V(3).InfoS("Backing Off restarting container in pod", "containerName", c.Name, "pod", klog.KObj(pod))
+		this.reasonCache.Add(pod.UID, c.Name, err, msg)
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+
+	if _, msg, err := this.imageManager.EnsureImageExists(ctx, pod, c, pullSecrets); err != nil {
+		this.reasonCache.Add(pod.UID, c.Name, err, msg)
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+
+	mounts, err := makeMounts(pod, c, this.volumeManager.GetMountedVolumesForPod(pod.UID))
+	if err != nil {
+		this.recordContainerEvent(pod, c, v1.EventTypeWarning, events.FailedToCreateContainer, "Error: %v", err)
+		this.reasonCache.Add(pod.UID, c.Name, container.ErrCreateContainerConfig, err.Error())
+		return fmt.Errorf("%v: %v", container.ErrCreateContainerConfig, err)
+	}
+
+	opts := &container.RunContainerOptions{
+		Mounts:       mounts,
+		CgroupParent: this.containerManager.GetPodCgroupParent(pod),
+		Resources:    this.containerManager.GenerateLinuxContainerResources(pod, c),
+	}
+	// 设备插件Allocate返回的环境变量、挂载和设备
+	if deviceOpts := this.containerManager.GetDeviceRunContainerOptions(pod, c); deviceOpts != nil {
+		opts.Envs = append(opts.Envs, deviceOpts.Envs...)
+		opts.Mounts = append(opts.Mounts, deviceOpts.Mounts...)
+		opts.Devices = deviceOpts.Devices
+		opts.Annotations = deviceOpts.Annotations
+	}
+	containerID, err := this.runtime.CreateContainer(ctx, sandboxID, pod, c, restartCount, opts)
+	if err != nil {
+		this.recordContainerEvent(pod, c, v1.EventTypeWarning, events.FailedToCreateContainer, "Error: %v", err)
+		this.reasonCache.Add(pod.UID, c.Name, container.ErrCreateContainer, err.Error())
+		return fmt.Errorf("%v: %v", container.ErrCreateContainer, err)
+	}
+	this.recordContainerEvent(pod, c, v1.EventTypeNormal, events.CreatedContainer, "Created container %s", c.Name)
+
+	if err = this.runtime.StartContainer(ctx, containerID); err != nil {
+		this.recordContainerEvent(pod, c, v1.EventTypeWarning, events.FailedToStartContainer, "Error: %v", err)
+		this.reasonCache.Add(pod.UID, c.Name, container.ErrRunContainer, err.Error())
+		return fmt.Errorf("%v: %v", container.ErrRunContainer, err)
+	}
+	this.recordContainerEvent(pod, c, v1.EventTypeNormal, events.StartedContainer, "Started container %s", c.Name)
+
+	// 执行postStart钩子，失败时kill容器，由重启策略决定是否重启
+	if c.Lifecycle != nil && c.Lifecycle.PostStart != nil {
+		kubeContainerID := container.BuildContainerID(this.runtime.Type(), containerID)
+		msg, handlerErr := this.runner.Run(ctx, kubeContainerID, pod, c, c.Lifecycle.PostStart)
+		if handlerErr != nil {
+			klog.ErrorS(handlerErr, "Failed to execute PostStartHook", "pod", klog.KObj(pod), "podUID", pod.UID,
+				"containerName", c.Name, "containerID", kubeContainerID.String())
+			this.recordContainerEvent(pod, c, v1.EventTypeWarning, events.FailedPostStartHook, "%s", msg)
+			if err = this.killContainer(ctx, pod, kubeContainerID, c.Name, "FailedPostStartHook", nil); err != nil {
+				klog.ErrorS(err, "Failed to kill container", "pod", klog.KObj(pod), "podUID", pod.UID,
+					"containerName", c.Name, "containerID", kubeContainerID.String())
+			}
+			this.reasonCache.Add(pod.UID, c.Name, container.ErrPostStartHook, msg)
+			return fmt.Errorf("%v: %s", container.ErrPostStartHook, msg)
+		}
+	}
+	this.reasonCache.Remove(pod.UID, c.Name)
+	klog.V(2).InfoS("Started container", "containerName", c.Name, "pod", klog.KObj(pod), "restartCount", restartCount)
+
+	return nil
+}
+
+// getPullSecretsForPod pod的imagePullSecrets和service account的imagePullSecrets
+// 获取失败的secret忽略，镜像仍然可以匿名拉取
+// pkg/kubelet/kubelet_pods.go getPullSecretsForPod
+func (this *Kubelet) getPullSecretsForPod(ctx context.Context, pod *v1.Pod) []v1.Secret {
+	names := []string{}
+	seen := map[string]bool{}
+	addName := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		addName(ref.Name)
+	}
+	if saName := pod.Spec.ServiceAccountName; saName != "" {
+		sa, err := this.client.CoreV1().ServiceAccounts(pod.Namespace).Get(ctx, saName, metav1.GetOptions{})
+		if err != nil {
+			klog.V(3).InfoS("Unable to retrieve service account for image pull secrets", "pod", klog.KObj(pod), "serviceAccount", saName, "err", err)
+		} else {
+			for _, ref := range sa.ImagePullSecrets {
+				addName(ref.Name)
+			}
+		}
+	}
+
+	secrets := []v1.Secret{}
+	for _, name := range names {
+		secret, err := this.client.CoreV1().Secrets(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			klog.InfoS("Unable to retrieve pull secret, the image pull may not succeed", "pod", klog.KObj(pod), "secret", name, "err", err)
+			continue
+		}
+		secrets = append(secrets, *secret)
+	}
+	return secrets
+}
+
+// 记录容器相关的事件，pod为空（孤儿pod）时不记录
+func (this *Kubelet) recordContainerEvent(pod *v1.Pod, c *v1.Container, eventType, reason, messageFmt string, args ...interface{}) {
+	if pod == nil || c == nil {
+		return
+	}
+	ref, err := container.GenerateContainerRef(pod, c)
+	if err != nil {
+		klog.ErrorS(err, "Can't make a container ref", "pod", klog.KObj(pod), "podUID", pod.UID, "containerName", c.Name)
+		return
+	}
+	this.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// doBackOff 检查容器是否处于重启退避期
+// 退避时间从10s开始翻倍，最长5分钟；容器上次退避后运行超过10分钟则重置
+func (this *Kubelet) doBackOff(pod *v1.Pod, c *v1.Container, podStatus *container.PodStatus) (bool, string, error) {
+	var cStatus *container.ContainerStatus
+	for _, cs := range podStatus.ContainerStatuses {
+		if cs.Name == c.Name && cs.State == container.ContainerStateExited {
+			cStatus = cs
+			break
+		}
+	}
+	if cStatus == nil {
+		return false, "", nil
+	}
+
+	klog.V(3).InfoS("Checking backoff for container in pod", "containerName", c.Name, "pod", klog.KObj(pod))
+	ts := cStatus.FinishedAt
+	key := getStableKey(pod, c)
+	if this.backOff.IsInBackOffSince(key, ts) {
+		this.recordContainerEvent(pod, c, v1.EventTypeWarning, events.BackOffStartContainer,
+			"Back-off restarting failed container %s in pod %s", c.Name, klog.KObj(pod))
+		msg := fmt.Sprintf("back-off %s restarting failed container=%s pod=%s_%s(%s)",
+			this.backOff.Get(key), c.Name, pod.Name, pod.Namespace, pod.UID)
+		return true, msg, container.ErrCrashLoopBackOff
+	}
+
+	this.backOff.Next(key, ts)
+	return false, "", nil
+}
+
+func getStableKey(pod *v1.Pod, c *v1.Container) string {
+	return fmt.Sprintf("%s_%s_%s_%s_%s", pod.Name, pod.Namespace, pod.UID, c.Name, c.Image)
+}
+
+// ShouldContainerBeRestarted 根据restartPolicy判断已经退出的容器是否需要重启
+func ShouldContainerBeRestarted(c *v1.Container, pod *v1.Pod, podStatus *container.PodStatus) bool {
+	// pod正在删除时不再启动容器
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	status := podStatus.FindContainerStatusByName(c.Name)
+	// 从未启动过
+	if status == nil {
+		return true
+	}
+	if status.State == container.ContainerStateRunning {
+		return false
+	}
+	if status.State == container.ContainerStateUnknown || status.State == container.ContainerStateCreated {
+		return true
+	}
+	if pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+		klog.V(4).InfoS("Already ran container, do nothing", "pod", klog.KObj(pod), "containerName", c.Name)
+		return false
+	}
+	if pod.Spec.RestartPolicy == v1.RestartPolicyOnFailure {
+		if status.ExitCode == 0 {
+			klog.V(4).InfoS("Already successfully ran container, do nothing", "pod", klog.KObj(pod), "containerName", c.Name)
+			return false
+		}
+	}
+	return true
+}
+
+func containerSucceeded(c *v1.Container, podStatus *container.PodStatus) bool {
+	cStatus := podStatus.FindContainerStatusByName(c.Name)
+	if cStatus == nil || cStatus.State == container.ContainerStateRunning {
+		return false
+	}
+	return cStatus.ExitCode == 0
+}
+
+// 判断是否需要新建sandbox，返回(是否新建, attempt, 现有sandboxID)
+func podSandboxChanged(podStatus *container.PodStatus) (bool, uint32, string) {
+	if len(podStatus.SandboxStatuses) == 0 {
+		return true, 0, ""
+	}
+	sandboxStatus := podStatus.SandboxStatuses[0]
+	if !sandboxStatus.Ready {
+		return true, sandboxStatus.Attempt + 1, sandboxStatus.ID
+	}
+	return false, sandboxStatus.Attempt, sandboxStatus.ID
+}
+
+// killContainer 停止容器，gracePeriodOverride不为空时覆盖pod的宽限期
+// 先执行preStop钩子，剩余的宽限期交给运行时，运行时发送SIGTERM，超时后SIGKILL
+// pkg/kubelet/kuberuntime/kuberuntime_container.go killContainer
+func (this *Kubelet) killContainer(ctx context.Context, pod *v1.Pod, containerID container.ContainerID, containerName string,
+	message string, gracePeriodOverride *int64) error {
+	gracePeriod := defaultTerminationGracePeriod
+	if pod != nil {
+		if pod.DeletionGracePeriodSeconds != nil {
+			gracePeriod = *pod.DeletionGracePeriodSeconds
+		} else if pod.Spec.TerminationGracePeriodSeconds != nil {
+			gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+		}
+	}
+	if gracePeriodOverride != nil {
+		gracePeriod = *gracePeriodOverride
+	}
+
+	containerSpec := findContainerSpec(pod, containerName)
+	if containerSpec != nil && containerSpec.Lifecycle != nil && containerSpec.Lifecycle.PreStop != nil && gracePeriod > 0 {
+		gracePeriod = gracePeriod - this.executePreStopHook(ctx, pod, containerID, containerSpec, gracePeriod)
+	}
+	// 至少留给容器处理SIGTERM的时间
+	if gracePeriod < minimumGracePeriodInSeconds {
+		gracePeriod = minimumGracePeriodInSeconds
+	}
+
+	klog.V(2).InfoS("Killing container with a grace period", "pod", klog.KObj(pod), "containerName", containerName,
+		"containerID", containerID.String(), "gracePeriod", gracePeriod, "message", message)
+	this.recordContainerEvent(pod, containerSpec, v1.EventTypeNormal, events.KillingContainer, "%s", message)
+	return this.runtime.StopContainer(ctx, containerID.ID, gracePeriod)
+}
+
+// executePreStopHook 执行preStop钩子，最多等待gracePeriod秒，返回消耗的秒数
+// ctx被取消（宽限期被缩短）时立即返回
+func (this *Kubelet) executePreStopHook(ctx context.Context, pod *v1.Pod, containerID container.ContainerID,
+	containerSpec *v1.Container, gracePeriod int64) int64 {
+	klog.V(3).InfoS("Running preStop hook", "pod", klog.KObj(pod), "containerName", containerSpec.Name, "containerID", containerID.String())
+
+	hookCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := this.clock.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer utilruntime.HandleCrash()
+		if msg, err := this.runner.Run(hookCtx, containerID, pod, containerSpec, containerSpec.Lifecycle.PreStop); err != nil {
+			klog.ErrorS(err, "PreStop hook failed", "pod", klog.KObj(pod), "containerName", containerSpec.Name, "containerID", containerID.String())
+			this.recordContainerEvent(pod, containerSpec, v1.EventTypeWarning, events.FailedPreStopHook, "%s", msg)
+		}
+	}()
+
+	select {
+	case <-done:
+		klog.V(3).InfoS("PreStop hook completed", "pod", klog.KObj(pod), "containerName", containerSpec.Name, "containerID", containerID.String())
+	case <-ctx.Done():
+		klog.V(2).InfoS("PreStop hook interrupted", "pod", klog.KObj(pod), "containerName", containerSpec.Name, "containerID", containerID.String())
+	case <-this.clock.After(time.Duration(gracePeriod) * time.Second):
+		klog.V(2).InfoS("PreStop hook not completed in grace period", "pod", klog.KObj(pod), "containerName", containerSpec.Name,
+			"containerID", containerID.String(), "gracePeriod", gracePeriod)
+	}
+
+	return int64(this.clock.Since(start).Seconds())
+}
+
+// killPod 停止pod所有运行中的容器和sandbox
+// 业务容器并行停止，之后按启动的相反顺序逐个停止sidecar
+func (this *Kubelet) killPod(ctx context.Context, pod *v1.Pod, podStatus *container.PodStatus, gracePeriodOverride *int64) error {
+	appContainers, sidecars := splitRunningContainers(pod, podStatus)
+
+	start := this.clock.Now()
+	if err := this.killContainersInParallel(ctx, pod, appContainers, gracePeriodOverride); err != nil {
+		return err
+	}
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		override := gracePeriodOverride
+		if override != nil {
+			// sidecar使用pod剩余的宽限期
+			remaining := *override - int64(this.clock.Since(start).Seconds())
+			override = &remaining
+		}
+		message := fmt.Sprintf("Stopping container %s", sidecars[i].Name)
+		if err := this.killContainer(ctx, pod, sidecars[i].ID, sidecars[i].Name, message, override); err != nil {
+			return err
+		}
+	}
+
+	errs := []error{}
+	for _, sandbox := range podStatus.SandboxStatuses {
+		// 已经退出的sandbox也要释放网络插件分配的IP
+		if err := this.tearDownPodNetwork(ctx, podStatus, sandbox); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !sandbox.Ready {
+			continue
+		}
+		if err := this.runtime.StopPodSandbox(ctx, sandbox.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (this *Kubelet) killContainersInParallel(ctx context.Context, pod *v1.Pod, statuses []*container.ContainerStatus,
+	gracePeriodOverride *int64) error {
+	errCh := make(chan error, len(statuses))
+	wg := sync.WaitGroup{}
+	for _, cs := range statuses {
+		wg.Add(1)
+		go func(cs *container.ContainerStatus) {
+			defer wg.Done()
+			defer utilruntime.HandleCrash()
+			message := fmt.Sprintf("Stopping container %s", cs.Name)
+			if err := this.killContainer(ctx, pod, cs.ID, cs.Name, message, gracePeriodOverride); err != nil {
+				errCh <- err
+			}
+		}(cs)
+	}
+	wg.Wait()
+	close(errCh)
+
+	errs := []error{}
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// 把运行中的容器分为业务容器和sidecar，sidecar按spec中的顺序排列
+func splitRunningContainers(pod *v1.Pod, podStatus *container.PodStatus) ([]*container.ContainerStatus, []*container.ContainerStatus) {
+	running := podStatus.GetRunningContainerStatuses()
+	if pod == nil {
+		return running, nil
+	}
+	sidecarIndex := map[string]int{}
+	for idx := range pod.Spec.InitContainers {
+		if container.IsRestartableInitContainer(&pod.Spec.InitContainers[idx]) {
+			sidecarIndex[pod.Spec.InitContainers[idx].Name] = idx
+		}
+	}
+
+	appContainers := []*container.ContainerStatus{}
+	sidecars := []*container.ContainerStatus{}
+	for _, cs := range running {
+		if _, ok := sidecarIndex[cs.Name]; ok {
+			sidecars = append(sidecars, cs)
+		} else {
+			appContainers = append(appContainers, cs)
+		}
+	}
+	sort.SliceStable(sidecars, func(i, j int) bool {
+		return sidecarIndex[sidecars[i].Name] < sidecarIndex[sidecars[j].Name]
+	})
+	return appContainers, sidecars
+}
+
+func findContainerSpec(pod *v1.Pod, containerName string) *v1.Container {
+	if pod == nil {
+		return nil
+	}
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == containerName {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == containerName {
+			return &pod.Spec.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+// HandlePodCleanups 清理已经不在本节点的pod
+func (this *Kubelet) HandlePodCleanups() error {
+	ctx := context.Background()
+
+	desiredPods := map[types.UID]bool{}
+	for _, pod := range this.podManager.GetPods() {
+		desiredPods[pod.UID] = true
+	}
+	this.probeManager.CleanupPods(desiredPods)
+	this.statusManager.RemoveOrphanedStatuses(desiredPods)
+
+	runningPods, err := this.runtime.GetPods(ctx)
+	if err != nil {
+		return err
+	}
+	for _, runningPod := range runningPods {
+		// 正在终止的pod由podWorkers负责停止
+		if desiredPods[runningPod.ID] || this.podWorkers.IsPodTerminating(runningPod.ID) {
+			continue
+		}
+		podStatus, err := this.runtime.GetPodStatus(ctx, runningPod.ID, runningPod.Name, runningPod.Namespace)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get status of orphaned pod", "podUID", runningPod.ID)
+			continue
+		}
+		if len(podStatus.GetRunningContainerStatuses()) > 0 || hasReadySandbox(podStatus) {
+			klog.V(3).InfoS("Cleaning up orphaned pod", "pod", klog.KRef(runningPod.Namespace, runningPod.Name), "podUID", runningPod.ID)
+			if err = this.killPod(ctx, nil, podStatus, nil); err != nil {
+				klog.ErrorS(err, "Failed killing orphaned pod", "podUID", runningPod.ID)
+			}
+		}
+		this.podWorkers.ForgetWorker(runningPod.ID)
+		this.reasonCache.RemovePod(runningPod.ID)
+	}
+
+	if this.sourcesReady() {
+		this.cleanupOrphanedPodDirs(desiredPods)
+		this.cleanupOrphanedPodCgroups(desiredPods, runningPods)
+		// 所有来源同步之前，静态pod可能还没有读取到
+		this.deleteOrphanedMirrorPods()
+	}
+
+	this.podWorkers.SyncKnownPods(desiredPods)
+	this.backOff.GC()
+	return nil
+}
+
+func hasReadySandbox(podStatus *container.PodStatus) bool {
+	for _, s := range podStatus.SandboxStatuses {
+		if s.Ready {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupOrphanedPodCgroups 删除已经不在本节点、容器已经全部删除的pod的cgroup
+// pkg/kubelet/kubelet_pods.go cleanupOrphanedPodCgroups
+func (this *Kubelet) cleanupOrphanedPodCgroups(desiredPods map[types.UID]bool, runningPods []*container.Pod) {
+	pcm := this.containerManager.NewPodContainerManager()
+	cgroupPods, err := pcm.GetAllPodsFromCgroups()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get list of pods that still exist on cgroup mounts")
+		return
+	}
+	possiblyRunningPods := make(map[types.UID]bool, len(runningPods))
+	for _, runningPod := range runningPods {
+		possiblyRunningPods[runningPod.ID] = true
+	}
+	for uid, cgroupName := range cgroupPods {
+		if desiredPods[uid] || possiblyRunningPods[uid] || this.podWorkers.IsPodTerminating(uid) {
+			continue
+		}
+		// 卷卸载之前保留cgroup，卸载时可能还需要在cgroup中运行进程
+		if this.volumeManager.PodHasMountedVolumes(uid) {
+			klog.V(3).InfoS("Orphaned pod found, but volumes not yet removed, keeping pod cgroups", "podUID", uid)
+			continue
+		}
+		klog.V(3).InfoS("Orphaned pod found, removing pod cgroups", "podUID", uid)
+		if err = pcm.Destroy(cgroupName); err != nil {
+			klog.ErrorS(err, "Failed to destroy orphaned pod cgroup", "podUID", uid)
+		}
+	}
+}
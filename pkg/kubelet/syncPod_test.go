@@ -0,0 +1,513 @@
+package kubelet
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/prober"
+	"strings"
+	"testing"
+	"time"
+)
+
+func preStopContainer(name string) v1.Container {
+	return v1.Container{
+		Name: name,
+		Lifecycle: &v1.Lifecycle{
+			PreStop: &v1.LifecycleHandler{Exec: &v1.ExecAction{Command: []string{"sleep", "10"}}},
+		},
+	}
+}
+
+func TestKillContainerPreStopConsumesGracePeriod(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime, fakeClock := testKubelet.kubelet, testKubelet.fakeRuntime, testKubelet.fakeClock
+	pod := newTestPod("uid", preStopContainer("app"))
+	fakeRuntime.ExecSyncFn = func(_ context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+		fakeClock.Step(10 * time.Second)
+		return nil, nil
+	}
+
+	gracePeriod := int64(30)
+	if err := kl.killContainer(context.Background(), pod, container.BuildContainerID("fake", "app"), "app", "", &gracePeriod); err != nil {
+		t.Fatal(err)
+	}
+	calls := fakeRuntime.GetStopCalls()
+	if len(calls) != 1 || calls[0].Timeout != 20 {
+		t.Errorf("expected the runtime to get the remaining 20s, got %+v", calls)
+	}
+}
+
+func TestKillContainerPreStopTimeout(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime, fakeClock := testKubelet.kubelet, testKubelet.fakeRuntime, testKubelet.fakeClock
+	pod := newTestPod("uid", preStopContainer("app"))
+	hookDone := make(chan struct{})
+	fakeRuntime.ExecSyncFn = func(ctx context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+		defer close(hookDone)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	errCh := make(chan error, 1)
+	gracePeriod := int64(5)
+	go func() {
+		errCh <- kl.killContainer(context.Background(), pod, container.BuildContainerID("fake", "app"), "app", "", &gracePeriod)
+	}()
+	// 钩子超过宽限期时不再等待，容器至少还有minimumGracePeriodInSeconds处理SIGTERM
+	waitFor(t, "preStop timer", fakeClock.HasWaiters)
+	fakeClock.Step(5 * time.Second)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	<-hookDone
+	calls := fakeRuntime.GetStopCalls()
+	if len(calls) != 1 || calls[0].Timeout != minimumGracePeriodInSeconds {
+		t.Errorf("expected minimum grace period %d, got %+v", minimumGracePeriodInSeconds, calls)
+	}
+}
+
+func TestKillContainerPreStopInterrupted(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime := testKubelet.kubelet, testKubelet.fakeRuntime
+	pod := newTestPod("uid", preStopContainer("app"))
+	hookStarted := make(chan struct{})
+	fakeRuntime.ExecSyncFn = func(ctx context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+		close(hookStarted)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	// 宽限期被缩短时ctx被取消，钩子立即返回
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	gracePeriod := int64(30)
+	go func() {
+		errCh <- kl.killContainer(ctx, pod, container.BuildContainerID("fake", "app"), "app", "", &gracePeriod)
+	}()
+	<-hookStarted
+	cancel()
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("killContainer did not return after cancellation")
+	}
+}
+
+// newExitedPodStatus pod的sandbox就绪，容器已经退出
+func newExitedPodStatus(pod *v1.Pod, name string, exitCode, restartCount int, finishedAt time.Time) *container.PodStatus {
+	podStatus := newRunningPodStatus(pod, finishedAt)
+	cs := podStatus.FindContainerStatusByName(name)
+	cs.State = container.ContainerStateExited
+	cs.ExitCode = exitCode
+	cs.RestartCount = restartCount
+	cs.FinishedAt = finishedAt
+	return podStatus
+}
+
+// 容器每次启动后立即退出，退避时间从10s开始翻倍，最长5分钟
+func TestDoBackOffDoubling(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeClock := testKubelet.kubelet, testKubelet.fakeClock
+	pod := newTestPod("uid", v1.Container{Name: "app", Image: "nginx"})
+	recorder := kl.recorder.(*record.FakeRecorder)
+
+	// 第一次退出后立即重启
+	podStatus := newExitedPodStatus(pod, "app", 1, 0, fakeClock.Now())
+	if inBackOff, _, _ := kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus); inBackOff {
+		t.Fatalf("expected the first restart not to back off")
+	}
+
+	for i, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second,
+		160 * time.Second, 300 * time.Second, 300 * time.Second} {
+		podStatus = newExitedPodStatus(pod, "app", 1, i+1, fakeClock.Now())
+		inBackOff, msg, err := kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus)
+		if !inBackOff || err != container.ErrCrashLoopBackOff {
+			t.Fatalf("restart %d: expected CrashLoopBackOff, got %v %v", i, inBackOff, err)
+		}
+		expectedMsg := fmt.Sprintf("back-off %s restarting failed container=app pod=pod-uid_default(uid)", expected)
+		if msg != expectedMsg {
+			t.Errorf("restart %d: expected message %q, got %q", i, expectedMsg, msg)
+		}
+		if event := <-recorder.Events; !strings.HasPrefix(event, "Warning BackOff Back-off restarting failed container app") {
+			t.Errorf("restart %d: expected back-off event, got %q", i, event)
+		}
+
+		fakeClock.Step(expected - time.Second)
+		if inBackOff, _, _ = kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus); !inBackOff {
+			t.Errorf("restart %d: expected back-off 1s before %s", i, expected)
+		}
+		<-recorder.Events
+		fakeClock.Step(time.Second)
+		if inBackOff, _, _ = kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus); inBackOff {
+			t.Errorf("restart %d: expected back-off to end after %s", i, expected)
+		}
+	}
+}
+
+// 容器上次重启后运行超过10分钟，退避时间重置为10s
+func TestDoBackOffResetAfterRunning(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeClock := testKubelet.kubelet, testKubelet.fakeClock
+	pod := newTestPod("uid", v1.Container{Name: "app", Image: "nginx"})
+	key := getStableKey(pod, &pod.Spec.Containers[0])
+
+	podStatus := newExitedPodStatus(pod, "app", 1, 0, fakeClock.Now())
+	kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus)
+	for i := 0; i < 3; i++ {
+		fakeClock.Step(kl.backOff.Get(key))
+		kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus)
+	}
+	if delay := kl.backOff.Get(key); delay != 80*time.Second {
+		t.Fatalf("expected back-off 80s after 4 crashes, got %s", delay)
+	}
+
+	// 运行正好10分钟时仍然按80s退避，超过10分钟后立即重启，退避时间重置为10s
+	testCases := []struct {
+		name              string
+		running           time.Duration
+		expectedInBackOff bool
+		expected          time.Duration
+	}{
+		{name: "running for 10 minutes", running: 10 * time.Minute, expectedInBackOff: true, expected: 80 * time.Second},
+		{name: "running for more than 10 minutes", running: 10*time.Minute + time.Second, expected: 10 * time.Second},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClock.Step(tc.running)
+			podStatus = newExitedPodStatus(pod, "app", 1, 4, fakeClock.Now())
+			if inBackOff, _, _ := kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus); inBackOff != tc.expectedInBackOff {
+				t.Fatalf("expected in back-off %v, got %v", tc.expectedInBackOff, inBackOff)
+			}
+			if delay := kl.backOff.Get(key); delay != tc.expected {
+				t.Errorf("expected back-off %s, got %s", tc.expected, delay)
+			}
+		})
+	}
+}
+
+// 退避期间不创建容器，CrashLoopBackOff作为容器的waiting reason，重启次数来自运行时
+func TestStartContainerCrashLoopBackOff(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl, fakeRuntime, fakeClock := testKubelet.kubelet, testKubelet.fakeRuntime, testKubelet.fakeClock
+	pod := newTestPod("uid", v1.Container{Name: "app", Image: "nginx"})
+	pod.Spec.RestartPolicy = v1.RestartPolicyAlways
+	podStatus := newExitedPodStatus(pod, "app", 137, 3, fakeClock.Now())
+	kl.doBackOff(pod, &pod.Spec.Containers[0], podStatus)
+
+	fakeClock.Step(time.Second)
+	err := kl.startContainer(context.Background(), "sandbox-uid", pod, &pod.Spec.Containers[0], podStatus, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), container.ErrCrashLoopBackOff.Error()) {
+		t.Fatalf("expected CrashLoopBackOff, got %v", err)
+	}
+	for _, call := range fakeRuntime.CalledFunctions {
+		if call == "CreateContainer" {
+			t.Errorf("expected no container to be created during back-off")
+		}
+	}
+	if reason, ok := kl.reasonCache.Get(pod.UID, "app"); !ok || reason.Err != container.ErrCrashLoopBackOff {
+		t.Fatalf("expected CrashLoopBackOff in the reason cache, got %+v", reason)
+	}
+
+	apiStatus := kl.generateAPIPodStatus(pod, podStatus)
+	cs := apiStatus.ContainerStatuses[0]
+	if cs.State.Waiting == nil || cs.State.Waiting.Reason != "CrashLoopBackOff" ||
+		!strings.HasPrefix(cs.State.Waiting.Message, "back-off 10s restarting failed container=app") {
+		t.Errorf("expected waiting reason CrashLoopBackOff, got %+v", cs.State)
+	}
+	if cs.LastTerminationState.Terminated == nil || cs.LastTerminationState.Terminated.ExitCode != 137 {
+		t.Errorf("expected the exited instance as the last termination state, got %+v", cs.LastTerminationState)
+	}
+	if cs.RestartCount != 3 {
+		t.Errorf("expected restart count 3, got %d", cs.RestartCount)
+	}
+
+	// 启动成功后清除原因
+	kl.reasonCache.Remove(pod.UID, "app")
+	cs = kl.generateAPIPodStatus(pod, podStatus).ContainerStatuses[0]
+	if cs.State.Waiting != nil || cs.State.Terminated == nil {
+		t.Errorf("expected the terminated state without a cached reason, got %+v", cs.State)
+	}
+}
+
+func TestShouldContainerBeRestarted(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name          string
+		restartPolicy v1.RestartPolicy
+		state         container.ContainerState
+		exitCode      int
+		noStatus      bool
+		deleting      bool
+		expected      bool
+	}{
+		{name: "never started", restartPolicy: v1.RestartPolicyNever, noStatus: true, expected: true},
+		{name: "running", restartPolicy: v1.RestartPolicyAlways, state: container.ContainerStateRunning},
+		{name: "created", restartPolicy: v1.RestartPolicyNever, state: container.ContainerStateCreated, expected: true},
+		{name: "unknown", restartPolicy: v1.RestartPolicyNever, state: container.ContainerStateUnknown, expected: true},
+		{name: "Always succeeded", restartPolicy: v1.RestartPolicyAlways, state: container.ContainerStateExited, expected: true},
+		{name: "Always failed", restartPolicy: v1.RestartPolicyAlways, state: container.ContainerStateExited, exitCode: 1, expected: true},
+		{name: "OnFailure succeeded", restartPolicy: v1.RestartPolicyOnFailure, state: container.ContainerStateExited},
+		{name: "OnFailure failed", restartPolicy: v1.RestartPolicyOnFailure, state: container.ContainerStateExited, exitCode: 1, expected: true},
+		{name: "Never succeeded", restartPolicy: v1.RestartPolicyNever, state: container.ContainerStateExited},
+		{name: "Never failed", restartPolicy: v1.RestartPolicyNever, state: container.ContainerStateExited, exitCode: 1},
+		{name: "deleting", restartPolicy: v1.RestartPolicyAlways, state: container.ContainerStateExited, exitCode: 1, deleting: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := newTestPod("uid", v1.Container{Name: "app"})
+			pod.Spec.RestartPolicy = tc.restartPolicy
+			if tc.deleting {
+				pod.DeletionTimestamp = &metav1.Time{Time: now}
+			}
+			podStatus := newRunningPodStatus(pod, now)
+			if tc.noStatus {
+				podStatus.ContainerStatuses = nil
+			} else {
+				podStatus.ContainerStatuses[0].State = tc.state
+				podStatus.ContainerStatuses[0].ExitCode = tc.exitCode
+			}
+			if got := ShouldContainerBeRestarted(&pod.Spec.Containers[0], pod, podStatus); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+
+			// computePodActions按同样的规则重启业务容器
+			if tc.deleting || tc.state == container.ContainerStateRunning {
+				return
+			}
+			changes := newTestKubelet().kubelet.computePodActions(pod, podStatus)
+			if restarted := len(changes.ContainersToStart) == 1; restarted != tc.expected {
+				t.Errorf("expected computePodActions to start the container: %v, got %+v", tc.expected, changes)
+			}
+		})
+	}
+}
+
+func sidecarContainer(name string) v1.Container {
+	restartPolicy := v1.ContainerRestartPolicyAlways
+	return v1.Container{Name: name, RestartPolicy: &restartPolicy}
+}
+
+// runtimeContainerStatus 运行时中的容器，exitCode小于0表示仍在运行
+func runtimeContainerStatus(name string, exitCode int) *container.ContainerStatus {
+	cs := &container.ContainerStatus{
+		ID:    container.BuildContainerID("fake", name),
+		Name:  name,
+		State: container.ContainerStateRunning,
+	}
+	if exitCode >= 0 {
+		cs.State = container.ContainerStateExited
+		cs.ExitCode = exitCode
+	}
+	return cs
+}
+
+// newInitPodStatus pod的sandbox就绪，运行时中只有给出的容器
+func newInitPodStatus(pod *v1.Pod, statuses ...*container.ContainerStatus) *container.PodStatus {
+	podStatus := newRunningPodStatus(pod, time.Now())
+	podStatus.ContainerStatuses = statuses
+	return podStatus
+}
+
+func expectPodActions(t *testing.T, step string, changes podActions, initContainers, containers []int, killPod bool) {
+	t.Helper()
+	if fmt.Sprint(changes.InitContainersToStart) != fmt.Sprint(initContainers) ||
+		fmt.Sprint(changes.ContainersToStart) != fmt.Sprint(containers) || changes.KillPod != killPod {
+		t.Errorf("%s: expected init containers %v, containers %v, kill pod %v, got %+v",
+			step, initContainers, containers, killPod, changes)
+	}
+}
+
+// 初始化容器按顺序执行，前一个成功退出后才启动下一个，全部完成后启动业务容器
+func TestComputeInitContainerActionsSequential(t *testing.T) {
+	kl := newTestKubelet().kubelet
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	pod.Spec.InitContainers = []v1.Container{{Name: "init-1"}, {Name: "init-2"}}
+
+	steps := []struct {
+		name           string
+		statuses       []*container.ContainerStatus
+		initContainers []int
+		containers     []int
+	}{
+		{name: "nothing started", initContainers: []int{0}},
+		{name: "init-1 running", statuses: []*container.ContainerStatus{runtimeContainerStatus("init-1", -1)}},
+		{name: "init-1 succeeded", statuses: []*container.ContainerStatus{runtimeContainerStatus("init-1", 0)}, initContainers: []int{1}},
+		{
+			name:     "init-2 running",
+			statuses: []*container.ContainerStatus{runtimeContainerStatus("init-2", -1), runtimeContainerStatus("init-1", 0)},
+		},
+		{
+			name:       "init-2 succeeded",
+			statuses:   []*container.ContainerStatus{runtimeContainerStatus("init-2", 0), runtimeContainerStatus("init-1", 0)},
+			containers: []int{0},
+		},
+		{
+			name: "app running",
+			statuses: []*container.ContainerStatus{runtimeContainerStatus("app", -1),
+				runtimeContainerStatus("init-2", 0), runtimeContainerStatus("init-1", 0)},
+		},
+	}
+	for _, step := range steps {
+		changes := kl.computePodActions(pod, newInitPodStatus(pod, step.statuses...))
+		expectPodActions(t, step.name, changes, step.initContainers, step.containers, false)
+	}
+}
+
+// 初始化容器失败后按pod的restartPolicy重试，Never时pod直接失败
+func TestComputeInitContainerActionsFailure(t *testing.T) {
+	testCases := []struct {
+		restartPolicy  v1.RestartPolicy
+		initContainers []int
+		killPod        bool
+	}{
+		{restartPolicy: v1.RestartPolicyAlways, initContainers: []int{1}},
+		{restartPolicy: v1.RestartPolicyOnFailure, initContainers: []int{1}},
+		{restartPolicy: v1.RestartPolicyNever, killPod: true},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.restartPolicy), func(t *testing.T) {
+			kl := newTestKubelet().kubelet
+			pod := newTestPod("uid", v1.Container{Name: "app"})
+			pod.Spec.RestartPolicy = tc.restartPolicy
+			pod.Spec.InitContainers = []v1.Container{{Name: "init-1"}, {Name: "init-2"}}
+			podStatus := newInitPodStatus(pod, runtimeContainerStatus("init-2", 1), runtimeContainerStatus("init-1", 0))
+			changes := kl.computePodActions(pod, podStatus)
+			expectPodActions(t, "init-2 failed", changes, tc.initContainers, nil, tc.killPod)
+		})
+	}
+}
+
+// sandbox重建后，旧sandbox中执行过的初始化容器需要重新执行
+func TestComputeInitContainerActionsNewSandbox(t *testing.T) {
+	kl := newTestKubelet().kubelet
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	pod.Spec.InitContainers = []v1.Container{{Name: "init-1"}}
+	now := time.Now()
+	initStatus := runtimeContainerStatus("init-1", 0)
+	initStatus.CreatedAt = now.Add(-time.Minute)
+	podStatus := newInitPodStatus(pod, initStatus)
+	podStatus.SandboxStatuses[0].CreatedAt = now
+
+	changes := kl.computePodActions(pod, podStatus)
+	expectPodActions(t, "new sandbox", changes, []int{0}, nil, false)
+}
+
+// sidecar按顺序启动，配置了启动探针时等待启动成功；之后一直保持运行，退出后不受restartPolicy限制总是重启
+func TestComputeInitContainerActionsSidecars(t *testing.T) {
+	testKubelet := newTestKubelet()
+	kl := testKubelet.kubelet
+	pod := newTestPod("uid", v1.Container{Name: "app"})
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+	pod.Spec.InitContainers = []v1.Container{sidecarContainer("sidecar-1"), {Name: "init-2"}, sidecarContainer("sidecar-3")}
+	pod.Spec.InitContainers[0].StartupProbe = &v1.Probe{
+		ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"true"}}},
+	}
+	running := func(names ...string) []*container.ContainerStatus {
+		statuses := []*container.ContainerStatus{}
+		for _, name := range names {
+			statuses = append(statuses, runtimeContainerStatus(name, -1))
+		}
+		return statuses
+	}
+	initDone := runtimeContainerStatus("init-2", 0)
+
+	steps := []struct {
+		name           string
+		started        bool
+		statuses       []*container.ContainerStatus
+		initContainers []int
+		containers     []int
+	}{
+		{name: "nothing started", initContainers: []int{0}},
+		{name: "sidecar-1 not started yet", statuses: running("sidecar-1")},
+		{name: "sidecar-1 started", started: true, statuses: running("sidecar-1"), initContainers: []int{1}},
+		{name: "init-2 running", started: true, statuses: running("sidecar-1", "init-2")},
+		{name: "init-2 succeeded", started: true, statuses: append(running("sidecar-1"), initDone), initContainers: []int{2}},
+		{
+			name:       "sidecar-3 running",
+			started:    true,
+			statuses:   append(running("sidecar-1", "sidecar-3"), initDone),
+			containers: []int{0},
+		},
+		{name: "app running", started: true, statuses: append(running("sidecar-1", "sidecar-3", "app"), initDone)},
+		{
+			name:           "sidecar-3 exited",
+			started:        true,
+			statuses:       append(running("sidecar-1", "app"), initDone, runtimeContainerStatus("sidecar-3", 0)),
+			initContainers: []int{2},
+		},
+		// 业务容器启动后，sidecar重启不用等待启动探针
+		{
+			name:           "sidecar-1 exited",
+			statuses:       append(running("sidecar-3", "app"), initDone, runtimeContainerStatus("sidecar-1", 1)),
+			initContainers: []int{0},
+		},
+	}
+	for _, step := range steps {
+		id := container.BuildContainerID("fake", "sidecar-1")
+		kl.startupManager.Remove(id)
+		if step.started {
+			kl.startupManager.Set(id, prober.Success, pod)
+		}
+		changes := kl.computePodActions(pod, newInitPodStatus(pod, step.statuses...))
+		expectPodActions(t, step.name, changes, step.initContainers, step.containers, false)
+		if len(changes.ContainersToKill) != 0 {
+			t.Errorf("%s: expected no container to be killed, got %+v", step.name, changes.ContainersToKill)
+		}
+	}
+}
+
+// 普通初始化容器成功退出、sidecar启动后pod才完成初始化，之前业务容器的waiting reason为PodInitializing
+func TestInitializedCondition(t *testing.T) {
+	testCases := []struct {
+		name        string
+		statuses    []*container.ContainerStatus
+		initialized v1.ConditionStatus
+	}{
+		{name: "nothing started", initialized: v1.ConditionFalse},
+		{name: "init running", statuses: []*container.ContainerStatus{runtimeContainerStatus("init-1", -1)}, initialized: v1.ConditionFalse},
+		{name: "init failed", statuses: []*container.ContainerStatus{runtimeContainerStatus("init-1", 1)}, initialized: v1.ConditionFalse},
+		{
+			name:        "sidecar not running",
+			statuses:    []*container.ContainerStatus{runtimeContainerStatus("init-1", 0)},
+			initialized: v1.ConditionFalse,
+		},
+		{
+			name:        "init succeeded and sidecar running",
+			statuses:    []*container.ContainerStatus{runtimeContainerStatus("sidecar", -1), runtimeContainerStatus("init-1", 0)},
+			initialized: v1.ConditionTrue,
+		},
+		// 业务容器启动后sidecar退出不影响Initialized
+		{
+			name: "sidecar exited after app started",
+			statuses: []*container.ContainerStatus{runtimeContainerStatus("app", -1),
+				runtimeContainerStatus("sidecar", 1), runtimeContainerStatus("init-1", 0)},
+			initialized: v1.ConditionTrue,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kl := newTestKubelet().kubelet
+			pod := newTestPod("uid", v1.Container{Name: "app"})
+			pod.Spec.InitContainers = []v1.Container{{Name: "init-1"}, sidecarContainer("sidecar")}
+			apiStatus := kl.generateAPIPodStatus(pod, newInitPodStatus(pod, tc.statuses...))
+			var initialized v1.PodCondition
+			for _, condition := range apiStatus.Conditions {
+				if condition.Type == v1.PodInitialized {
+					initialized = condition
+				}
+			}
+			if initialized.Status != tc.initialized {
+				t.Errorf("expected Initialized %s, got %+v", tc.initialized, initialized)
+			}
+			app := apiStatus.ContainerStatuses[0]
+			if tc.initialized == v1.ConditionFalse && (app.State.Waiting == nil || app.State.Waiting.Reason != "PodInitializing") {
+				t.Errorf("expected app to wait with PodInitializing, got %+v", app.State)
+			}
+		})
+	}
+}
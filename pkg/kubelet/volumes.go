@@ -0,0 +1,198 @@
+package kubelet
+
+import (
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/volume"
+	"mykubelet/pkg/volumemanager"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GetPodsDir pod目录的根目录，卷挂载在 <rootDirectory>/pods/<podUID>/volumes 下
+// pkg/kubelet/kubelet_getters.go getPodsDir
+func (this *Kubelet) GetPodsDir() string {
+	return filepath.Join(this.rootDirectory, "pods")
+}
+
+// GetPluginDir 卷插件的全局目录，csi卷stage到其下
+// pkg/kubelet/kubelet_getters.go getPluginDir
+func (this *Kubelet) GetPluginDir(pluginName string) string {
+	return filepath.Join(this.rootDirectory, "plugins", volume.EscapePluginName(pluginName))
+}
+
+// getPluginsRegistrationDir csi驱动在该目录中创建注册socket
+func (this *Kubelet) getPluginsRegistrationDir() string {
+	return filepath.Join(this.rootDirectory, "plugins_registry")
+}
+
+func (this *Kubelet) GetNodeName() types.NodeName {
+	return types.NodeName(this.nodeName)
+}
+
+// GetKubeClient 卷插件通过它获取configMap和secret
+func (this *Kubelet) GetKubeClient() kubernetes.Interface {
+	return this.client
+}
+
+// GetNodeAllocatable 节点的cpu和内存
+func (this *Kubelet) GetNodeAllocatable() (v1.ResourceList, error) {
+	info, err := this.GetCachedMachineInfo()
+	if err != nil {
+		return nil, err
+	}
+	return v1.ResourceList{
+		v1.ResourceCPU:    *resource.NewQuantity(int64(info.NumCores), resource.DecimalSI),
+		v1.ResourceMemory: *resource.NewQuantity(int64(info.MemoryCapacity), resource.BinarySI),
+	}, nil
+}
+
+// GetServiceAccountToken projected卷中的token，由tokenManager缓存和刷新
+func (this *Kubelet) GetServiceAccountToken(namespace, name string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+	return this.tokenManager.GetServiceAccountToken(namespace, name, tr)
+}
+
+// DeleteServiceAccountToken pod的projected卷卸载后删除token缓存
+func (this *Kubelet) DeleteServiceAccountToken(podUID types.UID) {
+	this.tokenManager.DeleteServiceAccountToken(podUID)
+}
+
+// GetVolumesInUse 已经挂载的需要attach的卷，上报到节点状态
+func (this *Kubelet) GetVolumesInUse() []v1.UniqueVolumeName {
+	return this.volumeManager.GetVolumesInUse()
+}
+
+// GetVolumesAttached 已经挂接到本节点的卷，上报到节点状态
+func (this *Kubelet) GetVolumesAttached() ([]v1.AttachedVolume, error) {
+	return this.csiPlugin.GetVolumesAttached()
+}
+
+// getPodsWithVolumes 需要挂载卷的pod
+// 容器已经全部停止的pod卸载卷：终止完成的pod，以及没有被终止但已经运行结束的pod
+func (this *Kubelet) getPodsWithVolumes() []*v1.Pod {
+	pods := this.GetPods()
+	ret := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if this.podCleanedUp(pod) {
+			continue
+		}
+		terminal := pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+		if terminal && !this.podWorkers.IsPodTerminationRequested(pod.UID) {
+			continue
+		}
+		ret = append(ret, pod)
+	}
+	return ret
+}
+
+// makeMounts 把容器的volumeMounts转换为宿主机上的挂载路径，subPath必须在卷的目录内
+// pkg/kubelet/kubelet_pods.go makeMounts
+func makeMounts(pod *v1.Pod, c *v1.Container, podVolumes map[string]volumemanager.VolumeInfo) ([]container.Mount, error) {
+	mounts := []container.Mount{}
+	for _, mount := range c.VolumeMounts {
+		vol, ok := podVolumes[mount.Name]
+		if !ok || vol.Path == "" {
+			return nil, fmt.Errorf("cannot find volume %q to mount into container %q", mount.Name, c.Name)
+		}
+		if mount.SubPathExpr != "" {
+			return nil, fmt.Errorf("subPathExpr of volume mount %q is not supported", mount.Name)
+		}
+		hostPath := vol.Path
+		if mount.SubPath != "" {
+			subPath, err := makeSubPath(vol.Path, mount.SubPath)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subPath %q of volume mount %q: %v", mount.SubPath, mount.Name, err)
+			}
+			hostPath = subPath
+		}
+		klog.V(5).InfoS("Mount volume into container", "pod", klog.KObj(pod), "containerName", c.Name,
+			"volumeName", mount.Name, "hostPath", hostPath, "containerPath", mount.MountPath)
+		mounts = append(mounts, container.Mount{
+			HostPath:      hostPath,
+			ContainerPath: mount.MountPath,
+			ReadOnly:      mount.ReadOnly || vol.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
+
+// makeSubPath 子路径不存在时创建为目录，解析符号链接后必须仍在卷的目录内
+func makeSubPath(volumePath, subPath string) (string, error) {
+	if filepath.IsAbs(subPath) {
+		return "", fmt.Errorf("must be a relative path")
+	}
+	for _, item := range strings.Split(subPath, string(os.PathSeparator)) {
+		if item == ".." {
+			return "", fmt.Errorf("must not contain '..'")
+		}
+	}
+	hostPath := filepath.Join(volumePath, subPath)
+	if _, err := os.Lstat(hostPath); os.IsNotExist(err) {
+		if err = os.MkdirAll(hostPath, 0750); err != nil {
+			return "", err
+		}
+	}
+	resolvedVolumePath, err := filepath.EvalSymlinks(volumePath)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(hostPath)
+	if err != nil {
+		return "", err
+	}
+	if resolved != resolvedVolumePath && !strings.HasPrefix(resolved, resolvedVolumePath+string(os.PathSeparator)) {
+		return "", fmt.Errorf("resolves to %q outside of the volume", resolved)
+	}
+	return hostPath, nil
+}
+
+// cleanupOrphanedPodDirs 删除已经不在本节点且卷已经全部卸载的pod目录
+// 卷目录中还有内容时说明卸载没有完成，保留目录避免删除挂载的数据
+// pkg/kubelet/kubelet_volumes.go cleanupOrphanedPodDirs
+func (this *Kubelet) cleanupOrphanedPodDirs(desiredPods map[types.UID]bool) {
+	entries, err := os.ReadDir(this.GetPodsDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.ErrorS(err, "Failed to read pods directory", "path", this.GetPodsDir())
+		}
+		return
+	}
+	for _, entry := range entries {
+		uid := types.UID(entry.Name())
+		if !entry.IsDir() || desiredPods[uid] || this.volumeManager.PodHasMountedVolumes(uid) {
+			continue
+		}
+		volumesDir := volume.GetPodVolumesDir(this.GetPodsDir(), uid)
+		if hasVolumeDirs(volumesDir) {
+			klog.V(3).InfoS("Orphaned pod found, but volume paths are still present on disk", "podUID", uid, "path", volumesDir)
+			continue
+		}
+		podDir := filepath.Join(this.GetPodsDir(), entry.Name())
+		klog.V(3).InfoS("Removing orphaned pod directory", "podUID", uid, "path", podDir)
+		if err = os.RemoveAll(podDir); err != nil {
+			klog.ErrorS(err, "Failed to remove orphaned pod directory", "podUID", uid, "path", podDir)
+		}
+	}
+}
+
+// hasVolumeDirs 各插件目录下是否还有卷
+func hasVolumeDirs(volumesDir string) bool {
+	pluginDirs, err := os.ReadDir(volumesDir)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	for _, pluginDir := range pluginDirs {
+		volumeDirs, err := os.ReadDir(filepath.Join(volumesDir, pluginDir.Name()))
+		if err != nil || len(volumeDirs) > 0 {
+			return true
+		}
+	}
+	return false
+}
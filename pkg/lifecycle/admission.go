@@ -0,0 +1,24 @@
+package lifecycle
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// PodAdmitAttributes 需要准入检查的pod，以及节点上其他运行中的pod
+// pkg/kubelet/lifecycle/interfaces.go
+type PodAdmitAttributes struct {
+	Pod       *v1.Pod
+	OtherPods []*v1.Pod
+}
+
+// PodAdmitResult 准入检查的结果，拒绝时reason和message写入pod的状态
+type PodAdmitResult struct {
+	Admit   bool
+	Reason  string
+	Message string
+}
+
+// PodAdmitHandler 判断pod能否在本节点运行
+type PodAdmitHandler interface {
+	Admit(attrs *PodAdmitAttributes) PodAdmitResult
+}
@@ -0,0 +1,142 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxRespBodyLength = 10 * 1 << 10 // 10KB
+
+// PodStatusProvider 提供pod当前的ip
+type PodStatusProvider interface {
+	GetPodStatus(uid types.UID) (v1.PodStatus, bool)
+}
+
+// HandlerRunner 执行容器的postStart/preStop钩子
+// pkg/kubelet/lifecycle/handlers.go
+type HandlerRunner struct {
+	runtime           container.Runtime
+	podStatusProvider PodStatusProvider
+	httpClient        *http.Client
+}
+
+func NewHandlerRunner(runtime container.Runtime, podStatusProvider PodStatusProvider) *HandlerRunner {
+	return &HandlerRunner{
+		runtime:           runtime,
+		podStatusProvider: podStatusProvider,
+		httpClient:        &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+	}
+}
+
+// Run 执行钩子，失败时返回错误和描述信息
+func (this *HandlerRunner) Run(ctx context.Context, containerID container.ContainerID, pod *v1.Pod,
+	c *v1.Container, handler *v1.LifecycleHandler) (string, error) {
+	switch {
+	case handler.Exec != nil:
+		output, err := this.runtime.ExecSync(ctx, containerID.ID, handler.Exec.Command, 0)
+		if err != nil {
+			msg := fmt.Sprintf("Exec lifecycle hook (%v) for Container %q in Pod %q failed - error: %v, message: %q",
+				handler.Exec.Command, c.Name, klog.KObj(pod), err, string(output))
+			klog.V(1).ErrorS(err, "Exec lifecycle hook for Container in Pod failed", "execCommand", handler.Exec.Command,
+				"containerName", c.Name, "pod", klog.KObj(pod), "message", string(output))
+			return msg, err
+		}
+		return "", nil
+
+	case handler.HTTPGet != nil:
+		err := this.runHTTPHandler(ctx, pod, c, handler.HTTPGet)
+		if err != nil {
+			msg := fmt.Sprintf("HTTP lifecycle hook (%s) for Container %q in Pod %q failed - error: %v",
+				handler.HTTPGet.Path, c.Name, klog.KObj(pod), err)
+			klog.V(1).ErrorS(err, "HTTP lifecycle hook for Container in Pod failed", "path", handler.HTTPGet.Path,
+				"containerName", c.Name, "pod", klog.KObj(pod))
+			return msg, err
+		}
+		return "", nil
+
+	default:
+		err := fmt.Errorf("invalid handler: %v", handler)
+		msg := fmt.Sprintf("Cannot run handler: %v", err)
+		klog.ErrorS(err, "Cannot run handler")
+		return msg, err
+	}
+}
+
+func (this *HandlerRunner) runHTTPHandler(ctx context.Context, pod *v1.Pod, c *v1.Container, handler *v1.HTTPGetAction) error {
+	host := handler.Host
+	if host == "" {
+		status, ok := this.podStatusProvider.GetPodStatus(pod.UID)
+		if !ok || status.PodIP == "" {
+			return fmt.Errorf("failed to find networking container")
+		}
+		host = status.PodIP
+	}
+	port, err := resolvePort(handler.Port, c)
+	if err != nil {
+		return err
+	}
+
+	scheme := strings.ToLower(string(handler.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := handler.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return err
+	}
+	u.Scheme = scheme
+	u.Host = net.JoinHostPort(host, strconv.Itoa(port))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range handler.HTTPHeaders {
+		req.Header.Add(h.Name, h.Value)
+	}
+	resp, err := this.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxRespBodyLength))
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("HTTP lifecycle hook (%s) for Container %q in Pod %q failed with statuscode %d",
+			handler.Path, c.Name, klog.KObj(pod), resp.StatusCode)
+	}
+	return nil
+}
+
+// 解析端口，支持容器中定义的端口名
+func resolvePort(portReference intstr.IntOrString, c *v1.Container) (int, error) {
+	if portReference.Type == intstr.Int {
+		return portReference.IntValue(), nil
+	}
+	portName := portReference.StrVal
+	port, aToIErr := strconv.Atoi(portName)
+	if aToIErr == nil {
+		return port, nil
+	}
+	for _, portSpec := range c.Ports {
+		if portSpec.Name == portName {
+			return int(portSpec.ContainerPort), nil
+		}
+	}
+	return -1, fmt.Errorf("couldn't find port: %v in %v", portReference, c)
+}
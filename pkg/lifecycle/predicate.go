@@ -0,0 +1,166 @@
+package lifecycle
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/podconfig"
+	"mykubelet/pkg/qos"
+	"sort"
+)
+
+const (
+	// NodeAffinityReason 节点标签不满足pod的nodeSelector或必须满足的节点亲和性
+	NodeAffinityReason = "NodeAffinity"
+	// TaintReason pod不能容忍节点的NoExecute污点或用户添加的NoSchedule污点
+	TaintReason = "Taint"
+	// UnexpectedAdmissionErrorReason 无法获取节点信息等意外错误
+	UnexpectedAdmissionErrorReason = "UnexpectedAdmissionError"
+	// 资源不足时reason为OutOf加资源名，如OutOfcpu、OutOfmemory、OutOfpods
+	outOfResourceReasonPrefix = "OutOf"
+)
+
+// GetNodeFunc 获取本节点，apiServer中还没有节点时返回根据本地信息构造的节点
+type GetNodeFunc func() (*v1.Node, error)
+
+// predicateAdmitHandler 检查pod的资源请求、节点亲和性和污点容忍
+// 调度器已经做过相同的检查，这里防止调度之后节点发生变化，以及直接指定nodeName的pod
+// pkg/kubelet/lifecycle/predicate.go
+type predicateAdmitHandler struct {
+	getNodeAnyWayFunc GetNodeFunc
+}
+
+func NewPredicateAdmitHandler(getNodeAnyWayFunc GetNodeFunc) PodAdmitHandler {
+	return &predicateAdmitHandler{getNodeAnyWayFunc: getNodeAnyWayFunc}
+}
+
+func (this *predicateAdmitHandler) Admit(attrs *PodAdmitAttributes) PodAdmitResult {
+	node, err := this.getNodeAnyWayFunc()
+	if err != nil {
+		klog.ErrorS(err, "Cannot get Node info")
+		return PodAdmitResult{
+			Admit:   false,
+			Reason:  UnexpectedAdmissionErrorReason,
+			Message: fmt.Sprintf("Kubelet cannot get node info: %v", err),
+		}
+	}
+	pod := attrs.Pod
+	if result := checkResources(pod, attrs.OtherPods, node); !result.Admit {
+		return result
+	}
+	if result := checkNodeAffinity(pod, node); !result.Admit {
+		return result
+	}
+	if result := checkTaints(pod, node); !result.Admit {
+		return result
+	}
+	return PodAdmitResult{Admit: true}
+}
+
+// checkResources pod的资源请求加上其他pod已经请求的资源不能超过节点的可分配资源
+// 节点没有上报的资源（如ephemeral-storage）不检查
+func checkResources(pod *v1.Pod, otherPods []*v1.Pod, node *v1.Node) PodAdmitResult {
+	allocatable := node.Status.Allocatable
+	if allowed, ok := allocatable[v1.ResourcePods]; ok && int64(len(otherPods))+1 > allowed.Value() {
+		return outOfResource(v1.ResourcePods, 1, int64(len(otherPods)), allowed.Value())
+	}
+
+	requests := qos.PodRequests(pod)
+	used := v1.ResourceList{}
+	for _, p := range otherPods {
+		for name, quantity := range qos.PodRequests(p) {
+			existing := used[name]
+			existing.Add(quantity)
+			used[name] = existing
+		}
+	}
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		name := v1.ResourceName(n)
+		request := requests[name]
+		allowed, ok := allocatable[name]
+		if request.IsZero() || !ok {
+			continue
+		}
+		usedQuantity := used[name]
+		if quantityValue(name, request)+quantityValue(name, usedQuantity) > quantityValue(name, allowed) {
+			return outOfResource(name, quantityValue(name, request), quantityValue(name, usedQuantity), quantityValue(name, allowed))
+		}
+	}
+	return PodAdmitResult{Admit: true}
+}
+
+// cpu按毫核比较，其他资源按整数值比较
+func quantityValue(name v1.ResourceName, quantity resource.Quantity) int64 {
+	if name == v1.ResourceCPU {
+		return quantity.MilliValue()
+	}
+	return quantity.Value()
+}
+
+func outOfResource(name v1.ResourceName, requested, used, capacity int64) PodAdmitResult {
+	return PodAdmitResult{
+		Admit:  false,
+		Reason: outOfResourceReasonPrefix + string(name),
+		Message: fmt.Sprintf("Node didn't have enough resource: %s, requested: %d, used: %d, capacity: %d",
+			name, requested, used, capacity),
+	}
+}
+
+// checkNodeAffinity 节点标签需要满足pod的nodeSelector和requiredDuringSchedulingIgnoredDuringExecution
+func checkNodeAffinity(pod *v1.Pod, node *v1.Node) PodAdmitResult {
+	match, err := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node)
+	if err != nil {
+		return PodAdmitResult{
+			Admit:   false,
+			Reason:  UnexpectedAdmissionErrorReason,
+			Message: fmt.Sprintf("Invalid node affinity: %v", err),
+		}
+	}
+	if !match {
+		return PodAdmitResult{
+			Admit:   false,
+			Reason:  NodeAffinityReason,
+			Message: "Node didn't match Pod's node affinity/selector",
+		}
+	}
+	return PodAdmitResult{Admit: true}
+}
+
+// kubelet和节点控制器维护的NoSchedule污点，只用于调度，准入时不检查：
+// 压力由驱逐管理器的准入检查处理（关键pod可以在磁盘压力下运行），
+// cordon的节点仍然可以运行直接指定了nodeName的pod
+var nodeManagedNoScheduleTaints = map[string]bool{
+	v1.TaintNodeMemoryPressure: true,
+	v1.TaintNodeDiskPressure:   true,
+	v1.TaintNodePIDPressure:    true,
+	v1.TaintNodeUnschedulable:  true,
+}
+
+// checkTaints pod需要容忍节点上所有NoExecute污点和用户添加的NoSchedule污点，静态pod由节点自己定义，不检查
+func checkTaints(pod *v1.Pod, node *v1.Node) PodAdmitResult {
+	if podconfig.IsStaticPod(pod) {
+		return PodAdmitResult{Admit: true}
+	}
+	taint, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(t *v1.Taint) bool {
+		if t.Effect == v1.TaintEffectNoSchedule {
+			return !nodeManagedNoScheduleTaints[t.Key]
+		}
+		return t.Effect == v1.TaintEffectNoExecute
+	})
+	if untolerated {
+		return PodAdmitResult{
+			Admit:   false,
+			Reason:  TaintReason,
+			Message: fmt.Sprintf("Node had untolerated taint {%s: %s}", taint.Key, taint.Value),
+		}
+	}
+	return PodAdmitResult{Admit: true}
+}
@@ -0,0 +1,194 @@
+package lifecycle
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/podconfig"
+	"testing"
+)
+
+func newNode(taints ...v1.Taint) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{"zone": "a"}},
+		Spec:       v1.NodeSpec{Taints: taints},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("2"),
+			v1.ResourceMemory: resource.MustParse("4Gi"),
+			v1.ResourcePods:   resource.MustParse("3"),
+		}},
+	}
+}
+
+func newPod(name string, cpu, memory string) *v1.Pod {
+	requests := v1.ResourceList{}
+	if cpu != "" {
+		requests[v1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		requests[v1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:      "app",
+			Resources: v1.ResourceRequirements{Requests: requests},
+		}}},
+	}
+}
+
+func withEphemeralStorage(pod *v1.Pod, request string) *v1.Pod {
+	pod.Spec.Containers[0].Resources.Requests[v1.ResourceEphemeralStorage] = resource.MustParse(request)
+	return pod
+}
+
+func withNodeSelector(pod *v1.Pod, selector map[string]string) *v1.Pod {
+	pod.Spec.NodeSelector = selector
+	return pod
+}
+
+func withTolerations(pod *v1.Pod, tolerations ...v1.Toleration) *v1.Pod {
+	pod.Spec.Tolerations = tolerations
+	return pod
+}
+
+func staticPod(pod *v1.Pod) *v1.Pod {
+	pod.Annotations = map[string]string{podconfig.ConfigSourceAnnotationKey: podconfig.FileSource}
+	return pod
+}
+
+func TestPredicateAdmit(t *testing.T) {
+	userNoSchedule := v1.Taint{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}
+	userNoExecute := v1.Taint{Key: "maintenance", Effect: v1.TaintEffectNoExecute}
+	testCases := []struct {
+		name       string
+		node       *v1.Node
+		pod        *v1.Pod
+		otherPods  []*v1.Pod
+		wantAdmit  bool
+		wantReason string
+	}{
+		{
+			name:      "fits",
+			node:      newNode(),
+			pod:       newPod("pod", "1", "1Gi"),
+			otherPods: []*v1.Pod{newPod("other", "1", "1Gi")},
+			wantAdmit: true,
+		},
+		{
+			name:       "out of cpu",
+			node:       newNode(),
+			pod:        newPod("pod", "1500m", ""),
+			otherPods:  []*v1.Pod{newPod("other", "1", "")},
+			wantReason: "OutOfcpu",
+		},
+		{
+			name:       "out of memory",
+			node:       newNode(),
+			pod:        newPod("pod", "", "3Gi"),
+			otherPods:  []*v1.Pod{newPod("other", "", "2Gi")},
+			wantReason: "OutOfmemory",
+		},
+		{
+			name:       "out of pods",
+			node:       newNode(),
+			pod:        newPod("pod", "", ""),
+			otherPods:  []*v1.Pod{newPod("a", "", ""), newPod("b", "", ""), newPod("c", "", "")},
+			wantReason: "OutOfpods",
+		},
+		{
+			name:      "resource not reported by node",
+			node:      newNode(),
+			pod:       withEphemeralStorage(newPod("pod", "", ""), "10Gi"),
+			wantAdmit: true,
+		},
+		{
+			name:      "node selector matches",
+			node:      newNode(),
+			pod:       withNodeSelector(newPod("pod", "", ""), map[string]string{"zone": "a"}),
+			wantAdmit: true,
+		},
+		{
+			name:       "node selector does not match",
+			node:       newNode(),
+			pod:        withNodeSelector(newPod("pod", "", ""), map[string]string{"zone": "b"}),
+			wantReason: NodeAffinityReason,
+		},
+		{
+			name:       "untolerated user NoSchedule taint",
+			node:       newNode(userNoSchedule),
+			pod:        newPod("pod", "", ""),
+			wantReason: TaintReason,
+		},
+		{
+			name: "tolerated user NoSchedule taint",
+			node: newNode(userNoSchedule),
+			pod: withTolerations(newPod("pod", "", ""),
+				v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule}),
+			wantAdmit: true,
+		},
+		{
+			name:       "untolerated NoExecute taint",
+			node:       newNode(userNoExecute),
+			pod:        newPod("pod", "", ""),
+			wantReason: TaintReason,
+		},
+		{
+			name:      "PreferNoSchedule taint is ignored",
+			node:      newNode(v1.Taint{Key: "dedicated", Effect: v1.TaintEffectPreferNoSchedule}),
+			pod:       newPod("pod", "", ""),
+			wantAdmit: true,
+		},
+		{
+			name: "pressure taints are left to the eviction manager",
+			node: newNode(
+				v1.Taint{Key: v1.TaintNodeMemoryPressure, Effect: v1.TaintEffectNoSchedule},
+				v1.Taint{Key: v1.TaintNodeDiskPressure, Effect: v1.TaintEffectNoSchedule},
+				v1.Taint{Key: v1.TaintNodePIDPressure, Effect: v1.TaintEffectNoSchedule},
+			),
+			pod:       newPod("pod", "1", "1Gi"),
+			wantAdmit: true,
+		},
+		{
+			name:      "cordoned node admits pods bound by nodeName",
+			node:      newNode(v1.Taint{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}),
+			pod:       newPod("pod", "", ""),
+			wantAdmit: true,
+		},
+		{
+			name:       "node managed key with NoExecute effect is checked",
+			node:       newNode(v1.Taint{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoExecute}),
+			pod:        newPod("pod", "", ""),
+			wantReason: TaintReason,
+		},
+		{
+			name:      "static pod ignores taints",
+			node:      newNode(userNoSchedule, userNoExecute),
+			pod:       staticPod(newPod("pod", "", "")),
+			wantAdmit: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewPredicateAdmitHandler(func() (*v1.Node, error) {
+				return tc.node, nil
+			})
+			result := handler.Admit(&PodAdmitAttributes{Pod: tc.pod, OtherPods: tc.otherPods})
+			if result.Admit != tc.wantAdmit || result.Reason != tc.wantReason {
+				t.Errorf("expected admit=%v reason=%q, got %+v", tc.wantAdmit, tc.wantReason, result)
+			}
+		})
+	}
+}
+
+func TestPredicateAdmitNodeError(t *testing.T) {
+	handler := NewPredicateAdmitHandler(func() (*v1.Node, error) {
+		return nil, fmt.Errorf("node not found")
+	})
+	result := handler.Admit(&PodAdmitAttributes{Pod: newPod("pod", "", "")})
+	if result.Admit || result.Reason != UnexpectedAdmissionErrorReason {
+		t.Errorf("expected unexpected admission error, got %+v", result)
+	}
+}
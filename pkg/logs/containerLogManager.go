@@ -0,0 +1,274 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/container"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 检查日志大小的间隔
+	logMonitorPeriod = 10 * time.Second
+	// 轮转后的文件名为 <日志文件>.<时间戳>
+	timestampFormat = "20060102-150405"
+	// 压缩后的文件后缀
+	compressSuffix = ".gz"
+	// 压缩过程中的临时文件后缀
+	tmpSuffix = ".tmp"
+)
+
+// LogRotatePolicy 日志轮转策略
+type LogRotatePolicy struct {
+	// 日志文件超过MaxSize字节时轮转
+	MaxSize int64
+	// 每个容器最多保留的日志文件数，包括正在写入的文件
+	MaxFiles int
+	// 是否用gzip压缩较旧的日志文件
+	Compress bool
+}
+
+// ContainerLogManager 按大小轮转容器的日志文件
+// 轮转后的文件仍为CRI格式，最新一个轮转文件不压缩，便于日志接口读取
+// pkg/kubelet/logs/container_log_manager.go
+type ContainerLogManager struct {
+	runtime container.Runtime
+	policy  LogRotatePolicy
+	clock   clock.Clock
+	mutex   sync.Mutex
+}
+
+// NewContainerLogManager maxSize为resource.Quantity格式，如10Mi
+func NewContainerLogManager(runtime container.Runtime, maxSize string, maxFiles int, compress bool, clock clock.Clock) (*ContainerLogManager, error) {
+	if maxFiles <= 1 {
+		return nil, fmt.Errorf("invalid MaxFiles %d, must be > 1", maxFiles)
+	}
+	parsedMaxSize, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse container log max size %q: %v", maxSize, err)
+	}
+	if parsedMaxSize.Value() <= 0 {
+		return nil, fmt.Errorf("invalid container log max size %q, must be > 0", maxSize)
+	}
+	return &ContainerLogManager{
+		runtime: runtime,
+		policy: LogRotatePolicy{
+			MaxSize:  parsedMaxSize.Value(),
+			MaxFiles: maxFiles,
+			Compress: compress,
+		},
+		clock: clock,
+	}, nil
+}
+
+// Start 定期检查并轮转日志
+func (this *ContainerLogManager) Start() {
+	klog.InfoS("Starting container log manager", "maxSize", this.policy.MaxSize, "maxFiles", this.policy.MaxFiles)
+	go wait.Forever(func() {
+		if err := this.rotateLogs(context.Background()); err != nil {
+			klog.ErrorS(err, "Failed to rotate container logs")
+		}
+	}, logMonitorPeriod)
+}
+
+// 检查所有运行中容器的日志文件
+func (this *ContainerLogManager) rotateLogs(ctx context.Context) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	pods, err := this.runtime.GetPods(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %v", err)
+	}
+	for _, pod := range pods {
+		for _, c := range pod.Containers {
+			// 只有运行中的容器会写日志
+			if c.State != container.ContainerStateRunning {
+				continue
+			}
+			id := c.ID.ID
+			status, err := this.runtime.GetContainerStatus(ctx, id)
+			if err != nil {
+				klog.ErrorS(err, "Failed to get container status", "containerID", id)
+				continue
+			}
+			path := status.LogPath
+			info, err := os.Stat(path)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					klog.ErrorS(err, "Failed to stat container log", "path", path)
+					continue
+				}
+				// 日志文件被删除，让运行时重新创建
+				if err = this.runtime.ReopenContainerLog(ctx, id); err != nil {
+					klog.ErrorS(err, "Container log doesn't exist, reopen container log failed", "containerID", id, "path", path)
+				}
+				continue
+			}
+			if info.Size() < this.policy.MaxSize {
+				continue
+			}
+			if err = this.rotateLog(ctx, id, path); err != nil {
+				klog.ErrorS(err, "Failed to rotate log for container", "path", path, "containerID", id)
+			}
+		}
+	}
+	return nil
+}
+
+// 轮转一个容器的日志：清理无用文件、删除多余文件、压缩旧文件，最后轮转当前文件
+func (this *ContainerLogManager) rotateLog(ctx context.Context, id, log string) error {
+	pattern := fmt.Sprintf("%s.*", log)
+	logs, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("failed to list all log files with pattern %q: %v", pattern, err)
+	}
+
+	logs, err = this.cleanupUnusedLogs(logs)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup logs: %v", err)
+	}
+
+	logs, err = this.removeExcessLogs(logs)
+	if err != nil {
+		return fmt.Errorf("failed to remove excess logs: %v", err)
+	}
+
+	if this.policy.Compress {
+		for _, l := range logs {
+			if strings.HasSuffix(l, compressSuffix) {
+				continue
+			}
+			if err := this.compressLog(l); err != nil {
+				return fmt.Errorf("failed to compress log %q: %v", l, err)
+			}
+		}
+	}
+
+	if err := this.rotateLatestLog(ctx, id, log); err != nil {
+		return fmt.Errorf("failed to rotate log %q: %v", log, err)
+	}
+	return nil
+}
+
+// 删除压缩失败留下的临时文件，以及已经有压缩版本的未压缩文件
+func (this *ContainerLogManager) cleanupUnusedLogs(logs []string) ([]string, error) {
+	inuse, unused := filterUnusedLogs(logs)
+	for _, l := range unused {
+		if err := os.Remove(l); err != nil {
+			return nil, fmt.Errorf("failed to remove unused log %q: %v", l, err)
+		}
+	}
+	return inuse, nil
+}
+
+func filterUnusedLogs(logs []string) (inuse []string, unused []string) {
+	for _, l := range logs {
+		if isInUse(l, logs) {
+			inuse = append(inuse, l)
+		} else {
+			unused = append(unused, l)
+		}
+	}
+	return inuse, unused
+}
+
+func isInUse(l string, logs []string) bool {
+	if strings.HasSuffix(l, tmpSuffix) {
+		return false
+	}
+	if strings.HasSuffix(l, compressSuffix) {
+		return true
+	}
+	for _, another := range logs {
+		if l+compressSuffix == another {
+			return false
+		}
+	}
+	return true
+}
+
+// 按时间从旧到新排序，只保留MaxFiles-2个轮转文件
+// 另外两个是当前文件和即将轮转出来的文件
+func (this *ContainerLogManager) removeExcessLogs(logs []string) ([]string, error) {
+	sort.Strings(logs)
+	maxRotatedFiles := this.policy.MaxFiles - 2
+	if maxRotatedFiles < 0 {
+		maxRotatedFiles = 0
+	}
+	i := 0
+	for ; i < len(logs)-maxRotatedFiles; i++ {
+		if err := os.Remove(logs[i]); err != nil {
+			return nil, fmt.Errorf("failed to remove old log %q: %v", logs[i], err)
+		}
+	}
+	return logs[i:], nil
+}
+
+// 先压缩到临时文件，成功后再重命名并删除原文件
+func (this *ContainerLogManager) compressLog(log string) error {
+	r, err := os.Open(log)
+	if err != nil {
+		return fmt.Errorf("failed to open log %q: %v", log, err)
+	}
+	defer r.Close()
+
+	tmpLog := log + tmpSuffix
+	f, err := os.OpenFile(tmpLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary log %q: %v", tmpLog, err)
+	}
+	defer func() {
+		// 压缩成功后临时文件已经被重命名，这里只清理失败的情况
+		f.Close()
+		os.Remove(tmpLog)
+	}()
+
+	w := gzip.NewWriter(f)
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to compress %q to %q: %v", log, tmpLog, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer for %q: %v", tmpLog, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %v", tmpLog, err)
+	}
+
+	compressedLog := log + compressSuffix
+	if err := os.Rename(tmpLog, compressedLog); err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %v", tmpLog, compressedLog, err)
+	}
+	if err := os.Remove(log); err != nil {
+		return fmt.Errorf("failed to remove log %q after compress: %v", log, err)
+	}
+	return nil
+}
+
+// 重命名当前文件，让运行时重新打开日志文件
+func (this *ContainerLogManager) rotateLatestLog(ctx context.Context, id, log string) error {
+	timestamp := this.clock.Now().Format(timestampFormat)
+	rotated := fmt.Sprintf("%s.%s", log, timestamp)
+	if err := os.Rename(log, rotated); err != nil {
+		return fmt.Errorf("failed to rotate log %q to %q: %v", log, rotated, err)
+	}
+	if err := this.runtime.ReopenContainerLog(ctx, id); err != nil {
+		// 重新打开失败时改回原来的名称，下一轮再试
+		if renameErr := os.Rename(rotated, log); renameErr != nil {
+			klog.ErrorS(renameErr, "Failed to rename rotated log back", "rotatedLog", rotated, "newLog", log, "containerID", id)
+		}
+		return fmt.Errorf("failed to reopen container log %q: %v", id, err)
+	}
+	return nil
+}
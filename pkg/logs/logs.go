@@ -0,0 +1,500 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CRI日志格式：时间戳 stream tag 内容，例如
+// 2016-10-06T00:17:09.669794202Z stdout F log content
+// pkg/kubelet/kuberuntime/logs/logs.go
+const (
+	timeFormatIn  = time.RFC3339Nano
+	timeFormatOut = time.RFC3339Nano
+
+	// tag为P表示一行日志被拆分，F表示一行的最后一部分
+	LogTagPartial = "P"
+	LogTagFull    = "F"
+
+	// 从文件末尾查找tail起始位置时每次读取的大小
+	blockSize = 1024
+	// follow模式下等待新日志的间隔
+	pollInterval = 250 * time.Millisecond
+	// follow模式下检查容器是否仍在运行的间隔
+	stateCheckPeriod = 5 * time.Second
+)
+
+var (
+	delimiter = []byte{' '}
+	eol       = []byte{'\n'}
+
+	// 已经写满limitBytes
+	errMaximumWrite = errors.New("maximum write")
+)
+
+// ContainerRunningFunc 判断容器是否仍在运行，容器退出后follow结束
+type ContainerRunningFunc func(ctx context.Context) (bool, error)
+
+// LogOptions 读取日志的参数，由v1.PodLogOptions转换而来
+type LogOptions struct {
+	// 小于0表示不限制
+	tail  int64
+	bytes int64
+	since time.Time
+
+	follow    bool
+	timestamp bool
+}
+
+// NewLogOptions 转换api的日志参数，now用于计算sinceSeconds
+func NewLogOptions(apiOpts *v1.PodLogOptions, now time.Time) *LogOptions {
+	opts := &LogOptions{
+		tail:      -1,
+		bytes:     -1,
+		follow:    apiOpts.Follow,
+		timestamp: apiOpts.Timestamps,
+	}
+	if apiOpts.TailLines != nil {
+		opts.tail = *apiOpts.TailLines
+	}
+	if apiOpts.LimitBytes != nil {
+		opts.bytes = *apiOpts.LimitBytes
+	}
+	if apiOpts.SinceSeconds != nil {
+		opts.since = now.Add(-time.Duration(*apiOpts.SinceSeconds) * time.Second)
+	}
+	if apiOpts.SinceTime != nil && apiOpts.SinceTime.After(opts.since) {
+		opts.since = apiOpts.SinceTime.Time
+	}
+	return opts
+}
+
+// 解析后的一行日志
+type logMessage struct {
+	timestamp time.Time
+	stream    string
+	log       []byte
+}
+
+func (this *logMessage) reset() {
+	this.timestamp = time.Time{}
+	this.stream = ""
+	this.log = nil
+}
+
+// parseCRILog 解析一行CRI格式的日志
+func parseCRILog(line []byte, msg *logMessage) error {
+	var err error
+	idx := bytes.Index(line, delimiter)
+	if idx < 0 {
+		return fmt.Errorf("timestamp is not found")
+	}
+	msg.timestamp, err = time.Parse(timeFormatIn, string(line[:idx]))
+	if err != nil {
+		return fmt.Errorf("unexpected timestamp format %q: %v", timeFormatIn, err)
+	}
+
+	line = line[idx+1:]
+	idx = bytes.Index(line, delimiter)
+	if idx < 0 {
+		return fmt.Errorf("stream type is not found")
+	}
+	msg.stream = string(line[:idx])
+	if msg.stream != "stdout" && msg.stream != "stderr" {
+		return fmt.Errorf("unexpected stream type %q", msg.stream)
+	}
+
+	line = line[idx+1:]
+	idx = bytes.Index(line, delimiter)
+	if idx < 0 {
+		return fmt.Errorf("log tag is not found")
+	}
+	tag := string(line[:idx])
+	if tag != LogTagPartial && tag != LogTagFull {
+		return fmt.Errorf("unexpected log tag %q", tag)
+	}
+
+	msg.log = line[idx+1:]
+	// 完整的一行需要补上换行符，被拆分的部分原样输出
+	if tag == LogTagFull {
+		msg.log = append(bytes.TrimSuffix(msg.log, eol), '\n')
+	} else {
+		msg.log = bytes.TrimSuffix(msg.log, eol)
+	}
+	return nil
+}
+
+// 把日志按stream写到stdout/stderr，并限制输出的字节数
+type logWriter struct {
+	stdout io.Writer
+	stderr io.Writer
+	opts   *LogOptions
+	remain int64
+}
+
+func newLogWriter(stdout io.Writer, stderr io.Writer, opts *LogOptions) *logWriter {
+	w := &logWriter{
+		stdout: stdout,
+		stderr: stderr,
+		opts:   opts,
+		remain: -1,
+	}
+	if opts.bytes >= 0 {
+		w.remain = opts.bytes
+	}
+	return w
+}
+
+func (this *logWriter) write(msg *logMessage) error {
+	if msg.timestamp.Before(this.opts.since) {
+		return nil
+	}
+	line := msg.log
+	if this.opts.timestamp {
+		prefix := append([]byte(msg.timestamp.Format(timeFormatOut)), delimiter[0])
+		line = append(prefix, line...)
+	}
+	if this.remain >= 0 && int64(len(line)) > this.remain {
+		line = line[:this.remain]
+	}
+
+	var w io.Writer
+	switch msg.stream {
+	case "stdout":
+		w = this.stdout
+	case "stderr":
+		w = this.stderr
+	default:
+		return fmt.Errorf("unexpected stream type %q", msg.stream)
+	}
+	if w == nil {
+		return nil
+	}
+	n, err := w.Write(line)
+	if this.remain >= 0 {
+		this.remain -= int64(n)
+		if this.remain <= 0 {
+			return errMaximumWrite
+		}
+	}
+	return err
+}
+
+// ReadLogs 读取容器的日志文件，先按时间顺序读取轮转出的文件（包括压缩的文件），再读取当前文件
+// follow模式下文件被轮转（路径指向新文件）时切换到新文件，容器退出后结束
+func ReadLogs(ctx context.Context, path string, opts *LogOptions, isRunning ContainerRunningFunc, stdout, stderr io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %q: %v", path, err)
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	start, err := findTailLineStartIndex(f, opts.tail)
+	if err != nil {
+		return fmt.Errorf("failed to get tail %d of log file %q: %v", opts.tail, path, err)
+	}
+	writer := newLogWriter(stdout, stderr, opts)
+
+	// 当前文件的行数不够tail时，从轮转出的文件中补足
+	if start == 0 && opts.tail != 0 {
+		history, skip, err := historyLogs(path, f, opts.tail)
+		if err != nil {
+			return err
+		}
+		for i, log := range history {
+			if i > 0 {
+				skip = 0
+			}
+			if err = readLogFile(ctx, log, skip, writer); err != nil {
+				if err == errMaximumWrite {
+					return nil
+				}
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	if _, err = f.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %d in log file %q: %v", start, path, err)
+	}
+
+	r := bufio.NewReader(f)
+	msg := &logMessage{}
+	// follow模式下读到文件末尾时可能只有半行
+	var pending []byte
+	lastStateCheck := time.Now()
+
+	for {
+		l, err := r.ReadBytes(eol[0])
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read log file %q: %v", path, err)
+		}
+		if err == io.EOF {
+			if !opts.follow {
+				return nil
+			}
+			pending = append(pending, l...)
+
+			// 文件被轮转，读完旧文件后从头读取新文件
+			if newF, rotated := checkRotated(path, f); rotated {
+				klog.V(4).InfoS("Log file was rotated, reopening", "path", path)
+				f.Close()
+				f = newF
+				r.Reset(f)
+				pending = nil
+				continue
+			}
+			if time.Since(lastStateCheck) >= stateCheckPeriod {
+				lastStateCheck = time.Now()
+				running, err := isRunning(ctx)
+				if err != nil {
+					return err
+				}
+				if !running {
+					return nil
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		if len(pending) > 0 {
+			l = append(pending, l...)
+			pending = nil
+		}
+		if err = writeLogLine(path, l, msg, writer); err != nil {
+			if err == errMaximumWrite {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// writeLogLine 解析并输出一行日志，格式错误的行跳过
+func writeLogLine(path string, l []byte, msg *logMessage, writer *logWriter) error {
+	msg.reset()
+	if err := parseCRILog(l, msg); err != nil {
+		klog.ErrorS(err, "Failed to parse log line", "path", path, "line", string(l))
+		return nil
+	}
+	return writer.write(msg)
+}
+
+// historyLogs 返回需要在当前文件之前读取的轮转文件，按时间从旧到新排列，以及第一个文件需要跳过的行数
+// tail小于0时返回所有轮转文件，current是已经打开的当前文件，轮转后可能出现在列表中
+func historyLogs(path string, current *os.File, tail int64) ([]string, int64, error) {
+	logs, err := rotatedLogs(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info, err := current.Stat(); err == nil && len(logs) > 0 {
+		if latest, err := os.Stat(logs[len(logs)-1]); err == nil && os.SameFile(info, latest) {
+			logs = logs[:len(logs)-1]
+		}
+	}
+	if tail < 0 || len(logs) == 0 {
+		return logs, 0, nil
+	}
+
+	remaining, err := countLines(current)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count lines of log file %q: %v", path, err)
+	}
+	remaining = tail - remaining
+	i := len(logs)
+	for remaining > 0 && i > 0 {
+		i--
+		lines, err := countLogFileLines(logs[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		if lines >= remaining {
+			return logs[i:], lines - remaining, nil
+		}
+		remaining -= lines
+	}
+	return logs[i:], 0, nil
+}
+
+// rotatedLogs 列出ContainerLogManager轮转出的文件，文件名中的时间戳保证按名称排序即按时间排序
+// 跳过压缩过程中的临时文件，以及已经有压缩版本的未压缩文件
+func rotatedLogs(path string) ([]string, error) {
+	pattern := fmt.Sprintf("%s.*", path)
+	logs, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rotated log files with pattern %q: %v", pattern, err)
+	}
+	inuse, _ := filterUnusedLogs(logs)
+	sort.Strings(inuse)
+	return inuse, nil
+}
+
+// openLogFile 压缩的文件返回解压后的内容，读取前被压缩的文件改为读取压缩版本
+func openLogFile(log string) (io.ReadCloser, error) {
+	f, err := os.Open(log)
+	if os.IsNotExist(err) && !strings.HasSuffix(log, compressSuffix) {
+		log += compressSuffix
+		f, err = os.Open(log)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %q: %v", log, err)
+	}
+	if !strings.HasSuffix(log, compressSuffix) {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read compressed log file %q: %v", log, err)
+	}
+	return &gzipLogFile{Reader: gz, file: f}, nil
+}
+
+type gzipLogFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (this *gzipLogFile) Close() error {
+	this.Reader.Close()
+	return this.file.Close()
+}
+
+func countLogFileLines(log string) (int64, error) {
+	r, err := openLogFile(log)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	lines, err := countLines(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count lines of log file %q: %v", log, err)
+	}
+	return lines, nil
+}
+
+// countLines 统计换行符的个数，和findTailLineStartIndex的计数方式一致
+func countLines(r io.Reader) (int64, error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	var cnt int64
+	buf := make([]byte, 32*blockSize)
+	for {
+		n, err := r.Read(buf)
+		cnt += int64(bytes.Count(buf[:n], eol))
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readLogFile 跳过前skip行后输出一个轮转文件的全部日志
+func readLogFile(ctx context.Context, log string, skip int64, writer *logWriter) error {
+	f, err := openLogFile(log)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	msg := &logMessage{}
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		l, err := r.ReadBytes(eol[0])
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read log file %q: %v", log, err)
+		}
+		if len(l) > 0 {
+			if skip > 0 {
+				skip--
+			} else if writeErr := writeLogLine(log, l, msg, writer); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// 路径指向的文件和正在读的文件不同，说明发生了轮转
+func checkRotated(path string, f *os.File) (*os.File, bool) {
+	current, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+	latest, err := os.Stat(path)
+	if err != nil || os.SameFile(current, latest) {
+		return nil, false
+	}
+	newF, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	return newF, true
+}
+
+// findTailLineStartIndex 从文件末尾向前查找最后n行的起始位置，n小于0时返回0
+// pkg/util/tail/tail.go
+func findTailLineStartIndex(f io.ReadSeeker, n int64) (int64, error) {
+	if n < 0 {
+		return 0, nil
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	var left, cnt int64
+	buf := make([]byte, blockSize)
+	for right := size; right > 0 && cnt <= n; right -= blockSize {
+		left = right - blockSize
+		if left <= 0 {
+			left = 0
+			buf = make([]byte, right)
+		}
+		if _, err := f.Seek(left, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return 0, err
+		}
+		cnt += int64(bytes.Count(buf, eol))
+	}
+	for ; cnt > n; cnt-- {
+		idx := bytes.Index(buf, eol) + 1
+		buf = buf[idx:]
+		left += int64(idx)
+	}
+	return left, nil
+}
@@ -0,0 +1,108 @@
+package logs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLogStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeTestLog 写入CRI格式的日志，第i行的时间戳为testLogStart之后i秒
+func writeTestLog(t *testing.T, path string, from, to int, compress bool) {
+	buf := &bytes.Buffer{}
+	for i := from; i < to; i++ {
+		ts := testLogStart.Add(time.Duration(i) * time.Second).Format(timeFormatIn)
+		fmt.Fprintf(buf, "%s stdout F line %d\n", ts, i)
+	}
+	data := buf.Bytes()
+	if compress {
+		compressed := &bytes.Buffer{}
+		w := gzip.NewWriter(compressed)
+		_, _ = w.Write(data)
+		_ = w.Close()
+		data = compressed.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectedLines(from, to int) string {
+	lines := []string{}
+	for i := from; i < to; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	return strings.Join(lines, "")
+}
+
+// newRotatedLogs 按ContainerLogManager的命名生成两个压缩文件、一个未压缩文件和当前文件，每个文件10行
+func newRotatedLogs(t *testing.T) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "0.log")
+	writeTestLog(t, path+".20240101-000010.gz", 0, 10, true)
+	writeTestLog(t, path+".20240101-000020.gz", 10, 20, true)
+	writeTestLog(t, path+".20240101-000030", 20, 30, false)
+	writeTestLog(t, path, 30, 40, false)
+	// 压缩失败留下的临时文件和已经压缩过的原文件不应该被读取
+	writeTestLog(t, path+".20240101-000020.tmp", 100, 110, false)
+	writeTestLog(t, path+".20240101-000010", 100, 110, false)
+	return path
+}
+
+func readTestLogs(t *testing.T, path string, apiOpts *v1.PodLogOptions) string {
+	stdout := &bytes.Buffer{}
+	opts := NewLogOptions(apiOpts, testLogStart.Add(40*time.Second))
+	err := ReadLogs(context.Background(), path, opts, func(context.Context) (bool, error) { return false, nil }, stdout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String()
+}
+
+func TestReadLogsAcrossRotatedFiles(t *testing.T) {
+	path := newRotatedLogs(t)
+	tail := func(n int64) *int64 { return &n }
+	limit := func(n int64) *int64 { return &n }
+	since := metav1.NewTime(testLogStart.Add(15 * time.Second))
+
+	tests := []struct {
+		name     string
+		opts     *v1.PodLogOptions
+		expected string
+	}{
+		{name: "all", opts: &v1.PodLogOptions{}, expected: expectedLines(0, 40)},
+		{name: "tail within current file", opts: &v1.PodLogOptions{TailLines: tail(5)}, expected: expectedLines(35, 40)},
+		{name: "tail equals current file", opts: &v1.PodLogOptions{TailLines: tail(10)}, expected: expectedLines(30, 40)},
+		{name: "tail into rotated file", opts: &v1.PodLogOptions{TailLines: tail(15)}, expected: expectedLines(25, 40)},
+		{name: "tail into compressed file", opts: &v1.PodLogOptions{TailLines: tail(25)}, expected: expectedLines(15, 40)},
+		{name: "tail beyond all files", opts: &v1.PodLogOptions{TailLines: tail(100)}, expected: expectedLines(0, 40)},
+		{name: "tail zero", opts: &v1.PodLogOptions{TailLines: tail(0)}, expected: ""},
+		{name: "since time", opts: &v1.PodLogOptions{SinceTime: &since}, expected: expectedLines(15, 40)},
+		{name: "limit bytes", opts: &v1.PodLogOptions{LimitBytes: limit(14)}, expected: "line 0\nline 1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readTestLogs(t, path, test.opts); got != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestReadLogsWithoutRotatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "0.log")
+	writeTestLog(t, path, 0, 3, false)
+	tail := int64(5)
+	if got := readTestLogs(t, path, &v1.PodLogOptions{TailLines: &tail}); got != expectedLines(0, 3) {
+		t.Errorf("unexpected logs:\n%s", got)
+	}
+}
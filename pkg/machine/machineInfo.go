@@ -0,0 +1,74 @@
+package machine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MachineInfo 节点的硬件信息，字段与cadvisor保持一致
+// github.com/google/cadvisor/info/v1/machine.go
+type MachineInfo struct {
+	Timestamp      time.Time `json:"timestamp"`
+	NumCores       int       `json:"num_cores"`
+	MemoryCapacity uint64    `json:"memory_capacity"`
+	MachineID      string    `json:"machine_id"`
+	SystemUUID     string    `json:"system_uuid"`
+	BootID         string    `json:"boot_id"`
+}
+
+// GetMachineInfo 从rootfs下的/proc、/sys、/etc读取节点信息，rootfs一般为/
+func GetMachineInfo(rootfs string) (*MachineInfo, error) {
+	memoryCapacity, err := getMemoryCapacity(filepath.Join(rootfs, "proc/meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	return &MachineInfo{
+		Timestamp:      time.Now(),
+		NumCores:       runtime.NumCPU(),
+		MemoryCapacity: memoryCapacity,
+		MachineID:      readFirstLine(filepath.Join(rootfs, "etc/machine-id"), filepath.Join(rootfs, "var/lib/dbus/machine-id")),
+		SystemUUID:     readFirstLine(filepath.Join(rootfs, "sys/class/dmi/id/product_uuid")),
+		BootID:         readFirstLine(filepath.Join(rootfs, "proc/sys/kernel/random/boot_id")),
+	}, nil
+}
+
+// 读取/proc/meminfo中的MemTotal，单位转换为字节
+func getMemoryCapacity(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse MemTotal %q: %v", fields[1], err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("MemTotal not found in %s", path)
+}
+
+// 依次读取文件，返回第一个存在的文件内容
+func readFirstLine(paths ...string) string {
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		return strings.TrimSpace(string(b))
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"k8s.io/klog/v2"
+	"sync"
+	"time"
+)
+
+// kubelet自身的指标，通过/metrics输出
+// pkg/kubelet/metrics/metrics.go
+const (
+	KubeletSubsystem = "kubelet"
+	ProberSubsystem  = "prober"
+
+	// bootstrap的结果
+	BootstrapResultSuccess = "success"
+	BootstrapResultFailure = "failure"
+	// 已经有kubeconfig，跳过bootstrap
+	BootstrapResultSkipped = "skipped"
+
+	// pod worker的操作类型
+	OperationSync      = "sync"
+	OperationTerminate = "terminate"
+
+	// 证书类型
+	CertificateTypeClient  = "client"
+	CertificateTypeServing = "serving"
+)
+
+var (
+	// Registry kubelet指标的registry，和/metrics/resource的分开
+	Registry = prometheus.NewRegistry()
+
+	BootstrapAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "bootstrap_attempts_total",
+			Help:      "Number of TLS bootstrap attempts by result.",
+		},
+		[]string{"result"},
+	)
+	CSRApprovalWaitDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "csr_approval_wait_duration_seconds",
+			Help:      "Duration in seconds waiting for the bootstrap CSR to be approved and issued.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+		},
+	)
+	LeaseRenewDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "lease_renew_duration_seconds",
+			Help:      "Latency in seconds of node lease renew requests.",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	LeaseRenewErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "lease_renew_errors_total",
+			Help:      "Number of failed node lease renew requests.",
+		},
+	)
+	NodeStatusPatchDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "node_status_patch_duration_seconds",
+			Help:      "Latency in seconds of node status patch requests.",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+	NodeStatusPatchErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "node_status_patch_errors_total",
+			Help:      "Number of failed node status patch requests.",
+		},
+	)
+	PodWorkerQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "pod_worker_queue_depth",
+			Help:      "Number of pods waiting for the pod workers, including pending updates and scheduled resyncs.",
+		},
+	)
+	PodWorkerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: KubeletSubsystem,
+			Name:      "pod_worker_duration_seconds",
+			Help:      "Duration in seconds to sync a single pod, broken down by operation type.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"operation_type"},
+	)
+	ProberResults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: ProberSubsystem,
+			Name:      "probe_total",
+			Help:      "Cumulative number of a liveness, readiness or startup probe for a container by result.",
+		},
+		[]string{"probe_type", "result", "container", "pod", "namespace", "pod_uid"},
+	)
+)
+
+var registerOnce sync.Once
+
+// Register 注册所有指标，以及go运行时和进程的指标
+func Register() {
+	registerOnce.Do(func() {
+		Registry.MustRegister(collectors.NewGoCollector())
+		Registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		Registry.MustRegister(BootstrapAttempts)
+		Registry.MustRegister(CSRApprovalWaitDuration)
+		Registry.MustRegister(LeaseRenewDuration)
+		Registry.MustRegister(LeaseRenewErrors)
+		Registry.MustRegister(NodeStatusPatchDuration)
+		Registry.MustRegister(NodeStatusPatchErrors)
+		Registry.MustRegister(PodWorkerQueueDepth)
+		Registry.MustRegister(PodWorkerDuration)
+		Registry.MustRegister(ProberResults)
+	})
+}
+
+// RegisterCertificateExpiry 注册证书的剩余有效时间，每次采集时调用notAfter获取当前证书的过期时间
+func RegisterCertificateExpiry(certType string, notAfter func() time.Time) {
+	gauge := prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Subsystem:   KubeletSubsystem,
+			Name:        "certificate_expiry_seconds",
+			Help:        "Seconds until the kubelet certificate expires, negative once expired.",
+			ConstLabels: prometheus.Labels{"type": certType},
+		},
+		func() float64 {
+			t := notAfter()
+			if t.IsZero() {
+				return 0
+			}
+			return time.Until(t).Seconds()
+		},
+	)
+	if err := Registry.Register(gauge); err != nil {
+		klog.ErrorS(err, "Failed to register certificate expiry metric", "type", certType)
+	}
+}
+
+// SinceInSeconds 从start到现在经过的秒数
+func SinceInSeconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
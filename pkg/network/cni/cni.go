@@ -0,0 +1,307 @@
+package cni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/network"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultConfDir 网络配置的默认目录
+	DefaultConfDir = "/etc/cni/net.d"
+	// DefaultBinDir 插件可执行文件的默认目录
+	DefaultBinDir = "/opt/cni/bin"
+	// DefaultCacheDir 缓存ADD结果的默认目录
+	DefaultCacheDir = "/var/lib/cni/cache"
+
+	// pod网络的网卡名
+	defaultIfName = "eth0"
+	// 单次调用插件的超时时间
+	pluginTimeout = 3 * time.Minute
+)
+
+// Plugin 从confDir加载网络配置，为每个sandbox调用CNI插件，ipRanges使用节点的podCIDR
+// pkg/kubelet/dockershim/network/cni/cni.go
+type Plugin struct {
+	confDir  string
+	binDirs  []string
+	cacheDir string
+
+	lock sync.RWMutex
+	// confDir中第一个有效的网络，为空时插件没有就绪
+	network  *networkConfigList
+	podCIDRs []string
+	// sandbox id -> IP，没有命中时从缓存文件中读取
+	podIPs map[string][]string
+}
+
+var _ network.NetworkPlugin = &Plugin{}
+
+// NewPlugin binDirs按顺序查找插件，配置目录可以晚于kubelet启动时出现
+func NewPlugin(confDir string, binDirs []string, cacheDir string) *Plugin {
+	plugin := &Plugin{
+		confDir:  confDir,
+		binDirs:  binDirs,
+		cacheDir: cacheDir,
+		podIPs:   map[string][]string{},
+	}
+	plugin.syncNetworkConfig()
+	return plugin
+}
+
+func (this *Plugin) Name() string {
+	return network.CNIPluginName
+}
+
+// syncNetworkConfig 重新加载网络配置，配置文件可能在运行中被网络组件安装或修改
+func (this *Plugin) syncNetworkConfig() {
+	list, err := loadDefaultNetwork(this.confDir)
+	if err != nil {
+		klog.InfoS("Unable to update cni config", "err", err)
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.network == nil || this.network.Name != list.Name || string(this.network.Bytes) != string(list.Bytes) {
+		klog.InfoS("Loaded CNI network config", "networkName", list.Name, "cniVersion", list.CNIVersion)
+	}
+	this.network = list
+}
+
+func (this *Plugin) getNetwork() *networkConfigList {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.network
+}
+
+// UpdatePodCIDRs 节点分配到podCIDR后才能为声明了ipRanges的插件分配IP
+func (this *Plugin) UpdatePodCIDRs(podCIDRs []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if strings.Join(this.podCIDRs, ",") == strings.Join(podCIDRs, ",") {
+		return
+	}
+	klog.InfoS("Updating pod CIDRs for network plugin", "podCIDRs", podCIDRs)
+	this.podCIDRs = append([]string{}, podCIDRs...)
+}
+
+// Status 没有网络配置，或者网络需要podCIDR但节点还没有分配时没有就绪
+func (this *Plugin) Status() error {
+	this.syncNetworkConfig()
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.network == nil {
+		return fmt.Errorf("cni config uninitialized")
+	}
+	if this.network.hasCapability("ipRanges") && len(this.podCIDRs) == 0 {
+		return fmt.Errorf("no podCIDR assigned to node for network %s", this.network.Name)
+	}
+	return nil
+}
+
+// capabilityArgs ipRanges中每个podCIDR是一组地址段，支持双栈
+func (this *Plugin) capabilityArgs(portMappings []network.PortMapping) map[string]interface{} {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	args := map[string]interface{}{}
+	if len(portMappings) > 0 {
+		args["portMappings"] = portMappings
+	}
+	if len(this.podCIDRs) > 0 {
+		ranges := [][]map[string]string{}
+		for _, cidr := range this.podCIDRs {
+			ranges = append(ranges, []map[string]string{{"subnet": cidr}})
+		}
+		args["ipRanges"] = ranges
+	}
+	return args
+}
+
+func podArgs(namespace, name, sandboxID string) [][2]string {
+	return [][2]string{
+		{"IgnoreUnknown", "1"},
+		{"K8S_POD_NAMESPACE", namespace},
+		{"K8S_POD_NAME", name},
+		{"K8S_POD_INFRA_CONTAINER_ID", sandboxID},
+	}
+}
+
+// SetUpPod 按顺序调用链中的插件执行ADD，每个插件的结果作为下一个插件的prevResult，最终结果写入缓存。
+// 执行ADD前先缓存配置和参数，中途失败或者kubelet重启时TearDownPod仍然可以对整条链执行DEL
+func (this *Plugin) SetUpPod(namespace, name, sandboxID, netnsPath string, portMappings []network.PortMapping) (*network.PodNetworkStatus, error) {
+	if err := this.Status(); err != nil {
+		return nil, err
+	}
+	list := this.getNetwork()
+	rt := &runtimeConf{
+		ContainerID:    sandboxID,
+		NetNS:          netnsPath,
+		IfName:         defaultIfName,
+		Args:           podArgs(namespace, name, sandboxID),
+		CapabilityArgs: this.capabilityArgs(portMappings),
+	}
+	cached := &cachedResult{
+		ContainerID:    sandboxID,
+		IfName:         defaultIfName,
+		NetworkName:    list.Name,
+		Config:         list.Bytes,
+		CNIArgs:        rt.Args,
+		CapabilityArgs: rt.CapabilityArgs,
+	}
+	if err := writeCachedResult(this.cacheDir, cached); err != nil {
+		return nil, fmt.Errorf("failed to write CNI cache for sandbox %s: %v", sandboxID, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	var result json.RawMessage
+	for _, plugin := range list.Plugins {
+		out, err := this.invoke(ctx, list, plugin, cmdAdd, rt, result)
+		if err != nil {
+			return nil, err
+		}
+		// 插件没有输出时沿用上一个插件的结果
+		if len(out) > 0 {
+			result = out
+		}
+	}
+	ips, err := resultIPs(result)
+	if err != nil {
+		return nil, err
+	}
+	cached.Result = result
+	if err = writeCachedResult(this.cacheDir, cached); err != nil {
+		klog.ErrorS(err, "Failed to write CNI result cache", "sandboxID", sandboxID)
+	}
+	this.lock.Lock()
+	this.podIPs[sandboxID] = ips
+	this.lock.Unlock()
+	klog.V(3).InfoS("Set up pod network", "pod", klog.KRef(namespace, name), "sandboxID", sandboxID, "ips", ips)
+	return &network.PodNetworkStatus{IPs: ips}, nil
+}
+
+// TearDownPod 使用ADD时缓存的配置和参数，按相反顺序对整条链执行DEL，ADD中途失败时没有执行过ADD的插件也会收到DEL，
+// 没有缓存说明没有配置过网络
+func (this *Plugin) TearDownPod(namespace, name, sandboxID, netnsPath string) error {
+	cached, err := readCachedResult(this.cacheDir, sandboxID, defaultIfName)
+	if err != nil {
+		return err
+	}
+	if cached == nil {
+		return nil
+	}
+	list, err := confListFromBytes(cached.Config)
+	if err != nil {
+		return err
+	}
+	rt := &runtimeConf{
+		ContainerID:    sandboxID,
+		NetNS:          netnsPath,
+		IfName:         cached.IfName,
+		Args:           cached.CNIArgs,
+		CapabilityArgs: cached.CapabilityArgs,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	// 0.4.0之前DEL不传prevResult，ADD没有完成时没有结果
+	var prevResult json.RawMessage
+	if compareVersion(list.CNIVersion, "0.4.0") >= 0 {
+		prevResult = cached.Result
+	}
+	for i := len(list.Plugins) - 1; i >= 0; i-- {
+		if _, err = this.invoke(ctx, list, list.Plugins[i], cmdDel, rt, prevResult); err != nil {
+			return err
+		}
+	}
+	if err = deleteCachedResult(this.cacheDir, sandboxID, defaultIfName); err != nil {
+		klog.ErrorS(err, "Failed to delete CNI result cache", "sandboxID", sandboxID)
+	}
+	this.lock.Lock()
+	delete(this.podIPs, sandboxID)
+	this.lock.Unlock()
+	klog.V(3).InfoS("Tore down pod network", "pod", klog.KRef(namespace, name), "sandboxID", sandboxID)
+	return nil
+}
+
+// CheckPod 按顺序执行CHECK，网络版本不支持、禁用CHECK或者没有ADD结果时跳过
+func (this *Plugin) CheckPod(namespace, name, sandboxID, netnsPath string) error {
+	cached, err := readCachedResult(this.cacheDir, sandboxID, defaultIfName)
+	if err != nil {
+		return err
+	}
+	// 不是由kubelet配置网络的sandbox，如切换网络插件之前创建的，或者ADD还没有完成
+	if cached == nil || len(cached.Result) == 0 {
+		return nil
+	}
+	list, err := confListFromBytes(cached.Config)
+	if err != nil {
+		return err
+	}
+	if !list.supportsCheck() {
+		return nil
+	}
+	rt := &runtimeConf{
+		ContainerID:    sandboxID,
+		NetNS:          netnsPath,
+		IfName:         cached.IfName,
+		Args:           cached.CNIArgs,
+		CapabilityArgs: cached.CapabilityArgs,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	for _, plugin := range list.Plugins {
+		if _, err = this.invoke(ctx, list, plugin, cmdCheck, rt, cached.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPodNetworkStatus kubelet重启后从缓存文件中恢复sandbox的IP
+func (this *Plugin) GetPodNetworkStatus(namespace, name, sandboxID string) *network.PodNetworkStatus {
+	this.lock.RLock()
+	ips, ok := this.podIPs[sandboxID]
+	this.lock.RUnlock()
+	if ok {
+		return &network.PodNetworkStatus{IPs: ips}
+	}
+	cached, err := readCachedResult(this.cacheDir, sandboxID, defaultIfName)
+	// ADD还没有完成时不缓存IP
+	if err != nil || cached == nil || len(cached.Result) == 0 {
+		if err != nil {
+			klog.ErrorS(err, "Failed to read CNI result cache", "pod", klog.KRef(namespace, name), "sandboxID", sandboxID)
+		}
+		return nil
+	}
+	if ips, err = resultIPs(cached.Result); err != nil {
+		klog.ErrorS(err, "Failed to get pod IPs from CNI result cache", "pod", klog.KRef(namespace, name), "sandboxID", sandboxID)
+		return nil
+	}
+	this.lock.Lock()
+	this.podIPs[sandboxID] = ips
+	this.lock.Unlock()
+	return &network.PodNetworkStatus{IPs: ips}
+}
+
+func (this *Plugin) invoke(ctx context.Context, list *networkConfigList, plugin *pluginConf, command string,
+	rt *runtimeConf, prevResult json.RawMessage) (json.RawMessage, error) {
+	pluginPath, err := findInPath(plugin.Type, this.binDirs)
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := buildOneConfig(list, plugin, prevResult, rt.CapabilityArgs)
+	if err != nil {
+		return nil, err
+	}
+	out, err := execPlugin(ctx, pluginPath, command, rt, this.binDirs, stdin)
+	if err != nil {
+		return nil, fmt.Errorf("plugin type=%q name=%q failed (%s): %v", plugin.Type, list.Name, strings.ToLower(command), err)
+	}
+	return out, nil
+}
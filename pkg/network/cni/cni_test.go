@@ -0,0 +1,418 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"mykubelet/pkg/network"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testSandboxID = "sandbox-1"
+	testNetNS     = "/var/run/netns/test"
+	bridgeResult  = `{"cniVersion":"0.4.0","interfaces":[{"name":"eth0"}],"ips":[{"version":"4","address":"10.1.0.5/24"},{"version":"6","address":"fd00::5/64"}]}`
+	testConfList  = `{
+  "cniVersion": "0.4.0",
+  "name": "test-net",
+  "plugins": [
+    {"type": "stub-bridge", "bridge": "cni0", "capabilities": {"ipRanges": true}},
+    {"type": "stub-portmap", "capabilities": {"portMappings": true}}
+  ]
+}`
+)
+
+// stubEnv 配置目录、插件目录和缓存目录，插件是记录调用的shell脚本
+type stubEnv struct {
+	confDir  string
+	binDir   string
+	cacheDir string
+	logDir   string
+}
+
+func newStubEnv(t *testing.T) *stubEnv {
+	dir := t.TempDir()
+	env := &stubEnv{
+		confDir:  filepath.Join(dir, "net.d"),
+		binDir:   filepath.Join(dir, "bin"),
+		cacheDir: filepath.Join(dir, "cache"),
+		logDir:   filepath.Join(dir, "log"),
+	}
+	for _, d := range []string{env.confDir, env.binDir, env.logDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return env
+}
+
+// writePlugin 生成插件脚本，每次调用在calls中记录插件名、命令和CNI_*环境变量，标准输入保存到<插件名>-<命令>.json，
+// ADD时输出result，failOn中的命令输出CNI错误并失败
+func (this *stubEnv) writePlugin(t *testing.T, name string, result string, failOn ...string) {
+	script := fmt.Sprintf(`#!/bin/sh
+stdin=$(cat)
+echo "%[1]s $CNI_COMMAND $CNI_CONTAINERID $CNI_NETNS $CNI_IFNAME $CNI_ARGS" >> %[2]s/calls
+printf '%%s' "$stdin" > %[2]s/%[1]s-$CNI_COMMAND.json
+for cmd in %[3]s; do
+  if [ "$cmd" = "$CNI_COMMAND" ]; then
+    echo '{"code":100,"msg":"%[1]s failed"}'
+    exit 1
+  fi
+done
+if [ "$CNI_COMMAND" = "ADD" ]; then
+  printf '%%s' '%[4]s'
+fi
+`, name, this.logDir, strings.Join(failOn, " "), result)
+	if err := os.WriteFile(filepath.Join(this.binDir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (this *stubEnv) writeConf(t *testing.T, name, content string) {
+	if err := os.WriteFile(filepath.Join(this.confDir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (this *stubEnv) newPlugin() *Plugin {
+	return NewPlugin(this.confDir, []string{filepath.Join(this.binDir, "missing"), this.binDir}, this.cacheDir)
+}
+
+// calls 按顺序返回"插件名 命令"
+func (this *stubEnv) calls(t *testing.T) []string {
+	data, err := os.ReadFile(filepath.Join(this.logDir, "calls"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		calls = append(calls, fields[0]+" "+fields[1])
+	}
+	return calls
+}
+
+// callEnv 第一次调用的容器ID、网络命名空间、网卡名和CNI_ARGS
+func (this *stubEnv) callEnv(t *testing.T) []string {
+	data, err := os.ReadFile(filepath.Join(this.logDir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(strings.SplitN(string(data), "\n", 2)[0])[2:]
+}
+
+func (this *stubEnv) resetCalls(t *testing.T) {
+	if err := os.Remove(filepath.Join(this.logDir, "calls")); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+// stdin 插件最近一次执行command时收到的配置
+func (this *stubEnv) stdin(t *testing.T, plugin, command string) map[string]interface{} {
+	data, err := os.ReadFile(filepath.Join(this.logDir, plugin+"-"+command+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := map[string]interface{}{}
+	if err = json.Unmarshal(data, &conf); err != nil {
+		t.Fatalf("invalid config passed to %s %s: %v", plugin, command, err)
+	}
+	return conf
+}
+
+func (this *stubEnv) cacheExists() bool {
+	_, err := os.Stat(cachePath(this.cacheDir, testSandboxID, defaultIfName))
+	return err == nil
+}
+
+func expectCalls(t *testing.T, env *stubEnv, expected ...string) {
+	t.Helper()
+	if calls := env.calls(t); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected plugin calls %v, got %v", expected, calls)
+	}
+	env.resetCalls(t)
+}
+
+func TestStatus(t *testing.T) {
+	env := newStubEnv(t)
+	plugin := env.newPlugin()
+	if err := plugin.Status(); err == nil {
+		t.Errorf("expected plugin not to be ready without network config")
+	}
+
+	// 配置文件可以在kubelet启动后由网络组件安装
+	env.writeConf(t, "00-invalid.conflist", `{"name": "invalid"}`)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	if err := plugin.Status(); err == nil {
+		t.Errorf("expected plugin not to be ready before podCIDR is assigned")
+	}
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+	if err := plugin.Status(); err != nil {
+		t.Errorf("expected plugin to be ready: %v", err)
+	}
+	if name := plugin.getNetwork().Name; name != "test-net" {
+		t.Errorf("expected the first valid network to be used, got %q", name)
+	}
+}
+
+func TestSetUpAndTearDownPod(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	env.writePlugin(t, "stub-bridge", bridgeResult)
+	// portmap没有输出结果，沿用bridge的结果
+	env.writePlugin(t, "stub-portmap", "")
+	plugin := env.newPlugin()
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24", "fd00::/64"})
+
+	portMappings := []network.PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}
+	status, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, portMappings)
+	if err != nil {
+		t.Fatalf("failed to set up pod: %v", err)
+	}
+	if !reflect.DeepEqual(status.IPs, []string{"10.1.0.5", "fd00::5"}) {
+		t.Errorf("unexpected pod IPs %v", status.IPs)
+	}
+	expectedEnv := []string{testSandboxID, testNetNS, defaultIfName,
+		"IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod;K8S_POD_INFRA_CONTAINER_ID=" + testSandboxID}
+	if callEnv := env.callEnv(t); !reflect.DeepEqual(callEnv, expectedEnv) {
+		t.Errorf("expected plugin environment %v, got %v", expectedEnv, callEnv)
+	}
+	expectCalls(t, env, "stub-bridge ADD", "stub-portmap ADD")
+
+	// 只注入插件声明的运行时参数
+	bridgeConf := env.stdin(t, "stub-bridge", cmdAdd)
+	if bridgeConf["name"] != "test-net" || bridgeConf["cniVersion"] != "0.4.0" || bridgeConf["bridge"] != "cni0" {
+		t.Errorf("unexpected bridge config %v", bridgeConf)
+	}
+	expectedRanges := []interface{}{
+		[]interface{}{map[string]interface{}{"subnet": "10.1.0.0/24"}},
+		[]interface{}{map[string]interface{}{"subnet": "fd00::/64"}},
+	}
+	if runtimeConfig, _ := bridgeConf["runtimeConfig"].(map[string]interface{}); !reflect.DeepEqual(runtimeConfig,
+		map[string]interface{}{"ipRanges": expectedRanges}) {
+		t.Errorf("unexpected bridge runtimeConfig %v", bridgeConf["runtimeConfig"])
+	}
+	if _, ok := bridgeConf["prevResult"]; ok {
+		t.Errorf("first plugin should not get prevResult")
+	}
+	portmapConf := env.stdin(t, "stub-portmap", cmdAdd)
+	runtimeConfig, _ := portmapConf["runtimeConfig"].(map[string]interface{})
+	if _, ok := runtimeConfig["portMappings"]; !ok || len(runtimeConfig) != 1 {
+		t.Errorf("unexpected portmap runtimeConfig %v", runtimeConfig)
+	}
+	if portmapConf["prevResult"] == nil {
+		t.Errorf("expected bridge result to be passed to portmap as prevResult")
+	}
+
+	// 网络配置变化后，DEL仍然使用ADD时的配置
+	env.writeConf(t, "10-test.conflist", `{"cniVersion": "0.4.0", "name": "other-net", "plugins": [{"type": "stub-bridge"}]}`)
+	if err = plugin.TearDownPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Fatalf("failed to tear down pod: %v", err)
+	}
+	expectCalls(t, env, "stub-portmap DEL", "stub-bridge DEL")
+	delConf := env.stdin(t, "stub-bridge", cmdDel)
+	if delConf["name"] != "test-net" || delConf["prevResult"] == nil {
+		t.Errorf("expected DEL to use the cached config and result, got %v", delConf)
+	}
+	if env.cacheExists() {
+		t.Errorf("expected cache to be deleted after tear down")
+	}
+	if status := plugin.GetPodNetworkStatus("default", "pod", testSandboxID); status != nil {
+		t.Errorf("expected no network status after tear down, got %v", status.IPs)
+	}
+
+	// 没有配置过网络的sandbox不调用插件
+	if err = plugin.TearDownPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Fatalf("failed to tear down pod again: %v", err)
+	}
+	expectCalls(t, env)
+}
+
+// ADD中途失败时，对整条链按相反顺序执行DEL
+func TestSetUpPodFailureTearsDownChain(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	env.writePlugin(t, "stub-bridge", bridgeResult)
+	env.writePlugin(t, "stub-portmap", "", cmdAdd)
+	plugin := env.newPlugin()
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+
+	_, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil)
+	if err == nil || !strings.Contains(err.Error(), "stub-portmap failed") {
+		t.Fatalf("expected the plugin error to be returned, got %v", err)
+	}
+	expectCalls(t, env, "stub-bridge ADD", "stub-portmap ADD")
+	if status := plugin.GetPodNetworkStatus("default", "pod", testSandboxID); status != nil {
+		t.Errorf("expected no network status for a failed set up, got %v", status.IPs)
+	}
+	if err = plugin.CheckPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Errorf("expected CHECK to be skipped for a failed set up: %v", err)
+	}
+	expectCalls(t, env)
+
+	// kubelet重启后也能清理
+	if err = env.newPlugin().TearDownPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Fatalf("failed to tear down pod: %v", err)
+	}
+	expectCalls(t, env, "stub-portmap DEL", "stub-bridge DEL")
+	if _, ok := env.stdin(t, "stub-bridge", cmdDel)["prevResult"]; ok {
+		t.Errorf("DEL after a failed ADD should not get prevResult")
+	}
+	if env.cacheExists() {
+		t.Errorf("expected cache to be deleted after tear down")
+	}
+}
+
+// DEL失败时保留缓存，下次清理时重试
+func TestTearDownPodFailureKeepsCache(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	env.writePlugin(t, "stub-bridge", bridgeResult, cmdDel)
+	env.writePlugin(t, "stub-portmap", "")
+	plugin := env.newPlugin()
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+	if _, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil); err != nil {
+		t.Fatalf("failed to set up pod: %v", err)
+	}
+	env.resetCalls(t)
+
+	if err := plugin.TearDownPod("default", "pod", testSandboxID, ""); err == nil {
+		t.Fatalf("expected tear down to fail")
+	}
+	expectCalls(t, env, "stub-portmap DEL", "stub-bridge DEL")
+	if !env.cacheExists() {
+		t.Fatalf("expected cache to be kept after a failed tear down")
+	}
+
+	env.writePlugin(t, "stub-bridge", bridgeResult)
+	if err := plugin.TearDownPod("default", "pod", testSandboxID, ""); err != nil {
+		t.Fatalf("failed to tear down pod: %v", err)
+	}
+	expectCalls(t, env, "stub-portmap DEL", "stub-bridge DEL")
+}
+
+// 插件都没有输出结果时没有IP，不是错误
+func TestSetUpPodWithoutResult(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conf", `{"cniVersion": "0.4.0", "name": "test-net", "type": "stub-bridge"}`)
+	env.writePlugin(t, "stub-bridge", "")
+	plugin := env.newPlugin()
+
+	status, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil)
+	if err != nil {
+		t.Fatalf("failed to set up pod: %v", err)
+	}
+	if len(status.IPs) != 0 {
+		t.Errorf("expected no IPs, got %v", status.IPs)
+	}
+	expectCalls(t, env, "stub-bridge ADD")
+
+	if err = plugin.TearDownPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Fatalf("failed to tear down pod: %v", err)
+	}
+	expectCalls(t, env, "stub-bridge DEL")
+}
+
+// kubelet重启后从缓存中恢复IP
+func TestGetPodNetworkStatusFromCache(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	env.writePlugin(t, "stub-bridge", bridgeResult)
+	env.writePlugin(t, "stub-portmap", "")
+	plugin := env.newPlugin()
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+	if _, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil); err != nil {
+		t.Fatalf("failed to set up pod: %v", err)
+	}
+
+	status := env.newPlugin().GetPodNetworkStatus("default", "pod", testSandboxID)
+	if status == nil || !reflect.DeepEqual(status.IPs, []string{"10.1.0.5", "fd00::5"}) {
+		t.Errorf("expected IPs to be restored from cache, got %v", status)
+	}
+	if status := plugin.GetPodNetworkStatus("default", "pod", "unknown"); status != nil {
+		t.Errorf("expected no network status for unknown sandbox, got %v", status.IPs)
+	}
+}
+
+func TestCheckPod(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     string
+		failOn   []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "check passes",
+			conf:     testConfList,
+			expected: []string{"stub-bridge CHECK", "stub-portmap CHECK"},
+		},
+		{
+			name:     "check fails",
+			conf:     testConfList,
+			failOn:   []string{cmdCheck},
+			expected: []string{"stub-bridge CHECK"},
+			wantErr:  true,
+		},
+		{
+			name: "check not supported before 0.4.0",
+			conf: strings.Replace(testConfList, `"0.4.0"`, `"0.3.1"`, 1),
+		},
+		{
+			name:   "check disabled",
+			conf:   strings.Replace(testConfList, `"name"`, `"disableCheck": true, "name"`, 1),
+			failOn: []string{cmdCheck},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newStubEnv(t)
+			env.writeConf(t, "10-test.conflist", tc.conf)
+			env.writePlugin(t, "stub-bridge", bridgeResult, tc.failOn...)
+			env.writePlugin(t, "stub-portmap", "")
+			plugin := env.newPlugin()
+			plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+			if _, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil); err != nil {
+				t.Fatalf("failed to set up pod: %v", err)
+			}
+			env.resetCalls(t)
+
+			err := plugin.CheckPod("default", "pod", testSandboxID, testNetNS)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+			expectCalls(t, env, tc.expected...)
+			if len(tc.expected) > 0 && env.stdin(t, "stub-bridge", cmdCheck)["prevResult"] == nil {
+				t.Errorf("expected CHECK to get the ADD result as prevResult")
+			}
+		})
+	}
+}
+
+func TestSetUpPodPluginNotFound(t *testing.T) {
+	env := newStubEnv(t)
+	env.writeConf(t, "10-test.conflist", testConfList)
+	env.writePlugin(t, "stub-bridge", bridgeResult)
+	plugin := env.newPlugin()
+	plugin.UpdatePodCIDRs([]string{"10.1.0.0/24"})
+
+	if _, err := plugin.SetUpPod("default", "pod", testSandboxID, testNetNS, nil); err == nil {
+		t.Fatalf("expected set up to fail when a plugin is missing")
+	}
+	expectCalls(t, env, "stub-bridge ADD")
+	// 找不到插件时DEL同样失败，保留缓存等插件安装后重试
+	if err := plugin.TearDownPod("default", "pod", testSandboxID, testNetNS); err == nil {
+		t.Errorf("expected tear down to fail when a plugin is missing")
+	}
+	env.writePlugin(t, "stub-portmap", "")
+	if err := plugin.TearDownPod("default", "pod", testSandboxID, testNetNS); err != nil {
+		t.Fatalf("failed to tear down pod: %v", err)
+	}
+	expectCalls(t, env, "stub-portmap DEL", "stub-bridge DEL")
+}
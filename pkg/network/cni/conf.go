@@ -0,0 +1,176 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pluginConf 网络中的一个插件，raw为原始配置，调用时在其上注入name、cniVersion、prevResult和runtimeConfig
+type pluginConf struct {
+	Type         string          `json:"type"`
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	raw          map[string]interface{}
+}
+
+// networkConfigList 一个网络的插件链，按顺序执行ADD，按相反顺序执行DEL
+// github.com/containernetworking/cni/libcni/conf.go
+type networkConfigList struct {
+	Name         string
+	CNIVersion   string
+	DisableCheck bool
+	Plugins      []*pluginConf
+	// 原始配置，缓存到结果中，DEL时使用ADD时的配置
+	Bytes []byte
+}
+
+// confListFromBytes 解析.conflist格式的配置
+func confListFromBytes(data []byte) (*networkConfigList, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing configuration list: %v", err)
+	}
+	list := &networkConfigList{Bytes: data}
+	list.Name, _ = raw["name"].(string)
+	if list.Name == "" {
+		return nil, fmt.Errorf("error parsing configuration list: no name")
+	}
+	list.CNIVersion, _ = raw["cniVersion"].(string)
+	list.DisableCheck, _ = raw["disableCheck"].(bool)
+	plugins, ok := raw["plugins"].([]interface{})
+	if !ok || len(plugins) == 0 {
+		return nil, fmt.Errorf("error parsing configuration list: no plugins in list")
+	}
+	for i, p := range plugins {
+		pluginRaw, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("error parsing configuration list: invalid plugin %d", i)
+		}
+		plugin, err := pluginConfFromRaw(pluginRaw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse plugin %d of network %s: %v", i, list.Name, err)
+		}
+		list.Plugins = append(list.Plugins, plugin)
+	}
+	return list, nil
+}
+
+// confListFromConf 单个插件的.conf配置转换为只有一个插件的列表
+func confListFromConf(data []byte) (*networkConfigList, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %v", err)
+	}
+	name, _ := raw["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("error parsing configuration: no name")
+	}
+	listRaw := map[string]interface{}{
+		"name":       name,
+		"cniVersion": raw["cniVersion"],
+		"plugins":    []interface{}{raw},
+	}
+	listBytes, err := json.Marshal(listRaw)
+	if err != nil {
+		return nil, err
+	}
+	return confListFromBytes(listBytes)
+}
+
+func pluginConfFromRaw(raw map[string]interface{}) (*pluginConf, error) {
+	plugin := &pluginConf{raw: raw}
+	plugin.Type, _ = raw["type"].(string)
+	if plugin.Type == "" {
+		return nil, fmt.Errorf("no plugin type")
+	}
+	if caps, ok := raw["capabilities"].(map[string]interface{}); ok {
+		plugin.Capabilities = map[string]bool{}
+		for name, enabled := range caps {
+			if b, ok := enabled.(bool); ok {
+				plugin.Capabilities[name] = b
+			}
+		}
+	}
+	return plugin, nil
+}
+
+// loadDefaultNetwork 按文件名排序，使用confDir中第一个有效的网络配置
+// pkg/kubelet/dockershim/network/cni/cni.go getDefaultCNINetwork
+func loadDefaultNetwork(confDir string) (*networkConfigList, error) {
+	var files []string
+	for _, ext := range []string{".conf", ".conflist", ".json"} {
+		matches, err := filepath.Glob(filepath.Join(confDir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no networks found in %s", confDir)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			klog.V(2).InfoS("Error reading CNI config file", "path", file, "err", err)
+			continue
+		}
+		var list *networkConfigList
+		if strings.HasSuffix(file, ".conflist") {
+			list, err = confListFromBytes(data)
+		} else {
+			list, err = confListFromConf(data)
+		}
+		if err != nil {
+			klog.V(2).InfoS("Error loading CNI config file", "path", file, "err", err)
+			continue
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("no valid networks found in %s", confDir)
+}
+
+// supportsCheck CHECK从0.4.0开始支持
+func (this *networkConfigList) supportsCheck() bool {
+	if this.DisableCheck {
+		return false
+	}
+	return compareVersion(this.CNIVersion, "0.4.0") >= 0
+}
+
+// hasCapability 链中有插件需要这个运行时参数
+func (this *networkConfigList) hasCapability(name string) bool {
+	for _, plugin := range this.Plugins {
+		if plugin.Capabilities[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersion 按数字比较点分隔的版本号，缺少的部分按0处理
+func compareVersion(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
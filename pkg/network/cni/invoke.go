@@ -0,0 +1,216 @@
+package cni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cmdAdd   = "ADD"
+	cmdDel   = "DEL"
+	cmdCheck = "CHECK"
+)
+
+// runtimeConf 调用插件时的运行时参数
+// github.com/containernetworking/cni/libcni/api.go RuntimeConf
+type runtimeConf struct {
+	ContainerID string
+	NetNS       string
+	IfName      string
+	// 通过CNI_ARGS传给插件，如K8S_POD_NAME
+	Args [][2]string
+	// 按插件声明的capabilities注入到runtimeConfig，如ipRanges、portMappings
+	CapabilityArgs map[string]interface{}
+}
+
+// cniError 插件失败时在stdout输出的错误
+type cniError struct {
+	Code    uint   `json:"code"`
+	Msg     string `json:"msg"`
+	Details string `json:"details,omitempty"`
+}
+
+func (this *cniError) Error() string {
+	details := ""
+	if this.Details != "" {
+		details = "; " + this.Details
+	}
+	return fmt.Sprintf("%s%s", this.Msg, details)
+}
+
+// findInPath 在binDirs中按顺序查找插件的可执行文件
+func findInPath(plugin string, binDirs []string) (string, error) {
+	if plugin == "" || strings.ContainsRune(plugin, os.PathSeparator) {
+		return "", fmt.Errorf("invalid plugin name %q", plugin)
+	}
+	for _, dir := range binDirs {
+		path := filepath.Join(dir, plugin)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find plugin %q in path %s", plugin, binDirs)
+}
+
+// execPlugin 通过环境变量传递命令和运行时参数，标准输入传递网络配置，返回标准输出
+func execPlugin(ctx context.Context, pluginPath string, command string, rt *runtimeConf, binDirs []string, stdin []byte) ([]byte, error) {
+	args := make([]string, 0, len(rt.Args))
+	for _, kv := range rt.Args {
+		args = append(args, kv[0]+"="+kv[1])
+	}
+	cmd := exec.CommandContext(ctx, pluginPath)
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+rt.ContainerID,
+		"CNI_NETNS="+rt.NetNS,
+		"CNI_ARGS="+strings.Join(args, ";"),
+		"CNI_IFNAME="+rt.IfName,
+		"CNI_PATH="+strings.Join(binDirs, string(os.PathListSeparator)),
+	)
+	cmd.Stdin = bytes.NewBuffer(stdin)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if stdout.Len() > 0 {
+			pluginErr := &cniError{}
+			if jsonErr := json.Unmarshal(stdout.Bytes(), pluginErr); jsonErr == nil && pluginErr.Msg != "" {
+				return nil, pluginErr
+			}
+		}
+		return nil, fmt.Errorf("netplugin failed: %v, stderr: %q", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// buildOneConfig 在插件的配置上注入网络名、版本、上一个插件的结果和插件声明需要的运行时参数
+// github.com/containernetworking/cni/libcni/api.go buildOneConfig
+func buildOneConfig(list *networkConfigList, plugin *pluginConf, prevResult json.RawMessage,
+	capabilityArgs map[string]interface{}) ([]byte, error) {
+	conf := make(map[string]interface{}, len(plugin.raw)+4)
+	for k, v := range plugin.raw {
+		conf[k] = v
+	}
+	conf["name"] = list.Name
+	conf["cniVersion"] = list.CNIVersion
+	if len(prevResult) > 0 {
+		conf["prevResult"] = prevResult
+	}
+	runtimeConfig := map[string]interface{}{}
+	for capability, enabled := range plugin.Capabilities {
+		if !enabled {
+			continue
+		}
+		if arg, ok := capabilityArgs[capability]; ok {
+			runtimeConfig[capability] = arg
+		}
+	}
+	if len(runtimeConfig) > 0 {
+		conf["runtimeConfig"] = runtimeConfig
+	}
+	return json.Marshal(conf)
+}
+
+// resultIPs 从插件的结果中取出IP，兼容0.3.0之后的ips和之前的ip4/ip6，链中插件都没有输出结果时没有IP
+func resultIPs(result json.RawMessage) ([]string, error) {
+	if len(result) == 0 {
+		return []string{}, nil
+	}
+	parsed := struct {
+		IPs []struct {
+			Address string `json:"address"`
+		} `json:"ips"`
+		IP4 *struct {
+			IP string `json:"ip"`
+		} `json:"ip4"`
+		IP6 *struct {
+			IP string `json:"ip"`
+		} `json:"ip6"`
+	}{}
+	if err := json.Unmarshal(result, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse CNI result: %v", err)
+	}
+	addresses := []string{}
+	for _, ip := range parsed.IPs {
+		addresses = append(addresses, ip.Address)
+	}
+	if parsed.IP4 != nil {
+		addresses = append(addresses, parsed.IP4.IP)
+	}
+	if parsed.IP6 != nil {
+		addresses = append(addresses, parsed.IP6.IP)
+	}
+	ips := []string{}
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q in CNI result: %v", address, err)
+		}
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+// cachedResult ADD前缓存配置和参数，成功后补上结果，DEL和CHECK使用和ADD时相同的配置
+// github.com/containernetworking/cni/libcni/api.go cachedInfo
+type cachedResult struct {
+	ContainerID    string                 `json:"containerId"`
+	IfName         string                 `json:"ifName"`
+	NetworkName    string                 `json:"networkName"`
+	Config         json.RawMessage        `json:"config"`
+	CNIArgs        [][2]string            `json:"cniArgs,omitempty"`
+	CapabilityArgs map[string]interface{} `json:"capabilityArgs,omitempty"`
+	Result         json.RawMessage        `json:"result,omitempty"`
+}
+
+func cachePath(cacheDir, containerID, ifName string) string {
+	return filepath.Join(cacheDir, "results", containerID+"-"+ifName)
+}
+
+// readCachedResult 没有缓存时返回nil
+func readCachedResult(cacheDir, containerID, ifName string) (*cachedResult, error) {
+	data, err := os.ReadFile(cachePath(cacheDir, containerID, ifName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedResult{}
+	if err = json.Unmarshal(data, cached); err != nil {
+		return nil, fmt.Errorf("failed to parse cached CNI result: %v", err)
+	}
+	return cached, nil
+}
+
+// writeCachedResult 先写临时文件再重命名，避免写入过程中重启导致文件损坏
+func writeCachedResult(cacheDir string, cached *cachedResult) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	path := cachePath(cacheDir, cached.ContainerID, cached.IfName)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func deleteCachedResult(cacheDir, containerID, ifName string) error {
+	if err := os.Remove(cachePath(cacheDir, containerID, ifName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"fmt"
+	"k8s.io/klog/v2"
+	"sync"
+)
+
+const (
+	// NoopNetworkPluginName 由容器运行时配置pod网络，kubelet不参与
+	NoopNetworkPluginName = ""
+	// CNIPluginName kubelet调用CNI插件配置sandbox的网络
+	CNIPluginName = "cni"
+)
+
+// PodNetworkStatus 网络插件为sandbox分配的IP，第一个为主IP
+type PodNetworkStatus struct {
+	IPs []string
+}
+
+// PortMapping 容器端口到节点端口的映射，通过portMappings运行时参数传给CNI插件
+type PortMapping struct {
+	HostPort      int32  `json:"hostPort"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// NetworkPlugin 为sandbox配置和清理网络
+// pkg/kubelet/dockershim/network/plugins.go
+type NetworkPlugin interface {
+	Name() string
+	// UpdatePodCIDRs 节点spec中的podCIDR变化时调用，插件从中为pod分配IP
+	UpdatePodCIDRs(podCIDRs []string)
+	// SetUpPod sandbox创建后在其网络命名空间中配置网络
+	SetUpPod(namespace, name, sandboxID, netnsPath string, portMappings []PortMapping) (*PodNetworkStatus, error)
+	// TearDownPod sandbox停止前清理网络，没有配置过网络的sandbox直接返回
+	TearDownPod(namespace, name, sandboxID, netnsPath string) error
+	// CheckPod 检查sandbox的网络是否和配置一致
+	CheckPod(namespace, name, sandboxID, netnsPath string) error
+	// GetPodNetworkStatus sandbox的IP，插件不管理其网络时返回nil
+	GetPodNetworkStatus(namespace, name, sandboxID string) *PodNetworkStatus
+	// Status 插件没有就绪时返回原因，如还没有网络配置
+	Status() error
+}
+
+// noopNetworkPlugin pod的网络由容器运行时负责
+type noopNetworkPlugin struct{}
+
+func NewNoopNetworkPlugin() NetworkPlugin {
+	return &noopNetworkPlugin{}
+}
+
+func (this *noopNetworkPlugin) Name() string {
+	return NoopNetworkPluginName
+}
+
+func (this *noopNetworkPlugin) UpdatePodCIDRs(_ []string) {}
+
+func (this *noopNetworkPlugin) SetUpPod(_, _, _, _ string, _ []PortMapping) (*PodNetworkStatus, error) {
+	return nil, nil
+}
+
+func (this *noopNetworkPlugin) TearDownPod(_, _, _, _ string) error {
+	return nil
+}
+
+func (this *noopNetworkPlugin) CheckPod(_, _, _, _ string) error {
+	return nil
+}
+
+func (this *noopNetworkPlugin) GetPodNetworkStatus(_, _, _ string) *PodNetworkStatus {
+	return nil
+}
+
+func (this *noopNetworkPlugin) Status() error {
+	return nil
+}
+
+// PluginManager 同一个pod的网络操作串行执行
+// pkg/kubelet/dockershim/network/plugins.go PluginManager
+type PluginManager struct {
+	plugin NetworkPlugin
+
+	podsLock sync.Mutex
+	// pod全名 -> 锁，引用计数为0时删除
+	pods map[string]*podLock
+}
+
+type podLock struct {
+	refcount uint
+	mu       sync.Mutex
+}
+
+func NewPluginManager(plugin NetworkPlugin) *PluginManager {
+	return &PluginManager{plugin: plugin, pods: map[string]*podLock{}}
+}
+
+func (this *PluginManager) PluginName() string {
+	return this.plugin.Name()
+}
+
+func (this *PluginManager) podLock(fullPodName string) *sync.Mutex {
+	this.podsLock.Lock()
+	defer this.podsLock.Unlock()
+	lock, ok := this.pods[fullPodName]
+	if !ok {
+		lock = &podLock{}
+		this.pods[fullPodName] = lock
+	}
+	lock.refcount++
+	return &lock.mu
+}
+
+func (this *PluginManager) podUnlock(fullPodName string) {
+	this.podsLock.Lock()
+	defer this.podsLock.Unlock()
+	lock, ok := this.pods[fullPodName]
+	if !ok {
+		klog.InfoS("Unbalanced pod lock unref for the pod", "podFullName", fullPodName)
+		return
+	}
+	lock.mu.Unlock()
+	lock.refcount--
+	if lock.refcount == 0 {
+		delete(this.pods, fullPodName)
+	}
+}
+
+func (this *PluginManager) lockPod(namespace, name string) string {
+	fullPodName := fmt.Sprintf("%s_%s", name, namespace)
+	this.podLock(fullPodName).Lock()
+	return fullPodName
+}
+
+func (this *PluginManager) UpdatePodCIDRs(podCIDRs []string) {
+	this.plugin.UpdatePodCIDRs(podCIDRs)
+}
+
+func (this *PluginManager) SetUpPod(namespace, name, sandboxID, netnsPath string, portMappings []PortMapping) (*PodNetworkStatus, error) {
+	defer this.podUnlock(this.lockPod(namespace, name))
+	klog.V(3).InfoS("Calling network plugin to set up the pod", "pod", klog.KRef(namespace, name), "networkPluginName", this.plugin.Name())
+	status, err := this.plugin.SetUpPod(namespace, name, sandboxID, netnsPath, portMappings)
+	if err != nil {
+		return nil, fmt.Errorf("networkPlugin %s failed to set up pod %q network: %v", this.plugin.Name(), klog.KRef(namespace, name), err)
+	}
+	return status, nil
+}
+
+func (this *PluginManager) TearDownPod(namespace, name, sandboxID, netnsPath string) error {
+	defer this.podUnlock(this.lockPod(namespace, name))
+	klog.V(3).InfoS("Calling network plugin to tear down the pod", "pod", klog.KRef(namespace, name), "networkPluginName", this.plugin.Name())
+	if err := this.plugin.TearDownPod(namespace, name, sandboxID, netnsPath); err != nil {
+		return fmt.Errorf("networkPlugin %s failed to teardown pod %q network: %v", this.plugin.Name(), klog.KRef(namespace, name), err)
+	}
+	return nil
+}
+
+func (this *PluginManager) CheckPod(namespace, name, sandboxID, netnsPath string) error {
+	defer this.podUnlock(this.lockPod(namespace, name))
+	if err := this.plugin.CheckPod(namespace, name, sandboxID, netnsPath); err != nil {
+		return fmt.Errorf("networkPlugin %s failed on the status hook for pod %q: %v", this.plugin.Name(), klog.KRef(namespace, name), err)
+	}
+	return nil
+}
+
+func (this *PluginManager) GetPodNetworkStatus(namespace, name, sandboxID string) *PodNetworkStatus {
+	return this.plugin.GetPodNetworkStatus(namespace, name, sandboxID)
+}
+
+// NetworkStatus 节点状态中NetworkUnavailable的依据
+func (this *PluginManager) NetworkStatus() error {
+	return this.plugin.Status()
+}
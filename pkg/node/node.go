@@ -7,12 +7,14 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	"mykubelet/pkg/metrics"
 	"runtime"
+	"time"
 )
 
-// RegisterNode 注册节点
-func RegisterNode(client *kubernetes.Clientset, nodeName string) {
-	nodeObj := &corev1.Node{
+// InitialNode 注册时创建的节点，带有主机名、操作系统和架构标签
+func InitialNode(nodeName string) *corev1.Node {
+	return &corev1.Node{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: nodeName,
 			Labels: map[string]string{
@@ -23,6 +25,11 @@ func RegisterNode(client *kubernetes.Clientset, nodeName string) {
 		},
 		Spec: corev1.NodeSpec{},
 	}
+}
+
+// RegisterNode 注册节点
+func RegisterNode(client *kubernetes.Clientset, nodeName string) {
+	nodeObj := InitialNode(nodeName)
 
 	getNode, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
 	// 创建节点
@@ -46,9 +53,12 @@ func RegisterNode(client *kubernetes.Clientset, nodeName string) {
 	}
 
 	// patch更新
+	start := time.Now()
 	_, err = client.CoreV1().Nodes().Patch(context.Background(), nodeName, types.StrategicMergePatchType,
 		patchBytes, metav1.PatchOptions{}, "status")
+	metrics.NodeStatusPatchDuration.Observe(metrics.SinceInSeconds(start))
 	if err != nil {
+		metrics.NodeStatusPatchErrors.Inc()
 		klog.Fatalln(err)
 	}
 	klog.Infoln("node status update success")
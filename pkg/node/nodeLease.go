@@ -1,11 +1,16 @@
 package node
 
 import (
+	"context"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	"k8s.io/component-helpers/apimachinery/lease"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/clock"
+	"mykubelet/pkg/metrics"
 	"time"
 )
 
@@ -24,7 +29,38 @@ func StartLeaseController(client kubernetes.Interface, nodeName string) {
 	}
 	klog.Infoln("starting lease controller")
 
-	ctl := lease.NewController(myclock, client, nodeName, LeaseDurationSeconds,
-		heartbeatFailure, renewInterval, LeaseNameSpace, SetNodeOwnerFunc(client, nodeName))
-	ctl.Run(wait.NeverStop)
+	ctl := lease.NewController(myclock, &instrumentedClient{Interface: client}, nodeName, LeaseDurationSeconds,
+		heartbeatFailure, renewInterval, nodeName, LeaseNameSpace, SetNodeOwnerFunc(client, nodeName))
+	ctl.Run(wait.ContextForChannel(wait.NeverStop))
+}
+
+// 租约控制器通过Update续租，包装client统计续租的耗时和失败次数
+type instrumentedClient struct {
+	kubernetes.Interface
+}
+
+func (this *instrumentedClient) CoordinationV1() coordinationv1client.CoordinationV1Interface {
+	return &instrumentedCoordinationClient{CoordinationV1Interface: this.Interface.CoordinationV1()}
+}
+
+type instrumentedCoordinationClient struct {
+	coordinationv1client.CoordinationV1Interface
+}
+
+func (this *instrumentedCoordinationClient) Leases(namespace string) coordinationv1client.LeaseInterface {
+	return &instrumentedLeaseClient{LeaseInterface: this.CoordinationV1Interface.Leases(namespace)}
+}
+
+type instrumentedLeaseClient struct {
+	coordinationv1client.LeaseInterface
+}
+
+func (this *instrumentedLeaseClient) Update(ctx context.Context, lease *coordinationv1.Lease, opts metav1.UpdateOptions) (*coordinationv1.Lease, error) {
+	start := time.Now()
+	ret, err := this.LeaseInterface.Update(ctx, lease, opts)
+	metrics.LeaseRenewDuration.Observe(metrics.SinceInSeconds(start))
+	if err != nil {
+		metrics.LeaseRenewErrors.Inc()
+	}
+	return ret, err
 }
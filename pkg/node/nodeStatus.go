@@ -12,8 +12,19 @@ func setNodeStatus(node *corev1.Node) {
 	node.Status.NodeInfo = nodeInfo()
 	node.Status.DaemonEndpoints = nodeDaemonEndpoints(10250)
 	node.Status.Addresses = nodeAddresses()
-	node.Status.Conditions = nodeConditions()
-	node.Status.Capacity = nodeCapacity()
+	node.Status.Conditions = mergeNodeConditions(node.Status.Conditions, nodeConditions())
+	node.Status.Capacity = mergeExtendedResources(node.Status.Capacity, nodeCapacity())
+	node.Status.Allocatable = mergeExtendedResources(node.Status.Allocatable, NodeAllocatable())
+}
+
+// mergeExtendedResources kubelet重启注册时保留节点上设备插件的扩展资源，由节点状态更新循环按插件的状态更新
+func mergeExtendedResources(existing corev1.ResourceList, resources corev1.ResourceList) corev1.ResourceList {
+	for name, value := range existing {
+		if _, ok := resources[name]; !ok {
+			resources[name] = value
+		}
+	}
+	return resources
 }
 
 // 节点信息
@@ -42,7 +53,7 @@ func nodeAddresses() []corev1.NodeAddress {
 	}
 }
 
-// 节点状态集合
+// 节点状态集合，压力condition和NetworkUnavailable由节点状态更新循环按驱逐管理器和网络插件的状态设置
 func nodeConditions() []corev1.NodeCondition {
 	return []corev1.NodeCondition{
 		{
@@ -61,33 +72,33 @@ func nodeConditions() []corev1.NodeCondition {
 			Reason:             "KubeletHasSufficientDisk",
 			Message:            "kubelet has sufficient disk space available",
 		},
-		{
-			Type:               "MemoryPressure",
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasSufficientMemory",
-			Message:            "kubelet has sufficient memory available",
-		},
-		{
-			Type:               "DiskPressure",
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasNoDiskPressure",
-			Message:            "kubelet has no disk pressure",
-		},
-		{
-			Type:               "NetworkUnavailable",
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "RouteCreated",
-			Message:            "RouteController created a route",
-		},
 	}
 }
 
+// mergeNodeConditions 按type合并condition，kubelet重启注册时保留节点状态更新循环设置的压力condition和NetworkUnavailable，
+// 状态没有变化的condition保留原来的LastTransitionTime
+func mergeNodeConditions(existing []corev1.NodeCondition, conditions []corev1.NodeCondition) []corev1.NodeCondition {
+	merged := append([]corev1.NodeCondition{}, existing...)
+	for _, condition := range conditions {
+		found := false
+		for i := range merged {
+			if merged[i].Type != condition.Type {
+				continue
+			}
+			if merged[i].Status == condition.Status {
+				condition.LastTransitionTime = merged[i].LastTransitionTime
+			}
+			merged[i] = condition
+			found = true
+			break
+		}
+		if !found {
+			merged = append(merged, condition)
+		}
+	}
+	return merged
+}
+
 // 节点资源信息 CPU和内存
 func nodeCapacity() corev1.ResourceList {
 	var cpuQ resource.Quantity
@@ -102,3 +113,9 @@ func nodeCapacity() corev1.ResourceList {
 		"pods":   resource.MustParse("200"), // 最多创建多少个pod
 	}
 }
+
+// NodeAllocatable 节点可以分配给pod的资源，没有为系统和kubelet预留资源，和节点容量相同
+// pkg/kubelet/cm/node_container_manager_linux.go GetNodeAllocatableAbsolute
+func NodeAllocatable() corev1.ResourceList {
+	return nodeCapacity()
+}
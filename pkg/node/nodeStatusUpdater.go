@@ -0,0 +1,316 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/metrics"
+	"time"
+)
+
+// 节点压力condition和污点的同步间隔
+const nodeStatusUpdateFrequency = 10 * time.Second
+
+// PressureProvider 节点的资源压力，由驱逐管理器提供
+type PressureProvider interface {
+	IsUnderMemoryPressure() bool
+	IsUnderDiskPressure() bool
+	IsUnderPIDPressure() bool
+}
+
+// VolumesProvider 节点上的卷，上报到node.status，attach/detach controller据此判断卷能否detach
+type VolumesProvider interface {
+	// GetVolumesInUse 已经挂载到pod的卷
+	GetVolumesInUse() []v1.UniqueVolumeName
+	// GetVolumesAttached 已经挂接到节点的卷
+	GetVolumesAttached() ([]v1.AttachedVolume, error)
+}
+
+// DevicesProvider 设备插件提供的扩展资源，由容器管理器提供
+type DevicesProvider interface {
+	// GetDevicePluginResourceCapacity 扩展资源的容量和可分配数量，以及插件已经停止、需要从节点上删除的资源
+	GetDevicePluginResourceCapacity() (v1.ResourceList, v1.ResourceList, []string)
+}
+
+// NetworkProvider pod网络的状态，由kubelet的网络插件提供
+type NetworkProvider interface {
+	// UpdatePodCIDRs 节点spec中的podCIDR，网络插件从中为pod分配IP
+	UpdatePodCIDRs(podCIDRs []string)
+	// NetworkStatus 网络插件没有就绪时返回原因
+	NetworkStatus() error
+}
+
+// 压力condition以及对应的污点和说明
+// pkg/kubelet/nodestatus/setters.go
+type pressureCondition struct {
+	conditionType v1.NodeConditionType
+	taintKey      string
+	// 有压力时的reason和message
+	trueReason  string
+	trueMessage string
+	// 没有压力时的reason和message
+	falseReason   string
+	falseMessage  string
+	underPressure func(PressureProvider) bool
+}
+
+var pressureConditions = []pressureCondition{
+	{
+		conditionType: v1.NodeMemoryPressure,
+		taintKey:      v1.TaintNodeMemoryPressure,
+		trueReason:    "KubeletHasInsufficientMemory",
+		trueMessage:   "kubelet has insufficient memory available",
+		falseReason:   "KubeletHasSufficientMemory",
+		falseMessage:  "kubelet has sufficient memory available",
+		underPressure: PressureProvider.IsUnderMemoryPressure,
+	},
+	{
+		conditionType: v1.NodeDiskPressure,
+		taintKey:      v1.TaintNodeDiskPressure,
+		trueReason:    "KubeletHasDiskPressure",
+		trueMessage:   "kubelet has disk pressure",
+		falseReason:   "KubeletHasNoDiskPressure",
+		falseMessage:  "kubelet has no disk pressure",
+		underPressure: PressureProvider.IsUnderDiskPressure,
+	},
+	{
+		conditionType: v1.NodePIDPressure,
+		taintKey:      v1.TaintNodePIDPressure,
+		trueReason:    "KubeletHasInsufficientPID",
+		trueMessage:   "kubelet has insufficient PID available",
+		falseReason:   "KubeletHasSufficientPID",
+		falseMessage:  "kubelet has sufficient PID available",
+		underPressure: PressureProvider.IsUnderPIDPressure,
+	},
+}
+
+// StartNodeStatusUpdater 定期把资源压力同步到节点的condition和node.kubernetes.io/*-pressure污点，
+// 上报节点上的卷、扩展资源和网络状态，并把节点的podCIDR同步给网络插件
+func StartNodeStatusUpdater(client kubernetes.Interface, nodeName string, provider PressureProvider,
+	volumesProvider VolumesProvider, devicesProvider DevicesProvider, networkProvider NetworkProvider, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		if err := updateNodeStatus(client, nodeName, provider, volumesProvider, devicesProvider, networkProvider); err != nil {
+			klog.ErrorS(err, "Unable to update node status", "node", nodeName)
+		}
+		if err := updateNodePressureTaints(client, nodeName, provider); err != nil {
+			klog.ErrorS(err, "Unable to update node pressure taints", "node", nodeName)
+		}
+	}, nodeStatusUpdateFrequency, stopCh)
+}
+
+// 更新压力condition、卷、扩展资源和网络的状态，没有变化时不更新
+func updateNodeStatus(client kubernetes.Interface, nodeName string, provider PressureProvider,
+	volumesProvider VolumesProvider, devicesProvider DevicesProvider, networkProvider NetworkProvider) error {
+	node, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting node %q: %v", nodeName, err)
+	}
+	networkProvider.UpdatePodCIDRs(nodePodCIDRs(node))
+	newNode := node.DeepCopy()
+	changed := false
+	now := metav1.Now()
+	for _, pc := range pressureConditions {
+		if setPressureCondition(newNode, pc, pc.underPressure(provider), now) {
+			changed = true
+		}
+	}
+	if setVolumesStatus(newNode, volumesProvider) {
+		changed = true
+	}
+	if setExtendedResources(newNode, devicesProvider) {
+		changed = true
+	}
+	if setNetworkCondition(newNode, networkProvider.NetworkStatus(), now) {
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	patchBytes, err := preparePatchBytesforNodeStatus(types.NodeName(nodeName), node, newNode)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	_, err = client.CoreV1().Nodes().Patch(context.Background(), nodeName, types.StrategicMergePatchType,
+		patchBytes, metav1.PatchOptions{}, "status")
+	metrics.NodeStatusPatchDuration.Observe(metrics.SinceInSeconds(start))
+	if err != nil {
+		metrics.NodeStatusPatchErrors.Inc()
+		return err
+	}
+	klog.V(2).InfoS("Updated node status", "node", nodeName)
+	return nil
+}
+
+// setVolumesStatus 设置volumesInUse和volumesAttached，获取已挂接的卷失败时保持原值，返回是否有变化
+// pkg/kubelet/nodestatus/setters.go VolumesInUse VolumesAttached
+func setVolumesStatus(node *v1.Node, volumesProvider VolumesProvider) bool {
+	changed := false
+	volumesInUse := volumesProvider.GetVolumesInUse()
+	if !equality.Semantic.DeepEqual(node.Status.VolumesInUse, volumesInUse) &&
+		!(len(node.Status.VolumesInUse) == 0 && len(volumesInUse) == 0) {
+		node.Status.VolumesInUse = volumesInUse
+		changed = true
+	}
+	volumesAttached, err := volumesProvider.GetVolumesAttached()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get attached volumes", "node", node.Name)
+		return changed
+	}
+	if !equality.Semantic.DeepEqual(node.Status.VolumesAttached, volumesAttached) &&
+		!(len(node.Status.VolumesAttached) == 0 && len(volumesAttached) == 0) {
+		node.Status.VolumesAttached = volumesAttached
+		changed = true
+	}
+	return changed
+}
+
+// setExtendedResources 设置设备插件资源的capacity和allocatable，已经删除的资源置为0，调度器不再把需要它的pod调度过来
+// pkg/kubelet/nodestatus/setters.go MachineInfo
+func setExtendedResources(node *v1.Node, devicesProvider DevicesProvider) bool {
+	capacity, allocatable, removed := devicesProvider.GetDevicePluginResourceCapacity()
+	if node.Status.Capacity == nil {
+		node.Status.Capacity = v1.ResourceList{}
+	}
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = v1.ResourceList{}
+	}
+	changed := false
+	set := func(list v1.ResourceList, name v1.ResourceName, value resource.Quantity) {
+		if old, ok := list[name]; ok && old.Cmp(value) == 0 {
+			return
+		}
+		list[name] = value
+		changed = true
+	}
+	for name, value := range capacity {
+		set(node.Status.Capacity, name, value)
+	}
+	for name, value := range allocatable {
+		set(node.Status.Allocatable, name, value)
+	}
+	for _, name := range removed {
+		set(node.Status.Capacity, v1.ResourceName(name), *resource.NewQuantity(0, resource.DecimalSI))
+		set(node.Status.Allocatable, v1.ResourceName(name), *resource.NewQuantity(0, resource.DecimalSI))
+	}
+	return changed
+}
+
+// nodePodCIDRs 双栈时使用podCIDRs，旧版本的apiServer只设置podCIDR
+func nodePodCIDRs(node *v1.Node) []string {
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+	return nil
+}
+
+// setNetworkCondition 网络插件没有就绪时NetworkUnavailable为True，调度器不会把非hostNetwork的pod调度过来，返回是否有变化
+func setNetworkCondition(node *v1.Node, networkErr error, now metav1.Time) bool {
+	pc := pressureCondition{
+		conditionType: v1.NodeNetworkUnavailable,
+		trueReason:    "NetworkPluginNotReady",
+		falseReason:   "NetworkPluginReady",
+		falseMessage:  "network plugin is ready",
+	}
+	if networkErr != nil {
+		pc.trueMessage = fmt.Sprintf("network plugin is not ready: %v", networkErr)
+	}
+	return setPressureCondition(node, pc, networkErr != nil, now)
+}
+
+// setPressureCondition 设置压力condition，状态变化时更新LastTransitionTime，返回是否有变化
+func setPressureCondition(node *v1.Node, pc pressureCondition, underPressure bool, now metav1.Time) bool {
+	status, reason, message := v1.ConditionFalse, pc.falseReason, pc.falseMessage
+	if underPressure {
+		status, reason, message = v1.ConditionTrue, pc.trueReason, pc.trueMessage
+	}
+	for i := range node.Status.Conditions {
+		condition := &node.Status.Conditions[i]
+		if condition.Type != pc.conditionType {
+			continue
+		}
+		if condition.Status == status && condition.Reason == reason {
+			return false
+		}
+		if condition.Status != status {
+			condition.LastTransitionTime = now
+		}
+		condition.Status = status
+		condition.Reason = reason
+		condition.Message = message
+		condition.LastHeartbeatTime = now
+		return true
+	}
+	node.Status.Conditions = append(node.Status.Conditions, v1.NodeCondition{
+		Type:               pc.conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	})
+	return true
+}
+
+// 有压力时添加NoSchedule污点，压力解除后删除，其他污点保持不变
+func updateNodePressureTaints(client kubernetes.Interface, nodeName string, provider PressureProvider) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		taints, changed := pressureTaints(node.Spec.Taints, provider)
+		if !changed {
+			return nil
+		}
+		newNode := node.DeepCopy()
+		newNode.Spec.Taints = taints
+		if _, err = client.CoreV1().Nodes().Update(context.Background(), newNode, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		klog.V(2).InfoS("Updated node pressure taints", "node", nodeName, "taints", taints)
+		return nil
+	})
+}
+
+// pressureTaints 根据当前的压力计算节点的污点，返回是否有变化
+func pressureTaints(taints []v1.Taint, provider PressureProvider) ([]v1.Taint, bool) {
+	pressureKeys := map[string]bool{}
+	for _, pc := range pressureConditions {
+		pressureKeys[pc.taintKey] = pc.underPressure(provider)
+	}
+
+	result := []v1.Taint{}
+	changed := false
+	existing := map[string]bool{}
+	for _, taint := range taints {
+		underPressure, isPressureTaint := pressureKeys[taint.Key]
+		if isPressureTaint && taint.Effect == v1.TaintEffectNoSchedule {
+			if !underPressure {
+				changed = true
+				continue
+			}
+			existing[taint.Key] = true
+		}
+		result = append(result, taint)
+	}
+	for _, pc := range pressureConditions {
+		if pressureKeys[pc.taintKey] && !existing[pc.taintKey] {
+			result = append(result, v1.Taint{Key: pc.taintKey, Effect: v1.TaintEffectNoSchedule})
+			changed = true
+		}
+	}
+	return result, changed
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"testing"
+	"time"
+)
+
+// applyNodeStatusPatch 把注册时生成的patch应用到apiServer上的节点
+func applyNodeStatusPatch(t *testing.T, oldNode, newNode *corev1.Node) *corev1.Node {
+	t.Helper()
+	patchBytes, err := preparePatchBytesforNodeStatus(types.NodeName(oldNode.Name), oldNode, newNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldData, err := json.Marshal(oldNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := strategicpatch.StrategicMergePatch(oldData, patchBytes, corev1.Node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchedNode := &corev1.Node{}
+	if err = json.Unmarshal(patched, patchedNode); err != nil {
+		t.Fatal(err)
+	}
+	return patchedNode
+}
+
+// kubelet重启注册时，节点状态更新循环设置的condition不能被删除
+func TestSetNodeStatusKeepsExistingConditions(t *testing.T) {
+	transition := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeNetworkUnavailable, Status: corev1.ConditionTrue, Reason: "NetworkPluginNotReady", LastTransitionTime: transition},
+			{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue, Reason: "KubeletHasInsufficientMemory", LastTransitionTime: transition},
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady", LastTransitionTime: transition},
+			{Type: "OutOfDisk", Status: corev1.ConditionTrue, Reason: "KubeletOutOfDisk", LastTransitionTime: transition},
+		}},
+	}
+	newNode := node.DeepCopy()
+	setNodeStatus(newNode)
+
+	conditions := map[corev1.NodeConditionType]corev1.NodeCondition{}
+	for _, condition := range newNode.Status.Conditions {
+		if _, ok := conditions[condition.Type]; ok {
+			t.Errorf("duplicate condition %s", condition.Type)
+		}
+		conditions[condition.Type] = condition
+	}
+	if len(conditions) != 4 {
+		t.Errorf("expected 4 conditions, got %+v", newNode.Status.Conditions)
+	}
+	for _, conditionType := range []corev1.NodeConditionType{corev1.NodeNetworkUnavailable, corev1.NodeMemoryPressure} {
+		if condition := conditions[conditionType]; condition.Status != corev1.ConditionTrue || !condition.LastTransitionTime.Equal(&transition) {
+			t.Errorf("expected condition %s to be kept, got %+v", conditionType, condition)
+		}
+	}
+	// 状态没有变化时保留LastTransitionTime
+	if ready := conditions[corev1.NodeReady]; !ready.LastTransitionTime.Equal(&transition) || ready.LastHeartbeatTime.IsZero() {
+		t.Errorf("expected Ready to keep its transition time and get a new heartbeat, got %+v", ready)
+	}
+	if outOfDisk := conditions["OutOfDisk"]; outOfDisk.Status != corev1.ConditionFalse || outOfDisk.LastTransitionTime.Equal(&transition) {
+		t.Errorf("expected OutOfDisk to transition to False, got %+v", outOfDisk)
+	}
+
+	// patch中不能有删除condition的指令
+	if patchedNode := applyNodeStatusPatch(t, node, newNode); len(patchedNode.Status.Conditions) != 4 {
+		t.Errorf("expected patch to keep all conditions, got %+v", patchedNode.Status.Conditions)
+	}
+}
+
+// kubelet重启注册时，设备插件的扩展资源不能被删除，cpu、内存和pod数量按kubelet的值更新
+func TestSetNodeStatusKeepsExtendedResources(t *testing.T) {
+	gpu := corev1.ResourceName("example.com/gpu")
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1000"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+				gpu:                   resource.MustParse("4"),
+			},
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1000"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+				gpu:                   resource.MustParse("3"),
+			},
+		},
+	}
+	newNode := node.DeepCopy()
+	setNodeStatus(newNode)
+
+	patchedNode := applyNodeStatusPatch(t, node, newNode)
+	expected := map[string]struct {
+		resources corev1.ResourceList
+		gpu       string
+	}{
+		"capacity":    {resources: patchedNode.Status.Capacity, gpu: "4"},
+		"allocatable": {resources: patchedNode.Status.Allocatable, gpu: "3"},
+	}
+	for name, e := range expected {
+		if value, ok := e.resources[gpu]; !ok || value.Cmp(resource.MustParse(e.gpu)) != 0 {
+			t.Errorf("expected %s %s to be kept as %s, got %v", name, gpu, e.gpu, e.resources)
+		}
+		if value := e.resources[corev1.ResourceCPU]; value.Cmp(nodeCapacity()[corev1.ResourceCPU]) != 0 {
+			t.Errorf("expected %s cpu to be updated, got %s", name, value.String())
+		}
+		if value := e.resources[corev1.ResourcePods]; value.Cmp(resource.MustParse("200")) != 0 {
+			t.Errorf("expected %s pods to be set, got %s", name, value.String())
+		}
+	}
+}
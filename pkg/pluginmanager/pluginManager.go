@@ -0,0 +1,209 @@
+package pluginmanager
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+	"k8s.io/utils/clock"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 扫描注册目录的间隔
+	reconcilerPeriod = time.Second
+	// 连接插件注册socket的超时时间
+	dialTimeout = 10 * time.Second
+
+	// 注册失败后重试的退避时间
+	registerBackOffPeriod    = time.Second
+	maxRegisterBackOffPeriod = time.Minute
+)
+
+// PluginHandler 一种插件类型的注册处理，如CSIPlugin
+// pkg/kubelet/pluginmanager/cache/types.go
+type PluginHandler interface {
+	// ValidatePlugin 检查插件名、endpoint和支持的版本
+	ValidatePlugin(pluginName string, endpoint string, versions []string) error
+	// RegisterPlugin 插件校验通过后注册
+	RegisterPlugin(pluginName, endpoint string, versions []string) error
+	// DeRegisterPlugin 插件的注册socket被删除后注销
+	DeRegisterPlugin(pluginName string)
+}
+
+// 已经注册的插件
+type registeredPlugin struct {
+	pluginType string
+	name       string
+	// socket文件的修改时间，插件重启后重新创建socket需要重新注册
+	modTime time.Time
+}
+
+// PluginManager 监视插件注册目录中的socket，调用插件的GetInfo，交给对应类型的handler注册
+// pkg/kubelet/pluginmanager/plugin_manager.go
+type PluginManager struct {
+	socketDir string
+
+	lock     sync.Mutex
+	handlers map[string]PluginHandler
+	// key为socket路径
+	registered map[string]registeredPlugin
+	backOff    *flowcontrol.Backoff
+	clock      clock.Clock
+}
+
+// NewPluginManager 创建插件管理器，socketDir一般为<rootDirectory>/plugins_registry
+func NewPluginManager(socketDir string, clock clock.Clock) *PluginManager {
+	backOff := flowcontrol.NewBackOff(registerBackOffPeriod, maxRegisterBackOffPeriod)
+	backOff.Clock = clock
+	return &PluginManager{
+		socketDir:  socketDir,
+		handlers:   map[string]PluginHandler{},
+		registered: map[string]registeredPlugin{},
+		backOff:    backOff,
+		clock:      clock,
+	}
+}
+
+// AddHandler 注册一种插件类型的handler，需要在Run之前调用
+func (this *PluginManager) AddHandler(pluginType string, handler PluginHandler) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.handlers[pluginType] = handler
+}
+
+// Run 创建注册目录并定期扫描
+func (this *PluginManager) Run(stopCh <-chan struct{}) {
+	if err := os.MkdirAll(this.socketDir, 0750); err != nil {
+		klog.ErrorS(err, "Failed to create plugin registration directory", "path", this.socketDir)
+		return
+	}
+	klog.InfoS("Starting plugin manager", "path", this.socketDir)
+	go wait.Until(this.reconcile, reconcilerPeriod, stopCh)
+}
+
+// reconcile 注册新出现或重新创建的socket，注销已经删除的socket
+// pkg/kubelet/pluginmanager/reconciler/reconciler.go
+func (this *PluginManager) reconcile() {
+	sockets, err := this.listSockets()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list plugin sockets", "path", this.socketDir)
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for socketPath, plugin := range this.registered {
+		if modTime, ok := sockets[socketPath]; ok && modTime.Equal(plugin.modTime) {
+			continue
+		}
+		this.deregister(socketPath, plugin)
+	}
+	for socketPath, modTime := range sockets {
+		if _, ok := this.registered[socketPath]; ok {
+			continue
+		}
+		if this.backOff.IsInBackOffSinceUpdate(socketPath, this.clock.Now()) {
+			continue
+		}
+		if err = this.register(socketPath, modTime); err != nil {
+			klog.ErrorS(err, "Failed to register plugin", "path", socketPath)
+			this.backOff.Next(socketPath, this.clock.Now())
+			continue
+		}
+		this.backOff.Reset(socketPath)
+	}
+}
+
+// listSockets 注册目录下的socket文件，.开头的文件忽略
+func (this *PluginManager) listSockets() (map[string]time.Time, error) {
+	sockets := map[string]time.Time{}
+	err := filepath.Walk(this.socketDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != this.socketDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSocket != 0 {
+			sockets[path] = info.ModTime()
+		}
+		return nil
+	})
+	return sockets, err
+}
+
+// register 调用GetInfo获取插件信息，交给handler校验和注册，并把结果通知插件
+// pkg/kubelet/pluginmanager/operationexecutor/operation_generator.go GenerateRegisterPluginFunc
+func (this *PluginManager) register(socketPath string, modTime time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("failed to dial socket %s: %v", socketPath, err)
+	}
+	defer conn.Close()
+
+	client := registerapi.NewRegistrationClient(conn)
+	info, err := client.GetInfo(ctx, &registerapi.InfoRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get plugin info using RPC GetInfo at socket %s: %v", socketPath, err)
+	}
+	handler, ok := this.handlers[info.Type]
+	if !ok {
+		err = fmt.Errorf("no handler registered for plugin type: %s at socket %s", info.Type, socketPath)
+		notifyPlugin(ctx, client, false, err)
+		return err
+	}
+	endpoint := info.Endpoint
+	if endpoint == "" {
+		endpoint = socketPath
+	}
+	if err = handler.ValidatePlugin(info.Name, endpoint, info.SupportedVersions); err != nil {
+		err = fmt.Errorf("plugin registration failed with err: %v", err)
+		notifyPlugin(ctx, client, false, err)
+		return err
+	}
+	if err = handler.RegisterPlugin(info.Name, endpoint, info.SupportedVersions); err != nil {
+		notifyPlugin(ctx, client, false, err)
+		return err
+	}
+	this.registered[socketPath] = registeredPlugin{pluginType: info.Type, name: info.Name, modTime: modTime}
+	notifyPlugin(ctx, client, true, nil)
+	klog.InfoS("Registered plugin", "type", info.Type, "name", info.Name, "endpoint", endpoint)
+	return nil
+}
+
+func (this *PluginManager) deregister(socketPath string, plugin registeredPlugin) {
+	if handler, ok := this.handlers[plugin.pluginType]; ok {
+		handler.DeRegisterPlugin(plugin.name)
+	}
+	delete(this.registered, socketPath)
+	klog.InfoS("Deregistered plugin", "type", plugin.pluginType, "name", plugin.name, "path", socketPath)
+}
+
+// notifyPlugin 把注册结果通知插件，通知失败只记录日志
+func notifyPlugin(ctx context.Context, client registerapi.RegistrationClient, registered bool, regErr error) {
+	status := &registerapi.RegistrationStatus{PluginRegistered: registered}
+	if regErr != nil {
+		status.Error = regErr.Error()
+	}
+	if _, err := client.NotifyRegistrationStatus(ctx, status); err != nil {
+		klog.ErrorS(err, "Failed to send registration status")
+	}
+}
@@ -0,0 +1,232 @@
+package podconfig
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+const (
+	// ConfigSourceAnnotationKey pod的来源
+	ConfigSourceAnnotationKey = "kubernetes.io/config.source"
+	// ConfigHashAnnotationKey 静态pod内容的hash，和uid相同，mirror pod通过它判断是否和静态pod一致
+	ConfigHashAnnotationKey = "kubernetes.io/config.hash"
+	// ConfigMirrorAnnotationKey mirror pod的注解，值为对应静态pod的hash
+	ConfigMirrorAnnotationKey = v1.MirrorPodAnnotationKey
+
+	// FileSource 来自静态pod清单目录
+	FileSource = "file"
+	// HTTPSource 来自静态pod清单URL
+	HTTPSource = "http"
+	// ApiserverSource 来自apiServer
+	ApiserverSource = "api"
+)
+
+// PodsUpdateFunc 来源每次读取到的全量pod
+type PodsUpdateFunc func(pods []*v1.Pod)
+
+// GetPodSource pod的来源，没有来源注解的是apiServer中的pod
+func GetPodSource(pod *v1.Pod) string {
+	if source, ok := pod.Annotations[ConfigSourceAnnotationKey]; ok {
+		return source
+	}
+	return ApiserverSource
+}
+
+// IsStaticPod 来自清单目录或URL的pod
+func IsStaticPod(pod *v1.Pod) bool {
+	return GetPodSource(pod) != ApiserverSource
+}
+
+// IsMirrorPod kubelet为静态pod在apiServer中创建的pod，只用于展示，不在节点上运行
+func IsMirrorPod(pod *v1.Pod) bool {
+	_, ok := pod.Annotations[ConfigMirrorAnnotationKey]
+	return ok
+}
+
+// GetPodHash 静态pod的hash
+func GetPodHash(pod *v1.Pod) string {
+	return pod.Annotations[ConfigHashAnnotationKey]
+}
+
+// GetPodFullName namespace/name，静态pod和mirror pod的全名相同
+func GetPodFullName(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// decodePods 解析yaml或json格式的Pod或PodList
+func decodePods(data []byte) ([]*v1.Pod, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := obj.(type) {
+	case *v1.Pod:
+		return []*v1.Pod{obj}, nil
+	case *v1.PodList:
+		pods := make([]*v1.Pod, 0, len(obj.Items))
+		for i := range obj.Items {
+			pods = append(pods, &obj.Items[i])
+		}
+		return pods, nil
+	default:
+		return nil, fmt.Errorf("unsupported object %s, only Pod and PodList are allowed", kindOf(obj))
+	}
+}
+
+func kindOf(obj runtime.Object) string {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Kind == "" {
+		return fmt.Sprintf("%T", obj)
+	}
+	return gvk.String()
+}
+
+// applyDefaults 设置静态pod的uid、名字、节点和来源注解，source为清单文件的路径或URL
+// 没有指定uid时由pod的内容、节点名和来源计算，清单不变时kubelet重启后uid也不变
+// pkg/kubelet/config/common.go applyDefaults
+func applyDefaults(pod *v1.Pod, source, sourceType string, nodeName types.NodeName) error {
+	setPodDefaults(pod)
+	if len(pod.UID) == 0 {
+		data, err := json.Marshal(pod)
+		if err != nil {
+			return err
+		}
+		hasher := md5.New()
+		hasher.Write(data)
+		fmt.Fprintf(hasher, "host:%s", nodeName)
+		fmt.Fprintf(hasher, "%s:%s", sourceType, source)
+		pod.UID = types.UID(hex.EncodeToString(hasher.Sum(nil)))
+	}
+	// 名字加上节点名，避免不同节点的同名静态pod在apiServer中冲突
+	pod.Name = pod.Name + "-" + strings.ToLower(string(nodeName))
+	if pod.Namespace == "" {
+		pod.Namespace = metav1.NamespaceDefault
+	}
+	pod.Spec.NodeName = string(nodeName)
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[ConfigHashAnnotationKey] = string(pod.UID)
+	pod.Annotations[ConfigSourceAnnotationKey] = sourceType
+	// 节点出现NoExecute污点时也不驱逐静态pod
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, v1.Toleration{
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffectNoExecute,
+	})
+	pod.Status.Phase = v1.PodPending
+	return validatePod(pod)
+}
+
+// setPodDefaults 静态pod不经过apiServer，需要设置apiServer会设置的默认值
+// pkg/apis/core/v1/defaults.go
+func setPodDefaults(pod *v1.Pod) {
+	spec := &pod.Spec
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = v1.RestartPolicyAlways
+	}
+	if spec.DNSPolicy == "" {
+		spec.DNSPolicy = v1.DNSClusterFirst
+	}
+	if spec.TerminationGracePeriodSeconds == nil {
+		period := int64(v1.DefaultTerminationGracePeriodSeconds)
+		spec.TerminationGracePeriodSeconds = &period
+	}
+	if spec.SchedulerName == "" {
+		spec.SchedulerName = v1.DefaultSchedulerName
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	for i := range spec.InitContainers {
+		setContainerDefaults(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		setContainerDefaults(&spec.Containers[i])
+	}
+}
+
+func setContainerDefaults(c *v1.Container) {
+	if c.ImagePullPolicy == "" {
+		// 使用digest时不会变化；没有tag或tag为latest时总是拉取
+		tag := ""
+		if i := strings.LastIndex(c.Image, ":"); i > strings.LastIndex(c.Image, "/") {
+			tag = c.Image[i+1:]
+		}
+		if !strings.Contains(c.Image, "@") && (tag == "" || tag == "latest") {
+			c.ImagePullPolicy = v1.PullAlways
+		} else {
+			c.ImagePullPolicy = v1.PullIfNotPresent
+		}
+	}
+	if c.TerminationMessagePath == "" {
+		c.TerminationMessagePath = v1.TerminationMessagePathDefault
+	}
+	if c.TerminationMessagePolicy == "" {
+		c.TerminationMessagePolicy = v1.TerminationMessageReadFile
+	}
+	for i := range c.Ports {
+		if c.Ports[i].Protocol == "" {
+			c.Ports[i].Protocol = v1.ProtocolTCP
+		}
+	}
+	for _, probe := range []*v1.Probe{c.LivenessProbe, c.ReadinessProbe, c.StartupProbe} {
+		if probe != nil && probe.HTTPGet != nil && probe.HTTPGet.Scheme == "" {
+			probe.HTTPGet.Scheme = v1.URISchemeHTTP
+		}
+	}
+}
+
+// validatePod 检查kubelet运行pod所需的字段，完整的校验在创建mirror pod时由apiServer完成
+func validatePod(pod *v1.Pod) error {
+	if msgs := validation.IsDNS1123Subdomain(pod.Name); len(msgs) > 0 {
+		return fmt.Errorf("invalid pod name %q: %s", pod.Name, strings.Join(msgs, ", "))
+	}
+	if msgs := validation.IsDNS1123Label(pod.Namespace); len(msgs) > 0 {
+		return fmt.Errorf("invalid pod namespace %q: %s", pod.Namespace, strings.Join(msgs, ", "))
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+	names := map[string]bool{}
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if msgs := validation.IsDNS1123Label(c.Name); len(msgs) > 0 {
+				return fmt.Errorf("invalid container name %q: %s", c.Name, strings.Join(msgs, ", "))
+			}
+			if names[c.Name] {
+				return fmt.Errorf("duplicate container name %q", c.Name)
+			}
+			if c.Image == "" {
+				return fmt.Errorf("container %q has no image", c.Name)
+			}
+			names[c.Name] = true
+		}
+	}
+	return nil
+}
+
+// filterDuplicates 同一个来源中全名相同的pod只保留第一个
+func filterDuplicates(pods []*v1.Pod, source string) []*v1.Pod {
+	seen := map[string]bool{}
+	ret := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		fullName := GetPodFullName(pod)
+		if seen[fullName] {
+			klog.InfoS("Pod with duplicate full name ignored", "pod", klog.KObj(pod), "source", source)
+			continue
+		}
+		seen[fullName] = true
+		ret = append(ret, pod)
+	}
+	return ret
+}
@@ -0,0 +1,114 @@
+package podconfig
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceFile 定期读取静态pod清单，path可以是目录或单个文件
+// 目录中每个文件是一个yaml或json格式的Pod，.开头的文件忽略
+// pkg/kubelet/config/file.go
+type SourceFile struct {
+	path     string
+	nodeName types.NodeName
+	period   time.Duration
+	updates  PodsUpdateFunc
+}
+
+// NewSourceFile 每个周期把读取到的全部静态pod交给updates
+func NewSourceFile(path string, nodeName types.NodeName, period time.Duration, updates PodsUpdateFunc) *SourceFile {
+	return &SourceFile{
+		path:     filepath.Clean(path),
+		nodeName: nodeName,
+		period:   period,
+		updates:  updates,
+	}
+}
+
+// Run 立即读取一次，之后按周期读取
+func (this *SourceFile) Run(stopCh <-chan struct{}) {
+	klog.InfoS("Watching static pod path", "path", this.path, "period", this.period)
+	go wait.Until(func() {
+		if err := this.listConfig(); err != nil {
+			klog.ErrorS(err, "Unable to read static pod config", "path", this.path)
+		}
+	}, this.period, stopCh)
+}
+
+// listConfig 读取失败时保留上一次的结果，路径不存在时表示没有静态pod
+func (this *SourceFile) listConfig() error {
+	info, err := os.Stat(this.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			klog.V(4).InfoS("Static pod path does not exist, ignoring", "path", this.path)
+			this.updates([]*v1.Pod{})
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		pod, err := this.extractFromFile(this.path)
+		if err != nil {
+			return err
+		}
+		this.updates([]*v1.Pod{pod})
+		return nil
+	}
+	pods, err := this.extractFromDir()
+	if err != nil {
+		return err
+	}
+	this.updates(filterDuplicates(pods, FileSource))
+	return nil
+}
+
+// extractFromDir 单个文件解析失败时跳过该文件，不影响其他静态pod
+func (this *SourceFile) extractFromDir() ([]*v1.Pod, error) {
+	entries, err := os.ReadDir(this.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory %q: %v", this.path, err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	pods := []*v1.Pod{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(this.path, entry.Name())
+		pod, err := this.extractFromFile(path)
+		if err != nil {
+			klog.ErrorS(err, "Could not process manifest file", "path", path)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (this *SourceFile) extractFromFile(path string) (*v1.Pod, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := decodePods(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid pod: %v", path, err)
+	}
+	if len(pods) != 1 {
+		return nil, fmt.Errorf("%s: manifest file must contain exactly one pod", path)
+	}
+	if err = applyDefaults(pods[0], path, FileSource, this.nodeName); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return pods[0], nil
+}
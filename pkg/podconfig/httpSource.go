@@ -0,0 +1,85 @@
+package podconfig
+
+import (
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"net/http"
+	"time"
+)
+
+// 请求静态pod清单URL的超时时间
+const httpTimeout = 10 * time.Second
+
+// SourceURL 定期从URL获取静态pod清单，返回Pod或PodList
+// pkg/kubelet/config/http.go
+type SourceURL struct {
+	url      string
+	header   http.Header
+	nodeName types.NodeName
+	period   time.Duration
+	updates  PodsUpdateFunc
+	client   *http.Client
+}
+
+// NewSourceURL header为请求时附加的头，可以为空
+func NewSourceURL(url string, header http.Header, nodeName types.NodeName, period time.Duration, updates PodsUpdateFunc) *SourceURL {
+	return &SourceURL{
+		url:      url,
+		header:   header,
+		nodeName: nodeName,
+		period:   period,
+		updates:  updates,
+		client:   &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// Run 立即获取一次，之后按周期获取
+func (this *SourceURL) Run(stopCh <-chan struct{}) {
+	klog.InfoS("Watching static pod URL", "url", this.url, "period", this.period)
+	go wait.Until(func() {
+		if err := this.extractFromURL(); err != nil {
+			klog.ErrorS(err, "Unable to read static pod config from URL", "url", this.url)
+		}
+	}, this.period, stopCh)
+}
+
+// extractFromURL 请求失败时保留上一次的结果，返回内容为空时表示没有静态pod
+func (this *SourceURL) extractFromURL() error {
+	req, err := http.NewRequest(http.MethodGet, this.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = this.header.Clone()
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", this.url, resp.Status)
+	}
+	if len(data) == 0 {
+		this.updates([]*v1.Pod{})
+		return nil
+	}
+
+	pods, err := decodePods(data)
+	if err != nil {
+		return fmt.Errorf("%v: received invalid pod manifest: %v", this.url, err)
+	}
+	for _, pod := range pods {
+		if err = applyDefaults(pod, this.url, HTTPSource, this.nodeName); err != nil {
+			return fmt.Errorf("%v: %v", this.url, err)
+		}
+	}
+	this.updates(filterDuplicates(pods, HTTPSource))
+	return nil
+}
@@ -0,0 +1,28 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"mykubelet/pkg/container"
+	"time"
+)
+
+// ExecProbe 在容器中执行命令，退出码为0即成功
+func ExecProbe(runtime container.Runtime, containerID container.ContainerID, cmd []string, timeout time.Duration) (Result, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := runtime.ExecSync(ctx, containerID.ID, cmd, timeout)
+	if err != nil {
+		var exitErr *container.ExitError
+		if errors.As(err, &exitErr) {
+			return Failure, string(exitErr.Output), nil
+		}
+		// 超时视为探测失败
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Failure, "command timed out", nil
+		}
+		return Unknown, "", err
+	}
+	return Success, string(output), nil
+}
@@ -0,0 +1,81 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"testing"
+	"time"
+)
+
+func TestExecProbe(t *testing.T) {
+	containerID := container.BuildContainerID("fake", "c1")
+	tests := []struct {
+		name     string
+		execFn   func(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, error)
+		expected Result
+		output   string
+		err      bool
+	}{
+		{
+			name: "exit code 0",
+			execFn: func(_ context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+				return []byte("ok"), nil
+			},
+			expected: Success,
+			output:   "ok",
+		},
+		{
+			name: "non-zero exit code",
+			execFn: func(_ context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+				return nil, &container.ExitError{Code: 1, Output: []byte("unhealthy")}
+			},
+			expected: Failure,
+			output:   "unhealthy",
+		},
+		{
+			name: "timeout",
+			execFn: func(ctx context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			expected: Failure,
+			output:   "command timed out",
+		},
+		{
+			name: "runtime error",
+			execFn: func(_ context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+				return nil, errors.New("container not found")
+			},
+			expected: Unknown,
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runtime := containertest.NewFakeRuntime()
+			var gotCmd []string
+			runtime.ExecSyncFn = func(ctx context.Context, id string, cmd []string, timeout time.Duration) ([]byte, error) {
+				if id != containerID.ID {
+					t.Errorf("expected container %q, got %q", containerID.ID, id)
+				}
+				gotCmd = cmd
+				return test.execFn(ctx, id, cmd, timeout)
+			}
+			result, output, err := ExecProbe(runtime, containerID, []string{"cat", "/tmp/healthy"}, 100*time.Millisecond)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+			if output != test.output {
+				t.Errorf("expected output %q, got %q", test.output, output)
+			}
+			if len(gotCmd) != 2 || gotCmd[0] != "cat" {
+				t.Errorf("unexpected command %v", gotCmd)
+			}
+		})
+	}
+}
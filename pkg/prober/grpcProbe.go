@@ -0,0 +1,50 @@
+package prober
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"strconv"
+	"time"
+)
+
+// GRPCProbe 调用标准的grpc健康检查服务，返回SERVING即成功
+func GRPCProbe(host string, port int, service string, timeout time.Duration) (Result, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUserAgent(ProbeUserAgent),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return Failure, fmt.Sprintf("timeout: failed to connect service %q within %v", addr, timeout), nil
+		}
+		return Failure, fmt.Sprintf("error: failed to connect service at %q: %v", addr, err), nil
+	}
+	defer conn.Close()
+
+	resp, err := grpchealth.NewHealthClient(conn).Check(ctx, &grpchealth.HealthCheckRequest{Service: service})
+	if err != nil {
+		st, ok := status.FromError(err)
+		if ok && st.Code() == codes.DeadlineExceeded {
+			return Failure, fmt.Sprintf("timeout: health rpc did not complete within %v", timeout), nil
+		}
+		if ok && st.Code() == codes.Unimplemented {
+			return Failure, fmt.Sprintf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health): %s", st.Message()), nil
+		}
+		return Failure, fmt.Sprintf("error: health rpc probe failed: %v", err), nil
+	}
+	if resp.GetStatus() != grpchealth.HealthCheckResponse_SERVING {
+		return Failure, fmt.Sprintf("service unhealthy (responded with %q)", resp.GetStatus().String()), nil
+	}
+	return Success, "service healthy", nil
+}
@@ -0,0 +1,88 @@
+package prober
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	grpchealth "google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startHealthServer(t *testing.T, register bool) (*health.Server, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	if register {
+		grpchealth.RegisterHealthServer(server, healthServer)
+	}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	_, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return healthServer, port
+}
+
+func TestGRPCProbe(t *testing.T) {
+	healthServer, port := startHealthServer(t, true)
+	healthServer.SetServingStatus("serving", grpchealth.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("not-serving", grpchealth.HealthCheckResponse_NOT_SERVING)
+
+	tests := []struct {
+		service  string
+		expected Result
+		output   string
+	}{
+		// 空服务名表示整个服务器的状态，默认为SERVING
+		{service: "", expected: Success, output: "service healthy"},
+		{service: "serving", expected: Success, output: "service healthy"},
+		{service: "not-serving", expected: Failure, output: "NOT_SERVING"},
+		{service: "unknown", expected: Failure, output: "health rpc probe failed"},
+	}
+	for _, test := range tests {
+		result, output, err := GRPCProbe("127.0.0.1", port, test.service, time.Second)
+		if err != nil {
+			t.Fatalf("service %q: unexpected error: %v", test.service, err)
+		}
+		if result != test.expected {
+			t.Errorf("service %q: expected %v, got %v (output %q)", test.service, test.expected, result, output)
+		}
+		if !strings.Contains(output, test.output) {
+			t.Errorf("service %q: expected output to contain %q, got %q", test.service, test.output, output)
+		}
+	}
+}
+
+func TestGRPCProbeUnimplemented(t *testing.T) {
+	_, port := startHealthServer(t, false)
+	result, output, err := GRPCProbe("127.0.0.1", port, "", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != Failure || !strings.Contains(output, "does not implement the grpc health protocol") {
+		t.Errorf("expected unimplemented failure, got %v %q", result, output)
+	}
+}
+
+func TestGRPCProbeConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	_ = listener.Close()
+
+	result, output, err := GRPCProbe("127.0.0.1", port, "", 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != Failure || !strings.Contains(output, "failed to connect") {
+		t.Errorf("expected connection failure, got %v %q", result, output)
+	}
+}
@@ -0,0 +1,90 @@
+package prober
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRespBodyLength = 10 * 1 << 10 // 10KB
+
+var ProbeUserAgent = "kube-probe/1.28"
+
+// HTTPProbe 发起GET请求，200 <= code < 400 即成功
+// 不跟随跳转到其他主机，https不校验证书，和kubelet的行为保持一致
+func HTTPProbe(req *http.Request, timeout time.Duration) (Result, string, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyURL(nil),
+		},
+		CheckRedirect: redirectChecker(req.URL.Hostname()),
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return Failure, err.Error(), nil
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxRespBodyLength))
+	if err != nil {
+		return Failure, "", err
+	}
+	body := string(b)
+	if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusBadRequest {
+		return Success, body, nil
+	}
+	return Failure, fmt.Sprintf("HTTP probe failed with statuscode: %d", res.StatusCode), nil
+}
+
+func redirectChecker(host string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Hostname() != host {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
+
+// NewProbeRequest 根据HTTPGetAction构建探测请求
+func NewProbeRequest(scheme, host string, port int, path string, headers http.Header) (*http.Request, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(scheme)
+	u.Host = net.JoinHostPort(host, strconv.Itoa(port))
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if _, ok := headers["User-Agent"]; !ok {
+		headers.Set("User-Agent", ProbeUserAgent)
+	}
+	if _, ok := headers["Accept"]; !ok {
+		headers.Set("Accept", "*/*")
+	}
+	req.Header = headers
+	req.Host = headers.Get("Host")
+	return req, nil
+}
@@ -0,0 +1,133 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestProbeRequest(t *testing.T, serverURL, path string, headers http.Header) *http.Request {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := NewProbeRequest(u.Scheme, u.Hostname(), port, path, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestHTTPProbe(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/notfound", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-error", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/error", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-other-host", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://other.invalid/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect-loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		expected Result
+		output   string
+	}{
+		{path: "/ok", expected: Success, output: "ok"},
+		{path: "/error", expected: Failure, output: "HTTP probe failed with statuscode: 500"},
+		{path: "/notfound", expected: Failure, output: "HTTP probe failed with statuscode: 404"},
+		{path: "/redirect", expected: Success, output: "ok"},
+		{path: "/redirect-error", expected: Failure, output: "HTTP probe failed with statuscode: 500"},
+		// 跳转到其他主机时不跟随，3xx视为成功
+		{path: "/redirect-other-host", expected: Success},
+		{path: "/redirect-loop", expected: Failure},
+		{path: "/slow", expected: Failure},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			req := newTestProbeRequest(t, server.URL, test.path, nil)
+			result, output, err := HTTPProbe(req, 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("expected result %v, got %v (output %q)", test.expected, result, output)
+			}
+			if test.output != "" && output != test.output {
+				t.Errorf("expected output %q, got %q", test.output, output)
+			}
+		})
+	}
+}
+
+func TestHTTPProbeHeaders(t *testing.T) {
+	var got http.Header
+	var host string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		host = r.Host
+	}))
+	defer server.Close()
+
+	headers := http.Header{}
+	headers.Set("X-Custom", "value")
+	headers.Set("Host", "example.com")
+	req := newTestProbeRequest(t, server.URL, "healthz", headers)
+	if req.URL.Path != "/healthz" {
+		t.Errorf("expected path /healthz, got %q", req.URL.Path)
+	}
+	if result, output, err := HTTPProbe(req, time.Second); err != nil || result != Success {
+		t.Fatalf("expected success, got %v %q %v", result, output, err)
+	}
+	if got.Get("User-Agent") != ProbeUserAgent {
+		t.Errorf("expected user agent %q, got %q", ProbeUserAgent, got.Get("User-Agent"))
+	}
+	if got.Get("Accept") != "*/*" {
+		t.Errorf("expected default accept header, got %q", got.Get("Accept"))
+	}
+	if got.Get("X-Custom") != "value" {
+		t.Errorf("expected custom header, got %q", got.Get("X-Custom"))
+	}
+	if host != "example.com" {
+		t.Errorf("expected host example.com, got %q", host)
+	}
+}
+
+func TestHTTPSProbeSkipsVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req := newTestProbeRequest(t, server.URL, "/", nil)
+	if result, output, err := HTTPProbe(req, time.Second); err != nil || result != Success {
+		t.Fatalf("expected success, got %v %q %v", result, output, err)
+	}
+}
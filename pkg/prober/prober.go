@@ -0,0 +1,176 @@
+package prober
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxProbeRetries = 3
+
+type probeType int
+
+const (
+	liveness probeType = iota
+	readiness
+	startup
+)
+
+func (this probeType) String() string {
+	switch this {
+	case readiness:
+		return "Readiness"
+	case liveness:
+		return "Liveness"
+	case startup:
+		return "Startup"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// prober 根据探针类型分发到具体的探测实现
+type prober struct {
+	runtime container.Runtime
+}
+
+func (this *prober) probe(probeType probeType, pod *v1.Pod, status v1.PodStatus, c v1.Container, containerID container.ContainerID) (Result, error) {
+	var p *v1.Probe
+	switch probeType {
+	case readiness:
+		p = c.ReadinessProbe
+	case liveness:
+		p = c.LivenessProbe
+	case startup:
+		p = c.StartupProbe
+	default:
+		return Failure, fmt.Errorf("unknown probe type: %q", probeType)
+	}
+	if p == nil {
+		klog.V(3).InfoS("Probe is nil", "probeType", probeType, "pod", klog.KObj(pod), "containerName", c.Name)
+		return Success, nil
+	}
+
+	result, output, err := this.runProbeWithRetries(probeType, p, pod, status, c, containerID, maxProbeRetries)
+	if err != nil || result != Success {
+		if err != nil {
+			klog.V(1).ErrorS(err, "Probe errored", "probeType", probeType, "pod", klog.KObj(pod), "containerName", c.Name)
+		} else {
+			klog.V(1).InfoS("Probe failed", "probeType", probeType, "pod", klog.KObj(pod), "containerName", c.Name, "probeResult", result, "output", output)
+		}
+		return Failure, err
+	}
+	klog.V(3).InfoS("Probe succeeded", "probeType", probeType, "pod", klog.KObj(pod), "containerName", c.Name)
+	return Success, nil
+}
+
+// 出错时重试，探测失败则直接返回
+func (this *prober) runProbeWithRetries(probeType probeType, p *v1.Probe, pod *v1.Pod, status v1.PodStatus,
+	c v1.Container, containerID container.ContainerID, retries int) (Result, string, error) {
+	var err error
+	var result Result
+	var output string
+	for i := 0; i < retries; i++ {
+		result, output, err = this.runProbe(probeType, p, pod, status, c, containerID)
+		if err == nil {
+			return result, output, nil
+		}
+	}
+	return result, output, err
+}
+
+func (this *prober) runProbe(probeType probeType, p *v1.Probe, pod *v1.Pod, status v1.PodStatus,
+	c v1.Container, containerID container.ContainerID) (Result, string, error) {
+	timeout := time.Duration(p.TimeoutSeconds) * time.Second
+	switch {
+	case p.Exec != nil:
+		klog.V(4).InfoS("Exec-Probe runProbe", "pod", klog.KObj(pod), "containerName", c.Name, "execCommand", p.Exec.Command)
+		return ExecProbe(this.runtime, containerID, p.Exec.Command, timeout)
+
+	case p.HTTPGet != nil:
+		scheme := strings.ToLower(string(p.HTTPGet.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+		host := p.HTTPGet.Host
+		if host == "" {
+			host = status.PodIP
+		}
+		port, err := extractPort(p.HTTPGet.Port, c)
+		if err != nil {
+			return Unknown, "", err
+		}
+		headers := http.Header{}
+		for _, h := range p.HTTPGet.HTTPHeaders {
+			headers.Add(h.Name, h.Value)
+		}
+		req, err := NewProbeRequest(scheme, host, port, p.HTTPGet.Path, headers)
+		if err != nil {
+			return Unknown, "", err
+		}
+		klog.V(4).InfoS("HTTP-Probe", "scheme", scheme, "host", host, "port", port, "path", p.HTTPGet.Path, "timeout", timeout)
+		return HTTPProbe(req, timeout)
+
+	case p.TCPSocket != nil:
+		port, err := extractPort(p.TCPSocket.Port, c)
+		if err != nil {
+			return Unknown, "", err
+		}
+		host := p.TCPSocket.Host
+		if host == "" {
+			host = status.PodIP
+		}
+		klog.V(4).InfoS("TCP-Probe", "host", host, "port", port, "timeout", timeout)
+		return TCPProbe(host, port, timeout)
+
+	case p.GRPC != nil:
+		host := status.PodIP
+		service := ""
+		if p.GRPC.Service != nil {
+			service = *p.GRPC.Service
+		}
+		klog.V(4).InfoS("GRPC-Probe", "host", host, "port", p.GRPC.Port, "service", service, "timeout", timeout)
+		return GRPCProbe(host, int(p.GRPC.Port), service, timeout)
+	}
+
+	klog.InfoS("Failed to find probe builder for container", "containerName", c.Name)
+	return Unknown, "", fmt.Errorf("missing probe handler for %s:%s", pod.Name, c.Name)
+}
+
+// 解析端口，支持容器中定义的端口名
+func extractPort(param intstr.IntOrString, container v1.Container) (int, error) {
+	port := -1
+	var err error
+	switch param.Type {
+	case intstr.Int:
+		port = param.IntValue()
+	case intstr.String:
+		if port, err = findPortByName(container, param.StrVal); err != nil {
+			// 兜底：字符串形式的数字
+			if port, err = strconv.Atoi(param.StrVal); err != nil {
+				return port, err
+			}
+		}
+	default:
+		return port, fmt.Errorf("intOrString had no kind: %+v", param)
+	}
+	if port > 0 && port < 65536 {
+		return port, nil
+	}
+	return port, fmt.Errorf("invalid port number: %v", port)
+}
+
+func findPortByName(container v1.Container, portName string) (int, error) {
+	for _, port := range container.Ports {
+		if port.Name == portName {
+			return int(port.ContainerPort), nil
+		}
+	}
+	return 0, fmt.Errorf("port %s not found", portName)
+}
@@ -0,0 +1,204 @@
+package prober
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/status"
+	"sync"
+	"time"
+)
+
+// 标识一个worker
+type probeKey struct {
+	podUID        types.UID
+	containerName string
+	probeType     probeType
+}
+
+// Manager 探针管理器
+// readiness结果写入状态管理器，liveness/startup的结果由pod同步循环消费，失败时重启容器
+type Manager struct {
+	workers    map[probeKey]*worker
+	workerLock sync.RWMutex
+
+	statusManager    *status.Manager
+	readinessManager *ResultsManager
+	livenessManager  *ResultsManager
+	startupManager   *ResultsManager
+
+	prober *prober
+	start  time.Time
+	// 探测周期和initialDelaySeconds的计时，测试时可以替换
+	clock clock.WithTicker
+}
+
+func NewManager(statusManager *status.Manager, livenessManager, startupManager *ResultsManager,
+	runtime container.Runtime, clock clock.WithTicker) *Manager {
+	return &Manager{
+		workers:          make(map[probeKey]*worker),
+		statusManager:    statusManager,
+		readinessManager: NewResultsManager(),
+		livenessManager:  livenessManager,
+		startupManager:   startupManager,
+		prober:           &prober{runtime: runtime},
+		start:            clock.Now(),
+		clock:            clock,
+	}
+}
+
+// Start 把readiness的结果同步到状态管理器
+// liveness和startup的结果由pod同步循环消费
+func (this *Manager) Start() {
+	go func() {
+		for update := range this.readinessManager.Updates() {
+			ready := update.Result == Success
+			this.statusManager.SetContainerReadiness(update.PodUID, update.ContainerID, ready)
+		}
+	}()
+}
+
+// AddPod 为pod中配置了探针的容器（包括sidecar）创建worker
+func (this *Manager) AddPod(pod *v1.Pod) {
+	this.workerLock.Lock()
+	defer this.workerLock.Unlock()
+
+	key := probeKey{podUID: pod.UID}
+	for _, c := range probedContainers(pod) {
+		key.containerName = c.Name
+
+		if c.StartupProbe != nil {
+			key.probeType = startup
+			if _, ok := this.workers[key]; !ok {
+				w := newWorker(this, startup, pod, c)
+				this.workers[key] = w
+				go w.run()
+			}
+		}
+		if c.ReadinessProbe != nil {
+			key.probeType = readiness
+			if _, ok := this.workers[key]; !ok {
+				w := newWorker(this, readiness, pod, c)
+				this.workers[key] = w
+				go w.run()
+			}
+		}
+		if c.LivenessProbe != nil {
+			key.probeType = liveness
+			if _, ok := this.workers[key]; !ok {
+				w := newWorker(this, liveness, pod, c)
+				this.workers[key] = w
+				go w.run()
+			}
+		}
+	}
+}
+
+// 需要探测的容器：业务容器和sidecar，普通初始化容器不支持探针
+func probedContainers(pod *v1.Pod) []v1.Container {
+	ret := []v1.Container{}
+	for _, c := range pod.Spec.InitContainers {
+		if container.IsRestartableInitContainer(&c) {
+			ret = append(ret, c)
+		}
+	}
+	return append(ret, pod.Spec.Containers...)
+}
+
+// RemovePod 停止pod所有的worker
+func (this *Manager) RemovePod(pod *v1.Pod) {
+	this.workerLock.RLock()
+	defer this.workerLock.RUnlock()
+
+	key := probeKey{podUID: pod.UID}
+	for _, c := range probedContainers(pod) {
+		key.containerName = c.Name
+		for _, probeType := range [...]probeType{readiness, liveness, startup} {
+			key.probeType = probeType
+			if worker, ok := this.workers[key]; ok {
+				worker.stop()
+			}
+		}
+	}
+}
+
+// CleanupPods 停止不在desiredPods中的pod的worker
+func (this *Manager) CleanupPods(desiredPods map[types.UID]bool) {
+	this.workerLock.RLock()
+	defer this.workerLock.RUnlock()
+
+	for key, worker := range this.workers {
+		if _, ok := desiredPods[key.podUID]; !ok {
+			worker.stop()
+		}
+	}
+}
+
+// UpdatePodStatus 根据探针结果设置容器的started和ready字段
+func (this *Manager) UpdatePodStatus(pod *v1.Pod, podStatus *v1.PodStatus) {
+	for i := range podStatus.ContainerStatuses {
+		this.updateContainerStatus(pod.UID, &podStatus.ContainerStatuses[i])
+	}
+
+	for i, c := range podStatus.InitContainerStatuses {
+		if isRestartable(pod, c.Name) {
+			this.updateContainerStatus(pod.UID, &podStatus.InitContainerStatuses[i])
+			continue
+		}
+		// 初始化容器执行成功即ready
+		var ready bool
+		if c.State.Terminated != nil && c.State.Terminated.ExitCode == 0 {
+			ready = true
+		}
+		podStatus.InitContainerStatuses[i].Ready = ready
+	}
+}
+
+func (this *Manager) updateContainerStatus(podUID types.UID, c *v1.ContainerStatus) {
+	var started bool
+	if c.State.Running == nil {
+		started = false
+	} else if result, ok := this.startupManager.Get(container.ParseContainerID(c.ContainerID)); ok {
+		started = result == Success
+	} else {
+		// 没有启动探针，或者worker还没有开始探测
+		_, exists := this.getWorker(podUID, c.Name, startup)
+		started = !exists
+	}
+	c.Started = &started
+
+	if started {
+		var ready bool
+		if result, ok := this.readinessManager.Get(container.ParseContainerID(c.ContainerID)); ok {
+			ready = result == Success
+		} else {
+			// 没有就绪探针视为ready
+			_, exists := this.getWorker(podUID, c.Name, readiness)
+			ready = !exists
+		}
+		c.Ready = ready
+	}
+}
+
+func isRestartable(pod *v1.Pod, name string) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return container.IsRestartableInitContainer(&c)
+		}
+	}
+	return false
+}
+
+func (this *Manager) getWorker(podUID types.UID, containerName string, probeType probeType) (*worker, bool) {
+	this.workerLock.RLock()
+	defer this.workerLock.RUnlock()
+	worker, ok := this.workers[probeKey{podUID, containerName, probeType}]
+	return worker, ok
+}
+
+func (this *Manager) removeWorker(podUID types.UID, containerName string, probeType probeType) {
+	this.workerLock.Lock()
+	defer this.workerLock.Unlock()
+	delete(this.workers, probeKey{podUID, containerName, probeType})
+}
@@ -0,0 +1,85 @@
+package prober
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"mykubelet/pkg/container"
+	"sync"
+)
+
+// Result 探针结果
+type Result int
+
+const (
+	Unknown Result = iota - 1
+	Success
+	Failure
+)
+
+func (this Result) String() string {
+	switch this {
+	case Success:
+		return "Success"
+	case Failure:
+		return "Failure"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ResultUpdate 探针结果发生变化的通知
+type ResultUpdate struct {
+	ContainerID container.ContainerID
+	Result      Result
+	PodUID      types.UID
+}
+
+// ResultsManager 按容器保存某一类探针的最新结果
+// 结果变化时推送到updates，供状态管理器和pod同步循环消费
+type ResultsManager struct {
+	sync.RWMutex
+	cache   map[container.ContainerID]Result
+	updates chan ResultUpdate
+}
+
+func NewResultsManager() *ResultsManager {
+	return &ResultsManager{
+		cache:   make(map[container.ContainerID]Result),
+		updates: make(chan ResultUpdate, 20),
+	}
+}
+
+func (this *ResultsManager) Get(id container.ContainerID) (Result, bool) {
+	this.RLock()
+	defer this.RUnlock()
+	result, found := this.cache[id]
+	return result, found
+}
+
+func (this *ResultsManager) Set(id container.ContainerID, result Result, pod *v1.Pod) {
+	if this.setInternal(id, result) {
+		this.updates <- ResultUpdate{ContainerID: id, Result: result, PodUID: pod.UID}
+	}
+}
+
+// 结果发生变化时返回true
+func (this *ResultsManager) setInternal(id container.ContainerID, result Result) bool {
+	this.Lock()
+	defer this.Unlock()
+	prev, exists := this.cache[id]
+	if !exists || prev != result {
+		this.cache[id] = result
+		return true
+	}
+	return false
+}
+
+func (this *ResultsManager) Remove(id container.ContainerID) {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.cache, id)
+}
+
+func (this *ResultsManager) Updates() <-chan ResultUpdate {
+	return this.updates
+}
@@ -0,0 +1,17 @@
+package prober
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// TCPProbe 能建立tcp连接即成功
+func TCPProbe(host string, port int, timeout time.Duration) (Result, string, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return Failure, err.Error(), nil
+	}
+	_ = conn.Close()
+	return Success, "", nil
+}
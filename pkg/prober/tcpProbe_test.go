@@ -0,0 +1,43 @@
+package prober
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	if result, output, err := TCPProbe("127.0.0.1", port, time.Second); err != nil || result != Success {
+		t.Errorf("expected success, got %v %q %v", result, output, err)
+	}
+
+	// 关闭监听后连接被拒绝
+	_ = listener.Close()
+	result, output, err := TCPProbe("127.0.0.1", port, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != Failure {
+		t.Errorf("expected failure, got %v", result)
+	}
+	if output == "" {
+		t.Errorf("expected connection error in output")
+	}
+}
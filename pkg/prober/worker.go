@@ -0,0 +1,229 @@
+package prober
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+	"math/rand"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/metrics"
+	"time"
+)
+
+// worker 每个容器的每种探针对应一个worker协程，按periodSeconds周期探测
+// pkg/kubelet/prober/worker.go
+type worker struct {
+	stopCh chan struct{}
+
+	pod       *v1.Pod
+	container v1.Container
+	spec      *v1.Probe
+	probeType probeType
+
+	// 还没有探测结果时的默认值
+	initialValue   Result
+	resultsManager *ResultsManager
+	probeManager   *Manager
+
+	containerID container.ContainerID
+	lastResult  Result
+	resultRun   int
+	// liveness/startup失败后暂停探测，直到容器重启
+	onHold bool
+}
+
+func newWorker(m *Manager, probeType probeType, pod *v1.Pod, c v1.Container) *worker {
+	w := &worker{
+		stopCh:       make(chan struct{}, 1),
+		pod:          pod,
+		container:    c,
+		probeType:    probeType,
+		probeManager: m,
+	}
+
+	switch probeType {
+	case readiness:
+		w.spec = c.ReadinessProbe
+		w.resultsManager = m.readinessManager
+		w.initialValue = Failure
+	case liveness:
+		w.spec = c.LivenessProbe
+		w.resultsManager = m.livenessManager
+		w.initialValue = Success
+	case startup:
+		w.spec = c.StartupProbe
+		w.resultsManager = m.startupManager
+		w.initialValue = Unknown
+	}
+	w.spec = withProbeDefaults(w.spec)
+	return w
+}
+
+// 和apiServer的默认值保持一致，返回副本以免修改informer缓存中的pod
+func withProbeDefaults(probe *v1.Probe) *v1.Probe {
+	p := probe.DeepCopy()
+	if p.TimeoutSeconds == 0 {
+		p.TimeoutSeconds = 1
+	}
+	if p.PeriodSeconds == 0 {
+		p.PeriodSeconds = 10
+	}
+	if p.SuccessThreshold == 0 {
+		p.SuccessThreshold = 1
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = 3
+	}
+	return p
+}
+
+func (this *worker) run() {
+	probeTickerPeriod := time.Duration(this.spec.PeriodSeconds) * time.Second
+
+	// kubelet重启时避免所有探针同时执行
+	if probeTickerPeriod > this.probeManager.clock.Since(this.probeManager.start) {
+		this.probeManager.clock.Sleep(time.Duration(rand.Float64() * float64(probeTickerPeriod)))
+	}
+
+	probeTicker := this.probeManager.clock.NewTicker(probeTickerPeriod)
+	defer func() {
+		probeTicker.Stop()
+		if !this.containerID.IsEmpty() {
+			this.resultsManager.Remove(this.containerID)
+		}
+		this.probeManager.removeWorker(this.pod.UID, this.container.Name, this.probeType)
+		for _, result := range []Result{Success, Failure, Unknown} {
+			metrics.ProberResults.DeleteLabelValues(this.probeMetricLabels(result)...)
+		}
+	}()
+
+probeLoop:
+	for this.doProbe() {
+		select {
+		case <-this.stopCh:
+			break probeLoop
+		case <-probeTicker.C():
+		}
+	}
+}
+
+func (this *worker) stop() {
+	select {
+	case this.stopCh <- struct{}{}:
+	default:
+	}
+}
+
+// doProbe 执行一次探测，返回false时worker退出
+func (this *worker) doProbe() (keepGoing bool) {
+	defer runtime.HandleCrash(func(_ interface{}) { keepGoing = true })
+
+	status, ok := this.probeManager.statusManager.GetPodStatus(this.pod.UID)
+	if !ok {
+		klog.V(3).InfoS("No status for pod", "pod", klog.KObj(this.pod))
+		return true
+	}
+
+	// pod已经结束，不再探测
+	if status.Phase == v1.PodFailed || status.Phase == v1.PodSucceeded {
+		klog.V(3).InfoS("Pod is terminated, exiting probe worker", "pod", klog.KObj(this.pod), "phase", status.Phase)
+		return false
+	}
+
+	c, ok := findContainerStatus(status.ContainerStatuses, this.container.Name)
+	if !ok {
+		// sidecar
+		c, ok = findContainerStatus(status.InitContainerStatuses, this.container.Name)
+	}
+	if !ok || len(c.ContainerID) == 0 {
+		klog.V(3).InfoS("Probe target container not found", "pod", klog.KObj(this.pod), "containerName", this.container.Name)
+		return true
+	}
+
+	if this.containerID.String() != c.ContainerID {
+		// 容器重启了，清理旧容器的结果
+		if !this.containerID.IsEmpty() {
+			this.resultsManager.Remove(this.containerID)
+		}
+		this.containerID = container.ParseContainerID(c.ContainerID)
+		this.resultsManager.Set(this.containerID, this.initialValue, this.pod)
+		this.onHold = false
+	}
+
+	if this.onHold {
+		return true
+	}
+
+	if c.State.Running == nil {
+		klog.V(3).InfoS("Non-running container probed", "pod", klog.KObj(this.pod), "containerName", this.container.Name)
+		if !this.containerID.IsEmpty() {
+			this.resultsManager.Set(this.containerID, Failure, this.pod)
+		}
+		// 容器不会再重启时退出
+		return c.State.Terminated == nil || this.pod.Spec.RestartPolicy != v1.RestartPolicyNever
+	}
+
+	// 启动探针成功之前不执行其他探针
+	if this.probeType != startup && (c.Started == nil || !*c.Started) {
+		return true
+	}
+	if this.probeType == startup && c.Started != nil && *c.Started {
+		return true
+	}
+
+	if int32(this.probeManager.clock.Since(c.State.Running.StartedAt.Time).Seconds()) < this.spec.InitialDelaySeconds {
+		return true
+	}
+
+	result, err := this.probeManager.prober.probe(this.probeType, this.pod, status, this.container, this.containerID)
+	if err != nil {
+		// 探测出错时保持上一次的结果
+		return true
+	}
+	metrics.ProberResults.WithLabelValues(this.probeMetricLabels(result)...).Inc()
+
+	if this.lastResult == result {
+		this.resultRun++
+	} else {
+		this.lastResult = result
+		this.resultRun = 1
+	}
+
+	if (result == Failure && this.resultRun < int(this.spec.FailureThreshold)) ||
+		(result == Success && this.resultRun < int(this.spec.SuccessThreshold)) {
+		return true
+	}
+
+	this.resultsManager.Set(this.containerID, result, this.pod)
+
+	if (this.probeType == liveness || this.probeType == startup) && result == Failure {
+		// 容器会被重启，重启前不再探测
+		this.onHold = true
+		this.resultRun = 0
+	}
+
+	return true
+}
+
+// prober_probe_total的标签值
+func (this *worker) probeMetricLabels(result Result) []string {
+	var resultLabel string
+	switch result {
+	case Success:
+		resultLabel = "successful"
+	case Failure:
+		resultLabel = "failed"
+	default:
+		resultLabel = "unknown"
+	}
+	return []string{this.probeType.String(), resultLabel, this.container.Name, this.pod.Name, this.pod.Namespace, string(this.pod.UID)}
+}
+
+func findContainerStatus(statuses []v1.ContainerStatus, name string) (v1.ContainerStatus, bool) {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return v1.ContainerStatus{}, false
+}
@@ -0,0 +1,209 @@
+package prober
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"mykubelet/pkg/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testContainerName = "c"
+
+// fakeExec 控制exec探针的退出码并记录执行次数
+type fakeExec struct {
+	lock   sync.Mutex
+	fail   bool
+	probes int
+}
+
+func (this *fakeExec) set(fail bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.fail = fail
+}
+
+func (this *fakeExec) count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.probes
+}
+
+func (this *fakeExec) execSync(_ context.Context, _ string, _ []string, _ time.Duration) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.probes++
+	if this.fail {
+		return nil, &container.ExitError{Code: 1}
+	}
+	return nil, nil
+}
+
+func newTestManager(clock *testingclock.FakeClock, exec *fakeExec) *Manager {
+	runtime := containertest.NewFakeRuntime()
+	runtime.ExecSyncFn = exec.execSync
+	statusManager := status.NewManager(fake.NewSimpleClientset(), nil)
+	return NewManager(statusManager, NewResultsManager(), NewResultsManager(), runtime, clock)
+}
+
+func newTestPod(probeType probeType, probe v1.Probe) *v1.Pod {
+	probe.ProbeHandler = v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"true"}}}
+	c := v1.Container{Name: testContainerName}
+	switch probeType {
+	case readiness:
+		c.ReadinessProbe = &probe
+	case liveness:
+		c.LivenessProbe = &probe
+	case startup:
+		c.StartupProbe = &probe
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "pod-uid"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{c}, RestartPolicy: v1.RestartPolicyAlways},
+	}
+}
+
+// setRunning 设置容器在startedAt启动并且已经通过启动探针
+func setRunning(m *Manager, pod *v1.Pod, containerID string, startedAt time.Time) {
+	started := true
+	m.statusManager.SetPodStatus(pod, v1.PodStatus{
+		Phase: v1.PodRunning,
+		ContainerStatuses: []v1.ContainerStatus{{
+			Name:        testContainerName,
+			ContainerID: containerID,
+			Started:     &started,
+			State:       v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.NewTime(startedAt)}},
+		}},
+	})
+}
+
+func expectResult(t *testing.T, w *worker, expected Result, msg string) {
+	t.Helper()
+	result, ok := w.resultsManager.Get(w.containerID)
+	if !ok {
+		t.Errorf("[%s] expected result %v, got none", msg, expected)
+	} else if result != expected {
+		t.Errorf("[%s] expected result %v, got %v", msg, expected, result)
+	}
+}
+
+func TestInitialDelay(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	exec := &fakeExec{}
+	m := newTestManager(fakeClock, exec)
+	pod := newTestPod(readiness, v1.Probe{InitialDelaySeconds: 10})
+	setRunning(m, pod, "fake://c1", fakeClock.Now())
+	w := newWorker(m, readiness, pod, pod.Spec.Containers[0])
+
+	if !w.doProbe() {
+		t.Fatal("expected to keep probing")
+	}
+	if exec.count() != 0 {
+		t.Errorf("expected no probe during initial delay, got %d", exec.count())
+	}
+	expectResult(t, w, Failure, "during initial delay")
+
+	fakeClock.Step(10 * time.Second)
+	w.doProbe()
+	if exec.count() != 1 {
+		t.Errorf("expected probe after initial delay, got %d", exec.count())
+	}
+	expectResult(t, w, Success, "after initial delay")
+}
+
+func TestFailureThreshold(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	exec := &fakeExec{}
+	m := newTestManager(fakeClock, exec)
+	pod := newTestPod(readiness, v1.Probe{SuccessThreshold: 1, FailureThreshold: 3})
+	setRunning(m, pod, "fake://c1", fakeClock.Now())
+	w := newWorker(m, readiness, pod, pod.Spec.Containers[0])
+
+	for i := 0; i < 2; i++ {
+		w.doProbe()
+		expectResult(t, w, Success, "success")
+	}
+	exec.set(true)
+	// 连续失败次数达到阈值之前保持成功
+	for i := 0; i < 2; i++ {
+		w.doProbe()
+		expectResult(t, w, Success, "failure below threshold")
+	}
+	w.doProbe()
+	expectResult(t, w, Failure, "failure threshold reached")
+}
+
+func TestSuccessThreshold(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	exec := &fakeExec{}
+	m := newTestManager(fakeClock, exec)
+	pod := newTestPod(readiness, v1.Probe{SuccessThreshold: 3, FailureThreshold: 1})
+	setRunning(m, pod, "fake://c1", fakeClock.Now())
+	w := newWorker(m, readiness, pod, pod.Spec.Containers[0])
+
+	for i := 0; i < 2; i++ {
+		w.doProbe()
+		expectResult(t, w, Failure, "success below threshold")
+	}
+	w.doProbe()
+	expectResult(t, w, Success, "success threshold reached")
+
+	// 一次失败后重新计数
+	exec.set(true)
+	w.doProbe()
+	expectResult(t, w, Failure, "failure")
+	exec.set(false)
+	w.doProbe()
+	w.doProbe()
+	expectResult(t, w, Failure, "success count reset")
+	w.doProbe()
+	expectResult(t, w, Success, "success threshold reached again")
+}
+
+func TestLivenessFailureOnHold(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	exec := &fakeExec{fail: true}
+	m := newTestManager(fakeClock, exec)
+	pod := newTestPod(liveness, v1.Probe{FailureThreshold: 1})
+	setRunning(m, pod, "fake://c1", fakeClock.Now())
+	w := newWorker(m, liveness, pod, pod.Spec.Containers[0])
+
+	w.doProbe()
+	expectResult(t, w, Failure, "liveness failure")
+	if !w.onHold {
+		t.Fatal("expected worker to be on hold after liveness failure")
+	}
+	w.doProbe()
+	if exec.count() != 1 {
+		t.Errorf("expected no probe while on hold, got %d", exec.count())
+	}
+
+	// 容器重启后恢复探测
+	exec.set(false)
+	setRunning(m, pod, "fake://c2", fakeClock.Now())
+	w.doProbe()
+	if w.onHold {
+		t.Error("expected worker to resume after container restart")
+	}
+	expectResult(t, w, Success, "after restart")
+	if _, ok := w.resultsManager.Get(container.ParseContainerID("fake://c1")); ok {
+		t.Error("expected result of the old container to be removed")
+	}
+}
+
+func TestWorkerStopsOnTerminatedPod(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	m := newTestManager(fakeClock, &fakeExec{})
+	pod := newTestPod(readiness, v1.Probe{})
+	m.statusManager.SetPodStatus(pod, v1.PodStatus{Phase: v1.PodSucceeded})
+	w := newWorker(m, readiness, pod, pod.Spec.Containers[0])
+	if w.doProbe() {
+		t.Error("expected worker to stop for terminated pod")
+	}
+}
@@ -0,0 +1,67 @@
+package qos
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// 决定QoS等级的资源
+var supportedQoSComputeResources = map[v1.ResourceName]bool{
+	v1.ResourceCPU:    true,
+	v1.ResourceMemory: true,
+}
+
+// GetPodQOS 计算pod的QoS等级
+// 所有容器都设置了cpu和内存的limits，且requests等于limits时为Guaranteed
+// 没有任何容器设置requests和limits时为BestEffort，其余为Burstable
+// pkg/apis/core/v1/helper/qos/qos.go
+func GetPodQOS(pod *v1.Pod) v1.PodQOSClass {
+	requests := v1.ResourceList{}
+	limits := v1.ResourceList{}
+	isGuaranteed := true
+	allContainers := append([]v1.Container{}, pod.Spec.Containers...)
+	allContainers = append(allContainers, pod.Spec.InitContainers...)
+	for _, c := range allContainers {
+		for name, quantity := range c.Resources.Requests {
+			if !supportedQoSComputeResources[name] || quantity.Cmp(resource.Quantity{}) != 1 {
+				continue
+			}
+			addQuantity(requests, name, quantity)
+		}
+		limitsFound := map[v1.ResourceName]bool{}
+		for name, quantity := range c.Resources.Limits {
+			if !supportedQoSComputeResources[name] || quantity.Cmp(resource.Quantity{}) != 1 {
+				continue
+			}
+			limitsFound[name] = true
+			addQuantity(limits, name, quantity)
+		}
+		if len(limitsFound) != len(supportedQoSComputeResources) {
+			isGuaranteed = false
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return v1.PodQOSBestEffort
+	}
+	if isGuaranteed {
+		for name, req := range requests {
+			if limit, ok := limits[name]; !ok || limit.Cmp(req) != 0 {
+				isGuaranteed = false
+				break
+			}
+		}
+	}
+	if isGuaranteed && len(requests) == len(limits) {
+		return v1.PodQOSGuaranteed
+	}
+	return v1.PodQOSBurstable
+}
+
+func addQuantity(list v1.ResourceList, name v1.ResourceName, quantity resource.Quantity) {
+	if existing, ok := list[name]; ok {
+		existing.Add(quantity)
+		list[name] = existing
+	} else {
+		list[name] = quantity.DeepCopy()
+	}
+}
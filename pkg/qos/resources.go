@@ -0,0 +1,75 @@
+package qos
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// PodRequests pod的资源请求
+// 业务容器的requests之和与init容器requests的最大值取较大者，sidecar容器和业务容器同时运行，计入两者；再加上pod的overhead
+// pkg/api/v1/resource/helpers.go PodRequests
+func PodRequests(pod *v1.Pod) v1.ResourceList {
+	reqs := podResources(pod, func(c *v1.Container) v1.ResourceList {
+		return c.Resources.Requests
+	})
+	if pod.Spec.Overhead != nil {
+		addResourceList(reqs, pod.Spec.Overhead)
+	}
+	return reqs
+}
+
+// PodLimits pod的资源限制，计算方式和PodRequests相同，overhead只计入设置了限制的资源
+// pkg/api/v1/resource/helpers.go PodLimits
+func PodLimits(pod *v1.Pod) v1.ResourceList {
+	limits := podResources(pod, func(c *v1.Container) v1.ResourceList {
+		return c.Resources.Limits
+	})
+	for name, quantity := range pod.Spec.Overhead {
+		if _, ok := limits[name]; ok {
+			addQuantity(limits, name, quantity)
+		}
+	}
+	return limits
+}
+
+func podResources(pod *v1.Pod, containerResources func(c *v1.Container) v1.ResourceList) v1.ResourceList {
+	reqs := v1.ResourceList{}
+	for i := range pod.Spec.Containers {
+		addResourceList(reqs, containerResources(&pod.Spec.Containers[i]))
+	}
+
+	// 已经启动的sidecar容器在后续init容器运行时仍然占用资源
+	restartableInitReqs := v1.ResourceList{}
+	initReqs := v1.ResourceList{}
+	for i := range pod.Spec.InitContainers {
+		c := &pod.Spec.InitContainers[i]
+		containerReqs := containerResources(c)
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			addResourceList(reqs, containerReqs)
+			addResourceList(restartableInitReqs, containerReqs)
+			containerReqs = restartableInitReqs
+		} else {
+			tmp := v1.ResourceList{}
+			addResourceList(tmp, containerReqs)
+			addResourceList(tmp, restartableInitReqs)
+			containerReqs = tmp
+		}
+		maxResourceList(initReqs, containerReqs)
+	}
+	maxResourceList(reqs, initReqs)
+	return reqs
+}
+
+func addResourceList(list, newList v1.ResourceList) {
+	for name, quantity := range newList {
+		addQuantity(list, name, quantity)
+	}
+}
+
+// maxResourceList 每项资源取两者中的较大值
+func maxResourceList(list, newList v1.ResourceList) {
+	for name, quantity := range newList {
+		if existing, ok := list[name]; !ok || quantity.Cmp(existing) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
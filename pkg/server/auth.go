@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/config"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 认证和鉴权结果缓存的最大条目数
+const authCacheSize = 1024
+
+// UserInfo 认证通过的用户
+type UserInfo struct {
+	Name   string
+	UID    string
+	Groups []string
+	Extra  map[string][]string
+}
+
+// Attributes 鉴权使用的请求属性，kubelet的接口都映射为nodes资源的子资源
+type Attributes struct {
+	User        *UserInfo
+	Verb        string
+	Resource    string
+	Subresource string
+	Name        string
+	Path        string
+}
+
+// AuthInterface kubelet api的认证和鉴权
+type AuthInterface interface {
+	// AuthenticateRequest 返回请求的用户，false表示没有可用的凭证或凭证无效
+	AuthenticateRequest(req *http.Request) (*UserInfo, bool, error)
+	// GetRequestAttributes 把请求映射为鉴权属性
+	GetRequestAttributes(u *UserInfo, req *http.Request) Attributes
+	// Authorize 返回是否允许以及原因
+	Authorize(ctx context.Context, attrs Attributes) (bool, string, error)
+}
+
+// KubeletAuth 客户端证书或TokenReview认证，SubjectAccessReview鉴权，结果按TTL缓存
+// pkg/kubelet/server/auth.go
+type KubeletAuth struct {
+	client   kubernetes.Interface
+	nodeName string
+
+	tokenCache    *cache.LRUExpireCache
+	tokenCacheTTL time.Duration
+
+	decisionCache   *cache.LRUExpireCache
+	authorizedTTL   time.Duration
+	unauthorizedTTL time.Duration
+}
+
+var _ AuthInterface = &KubeletAuth{}
+
+// 缓存的TokenReview结果
+type tokenReviewResult struct {
+	user          *UserInfo
+	authenticated bool
+}
+
+// 缓存的SubjectAccessReview结果
+type authorizationDecision struct {
+	allowed bool
+	reason  string
+}
+
+// NewKubeletAuth clock用于缓存过期，测试时可以替换
+func NewKubeletAuth(client kubernetes.Interface, nodeName string, kubeletConfig *config.KubeletConfiguration, clock cache.Clock) *KubeletAuth {
+	return &KubeletAuth{
+		client:          client,
+		nodeName:        nodeName,
+		tokenCache:      cache.NewLRUExpireCacheWithClock(authCacheSize, clock),
+		tokenCacheTTL:   kubeletConfig.AuthenticationWebhookCacheTTL.Duration,
+		decisionCache:   cache.NewLRUExpireCacheWithClock(authCacheSize, clock),
+		authorizedTTL:   kubeletConfig.AuthorizationWebhookCacheAuthorizedTTL.Duration,
+		unauthorizedTTL: kubeletConfig.AuthorizationWebhookCacheUnauthorizedTTL.Duration,
+	}
+}
+
+// AuthenticateRequest 先校验客户端证书，再校验bearer token
+func (this *KubeletAuth) AuthenticateRequest(req *http.Request) (*UserInfo, bool, error) {
+	if user, ok := authenticateX509(req); ok {
+		return user, true, nil
+	}
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false, nil
+	}
+	return this.authenticateToken(req.Context(), token)
+}
+
+// 客户端证书在tls握手时已经用集群ca校验过，CN作为用户名，O作为用户组
+func authenticateX509(req *http.Request) (*UserInfo, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, false
+	}
+	return &UserInfo{
+		Name:   cert.Subject.CommonName,
+		Groups: cert.Subject.Organization,
+	}, true
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 3)
+	if len(parts) < 2 || strings.ToLower(parts[0]) != "bearer" {
+		return "", false
+	}
+	token := parts[1]
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// 通过TokenReview校验token，成功和失败的结果都会缓存，请求出错时不缓存
+func (this *KubeletAuth) authenticateToken(ctx context.Context, token string) (*UserInfo, bool, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	if cached, ok := this.tokenCache.Get(key); ok {
+		result := cached.(*tokenReviewResult)
+		return result.user, result.authenticated, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	review, err := this.client.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	if review.Status.Error != "" {
+		return nil, false, fmt.Errorf("token review failed: %s", review.Status.Error)
+	}
+
+	result := &tokenReviewResult{authenticated: review.Status.Authenticated}
+	if result.authenticated {
+		u := review.Status.User
+		result.user = &UserInfo{
+			Name:   u.Username,
+			UID:    u.UID,
+			Groups: u.Groups,
+			Extra:  map[string][]string{},
+		}
+		for k, v := range u.Extra {
+			result.user.Extra[k] = v
+		}
+	}
+	this.tokenCache.Add(key, result, this.tokenCacheTTL)
+	return result.user, result.authenticated, nil
+}
+
+// GetRequestAttributes 根据请求路径确定nodes的子资源，默认为proxy
+func (this *KubeletAuth) GetRequestAttributes(u *UserInfo, req *http.Request) Attributes {
+	requestPath := req.URL.Path
+	attrs := Attributes{
+		User:        u,
+		Verb:        apiVerb(req.Method),
+		Resource:    "nodes",
+		Subresource: "proxy",
+		Name:        this.nodeName,
+		Path:        requestPath,
+	}
+	switch {
+	case isSubpath(requestPath, "/stats"):
+		attrs.Subresource = "stats"
+	case isSubpath(requestPath, "/metrics"):
+		attrs.Subresource = "metrics"
+	case isSubpath(requestPath, "/logs"):
+		attrs.Subresource = "log"
+	}
+	klog.V(5).InfoS("Node request attributes", "user", u.Name, "verb", attrs.Verb, "resource", attrs.Resource, "subresource", attrs.Subresource)
+	return attrs
+}
+
+// http方法对应的api动词
+func apiVerb(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
+
+func isSubpath(subpath, path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return subpath == path || strings.HasPrefix(subpath, path+"/")
+}
+
+// Authorize 通过SubjectAccessReview鉴权，允许和拒绝的结果使用不同的缓存时间
+func (this *KubeletAuth) Authorize(ctx context.Context, attrs Attributes) (bool, string, error) {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        attrs.Verb,
+				Version:     "v1",
+				Resource:    attrs.Resource,
+				Subresource: attrs.Subresource,
+				Name:        attrs.Name,
+			},
+			User:   attrs.User.Name,
+			UID:    attrs.User.UID,
+			Groups: attrs.User.Groups,
+			Extra:  map[string]authorizationv1.ExtraValue{},
+		},
+	}
+	for k, v := range attrs.User.Extra {
+		review.Spec.Extra[k] = v
+	}
+
+	key, err := json.Marshal(review.Spec)
+	if err != nil {
+		return false, "", err
+	}
+	if cached, ok := this.decisionCache.Get(string(key)); ok {
+		decision := cached.(*authorizationDecision)
+		return decision.allowed, decision.reason, nil
+	}
+
+	review, err = this.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	if review.Status.EvaluationError != "" && !review.Status.Allowed {
+		klog.V(2).InfoS("SubjectAccessReview evaluation error", "user", attrs.User.Name, "err", review.Status.EvaluationError)
+	}
+
+	decision := &authorizationDecision{allowed: review.Status.Allowed, reason: review.Status.Reason}
+	if decision.allowed {
+		this.decisionCache.Add(string(key), decision, this.authorizedTTL)
+	} else {
+		this.decisionCache.Add(string(key), decision, this.unauthorizedTTL)
+	}
+	return decision.allowed, decision.reason, nil
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTokenTTL        = 2 * time.Minute
+	testAuthorizedTTL   = 5 * time.Minute
+	testUnauthorizedTTL = 30 * time.Second
+)
+
+// fakeReviewer 通过clientset的reactor返回TokenReview和SubjectAccessReview的结果并记录请求次数
+type fakeReviewer struct {
+	lock sync.Mutex
+	// token -> 用户名，不存在的token认证失败
+	tokens map[string]string
+	// 用户名 -> 允许访问的子资源
+	allowed       map[string][]string
+	tokenReviews  int
+	accessReviews int
+	err           error
+}
+
+func (this *fakeReviewer) reviewToken(action k8stesting.Action) (bool, runtime.Object, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.tokenReviews++
+	if this.err != nil {
+		return true, nil, this.err
+	}
+	review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview).DeepCopy()
+	if name, ok := this.tokens[review.Spec.Token]; ok {
+		review.Status.Authenticated = true
+		review.Status.User = authenticationv1.UserInfo{Username: name, UID: name + "-uid", Groups: []string{"system:authenticated"}}
+	}
+	return true, review, nil
+}
+
+func (this *fakeReviewer) reviewAccess(action k8stesting.Action) (bool, runtime.Object, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.accessReviews++
+	if this.err != nil {
+		return true, nil, this.err
+	}
+	review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+	attrs := review.Spec.ResourceAttributes
+	for _, subresource := range this.allowed[review.Spec.User] {
+		if attrs.Resource == "nodes" && attrs.Name == "node" && attrs.Subresource == subresource {
+			review.Status.Allowed = true
+			return true, review, nil
+		}
+	}
+	review.Status.Reason = fmt.Sprintf("%s cannot %s nodes/%s", review.Spec.User, attrs.Verb, attrs.Subresource)
+	return true, review, nil
+}
+
+func (this *fakeReviewer) counts() (int, int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.tokenReviews, this.accessReviews
+}
+
+func (this *fakeReviewer) setErr(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.err = err
+}
+
+func newTestAuth(reviewer *fakeReviewer, clock *testingclock.FakeClock) *KubeletAuth {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", reviewer.reviewToken)
+	client.PrependReactor("create", "subjectaccessreviews", reviewer.reviewAccess)
+	kubeletConfig := &config.KubeletConfiguration{
+		AuthenticationWebhookCacheTTL:            metav1.Duration{Duration: testTokenTTL},
+		AuthorizationWebhookCacheAuthorizedTTL:   metav1.Duration{Duration: testAuthorizedTTL},
+		AuthorizationWebhookCacheUnauthorizedTTL: metav1.Duration{Duration: testUnauthorizedTTL},
+	}
+	return NewKubeletAuth(client, "node", kubeletConfig, clock)
+}
+
+func newBearerRequest(method, path, token string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAuthenticateX509(t *testing.T) {
+	auth := newTestAuth(&fakeReviewer{}, testingclock.NewFakeClock(time.Now()))
+	req := httptest.NewRequest(http.MethodGet, "/pods", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{
+		Subject: pkix.Name{CommonName: "system:kube-apiserver", Organization: []string{"system:masters"}},
+	}}}}
+	user, ok, err := auth.AuthenticateRequest(req)
+	if err != nil || !ok {
+		t.Fatalf("expected authenticated request, got %v %v", ok, err)
+	}
+	if user.Name != "system:kube-apiserver" || len(user.Groups) != 1 || user.Groups[0] != "system:masters" {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	// 没有经过校验的证书链不能作为凭证
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "forged"}}}}
+	if _, ok, _ = auth.AuthenticateRequest(req); ok {
+		t.Error("expected unverified certificate to be rejected")
+	}
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	reviewer := &fakeReviewer{tokens: map[string]string{"good": "alice"}}
+	auth := newTestAuth(reviewer, testingclock.NewFakeClock(time.Now()))
+
+	tests := []struct {
+		name          string
+		header        string
+		authenticated bool
+		user          string
+	}{
+		{name: "valid token", header: "Bearer good", authenticated: true, user: "alice"},
+		{name: "invalid token", header: "Bearer bad"},
+		{name: "no credentials"},
+		{name: "basic auth", header: "Basic Zm9vOmJhcg=="},
+		{name: "empty bearer", header: "Bearer "},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/pods", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		user, ok, err := auth.AuthenticateRequest(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if ok != test.authenticated {
+			t.Errorf("%s: expected authenticated=%v, got %v", test.name, test.authenticated, ok)
+		}
+		if ok && user.Name != test.user {
+			t.Errorf("%s: expected user %q, got %q", test.name, test.user, user.Name)
+		}
+	}
+	if tokenReviews, _ := reviewer.counts(); tokenReviews != 2 {
+		t.Errorf("expected only bearer tokens to be reviewed, got %d reviews", tokenReviews)
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	reviewer := &fakeReviewer{tokens: map[string]string{"good": "alice"}}
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	auth := newTestAuth(reviewer, fakeClock)
+
+	authenticate := func(token string) bool {
+		_, ok, err := auth.AuthenticateRequest(newBearerRequest(http.MethodGet, "/pods", token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	for i := 0; i < 3; i++ {
+		if !authenticate("good") || authenticate("bad") {
+			t.Fatal("unexpected authentication result")
+		}
+	}
+	if tokenReviews, _ := reviewer.counts(); tokenReviews != 2 {
+		t.Errorf("expected results to be cached, got %d reviews", tokenReviews)
+	}
+
+	fakeClock.Step(testTokenTTL + time.Second)
+	authenticate("good")
+	authenticate("bad")
+	if tokenReviews, _ := reviewer.counts(); tokenReviews != 4 {
+		t.Errorf("expected results to expire after %v, got %d reviews", testTokenTTL, tokenReviews)
+	}
+
+	// 请求出错时不缓存
+	reviewer.setErr(fmt.Errorf("apiserver unavailable"))
+	if _, _, err := auth.AuthenticateRequest(newBearerRequest(http.MethodGet, "/pods", "other")); err == nil {
+		t.Error("expected error from token review")
+	}
+	reviewer.setErr(nil)
+	if _, ok, err := auth.AuthenticateRequest(newBearerRequest(http.MethodGet, "/pods", "other")); err != nil || ok {
+		t.Errorf("expected review to be retried after an error, got %v %v", ok, err)
+	}
+}
+
+func TestGetRequestAttributes(t *testing.T) {
+	auth := newTestAuth(&fakeReviewer{}, testingclock.NewFakeClock(time.Now()))
+	user := &UserInfo{Name: "alice"}
+	tests := []struct {
+		method      string
+		path        string
+		verb        string
+		subresource string
+	}{
+		{method: http.MethodGet, path: "/stats/summary", verb: "get", subresource: "stats"},
+		{method: http.MethodGet, path: "/stats", verb: "get", subresource: "stats"},
+		{method: http.MethodGet, path: "/metrics", verb: "get", subresource: "metrics"},
+		{method: http.MethodGet, path: "/metrics/resource", verb: "get", subresource: "metrics"},
+		{method: http.MethodGet, path: "/logs/syslog", verb: "get", subresource: "log"},
+		{method: http.MethodGet, path: "/pods", verb: "get", subresource: "proxy"},
+		{method: http.MethodGet, path: "/containerLogs/default/pod/c", verb: "get", subresource: "proxy"},
+		{method: http.MethodPost, path: "/exec/default/pod/c", verb: "create", subresource: "proxy"},
+		{method: http.MethodGet, path: "/statsfoo", verb: "get", subresource: "proxy"},
+		{method: http.MethodPut, path: "/", verb: "update", subresource: "proxy"},
+		{method: http.MethodDelete, path: "/", verb: "delete", subresource: "proxy"},
+	}
+	for _, test := range tests {
+		attrs := auth.GetRequestAttributes(user, httptest.NewRequest(test.method, test.path, nil))
+		if attrs.Verb != test.verb || attrs.Subresource != test.subresource || attrs.Resource != "nodes" || attrs.Name != "node" {
+			t.Errorf("%s %s: expected %s nodes/%s, got %+v", test.method, test.path, test.verb, test.subresource, attrs)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	reviewer := &fakeReviewer{allowed: map[string][]string{"alice": {"stats", "metrics"}}}
+	auth := newTestAuth(reviewer, testingclock.NewFakeClock(time.Now()))
+	alice := &UserInfo{Name: "alice"}
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{path: "/stats/summary", allowed: true},
+		{path: "/metrics", allowed: true},
+		{path: "/logs/", allowed: false},
+		{path: "/pods", allowed: false},
+	}
+	for _, test := range tests {
+		attrs := auth.GetRequestAttributes(alice, httptest.NewRequest(http.MethodGet, test.path, nil))
+		allowed, reason, err := auth.Authorize(context.Background(), attrs)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.path, err)
+		}
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v (%s)", test.path, test.allowed, allowed, reason)
+		}
+		if !allowed && reason == "" {
+			t.Errorf("%s: expected deny reason", test.path)
+		}
+	}
+}
+
+func TestDecisionCache(t *testing.T) {
+	reviewer := &fakeReviewer{allowed: map[string][]string{"alice": {"stats"}}}
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	auth := newTestAuth(reviewer, fakeClock)
+	alice := &UserInfo{Name: "alice"}
+
+	authorize := func(path string) bool {
+		attrs := auth.GetRequestAttributes(alice, httptest.NewRequest(http.MethodGet, path, nil))
+		allowed, _, err := auth.Authorize(context.Background(), attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+	expectReviews := func(expected int, msg string) {
+		t.Helper()
+		if _, accessReviews := reviewer.counts(); accessReviews != expected {
+			t.Errorf("%s: expected %d access reviews, got %d", msg, expected, accessReviews)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if !authorize("/stats/summary") || authorize("/pods") {
+			t.Fatal("unexpected authorization decision")
+		}
+	}
+	expectReviews(2, "cached decisions")
+
+	// 拒绝的结果先过期
+	fakeClock.Step(testUnauthorizedTTL + time.Second)
+	authorize("/stats/summary")
+	authorize("/pods")
+	expectReviews(3, "unauthorized expired")
+
+	fakeClock.Step(testAuthorizedTTL)
+	authorize("/stats/summary")
+	expectReviews(4, "authorized expired")
+
+	// 不同的用户或子资源使用不同的缓存项
+	authorize("/metrics")
+	expectReviews(5, "different subresource")
+}
+
+func TestAuthorizeError(t *testing.T) {
+	reviewer := &fakeReviewer{err: fmt.Errorf("apiserver unavailable")}
+	auth := newTestAuth(reviewer, testingclock.NewFakeClock(time.Now()))
+	attrs := auth.GetRequestAttributes(&UserInfo{Name: "alice"}, httptest.NewRequest(http.MethodGet, "/pods", nil))
+	if allowed, _, err := auth.Authorize(context.Background(), attrs); err == nil || allowed {
+		t.Errorf("expected error and deny, got %v %v", allowed, err)
+	}
+	reviewer.setErr(nil)
+	if _, _, err := auth.Authorize(context.Background(), attrs); err != nil {
+		t.Errorf("expected errors not to be cached, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/klog/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// 检查证书文件是否更新的间隔
+const certificateReloadInterval = time.Minute
+
+// certificateLoader 从文件加载服务端证书，文件更新后重新加载，不需要重启server
+type certificateLoader struct {
+	certFile string
+	keyFile  string
+
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+	// 上一次加载时文件的修改时间
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertificateLoader 证书文件不存在时生成自签名证书
+// cmd/kubelet/app/server.go InitializeTLS
+func newCertificateLoader(certFile, keyFile, host string) (*certificateLoader, error) {
+	if err := ensureSelfSignedCert(certFile, keyFile, host); err != nil {
+		return nil, err
+	}
+	loader := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	if err := loader.reload(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// Run 定期检查证书文件是否变化
+func (this *certificateLoader) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := this.reload(); err != nil {
+			klog.ErrorS(err, "Failed to reload serving certificate", "certFile", this.certFile, "keyFile", this.keyFile)
+		}
+	}, certificateReloadInterval, stopCh)
+}
+
+// GetCertificate 作为tls.Config.GetCertificate，每次握手时返回当前的证书
+func (this *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.certificate, nil
+}
+
+// NotAfter 当前证书的过期时间
+func (this *certificateLoader) NotAfter() time.Time {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.certificate == nil || len(this.certificate.Certificate) == 0 {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(this.certificate.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
+
+// 文件修改时间变化时重新加载证书
+func (this *certificateLoader) reload() error {
+	certInfo, err := os.Stat(this.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(this.keyFile)
+	if err != nil {
+		return err
+	}
+
+	this.lock.RLock()
+	unchanged := this.certificate != nil && certInfo.ModTime().Equal(this.certModTime) && keyInfo.ModTime().Equal(this.keyModTime)
+	this.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(this.certFile, this.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load serving certificate: %v", err)
+	}
+
+	this.lock.Lock()
+	this.certificate = &certificate
+	this.certModTime = certInfo.ModTime()
+	this.keyModTime = keyInfo.ModTime()
+	this.lock.Unlock()
+
+	klog.InfoS("Loaded serving certificate", "certFile", this.certFile, "keyFile", this.keyFile)
+	return nil
+}
+
+// 证书或私钥不存在时，生成自签名证书保存到文件
+func ensureSelfSignedCert(certFile, keyFile, host string) error {
+	canReadCertAndKey, err := cert.CanReadCertAndKey(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if canReadCertAndKey {
+		return nil
+	}
+
+	certData, keyData, err := cert.GenerateSelfSignedCertKey(host, nil, nil)
+	if err != nil {
+		return fmt.Errorf("unable to generate self signed cert: %v", err)
+	}
+	if err = cert.WriteCert(certFile, certData); err != nil {
+		return err
+	}
+	if err = keyutil.WriteKey(keyFile, keyData); err != nil {
+		return err
+	}
+	klog.InfoS("Generated self-signed serving certificate", "certFile", certFile, "keyFile", keyFile)
+	return nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"io"
+	"net/http"
+)
+
+// flushWriter 每次写入后立即flush，用于follow日志等流式响应
+// pkg/util/flushwriter/writer.go
+type flushWriter struct {
+	flusher http.Flusher
+	writer  io.Writer
+	// 是否已经写入过数据，写入后无法再修改响应码
+	written bool
+}
+
+func newFlushWriter(w io.Writer) *flushWriter {
+	fw := &flushWriter{writer: w}
+	if flusher, ok := w.(http.Flusher); ok {
+		fw.flusher = flusher
+	}
+	return fw
+}
+
+func (this *flushWriter) Write(p []byte) (int, error) {
+	n, err := this.writer.Write(p)
+	if n > 0 {
+		this.written = true
+	}
+	if err != nil {
+		return n, err
+	}
+	if this.flusher != nil {
+		this.flusher.Flush()
+	}
+	return n, nil
+}
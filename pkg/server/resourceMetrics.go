@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"net/http"
+	"time"
+)
+
+// /metrics/resource的指标，metrics-server从这里采集CPU和内存
+// pkg/kubelet/metrics/collectors/resource_metrics.go
+var (
+	nodeCPUUsageDesc = prometheus.NewDesc("node_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the node in core-seconds",
+		nil, nil)
+	nodeMemoryUsageDesc = prometheus.NewDesc("node_memory_working_set_bytes",
+		"Current working set of the node in bytes",
+		nil, nil)
+	containerCPUUsageDesc = prometheus.NewDesc("container_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the container in core-seconds",
+		[]string{"container", "pod", "namespace"}, nil)
+	containerMemoryUsageDesc = prometheus.NewDesc("container_memory_working_set_bytes",
+		"Current working set of the container in bytes",
+		[]string{"container", "pod", "namespace"}, nil)
+	containerStartTimeDesc = prometheus.NewDesc("container_start_time_seconds",
+		"Start time of the container since unix epoch in seconds",
+		[]string{"container", "pod", "namespace"}, nil)
+	podCPUUsageDesc = prometheus.NewDesc("pod_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the pod in core-seconds",
+		[]string{"pod", "namespace"}, nil)
+	podMemoryUsageDesc = prometheus.NewDesc("pod_memory_working_set_bytes",
+		"Current working set of the pod in bytes",
+		[]string{"pod", "namespace"}, nil)
+	resourceScrapeResultDesc = prometheus.NewDesc("scrape_error",
+		"1 if there was an error while getting container metrics, 0 otherwise",
+		nil, nil)
+)
+
+// 采集超时时间
+const resourceMetricsTimeout = 30 * time.Second
+
+type summaryProvider interface {
+	GetSummary(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.Summary, error)
+}
+
+// 每次采集时读取最新的统计，指标带上统计的时间戳
+type resourceMetricsCollector struct {
+	provider summaryProvider
+}
+
+var _ prometheus.Collector = &resourceMetricsCollector{}
+
+// newResourceMetricsHandler 使用独立的registry，只输出资源指标
+func newResourceMetricsHandler(provider summaryProvider) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&resourceMetricsCollector{provider: provider})
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+func (this *resourceMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeCPUUsageDesc
+	ch <- nodeMemoryUsageDesc
+	ch <- containerCPUUsageDesc
+	ch <- containerMemoryUsageDesc
+	ch <- containerStartTimeDesc
+	ch <- podCPUUsageDesc
+	ch <- podMemoryUsageDesc
+	ch <- resourceScrapeResultDesc
+}
+
+func (this *resourceMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), resourceMetricsTimeout)
+	defer cancel()
+
+	var errorCount float64
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(resourceScrapeResultDesc, prometheus.GaugeValue, errorCount)
+	}()
+
+	summary, err := this.provider.GetSummary(ctx, true)
+	if err != nil {
+		errorCount = 1
+		klog.ErrorS(err, "Error getting summary for resourceMetric prometheus endpoint")
+		return
+	}
+
+	collectCPU(ch, nodeCPUUsageDesc, summary.Node.CPU)
+	collectMemory(ch, nodeMemoryUsageDesc, summary.Node.Memory)
+	for _, pod := range summary.Pods {
+		for _, c := range pod.Containers {
+			labels := []string{c.Name, pod.PodRef.Name, pod.PodRef.Namespace}
+			ch <- prometheus.NewMetricWithTimestamp(c.StartTime.Time,
+				prometheus.MustNewConstMetric(containerStartTimeDesc, prometheus.GaugeValue,
+					float64(c.StartTime.UnixNano())/float64(time.Second), labels...))
+			collectCPU(ch, containerCPUUsageDesc, c.CPU, labels...)
+			collectMemory(ch, containerMemoryUsageDesc, c.Memory, labels...)
+		}
+		labels := []string{pod.PodRef.Name, pod.PodRef.Namespace}
+		collectCPU(ch, podCPUUsageDesc, pod.CPU, labels...)
+		collectMemory(ch, podMemoryUsageDesc, pod.Memory, labels...)
+	}
+}
+
+// CPU累计时间从纳秒转换为秒
+func collectCPU(ch chan<- prometheus.Metric, desc *prometheus.Desc, s *statsapi.CPUStats, labels ...string) {
+	if s == nil || s.UsageCoreNanoSeconds == nil {
+		return
+	}
+	ch <- prometheus.NewMetricWithTimestamp(s.Time.Time,
+		prometheus.MustNewConstMetric(desc, prometheus.CounterValue,
+			float64(*s.UsageCoreNanoSeconds)/float64(time.Second), labels...))
+}
+
+func collectMemory(ch chan<- prometheus.Metric, desc *prometheus.Desc, s *statsapi.MemoryStats, labels ...string) {
+	if s == nil || s.WorkingSetBytes == nil {
+		return
+	}
+	ch <- prometheus.NewMetricWithTimestamp(s.Time.Time,
+		prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(*s.WorkingSetBytes), labels...))
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"io"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"mykubelet/pkg/stats"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// /metrics/resource的输出来自stats.Provider读取的cgroup v2树和/proc
+func TestResourceMetrics(t *testing.T) {
+	containerID := strings.Repeat("a", 64)
+	sandboxID := strings.Repeat("b", 64)
+	podDir := "kubepods/besteffort/pod11111111-2222-3333-4444-555555555555"
+	cgroupRoot, procRoot := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(cgroupRoot, "cgroup.controllers"):                  "cpu memory\n",
+		filepath.Join(cgroupRoot, "cpu.stat"):                            "usage_usec 4500000\n",
+		filepath.Join(cgroupRoot, podDir, "cpu.stat"):                    "usage_usec 3000000\n",
+		filepath.Join(cgroupRoot, podDir, "memory.current"):              "314572800\n",
+		filepath.Join(cgroupRoot, podDir, "memory.stat"):                 "inactive_file 104857600\n",
+		filepath.Join(cgroupRoot, podDir, containerID, "cpu.stat"):       "usage_usec 2000000\n",
+		filepath.Join(cgroupRoot, podDir, containerID, "memory.current"): "209715200\n",
+		filepath.Join(cgroupRoot, podDir, containerID, "memory.stat"):    "inactive_file 52428800\n",
+		filepath.Join(cgroupRoot, podDir, sandboxID, "cgroup.procs"):     "42\n",
+		filepath.Join(procRoot, "stat"):                                  "btime 1700000000\n",
+		filepath.Join(procRoot, "meminfo"):                               "MemTotal: 8000000 kB\nMemFree: 2000000 kB\nInactive(file): 1000000 kB\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Unix(1700000100, 0)
+	runtime := containertest.NewFakeRuntime()
+	runtime.Pods = []*container.Pod{{
+		ID:        "11111111-2222-3333-4444-555555555555",
+		Name:      "web",
+		Namespace: "default",
+		Sandboxes: []*container.Container{{
+			ID:    container.ContainerID{Type: "containerd", ID: sandboxID},
+			State: container.ContainerStateRunning,
+		}},
+		Containers: []*container.Container{{
+			ID:      container.ContainerID{Type: "containerd", ID: containerID},
+			Name:    "app",
+			State:   container.ContainerStateRunning,
+			Created: created,
+		}},
+	}}
+	provider := stats.NewProvider("node", runtime, cgroupRoot, procRoot, t.TempDir(), testingclock.NewFakeClock(time.Unix(1700001000, 0)))
+
+	server := httptest.NewServer(newResourceMetricsHandler(provider))
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 指标带有统计时间的毫秒时间戳
+	timestamp := " 1700001000000"
+	for _, expected := range []string{
+		"node_cpu_usage_seconds_total 4.5" + timestamp,
+		"node_memory_working_set_bytes 5.12e+09" + timestamp,
+		`pod_cpu_usage_seconds_total{namespace="default",pod="web"} 3` + timestamp,
+		`pod_memory_working_set_bytes{namespace="default",pod="web"} 2.097152e+08` + timestamp,
+		`container_cpu_usage_seconds_total{container="app",namespace="default",pod="web"} 2` + timestamp,
+		`container_memory_working_set_bytes{container="app",namespace="default",pod="web"} 1.572864e+08` + timestamp,
+		`container_start_time_seconds{container="app",namespace="default",pod="web"} 1.7000001e+09 1700000100000`,
+		"scrape_error 0",
+	} {
+		if !strings.Contains(string(body), expected+"\n") {
+			t.Errorf("expected %q in output:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"mykubelet/pkg/config"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/machine"
+	"mykubelet/pkg/metrics"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostInterface server需要从kubelet获取的数据
+type HostInterface interface {
+	GetPods() []*v1.Pod
+	GetPodByName(namespace, name string) (*v1.Pod, bool)
+	GetCachedMachineInfo() (*machine.MachineInfo, error)
+	GetKubeletContainerLogs(ctx context.Context, namespace, podName, containerName string,
+		logOptions *v1.PodLogOptions, stdout, stderr io.Writer) error
+	GetExec(ctx context.Context, namespace, podName string, podUID types.UID, containerName string,
+		cmd []string, opts container.StreamOptions) (*url.URL, error)
+	GetAttach(ctx context.Context, namespace, podName string, podUID types.UID, containerName string,
+		opts container.StreamOptions) (*url.URL, error)
+	GetPortForward(ctx context.Context, namespace, podName string, podUID types.UID, ports []int32) (*url.URL, error)
+	GetSummary(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.Summary, error)
+}
+
+// Server kubelet的https server，监听节点DaemonEndpoints中声明的端口
+// pkg/kubelet/server/server.go
+type Server struct {
+	host          HostInterface
+	auth          AuthInterface
+	kubeletConfig *config.KubeletConfiguration
+	mux           *http.ServeMux
+}
+
+func NewServer(host HostInterface, auth AuthInterface, kubeletConfig *config.KubeletConfiguration) *Server {
+	server := &Server{
+		host:          host,
+		auth:          auth,
+		kubeletConfig: kubeletConfig,
+		mux:           http.NewServeMux(),
+	}
+	server.InstallDefaultHandlers()
+	return server
+}
+
+// ListenAndServeKubeletServer 启动https server，阻塞直到出错
+// 客户端证书使用集群ca校验，服务端证书文件更新后自动重新加载
+func ListenAndServeKubeletServer(host HostInterface, auth AuthInterface, kubeletConfig *config.KubeletConfiguration) error {
+	loader, err := newCertificateLoader(kubeletConfig.TLSCertFile, kubeletConfig.TLSPrivateKeyFile, kubeletConfig.NodeName)
+	if err != nil {
+		return err
+	}
+	go loader.Run(wait.NeverStop)
+	metrics.RegisterCertificateExpiry(metrics.CertificateTypeServing, loader.NotAfter)
+
+	clientCAs, err := cert.NewPool(kubeletConfig.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client CA file %s: %v", kubeletConfig.ClientCAFile, err)
+	}
+
+	address := net.JoinHostPort(kubeletConfig.Address, strconv.Itoa(int(kubeletConfig.Port)))
+	klog.InfoS("Starting to listen", "address", address)
+	s := &http.Server{
+		Addr:    address,
+		Handler: NewServer(host, auth, kubeletConfig),
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: loader.GetCertificate,
+			// apiServer通过集群ca签发的客户端证书访问kubelet
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	return s.ListenAndServeTLS("", "")
+}
+
+// InstallDefaultHandlers 注册默认的接口
+func (this *Server) InstallDefaultHandlers() {
+	this.mux.HandleFunc("/healthz", this.handleHealthz)
+	this.mux.HandleFunc("/pods", this.getPods)
+	this.mux.HandleFunc("/spec/", this.getSpec)
+	this.mux.HandleFunc("/configz", this.getConfigz)
+	this.mux.HandleFunc("/containerLogs/", this.getContainerLogs)
+	this.mux.HandleFunc("/exec/", this.getExec)
+	this.mux.HandleFunc("/attach/", this.getAttach)
+	this.mux.HandleFunc("/portForward/", this.getPortForward)
+	this.mux.HandleFunc("/stats/summary", this.getStatsSummary)
+	this.mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}))
+	this.mux.Handle("/metrics/resource", newResourceMetricsHandler(this.host))
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	if this.authorizeRequest(w, req) {
+		this.mux.ServeHTTP(w, req)
+	}
+	klog.V(3).InfoS("HTTP", "verb", req.Method, "URI", req.RequestURI, "latency", time.Since(start),
+		"userAgent", req.UserAgent(), "srcIP", req.RemoteAddr)
+}
+
+// 认证和鉴权，失败时写入401/403并返回false
+// pkg/kubelet/server/server.go InstallAuthFilter
+func (this *Server) authorizeRequest(w http.ResponseWriter, req *http.Request) bool {
+	user, ok, err := this.auth.AuthenticateRequest(req)
+	if err != nil {
+		klog.ErrorS(err, "Unable to authenticate the request due to an error")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	attrs := this.auth.GetRequestAttributes(user, req)
+	allowed, _, err := this.auth.Authorize(req.Context(), attrs)
+	if err != nil {
+		msg := fmt.Sprintf("Authorization error (user=%s, verb=%s, resource=%s, subresource=%s)", user.Name, attrs.Verb, attrs.Resource, attrs.Subresource)
+		klog.ErrorS(err, msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		msg := fmt.Sprintf("Forbidden (user=%s, verb=%s, resource=%s, subresource=%s)", user.Name, attrs.Verb, attrs.Resource, attrs.Subresource)
+		klog.V(2).InfoS(msg, "path", req.URL.Path)
+		http.Error(w, msg, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (this *Server) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// getPods 返回本节点的pod
+func (this *Server) getPods(w http.ResponseWriter, req *http.Request) {
+	pods := this.host.GetPods()
+	podList := v1.PodList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+		Items:    make([]v1.Pod, 0, len(pods)),
+	}
+	for _, pod := range pods {
+		podList.Items = append(podList.Items, *pod)
+	}
+	writeJSONResponse(w, podList)
+}
+
+// getSpec 返回节点的硬件信息
+func (this *Server) getSpec(w http.ResponseWriter, req *http.Request) {
+	info, err := this.host.GetCachedMachineInfo()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, info)
+}
+
+// getConfigz 返回kubelet的配置
+func (this *Server) getConfigz(w http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(w, map[string]interface{}{"kubeletconfig": this.kubeletConfig})
+}
+
+// getStatsSummary 返回节点和pod的资源使用，only_cpu_and_memory=true时只统计CPU和内存
+func (this *Server) getStatsSummary(w http.ResponseWriter, req *http.Request) {
+	onlyCPUAndMemory := false
+	if v := req.URL.Query().Get("only_cpu_and_memory"); v != "" {
+		var err error
+		if onlyCPUAndMemory, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid only_cpu_and_memory %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	summary, err := this.host.GetSummary(req.Context(), onlyCPUAndMemory)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get summary stats")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, summary)
+}
+
+// getContainerLogs 读取容器日志，路径为/containerLogs/{namespace}/{pod}/{container}
+func (this *Server) getContainerLogs(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/containerLogs/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		http.Error(w, `{"message": "Missing podNamespace, podID or containerName."}`, http.StatusBadRequest)
+		return
+	}
+	podNamespace, podID, containerName := parts[0], parts[1], parts[2]
+
+	logOptions, err := parseLogOptions(req.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": "Unable to decode query: %v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	pod, ok := this.host.GetPodByName(podNamespace, podID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("pod %q does not exist", podID), http.StatusNotFound)
+		return
+	}
+	if !podHasContainer(pod, containerName) {
+		http.Error(w, fmt.Sprintf("container %q not found in pod %q", containerName, podID), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fw := newFlushWriter(w)
+	if err = this.host.GetKubeletContainerLogs(req.Context(), podNamespace, podID, containerName, logOptions, fw, fw); err != nil {
+		if !fw.written {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		klog.ErrorS(err, "Failed to stream container logs", "pod", klog.KRef(podNamespace, podID), "containerName", containerName)
+	}
+}
+
+// 解析kubectl logs的查询参数
+func parseLogOptions(query url.Values) (*v1.PodLogOptions, error) {
+	logOptions := &v1.PodLogOptions{}
+	var err error
+	parseBool := func(name string, into *bool) {
+		if v := query.Get(name); v != "" && err == nil {
+			if *into, err = strconv.ParseBool(v); err != nil {
+				err = fmt.Errorf("invalid %s %q", name, v)
+			}
+		}
+	}
+	parseInt := func(name string, min int64) *int64 {
+		v := query.Get(name)
+		if v == "" || err != nil {
+			return nil
+		}
+		i, parseErr := strconv.ParseInt(v, 10, 64)
+		if parseErr != nil || i < min {
+			err = fmt.Errorf("invalid %s %q", name, v)
+			return nil
+		}
+		return &i
+	}
+	parseBool("follow", &logOptions.Follow)
+	parseBool("previous", &logOptions.Previous)
+	parseBool("timestamps", &logOptions.Timestamps)
+	logOptions.TailLines = parseInt("tailLines", 0)
+	logOptions.LimitBytes = parseInt("limitBytes", 1)
+	logOptions.SinceSeconds = parseInt("sinceSeconds", 1)
+	if v := query.Get("sinceTime"); v != "" && err == nil {
+		t, parseErr := time.Parse(time.RFC3339, v)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid sinceTime %q", v)
+		} else {
+			logOptions.SinceTime = &metav1.Time{Time: t}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if logOptions.SinceSeconds != nil && logOptions.SinceTime != nil {
+		return nil, fmt.Errorf("at most one of sinceTime or sinceSeconds may be specified")
+	}
+	return logOptions, nil
+}
+
+func podHasContainer(pod *v1.Pod, containerName string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return true
+		}
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == containerName {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSONResponse(w http.ResponseWriter, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		klog.ErrorS(err, "Error writing response")
+	}
+}
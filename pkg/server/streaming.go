@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/proxy"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// exec/attach/portForward的请求由运行时的streaming server处理
+// kubelet只负责定位容器，再把升级后的SPDY/WebSocket连接原样代理过去
+// 标准流、tty和窗口大小调整都由运行时按remotecommand/portforward协议实现
+// pkg/kubelet/server/server.go
+
+// 路径中的pod和容器，podUID可以为空
+type streamRequestParams struct {
+	podNamespace  string
+	podName       string
+	podUID        types.UID
+	containerName string
+}
+
+// 解析 /{prefix}/{namespace}/{pod}[/{uid}]/{container}
+// withContainer为false时解析 /{prefix}/{namespace}/{pod}[/{uid}]
+func parseStreamRequestParams(path, prefix string, withContainer bool) (*streamRequestParams, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+	for _, part := range parts {
+		if part == "" {
+			return nil, false
+		}
+	}
+	params := &streamRequestParams{}
+	if withContainer {
+		switch len(parts) {
+		case 3:
+			params.podNamespace, params.podName, params.containerName = parts[0], parts[1], parts[2]
+		case 4:
+			params.podNamespace, params.podName, params.podUID, params.containerName = parts[0], parts[1], types.UID(parts[2]), parts[3]
+		default:
+			return nil, false
+		}
+		return params, true
+	}
+	switch len(parts) {
+	case 2:
+		params.podNamespace, params.podName = parts[0], parts[1]
+	case 3:
+		params.podNamespace, params.podName, params.podUID = parts[0], parts[1], types.UID(parts[2])
+	default:
+		return nil, false
+	}
+	return params, true
+}
+
+// 解析需要连接的标准流，至少需要一个
+// apiServer传的是"1"，直接访问kubelet时也接受"true"
+func parseStreamOptions(query url.Values) (container.StreamOptions, error) {
+	isSet := func(name string) bool {
+		b, _ := strconv.ParseBool(query.Get(name))
+		return b
+	}
+	opts := container.StreamOptions{
+		Stdin:  isSet(v1.ExecStdinParam),
+		Stdout: isSet(v1.ExecStdoutParam),
+		Stderr: isSet(v1.ExecStderrParam),
+		TTY:    isSet(v1.ExecTTYParam),
+	}
+	// tty模式下没有单独的stderr
+	if opts.TTY && opts.Stderr {
+		opts.Stderr = false
+	}
+	if !opts.Stdin && !opts.Stdout && !opts.Stderr {
+		return opts, fmt.Errorf("you must specify at least 1 of stdin, stdout, stderr")
+	}
+	return opts, nil
+}
+
+// 解析需要转发的端口，可以为空，由客户端在建立的流中指定
+func parsePorts(query url.Values) ([]int32, error) {
+	ports := []int32{}
+	for _, v := range query[v1.PortHeader] {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port %q", v)
+		}
+		ports = append(ports, int32(port))
+	}
+	return ports, nil
+}
+
+// getExec 路径为/exec/{namespace}/{pod}[/{uid}]/{container}，命令通过command参数传递
+func (this *Server) getExec(w http.ResponseWriter, req *http.Request) {
+	params, ok := parseStreamRequestParams(req.URL.Path, "/exec/", true)
+	if !ok {
+		http.Error(w, `{"message": "Missing podNamespace, podID or containerName."}`, http.StatusBadRequest)
+		return
+	}
+	query := req.URL.Query()
+	opts, err := parseStreamOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmd := query[v1.ExecCommandParam]
+	if len(cmd) == 0 {
+		http.Error(w, "you must specify a command", http.StatusBadRequest)
+		return
+	}
+	if !this.podExists(w, params) {
+		return
+	}
+	streamURL, err := this.host.GetExec(req.Context(), params.podNamespace, params.podName, params.podUID,
+		params.containerName, cmd, opts)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get exec url", "pod", klog.KRef(params.podNamespace, params.podName), "containerName", params.containerName)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	this.proxyStream(w, req, streamURL)
+}
+
+// getAttach 路径为/attach/{namespace}/{pod}[/{uid}]/{container}
+func (this *Server) getAttach(w http.ResponseWriter, req *http.Request) {
+	params, ok := parseStreamRequestParams(req.URL.Path, "/attach/", true)
+	if !ok {
+		http.Error(w, `{"message": "Missing podNamespace, podID or containerName."}`, http.StatusBadRequest)
+		return
+	}
+	opts, err := parseStreamOptions(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !this.podExists(w, params) {
+		return
+	}
+	streamURL, err := this.host.GetAttach(req.Context(), params.podNamespace, params.podName, params.podUID,
+		params.containerName, opts)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get attach url", "pod", klog.KRef(params.podNamespace, params.podName), "containerName", params.containerName)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	this.proxyStream(w, req, streamURL)
+}
+
+// getPortForward 路径为/portForward/{namespace}/{pod}[/{uid}]
+func (this *Server) getPortForward(w http.ResponseWriter, req *http.Request) {
+	params, ok := parseStreamRequestParams(req.URL.Path, "/portForward/", false)
+	if !ok {
+		http.Error(w, `{"message": "Missing podNamespace or podID."}`, http.StatusBadRequest)
+		return
+	}
+	ports, err := parsePorts(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !this.podExists(w, params) {
+		return
+	}
+	streamURL, err := this.host.GetPortForward(req.Context(), params.podNamespace, params.podName, params.podUID, ports)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get port forward url", "pod", klog.KRef(params.podNamespace, params.podName))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	this.proxyStream(w, req, streamURL)
+}
+
+// pod不存在或uid不一致时返回404
+func (this *Server) podExists(w http.ResponseWriter, params *streamRequestParams) bool {
+	pod, ok := this.host.GetPodByName(params.podNamespace, params.podName)
+	if !ok || (params.podUID != "" && pod.UID != params.podUID) {
+		http.Error(w, fmt.Sprintf("pod %q does not exist", params.podName), http.StatusNotFound)
+		return false
+	}
+	if params.containerName != "" && !podHasContainer(pod, params.containerName) {
+		http.Error(w, fmt.Sprintf("container %q not found in pod %q", params.containerName, params.podName), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// 把升级请求代理到运行时的streaming server
+func (this *Server) proxyStream(w http.ResponseWriter, req *http.Request, streamURL *url.URL) {
+	streamURL, err := this.resolveStreamURL(streamURL)
+	if err != nil {
+		klog.ErrorS(err, "Failed to resolve streaming url")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handler := proxy.NewUpgradeAwareHandler(streamURL, nil, false, true, &responder{})
+	handler.ServeHTTP(w, req)
+}
+
+// resolveStreamURL 部分运行时只返回路径，需要以运行时streaming server的地址为基础补全
+func (this *Server) resolveStreamURL(streamURL *url.URL) (*url.URL, error) {
+	if streamURL.IsAbs() {
+		return streamURL, nil
+	}
+	if this.kubeletConfig.RuntimeStreamingBaseURL == "" {
+		return nil, fmt.Errorf("runtime returned relative streaming url %q but no runtime streaming base url is configured", streamURL)
+	}
+	baseURL, err := url.Parse(this.kubeletConfig.RuntimeStreamingBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid runtime streaming base url %q: %v", this.kubeletConfig.RuntimeStreamingBaseURL, err)
+	}
+	return baseURL.ResolveReference(streamURL), nil
+}
+
+type responder struct{}
+
+func (this *responder) Error(w http.ResponseWriter, req *http.Request, err error) {
+	klog.ErrorS(err, "Error while proxying request")
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
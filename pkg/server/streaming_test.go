@@ -0,0 +1,710 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	spdytransport "k8s.io/client-go/transport/spdy"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/utils/exec"
+	"mykubelet/pkg/config"
+	"mykubelet/pkg/container"
+	"mykubelet/pkg/machine"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testNamespace = "default"
+	testPodName   = "nginx"
+	testContainer = "app"
+)
+
+// 一次exec/attach/portForward请求在运行时中对应的会话
+type streamSession struct {
+	cmd  []string
+	opts container.StreamOptions
+	// 非0时通过error流返回退出码
+	exitCode int
+	// tty模式下等待收到这么多次窗口大小调整后再结束
+	expectResizes int
+
+	lock    sync.Mutex
+	resizes []remotecommand.TerminalSize
+	ports   []string
+}
+
+func (this *streamSession) getResizes() []remotecommand.TerminalSize {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]remotecommand.TerminalSize{}, this.resizes...)
+}
+
+func (this *streamSession) getPorts() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]string{}, this.ports...)
+}
+
+// fakeStreamingRuntime 模拟运行时的streaming server
+// exec/attach把stdin原样写回stdout，portForward把数据流原样写回
+type fakeStreamingRuntime struct {
+	*httptest.Server
+	lock     sync.Mutex
+	sessions map[string]*streamSession
+}
+
+func newFakeStreamingRuntime() *fakeStreamingRuntime {
+	runtime := &fakeStreamingRuntime{sessions: map[string]*streamSession{}}
+	runtime.Server = httptest.NewServer(http.HandlerFunc(runtime.serveHTTP))
+	return runtime
+}
+
+func (this *fakeStreamingRuntime) getSession(token string) (*streamSession, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	session, ok := this.sessions[token]
+	return session, ok
+}
+
+func (this *fakeStreamingRuntime) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	session, ok := this.getSession(parts[1])
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	switch parts[0] {
+	case "exec", "attach":
+		if wsstream.IsWebSocketRequest(req) {
+			serveWebSocket(w, req, session)
+		} else {
+			serveSPDY(w, req, session)
+		}
+	case "portforward":
+		servePortForward(w, req, session)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func serveSPDY(w http.ResponseWriter, req *http.Request, session *streamSession) {
+	if _, err := httpstream.Handshake(req, w, []string{remotecommandconsts.StreamProtocolV4Name}); err != nil {
+		return
+	}
+	streamCh := make(chan httpstream.Stream, 5)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(stream httpstream.Stream, _ <-chan struct{}) error {
+		streamCh <- stream
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	expected := 1
+	for _, enabled := range []bool{session.opts.Stdin, session.opts.Stdout, session.opts.Stderr, session.opts.TTY} {
+		if enabled {
+			expected++
+		}
+	}
+	streams := map[string]httpstream.Stream{}
+	timeout := time.After(streamTimeout)
+	for len(streams) < expected {
+		select {
+		case stream := <-streamCh:
+			streams[stream.Headers().Get(v1.StreamType)] = stream
+		case <-timeout:
+			return
+		}
+	}
+	var stdin, resize io.Reader
+	var stdout, stderr io.WriteCloser
+	if s, ok := streams[v1.StreamTypeStdin]; ok {
+		stdin = s
+	}
+	if s, ok := streams[v1.StreamTypeStdout]; ok {
+		stdout = s
+	}
+	if s, ok := streams[v1.StreamTypeStderr]; ok {
+		stderr = s
+	}
+	if s, ok := streams[v1.StreamTypeResize]; ok {
+		resize = s
+	}
+	session.run(stdin, stdout, stderr, resize, streams[v1.StreamTypeError])
+}
+
+// v4.channel.k8s.io的通道顺序为stdin、stdout、stderr、error、resize
+func serveWebSocket(w http.ResponseWriter, req *http.Request, session *streamSession) {
+	channel := func(enabled bool, t wsstream.ChannelType) wsstream.ChannelType {
+		if enabled {
+			return t
+		}
+		return wsstream.IgnoreChannel
+	}
+	conn := wsstream.NewConn(map[string]wsstream.ChannelProtocolConfig{
+		remotecommandconsts.StreamProtocolV4Name: {
+			Binary: true,
+			Channels: []wsstream.ChannelType{
+				channel(session.opts.Stdin, wsstream.ReadChannel),
+				channel(session.opts.Stdout, wsstream.WriteChannel),
+				channel(session.opts.Stderr, wsstream.WriteChannel),
+				wsstream.WriteChannel,
+				channel(session.opts.TTY, wsstream.ReadChannel),
+			},
+		},
+	})
+	_, channels, err := conn.Open(w, req)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var stdin, resize io.Reader
+	var stdout, stderr io.WriteCloser
+	if session.opts.Stdin {
+		stdin = channels[0]
+	}
+	if session.opts.Stdout {
+		stdout = channels[1]
+	}
+	if session.opts.Stderr {
+		stderr = channels[2]
+	}
+	if session.opts.TTY {
+		resize = channels[4]
+	}
+	session.run(stdin, stdout, stderr, resize, channels[3])
+}
+
+func (this *streamSession) run(stdin io.Reader, stdout, stderr io.WriteCloser, resize io.Reader, errorStream io.WriteCloser) {
+	if resize != nil {
+		go func() {
+			decoder := json.NewDecoder(resize)
+			for {
+				size := remotecommand.TerminalSize{}
+				if err := decoder.Decode(&size); err != nil {
+					return
+				}
+				this.lock.Lock()
+				this.resizes = append(this.resizes, size)
+				this.lock.Unlock()
+			}
+		}()
+	}
+	if stdout != nil {
+		if stdin != nil {
+			io.Copy(stdout, stdin)
+		} else {
+			fmt.Fprintf(stdout, "%s\n", strings.Join(this.cmd, " "))
+		}
+	}
+	if stderr != nil {
+		fmt.Fprint(stderr, "stderr output\n")
+	}
+	deadline := time.Now().Add(streamTimeout)
+	for len(this.getResizes()) < this.expectResizes && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, w := range []io.WriteCloser{stdout, stderr} {
+		if w != nil {
+			w.Close()
+		}
+	}
+	if this.exitCode != 0 {
+		json.NewEncoder(errorStream).Encode(metav1.Status{
+			Status: metav1.StatusFailure,
+			Reason: remotecommandconsts.NonZeroExitCodeReason,
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{
+					Type:    remotecommandconsts.ExitCodeCauseType,
+					Message: strconv.Itoa(this.exitCode),
+				}},
+			},
+		})
+	}
+	errorStream.Close()
+}
+
+// 每个连接的data流和error流通过requestID配对，data流的数据原样写回
+func servePortForward(w http.ResponseWriter, req *http.Request, session *streamSession) {
+	if _, err := httpstream.Handshake(req, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+		return
+	}
+	streamCh := make(chan httpstream.Stream, 4)
+	conn := spdy.NewResponseUpgrader().UpgradeResponse(w, req, func(stream httpstream.Stream, _ <-chan struct{}) error {
+		streamCh <- stream
+		return nil
+	})
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	type streamPair struct {
+		data, error httpstream.Stream
+	}
+	pairs := map[string]*streamPair{}
+	for {
+		select {
+		case stream := <-streamCh:
+			requestID := stream.Headers().Get(v1.PortForwardRequestIDHeader)
+			pair, ok := pairs[requestID]
+			if !ok {
+				pair = &streamPair{}
+				pairs[requestID] = pair
+			}
+			switch stream.Headers().Get(v1.StreamType) {
+			case v1.StreamTypeData:
+				pair.data = stream
+				session.lock.Lock()
+				session.ports = append(session.ports, stream.Headers().Get(v1.PortHeader))
+				session.lock.Unlock()
+			case v1.StreamTypeError:
+				pair.error = stream
+			}
+			if pair.data != nil && pair.error != nil {
+				delete(pairs, requestID)
+				go func() {
+					io.Copy(pair.data, pair.data)
+					pair.data.Close()
+					pair.error.Close()
+				}()
+			}
+		case <-conn.CloseChan():
+			return
+		}
+	}
+}
+
+// fakeHost 为每次请求在fakeStreamingRuntime中创建会话
+type fakeHost struct {
+	runtime *fakeStreamingRuntime
+	// 返回相对地址，模拟只返回路径的运行时
+	relativeURL bool
+	// 新建会话的退出码和期望的窗口大小调整次数
+	exitCode      int
+	expectResizes int
+
+	lock      sync.Mutex
+	nextToken int
+	last      *streamSession
+}
+
+func (this *fakeHost) newSession(kind string, session *streamSession) *url.URL {
+	session.exitCode = this.exitCode
+	session.expectResizes = this.expectResizes
+	this.lock.Lock()
+	this.nextToken++
+	token := strconv.Itoa(this.nextToken)
+	this.last = session
+	this.lock.Unlock()
+
+	this.runtime.lock.Lock()
+	this.runtime.sessions[token] = session
+	this.runtime.lock.Unlock()
+
+	streamURL := &url.URL{Path: "/" + kind + "/" + token}
+	if this.relativeURL {
+		return streamURL
+	}
+	base, _ := url.Parse(this.runtime.URL)
+	return base.ResolveReference(streamURL)
+}
+
+func (this *fakeHost) lastSession() *streamSession {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.last
+}
+
+func (this *fakeHost) GetPods() []*v1.Pod {
+	return nil
+}
+
+func (this *fakeHost) GetPodByName(namespace, name string) (*v1.Pod, bool) {
+	if namespace != testNamespace || name != testPodName {
+		return nil, false
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: "uid"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: testContainer}}},
+	}, true
+}
+
+func (this *fakeHost) GetCachedMachineInfo() (*machine.MachineInfo, error) {
+	return nil, nil
+}
+
+func (this *fakeHost) GetKubeletContainerLogs(_ context.Context, _, _, _ string, _ *v1.PodLogOptions, _, _ io.Writer) error {
+	return nil
+}
+
+func (this *fakeHost) GetExec(_ context.Context, _, _ string, _ types.UID, _ string, cmd []string,
+	opts container.StreamOptions) (*url.URL, error) {
+	return this.newSession("exec", &streamSession{cmd: cmd, opts: opts}), nil
+}
+
+func (this *fakeHost) GetAttach(_ context.Context, _, _ string, _ types.UID, _ string,
+	opts container.StreamOptions) (*url.URL, error) {
+	return this.newSession("attach", &streamSession{cmd: []string{"attach"}, opts: opts}), nil
+}
+
+func (this *fakeHost) GetPortForward(_ context.Context, _, _ string, _ types.UID, _ []int32) (*url.URL, error) {
+	return this.newSession("portforward", &streamSession{}), nil
+}
+
+func (this *fakeHost) GetSummary(_ context.Context, _ bool) (*statsapi.Summary, error) {
+	return nil, nil
+}
+
+// allowAll 允许所有请求
+type allowAll struct{}
+
+func (this allowAll) AuthenticateRequest(_ *http.Request) (*UserInfo, bool, error) {
+	return &UserInfo{Name: "admin"}, true, nil
+}
+
+func (this allowAll) GetRequestAttributes(u *UserInfo, req *http.Request) Attributes {
+	return Attributes{User: u, Verb: "create", Resource: "nodes", Path: req.URL.Path}
+}
+
+func (this allowAll) Authorize(_ context.Context, _ Attributes) (bool, string, error) {
+	return true, "", nil
+}
+
+const streamTimeout = 5 * time.Second
+
+func newStreamingTestServer(t *testing.T, host *fakeHost, kubeletConfig *config.KubeletConfiguration) *httptest.Server {
+	host.runtime = newFakeStreamingRuntime()
+	t.Cleanup(host.runtime.Close)
+	server := httptest.NewServer(NewServer(host, allowAll{}, kubeletConfig))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 依次返回预设的窗口大小，之后阻塞直到测试结束
+type sizeQueue struct {
+	sizes []remotecommand.TerminalSize
+	done  chan struct{}
+}
+
+func (this *sizeQueue) Next() *remotecommand.TerminalSize {
+	if len(this.sizes) == 0 {
+		<-this.done
+		return nil
+	}
+	size := this.sizes[0]
+	this.sizes = this.sizes[1:]
+	return &size
+}
+
+func streamSPDY(t *testing.T, serverURL, path string, query url.Values, options remotecommand.StreamOptions) error {
+	u, err := url.Parse(serverURL + path + "?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor, err := remotecommand.NewSPDYExecutor(&rest.Config{Host: serverURL}, "POST", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+	return executor.StreamWithContext(ctx, options)
+}
+
+func TestExecSPDY(t *testing.T) {
+	host := &fakeHost{}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	query := url.Values{v1.ExecCommandParam: {"cat"}, v1.ExecStdinParam: {"1"}, v1.ExecStdoutParam: {"1"}, v1.ExecStderrParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/exec/default/nginx/app", query, remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("hello from stdin\n"),
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if stdout.String() != "hello from stdin\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	if stderr.String() != "stderr output\n" {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+	if cmd := host.lastSession().cmd; len(cmd) != 1 || cmd[0] != "cat" {
+		t.Errorf("unexpected command %v", cmd)
+	}
+}
+
+func TestExecSPDYTTYResize(t *testing.T) {
+	host := &fakeHost{expectResizes: 2}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	done := make(chan struct{})
+	defer close(done)
+	queue := &sizeQueue{
+		sizes: []remotecommand.TerminalSize{{Width: 80, Height: 24}, {Width: 120, Height: 40}},
+		done:  done,
+	}
+	stdout := &bytes.Buffer{}
+	query := url.Values{v1.ExecCommandParam: {"sh"}, v1.ExecStdinParam: {"1"}, v1.ExecStdoutParam: {"1"}, v1.ExecStderrParam: {"1"}, v1.ExecTTYParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/exec/default/nginx/app", query, remotecommand.StreamOptions{
+		Stdin:             strings.NewReader("ls\n"),
+		Stdout:            stdout,
+		Tty:               true,
+		TerminalSizeQueue: queue,
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	session := host.lastSession()
+	if session.opts.Stderr {
+		t.Errorf("stderr should be disabled in tty mode")
+	}
+	if stdout.String() != "ls\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	resizes := session.getResizes()
+	expected := []remotecommand.TerminalSize{{Width: 80, Height: 24}, {Width: 120, Height: 40}}
+	if len(resizes) != len(expected) || resizes[0] != expected[0] || resizes[1] != expected[1] {
+		t.Errorf("expected resizes %v, got %v", expected, resizes)
+	}
+}
+
+func TestExecSPDYExitCode(t *testing.T) {
+	host := &fakeHost{exitCode: 3}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	query := url.Values{v1.ExecCommandParam: {"false"}, v1.ExecStdoutParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/exec/default/nginx/app", query, remotecommand.StreamOptions{
+		Stdout: io.Discard,
+	})
+	exitErr, ok := err.(exec.ExitError)
+	if !ok {
+		t.Fatalf("expected exit error, got %v", err)
+	}
+	if exitErr.ExitStatus() != 3 {
+		t.Errorf("expected exit code 3, got %d", exitErr.ExitStatus())
+	}
+}
+
+func TestAttachSPDY(t *testing.T) {
+	host := &fakeHost{}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	stdout := &bytes.Buffer{}
+	query := url.Values{v1.ExecStdinParam: {"1"}, v1.ExecStdoutParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/attach/default/nginx/uid/app", query, remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("attached\n"),
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatalf("attach failed: %v", err)
+	}
+	if stdout.String() != "attached\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+}
+
+// 运行时返回相对地址时以RuntimeStreamingBaseURL为基础
+func TestExecRelativeStreamURL(t *testing.T) {
+	host := &fakeHost{relativeURL: true}
+	kubeletConfig := &config.KubeletConfiguration{}
+	server := newStreamingTestServer(t, host, kubeletConfig)
+	kubeletConfig.RuntimeStreamingBaseURL = host.runtime.URL
+
+	stdout := &bytes.Buffer{}
+	query := url.Values{v1.ExecCommandParam: {"echo", "relative"}, v1.ExecStdoutParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/exec/default/nginx/app", query, remotecommand.StreamOptions{
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if stdout.String() != "echo relative\n" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+}
+
+func TestExecRelativeStreamURLWithoutBase(t *testing.T) {
+	host := &fakeHost{relativeURL: true}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	query := url.Values{v1.ExecCommandParam: {"ls"}, v1.ExecStdoutParam: {"1"}}
+	err := streamSPDY(t, server.URL, "/exec/default/nginx/app", query, remotecommand.StreamOptions{
+		Stdout: io.Discard,
+	})
+	if err == nil {
+		t.Fatalf("expected an error for a relative url without base url")
+	}
+}
+
+func TestResolveStreamURL(t *testing.T) {
+	testCases := []struct {
+		name      string
+		baseURL   string
+		streamURL string
+		expected  string
+		expectErr bool
+	}{
+		{name: "absolute", streamURL: "http://10.0.0.1:1234/exec/abc", expected: "http://10.0.0.1:1234/exec/abc"},
+		{name: "relative", baseURL: "http://127.0.0.1:10010", streamURL: "/exec/abc", expected: "http://127.0.0.1:10010/exec/abc"},
+		{name: "relative with base path", baseURL: "http://127.0.0.1:10010/cri/", streamURL: "exec/abc", expected: "http://127.0.0.1:10010/cri/exec/abc"},
+		{name: "relative without base", streamURL: "/exec/abc", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer(&fakeHost{}, allowAll{}, &config.KubeletConfiguration{RuntimeStreamingBaseURL: tc.baseURL})
+			streamURL, _ := url.Parse(tc.streamURL)
+			resolved, err := server.resolveStreamURL(streamURL)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", resolved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resolved.String() != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, resolved)
+			}
+		})
+	}
+}
+
+// 接收一帧，返回通道号和数据，忽略建立连接后的空帧
+func receiveFrame(t *testing.T, ws *websocket.Conn) (byte, []byte) {
+	for {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatalf("failed to receive frame: %v", err)
+		}
+		if len(frame) > 1 {
+			return frame[0], frame[1:]
+		}
+	}
+}
+
+func TestExecWebSocket(t *testing.T) {
+	host := &fakeHost{expectResizes: 1}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/exec/default/nginx/app?" +
+		url.Values{v1.ExecCommandParam: {"sh"}, v1.ExecStdinParam: {"1"}, v1.ExecStdoutParam: {"1"}, v1.ExecTTYParam: {"1"}}.Encode()
+	wsConfig, err := websocket.NewConfig(wsURL, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsConfig.Protocol = []string{remotecommandconsts.StreamProtocolV4Name}
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(streamTimeout))
+
+	resize, _ := json.Marshal(remotecommand.TerminalSize{Width: 100, Height: 30})
+	if err := websocket.Message.Send(ws, append([]byte{4}, resize...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := websocket.Message.Send(ws, append([]byte{0}, "hello"...)); err != nil {
+		t.Fatal(err)
+	}
+	channel, data := receiveFrame(t, ws)
+	if channel != 1 || string(data) != "hello" {
+		t.Errorf("expected stdout frame %q, got channel %d data %q", "hello", channel, data)
+	}
+
+	session := host.lastSession()
+	deadline := time.Now().Add(streamTimeout)
+	for len(session.getResizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	resizes := session.getResizes()
+	if len(resizes) != 1 || resizes[0] != (remotecommand.TerminalSize{Width: 100, Height: 30}) {
+		t.Errorf("unexpected resizes %v", resizes)
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	host := &fakeHost{}
+	server := newStreamingTestServer(t, host, &config.KubeletConfiguration{})
+
+	transport, upgrader, err := spdytransport.RoundTripperFor(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/portForward/default/nginx?port=8080")
+	dialer := spdytransport.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", u)
+	stopCh, readyCh := make(chan struct{}), make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{"0:8080"}, stopCh, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarder.ForwardPorts()
+	}()
+	defer func() {
+		close(stopCh)
+		<-errCh
+	}()
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		t.Fatalf("port forward failed: %v", err)
+	case <-time.After(streamTimeout):
+		t.Fatalf("timed out waiting for port forward")
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(ports[0].Local))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(streamTimeout))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	conn.Close()
+	if string(reply) != "ping" {
+		t.Errorf("expected echo %q, got %q", "ping", reply)
+	}
+	if ports := host.lastSession().getPorts(); len(ports) != 1 || ports[0] != "8080" {
+		t.Errorf("expected forwarded port 8080, got %v", ports)
+	}
+}
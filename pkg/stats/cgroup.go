@@ -0,0 +1,232 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cgroup v1中没有限制时memory.limit_in_bytes是一个接近int64最大值的数
+	cgroupV1MemoryUnlimited = uint64(1) << 62
+	// 容器ID是64位十六进制字符串
+	containerIDLength = 64
+)
+
+// cgroupReader 读取cgroup文件，同时支持v2统一层级和v1按子系统分开的层级
+type cgroupReader struct {
+	// cgroup文件系统的挂载点，一般为/sys/fs/cgroup
+	root    string
+	unified bool
+}
+
+func newCgroupReader(root string) *cgroupReader {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return &cgroupReader{root: root, unified: err == nil}
+}
+
+// 子系统对应的目录，v2所有子系统在同一个目录
+func (this *cgroupReader) path(subsystem, dir string) string {
+	if this.unified {
+		return filepath.Join(this.root, dir)
+	}
+	return filepath.Join(this.root, subsystem, dir)
+}
+
+// cpuUsage 累计使用的CPU时间，单位纳秒，dir为空表示根cgroup
+func (this *cgroupReader) cpuUsage(dir string) (uint64, error) {
+	if this.unified {
+		stat, err := readKeyValues(filepath.Join(this.path("cpu", dir), "cpu.stat"))
+		if err != nil {
+			return 0, err
+		}
+		usec, ok := stat["usage_usec"]
+		if !ok {
+			return 0, fmt.Errorf("usage_usec not found in cpu.stat of %q", dir)
+		}
+		return usec * 1000, nil
+	}
+	return readUint(filepath.Join(this.path("cpuacct", dir), "cpuacct.usage"))
+}
+
+// cgroup的内存使用，单位字节
+type memoryUsage struct {
+	usage           uint64
+	workingSet      uint64
+	rss             uint64
+	pageFaults      uint64
+	majorPageFaults uint64
+	// 为0表示没有限制
+	limit uint64
+}
+
+// memoryUsage 工作集为使用量减去非活跃的文件缓存，和cadvisor的计算方式一致
+func (this *cgroupReader) memoryUsage(dir string) (*memoryUsage, error) {
+	// v1的memory.stat中包含子cgroup的统计项以total_开头
+	usageFile, limitFile, prefix := "memory.current", "memory.max", ""
+	if !this.unified {
+		usageFile, limitFile, prefix = "memory.usage_in_bytes", "memory.limit_in_bytes", "total_"
+	}
+	path := this.path("memory", dir)
+	usage, err := readUint(filepath.Join(path, usageFile))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := readKeyValues(filepath.Join(path, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	ret := &memoryUsage{
+		usage:           usage,
+		pageFaults:      stat[prefix+"pgfault"],
+		majorPageFaults: stat[prefix+"pgmajfault"],
+	}
+	if this.unified {
+		ret.rss = stat["anon"]
+	} else {
+		ret.rss = stat["total_rss"]
+	}
+	if inactiveFile := stat[prefix+"inactive_file"]; inactiveFile < usage {
+		ret.workingSet = usage - inactiveFile
+	}
+	// memory.max为max或v1中的极大值都表示不限制
+	if limit, err := readUint(filepath.Join(path, limitFile)); err == nil && limit < cgroupV1MemoryUnlimited {
+		ret.limit = limit
+	}
+	return ret, nil
+}
+
+// pids cgroup中的进程，v1使用memory层级下的cgroup.procs
+func (this *cgroupReader) pids(dir string) ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(this.path("memory", dir), "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in cgroup %q", field, dir)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// cgroupIndex 容器ID和pod uid到cgroup目录（相对于挂载点）的映射
+type cgroupIndex struct {
+	containers map[string]string
+	pods       map[string]string
+}
+
+// findCgroups 遍历cgroup树，根据目录名找到容器和pod的cgroup
+// cgroupfs驱动下目录名为<id>、pod<uid>，systemd驱动下为cri-containerd-<id>.scope、kubepods-besteffort-pod<uid>.slice
+// v1中各子系统的层级结构相同，只遍历memory层级
+func (this *cgroupReader) findCgroups() (*cgroupIndex, error) {
+	index := &cgroupIndex{
+		containers: map[string]string{},
+		pods:       map[string]string{},
+	}
+	root := this.path("memory", "")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 遍历过程中cgroup可能被删除
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(d.Name(), ".scope"), ".slice")
+		if id, ok := parseContainerID(name); ok {
+			index.containers[id] = rel
+			return filepath.SkipDir
+		}
+		if uid, ok := parsePodUID(name); ok {
+			index.pods[uid] = rel
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk cgroup tree %q: %v", root, err)
+	}
+	return index, nil
+}
+
+// 目录名的最后一段是64位十六进制时认为是容器的cgroup
+func parseContainerID(name string) (string, bool) {
+	id := name[strings.LastIndex(name, "-")+1:]
+	if len(id) != containerIDLength {
+		return "", false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// 目录名中pod之后是uid，systemd驱动下uid中的-被替换为_
+func parsePodUID(name string) (string, bool) {
+	idx := strings.LastIndex(name, "pod")
+	if idx < 0 {
+		return "", false
+	}
+	uid := strings.ReplaceAll(name[idx+len("pod"):], "_", "-")
+	if len(uid) != 36 || uid[8] != '-' || uid[13] != '-' || uid[18] != '-' || uid[23] != '-' {
+		return "", false
+	}
+	return uid, true
+}
+
+// 读取只包含一个数字的文件，内容为max时返回最大值
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return ^uint64(0), nil
+	}
+	ret, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q in %s: %v", value, path, err)
+	}
+	return ret, nil
+}
+
+// 读取每行为"key value"格式的文件，如cpu.stat、memory.stat
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		ret[fields[0]] = value
+	}
+	return ret, scanner.Err()
+}
@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"io/fs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fsInfo 通过statfs获取path所在文件系统的容量
+func fsInfo(path string, now metav1.Time) (*statsapi.FsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	capacity := st.Blocks * uint64(st.Bsize)
+	available := st.Bavail * uint64(st.Bsize)
+	used := (st.Blocks - st.Bfree) * uint64(st.Bsize)
+	inodes := st.Files
+	inodesFree := st.Ffree
+	inodesUsed := inodes - inodesFree
+	return &statsapi.FsStats{
+		Time:           now,
+		AvailableBytes: &available,
+		CapacityBytes:  &capacity,
+		UsedBytes:      &used,
+		Inodes:         &inodes,
+		InodesFree:     &inodesFree,
+		InodesUsed:     &inodesUsed,
+	}, nil
+}
+
+// dirUsage 统计目录占用的磁盘空间和inode数，目录不存在时返回0
+// pkg/volume/util/fs/fs.go DiskUsage
+func dirUsage(path string) (uint64, uint64, error) {
+	var bytes, inodes uint64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		inodes++
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			bytes += uint64(st.Blocks) * 512
+		} else {
+			bytes += uint64(info.Size())
+		}
+		return nil
+	})
+	return bytes, inodes, err
+}
+
+// 用目录的占用替换文件系统的已用空间，容量和可用空间仍为整个文件系统的
+func withUsage(fsStats *statsapi.FsStats, usedBytes, inodesUsed uint64) *statsapi.FsStats {
+	return &statsapi.FsStats{
+		Time:           fsStats.Time,
+		AvailableBytes: fsStats.AvailableBytes,
+		CapacityBytes:  fsStats.CapacityBytes,
+		UsedBytes:      &usedBytes,
+		Inodes:         fsStats.Inodes,
+		InodesFree:     fsStats.InodesFree,
+		InodesUsed:     &inodesUsed,
+	}
+}
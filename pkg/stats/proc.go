@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 容器网络命名空间中的默认网卡
+const defaultNetworkInterfaceName = "eth0"
+
+// readNetworkStats 解析/proc/net/dev，忽略lo
+// 默认网卡为eth0，没有时取名称排序后的第一个
+func readNetworkStats(path string, now metav1.Time) (*statsapi.NetworkStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	interfaces := []statsapi.InterfaceStats{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 前两行是表头
+		name, data, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(data)
+		if name == "lo" || len(fields) < 16 {
+			continue
+		}
+		values := make([]uint64, len(fields))
+		for i, field := range fields {
+			if values[i], err = strconv.ParseUint(field, 10, 64); err != nil {
+				return nil, fmt.Errorf("failed to parse %s stats of interface %q: %v", path, name, err)
+			}
+		}
+		// 接收：bytes packets errs drop ...，发送从第9列开始
+		interfaces = append(interfaces, statsapi.InterfaceStats{
+			Name:     name,
+			RxBytes:  &values[0],
+			RxErrors: &values[2],
+			TxBytes:  &values[8],
+			TxErrors: &values[10],
+		})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(interfaces) == 0 {
+		return nil, fmt.Errorf("no network interface found in %s", path)
+	}
+	sort.Slice(interfaces, func(i, j int) bool {
+		return interfaces[i].Name < interfaces[j].Name
+	})
+
+	stats := &statsapi.NetworkStats{Time: now, Interfaces: interfaces, InterfaceStats: interfaces[0]}
+	for _, i := range interfaces {
+		if i.Name == defaultNetworkInterfaceName {
+			stats.InterfaceStats = i
+		}
+	}
+	return stats, nil
+}
+
+// readNodeMemoryStats 节点的内存使用来自/proc/meminfo，v2的根cgroup没有memory.current
+// 可用内存为总量减去工作集，与驱逐判断使用的口径一致
+func readNodeMemoryStats(path string, now metav1.Time) (*statsapi.MemoryStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meminfo := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		meminfo[strings.TrimSuffix(fields[0], ":")] = kb * 1024
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	total, ok := meminfo["MemTotal"]
+	if !ok {
+		return nil, fmt.Errorf("MemTotal not found in %s", path)
+	}
+
+	usage := total - meminfo["MemFree"]
+	workingSet := uint64(0)
+	if inactiveFile := meminfo["Inactive(file)"]; inactiveFile < usage {
+		workingSet = usage - inactiveFile
+	}
+	available := total - workingSet
+	rss := meminfo["AnonPages"]
+	return &statsapi.MemoryStats{
+		Time:            now,
+		AvailableBytes:  &available,
+		UsageBytes:      &usage,
+		WorkingSetBytes: &workingSet,
+		RSSBytes:        &rss,
+	}, nil
+}
+
+// readBootTime 读取/proc/stat中的btime，作为节点的启动时间
+func readBootTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse btime %q: %v", fields[1], err)
+		}
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("btime not found in %s", path)
+}
+
+// readRlimitStats 进程号上限来自/proc/sys/kernel/pid_max，当前进程数来自/proc/loadavg
+func readRlimitStats(procRoot string, now metav1.Time) (*statsapi.RlimitStats, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, "sys/kernel/pid_max"))
+	if err != nil {
+		return nil, err
+	}
+	maxPID, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pid_max: %v", err)
+	}
+
+	// loadavg的第四列为 运行中/总数，总数包括线程
+	data, err = os.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("unexpected loadavg format %q", string(data))
+	}
+	_, total, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return nil, fmt.Errorf("unexpected loadavg format %q", string(data))
+	}
+	running, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse process count %q: %v", total, err)
+	}
+	return &statsapi.RlimitStats{Time: now, MaxPID: &maxPID, NumOfRunningProcesses: &running}, nil
+}
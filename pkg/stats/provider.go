@@ -0,0 +1,360 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/container"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 超过这个时间没有更新的CPU采样会被清理
+const cpuSampleExpiry = 5 * time.Minute
+
+// 一次CPU累计时间的采样
+type cpuSample struct {
+	time  time.Time
+	usage uint64
+}
+
+// Provider 节点、pod和容器的资源使用统计
+// CPU和内存来自cgroup文件，网络来自/proc/net/dev，文件系统来自statfs，pod和容器的对应关系来自运行时
+// pkg/kubelet/stats/provider.go
+type Provider struct {
+	nodeName string
+	runtime  container.Runtime
+	cgroup   *cgroupReader
+	// proc文件系统的挂载点，一般为/proc
+	procRoot string
+	// kubelet的根目录，所在的文件系统作为节点的nodefs
+	rootDirectory string
+	clock         clock.Clock
+
+	// 上一次的CPU累计时间，用于计算usageNanoCores
+	cpuLock    sync.Mutex
+	cpuSamples map[string]cpuSample
+}
+
+// NewProvider cgroupRoot和procRoot可以指向构造的目录，便于测试
+func NewProvider(nodeName string, runtime container.Runtime, cgroupRoot, procRoot, rootDirectory string, clock clock.Clock) *Provider {
+	return &Provider{
+		nodeName:      nodeName,
+		runtime:       runtime,
+		cgroup:        newCgroupReader(cgroupRoot),
+		procRoot:      procRoot,
+		rootDirectory: rootDirectory,
+		clock:         clock,
+		cpuSamples:    map[string]cpuSample{},
+	}
+}
+
+// GetSummary 返回/stats/summary的数据，onlyCPUAndMemory为true时不统计网络和文件系统
+func (this *Provider) GetSummary(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.Summary, error) {
+	defer this.pruneCPUSamples()
+
+	nodeStats, err := this.getNodeStats(ctx, onlyCPUAndMemory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node stats: %v", err)
+	}
+	podStats, err := this.listPodStats(ctx, nodeStats.Fs, onlyCPUAndMemory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod stats: %v", err)
+	}
+	return &statsapi.Summary{Node: *nodeStats, Pods: podStats}, nil
+}
+
+func (this *Provider) getNodeStats(ctx context.Context, onlyCPUAndMemory bool) (*statsapi.NodeStats, error) {
+	now := metav1.NewTime(this.clock.Now())
+	nodeStats := &statsapi.NodeStats{NodeName: this.nodeName}
+
+	bootTime, err := readBootTime(filepath.Join(this.procRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	nodeStats.StartTime = metav1.NewTime(bootTime)
+
+	usage, err := this.cgroup.cpuUsage("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root cgroup cpu usage: %v", err)
+	}
+	nodeStats.CPU = this.cpuStats("/", usage, now)
+	if nodeStats.Memory, err = readNodeMemoryStats(filepath.Join(this.procRoot, "meminfo"), now); err != nil {
+		return nil, err
+	}
+	if onlyCPUAndMemory {
+		return nodeStats, nil
+	}
+
+	// 网络、文件系统和进程数的统计失败不影响其他数据
+	if nodeStats.Rlimit, err = readRlimitStats(this.procRoot, now); err != nil {
+		klog.ErrorS(err, "Failed to get node rlimit stats")
+	}
+	if nodeStats.Network, err = readNetworkStats(filepath.Join(this.procRoot, "net/dev"), now); err != nil {
+		klog.ErrorS(err, "Failed to get node network stats")
+	}
+	if nodeStats.Fs, err = fsInfo(this.rootDirectory, now); err != nil {
+		klog.ErrorS(err, "Failed to get node filesystem stats", "path", this.rootDirectory)
+	}
+	if imageFs, err := this.imageFsStats(ctx, now); err != nil {
+		klog.ErrorS(err, "Failed to get image filesystem stats")
+	} else {
+		nodeStats.Runtime = &statsapi.RuntimeStats{ImageFs: imageFs}
+	}
+	return nodeStats, nil
+}
+
+// ImageFsStats 镜像文件系统的使用情况，用于镜像垃圾回收
+func (this *Provider) ImageFsStats(ctx context.Context) (*statsapi.FsStats, error) {
+	return this.imageFsStats(ctx, metav1.NewTime(this.clock.Now()))
+}
+
+// 镜像文件系统的已用空间以运行时统计的为准
+func (this *Provider) imageFsStats(ctx context.Context, now metav1.Time) (*statsapi.FsStats, error) {
+	usage, err := this.runtime.ImageFsInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fsStats, err := fsInfo(usage.Mountpoint, now)
+	if err != nil {
+		return nil, err
+	}
+	return withUsage(fsStats, usage.UsedBytes, usage.InodesUsed), nil
+}
+
+// listPodStats 统计运行中的pod，pod有自己的cgroup时直接读取，否则为容器之和
+func (this *Provider) listPodStats(ctx context.Context, nodeFs *statsapi.FsStats, onlyCPUAndMemory bool) ([]statsapi.PodStats, error) {
+	pods, err := this.runtime.GetPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index, err := this.cgroup.findCgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	now := metav1.NewTime(this.clock.Now())
+	ret := []statsapi.PodStats{}
+	for _, pod := range pods {
+		sandbox := runningSandbox(pod)
+		if sandbox == nil {
+			continue
+		}
+		podStats := statsapi.PodStats{
+			PodRef: statsapi.PodReference{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+				UID:       string(pod.ID),
+			},
+			StartTime:  metav1.NewTime(sandbox.Created),
+			Containers: []statsapi.ContainerStats{},
+		}
+
+		for _, c := range pod.Containers {
+			if c.State != container.ContainerStateRunning {
+				continue
+			}
+			dir, ok := index.containers[c.ID.ID]
+			if !ok {
+				klog.V(4).InfoS("Container cgroup not found", "pod", klog.KRef(pod.Namespace, pod.Name), "containerID", c.ID.ID)
+				continue
+			}
+			containerStats := statsapi.ContainerStats{
+				Name:      c.Name,
+				StartTime: metav1.NewTime(c.Created),
+			}
+			containerStats.CPU, containerStats.Memory = this.cgroupStats(dir, c.ID.ID, now)
+			if !onlyCPUAndMemory && nodeFs != nil {
+				containerStats.Logs = this.containerLogStats(pod, c.Name, nodeFs)
+			}
+			podStats.Containers = append(podStats.Containers, containerStats)
+		}
+
+		if dir, ok := index.pods[string(pod.ID)]; ok {
+			podStats.CPU, podStats.Memory = this.cgroupStats(dir, string(pod.ID), now)
+		} else {
+			podStats.CPU, podStats.Memory = sumContainerStats(podStats.Containers, now)
+		}
+		if !onlyCPUAndMemory {
+			podStats.ProcessStats = this.podProcessStats(index, pod)
+			podStats.Network = this.podNetworkStats(index, pod, sandbox, now)
+			podStats.EphemeralStorage = sumLogStats(podStats.Containers, nodeFs)
+		}
+		ret = append(ret, podStats)
+	}
+	return ret, nil
+}
+
+// 返回pod正在运行的sandbox
+func runningSandbox(pod *container.Pod) *container.Container {
+	for _, s := range pod.Sandboxes {
+		if s.State == container.ContainerStateRunning {
+			return s
+		}
+	}
+	return nil
+}
+
+// 读取一个cgroup的CPU和内存，读取失败时对应的统计为空
+func (this *Provider) cgroupStats(dir, key string, now metav1.Time) (*statsapi.CPUStats, *statsapi.MemoryStats) {
+	var cpu *statsapi.CPUStats
+	if usage, err := this.cgroup.cpuUsage(dir); err != nil {
+		klog.V(4).InfoS("Failed to read cpu usage", "cgroup", dir, "err", err)
+	} else {
+		cpu = this.cpuStats(key, usage, now)
+	}
+
+	var memory *statsapi.MemoryStats
+	if usage, err := this.cgroup.memoryUsage(dir); err != nil {
+		klog.V(4).InfoS("Failed to read memory usage", "cgroup", dir, "err", err)
+	} else {
+		memory = &statsapi.MemoryStats{
+			Time:            now,
+			UsageBytes:      &usage.usage,
+			WorkingSetBytes: &usage.workingSet,
+			RSSBytes:        &usage.rss,
+			PageFaults:      &usage.pageFaults,
+			MajorPageFaults: &usage.majorPageFaults,
+		}
+		if usage.limit > 0 {
+			available := uint64(0)
+			if usage.limit > usage.workingSet {
+				available = usage.limit - usage.workingSet
+			}
+			memory.AvailableBytes = &available
+		}
+	}
+	return cpu, memory
+}
+
+// cpuStats 根据上一次的采样计算usageNanoCores，第一次采样时没有使用率
+func (this *Provider) cpuStats(key string, usage uint64, now metav1.Time) *statsapi.CPUStats {
+	this.cpuLock.Lock()
+	defer this.cpuLock.Unlock()
+
+	stats := &statsapi.CPUStats{Time: now, UsageCoreNanoSeconds: &usage}
+	if last, ok := this.cpuSamples[key]; ok {
+		elapsed := now.Sub(last.time)
+		if elapsed > 0 && usage >= last.usage {
+			nanoCores := uint64(float64(usage-last.usage) / elapsed.Seconds())
+			stats.UsageNanoCores = &nanoCores
+		} else if elapsed <= 0 {
+			// 同一时刻的重复采样，保留上一次的计算基准
+			return stats
+		}
+	}
+	this.cpuSamples[key] = cpuSample{time: now.Time, usage: usage}
+	return stats
+}
+
+// 清理已经退出的容器和pod的采样
+func (this *Provider) pruneCPUSamples() {
+	this.cpuLock.Lock()
+	defer this.cpuLock.Unlock()
+	now := this.clock.Now()
+	for key, sample := range this.cpuSamples {
+		if now.Sub(sample.time) > cpuSampleExpiry {
+			delete(this.cpuSamples, key)
+		}
+	}
+}
+
+// 容器日志目录的占用，包括轮转后的文件
+func (this *Provider) containerLogStats(pod *container.Pod, containerName string, nodeFs *statsapi.FsStats) *statsapi.FsStats {
+	dir := filepath.Join(container.BuildPodLogsDirectory(pod.Namespace, pod.Name, pod.ID), containerName)
+	bytes, inodes, err := dirUsage(dir)
+	if err != nil {
+		klog.V(4).InfoS("Failed to get container log usage", "path", dir, "err", err)
+		return nil
+	}
+	return withUsage(nodeFs, bytes, inodes)
+}
+
+// pod中所有容器的进程数
+func (this *Provider) podProcessStats(index *cgroupIndex, pod *container.Pod) *statsapi.ProcessStats {
+	var count uint64
+	for _, c := range pod.Containers {
+		dir, ok := index.containers[c.ID.ID]
+		if !ok || c.State != container.ContainerStateRunning {
+			continue
+		}
+		pids, err := this.cgroup.pids(dir)
+		if err != nil {
+			klog.V(4).InfoS("Failed to list container processes", "pod", klog.KRef(pod.Namespace, pod.Name), "containerID", c.ID.ID, "err", err)
+			continue
+		}
+		count += uint64(len(pids))
+	}
+	return &statsapi.ProcessStats{ProcessCount: &count}
+}
+
+// pod的网络统计来自sandbox进程所在网络命名空间的/proc/<pid>/net/dev
+func (this *Provider) podNetworkStats(index *cgroupIndex, pod *container.Pod, sandbox *container.Container, now metav1.Time) *statsapi.NetworkStats {
+	dir, ok := index.containers[sandbox.ID.ID]
+	if !ok {
+		return nil
+	}
+	pids, err := this.cgroup.pids(dir)
+	if err != nil || len(pids) == 0 {
+		klog.V(4).InfoS("Failed to find sandbox process", "pod", klog.KRef(pod.Namespace, pod.Name), "err", err)
+		return nil
+	}
+	stats, err := readNetworkStats(filepath.Join(this.procRoot, strconv.Itoa(pids[0]), "net/dev"), now)
+	if err != nil {
+		klog.V(4).InfoS("Failed to get pod network stats", "pod", klog.KRef(pod.Namespace, pod.Name), "err", err)
+		return nil
+	}
+	return stats
+}
+
+// pod没有独立cgroup时，CPU和内存为各容器之和
+func sumContainerStats(containers []statsapi.ContainerStats, now metav1.Time) (*statsapi.CPUStats, *statsapi.MemoryStats) {
+	cpu := &statsapi.CPUStats{Time: now}
+	memory := &statsapi.MemoryStats{Time: now}
+	for _, c := range containers {
+		if c.CPU != nil {
+			cpu.UsageNanoCores = addUint64(cpu.UsageNanoCores, c.CPU.UsageNanoCores)
+			cpu.UsageCoreNanoSeconds = addUint64(cpu.UsageCoreNanoSeconds, c.CPU.UsageCoreNanoSeconds)
+		}
+		if c.Memory != nil {
+			memory.UsageBytes = addUint64(memory.UsageBytes, c.Memory.UsageBytes)
+			memory.WorkingSetBytes = addUint64(memory.WorkingSetBytes, c.Memory.WorkingSetBytes)
+			memory.RSSBytes = addUint64(memory.RSSBytes, c.Memory.RSSBytes)
+			memory.PageFaults = addUint64(memory.PageFaults, c.Memory.PageFaults)
+			memory.MajorPageFaults = addUint64(memory.MajorPageFaults, c.Memory.MajorPageFaults)
+		}
+	}
+	return cpu, memory
+}
+
+// pod的临时存储占用，目前只包含容器日志
+func sumLogStats(containers []statsapi.ContainerStats, nodeFs *statsapi.FsStats) *statsapi.FsStats {
+	if nodeFs == nil {
+		return nil
+	}
+	var bytes, inodes uint64
+	for _, c := range containers {
+		if c.Logs == nil {
+			continue
+		}
+		bytes += *c.Logs.UsedBytes
+		inodes += *c.Logs.InodesUsed
+	}
+	return withUsage(nodeFs, bytes, inodes)
+}
+
+func addUint64(sum, value *uint64) *uint64 {
+	if value == nil {
+		return sum
+	}
+	ret := *value
+	if sum != nil {
+		ret += *sum
+	}
+	return &ret
+}
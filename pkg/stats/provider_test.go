@@ -0,0 +1,298 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsapi "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	testingclock "k8s.io/utils/clock/testing"
+	"mykubelet/pkg/container"
+	containertest "mykubelet/pkg/container/testing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPodUID    = "11111111-2222-3333-4444-555555555555"
+	mib           = uint64(1024 * 1024)
+	procNetDevHdr = "Inter-|   Receive                                                |  Transmit\n" +
+		" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n"
+)
+
+var (
+	testContainerID = strings.Repeat("a", 64)
+	testSandboxID   = strings.Repeat("b", 64)
+)
+
+// writeFiles 在root下按相对路径写入文件
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// netDevLine /proc/net/dev中的一行，只填写测试关心的列
+func netDevLine(name string, rxBytes, rxErrors, txBytes, txErrors int) string {
+	return fmt.Sprintf("%6s: %d 10 %d 0 0 0 0 0 %d 20 %d 0 0 0 0 0\n", name, rxBytes, rxErrors, txBytes, txErrors)
+}
+
+// writeProc 构造/proc，sandbox进程42有自己的网络命名空间
+func writeProc(t *testing.T) string {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"stat":               "cpu  1 2 3 4\nbtime 1700000000\n",
+		"meminfo":            "MemTotal:        8000000 kB\nMemFree:         2000000 kB\nInactive(file):  1000000 kB\nAnonPages:       3000000 kB\n",
+		"sys/kernel/pid_max": "4194304\n",
+		"loadavg":            "0.10 0.20 0.30 2/345 6789\n",
+		"net/dev":            procNetDevHdr + netDevLine("lo", 100, 0, 100, 0) + netDevLine("ens3", 7000, 0, 8000, 0) + netDevLine("eth0", 1000, 1, 2000, 2),
+		"42/net/dev":         procNetDevHdr + netDevLine("lo", 100, 0, 100, 0) + netDevLine("eth0", 5000, 3, 6000, 4),
+	})
+	return root
+}
+
+// writeCgroupV2 systemd驱动下的cgroup v2树，节点、pod和容器的cpu.stat中使用usage_usec
+func writeCgroupV2(t *testing.T, root string, nodeUsec, podUsec, containerUsec int) {
+	podDir := "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + strings.ReplaceAll(testPodUID, "-", "_") + ".slice"
+	containerDir := podDir + "/cri-containerd-" + testContainerID + ".scope"
+	sandboxDir := podDir + "/cri-containerd-" + testSandboxID + ".scope"
+	writeFiles(t, root, map[string]string{
+		"cgroup.controllers":             "cpu memory pids\n",
+		"cpu.stat":                       fmt.Sprintf("usage_usec %d\nuser_usec 1\nsystem_usec 1\n", nodeUsec),
+		podDir + "/cpu.stat":             fmt.Sprintf("usage_usec %d\n", podUsec),
+		podDir + "/memory.current":       fmt.Sprint(300 * mib),
+		podDir + "/memory.stat":          fmt.Sprintf("anon %d\ninactive_file %d\npgfault 7\npgmajfault 1\n", 150*mib, 100*mib),
+		podDir + "/memory.max":           fmt.Sprint(500 * mib),
+		containerDir + "/cpu.stat":       fmt.Sprintf("usage_usec %d\n", containerUsec),
+		containerDir + "/memory.current": fmt.Sprint(200 * mib),
+		containerDir + "/memory.stat":    fmt.Sprintf("anon %d\ninactive_file %d\npgfault 5\npgmajfault 0\n", 120*mib, 50*mib),
+		containerDir + "/memory.max":     "max\n",
+		containerDir + "/cgroup.procs":   "100\n101\n",
+		sandboxDir + "/cpu.stat":         "usage_usec 10\n",
+		sandboxDir + "/memory.current":   fmt.Sprint(mib),
+		sandboxDir + "/memory.stat":      "anon 0\n",
+		sandboxDir + "/cgroup.procs":     "42\n",
+	})
+}
+
+// writeCgroupV1 cgroupfs驱动下的cgroup v1树，各子系统分开挂载
+func writeCgroupV1(t *testing.T, root string) {
+	podDir := "kubepods/burstable/pod" + testPodUID
+	containerDir := podDir + "/" + testContainerID
+	sandboxDir := podDir + "/" + testSandboxID
+	writeFiles(t, root, map[string]string{
+		"cpuacct/cpuacct.usage":                             "4000000000\n",
+		"cpuacct/" + podDir + "/cpuacct.usage":              "3000000000\n",
+		"cpuacct/" + containerDir + "/cpuacct.usage":        "2000000000\n",
+		"memory/" + podDir + "/memory.usage_in_bytes":       fmt.Sprint(300 * mib),
+		"memory/" + podDir + "/memory.limit_in_bytes":       fmt.Sprint(500 * mib),
+		"memory/" + podDir + "/memory.stat":                 fmt.Sprintf("rss 1\ntotal_rss %d\ntotal_inactive_file %d\ntotal_pgfault 7\ntotal_pgmajfault 1\n", 150*mib, 100*mib),
+		"memory/" + containerDir + "/memory.usage_in_bytes": fmt.Sprint(200 * mib),
+		"memory/" + containerDir + "/memory.limit_in_bytes": "9223372036854771712\n",
+		"memory/" + containerDir + "/memory.stat":           fmt.Sprintf("total_rss %d\ntotal_inactive_file %d\n", 120*mib, 50*mib),
+		"memory/" + containerDir + "/cgroup.procs":          "100\n101\n",
+		"memory/" + sandboxDir + "/cgroup.procs":            "42\n",
+	})
+}
+
+func newTestRuntime(t *testing.T) *containertest.FakeRuntime {
+	created := time.Unix(1700000100, 0)
+	runtime := containertest.NewFakeRuntime()
+	runtime.ImageFs = &container.FsUsage{Mountpoint: t.TempDir(), UsedBytes: 1024, InodesUsed: 2}
+	runtime.Pods = []*container.Pod{{
+		ID:        testPodUID,
+		Name:      "web",
+		Namespace: "default",
+		Sandboxes: []*container.Container{{
+			ID:      container.ContainerID{Type: "containerd", ID: testSandboxID},
+			State:   container.ContainerStateRunning,
+			Created: created,
+		}},
+		Containers: []*container.Container{{
+			ID:      container.ContainerID{Type: "containerd", ID: testContainerID},
+			Name:    "app",
+			State:   container.ContainerStateRunning,
+			Created: created,
+		}},
+	}}
+	return runtime
+}
+
+func newTestProvider(t *testing.T, cgroupRoot string, clock *testingclock.FakeClock) *Provider {
+	return NewProvider("node", newTestRuntime(t), cgroupRoot, writeProc(t), t.TempDir(), clock)
+}
+
+func expectUint64(t *testing.T, name string, value *uint64, expected uint64) {
+	t.Helper()
+	if value == nil {
+		t.Errorf("%s: expected %d, got nil", name, expected)
+		return
+	}
+	if *value != expected {
+		t.Errorf("%s: expected %d, got %d", name, expected, *value)
+	}
+}
+
+// 检查节点的内存和网络，来自构造的/proc
+func expectNodeStats(t *testing.T, node statsapi.NodeStats) {
+	t.Helper()
+	if node.NodeName != "node" || !node.StartTime.Equal(&metav1.Time{Time: time.Unix(1700000000, 0)}) {
+		t.Errorf("unexpected node name or start time: %s %s", node.NodeName, node.StartTime)
+	}
+	expectUint64(t, "node memory usage", node.Memory.UsageBytes, 6000000*1024)
+	expectUint64(t, "node memory working set", node.Memory.WorkingSetBytes, 5000000*1024)
+	expectUint64(t, "node memory available", node.Memory.AvailableBytes, 3000000*1024)
+	expectUint64(t, "node memory rss", node.Memory.RSSBytes, 3000000*1024)
+	if node.Network == nil {
+		t.Fatalf("expected node network stats")
+	}
+	if len(node.Network.Interfaces) != 2 || node.Network.Name != "eth0" {
+		t.Errorf("expected eth0 as default of two interfaces, got %+v", node.Network)
+	}
+	expectUint64(t, "node rx bytes", node.Network.RxBytes, 1000)
+	expectUint64(t, "node rx errors", node.Network.RxErrors, 1)
+	expectUint64(t, "node tx bytes", node.Network.TxBytes, 2000)
+	expectUint64(t, "node tx errors", node.Network.TxErrors, 2)
+	if node.Rlimit == nil {
+		t.Fatalf("expected node rlimit stats")
+	}
+	if *node.Rlimit.MaxPID != 4194304 || *node.Rlimit.NumOfRunningProcesses != 345 {
+		t.Errorf("unexpected rlimit stats %d %d", *node.Rlimit.MaxPID, *node.Rlimit.NumOfRunningProcesses)
+	}
+	if node.Runtime == nil || node.Runtime.ImageFs == nil {
+		t.Fatalf("expected image filesystem stats")
+	}
+	expectUint64(t, "image fs used", node.Runtime.ImageFs.UsedBytes, 1024)
+}
+
+// 检查pod和容器的CPU、内存和网络，两种cgroup版本的fixture使用相同的数值
+func expectPodStats(t *testing.T, pods []statsapi.PodStats) {
+	t.Helper()
+	if len(pods) != 1 {
+		t.Fatalf("expected 1 pod, got %d", len(pods))
+	}
+	pod := pods[0]
+	if pod.PodRef.UID != testPodUID || pod.PodRef.Name != "web" || pod.PodRef.Namespace != "default" {
+		t.Errorf("unexpected pod reference %+v", pod.PodRef)
+	}
+	expectUint64(t, "pod cpu", pod.CPU.UsageCoreNanoSeconds, 3000000000)
+	expectUint64(t, "pod memory usage", pod.Memory.UsageBytes, 300*mib)
+	expectUint64(t, "pod memory working set", pod.Memory.WorkingSetBytes, 200*mib)
+	expectUint64(t, "pod memory available", pod.Memory.AvailableBytes, 300*mib)
+	expectUint64(t, "pod memory rss", pod.Memory.RSSBytes, 150*mib)
+	expectUint64(t, "pod page faults", pod.Memory.PageFaults, 7)
+
+	if len(pod.Containers) != 1 || pod.Containers[0].Name != "app" {
+		t.Fatalf("expected the app container, got %+v", pod.Containers)
+	}
+	c := pod.Containers[0]
+	expectUint64(t, "container cpu", c.CPU.UsageCoreNanoSeconds, 2000000000)
+	expectUint64(t, "container memory working set", c.Memory.WorkingSetBytes, 150*mib)
+	expectUint64(t, "container memory rss", c.Memory.RSSBytes, 120*mib)
+	if c.Memory.AvailableBytes != nil {
+		t.Errorf("expected no available bytes for a container without memory limit, got %d", *c.Memory.AvailableBytes)
+	}
+
+	if pod.Network == nil {
+		t.Fatalf("expected pod network stats from the sandbox network namespace")
+	}
+	expectUint64(t, "pod rx bytes", pod.Network.RxBytes, 5000)
+	expectUint64(t, "pod rx errors", pod.Network.RxErrors, 3)
+	expectUint64(t, "pod tx bytes", pod.Network.TxBytes, 6000)
+	expectUint64(t, "pod tx errors", pod.Network.TxErrors, 4)
+	if pod.ProcessStats == nil {
+		t.Fatalf("expected pod process stats")
+	}
+	expectUint64(t, "pod processes", pod.ProcessStats.ProcessCount, 2)
+}
+
+func TestGetSummaryCgroupV2(t *testing.T) {
+	cgroupRoot := t.TempDir()
+	writeCgroupV2(t, cgroupRoot, 4000000, 3000000, 2000000)
+	clock := testingclock.NewFakeClock(time.Unix(1700001000, 0))
+	provider := newTestProvider(t, cgroupRoot, clock)
+
+	summary, err := provider.GetSummary(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUint64(t, "node cpu", summary.Node.CPU.UsageCoreNanoSeconds, 4000000000)
+	if summary.Node.CPU.UsageNanoCores != nil {
+		t.Errorf("expected no cpu usage rate on the first sample")
+	}
+	expectNodeStats(t, summary.Node)
+	expectPodStats(t, summary.Pods)
+
+	// 10秒后节点多用了5秒CPU，pod多用了2秒，容器多用了1秒
+	clock.Step(10 * time.Second)
+	writeCgroupV2(t, cgroupRoot, 9000000, 5000000, 3000000)
+	summary, err = provider.GetSummary(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUint64(t, "node cpu rate", summary.Node.CPU.UsageNanoCores, 500000000)
+	expectUint64(t, "pod cpu rate", summary.Pods[0].CPU.UsageNanoCores, 200000000)
+	expectUint64(t, "container cpu rate", summary.Pods[0].Containers[0].CPU.UsageNanoCores, 100000000)
+	if summary.Node.Network != nil || summary.Pods[0].Network != nil {
+		t.Errorf("expected no network stats when only cpu and memory are requested")
+	}
+}
+
+func TestGetSummaryCgroupV1(t *testing.T) {
+	cgroupRoot := t.TempDir()
+	writeCgroupV1(t, cgroupRoot)
+	provider := newTestProvider(t, cgroupRoot, testingclock.NewFakeClock(time.Unix(1700001000, 0)))
+
+	summary, err := provider.GetSummary(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUint64(t, "node cpu", summary.Node.CPU.UsageCoreNanoSeconds, 4000000000)
+	expectNodeStats(t, summary.Node)
+	expectPodStats(t, summary.Pods)
+}
+
+// pod没有独立的cgroup时，CPU和内存为容器之和
+func TestGetSummaryWithoutPodCgroup(t *testing.T) {
+	cgroupRoot := t.TempDir()
+	writeFiles(t, cgroupRoot, map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"cpu.stat":           "usage_usec 1\n",
+		"cri-containerd-" + testContainerID + ".scope/cpu.stat":       "usage_usec 2000000\n",
+		"cri-containerd-" + testContainerID + ".scope/memory.current": fmt.Sprint(200 * mib),
+		"cri-containerd-" + testContainerID + ".scope/memory.stat":    fmt.Sprintf("anon %d\ninactive_file %d\n", 120*mib, 50*mib),
+	})
+	provider := newTestProvider(t, cgroupRoot, testingclock.NewFakeClock(time.Unix(1700001000, 0)))
+
+	summary, err := provider.GetSummary(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := summary.Pods[0]
+	expectUint64(t, "pod cpu", pod.CPU.UsageCoreNanoSeconds, 2000000000)
+	expectUint64(t, "pod memory working set", pod.Memory.WorkingSetBytes, 150*mib)
+}
+
+func TestGetSummarySkipsPodsWithoutRunningSandbox(t *testing.T) {
+	cgroupRoot := t.TempDir()
+	writeCgroupV2(t, cgroupRoot, 1, 1, 1)
+	runtime := newTestRuntime(t)
+	runtime.Pods[0].Sandboxes[0].State = container.ContainerStateExited
+	provider := NewProvider("node", runtime, cgroupRoot, writeProc(t), t.TempDir(), testingclock.NewFakeClock(time.Now()))
+
+	summary, err := provider.GetSummary(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Pods) != 0 {
+		t.Errorf("expected no pod stats, got %+v", summary.Pods)
+	}
+}
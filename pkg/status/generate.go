@@ -0,0 +1,153 @@
+package status
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"mykubelet/pkg/container"
+	"strings"
+)
+
+const (
+	UnknownContainerStatuses = "UnknownContainerStatuses"
+	PodCompleted             = "PodCompleted"
+	ContainersNotReady       = "ContainersNotReady"
+	ContainersNotInitialized = "ContainersNotInitialized"
+)
+
+// GenerateContainersReadyCondition 根据容器状态生成ContainersReady条件
+// pkg/kubelet/status/generate.go
+func GenerateContainersReadyCondition(spec *v1.PodSpec, containerStatuses []v1.ContainerStatus, podPhase v1.PodPhase) v1.PodCondition {
+	if containerStatuses == nil {
+		return v1.PodCondition{
+			Type:   v1.ContainersReady,
+			Status: v1.ConditionFalse,
+			Reason: UnknownContainerStatuses,
+		}
+	}
+	unknownContainers := []string{}
+	unreadyContainers := []string{}
+	for _, container := range spec.Containers {
+		if cs, ok := findContainerStatus(containerStatuses, container.Name); ok {
+			if !cs.Ready {
+				unreadyContainers = append(unreadyContainers, container.Name)
+			}
+		} else {
+			unknownContainers = append(unknownContainers, container.Name)
+		}
+	}
+
+	// 已经运行结束的pod，容器不可能再ready
+	if podPhase == v1.PodSucceeded && len(unknownContainers) == 0 {
+		return v1.PodCondition{
+			Type:   v1.ContainersReady,
+			Status: v1.ConditionFalse,
+			Reason: PodCompleted,
+		}
+	}
+
+	unreadyMessages := []string{}
+	if len(unknownContainers) > 0 {
+		unreadyMessages = append(unreadyMessages, fmt.Sprintf("containers with unknown status: %s", unknownContainers))
+	}
+	if len(unreadyContainers) > 0 {
+		unreadyMessages = append(unreadyMessages, fmt.Sprintf("containers with unready status: %s", unreadyContainers))
+	}
+	if len(unreadyMessages) != 0 {
+		return v1.PodCondition{
+			Type:    v1.ContainersReady,
+			Status:  v1.ConditionFalse,
+			Reason:  ContainersNotReady,
+			Message: strings.Join(unreadyMessages, ", "),
+		}
+	}
+
+	return v1.PodCondition{
+		Type:   v1.ContainersReady,
+		Status: v1.ConditionTrue,
+	}
+}
+
+// GeneratePodReadyCondition 生成Ready条件，pod ready等价于所有容器ready
+func GeneratePodReadyCondition(spec *v1.PodSpec, containerStatuses []v1.ContainerStatus, podPhase v1.PodPhase) v1.PodCondition {
+	containersReady := GenerateContainersReadyCondition(spec, containerStatuses, podPhase)
+	if containersReady.Status != v1.ConditionTrue {
+		return v1.PodCondition{
+			Type:    v1.PodReady,
+			Status:  containersReady.Status,
+			Reason:  containersReady.Reason,
+			Message: containersReady.Message,
+		}
+	}
+
+	return v1.PodCondition{
+		Type:   v1.PodReady,
+		Status: v1.ConditionTrue,
+	}
+}
+
+// GeneratePodInitializedCondition 生成Initialized条件
+// 普通初始化容器全部成功、sidecar全部启动后为True
+func GeneratePodInitializedCondition(spec *v1.PodSpec, containerStatuses []v1.ContainerStatus, podPhase v1.PodPhase) v1.PodCondition {
+	if len(spec.InitContainers) == 0 {
+		return v1.PodCondition{
+			Type:   v1.PodInitialized,
+			Status: v1.ConditionTrue,
+		}
+	}
+	unknownContainers := []string{}
+	incompleteContainers := []string{}
+	for _, c := range spec.InitContainers {
+		cs, ok := findContainerStatus(containerStatuses, c.Name)
+		if !ok {
+			unknownContainers = append(unknownContainers, c.Name)
+			continue
+		}
+		if container.IsRestartableInitContainer(&c) {
+			if cs.Started == nil || !*cs.Started {
+				incompleteContainers = append(incompleteContainers, c.Name)
+			}
+			continue
+		}
+		if !cs.Ready {
+			incompleteContainers = append(incompleteContainers, c.Name)
+		}
+	}
+
+	if podPhase == v1.PodSucceeded && len(unknownContainers) == 0 {
+		return v1.PodCondition{
+			Type:   v1.PodInitialized,
+			Status: v1.ConditionTrue,
+			Reason: PodCompleted,
+		}
+	}
+
+	unreadyMessages := []string{}
+	if len(unknownContainers) > 0 {
+		unreadyMessages = append(unreadyMessages, fmt.Sprintf("containers with unknown status: %s", unknownContainers))
+	}
+	if len(incompleteContainers) > 0 {
+		unreadyMessages = append(unreadyMessages, fmt.Sprintf("containers with incomplete status: %s", incompleteContainers))
+	}
+	if len(unreadyMessages) != 0 {
+		return v1.PodCondition{
+			Type:    v1.PodInitialized,
+			Status:  v1.ConditionFalse,
+			Reason:  ContainersNotInitialized,
+			Message: strings.Join(unreadyMessages, ", "),
+		}
+	}
+
+	return v1.PodCondition{
+		Type:   v1.PodInitialized,
+		Status: v1.ConditionTrue,
+	}
+}
+
+func findContainerStatus(statuses []v1.ContainerStatus, name string) (v1.ContainerStatus, bool) {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return v1.ContainerStatus{}, false
+}
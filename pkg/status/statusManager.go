@@ -0,0 +1,388 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/container"
+	"sync"
+	"time"
+)
+
+const syncPeriod = 10 * time.Second
+
+// pod状态及其版本号，版本号用于判断是否需要同步到apiServer
+type versionedPodStatus struct {
+	status       v1.PodStatus
+	version      uint64
+	podName      string
+	podNamespace string
+	// pod已经终止，同步后可以从apiServer删除
+	podIsFinished bool
+}
+
+// PodManager 静态pod的状态写到对应的mirror pod
+type PodManager interface {
+	// TranslatePodUID mirror pod的uid转换为对应静态pod的uid，其他pod原样返回
+	TranslatePodUID(uid types.UID) types.UID
+}
+
+// Manager pod状态管理器
+// 缓存kubelet计算出的pod状态，异步patch到apiServer
+type Manager struct {
+	client     kubernetes.Interface
+	podManager PodManager
+
+	podStatusesLock  sync.RWMutex
+	podStatuses      map[types.UID]versionedPodStatus
+	apiStatusVersion map[types.UID]uint64
+
+	podStatusChannel chan types.UID
+}
+
+func NewManager(client kubernetes.Interface, podManager PodManager) *Manager {
+	return &Manager{
+		client:           client,
+		podManager:       podManager,
+		podStatuses:      make(map[types.UID]versionedPodStatus),
+		apiStatusVersion: make(map[types.UID]uint64),
+		podStatusChannel: make(chan types.UID, 1000),
+	}
+}
+
+// Start 启动同步协程
+func (this *Manager) Start() {
+	klog.Infoln("starting pod status manager")
+	syncTicker := time.NewTicker(syncPeriod)
+	go wait.Forever(func() {
+		for {
+			select {
+			case uid := <-this.podStatusChannel:
+				this.syncPod(uid)
+			case <-syncTicker.C:
+				this.syncBatch()
+			}
+		}
+	}, 0)
+}
+
+// GetPodStatus 获取缓存的pod状态
+func (this *Manager) GetPodStatus(uid types.UID) (v1.PodStatus, bool) {
+	this.podStatusesLock.RLock()
+	defer this.podStatusesLock.RUnlock()
+	status, ok := this.podStatuses[uid]
+	return status.status, ok
+}
+
+// SetPodStatus 更新pod状态
+func (this *Manager) SetPodStatus(pod *v1.Pod, status v1.PodStatus) {
+	this.podStatusesLock.Lock()
+	defer this.podStatusesLock.Unlock()
+
+	this.updateStatusInternal(pod.UID, pod.Name, pod.Namespace, status)
+}
+
+// SetContainerReadiness 由readiness探针结果触发，更新容器ready以及pod的Ready条件
+func (this *Manager) SetContainerReadiness(podUID types.UID, containerID container.ContainerID, ready bool) {
+	this.setContainerField(podUID, containerID, func(cs *v1.ContainerStatus) {
+		cs.Ready = ready
+	})
+}
+
+// SetContainerStartup 由startup探针结果触发，更新容器started
+func (this *Manager) SetContainerStartup(podUID types.UID, containerID container.ContainerID, started bool) {
+	this.setContainerField(podUID, containerID, func(cs *v1.ContainerStatus) {
+		cs.Started = &started
+	})
+}
+
+func (this *Manager) setContainerField(podUID types.UID, containerID container.ContainerID, mutate func(cs *v1.ContainerStatus)) {
+	this.podStatusesLock.Lock()
+	defer this.podStatusesLock.Unlock()
+
+	cached, ok := this.podStatuses[podUID]
+	if !ok {
+		klog.V(4).InfoS("Container status changed before pod has synced", "podUID", podUID, "containerID", containerID.String())
+		return
+	}
+	status := *cached.status.DeepCopy()
+	found := false
+	for i := range status.ContainerStatuses {
+		if status.ContainerStatuses[i].ContainerID == containerID.String() {
+			mutate(&status.ContainerStatuses[i])
+			found = true
+			break
+		}
+	}
+	// sidecar的状态在initContainerStatuses中
+	for i := range status.InitContainerStatuses {
+		if !found && status.InitContainerStatuses[i].ContainerID == containerID.String() {
+			mutate(&status.InitContainerStatuses[i])
+			found = true
+			break
+		}
+	}
+	if !found {
+		klog.V(4).InfoS("Container not found in pod status", "podUID", podUID, "containerID", containerID.String())
+		return
+	}
+
+	// ready发生变化时重新计算pod的条件
+	spec := &v1.PodSpec{}
+	for _, cs := range status.ContainerStatuses {
+		spec.Containers = append(spec.Containers, v1.Container{Name: cs.Name})
+	}
+	updateConditionFunc := func(conditionType v1.PodConditionType, condition v1.PodCondition) {
+		for i := range status.Conditions {
+			if status.Conditions[i].Type == conditionType {
+				status.Conditions[i] = condition
+				return
+			}
+		}
+		status.Conditions = append(status.Conditions, condition)
+	}
+	updateConditionFunc(v1.PodReady, GeneratePodReadyCondition(spec, status.ContainerStatuses, status.Phase))
+	updateConditionFunc(v1.ContainersReady, GenerateContainersReadyCondition(spec, status.ContainerStatuses, status.Phase))
+
+	this.updateStatusInternal(podUID, cached.podName, cached.podNamespace, status)
+}
+
+// TerminatePod 所有容器已经停止，把没有终止状态的容器标记为终止，并确定pod最终的phase
+// 同步时如果pod正在删除，以0宽限期从apiServer删除pod
+func (this *Manager) TerminatePod(pod *v1.Pod) {
+	this.podStatusesLock.Lock()
+	defer this.podStatusesLock.Unlock()
+
+	oldStatus := &pod.Status
+	if cached, ok := this.podStatuses[pod.UID]; ok {
+		oldStatus = &cached.status
+	}
+	status := *oldStatus.DeepCopy()
+
+	allSucceeded := true
+	markTerminated := func(statuses []v1.ContainerStatus) {
+		for i := range statuses {
+			cs := &statuses[i]
+			if cs.State.Terminated != nil {
+				if cs.State.Terminated.ExitCode != 0 {
+					allSucceeded = false
+				}
+				continue
+			}
+			// 正在等待的容器上一次的状态作为终止状态，否则无法确定退出码
+			if cs.State.Waiting != nil && cs.LastTerminationState.Terminated != nil {
+				cs.State = cs.LastTerminationState
+				cs.LastTerminationState = v1.ContainerState{}
+			} else {
+				cs.State = v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{
+						Reason:   "ContainerStatusUnknown",
+						Message:  "The container could not be located when the pod was terminated",
+						ExitCode: 137,
+					},
+				}
+			}
+			if cs.State.Terminated.ExitCode != 0 {
+				allSucceeded = false
+			}
+			cs.Ready = false
+		}
+	}
+	markTerminated(status.InitContainerStatuses)
+	markTerminated(status.ContainerStatuses)
+
+	if status.Phase != v1.PodSucceeded && status.Phase != v1.PodFailed {
+		if allSucceeded && len(status.ContainerStatuses) > 0 {
+			status.Phase = v1.PodSucceeded
+		} else {
+			status.Phase = v1.PodFailed
+		}
+	}
+
+	this.updateStatusInternal(pod.UID, pod.Name, pod.Namespace, status)
+	cached := this.podStatuses[pod.UID]
+	cached.podIsFinished = true
+	this.podStatuses[pod.UID] = cached
+
+	// 状态没有变化时也需要触发同步，完成删除
+	select {
+	case this.podStatusChannel <- pod.UID:
+	default:
+	}
+}
+
+// DeletePodStatus 删除缓存的pod状态
+func (this *Manager) DeletePodStatus(uid types.UID) {
+	this.podStatusesLock.Lock()
+	defer this.podStatusesLock.Unlock()
+	delete(this.podStatuses, uid)
+	delete(this.apiStatusVersion, uid)
+}
+
+// RemoveOrphanedStatuses 清理已经不在节点上的pod状态
+func (this *Manager) RemoveOrphanedStatuses(podUIDs map[types.UID]bool) {
+	this.podStatusesLock.Lock()
+	defer this.podStatusesLock.Unlock()
+	for uid := range this.podStatuses {
+		if _, ok := podUIDs[uid]; !ok {
+			delete(this.podStatuses, uid)
+			delete(this.apiStatusVersion, uid)
+		}
+	}
+}
+
+// 调用方需持有锁
+func (this *Manager) updateStatusInternal(uid types.UID, name, namespace string, status v1.PodStatus) {
+	oldStatus, found := this.podStatuses[uid]
+
+	// 条件没有变化时保留原来的lastTransitionTime
+	for i := range status.Conditions {
+		cond := &status.Conditions[i]
+		cond.LastProbeTime = metav1.Time{}
+		if found {
+			for _, old := range oldStatus.status.Conditions {
+				if old.Type == cond.Type && old.Status == cond.Status {
+					cond.LastTransitionTime = old.LastTransitionTime
+				}
+			}
+		}
+		if cond.LastTransitionTime.IsZero() {
+			cond.LastTransitionTime = metav1.Now()
+		}
+	}
+	if found && oldStatus.status.StartTime != nil {
+		status.StartTime = oldStatus.status.StartTime
+	} else if status.StartTime == nil {
+		now := metav1.Now()
+		status.StartTime = &now
+	}
+
+	if found && equality.Semantic.DeepEqual(oldStatus.status, status) {
+		return
+	}
+
+	this.podStatuses[uid] = versionedPodStatus{
+		status:        status,
+		version:       oldStatus.version + 1,
+		podName:       name,
+		podNamespace:  namespace,
+		podIsFinished: oldStatus.podIsFinished,
+	}
+
+	select {
+	case this.podStatusChannel <- uid:
+	default:
+		// 通道满了就等待下一次批量同步
+	}
+}
+
+// 定时把所有版本落后的状态同步到apiServer
+func (this *Manager) syncBatch() {
+	this.podStatusesLock.RLock()
+	uids := []types.UID{}
+	for uid, status := range this.podStatuses {
+		// 已经终止的pod在删除成功前一直重试
+		if this.apiStatusVersion[uid] < status.version || status.podIsFinished {
+			uids = append(uids, uid)
+		}
+	}
+	this.podStatusesLock.RUnlock()
+
+	for _, uid := range uids {
+		this.syncPod(uid)
+	}
+}
+
+func (this *Manager) syncPod(uid types.UID) {
+	this.podStatusesLock.RLock()
+	status, ok := this.podStatuses[uid]
+	this.podStatusesLock.RUnlock()
+	if !ok {
+		return
+	}
+
+	pod, err := this.client.CoreV1().Pods(status.podNamespace).Get(context.Background(), status.podName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.V(3).InfoS("Pod does not exist on the server", "pod", klog.KRef(status.podNamespace, status.podName))
+		if status.podIsFinished {
+			this.DeletePodStatus(uid)
+		}
+		return
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to get status for pod", "pod", klog.KRef(status.podNamespace, status.podName))
+		return
+	}
+	// 静态pod的状态更新到mirror pod
+	if this.podManager.TranslatePodUID(pod.UID) != uid {
+		klog.V(3).InfoS("Pod was deleted and then recreated, skipping status update", "pod", klog.KObj(pod))
+		return
+	}
+
+	if err = PatchPodStatus(this.client, pod, status.status); err != nil {
+		klog.ErrorS(err, "Failed to update status for pod", "pod", klog.KObj(pod))
+		return
+	}
+	klog.V(3).InfoS("Status for pod updated successfully", "pod", klog.KObj(pod), "version", status.version)
+
+	this.podStatusesLock.Lock()
+	this.apiStatusVersion[uid] = status.version
+	this.podStatusesLock.Unlock()
+
+	if !canBeDeleted(pod, status) {
+		return
+	}
+	// 容器已经全部停止，以0宽限期删除pod，uid作为前置条件防止删除同名的新pod
+	deleteOptions := metav1.DeleteOptions{
+		GracePeriodSeconds: new(int64),
+		Preconditions:      metav1.NewUIDPreconditions(string(pod.UID)),
+	}
+	err = this.client.CoreV1().Pods(pod.Namespace).Delete(context.Background(), pod.Name, deleteOptions)
+	if err != nil && !errors.IsNotFound(err) {
+		klog.ErrorS(err, "Failed to delete status for pod", "pod", klog.KObj(pod))
+		return
+	}
+	klog.V(3).InfoS("Pod fully terminated and removed from etcd", "pod", klog.KObj(pod))
+	this.DeletePodStatus(uid)
+}
+
+// pod正在删除且所有容器已经停止，mirror pod由kubelet在静态pod删除后删除
+func canBeDeleted(pod *v1.Pod, status versionedPodStatus) bool {
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	return pod.DeletionTimestamp != nil && status.podIsFinished
+}
+
+// PatchPodStatus 以策略性合并patch更新pod status
+// pkg/util/pod/pod.go
+func PatchPodStatus(client kubernetes.Interface, pod *v1.Pod, newStatus v1.PodStatus) error {
+	oldData, err := json.Marshal(v1.Pod{Status: pod.Status})
+	if err != nil {
+		return fmt.Errorf("failed to Marshal oldData for pod %q/%q: %v", pod.Namespace, pod.Name, err)
+	}
+	// uid作为前置条件，防止更新到同名的新pod
+	newData, err := json.Marshal(v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: pod.UID},
+		Status:     newStatus,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to Marshal newData for pod %q/%q: %v", pod.Namespace, pod.Name, err)
+	}
+	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, v1.Pod{})
+	if err != nil {
+		return fmt.Errorf("failed to CreateTwoWayMergePatch for pod %q/%q: %v", pod.Namespace, pod.Name, err)
+	}
+	_, err = client.CoreV1().Pods(pod.Namespace).Patch(context.Background(), pod.Name, types.StrategicMergePatchType,
+		patchBytes, metav1.PatchOptions{}, "status")
+	return err
+}
@@ -0,0 +1,160 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// token最长使用24小时，即使ttl更长也要刷新
+	maxTTL = 24 * time.Hour
+	// 清理过期token的间隔
+	gcPeriod = time.Minute
+	// 刷新时间的随机抖动，避免大量token同时刷新
+	maxJitter = 10 * time.Second
+)
+
+// Manager 通过TokenRequest获取service account的token并缓存
+// 使用超过ttl的80%或者超过24小时后重新获取，获取失败时继续使用没有过期的旧token
+// pkg/kubelet/token/token_manager.go
+type Manager struct {
+	cacheMutex sync.RWMutex
+	cache      map[string]*authenticationv1.TokenRequest
+
+	getToken func(name, namespace string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error)
+	clock    clock.Clock
+}
+
+// NewManager 创建token管理器
+func NewManager(client kubernetes.Interface, clock clock.Clock) *Manager {
+	return &Manager{
+		cache: map[string]*authenticationv1.TokenRequest{},
+		getToken: func(name, namespace string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+			if client == nil {
+				return nil, fmt.Errorf("cannot use TokenManager when kubelet is in standalone mode")
+			}
+			tokenRequest, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(context.Background(), name, tr, metav1.CreateOptions{})
+			if err != nil {
+				return nil, err
+			}
+			if tokenRequest.Spec.ExpirationSeconds == nil || tokenRequest.Status.ExpirationTimestamp.IsZero() {
+				return nil, fmt.Errorf("expiration of token for service account %s/%s is not set", namespace, name)
+			}
+			return tokenRequest, nil
+		},
+		clock: clock,
+	}
+}
+
+// Start 定期清理过期的token
+func (this *Manager) Start(stopCh <-chan struct{}) {
+	go wait.Until(this.cleanup, gcPeriod, stopCh)
+}
+
+// GetServiceAccountToken 返回缓存的token，需要刷新时重新获取
+func (this *Manager) GetServiceAccountToken(namespace, name string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+	key := keyFunc(name, namespace, tr)
+
+	ctr, ok := this.get(key)
+	if ok && !this.requiresRefresh(ctr) {
+		return ctr, nil
+	}
+
+	tr, err := this.getToken(name, namespace, tr)
+	if err != nil {
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("failed to fetch token: %v", err)
+		case this.expired(ctr):
+			return nil, fmt.Errorf("token %s expired and refresh failed: %v", key, err)
+		default:
+			klog.ErrorS(err, "Couldn't update token", "cacheKey", key)
+			return ctr, nil
+		}
+	}
+
+	this.set(key, tr)
+	return tr, nil
+}
+
+// DeleteServiceAccountToken 删除绑定到pod的token，pod的卷卸载时调用
+func (this *Manager) DeleteServiceAccountToken(podUID types.UID) {
+	this.cacheMutex.Lock()
+	defer this.cacheMutex.Unlock()
+	for k, tr := range this.cache {
+		if tr.Spec.BoundObjectRef != nil && tr.Spec.BoundObjectRef.UID == podUID {
+			delete(this.cache, k)
+		}
+	}
+}
+
+func (this *Manager) cleanup() {
+	this.cacheMutex.Lock()
+	defer this.cacheMutex.Unlock()
+	for k, tr := range this.cache {
+		if this.expired(tr) {
+			delete(this.cache, k)
+		}
+	}
+}
+
+func (this *Manager) get(key string) (*authenticationv1.TokenRequest, bool) {
+	this.cacheMutex.RLock()
+	defer this.cacheMutex.RUnlock()
+	ctr, ok := this.cache[key]
+	return ctr, ok
+}
+
+func (this *Manager) set(key string, tr *authenticationv1.TokenRequest) {
+	this.cacheMutex.Lock()
+	defer this.cacheMutex.Unlock()
+	this.cache[key] = tr
+}
+
+func (this *Manager) expired(t *authenticationv1.TokenRequest) bool {
+	return this.clock.Now().After(t.Status.ExpirationTimestamp.Time)
+}
+
+// requiresRefresh 签发超过24小时，或者距离过期不到ttl的20%时需要刷新
+func (this *Manager) requiresRefresh(tr *authenticationv1.TokenRequest) bool {
+	if tr.Spec.ExpirationSeconds == nil {
+		klog.ErrorS(nil, "Expiration seconds was nil for token request", "serviceAccount", tr.Name)
+		return false
+	}
+	now := this.clock.Now()
+	exp := tr.Status.ExpirationTimestamp.Time
+	iat := exp.Add(-1 * time.Duration(*tr.Spec.ExpirationSeconds) * time.Second)
+
+	jitter := time.Duration(rand.Float64()*maxJitter.Seconds()) * time.Second
+	if now.After(iat.Add(maxTTL - jitter)) {
+		return true
+	}
+	if now.After(exp.Add(-1*time.Duration((*tr.Spec.ExpirationSeconds*20)/100)*time.Second - jitter)) {
+		return true
+	}
+	return false
+}
+
+// keyFunc 同一个service account的token按audience、有效期和绑定的对象区分
+func keyFunc(name, namespace string, tr *authenticationv1.TokenRequest) string {
+	var exp int64
+	if tr.Spec.ExpirationSeconds != nil {
+		exp = *tr.Spec.ExpirationSeconds
+	}
+	var ref authenticationv1.BoundObjectReference
+	if tr.Spec.BoundObjectRef != nil {
+		ref = *tr.Spec.BoundObjectRef
+	}
+	return fmt.Sprintf("%q/%q/%#v/%#v/%#v", name, namespace, strings.Join(tr.Spec.Audiences, ","), exp, ref)
+}
@@ -0,0 +1,176 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	testingclock "k8s.io/utils/clock/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTokenIssuer 按请求的有效期签发token，err不为空时获取失败
+type fakeTokenIssuer struct {
+	clock *testingclock.FakeClock
+	calls int
+	err   error
+}
+
+func (this *fakeTokenIssuer) getToken(name, namespace string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+	this.calls++
+	if this.err != nil {
+		return nil, this.err
+	}
+	ret := tr.DeepCopy()
+	ret.Name = name
+	ret.Namespace = namespace
+	ret.Status = authenticationv1.TokenRequestStatus{
+		Token:               fmt.Sprintf("token-%d", this.calls),
+		ExpirationTimestamp: metav1.NewTime(this.clock.Now().Add(time.Duration(*tr.Spec.ExpirationSeconds) * time.Second)),
+	}
+	return ret, nil
+}
+
+func newTestManager() (*Manager, *fakeTokenIssuer, *testingclock.FakeClock) {
+	clock := testingclock.NewFakeClock(time.Now())
+	issuer := &fakeTokenIssuer{clock: clock}
+	manager := NewManager(nil, clock)
+	manager.getToken = issuer.getToken
+	return manager, issuer, clock
+}
+
+// tokenRequest 绑定到pod的token请求
+func tokenRequest(ttl time.Duration, podUID types.UID) *authenticationv1.TokenRequest {
+	expirationSeconds := int64(ttl / time.Second)
+	return &authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{
+		Audiences:         []string{"api"},
+		ExpirationSeconds: &expirationSeconds,
+		BoundObjectRef:    &authenticationv1.BoundObjectReference{Kind: "Pod", Name: "pod", UID: podUID},
+	}}
+}
+
+// 刷新时间有最多10s的随机抖动，阈值前10s以上不刷新，阈值之后一定刷新
+func TestRequiresRefresh(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ttl      time.Duration
+		elapsed  time.Duration
+		expected bool
+	}{
+		{name: "before 80% of ttl", ttl: time.Hour, elapsed: 48*time.Minute - 11*time.Second},
+		{name: "after 80% of ttl", ttl: time.Hour, elapsed: 48*time.Minute + time.Second, expected: true},
+		{name: "before 24 hours", ttl: 48 * time.Hour, elapsed: 24*time.Hour - 11*time.Second},
+		{name: "after 24 hours", ttl: 48 * time.Hour, elapsed: 24*time.Hour + time.Second, expected: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, issuer, clock := newTestManager()
+			tr, err := issuer.getToken("default", "default", tokenRequest(tc.ttl, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.Step(tc.elapsed)
+			if got := manager.requiresRefresh(tr); got != tc.expected {
+				t.Errorf("expected requiresRefresh %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	manager, _, _ := newTestManager()
+	if manager.requiresRefresh(&authenticationv1.TokenRequest{}) {
+		t.Errorf("expected no refresh without expiration seconds")
+	}
+}
+
+func TestGetServiceAccountTokenRefresh(t *testing.T) {
+	manager, issuer, clock := newTestManager()
+	steps := []struct {
+		advance       time.Duration
+		expectedToken string
+	}{
+		{expectedToken: "token-1"},
+		{advance: 40 * time.Minute, expectedToken: "token-1"},
+		{advance: 8*time.Minute + time.Second, expectedToken: "token-2"},
+		{advance: 40 * time.Minute, expectedToken: "token-2"},
+	}
+	for i, step := range steps {
+		clock.Step(step.advance)
+		tr, err := manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, ""))
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if tr.Status.Token != step.expectedToken {
+			t.Errorf("step %d: expected %s, got %s", i, step.expectedToken, tr.Status.Token)
+		}
+	}
+	if issuer.calls != 2 {
+		t.Errorf("expected cached tokens to be reused, got %d calls", issuer.calls)
+	}
+
+	// 有效期不同的请求分别缓存
+	if tr, err := manager.GetServiceAccountToken("default", "default", tokenRequest(2*time.Hour, "")); err != nil || tr.Status.Token != "token-3" {
+		t.Errorf("expected a new token for a different ttl, got %v %v", tr, err)
+	}
+}
+
+// 刷新失败时继续使用旧token，直到旧token过期
+func TestGetServiceAccountTokenRefreshFailure(t *testing.T) {
+	manager, issuer, clock := newTestManager()
+	if _, err := manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.err = errors.New("apiserver unavailable")
+	clock.Step(50 * time.Minute)
+	tr, err := manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, ""))
+	if err != nil || tr.Status.Token != "token-1" {
+		t.Fatalf("expected the old token while it has not expired, got %v %v", tr, err)
+	}
+	if issuer.calls != 2 {
+		t.Errorf("expected a refresh attempt, got %d calls", issuer.calls)
+	}
+
+	clock.Step(10*time.Minute + time.Second)
+	if _, err = manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, "")); err == nil ||
+		!strings.Contains(err.Error(), "expired and refresh failed") {
+		t.Errorf("expected an error after the old token expired, got %v", err)
+	}
+
+	// 恢复后重新获取
+	issuer.err = nil
+	if tr, err = manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, "")); err != nil || tr.Status.Token != "token-4" {
+		t.Errorf("expected a new token after recovery, got %v %v", tr, err)
+	}
+
+	// 没有缓存时获取失败直接返回错误
+	issuer.err = errors.New("forbidden")
+	if _, err = manager.GetServiceAccountToken("default", "other", tokenRequest(time.Hour, "")); err == nil {
+		t.Errorf("expected an error without a cached token")
+	}
+}
+
+func TestCleanupAndDeleteServiceAccountToken(t *testing.T) {
+	manager, _, clock := newTestManager()
+	for _, podUID := range []types.UID{"a", "b"} {
+		if _, err := manager.GetServiceAccountToken("default", "default", tokenRequest(time.Hour, podUID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := manager.GetServiceAccountToken("default", "default", tokenRequest(2*time.Hour, "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.DeleteServiceAccountToken("a")
+	if len(manager.cache) != 2 {
+		t.Errorf("expected the token bound to pod a to be deleted, got %d tokens", len(manager.cache))
+	}
+
+	clock.Step(time.Hour + time.Second)
+	manager.cleanup()
+	if len(manager.cache) != 1 {
+		t.Errorf("expected only the unexpired token to be kept, got %d tokens", len(manager.cache))
+	}
+}
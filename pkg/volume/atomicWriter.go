@@ -0,0 +1,282 @@
+package volume
+
+import (
+	"bytes"
+	"fmt"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	maxFileNameLength = 255
+	maxPathLength     = 4096
+
+	// 指向当前版本数据目录的符号链接
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+)
+
+// FileProjection 写入卷中的一个文件
+type FileProjection struct {
+	Data []byte
+	Mode int32
+}
+
+// AtomicWriter 原子地更新目录中的一组文件，容器要么看到旧版本的全部文件，要么看到新版本的全部文件
+// 每个版本写入一个时间戳目录，..data符号链接指向当前版本，用户看到的文件是指向..data中同名文件的符号链接
+// 更新时先写新的时间戳目录，再用rename替换..data，最后删除旧版本
+//
+//	<target>/..2023_01_01_00_00_00.123456789/key
+//	<target>/..data -> ..2023_01_01_00_00_00.123456789
+//	<target>/key -> ..data/key
+//
+// pkg/volume/util/atomic_writer.go
+type AtomicWriter struct {
+	targetDir  string
+	logContext string
+}
+
+// NewAtomicWriter 目标目录必须已经存在
+func NewAtomicWriter(targetDir, logContext string) (*AtomicWriter, error) {
+	if _, err := os.Stat(targetDir); err != nil {
+		return nil, err
+	}
+	return &AtomicWriter{targetDir: targetDir, logContext: logContext}, nil
+}
+
+// Write 把payload写入目标目录，内容没有变化时不做任何修改
+func (this *AtomicWriter) Write(payload map[string]FileProjection) error {
+	cleanPayload, err := validatePayload(payload)
+	if err != nil {
+		klog.ErrorS(err, "Invalid payload", "logContext", this.logContext)
+		return err
+	}
+
+	dataDirPath := filepath.Join(this.targetDir, dataDirName)
+	oldTsDir, err := os.Readlink(dataDirPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		oldTsDir = ""
+	}
+	oldTsPath := filepath.Join(this.targetDir, oldTsDir)
+
+	// 不在新payload中的旧文件
+	pathsToRemove := map[string]bool{}
+	if oldTsDir != "" {
+		if pathsToRemove, err = this.pathsToRemove(cleanPayload, oldTsPath); err != nil {
+			return err
+		}
+		should, err := shouldWritePayload(cleanPayload, oldTsPath)
+		if err != nil {
+			return err
+		}
+		if !should && len(pathsToRemove) == 0 {
+			klog.V(4).InfoS("No update required for target directory", "logContext", this.logContext, "path", this.targetDir)
+			return nil
+		}
+	}
+
+	tsDir, err := this.newTimestampDir()
+	if err != nil {
+		return err
+	}
+	tsDirName := filepath.Base(tsDir)
+	if err = writePayloadToDir(cleanPayload, tsDir); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("%s: error writing payload to ts data directory %s: %v", this.logContext, tsDir, err)
+	}
+
+	// 新建临时符号链接再rename，替换..data是原子的
+	newDataDirPath := filepath.Join(this.targetDir, newDataDirName)
+	os.Remove(newDataDirPath)
+	if err = os.Symlink(tsDirName, newDataDirPath); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("%s: error creating symbolic link for atomic update: %v", this.logContext, err)
+	}
+	if err = os.Rename(newDataDirPath, dataDirPath); err != nil {
+		os.Remove(newDataDirPath)
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("%s: error renaming symbolic link for data directory %s: %v", this.logContext, newDataDirPath, err)
+	}
+
+	if err = this.createUserVisibleFiles(cleanPayload); err != nil {
+		return fmt.Errorf("%s: error creating visible symlinks in %s: %v", this.logContext, this.targetDir, err)
+	}
+	if err = this.removeUserVisiblePaths(pathsToRemove); err != nil {
+		return fmt.Errorf("%s: error removing old visible symlinks: %v", this.logContext, err)
+	}
+	if oldTsDir != "" {
+		if err = os.RemoveAll(oldTsPath); err != nil {
+			return fmt.Errorf("%s: error removing old data directory %s: %v", this.logContext, oldTsDir, err)
+		}
+	}
+	return nil
+}
+
+// validatePayload 路径必须是目录内的相对路径，返回清理后的payload
+func validatePayload(payload map[string]FileProjection) (map[string]FileProjection, error) {
+	cleanPayload := make(map[string]FileProjection, len(payload))
+	for p, content := range payload {
+		if err := validatePath(p); err != nil {
+			return nil, err
+		}
+		cleanPayload[filepath.Clean(p)] = content
+	}
+	return cleanPayload, nil
+}
+
+func validatePath(targetPath string) error {
+	if targetPath == "" {
+		return fmt.Errorf("invalid path: must not be empty: %q", targetPath)
+	}
+	if filepath.IsAbs(targetPath) {
+		return fmt.Errorf("invalid path: must be relative path: %s", targetPath)
+	}
+	if len(targetPath) > maxPathLength {
+		return fmt.Errorf("invalid path: must be less than or equal to %d characters", maxPathLength)
+	}
+	for _, item := range strings.Split(targetPath, string(os.PathSeparator)) {
+		if item == ".." {
+			return fmt.Errorf("invalid path: must not contain '..': %s", targetPath)
+		}
+		if len(item) > maxFileNameLength {
+			return fmt.Errorf("invalid path: filenames must be less than or equal to %d characters", maxFileNameLength)
+		}
+	}
+	// 以..开头的名字保留给时间戳目录和..data
+	if strings.HasPrefix(targetPath, "..") {
+		return fmt.Errorf("invalid path: must not start with '..': %s", targetPath)
+	}
+	return nil
+}
+
+// shouldWritePayload 有文件的内容或权限和旧版本不同
+func shouldWritePayload(payload map[string]FileProjection, oldTsDir string) (bool, error) {
+	for userVisiblePath, fileProjection := range payload {
+		p := filepath.Join(oldTsDir, userVisiblePath)
+		info, err := os.Lstat(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		if info.Mode().Perm() != os.FileMode(fileProjection.Mode).Perm() {
+			return true, nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(content, fileProjection.Data) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pathsToRemove 旧版本中有而新payload中没有的路径，包括目录
+func (this *AtomicWriter) pathsToRemove(payload map[string]FileProjection, oldTsDir string) (map[string]bool, error) {
+	paths := map[string]bool{}
+	visitor := func(path string, info os.FileInfo, err error) error {
+		relativePath := strings.TrimPrefix(path, oldTsDir)
+		relativePath = strings.TrimPrefix(relativePath, string(os.PathSeparator))
+		if relativePath == "" {
+			return nil
+		}
+		paths[relativePath] = true
+		return nil
+	}
+	if err := filepath.Walk(oldTsDir, visitor); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for file := range payload {
+		delete(paths, file)
+		// 新文件所在的目录也保留
+		for subPath := filepath.Dir(file); subPath != "." && subPath != string(os.PathSeparator); subPath = filepath.Dir(subPath) {
+			delete(paths, subPath)
+		}
+	}
+	klog.V(5).InfoS("Paths to remove", "logContext", this.logContext, "paths", paths)
+	return paths, nil
+}
+
+// newTimestampDir 创建新版本的时间戳目录
+func (this *AtomicWriter) newTimestampDir() (string, error) {
+	tsDir, err := os.MkdirTemp(this.targetDir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return "", fmt.Errorf("%s: unable to create new temp directory: %v", this.logContext, err)
+	}
+	// MkdirTemp创建的目录权限为0700，容器中的非root用户无法读取
+	if err = os.Chmod(tsDir, 0755); err != nil {
+		return "", fmt.Errorf("%s: unable to set mode on new temp directory: %v", this.logContext, err)
+	}
+	return tsDir, nil
+}
+
+// writePayloadToDir 把文件写入时间戳目录
+func writePayloadToDir(payload map[string]FileProjection, dir string) error {
+	for userVisiblePath, fileProjection := range payload {
+		fullPath := filepath.Join(dir, userVisiblePath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return err
+		}
+		mode := os.FileMode(fileProjection.Mode)
+		if err := os.WriteFile(fullPath, fileProjection.Data, mode); err != nil {
+			return err
+		}
+		// 写入时受umask影响，重新设置权限
+		if err := os.Chmod(fullPath, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createUserVisibleFiles 为payload中的顶层路径创建指向..data的符号链接
+func (this *AtomicWriter) createUserVisibleFiles(payload map[string]FileProjection) error {
+	for userVisiblePath := range payload {
+		slashpos := strings.Index(userVisiblePath, string(os.PathSeparator))
+		if slashpos == -1 {
+			slashpos = len(userVisiblePath)
+		}
+		linkname := userVisiblePath[:slashpos]
+		visibleFile := filepath.Join(this.targetDir, linkname)
+		if _, err := os.Readlink(visibleFile); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			if err = os.Symlink(filepath.Join(dataDirName, linkname), visibleFile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeUserVisiblePaths 删除不再需要的顶层符号链接
+func (this *AtomicWriter) removeUserVisiblePaths(paths map[string]bool) error {
+	errs := []string{}
+	for p := range paths {
+		// 子路径在..data中，随旧版本一起删除
+		if strings.Contains(p, string(os.PathSeparator)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(this.targetDir, p)); err != nil && !os.IsNotExist(err) {
+			klog.ErrorS(err, "Failed to prune old user-visible path", "logContext", this.logContext, "path", p)
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error while removing old user-visible paths: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
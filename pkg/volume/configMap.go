@@ -0,0 +1,94 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const configMapPluginName = "kubernetes.io/configmap"
+
+// configMapPlugin 把configMap的数据写成文件，定期重新获取以同步configMap的变化
+// pkg/volume/configmap/configmap.go
+type configMapPlugin struct {
+	host VolumeHost
+}
+
+func (this *configMapPlugin) GetPluginName() string {
+	return configMapPluginName
+}
+
+func (this *configMapPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.ConfigMap != nil
+}
+
+func (this *configMapPlugin) RequiresRemount() bool {
+	return true
+}
+
+func (this *configMapPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	source := spec.ConfigMap
+	optional := source.Optional != nil && *source.Optional
+	return &payloadMounter{
+		path:       GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, configMapPluginName, spec.Name),
+		medium:     v1.StorageMediumDefault,
+		logContext: fmt.Sprintf("configMap volume %s/%s/%s", pod.Namespace, pod.Name, spec.Name),
+		makePayload: func() (map[string]FileProjection, error) {
+			configMap, err := getConfigMap(this.host, pod.Namespace, source.Name, optional)
+			if err != nil {
+				return nil, err
+			}
+			return makeConfigMapPayload(source.Items, configMap, source.DefaultMode, optional)
+		},
+	}, nil
+}
+
+func (this *configMapPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &dirUnmounter{path: GetPodVolumeDir(this.host.GetPodsDir(), podUID, configMapPluginName, volumeName)}, nil
+}
+
+// getConfigMap optional的configMap不存在时返回空的configMap
+func getConfigMap(host VolumeHost, namespace, name string, optional bool) (*v1.ConfigMap, error) {
+	configMap, err := host.GetKubeClient().CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+		}
+		return nil, fmt.Errorf("couldn't get configMap %s/%s: %v", namespace, name, err)
+	}
+	return configMap, nil
+}
+
+// makeConfigMapPayload 没有指定items时写入所有key，否则只写入items中的key
+// pkg/volume/configmap/configmap.go MakePayload
+func makeConfigMapPayload(items []v1.KeyToPath, configMap *v1.ConfigMap, defaultMode *int32,
+	optional bool) (map[string]FileProjection, error) {
+	payload := make(map[string]FileProjection, len(configMap.Data)+len(configMap.BinaryData))
+	if len(items) == 0 {
+		for name, data := range configMap.Data {
+			payload[name] = FileProjection{Data: []byte(data), Mode: fileMode(nil, defaultMode)}
+		}
+		for name, data := range configMap.BinaryData {
+			payload[name] = FileProjection{Data: data, Mode: fileMode(nil, defaultMode)}
+		}
+		return payload, nil
+	}
+	for _, item := range items {
+		var data []byte
+		if stringData, ok := configMap.Data[item.Key]; ok {
+			data = []byte(stringData)
+		} else if binaryData, ok := configMap.BinaryData[item.Key]; ok {
+			data = binaryData
+		} else {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("configmap references non-existent config key: %s", item.Key)
+		}
+		payload[item.Path] = FileProjection{Data: data, Mode: fileMode(item.Mode, defaultMode)}
+	}
+	return payload, nil
+}
@@ -0,0 +1,138 @@
+package csi
+
+import (
+	"context"
+	"fmt"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"strings"
+	"time"
+)
+
+// 每次调用csi驱动的超时时间
+const csiTimeout = 2 * time.Minute
+
+// csiDriverClient 调用csi驱动的Node服务，每次调用建立新的连接，驱动重启后不需要重连
+// pkg/volume/csi/csi_client.go
+type csiDriverClient struct {
+	driverName string
+	endpoint   string
+}
+
+// newCsiDriverClient 驱动必须已经通过插件注册目录注册
+func newCsiDriverClient(driverName string) (*csiDriverClient, error) {
+	driver, ok := csiDrivers.Get(driverName)
+	if !ok {
+		return nil, fmt.Errorf("driver name %s not found in the list of registered CSI drivers", driverName)
+	}
+	return &csiDriverClient{driverName: driverName, endpoint: driver.endpoint}, nil
+}
+
+func (this *csiDriverClient) withNodeClient(f func(ctx context.Context, client csipbv1.NodeClient) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), csiTimeout)
+	defer cancel()
+	target := this.endpoint
+	if !strings.HasPrefix(target, "unix://") {
+		target = "unix://" + target
+	}
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("failed to connect to CSI driver %s at %s: %v", this.driverName, this.endpoint, err)
+	}
+	defer conn.Close()
+	return f(ctx, csipbv1.NewNodeClient(conn))
+}
+
+// NodeGetInfo 节点在存储系统中的ID、最多可以挂载的卷数和拓扑
+func (this *csiDriverClient) NodeGetInfo() (string, int64, map[string]string, error) {
+	var resp *csipbv1.NodeGetInfoResponse
+	err := this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		var err error
+		resp, err = client.NodeGetInfo(ctx, &csipbv1.NodeGetInfoRequest{})
+		return err
+	})
+	if err != nil {
+		return "", 0, nil, err
+	}
+	var segments map[string]string
+	if resp.GetAccessibleTopology() != nil {
+		segments = resp.GetAccessibleTopology().GetSegments()
+	}
+	return resp.GetNodeId(), resp.GetMaxVolumesPerNode(), segments, nil
+}
+
+// NodeSupportsStageUnstage 驱动是否需要先NodeStageVolume到全局目录，再NodePublishVolume到pod目录
+func (this *csiDriverClient) NodeSupportsStageUnstage() (bool, error) {
+	var resp *csipbv1.NodeGetCapabilitiesResponse
+	err := this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		var err error
+		resp, err = client.NodeGetCapabilities(ctx, &csipbv1.NodeGetCapabilitiesRequest{})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, capability := range resp.GetCapabilities() {
+		if capability.GetRpc().GetType() == csipbv1.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NodeStageVolume 把卷挂载到节点的全局目录，同一个卷被多个pod使用时只需要一次
+func (this *csiDriverClient) NodeStageVolume(volumeID string, publishContext map[string]string, stagingTargetPath string,
+	capability *csipbv1.VolumeCapability, secrets, volumeContext map[string]string) error {
+	return this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		_, err := client.NodeStageVolume(ctx, &csipbv1.NodeStageVolumeRequest{
+			VolumeId:          volumeID,
+			PublishContext:    publishContext,
+			StagingTargetPath: stagingTargetPath,
+			VolumeCapability:  capability,
+			Secrets:           secrets,
+			VolumeContext:     volumeContext,
+		})
+		return err
+	})
+}
+
+// NodeUnstageVolume 卸载全局目录
+func (this *csiDriverClient) NodeUnstageVolume(volumeID, stagingTargetPath string) error {
+	return this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		_, err := client.NodeUnstageVolume(ctx, &csipbv1.NodeUnstageVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stagingTargetPath,
+		})
+		return err
+	})
+}
+
+// NodePublishVolume 把卷挂载到pod的卷目录
+func (this *csiDriverClient) NodePublishVolume(volumeID string, readOnly bool, stagingTargetPath, targetPath string,
+	capability *csipbv1.VolumeCapability, publishContext, volumeContext, secrets map[string]string) error {
+	return this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		_, err := client.NodePublishVolume(ctx, &csipbv1.NodePublishVolumeRequest{
+			VolumeId:          volumeID,
+			PublishContext:    publishContext,
+			StagingTargetPath: stagingTargetPath,
+			TargetPath:        targetPath,
+			VolumeCapability:  capability,
+			Readonly:          readOnly,
+			Secrets:           secrets,
+			VolumeContext:     volumeContext,
+		})
+		return err
+	})
+}
+
+// NodeUnpublishVolume 卸载pod的卷目录
+func (this *csiDriverClient) NodeUnpublishVolume(volumeID, targetPath string) error {
+	return this.withNodeClient(func(ctx context.Context, client csipbv1.NodeClient) error {
+		_, err := client.NodeUnpublishVolume(ctx, &csipbv1.NodeUnpublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: targetPath,
+		})
+		return err
+	})
+}
@@ -0,0 +1,364 @@
+package csi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/volume"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// 卷目录中保存卸载时需要的信息的文件
+	volDataFileName = "vol_data.json"
+	// pod卷目录中csi驱动挂载的子目录
+	mountDirName = "mount"
+
+	// inline卷传给驱动的volumeContext
+	volumeContextEphemeral = "csi.storage.k8s.io/ephemeral"
+	// podInfoOnMount为true时传给驱动的pod信息
+	volumeContextPodName            = "csi.storage.k8s.io/pod.name"
+	volumeContextPodNamespace       = "csi.storage.k8s.io/pod.namespace"
+	volumeContextPodUID             = "csi.storage.k8s.io/pod.uid"
+	volumeContextServiceAccountName = "csi.storage.k8s.io/serviceAccount.name"
+)
+
+// volumeData 保存在vol_data.json中，卸载和kubelet重启后重建时使用
+type volumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+	// 驱动支持stage时的全局目录，为空表示没有stage
+	StagingPath string `json:"stagingPath,omitempty"`
+	Ephemeral   bool   `json:"ephemeral,omitempty"`
+}
+
+// csiSource 从PV或inline卷中解析出来的csi参数
+type csiSource struct {
+	driverName           string
+	volumeHandle         string
+	readOnly             bool
+	fsType               string
+	mountOptions         []string
+	accessMode           csipbv1.VolumeCapability_AccessMode_Mode
+	volumeAttributes     map[string]string
+	nodeStageSecretRef   *v1.SecretReference
+	nodePublishSecretRef *v1.SecretReference
+	// inline卷不需要attach和stage
+	ephemeral bool
+}
+
+// csiMountMgr csi卷的挂载和卸载
+// 需要attach的卷等待VolumeAttachment变为attached，驱动支持时先stage到全局目录，再publish到pod的卷目录
+// pkg/volume/csi/csi_mounter.go
+type csiMountMgr struct {
+	plugin       *Plugin
+	spec         *v1.Volume
+	pod          *v1.Pod
+	podUID       types.UID
+	podVolumeDir string
+
+	// SetUp时解析
+	readOnly   bool
+	uniqueName v1.UniqueVolumeName
+}
+
+var _ volume.Mounter = &csiMountMgr{}
+var _ volume.Unmounter = &csiMountMgr{}
+var _ volume.UniqueVolumeNamer = &csiMountMgr{}
+
+// GetPath 驱动把卷挂载到卷目录的mount子目录
+func (this *csiMountMgr) GetPath() string {
+	return filepath.Join(this.podVolumeDir, mountDirName)
+}
+
+func (this *csiMountMgr) GetAttributes() volume.Attributes {
+	return volume.Attributes{ReadOnly: this.readOnly}
+}
+
+// GetUniqueVolumeName inline卷不需要attach，不上报
+func (this *csiMountMgr) GetUniqueVolumeName() v1.UniqueVolumeName {
+	return this.uniqueName
+}
+
+func (this *csiMountMgr) SetUp() error {
+	source, err := this.getCSISource()
+	if err != nil {
+		return err
+	}
+	this.readOnly = source.readOnly
+	client, err := newCsiDriverClient(source.driverName)
+	if err != nil {
+		return err
+	}
+	csiDriver, err := this.plugin.getCSIDriver(source.driverName)
+	if err != nil {
+		return fmt.Errorf("failed to get CSIDriver %s: %v", source.driverName, err)
+	}
+
+	// 需要attach的卷由attach/detach controller创建VolumeAttachment，external-attacher挂接后才能stage
+	var publishContext map[string]string
+	if !source.ephemeral && (csiDriver == nil || csiDriver.Spec.AttachRequired == nil || *csiDriver.Spec.AttachRequired) {
+		attachID := getAttachmentName(source.volumeHandle, source.driverName, string(this.plugin.host.GetNodeName()))
+		va, err := this.plugin.host.GetKubeClient().StorageV1().VolumeAttachments().Get(context.Background(), attachID, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get volume attachment %s: %v", attachID, err)
+		}
+		if !va.Status.Attached {
+			return fmt.Errorf("volume %s is not attached to node yet, volume attachment %s", source.volumeHandle, attachID)
+		}
+		publishContext = va.Status.AttachmentMetadata
+	}
+
+	capability := makeVolumeCapability(source)
+	volumeContext := map[string]string{}
+	for k, v := range source.volumeAttributes {
+		volumeContext[k] = v
+	}
+	if source.ephemeral {
+		volumeContext[volumeContextEphemeral] = "true"
+	}
+	if csiDriver != nil && csiDriver.Spec.PodInfoOnMount != nil && *csiDriver.Spec.PodInfoOnMount {
+		volumeContext[volumeContextPodName] = this.pod.Name
+		volumeContext[volumeContextPodNamespace] = this.pod.Namespace
+		volumeContext[volumeContextPodUID] = string(this.pod.UID)
+		volumeContext[volumeContextServiceAccountName] = this.pod.Spec.ServiceAccountName
+	}
+
+	data := volumeData{
+		DriverName:   source.driverName,
+		VolumeHandle: source.volumeHandle,
+		Ephemeral:    source.ephemeral,
+	}
+	if !source.ephemeral {
+		stageUnstageSet, err := client.NodeSupportsStageUnstage()
+		if err != nil {
+			return fmt.Errorf("failed to check STAGE_UNSTAGE_VOLUME capability: %v", err)
+		}
+		if stageUnstageSet {
+			data.StagingPath = this.getStagingPath(source.driverName, source.volumeHandle)
+		}
+	}
+	// 先保存卷信息，publish中途失败时也能卸载
+	if err = os.MkdirAll(this.podVolumeDir, 0750); err != nil {
+		return err
+	}
+	if err = saveVolumeData(this.podVolumeDir, data); err != nil {
+		return err
+	}
+
+	if data.StagingPath != "" {
+		if err = os.MkdirAll(data.StagingPath, 0750); err != nil {
+			return err
+		}
+		secrets, err := this.getSecrets(source.nodeStageSecretRef)
+		if err != nil {
+			return err
+		}
+		if err = client.NodeStageVolume(source.volumeHandle, publishContext, data.StagingPath, capability, secrets, volumeContext); err != nil {
+			return fmt.Errorf("MountVolume.MountDevice failed for volume %s: %v", source.volumeHandle, err)
+		}
+	}
+
+	// 目标目录的父目录由kubelet创建，目标目录由驱动创建
+	secrets, err := this.getSecrets(source.nodePublishSecretRef)
+	if err != nil {
+		return err
+	}
+	if err = client.NodePublishVolume(source.volumeHandle, source.readOnly, data.StagingPath, this.GetPath(), capability,
+		publishContext, volumeContext, secrets); err != nil {
+		return fmt.Errorf("rpc NodePublishVolume failed for volume %s: %v", source.volumeHandle, err)
+	}
+	if !source.ephemeral {
+		this.uniqueName = getUniqueVolumeName(source.driverName, source.volumeHandle)
+	}
+	klog.V(4).InfoS("CSI volume published", "pod", klog.KObj(this.pod), "driver", source.driverName,
+		"volumeHandle", source.volumeHandle, "targetPath", this.GetPath())
+	return nil
+}
+
+// TearDown unpublish pod的卷目录，同一个卷没有其他pod使用时unstage全局目录
+func (this *csiMountMgr) TearDown() error {
+	data, err := loadVolumeData(this.podVolumeDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 还没有publish过
+			return os.RemoveAll(this.podVolumeDir)
+		}
+		return err
+	}
+	client, err := newCsiDriverClient(data.DriverName)
+	if err != nil {
+		return err
+	}
+	if err = client.NodeUnpublishVolume(data.VolumeHandle, this.GetPath()); err != nil {
+		return fmt.Errorf("rpc NodeUnpublishVolume failed for volume %s: %v", data.VolumeHandle, err)
+	}
+	if err = os.RemoveAll(this.podVolumeDir); err != nil {
+		return err
+	}
+
+	if data.StagingPath == "" || this.volumeInUse(data) {
+		return nil
+	}
+	if err = client.NodeUnstageVolume(data.VolumeHandle, data.StagingPath); err != nil {
+		return fmt.Errorf("rpc NodeUnstageVolume failed for volume %s: %v", data.VolumeHandle, err)
+	}
+	// 删除 <驱动名>/<volumeHandle的hash> 目录
+	return os.RemoveAll(filepath.Dir(data.StagingPath))
+}
+
+// volumeInUse 其他pod是否还在使用同一个卷
+func (this *csiMountMgr) volumeInUse(data *volumeData) bool {
+	pattern := filepath.Join(this.plugin.host.GetPodsDir(), "*", "volumes", volume.EscapePluginName(CSIPluginName), "*", volDataFileName)
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return true
+	}
+	for _, file := range files {
+		other, err := loadVolumeData(filepath.Dir(file))
+		if err != nil {
+			continue
+		}
+		if other.DriverName == data.DriverName && other.VolumeHandle == data.VolumeHandle {
+			return true
+		}
+	}
+	return false
+}
+
+// getStagingPath 卷的全局目录 <rootDirectory>/plugins/kubernetes.io/csi/<驱动名>/<volumeHandle的sha256>/globalmount
+func (this *csiMountMgr) getStagingPath(driverName, volumeHandle string) string {
+	return filepath.Join(this.plugin.host.GetPluginDir(CSIPluginName), driverName,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(volumeHandle))), "globalmount")
+}
+
+// getCSISource PVC从绑定的PV中解析，inline卷直接使用卷的spec
+func (this *csiMountMgr) getCSISource() (*csiSource, error) {
+	if this.spec.CSI != nil {
+		inline := this.spec.CSI
+		// inline卷的handle由pod和卷名生成
+		handle := fmt.Sprintf("csi-%x", sha256.Sum256([]byte(string(this.pod.UID)+this.spec.Name)))
+		source := &csiSource{
+			driverName:       inline.Driver,
+			volumeHandle:     handle,
+			readOnly:         inline.ReadOnly != nil && *inline.ReadOnly,
+			volumeAttributes: inline.VolumeAttributes,
+			accessMode:       csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			ephemeral:        true,
+		}
+		if inline.FSType != nil {
+			source.fsType = *inline.FSType
+		}
+		if inline.NodePublishSecretRef != nil {
+			source.nodePublishSecretRef = &v1.SecretReference{Name: inline.NodePublishSecretRef.Name, Namespace: this.pod.Namespace}
+		}
+		return source, nil
+	}
+
+	client := this.plugin.host.GetKubeClient()
+	claimName := this.spec.PersistentVolumeClaim.ClaimName
+	pvc, err := client.CoreV1().PersistentVolumeClaims(this.pod.Namespace).Get(context.Background(), claimName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error processing PVC %s/%s: %v", this.pod.Namespace, claimName, err)
+	}
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("PVC %s/%s is not bound", this.pod.Namespace, claimName)
+	}
+	pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error processing PVC %s/%s: %v", this.pod.Namespace, claimName, err)
+	}
+	if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.UID != pvc.UID {
+		return nil, fmt.Errorf("PV %s is not bound to PVC %s/%s", pv.Name, this.pod.Namespace, claimName)
+	}
+	if pv.Spec.CSI == nil {
+		return nil, fmt.Errorf("PV %s of PVC %s/%s is not a CSI volume, only CSI persistent volumes are supported",
+			pv.Name, this.pod.Namespace, claimName)
+	}
+	if pv.Spec.VolumeMode != nil && *pv.Spec.VolumeMode == v1.PersistentVolumeBlock {
+		return nil, fmt.Errorf("PV %s is a raw block volume, which is not supported", pv.Name)
+	}
+	return &csiSource{
+		driverName:           pv.Spec.CSI.Driver,
+		volumeHandle:         pv.Spec.CSI.VolumeHandle,
+		readOnly:             this.spec.PersistentVolumeClaim.ReadOnly || pv.Spec.CSI.ReadOnly,
+		fsType:               pv.Spec.CSI.FSType,
+		mountOptions:         pv.Spec.MountOptions,
+		accessMode:           accessModeFromPV(pv.Spec.AccessModes),
+		volumeAttributes:     pv.Spec.CSI.VolumeAttributes,
+		nodeStageSecretRef:   pv.Spec.CSI.NodeStageSecretRef,
+		nodePublishSecretRef: pv.Spec.CSI.NodePublishSecretRef,
+	}, nil
+}
+
+// getSecrets 读取secret的内容传给驱动
+func (this *csiMountMgr) getSecrets(ref *v1.SecretReference) (map[string]string, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	secret, err := this.plugin.host.GetKubeClient().CoreV1().Secrets(ref.Namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the secret %s in the namespace %s with error: %v", ref.Name, ref.Namespace, err)
+	}
+	secrets := map[string]string{}
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+	return secrets, nil
+}
+
+// accessModeFromPV 多种访问模式时取范围最大的
+func accessModeFromPV(modes []v1.PersistentVolumeAccessMode) csipbv1.VolumeCapability_AccessMode_Mode {
+	has := map[v1.PersistentVolumeAccessMode]bool{}
+	for _, mode := range modes {
+		has[mode] = true
+	}
+	switch {
+	case has[v1.ReadWriteMany]:
+		return csipbv1.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	case has[v1.ReadOnlyMany]:
+		return csipbv1.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	case has[v1.ReadWriteOncePod]:
+		return csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER
+	}
+	return csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+}
+
+func makeVolumeCapability(source *csiSource) *csipbv1.VolumeCapability {
+	return &csipbv1.VolumeCapability{
+		AccessMode: &csipbv1.VolumeCapability_AccessMode{Mode: source.accessMode},
+		AccessType: &csipbv1.VolumeCapability_Mount{
+			Mount: &csipbv1.VolumeCapability_MountVolume{
+				FsType:     source.fsType,
+				MountFlags: source.mountOptions,
+			},
+		},
+	}
+}
+
+func saveVolumeData(dir string, data volumeData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, volDataFileName), content, 0640)
+}
+
+func loadVolumeData(dir string) (*volumeData, error) {
+	content, err := os.ReadFile(filepath.Join(dir, volDataFileName))
+	if err != nil {
+		return nil, err
+	}
+	data := &volumeData{}
+	if err = json.Unmarshal(content, data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in %s: %v", volDataFileName, dir, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,385 @@
+package csi
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	testVolumeHandle = "vol-1"
+	testPVName       = "pv-1"
+	testPVCName      = "data"
+	testNamespace    = "default"
+)
+
+// newBoundClaim 绑定到csi PV的PVC
+func newBoundClaim() (*v1.PersistentVolumeClaim, *v1.PersistentVolume) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVCName, Namespace: testNamespace, UID: "pvc-uid"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: testPVName},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: testPVName},
+		Spec: v1.PersistentVolumeSpec{
+			AccessModes:  []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			MountOptions: []string{"noatime"},
+			ClaimRef:     &v1.ObjectReference{Name: testPVCName, Namespace: testNamespace, UID: "pvc-uid"},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:           testDriverName,
+					VolumeHandle:     testVolumeHandle,
+					FSType:           "ext4",
+					VolumeAttributes: map[string]string{"type": "ssd"},
+				},
+			},
+		},
+	}
+	return pvc, pv
+}
+
+func newClaimPod(uid types.UID) (*v1.Pod, *v1.Volume) {
+	spec := &v1.Volume{
+		Name: "data",
+		VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: testPVCName},
+		},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-" + string(uid), Namespace: testNamespace, UID: uid},
+		Spec:       v1.PodSpec{Volumes: []v1.Volume{*spec}},
+	}
+	return pod, spec
+}
+
+// setAttached external-attacher把卷挂接到本节点
+func setAttached(t *testing.T, host *fakeVolumeHost, attached bool) {
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: getAttachmentName(testVolumeHandle, testDriverName, testNodeName)},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: testDriverName,
+			NodeName: testNodeName,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: func() *string { s := testPVName; return &s }()},
+		},
+		Status: storagev1.VolumeAttachmentStatus{
+			Attached:           attached,
+			AttachmentMetadata: map[string]string{"devicePath": "/dev/vdb"},
+		},
+	}
+	if _, err := host.client.StorageV1().VolumeAttachments().Create(context.Background(), va, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestMounter(t *testing.T, plugin *Plugin, pod *v1.Pod, spec *v1.Volume) *csiMountMgr {
+	mounter, err := plugin.NewMounter(spec, pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mounter.(*csiMountMgr)
+}
+
+func newTestUnmounter(t *testing.T, plugin *Plugin, pod *v1.Pod, spec *v1.Volume) *csiMountMgr {
+	unmounter, err := plugin.NewUnmounter(spec.Name, pod.UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unmounter.(*csiMountMgr)
+}
+
+func expectCalls(t *testing.T, driver *fakeCSIDriver, expected ...string) {
+	t.Helper()
+	if expected == nil {
+		expected = []string{}
+	}
+	if calls := driver.getCalls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected driver calls %v, got %v", expected, calls)
+	}
+	driver.resetCalls()
+}
+
+func TestSetUpAndTearDownWithStage(t *testing.T) {
+	driver := &fakeCSIDriver{stageUnstage: true}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	host := newFakeVolumeHost(t, pvc, pv)
+	setAttached(t, host, true)
+	plugin := NewPlugin(host)
+	pod, spec := newClaimPod("pod-1")
+
+	mounter := newTestMounter(t, plugin, pod, spec)
+	if err := mounter.SetUp(); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeStageVolume", "NodePublishVolume")
+
+	stagingPath := mounter.getStagingPath(testDriverName, testVolumeHandle)
+	if driver.stageRequest.StagingTargetPath != stagingPath || driver.stageRequest.VolumeId != testVolumeHandle {
+		t.Errorf("unexpected stage request %+v", driver.stageRequest)
+	}
+	if driver.stageRequest.PublishContext["devicePath"] != "/dev/vdb" {
+		t.Errorf("expected publish context from volume attachment, got %v", driver.stageRequest.PublishContext)
+	}
+	if mount := driver.stageRequest.VolumeCapability.GetMount(); mount.FsType != "ext4" || !reflect.DeepEqual(mount.MountFlags, []string{"noatime"}) {
+		t.Errorf("unexpected volume capability %+v", driver.stageRequest.VolumeCapability)
+	}
+	publish := driver.publishRequest
+	if publish.StagingTargetPath != stagingPath || publish.TargetPath != mounter.GetPath() {
+		t.Errorf("unexpected publish paths staging %s target %s", publish.StagingTargetPath, publish.TargetPath)
+	}
+	if publish.VolumeContext["type"] != "ssd" {
+		t.Errorf("expected volume attributes in volume context, got %v", publish.VolumeContext)
+	}
+	if _, err := os.Stat(stagingPath); err != nil {
+		t.Errorf("expected staging path to be created: %v", err)
+	}
+	if name := mounter.GetUniqueVolumeName(); name != getUniqueVolumeName(testDriverName, testVolumeHandle) {
+		t.Errorf("unexpected unique volume name %s", name)
+	}
+
+	unmounter := newTestUnmounter(t, plugin, pod, spec)
+	if err := unmounter.TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume", "NodeUnstageVolume")
+	if _, err := os.Stat(unmounter.podVolumeDir); !os.IsNotExist(err) {
+		t.Errorf("expected pod volume dir to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(stagingPath)); !os.IsNotExist(err) {
+		t.Errorf("expected staging dir to be removed, got %v", err)
+	}
+}
+
+// 同一个卷还有其他pod在使用时只unpublish
+func TestTearDownKeepsStagedVolumeInUse(t *testing.T) {
+	driver := &fakeCSIDriver{stageUnstage: true}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	host := newFakeVolumeHost(t, pvc, pv)
+	setAttached(t, host, true)
+	plugin := NewPlugin(host)
+	pod1, spec := newClaimPod("pod-1")
+	pod2, _ := newClaimPod("pod-2")
+
+	for _, pod := range []*v1.Pod{pod1, pod2} {
+		if err := newTestMounter(t, plugin, pod, spec).SetUp(); err != nil {
+			t.Fatalf("SetUp failed: %v", err)
+		}
+	}
+	expectCalls(t, driver, "NodeStageVolume", "NodePublishVolume", "NodeStageVolume", "NodePublishVolume")
+
+	if err := newTestUnmounter(t, plugin, pod1, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume")
+
+	if err := newTestUnmounter(t, plugin, pod2, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume", "NodeUnstageVolume")
+}
+
+func TestSetUpAndTearDownWithoutStage(t *testing.T) {
+	driver := &fakeCSIDriver{}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	host := newFakeVolumeHost(t, pvc, pv)
+	setAttached(t, host, true)
+	plugin := NewPlugin(host)
+	pod, spec := newClaimPod("pod-1")
+
+	if err := newTestMounter(t, plugin, pod, spec).SetUp(); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	expectCalls(t, driver, "NodePublishVolume")
+	if driver.publishRequest.StagingTargetPath != "" {
+		t.Errorf("expected no staging path, got %s", driver.publishRequest.StagingTargetPath)
+	}
+
+	if err := newTestUnmounter(t, plugin, pod, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume")
+}
+
+// inline卷不需要attach和stage，podInfoOnMount时传入pod信息
+func TestSetUpInlineVolume(t *testing.T) {
+	driver := &fakeCSIDriver{stageUnstage: true}
+	registerFakeCSIDriver(t, driver)
+	host := newFakeVolumeHost(t)
+	podInfoOnMount := true
+	csiDriver := &storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{Name: testDriverName},
+		Spec:       storagev1.CSIDriverSpec{PodInfoOnMount: &podInfoOnMount},
+	}
+	if _, err := host.client.StorageV1().CSIDrivers().Create(context.Background(), csiDriver, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	plugin := NewPlugin(host)
+	spec := &v1.Volume{
+		Name: "scratch",
+		VolumeSource: v1.VolumeSource{
+			CSI: &v1.CSIVolumeSource{Driver: testDriverName, VolumeAttributes: map[string]string{"size": "1Gi"}},
+		},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "inline", Namespace: testNamespace, UID: "inline-uid"},
+		Spec:       v1.PodSpec{ServiceAccountName: "default", Volumes: []v1.Volume{*spec}},
+	}
+
+	mounter := newTestMounter(t, plugin, pod, spec)
+	if err := mounter.SetUp(); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	expectCalls(t, driver, "NodePublishVolume")
+	expectedContext := map[string]string{
+		"size":                          "1Gi",
+		volumeContextEphemeral:          "true",
+		volumeContextPodName:            "inline",
+		volumeContextPodNamespace:       testNamespace,
+		volumeContextPodUID:             "inline-uid",
+		volumeContextServiceAccountName: "default",
+	}
+	if !reflect.DeepEqual(driver.publishRequest.VolumeContext, expectedContext) {
+		t.Errorf("expected volume context %v, got %v", expectedContext, driver.publishRequest.VolumeContext)
+	}
+	if name := mounter.GetUniqueVolumeName(); name != "" {
+		t.Errorf("inline volume should not be reported as in use, got %s", name)
+	}
+
+	if err := newTestUnmounter(t, plugin, pod, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume")
+}
+
+func TestSetUpErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		attached      bool
+		registered    bool
+		failMethod    string
+		expectedCalls []string
+	}{
+		{name: "driver not registered", attached: true},
+		{name: "volume not attached", registered: true},
+		{
+			name:          "NodeStageVolume fails",
+			attached:      true,
+			registered:    true,
+			failMethod:    "NodeStageVolume",
+			expectedCalls: []string{"NodeStageVolume"},
+		},
+		{
+			name:          "NodePublishVolume fails",
+			attached:      true,
+			registered:    true,
+			failMethod:    "NodePublishVolume",
+			expectedCalls: []string{"NodeStageVolume", "NodePublishVolume"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			driver := &fakeCSIDriver{stageUnstage: true}
+			if tc.registered {
+				registerFakeCSIDriver(t, driver)
+			}
+			if tc.failMethod != "" {
+				driver.setError(tc.failMethod, fmt.Errorf("driver error"))
+			}
+			pvc, pv := newBoundClaim()
+			host := newFakeVolumeHost(t, pvc, pv)
+			setAttached(t, host, tc.attached)
+			pod, spec := newClaimPod("pod-1")
+
+			if err := newTestMounter(t, NewPlugin(host), pod, spec).SetUp(); err == nil {
+				t.Fatalf("expected SetUp to fail")
+			}
+			expectCalls(t, driver, tc.expectedCalls...)
+		})
+	}
+}
+
+func TestSetUpUnboundClaim(t *testing.T) {
+	driver := &fakeCSIDriver{}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	pvc.Status.Phase = v1.ClaimPending
+	host := newFakeVolumeHost(t, pvc, pv)
+	pod, spec := newClaimPod("pod-1")
+
+	if err := newTestMounter(t, NewPlugin(host), pod, spec).SetUp(); err == nil {
+		t.Fatalf("expected SetUp to fail for an unbound claim")
+	}
+	expectCalls(t, driver)
+}
+
+// 失败的publish也保存了卷信息，卸载时按相反顺序清理
+func TestTearDownAfterFailedPublish(t *testing.T) {
+	driver := &fakeCSIDriver{stageUnstage: true}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	host := newFakeVolumeHost(t, pvc, pv)
+	setAttached(t, host, true)
+	plugin := NewPlugin(host)
+	pod, spec := newClaimPod("pod-1")
+
+	driver.setError("NodePublishVolume", fmt.Errorf("driver error"))
+	if err := newTestMounter(t, plugin, pod, spec).SetUp(); err == nil {
+		t.Fatalf("expected SetUp to fail")
+	}
+	driver.resetCalls()
+
+	if err := newTestUnmounter(t, plugin, pod, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown failed: %v", err)
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume", "NodeUnstageVolume")
+}
+
+func TestTearDownErrors(t *testing.T) {
+	driver := &fakeCSIDriver{stageUnstage: true}
+	registerFakeCSIDriver(t, driver)
+	pvc, pv := newBoundClaim()
+	host := newFakeVolumeHost(t, pvc, pv)
+	setAttached(t, host, true)
+	plugin := NewPlugin(host)
+	pod, spec := newClaimPod("pod-1")
+	if err := newTestMounter(t, plugin, pod, spec).SetUp(); err != nil {
+		t.Fatalf("SetUp failed: %v", err)
+	}
+	driver.resetCalls()
+
+	// unpublish失败时保留卷信息，下次重试
+	driver.setError("NodeUnpublishVolume", fmt.Errorf("driver error"))
+	unmounter := newTestUnmounter(t, plugin, pod, spec)
+	if err := unmounter.TearDown(); err == nil {
+		t.Fatalf("expected TearDown to fail")
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume")
+	if _, err := loadVolumeData(unmounter.podVolumeDir); err != nil {
+		t.Fatalf("expected volume data to be kept: %v", err)
+	}
+
+	// unstage失败时返回错误
+	driver.setError("NodeUnpublishVolume", nil)
+	driver.setError("NodeUnstageVolume", fmt.Errorf("driver error"))
+	if err := unmounter.TearDown(); err == nil {
+		t.Fatalf("expected TearDown to fail")
+	}
+	expectCalls(t, driver, "NodeUnpublishVolume", "NodeUnstageVolume")
+
+	// 还没有publish过的卷直接删除目录
+	pod2, _ := newClaimPod("pod-2")
+	if err := newTestUnmounter(t, plugin, pod2, spec).TearDown(); err != nil {
+		t.Fatalf("TearDown of a never published volume failed: %v", err)
+	}
+	expectCalls(t, driver)
+}
@@ -0,0 +1,237 @@
+package csi
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/klog/v2"
+	"mykubelet/pkg/volume"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CSIPluginName csi卷插件的名字
+const CSIPluginName = "kubernetes.io/csi"
+
+// Driver 已经注册的csi驱动
+type Driver struct {
+	endpoint                string
+	highestSupportedVersion *utilversion.Version
+}
+
+// DriversStore 已经注册的csi驱动，key为驱动名
+type DriversStore struct {
+	lock  sync.RWMutex
+	store map[string]Driver
+}
+
+// Get 按驱动名查找驱动
+func (this *DriversStore) Get(driverName string) (Driver, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	driver, ok := this.store[driverName]
+	return driver, ok
+}
+
+// Set 添加或替换驱动
+func (this *DriversStore) Set(driverName string, driver Driver) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.store[driverName] = driver
+}
+
+// Delete 删除驱动
+func (this *DriversStore) Delete(driverName string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.store, driverName)
+}
+
+// csiDrivers 通过插件注册目录注册的驱动，同一个进程中只有一个kubelet
+var csiDrivers = &DriversStore{store: map[string]Driver{}}
+
+// Plugin csi卷插件，处理绑定到csi PV的PVC和inline的csi卷
+// pkg/volume/csi/csi_plugin.go
+type Plugin struct {
+	host            volume.VolumeHost
+	nodeInfoManager *nodeInfoManager
+}
+
+var _ volume.VolumePlugin = &Plugin{}
+
+// NewPlugin 创建csi卷插件
+func NewPlugin(host volume.VolumeHost) *Plugin {
+	return &Plugin{
+		host:            host,
+		nodeInfoManager: newNodeInfoManager(host.GetNodeName(), host.GetKubeClient()),
+	}
+}
+
+func (this *Plugin) GetPluginName() string {
+	return CSIPluginName
+}
+
+// CanSupport PVC绑定的PV是否为csi卷要在SetUp时才能确定，所有PVC都由csi插件处理
+func (this *Plugin) CanSupport(spec *v1.Volume) bool {
+	return spec.PersistentVolumeClaim != nil || spec.CSI != nil
+}
+
+func (this *Plugin) RequiresRemount() bool {
+	return false
+}
+
+func (this *Plugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (volume.Mounter, error) {
+	return &csiMountMgr{
+		plugin:       this,
+		spec:         spec,
+		pod:          pod,
+		podVolumeDir: volume.GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, CSIPluginName, spec.Name),
+	}, nil
+}
+
+func (this *Plugin) NewUnmounter(volumeName string, podUID types.UID) (volume.Unmounter, error) {
+	return &csiMountMgr{
+		plugin:       this,
+		podUID:       podUID,
+		podVolumeDir: volume.GetPodVolumeDir(this.host.GetPodsDir(), podUID, CSIPluginName, volumeName),
+	}, nil
+}
+
+// GetVolumesAttached 已经挂接到本节点的csi卷，来自状态为attached的VolumeAttachment
+func (this *Plugin) GetVolumesAttached() ([]v1.AttachedVolume, error) {
+	client := this.host.GetKubeClient()
+	attachments, err := client.StorageV1().VolumeAttachments().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := []v1.AttachedVolume{}
+	for _, va := range attachments.Items {
+		if va.Spec.NodeName != string(this.host.GetNodeName()) || !va.Status.Attached || va.Spec.Source.PersistentVolumeName == nil {
+			continue
+		}
+		pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), *va.Spec.Source.PersistentVolumeName, metav1.GetOptions{})
+		if err != nil {
+			klog.V(4).InfoS("Failed to get persistent volume of volume attachment", "volumeAttachment", va.Name, "err", err)
+			continue
+		}
+		if pv.Spec.CSI == nil {
+			continue
+		}
+		ret = append(ret, v1.AttachedVolume{Name: getUniqueVolumeName(pv.Spec.CSI.Driver, pv.Spec.CSI.VolumeHandle)})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// getCSIDriver 驱动的CSIDriver对象，不存在时返回nil，按默认值处理
+func (this *Plugin) getCSIDriver(driverName string) (*storagev1.CSIDriver, error) {
+	csiDriver, err := this.host.GetKubeClient().StorageV1().CSIDrivers().Get(context.Background(), driverName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return csiDriver, nil
+}
+
+// getUniqueVolumeName 和attach/detach controller使用的名字一致 kubernetes.io/csi/<驱动名>^<volumeHandle>
+func getUniqueVolumeName(driverName, volumeHandle string) v1.UniqueVolumeName {
+	return v1.UniqueVolumeName(fmt.Sprintf("%s/%s^%s", CSIPluginName, driverName, volumeHandle))
+}
+
+// getAttachmentName external-attacher创建的VolumeAttachment的名字
+func getAttachmentName(volumeHandle, driverName, nodeName string) string {
+	result := sha256.Sum256([]byte(fmt.Sprintf("%s%s%s", volumeHandle, driverName, nodeName)))
+	return fmt.Sprintf("csi-%x", result)
+}
+
+// RegistrationHandler 处理插件注册目录中CSIPlugin类型的插件
+// pkg/volume/csi/csi_plugin.go RegistrationHandler
+type RegistrationHandler struct {
+	plugin *Plugin
+}
+
+// NewRegistrationHandler 驱动注册后获取节点信息并发布到CSINode和Node
+func NewRegistrationHandler(plugin *Plugin) *RegistrationHandler {
+	return &RegistrationHandler{plugin: plugin}
+}
+
+// ValidatePlugin 只支持1.x版本的csi
+func (this *RegistrationHandler) ValidatePlugin(pluginName string, endpoint string, versions []string) error {
+	klog.InfoS("Trying to validate a new CSI Driver", "name", pluginName, "endpoint", endpoint, "versions", strings.Join(versions, ","))
+	_, err := highestSupportedVersion(versions)
+	if err != nil {
+		return fmt.Errorf("validation failed for CSI Driver %s at endpoint %s: %v", pluginName, endpoint, err)
+	}
+	return nil
+}
+
+// RegisterPlugin 记录驱动的endpoint，调用NodeGetInfo并更新CSINode和Node
+func (this *RegistrationHandler) RegisterPlugin(pluginName string, endpoint string, versions []string) error {
+	klog.InfoS("Register new plugin", "name", pluginName, "endpoint", endpoint)
+	highestVersion, err := highestSupportedVersion(versions)
+	if err != nil {
+		return err
+	}
+	csiDrivers.Set(pluginName, Driver{endpoint: endpoint, highestSupportedVersion: highestVersion})
+
+	client, err := newCsiDriverClient(pluginName)
+	if err != nil {
+		csiDrivers.Delete(pluginName)
+		return err
+	}
+	nodeID, maxVolumePerNode, accessibleTopology, err := client.NodeGetInfo()
+	if err != nil {
+		csiDrivers.Delete(pluginName)
+		return fmt.Errorf("error during CSI NodeGetInfo() call: %v", err)
+	}
+	if err = this.plugin.nodeInfoManager.InstallCSIDriver(pluginName, nodeID, maxVolumePerNode, accessibleTopology); err != nil {
+		csiDrivers.Delete(pluginName)
+		return fmt.Errorf("error updating CSINode object with CSI driver node info: %v", err)
+	}
+	return nil
+}
+
+// DeRegisterPlugin 驱动的注册socket删除后，从CSINode和Node中删除驱动的信息
+func (this *RegistrationHandler) DeRegisterPlugin(pluginName string) {
+	klog.InfoS("Deregister plugin", "name", pluginName)
+	csiDrivers.Delete(pluginName)
+	if err := this.plugin.nodeInfoManager.UninstallCSIDriver(pluginName); err != nil {
+		klog.ErrorS(err, "Failed to uninstall CSI driver", "name", pluginName)
+	}
+}
+
+// highestSupportedVersion 驱动支持的最高的1.x版本
+func highestSupportedVersion(versions []string) (*utilversion.Version, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("CSI driver reporting empty array for supported versions")
+	}
+	var highest *utilversion.Version
+	for _, v := range versions {
+		version, err := utilversion.ParseGeneric(v)
+		if err != nil {
+			klog.InfoS("Invalid CSI version, skipping", "version", v, "err", err)
+			continue
+		}
+		if version.Major() != 1 {
+			continue
+		}
+		if highest == nil || highest.LessThan(version) {
+			highest = version
+		}
+	}
+	if highest == nil {
+		return nil, fmt.Errorf("none of the CSI versions reported by this driver are supported")
+	}
+	return highest, nil
+}
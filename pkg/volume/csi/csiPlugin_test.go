@@ -0,0 +1,365 @@
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const (
+	testDriverName = "csi.example.com"
+	testNodeName   = "node"
+	testZoneKey    = "topology.csi.example.com/zone"
+)
+
+// fakeCSIDriver 在unix socket上提供csi的Node服务，记录调用顺序，可以为每个方法注入错误
+type fakeCSIDriver struct {
+	csipbv1.UnimplementedNodeServer
+
+	nodeID       string
+	maxVolumes   int64
+	topology     map[string]string
+	stageUnstage bool
+
+	lock   sync.Mutex
+	calls  []string
+	errors map[string]error
+	// 最近一次NodeStageVolume和NodePublishVolume的请求
+	stageRequest   *csipbv1.NodeStageVolumeRequest
+	publishRequest *csipbv1.NodePublishVolumeRequest
+}
+
+// startFakeCSIDriver 启动驱动并返回socket路径，测试结束时停止
+func startFakeCSIDriver(t *testing.T, driver *fakeCSIDriver) string {
+	// unix socket路径长度有限，不使用t.TempDir()
+	dir, err := os.MkdirTemp("", "csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := filepath.Join(dir, "csi.sock")
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	csipbv1.RegisterNodeServer(server, driver)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	})
+	return endpoint
+}
+
+// registerFakeCSIDriver 启动驱动并直接加入已注册的驱动，不经过NodeGetInfo
+func registerFakeCSIDriver(t *testing.T, driver *fakeCSIDriver) {
+	endpoint := startFakeCSIDriver(t, driver)
+	csiDrivers.Set(testDriverName, Driver{endpoint: endpoint})
+	t.Cleanup(func() {
+		csiDrivers.Delete(testDriverName)
+	})
+}
+
+func (this *fakeCSIDriver) record(method string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.calls = append(this.calls, method)
+	return this.errors[method]
+}
+
+func (this *fakeCSIDriver) setError(method string, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.errors == nil {
+		this.errors = map[string]error{}
+	}
+	this.errors[method] = err
+}
+
+// getCalls 除了NodeGetCapabilities之外的调用
+func (this *fakeCSIDriver) getCalls() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	calls := []string{}
+	for _, call := range this.calls {
+		if call != "NodeGetCapabilities" {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (this *fakeCSIDriver) resetCalls() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.calls = nil
+}
+
+func (this *fakeCSIDriver) NodeGetInfo(_ context.Context, _ *csipbv1.NodeGetInfoRequest) (*csipbv1.NodeGetInfoResponse, error) {
+	if err := this.record("NodeGetInfo"); err != nil {
+		return nil, err
+	}
+	resp := &csipbv1.NodeGetInfoResponse{NodeId: this.nodeID, MaxVolumesPerNode: this.maxVolumes}
+	if this.topology != nil {
+		resp.AccessibleTopology = &csipbv1.Topology{Segments: this.topology}
+	}
+	return resp, nil
+}
+
+func (this *fakeCSIDriver) NodeGetCapabilities(_ context.Context, _ *csipbv1.NodeGetCapabilitiesRequest) (*csipbv1.NodeGetCapabilitiesResponse, error) {
+	if err := this.record("NodeGetCapabilities"); err != nil {
+		return nil, err
+	}
+	resp := &csipbv1.NodeGetCapabilitiesResponse{}
+	if this.stageUnstage {
+		resp.Capabilities = append(resp.Capabilities, &csipbv1.NodeServiceCapability{
+			Type: &csipbv1.NodeServiceCapability_Rpc{
+				Rpc: &csipbv1.NodeServiceCapability_RPC{Type: csipbv1.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME},
+			},
+		})
+	}
+	return resp, nil
+}
+
+func (this *fakeCSIDriver) NodeStageVolume(_ context.Context, req *csipbv1.NodeStageVolumeRequest) (*csipbv1.NodeStageVolumeResponse, error) {
+	if err := this.record("NodeStageVolume"); err != nil {
+		return nil, err
+	}
+	this.lock.Lock()
+	this.stageRequest = req
+	this.lock.Unlock()
+	return &csipbv1.NodeStageVolumeResponse{}, nil
+}
+
+func (this *fakeCSIDriver) NodeUnstageVolume(_ context.Context, _ *csipbv1.NodeUnstageVolumeRequest) (*csipbv1.NodeUnstageVolumeResponse, error) {
+	if err := this.record("NodeUnstageVolume"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.NodeUnstageVolumeResponse{}, nil
+}
+
+func (this *fakeCSIDriver) NodePublishVolume(_ context.Context, req *csipbv1.NodePublishVolumeRequest) (*csipbv1.NodePublishVolumeResponse, error) {
+	if err := this.record("NodePublishVolume"); err != nil {
+		return nil, err
+	}
+	this.lock.Lock()
+	this.publishRequest = req
+	this.lock.Unlock()
+	return &csipbv1.NodePublishVolumeResponse{}, nil
+}
+
+func (this *fakeCSIDriver) NodeUnpublishVolume(_ context.Context, _ *csipbv1.NodeUnpublishVolumeRequest) (*csipbv1.NodeUnpublishVolumeResponse, error) {
+	if err := this.record("NodeUnpublishVolume"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.NodeUnpublishVolumeResponse{}, nil
+}
+
+// fakeVolumeHost 卷目录在临时目录中
+type fakeVolumeHost struct {
+	rootDir string
+	client  kubernetes.Interface
+}
+
+func newFakeVolumeHost(t *testing.T, objects ...interface{}) *fakeVolumeHost {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: testNodeName, UID: "node-uid"}}
+	client := fake.NewSimpleClientset(node)
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *v1.PersistentVolume:
+			_, err = client.CoreV1().PersistentVolumes().Create(context.Background(), o, metav1.CreateOptions{})
+		case *v1.PersistentVolumeClaim:
+			_, err = client.CoreV1().PersistentVolumeClaims(o.Namespace).Create(context.Background(), o, metav1.CreateOptions{})
+		default:
+			err = fmt.Errorf("unsupported object %T", obj)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &fakeVolumeHost{rootDir: t.TempDir(), client: client}
+}
+
+func (this *fakeVolumeHost) GetPodsDir() string {
+	return filepath.Join(this.rootDir, "pods")
+}
+
+func (this *fakeVolumeHost) GetPluginDir(pluginName string) string {
+	return filepath.Join(this.rootDir, "plugins", pluginName)
+}
+
+func (this *fakeVolumeHost) GetNodeName() types.NodeName {
+	return testNodeName
+}
+
+func (this *fakeVolumeHost) GetKubeClient() kubernetes.Interface {
+	return this.client
+}
+
+func (this *fakeVolumeHost) GetNodeAllocatable() (v1.ResourceList, error) {
+	return v1.ResourceList{}, nil
+}
+
+func (this *fakeVolumeHost) GetServiceAccountToken(_, _ string, _ *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (this *fakeVolumeHost) DeleteServiceAccountToken(_ types.UID) {
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	driver := &fakeCSIDriver{
+		nodeID:     "storage-node-1",
+		maxVolumes: 16,
+		topology:   map[string]string{testZoneKey: "zone-a"},
+	}
+	endpoint := startFakeCSIDriver(t, driver)
+	host := newFakeVolumeHost(t)
+	handler := NewRegistrationHandler(NewPlugin(host))
+	t.Cleanup(func() {
+		csiDrivers.Delete(testDriverName)
+	})
+
+	if err := handler.ValidatePlugin(testDriverName, endpoint, []string{"1.5.0"}); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if err := handler.RegisterPlugin(testDriverName, endpoint, []string{"1.0.0", "1.5.0"}); err != nil {
+		t.Fatalf("failed to register plugin: %v", err)
+	}
+	if registered, ok := csiDrivers.Get(testDriverName); !ok || registered.highestSupportedVersion.String() != "1.5.0" {
+		t.Errorf("expected driver to be registered with version 1.5.0, got %+v", registered)
+	}
+
+	csiNode, err := host.client.StorageV1().CSINodes().Get(context.Background(), testNodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get CSINode: %v", err)
+	}
+	if len(csiNode.OwnerReferences) != 1 || csiNode.OwnerReferences[0].UID != "node-uid" {
+		t.Errorf("expected CSINode to be owned by the node, got %+v", csiNode.OwnerReferences)
+	}
+	if len(csiNode.Spec.Drivers) != 1 {
+		t.Fatalf("expected 1 driver in CSINode, got %+v", csiNode.Spec.Drivers)
+	}
+	installed := csiNode.Spec.Drivers[0]
+	if installed.Name != testDriverName || installed.NodeID != "storage-node-1" {
+		t.Errorf("unexpected CSINode driver %+v", installed)
+	}
+	if !reflect.DeepEqual(installed.TopologyKeys, []string{testZoneKey}) {
+		t.Errorf("unexpected topology keys %v", installed.TopologyKeys)
+	}
+	if installed.Allocatable == nil || installed.Allocatable.Count == nil || *installed.Allocatable.Count != 16 {
+		t.Errorf("expected allocatable count 16, got %+v", installed.Allocatable)
+	}
+
+	node, err := host.client.CoreV1().Nodes().Get(context.Background(), testNodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Labels[testZoneKey] != "zone-a" {
+		t.Errorf("expected topology label, got %v", node.Labels)
+	}
+	nodeIDs := map[string]string{}
+	if err := json.Unmarshal([]byte(node.Annotations[annotationKeyNodeID]), &nodeIDs); err != nil {
+		t.Fatalf("invalid node id annotation: %v", err)
+	}
+	if nodeIDs[testDriverName] != "storage-node-1" {
+		t.Errorf("unexpected node id annotation %v", nodeIDs)
+	}
+
+	handler.DeRegisterPlugin(testDriverName)
+	if _, ok := csiDrivers.Get(testDriverName); ok {
+		t.Errorf("expected driver to be removed")
+	}
+	csiNode, err = host.client.StorageV1().CSINodes().Get(context.Background(), testNodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(csiNode.Spec.Drivers) != 0 {
+		t.Errorf("expected driver to be removed from CSINode, got %+v", csiNode.Spec.Drivers)
+	}
+	node, err = host.client.CoreV1().Nodes().Get(context.Background(), testNodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.Annotations[annotationKeyNodeID]; ok {
+		t.Errorf("expected node id annotation to be removed, got %v", node.Annotations)
+	}
+	// 拓扑标签可能被其他驱动使用，保留
+	if node.Labels[testZoneKey] != "zone-a" {
+		t.Errorf("expected topology label to be kept, got %v", node.Labels)
+	}
+}
+
+func TestRegisterPluginErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		driver *fakeCSIDriver
+	}{
+		{
+			name:   "NodeGetInfo fails",
+			driver: &fakeCSIDriver{nodeID: "storage-node-1"},
+		},
+		{
+			name:   "empty node id",
+			driver: &fakeCSIDriver{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.driver.nodeID != "" {
+				tc.driver.setError("NodeGetInfo", fmt.Errorf("driver not ready"))
+			}
+			endpoint := startFakeCSIDriver(t, tc.driver)
+			host := newFakeVolumeHost(t)
+			handler := NewRegistrationHandler(NewPlugin(host))
+
+			if err := handler.RegisterPlugin(testDriverName, endpoint, []string{"1.0.0"}); err == nil {
+				t.Fatalf("expected registration to fail")
+			}
+			if _, ok := csiDrivers.Get(testDriverName); ok {
+				csiDrivers.Delete(testDriverName)
+				t.Errorf("driver should not stay registered after a failed registration")
+			}
+			_, err := host.client.StorageV1().CSINodes().Get(context.Background(), testNodeName, metav1.GetOptions{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("expected no CSINode, got err %v", err)
+			}
+		})
+	}
+}
+
+func TestValidatePlugin(t *testing.T) {
+	handler := NewRegistrationHandler(NewPlugin(newFakeVolumeHost(t)))
+	testCases := []struct {
+		versions  []string
+		expectErr bool
+	}{
+		{versions: []string{"1.0.0"}},
+		{versions: []string{"0.3.0", "1.2.0"}},
+		{versions: []string{"0.3.0"}, expectErr: true},
+		{versions: []string{"2.0.0", "invalid"}, expectErr: true},
+		{versions: nil, expectErr: true},
+	}
+	for _, tc := range testCases {
+		err := handler.ValidatePlugin(testDriverName, "/csi.sock", tc.versions)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("versions %v: expected error %v, got %v", tc.versions, tc.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sort"
+)
+
+// 节点上各驱动的nodeID，json格式的 驱动名->nodeID
+const annotationKeyNodeID = "csi.volume.kubernetes.io/nodeid"
+
+// nodeInfoManager 把驱动的节点信息发布到CSINode对象，以及Node的注解和拓扑标签
+// pkg/volume/csi/nodeinfomanager/nodeinfomanager.go
+type nodeInfoManager struct {
+	nodeName types.NodeName
+	client   kubernetes.Interface
+}
+
+func newNodeInfoManager(nodeName types.NodeName, client kubernetes.Interface) *nodeInfoManager {
+	return &nodeInfoManager{nodeName: nodeName, client: client}
+}
+
+// InstallCSIDriver 更新Node的nodeID注解和拓扑标签，并在CSINode中添加驱动
+func (this *nodeInfoManager) InstallCSIDriver(driverName string, driverNodeID string, maxAttachLimit int64,
+	topology map[string]string) error {
+	if driverNodeID == "" {
+		return fmt.Errorf("error adding CSI driver node info: driverNodeID must not be empty")
+	}
+	if err := this.updateNode(func(node *v1.Node) (bool, error) {
+		changed, err := updateNodeIDInNode(node, driverName, driverNodeID)
+		if err != nil {
+			return false, err
+		}
+		return updateTopologyLabels(node, topology) || changed, nil
+	}); err != nil {
+		return fmt.Errorf("error updating Node object with CSI driver node info: %v", err)
+	}
+
+	topologyKeys := make([]string, 0, len(topology))
+	for key := range topology {
+		topologyKeys = append(topologyKeys, key)
+	}
+	sort.Strings(topologyKeys)
+	return this.updateCSINode(func(csiNode *storagev1.CSINode) {
+		driver := storagev1.CSINodeDriver{
+			Name:         driverName,
+			NodeID:       driverNodeID,
+			TopologyKeys: topologyKeys,
+		}
+		if maxAttachLimit > 0 {
+			count := int32(maxAttachLimit)
+			driver.Allocatable = &storagev1.VolumeNodeResources{Count: &count}
+		}
+		drivers := []storagev1.CSINodeDriver{}
+		for _, d := range csiNode.Spec.Drivers {
+			if d.Name != driverName {
+				drivers = append(drivers, d)
+			}
+		}
+		csiNode.Spec.Drivers = append(drivers, driver)
+	})
+}
+
+// UninstallCSIDriver 删除Node注解和CSINode中驱动的信息，拓扑标签可能被其他驱动使用，保留
+func (this *nodeInfoManager) UninstallCSIDriver(driverName string) error {
+	if err := this.updateNode(func(node *v1.Node) (bool, error) {
+		return removeNodeIDFromNode(node, driverName)
+	}); err != nil {
+		return fmt.Errorf("error removing CSI driver node info from Node object: %v", err)
+	}
+	return this.updateCSINode(func(csiNode *storagev1.CSINode) {
+		drivers := []storagev1.CSINodeDriver{}
+		for _, d := range csiNode.Spec.Drivers {
+			if d.Name != driverName {
+				drivers = append(drivers, d)
+			}
+		}
+		csiNode.Spec.Drivers = drivers
+	})
+}
+
+// updateNode 修改Node对象，没有变化时不更新
+func (this *nodeInfoManager) updateNode(update func(node *v1.Node) (bool, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := this.client.CoreV1().Nodes().Get(context.Background(), string(this.nodeName), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		node = node.DeepCopy()
+		changed, err := update(node)
+		if err != nil || !changed {
+			return err
+		}
+		_, err = this.client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// updateCSINode 修改CSINode对象，不存在时创建，owner为本节点，节点删除后一起删除
+func (this *nodeInfoManager) updateCSINode(update func(csiNode *storagev1.CSINode)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		csiNode, err := this.client.StorageV1().CSINodes().Get(context.Background(), string(this.nodeName), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			node, err := this.client.CoreV1().Nodes().Get(context.Background(), string(this.nodeName), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			csiNode = &storagev1.CSINode{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: string(this.nodeName),
+					OwnerReferences: []metav1.OwnerReference{
+						{
+							APIVersion: "v1",
+							Kind:       "Node",
+							Name:       node.Name,
+							UID:        node.UID,
+						},
+					},
+				},
+			}
+			update(csiNode)
+			_, err = this.client.StorageV1().CSINodes().Create(context.Background(), csiNode, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		csiNode = csiNode.DeepCopy()
+		update(csiNode)
+		_, err = this.client.StorageV1().CSINodes().Update(context.Background(), csiNode, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func updateNodeIDInNode(node *v1.Node, driverName, driverNodeID string) (bool, error) {
+	nodeIDs := map[string]string{}
+	if value, ok := node.Annotations[annotationKeyNodeID]; ok {
+		if err := json.Unmarshal([]byte(value), &nodeIDs); err != nil {
+			return false, fmt.Errorf("failed to parse node annotation %q: %v", annotationKeyNodeID, err)
+		}
+	}
+	if nodeIDs[driverName] == driverNodeID {
+		return false, nil
+	}
+	nodeIDs[driverName] = driverNodeID
+	data, err := json.Marshal(nodeIDs)
+	if err != nil {
+		return false, err
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[annotationKeyNodeID] = string(data)
+	return true, nil
+}
+
+func removeNodeIDFromNode(node *v1.Node, driverName string) (bool, error) {
+	value, ok := node.Annotations[annotationKeyNodeID]
+	if !ok {
+		return false, nil
+	}
+	nodeIDs := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &nodeIDs); err != nil {
+		return false, fmt.Errorf("failed to parse node annotation %q: %v", annotationKeyNodeID, err)
+	}
+	if _, ok = nodeIDs[driverName]; !ok {
+		return false, nil
+	}
+	delete(nodeIDs, driverName)
+	if len(nodeIDs) == 0 {
+		delete(node.Annotations, annotationKeyNodeID)
+		return true, nil
+	}
+	data, err := json.Marshal(nodeIDs)
+	if err != nil {
+		return false, err
+	}
+	node.Annotations[annotationKeyNodeID] = string(data)
+	return true, nil
+}
+
+// updateTopologyLabels 把驱动上报的拓扑写到节点标签，已有不同值的标签会被覆盖
+func updateTopologyLabels(node *v1.Node, topology map[string]string) bool {
+	changed := false
+	for key, value := range topology {
+		if existing, ok := node.Labels[key]; ok && existing == value {
+			continue
+		} else if ok {
+			klog.InfoS("Overwriting node topology label reported by CSI driver", "key", key, "old", existing, "new", value)
+		}
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		node.Labels[key] = value
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,183 @@
+package volume
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"math"
+	"sort"
+	"strings"
+)
+
+const downwardAPIPluginName = "kubernetes.io/downward-api"
+
+// downwardAPIPlugin 把pod的元数据和容器的资源写成文件，labels和annotations变化后更新
+// pkg/volume/downwardapi/downwardapi.go
+type downwardAPIPlugin struct {
+	host VolumeHost
+}
+
+func (this *downwardAPIPlugin) GetPluginName() string {
+	return downwardAPIPluginName
+}
+
+func (this *downwardAPIPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.DownwardAPI != nil
+}
+
+func (this *downwardAPIPlugin) RequiresRemount() bool {
+	return true
+}
+
+func (this *downwardAPIPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	source := spec.DownwardAPI
+	return &payloadMounter{
+		path:       GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, downwardAPIPluginName, spec.Name),
+		medium:     v1.StorageMediumDefault,
+		logContext: fmt.Sprintf("downwardAPI volume %s/%s/%s", pod.Namespace, pod.Name, spec.Name),
+		makePayload: func() (map[string]FileProjection, error) {
+			return makeDownwardAPIPayload(source.Items, pod, this.host, source.DefaultMode)
+		},
+	}, nil
+}
+
+func (this *downwardAPIPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &dirUnmounter{path: GetPodVolumeDir(this.host.GetPodsDir(), podUID, downwardAPIPluginName, volumeName)}, nil
+}
+
+// makeDownwardAPIPayload 按items计算每个文件的内容
+// pkg/volume/downwardapi/downwardapi.go CollectData
+func makeDownwardAPIPayload(items []v1.DownwardAPIVolumeFile, pod *v1.Pod, host VolumeHost,
+	defaultMode *int32) (map[string]FileProjection, error) {
+	payload := make(map[string]FileProjection, len(items))
+	errs := []string{}
+	for _, item := range items {
+		var value string
+		var err error
+		switch {
+		case item.FieldRef != nil:
+			value, err = extractFieldPathAsString(pod, item.FieldRef.FieldPath)
+		case item.ResourceFieldRef != nil:
+			value, err = extractContainerResourceValue(item.ResourceFieldRef, pod, host)
+		default:
+			err = fmt.Errorf("item %q must set fieldRef or resourceFieldRef", item.Path)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		payload[item.Path] = FileProjection{Data: []byte(value), Mode: fileMode(item.Mode, defaultMode)}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("error collecting downwardAPI data: %s", strings.Join(errs, "; "))
+	}
+	return payload, nil
+}
+
+// extractFieldPathAsString 卷中只支持metadata的字段
+// pkg/fieldpath/fieldpath.go ExtractFieldPathAsString
+func extractFieldPathAsString(pod *v1.Pod, fieldPath string) (string, error) {
+	if path, subscript, ok := splitMaybeSubscriptedPath(fieldPath); ok {
+		switch path {
+		case "metadata.annotations":
+			return pod.Annotations[subscript], nil
+		case "metadata.labels":
+			return pod.Labels[subscript], nil
+		}
+		return "", fmt.Errorf("fieldPath %q does not support subscript", fieldPath)
+	}
+	switch fieldPath {
+	case "metadata.annotations":
+		return formatMap(pod.Annotations), nil
+	case "metadata.labels":
+		return formatMap(pod.Labels), nil
+	case "metadata.name":
+		return pod.Name, nil
+	case "metadata.namespace":
+		return pod.Namespace, nil
+	case "metadata.uid":
+		return string(pod.UID), nil
+	}
+	return "", fmt.Errorf("unsupported fieldPath: %v", fieldPath)
+}
+
+// splitMaybeSubscriptedPath 拆分 metadata.labels['key'] 形式的路径
+func splitMaybeSubscriptedPath(fieldPath string) (string, string, bool) {
+	if !strings.HasSuffix(fieldPath, "']") {
+		return fieldPath, "", false
+	}
+	s := strings.TrimSuffix(fieldPath, "']")
+	parts := strings.SplitN(s, "['", 2)
+	if len(parts) < 2 || len(parts[0]) == 0 {
+		return fieldPath, "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// formatMap 按key排序，每行一个 key="value"
+func formatMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%v=%q", key, m[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// extractContainerResourceValue 容器的requests或limits除以divisor后向上取整，没有设置limits时使用节点的可分配资源
+// pkg/api/v1/resource/helpers.go ExtractContainerResourceValue
+func extractContainerResourceValue(fs *v1.ResourceFieldSelector, pod *v1.Pod, host VolumeHost) (string, error) {
+	var c *v1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == fs.ContainerName {
+			c = &pod.Spec.Containers[i]
+		}
+	}
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == fs.ContainerName {
+			c = &pod.Spec.InitContainers[i]
+		}
+	}
+	if c == nil {
+		return "", fmt.Errorf("container %q not found in pod %s/%s", fs.ContainerName, pod.Namespace, pod.Name)
+	}
+
+	divisor := resource.MustParse("1")
+	if !fs.Divisor.IsZero() {
+		divisor = fs.Divisor
+	}
+	source, name, ok := strings.Cut(fs.Resource, ".")
+	if !ok || (source != "limits" && source != "requests") {
+		return "", fmt.Errorf("unsupported container resource: %v", fs.Resource)
+	}
+	resourceName := v1.ResourceName(name)
+	switch resourceName {
+	case v1.ResourceCPU, v1.ResourceMemory, v1.ResourceEphemeralStorage:
+	default:
+		return "", fmt.Errorf("unsupported container resource: %v", fs.Resource)
+	}
+
+	var quantity resource.Quantity
+	if source == "requests" {
+		quantity = c.Resources.Requests[resourceName]
+	} else {
+		var found bool
+		if quantity, found = c.Resources.Limits[resourceName]; !found {
+			allocatable, err := host.GetNodeAllocatable()
+			if err != nil {
+				return "", err
+			}
+			quantity = allocatable[resourceName]
+		}
+	}
+
+	if resourceName == v1.ResourceCPU {
+		return fmt.Sprintf("%d", int64(math.Ceil(float64(quantity.MilliValue())/float64(divisor.MilliValue())))), nil
+	}
+	return fmt.Sprintf("%d", int64(math.Ceil(float64(quantity.Value())/float64(divisor.Value())))), nil
+}
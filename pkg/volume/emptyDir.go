@@ -0,0 +1,75 @@
+package volume
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const emptyDirPluginName = "kubernetes.io/empty-dir"
+
+// emptyDirPlugin pod生命周期内存在的临时目录，medium为Memory时使用tmpfs
+// pkg/volume/emptydir/empty_dir.go
+type emptyDirPlugin struct {
+	host VolumeHost
+}
+
+func (this *emptyDirPlugin) GetPluginName() string {
+	return emptyDirPluginName
+}
+
+func (this *emptyDirPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.EmptyDir != nil
+}
+
+func (this *emptyDirPlugin) RequiresRemount() bool {
+	return false
+}
+
+func (this *emptyDirPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	medium := spec.EmptyDir.Medium
+	if medium != v1.StorageMediumDefault && medium != v1.StorageMediumMemory {
+		return nil, fmt.Errorf("emptyDir medium %q is not supported", medium)
+	}
+	return &emptyDirMounter{
+		path:      GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, emptyDirPluginName, spec.Name),
+		medium:    medium,
+		sizeLimit: spec.EmptyDir.SizeLimit,
+	}, nil
+}
+
+func (this *emptyDirPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &dirUnmounter{path: GetPodVolumeDir(this.host.GetPodsDir(), podUID, emptyDirPluginName, volumeName)}, nil
+}
+
+type emptyDirMounter struct {
+	path      string
+	medium    v1.StorageMedium
+	sizeLimit *resource.Quantity
+}
+
+func (this *emptyDirMounter) GetPath() string {
+	return this.path
+}
+
+func (this *emptyDirMounter) GetAttributes() Attributes {
+	return Attributes{ReadOnly: false}
+}
+
+func (this *emptyDirMounter) SetUp() error {
+	return setUpDir(this.path, this.medium, this.sizeLimit)
+}
+
+// dirUnmounter 卸载并删除kubelet在pod目录下创建的卷目录
+type dirUnmounter struct {
+	path string
+}
+
+func (this *dirUnmounter) GetPath() string {
+	return this.path
+}
+
+func (this *dirUnmounter) TearDown() error {
+	return tearDownDir(this.path)
+}
@@ -0,0 +1,124 @@
+package volume
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+)
+
+const hostPathPluginName = "kubernetes.io/host-path"
+
+// hostPathPlugin 直接挂载宿主机上的路径，SetUp时按type检查路径
+// pkg/volume/hostpath/host_path.go
+type hostPathPlugin struct{}
+
+func (this *hostPathPlugin) GetPluginName() string {
+	return hostPathPluginName
+}
+
+func (this *hostPathPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.HostPath != nil
+}
+
+func (this *hostPathPlugin) RequiresRemount() bool {
+	return false
+}
+
+func (this *hostPathPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	pathType := v1.HostPathUnset
+	if spec.HostPath.Type != nil {
+		pathType = *spec.HostPath.Type
+	}
+	return &hostPathMounter{path: spec.HostPath.Path, pathType: pathType}, nil
+}
+
+// NewUnmounter 宿主机上的路径不属于kubelet，卸载时不做任何处理
+func (this *hostPathPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &hostPathUnmounter{}, nil
+}
+
+type hostPathMounter struct {
+	path     string
+	pathType v1.HostPathType
+}
+
+func (this *hostPathMounter) GetPath() string {
+	return this.path
+}
+
+func (this *hostPathMounter) GetAttributes() Attributes {
+	return Attributes{ReadOnly: false}
+}
+
+func (this *hostPathMounter) SetUp() error {
+	return checkHostPathType(this.path, this.pathType)
+}
+
+type hostPathUnmounter struct{}
+
+func (this *hostPathUnmounter) GetPath() string {
+	return ""
+}
+
+func (this *hostPathUnmounter) TearDown() error {
+	return nil
+}
+
+// checkHostPathType 检查路径是否符合type，*OrCreate类型在路径不存在时创建
+// pkg/volume/hostpath/host_path.go checkType
+func checkHostPathType(path string, pathType v1.HostPathType) error {
+	if pathType == v1.HostPathUnset {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("hostPath type check failed: %v", err)
+	}
+	exists := err == nil
+
+	switch pathType {
+	case v1.HostPathDirectoryOrCreate:
+		if !exists {
+			return os.MkdirAll(path, 0755)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("hostPath type check failed: %s is not a directory", path)
+		}
+	case v1.HostPathDirectory:
+		if !exists || !info.IsDir() {
+			return fmt.Errorf("hostPath type check failed: %s is not a directory", path)
+		}
+	case v1.HostPathFileOrCreate:
+		if !exists {
+			// 只创建文件，父目录必须已经存在
+			f, err := os.OpenFile(path, os.O_CREATE, 0644)
+			if err != nil {
+				return fmt.Errorf("hostPath type check failed: %v", err)
+			}
+			return f.Close()
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("hostPath type check failed: %s is not a file", path)
+		}
+	case v1.HostPathFile:
+		if !exists || !info.Mode().IsRegular() {
+			return fmt.Errorf("hostPath type check failed: %s is not a file", path)
+		}
+	case v1.HostPathSocket:
+		if !exists || info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("hostPath type check failed: %s is not a socket file", path)
+		}
+	case v1.HostPathCharDev:
+		if !exists || info.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("hostPath type check failed: %s is not a character device", path)
+		}
+	case v1.HostPathBlockDev:
+		if !exists || info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("hostPath type check failed: %s is not a block device", path)
+		}
+	default:
+		return fmt.Errorf("hostPath type %q is not supported", pathType)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package volume
+
+import (
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"path/filepath"
+	"strings"
+)
+
+// VolumeHost 卷插件需要的kubelet功能
+// pkg/volume/plugins.go
+type VolumeHost interface {
+	// GetPodsDir pod目录的根目录，一般为<rootDirectory>/pods
+	GetPodsDir() string
+	// GetPluginDir 插件自己的目录，一般为<rootDirectory>/plugins/<插件名>
+	GetPluginDir(pluginName string) string
+	GetNodeName() types.NodeName
+	GetKubeClient() kubernetes.Interface
+	// GetNodeAllocatable 节点的可分配资源，容器没有设置limits时downwardAPI使用它
+	GetNodeAllocatable() (v1.ResourceList, error)
+	// GetServiceAccountToken 通过TokenRequest获取service account的token，带缓存和自动刷新
+	GetServiceAccountToken(namespace, name string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error)
+	// DeleteServiceAccountToken 删除绑定到pod的token缓存
+	DeleteServiceAccountToken(podUID types.UID)
+}
+
+// Attributes 卷挂载到容器时的属性
+type Attributes struct {
+	// 为true时总是只读挂载，如configMap和secret
+	ReadOnly bool
+}
+
+// Mounter 在宿主机上准备卷的目录
+type Mounter interface {
+	// GetPath 卷在宿主机上的路径，创建容器时挂载这个路径
+	GetPath() string
+	GetAttributes() Attributes
+	// SetUp 创建卷或者更新卷的内容，需要可以重复调用
+	SetUp() error
+}
+
+// UniqueVolumeNamer 需要挂接到节点的卷，挂载后名字上报到node.status.volumesInUse
+type UniqueVolumeNamer interface {
+	GetUniqueVolumeName() v1.UniqueVolumeName
+}
+
+// Unmounter 清理宿主机上卷的目录
+type Unmounter interface {
+	GetPath() string
+	TearDown() error
+}
+
+// VolumePlugin 一种卷类型的实现
+type VolumePlugin interface {
+	// GetPluginName 插件名，如kubernetes.io/empty-dir
+	GetPluginName() string
+	CanSupport(spec *v1.Volume) bool
+	// RequiresRemount 挂载后是否需要定期重新SetUp，以同步configMap、secret等对象的变化
+	RequiresRemount() bool
+	NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error)
+	NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error)
+}
+
+// VolumePluginMgr 按卷的spec或插件名查找插件
+type VolumePluginMgr struct {
+	plugins []VolumePlugin
+}
+
+// NewVolumePluginMgr 创建插件管理器，注册所有内置插件和额外的插件，如csi
+func NewVolumePluginMgr(host VolumeHost, plugins ...VolumePlugin) *VolumePluginMgr {
+	return &VolumePluginMgr{
+		plugins: append([]VolumePlugin{
+			&emptyDirPlugin{host: host},
+			&hostPathPlugin{},
+			&configMapPlugin{host: host},
+			&secretPlugin{host: host},
+			&downwardAPIPlugin{host: host},
+			&projectedPlugin{host: host},
+		}, plugins...),
+	}
+}
+
+// FindPluginBySpec 支持该卷的插件，必须有且只有一个
+func (this *VolumePluginMgr) FindPluginBySpec(spec *v1.Volume) (VolumePlugin, error) {
+	matches := []VolumePlugin{}
+	for _, plugin := range this.plugins {
+		if plugin.CanSupport(spec) {
+			matches = append(matches, plugin)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no volume plugin matched volume %q", spec.Name)
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("multiple volume plugins matched volume %q", spec.Name)
+	}
+	return matches[0], nil
+}
+
+// FindPluginByName 按插件名查找插件
+func (this *VolumePluginMgr) FindPluginByName(name string) (VolumePlugin, error) {
+	for _, plugin := range this.plugins {
+		if plugin.GetPluginName() == name {
+			return plugin, nil
+		}
+	}
+	return nil, fmt.Errorf("no volume plugin matched name %q", name)
+}
+
+// GetPodVolumesDir pod所有卷的目录 <podsDir>/<podUID>/volumes
+func GetPodVolumesDir(podsDir string, podUID types.UID) string {
+	return filepath.Join(podsDir, string(podUID), "volumes")
+}
+
+// GetPodVolumeDir 卷的目录 <podsDir>/<podUID>/volumes/<转义后的插件名>/<卷名>
+func GetPodVolumeDir(podsDir string, podUID types.UID, pluginName, volumeName string) string {
+	return filepath.Join(GetPodVolumesDir(podsDir, podUID), EscapePluginName(pluginName), volumeName)
+}
+
+// EscapePluginName 插件名中的/替换为~，作为目录名
+func EscapePluginName(name string) string {
+	return strings.ReplaceAll(name, "/", "~")
+}
+
+// UnescapePluginName 从目录名还原插件名
+func UnescapePluginName(name string) string {
+	return strings.ReplaceAll(name, "~", "/")
+}
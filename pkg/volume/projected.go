@@ -0,0 +1,151 @@
+package volume
+
+import (
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+const (
+	projectedPluginName = "kubernetes.io/projected"
+	// 没有设置expirationSeconds时token的有效期，和apiServer的默认值一致
+	defaultTokenExpirationSeconds int64 = 60 * 60
+)
+
+// projectedPlugin 把多个secret、configMap、downwardAPI和service account token投射到同一个目录，有权限时写在tmpfs上
+// kube-api-access-*卷由token、kube-root-ca.crt中的ca.crt和downwardAPI的namespace组成，集群内的客户端依赖它们
+// pkg/volume/projected/projected.go
+type projectedPlugin struct {
+	host VolumeHost
+}
+
+func (this *projectedPlugin) GetPluginName() string {
+	return projectedPluginName
+}
+
+func (this *projectedPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.Projected != nil
+}
+
+func (this *projectedPlugin) RequiresRemount() bool {
+	return true
+}
+
+func (this *projectedPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	source := spec.Projected
+	return &payloadMounter{
+		path:       GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, projectedPluginName, spec.Name),
+		medium:     v1.StorageMediumMemory,
+		logContext: fmt.Sprintf("projected volume %s/%s/%s", pod.Namespace, pod.Name, spec.Name),
+		makePayload: func() (map[string]FileProjection, error) {
+			return this.collectData(source, pod)
+		},
+	}, nil
+}
+
+func (this *projectedPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &projectedUnmounter{
+		dirUnmounter: dirUnmounter{path: GetPodVolumeDir(this.host.GetPodsDir(), podUID, projectedPluginName, volumeName)},
+		host:         this.host,
+		podUID:       podUID,
+	}, nil
+}
+
+// projectedUnmounter 卸载时同时删除绑定到pod的token缓存
+type projectedUnmounter struct {
+	dirUnmounter
+	host   VolumeHost
+	podUID types.UID
+}
+
+func (this *projectedUnmounter) TearDown() error {
+	if err := this.dirUnmounter.TearDown(); err != nil {
+		return err
+	}
+	this.host.DeleteServiceAccountToken(this.podUID)
+	return nil
+}
+
+// collectData 依次收集每个来源的文件，后面的来源覆盖前面的同名文件
+// pkg/volume/projected/projected.go collectData
+func (this *projectedPlugin) collectData(source *v1.ProjectedVolumeSource, pod *v1.Pod) (map[string]FileProjection, error) {
+	defaultMode := source.DefaultMode
+	if defaultMode == nil {
+		mode := v1.ProjectedVolumeSourceDefaultMode
+		defaultMode = &mode
+	}
+
+	payload := map[string]FileProjection{}
+	errs := []string{}
+	for _, s := range source.Sources {
+		var projection map[string]FileProjection
+		var err error
+		switch {
+		case s.Secret != nil:
+			optional := s.Secret.Optional != nil && *s.Secret.Optional
+			var secret *v1.Secret
+			if secret, err = getSecret(this.host, pod.Namespace, s.Secret.Name, optional); err == nil {
+				projection, err = makeSecretPayload(s.Secret.Items, secret, defaultMode, optional)
+			}
+		case s.ConfigMap != nil:
+			optional := s.ConfigMap.Optional != nil && *s.ConfigMap.Optional
+			var configMap *v1.ConfigMap
+			if configMap, err = getConfigMap(this.host, pod.Namespace, s.ConfigMap.Name, optional); err == nil {
+				projection, err = makeConfigMapPayload(s.ConfigMap.Items, configMap, defaultMode, optional)
+			}
+		case s.DownwardAPI != nil:
+			projection, err = makeDownwardAPIPayload(s.DownwardAPI.Items, pod, this.host, defaultMode)
+		case s.ServiceAccountToken != nil:
+			projection, err = this.makeServiceAccountTokenPayload(s.ServiceAccountToken, pod, *defaultMode)
+		default:
+			klog.InfoS("Unsupported projected volume source, skipping", "pod", klog.KObj(pod))
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for p, content := range projection {
+			payload[p] = content
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("error preparing data for projected volume: %s", strings.Join(errs, "; "))
+	}
+	return payload, nil
+}
+
+// makeServiceAccountTokenPayload 通过TokenRequest获取绑定到pod的token
+// audience为空时使用apiServer的默认audience
+func (this *projectedPlugin) makeServiceAccountTokenPayload(source *v1.ServiceAccountTokenProjection, pod *v1.Pod,
+	mode int32) (map[string]FileProjection, error) {
+	var audiences []string
+	if source.Audience != "" {
+		audiences = []string{source.Audience}
+	}
+	expirationSeconds := source.ExpirationSeconds
+	if expirationSeconds == nil {
+		defaultExpirationSeconds := defaultTokenExpirationSeconds
+		expirationSeconds = &defaultExpirationSeconds
+	}
+	tr, err := this.host.GetServiceAccountToken(pod.Namespace, pod.Spec.ServiceAccountName, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]FileProjection{
+		source.Path: {Data: []byte(tr.Status.Token), Mode: mode},
+	}, nil
+}
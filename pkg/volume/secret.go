@@ -0,0 +1,87 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const secretPluginName = "kubernetes.io/secret"
+
+// secretPlugin 把secret的数据写成文件，有权限时写在tmpfs上，不落盘
+// pkg/volume/secret/secret.go
+type secretPlugin struct {
+	host VolumeHost
+}
+
+func (this *secretPlugin) GetPluginName() string {
+	return secretPluginName
+}
+
+func (this *secretPlugin) CanSupport(spec *v1.Volume) bool {
+	return spec.Secret != nil
+}
+
+func (this *secretPlugin) RequiresRemount() bool {
+	return true
+}
+
+func (this *secretPlugin) NewMounter(spec *v1.Volume, pod *v1.Pod) (Mounter, error) {
+	source := spec.Secret
+	optional := source.Optional != nil && *source.Optional
+	return &payloadMounter{
+		path:       GetPodVolumeDir(this.host.GetPodsDir(), pod.UID, secretPluginName, spec.Name),
+		medium:     v1.StorageMediumMemory,
+		logContext: fmt.Sprintf("secret volume %s/%s/%s", pod.Namespace, pod.Name, spec.Name),
+		makePayload: func() (map[string]FileProjection, error) {
+			secret, err := getSecret(this.host, pod.Namespace, source.SecretName, optional)
+			if err != nil {
+				return nil, err
+			}
+			return makeSecretPayload(source.Items, secret, source.DefaultMode, optional)
+		},
+	}, nil
+}
+
+func (this *secretPlugin) NewUnmounter(volumeName string, podUID types.UID) (Unmounter, error) {
+	return &dirUnmounter{path: GetPodVolumeDir(this.host.GetPodsDir(), podUID, secretPluginName, volumeName)}, nil
+}
+
+// getSecret optional的secret不存在时返回空的secret
+func getSecret(host VolumeHost, namespace, name string, optional bool) (*v1.Secret, error) {
+	secret, err := host.GetKubeClient().CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+		}
+		return nil, fmt.Errorf("couldn't get secret %s/%s: %v", namespace, name, err)
+	}
+	return secret, nil
+}
+
+// makeSecretPayload 没有指定items时写入所有key，否则只写入items中的key
+// pkg/volume/secret/secret.go MakePayload
+func makeSecretPayload(items []v1.KeyToPath, secret *v1.Secret, defaultMode *int32,
+	optional bool) (map[string]FileProjection, error) {
+	payload := make(map[string]FileProjection, len(secret.Data))
+	if len(items) == 0 {
+		for name, data := range secret.Data {
+			payload[name] = FileProjection{Data: data, Mode: fileMode(nil, defaultMode)}
+		}
+		return payload, nil
+	}
+	for _, item := range items {
+		data, ok := secret.Data[item.Key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("references non-existent secret key: %s", item.Key)
+		}
+		payload[item.Path] = FileProjection{Data: data, Mode: fileMode(item.Mode, defaultMode)}
+	}
+	return payload, nil
+}
@@ -0,0 +1,132 @@
+package volume
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// 卷目录的权限，容器中的任意用户都可以写
+const volumeDirPerm os.FileMode = 0777
+
+// tmpfsPermitted 挂载tmpfs需要root权限，没有权限时内存卷退化为磁盘目录
+func tmpfsPermitted() bool {
+	return os.Geteuid() == 0
+}
+
+// setUpDir 创建卷的目录，medium为Memory且有权限时挂载tmpfs
+// 目录已经是挂载点时说明之前已经挂载过，不再重复挂载
+// pkg/volume/emptydir/empty_dir.go setupDir setupTmpfs
+func setUpDir(dir string, medium v1.StorageMedium, sizeLimit *resource.Quantity) error {
+	if err := os.MkdirAll(dir, volumeDirPerm); err != nil {
+		return err
+	}
+	// 创建时受umask影响，重新设置权限
+	if err := os.Chmod(dir, volumeDirPerm); err != nil {
+		return err
+	}
+	if medium != v1.StorageMediumMemory {
+		return nil
+	}
+	if !tmpfsPermitted() {
+		klog.InfoS("Not permitted to mount tmpfs, using a directory on disk for memory backed volume", "path", dir)
+		return nil
+	}
+	notMnt, err := isLikelyNotMountPoint(dir)
+	if err != nil {
+		return err
+	}
+	if !notMnt {
+		return nil
+	}
+	options := ""
+	if sizeLimit != nil && sizeLimit.Value() > 0 {
+		options = fmt.Sprintf("size=%d", sizeLimit.Value())
+	}
+	klog.V(3).InfoS("Mounting tmpfs for volume", "path", dir, "options", options)
+	if err = syscall.Mount("tmpfs", dir, "tmpfs", 0, options); err != nil {
+		return fmt.Errorf("failed to mount tmpfs at %q: %v", dir, err)
+	}
+	return os.Chmod(dir, volumeDirPerm)
+}
+
+// tearDownDir 卸载目录上的tmpfs并删除目录
+func tearDownDir(dir string) error {
+	notMnt, err := isLikelyNotMountPoint(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !notMnt {
+		if err = syscall.Unmount(dir, 0); err != nil {
+			return fmt.Errorf("failed to unmount %q: %v", dir, err)
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// isLikelyNotMountPoint 目录和父目录在同一个设备上时认为不是挂载点，无法识别bind mount
+// staging/src/k8s.io/mount-utils/mount_linux.go IsLikelyNotMountPoint
+func isLikelyNotMountPoint(path string) (bool, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return true, err
+	}
+	rootStat, err := os.Stat(filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return true, err
+	}
+	return stat.Sys().(*syscall.Stat_t).Dev == rootStat.Sys().(*syscall.Stat_t).Dev, nil
+}
+
+// payloadMounter configMap、secret、downwardAPI和projected卷的公共实现
+// 每次SetUp重新计算文件内容，通过AtomicWriter原子地更新到卷目录
+type payloadMounter struct {
+	path   string
+	medium v1.StorageMedium
+	// 日志中标识卷，如configMap卷 <namespace>/<pod>/<卷名>
+	logContext  string
+	makePayload func() (map[string]FileProjection, error)
+}
+
+func (this *payloadMounter) GetPath() string {
+	return this.path
+}
+
+// GetAttributes 内容由kubelet维护，容器中只读
+func (this *payloadMounter) GetAttributes() Attributes {
+	return Attributes{ReadOnly: true}
+}
+
+func (this *payloadMounter) SetUp() error {
+	// 先获取内容，对象不存在时不创建目录
+	payload, err := this.makePayload()
+	if err != nil {
+		return err
+	}
+	if err = setUpDir(this.path, this.medium, nil); err != nil {
+		return err
+	}
+	writer, err := NewAtomicWriter(this.path, this.logContext)
+	if err != nil {
+		return err
+	}
+	return writer.Write(payload)
+}
+
+// 没有设置mode时使用的默认权限
+func fileMode(mode, defaultMode *int32) int32 {
+	if mode != nil {
+		return *mode
+	}
+	if defaultMode != nil {
+		return *defaultMode
+	}
+	return v1.ConfigMapVolumeSourceDefaultMode
+}
@@ -0,0 +1,135 @@
+package volumemanager
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sync"
+	"time"
+)
+
+// desiredStateOfWorld 需要挂载卷的pod，保存最新的pod对象，downwardAPI卷根据它更新
+// pkg/kubelet/volumemanager/cache/desired_state_of_world.go
+type desiredStateOfWorld struct {
+	lock sync.RWMutex
+	pods map[types.UID]*v1.Pod
+}
+
+func newDesiredStateOfWorld() *desiredStateOfWorld {
+	return &desiredStateOfWorld{pods: map[types.UID]*v1.Pod{}}
+}
+
+func (this *desiredStateOfWorld) AddPod(pod *v1.Pod) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.pods[pod.UID] = pod
+}
+
+func (this *desiredStateOfWorld) DeletePod(uid types.UID) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.pods, uid)
+}
+
+func (this *desiredStateOfWorld) GetPods() []*v1.Pod {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := make([]*v1.Pod, 0, len(this.pods))
+	for _, pod := range this.pods {
+		ret = append(ret, pod)
+	}
+	return ret
+}
+
+// VolumeExists pod需要挂载该卷
+func (this *desiredStateOfWorld) VolumeExists(uid types.UID, volumeName string) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	pod, ok := this.pods[uid]
+	if !ok {
+		return false
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == volumeName {
+			return true
+		}
+	}
+	return false
+}
+
+// mountedVolume 已经挂载的卷
+type mountedVolume struct {
+	podUID     types.UID
+	volumeName string
+	pluginName string
+	// 卷在宿主机上的路径
+	path     string
+	readOnly bool
+	// 需要attach的卷的唯一名字，上报到节点的volumesInUse，不需要attach的卷为空
+	uniqueName v1.UniqueVolumeName
+	// 最后一次SetUp成功的时间，为零表示kubelet重启后从磁盘上重建的卷，需要按spec重新SetUp
+	lastSetUp time.Time
+}
+
+// actualStateOfWorld 已经挂载的卷
+// pkg/kubelet/volumemanager/cache/actual_state_of_world.go
+type actualStateOfWorld struct {
+	lock    sync.RWMutex
+	volumes map[types.UID]map[string]mountedVolume
+}
+
+func newActualStateOfWorld() *actualStateOfWorld {
+	return &actualStateOfWorld{volumes: map[types.UID]map[string]mountedVolume{}}
+}
+
+func (this *actualStateOfWorld) MarkVolumeAsMounted(volume mountedVolume) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.volumes[volume.podUID]; !ok {
+		this.volumes[volume.podUID] = map[string]mountedVolume{}
+	}
+	this.volumes[volume.podUID][volume.volumeName] = volume
+}
+
+func (this *actualStateOfWorld) MarkVolumeAsUnmounted(uid types.UID, volumeName string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.volumes[uid], volumeName)
+	if len(this.volumes[uid]) == 0 {
+		delete(this.volumes, uid)
+	}
+}
+
+func (this *actualStateOfWorld) GetVolume(uid types.UID, volumeName string) (mountedVolume, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	volume, ok := this.volumes[uid][volumeName]
+	return volume, ok
+}
+
+func (this *actualStateOfWorld) GetMountedVolumes() []mountedVolume {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := []mountedVolume{}
+	for _, volumes := range this.volumes {
+		for _, volume := range volumes {
+			ret = append(ret, volume)
+		}
+	}
+	return ret
+}
+
+func (this *actualStateOfWorld) GetMountedVolumesForPod(uid types.UID) []mountedVolume {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ret := []mountedVolume{}
+	for _, volume := range this.volumes[uid] {
+		ret = append(ret, volume)
+	}
+	return ret
+}
+
+func (this *actualStateOfWorld) PodHasMountedVolumes(uid types.UID) bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.volumes[uid]) > 0
+}
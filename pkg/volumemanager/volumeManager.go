@@ -0,0 +1,316 @@
+package volumemanager
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"mykubelet/pkg/events"
+	"mykubelet/pkg/volume"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// 根据pod列表更新期望状态的间隔
+	desiredStateOfWorldPopulatorLoopSleepPeriod = 100 * time.Millisecond
+	// 挂载和卸载卷的间隔
+	reconcilerLoopSleepPeriod = 100 * time.Millisecond
+	// configMap、secret等卷重新获取内容的间隔
+	remountPeriod = time.Minute
+
+	// 等待pod的卷挂载完成的超时时间和检查间隔
+	podAttachAndMountTimeout       = 2*time.Minute + 3*time.Second
+	podAttachAndMountRetryInterval = 300 * time.Millisecond
+
+	// 挂载失败的退避时间
+	mountBackOffPeriod    = 500 * time.Millisecond
+	maxMountBackOffPeriod = 2*time.Minute + 2*time.Second
+)
+
+// VolumeInfo 挂载到容器时需要的卷信息
+type VolumeInfo struct {
+	// 卷在宿主机上的路径
+	Path     string
+	ReadOnly bool
+}
+
+// VolumeManager 根据pod计算需要挂载的卷，在pods目录下准备卷的目录，pod终止后卸载
+// pkg/kubelet/volumemanager/volume_manager.go
+type VolumeManager struct {
+	pluginMgr *volume.VolumePluginMgr
+	podsDir   string
+	// 需要挂载卷的pod，已经终止的pod不在其中
+	podsFunc func() []*v1.Pod
+	recorder record.EventRecorder
+	clock    clock.Clock
+
+	desiredStateOfWorld *desiredStateOfWorld
+	actualStateOfWorld  *actualStateOfWorld
+	// 每个卷的挂载失败退避
+	backOff *flowcontrol.Backoff
+}
+
+// NewVolumeManager 创建卷管理器
+func NewVolumeManager(pluginMgr *volume.VolumePluginMgr, podsDir string, podsFunc func() []*v1.Pod,
+	recorder record.EventRecorder, clock clock.Clock) *VolumeManager {
+	backOff := flowcontrol.NewBackOff(mountBackOffPeriod, maxMountBackOffPeriod)
+	backOff.Clock = clock
+	return &VolumeManager{
+		pluginMgr:           pluginMgr,
+		podsDir:             podsDir,
+		podsFunc:            podsFunc,
+		recorder:            recorder,
+		clock:               clock,
+		desiredStateOfWorld: newDesiredStateOfWorld(),
+		actualStateOfWorld:  newActualStateOfWorld(),
+		backOff:             backOff,
+	}
+}
+
+// Run 从磁盘上重建已经挂载的卷，然后启动期望状态的更新和reconciler
+// sourcesReady之前不知道哪些pod已经删除，不卸载任何卷
+func (this *VolumeManager) Run(sourcesReady func() bool, stopCh <-chan struct{}) {
+	this.reconstructVolumes()
+	go wait.Until(this.populate, desiredStateOfWorldPopulatorLoopSleepPeriod, stopCh)
+	go wait.Until(func() {
+		if sourcesReady() {
+			this.unmountVolumes()
+		}
+		this.mountVolumes()
+	}, reconcilerLoopSleepPeriod, stopCh)
+}
+
+// populate 用最新的pod列表替换期望状态
+// pkg/kubelet/volumemanager/populator/desired_state_of_world_populator.go
+func (this *VolumeManager) populate() {
+	pods := this.podsFunc()
+	desired := map[types.UID]bool{}
+	for _, pod := range pods {
+		desired[pod.UID] = true
+		this.desiredStateOfWorld.AddPod(pod)
+	}
+	for _, pod := range this.desiredStateOfWorld.GetPods() {
+		if !desired[pod.UID] {
+			this.desiredStateOfWorld.DeletePod(pod.UID)
+		}
+	}
+}
+
+// WaitForAttachAndMount 等待pod的所有卷挂载完成，超时后返回没有挂载的卷
+func (this *VolumeManager) WaitForAttachAndMount(ctx context.Context, pod *v1.Pod) error {
+	if len(pod.Spec.Volumes) == 0 {
+		return nil
+	}
+	// 不等populator，立即加入期望状态
+	this.desiredStateOfWorld.AddPod(pod)
+
+	err := wait.PollUntilContextTimeout(ctx, podAttachAndMountRetryInterval, podAttachAndMountTimeout, true,
+		func(context.Context) (bool, error) {
+			return len(this.getUnmountedVolumes(pod)) == 0, nil
+		})
+	if err != nil {
+		unmounted := this.getUnmountedVolumes(pod)
+		if len(unmounted) == 0 {
+			return nil
+		}
+		return fmt.Errorf("unmounted volumes=%v: %v", unmounted, err)
+	}
+	klog.V(3).InfoS("All volumes are attached and mounted for pod", "pod", klog.KObj(pod))
+	return nil
+}
+
+func (this *VolumeManager) getUnmountedVolumes(pod *v1.Pod) []string {
+	unmounted := []string{}
+	for _, v := range pod.Spec.Volumes {
+		mounted, ok := this.actualStateOfWorld.GetVolume(pod.UID, v.Name)
+		if !ok || mounted.lastSetUp.IsZero() {
+			unmounted = append(unmounted, v.Name)
+		}
+	}
+	sort.Strings(unmounted)
+	return unmounted
+}
+
+// GetMountedVolumesForPod pod已经挂载的卷，key为卷名
+func (this *VolumeManager) GetMountedVolumesForPod(uid types.UID) map[string]VolumeInfo {
+	ret := map[string]VolumeInfo{}
+	for _, mounted := range this.actualStateOfWorld.GetMountedVolumesForPod(uid) {
+		ret[mounted.volumeName] = VolumeInfo{Path: mounted.path, ReadOnly: mounted.readOnly}
+	}
+	return ret
+}
+
+// PodHasMountedVolumes pod还有没有卸载的卷，卸载完成前不能删除pod目录
+func (this *VolumeManager) PodHasMountedVolumes(uid types.UID) bool {
+	return this.actualStateOfWorld.PodHasMountedVolumes(uid)
+}
+
+// GetVolumesInUse 已经挂载的需要attach的卷，attach/detach controller不会detach这些卷
+func (this *VolumeManager) GetVolumesInUse() []v1.UniqueVolumeName {
+	seen := map[v1.UniqueVolumeName]bool{}
+	ret := []v1.UniqueVolumeName{}
+	for _, mounted := range this.actualStateOfWorld.GetMountedVolumes() {
+		if mounted.uniqueName == "" || seen[mounted.uniqueName] {
+			continue
+		}
+		seen[mounted.uniqueName] = true
+		ret = append(ret, mounted.uniqueName)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// mountVolumes 挂载期望状态中还没有挂载的卷，需要重新挂载的卷到期后重新SetUp
+// pkg/kubelet/volumemanager/reconciler/reconciler.go mountOrAttachVolumes
+func (this *VolumeManager) mountVolumes() {
+	now := this.clock.Now()
+	for _, pod := range this.desiredStateOfWorld.GetPods() {
+		for i := range pod.Spec.Volumes {
+			spec := &pod.Spec.Volumes[i]
+			backOffKey := string(pod.UID) + "/" + spec.Name
+			if this.backOff.IsInBackOffSinceUpdate(backOffKey, now) {
+				continue
+			}
+			plugin, err := this.pluginMgr.FindPluginBySpec(spec)
+			if err != nil {
+				this.backOff.Next(backOffKey, now)
+				this.mountFailed(pod, spec.Name, err)
+				continue
+			}
+			mounted, ok := this.actualStateOfWorld.GetVolume(pod.UID, spec.Name)
+			if ok && !mounted.lastSetUp.IsZero() {
+				if !plugin.RequiresRemount() || now.Sub(mounted.lastSetUp) < remountPeriod {
+					continue
+				}
+			}
+
+			mounter, err := plugin.NewMounter(spec, pod)
+			if err == nil {
+				err = mounter.SetUp()
+			}
+			if err != nil {
+				this.backOff.Next(backOffKey, now)
+				this.mountFailed(pod, spec.Name, err)
+				continue
+			}
+			this.backOff.Reset(backOffKey)
+			if !ok || mounted.lastSetUp.IsZero() {
+				klog.V(2).InfoS("MountVolume.SetUp succeeded for volume", "pod", klog.KObj(pod), "volumeName", spec.Name,
+					"plugin", plugin.GetPluginName(), "path", mounter.GetPath())
+			}
+			this.actualStateOfWorld.MarkVolumeAsMounted(mountedVolume{
+				podUID:     pod.UID,
+				volumeName: spec.Name,
+				pluginName: plugin.GetPluginName(),
+				path:       mounter.GetPath(),
+				readOnly:   mounter.GetAttributes().ReadOnly,
+				uniqueName: getUniqueVolumeName(mounter),
+				lastSetUp:  now,
+			})
+		}
+	}
+}
+
+func (this *VolumeManager) mountFailed(pod *v1.Pod, volumeName string, err error) {
+	msg := fmt.Sprintf("MountVolume.SetUp failed for volume %q : %v", volumeName, err)
+	klog.ErrorS(err, "MountVolume.SetUp failed for volume", "pod", klog.KObj(pod), "volumeName", volumeName)
+	this.recorder.Event(pod, v1.EventTypeWarning, events.FailedMountVolume, msg)
+}
+
+// unmountVolumes 卸载已经不在期望状态中的卷
+// pkg/kubelet/volumemanager/reconciler/reconciler.go unmountVolumes
+func (this *VolumeManager) unmountVolumes() {
+	for _, mounted := range this.actualStateOfWorld.GetMountedVolumes() {
+		if this.desiredStateOfWorld.VolumeExists(mounted.podUID, mounted.volumeName) {
+			continue
+		}
+		backOffKey := string(mounted.podUID) + "/" + mounted.volumeName
+		if this.backOff.IsInBackOffSinceUpdate(backOffKey, this.clock.Now()) {
+			continue
+		}
+		plugin, err := this.pluginMgr.FindPluginByName(mounted.pluginName)
+		if err != nil {
+			klog.ErrorS(err, "Failed to find plugin for mounted volume", "podUID", mounted.podUID, "volumeName", mounted.volumeName)
+			continue
+		}
+		unmounter, err := plugin.NewUnmounter(mounted.volumeName, mounted.podUID)
+		if err == nil {
+			err = unmounter.TearDown()
+		}
+		if err != nil {
+			this.backOff.Next(backOffKey, this.clock.Now())
+			klog.ErrorS(err, "UnmountVolume.TearDown failed for volume", "podUID", mounted.podUID, "volumeName", mounted.volumeName)
+			continue
+		}
+		klog.V(2).InfoS("UnmountVolume.TearDown succeeded for volume", "podUID", mounted.podUID, "volumeName", mounted.volumeName,
+			"plugin", mounted.pluginName)
+		this.actualStateOfWorld.MarkVolumeAsUnmounted(mounted.podUID, mounted.volumeName)
+		this.backOff.Reset(backOffKey)
+	}
+}
+
+// reconstructVolumes kubelet重启后从pods目录中找回之前挂载的卷
+// 还需要的卷会按spec重新SetUp，不再需要的卷在pod来源同步后卸载
+// pkg/kubelet/volumemanager/reconciler/reconstruct.go
+func (this *VolumeManager) reconstructVolumes() {
+	podDirs, err := os.ReadDir(this.podsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.ErrorS(err, "Cannot read pods directory", "path", this.podsDir)
+		}
+		return
+	}
+	for _, podDir := range podDirs {
+		if !podDir.IsDir() {
+			continue
+		}
+		uid := types.UID(podDir.Name())
+		volumesDir := volume.GetPodVolumesDir(this.podsDir, uid)
+		pluginDirs, err := os.ReadDir(volumesDir)
+		if err != nil {
+			continue
+		}
+		for _, pluginDir := range pluginDirs {
+			pluginName := volume.UnescapePluginName(pluginDir.Name())
+			if _, err = this.pluginMgr.FindPluginByName(pluginName); err != nil {
+				klog.InfoS("Could not find plugin for volume directory, skipping", "path", filepath.Join(volumesDir, pluginDir.Name()))
+				continue
+			}
+			volumeDirs, err := os.ReadDir(filepath.Join(volumesDir, pluginDir.Name()))
+			if err != nil {
+				continue
+			}
+			for _, volumeDir := range volumeDirs {
+				// 卷名不会以.开头，跳过异常的目录
+				if strings.HasPrefix(volumeDir.Name(), ".") {
+					continue
+				}
+				klog.V(4).InfoS("Reconstructed volume from disk", "podUID", uid, "volumeName", volumeDir.Name(), "plugin", pluginName)
+				this.actualStateOfWorld.MarkVolumeAsMounted(mountedVolume{
+					podUID:     uid,
+					volumeName: volumeDir.Name(),
+					pluginName: pluginName,
+					path:       volume.GetPodVolumeDir(this.podsDir, uid, pluginName, volumeDir.Name()),
+				})
+			}
+		}
+	}
+}
+
+func getUniqueVolumeName(mounter volume.Mounter) v1.UniqueVolumeName {
+	if namer, ok := mounter.(volume.UniqueVolumeNamer); ok {
+		return namer.GetUniqueVolumeName()
+	}
+	return ""
+}